@@ -0,0 +1,188 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpecRange bounds the values a peer may set a numeric spec field to when
+// its spec is adopted, inclusively. Non-numeric fields, e.g., verify, only
+// need to be allowed, with neither bound set.
+type SpecRange struct {
+	Min *float64 `json:"min,omitempty"` // Min is the lowest value allowed, unbounded if not set
+	Max *float64 `json:"max,omitempty"` // Max is the highest value allowed, unbounded if not set
+}
+
+// check checks that value, the JSON encoding of a field of the peer spec,
+// nil if unset, is within the range.
+func (r SpecRange) check(name string, value json.RawMessage) error {
+	if r.Min == nil && r.Max == nil {
+		return nil
+	}
+	var number float64 // unset fields are zero
+	if value != nil {
+		if err := json.Unmarshal(value, &number); err != nil {
+			return fmt.Errorf("peer spec sets %s to %s, not a number", name, value)
+		}
+	}
+	if (r.Min != nil && number < *r.Min) || (r.Max != nil && number > *r.Max) {
+		return fmt.Errorf("peer spec sets %s to %v, out of the allowed range %s", name, number, r)
+	}
+	return nil
+}
+
+func (r SpecRange) String() string {
+	bound := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	}
+	return bound(r.Min) + ".." + bound(r.Max)
+}
+
+// ParseSpecAllowlist parses the spec fields a peer may set when its spec is
+// adopted, as comma-separated JSON names of the fields, each optionally
+// bounded as <name>=<min>..<max> with either bound left out if unbounded,
+// e.g., "message_size=64..65536,target_duration=..1m,verify". Bounds are
+// numbers or, for durations, Go durations, which are compared in
+// nanoseconds as encoded in the spec.
+func ParseSpecAllowlist(spec string) (map[string]SpecRange, error) {
+	allowed := make(map[string]SpecRange)
+	for _, field := range strings.Split(spec, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		name, bounds, bounded := strings.Cut(field, "=")
+		if name == "" {
+			return nil, fmt.Errorf("invalid allowed spec field %q, must be <name> or <name>=<min>..<max>", field)
+		}
+		var r SpecRange
+		if bounded {
+			low, high, ok := strings.Cut(bounds, "..")
+			if !ok {
+				return nil, fmt.Errorf("invalid range %q of spec field %s, must be <min>..<max>", bounds, name)
+			}
+			var err error
+			if r.Min, err = parseSpecBound(low); err != nil {
+				return nil, fmt.Errorf("invalid lower bound of spec field %s: %w", name, err)
+			}
+			if r.Max, err = parseSpecBound(high); err != nil {
+				return nil, fmt.Errorf("invalid upper bound of spec field %s: %w", name, err)
+			}
+			if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+				return nil, fmt.Errorf("empty range %q of spec field %s", bounds, name)
+			}
+		}
+		allowed[name] = r
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("no spec fields allowed")
+	}
+	return allowed, nil
+}
+
+// parseSpecBound parses a bound of a SpecRange, nil if empty.
+func parseSpecBound(s string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(v) {
+		return &v, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a number nor a duration", s)
+	}
+	v := float64(d.Nanoseconds())
+	return &v, nil
+}
+
+// AdoptSpec sets the spec of benchmark, i.e., its fields exchanged in the
+// handshake, to the spec of peer, the handshake message of the peer as
+// received with ControlChannel.PeekSpec, so the handshake succeeds without
+// the same spec being given to both sides. The peer must run the same
+// type of benchmark. Local settings which need not match the peer, e.g.,
+// Profile, are kept.
+//
+// If allowed is not nil, only the fields it lists by JSON name may differ
+// from the current spec, within their ranges, and the benchmark is left
+// unchanged otherwise. If it is nil, any field may differ, but the spec
+// must be within DefaultSpecLimits. It returns the names of the fields
+// which differed.
+func AdoptSpec(benchmark Benchmark, peer *ControlMessage, allowed map[string]SpecRange) ([]string, error) {
+	if local := benchmarkType(benchmark); peer.Benchmark != local {
+		return nil, fmt.Errorf("benchmark type mismatch, %s here but %s at the peer, aborting", local, peer.Benchmark)
+	}
+	peerSpec := peer.Spec
+	v := reflect.ValueOf(benchmark)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot adopt the spec of %T, not a pointer to a struct", benchmark)
+	}
+
+	var current, peerFields map[string]json.RawMessage
+	currentSpec, err := json.Marshal(benchmark)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(currentSpec, &current); err != nil {
+		return nil, fmt.Errorf("cannot adopt the spec of %T: %w", benchmark, err)
+	}
+	if err := json.Unmarshal(peerSpec, &peerFields); err != nil {
+		return nil, fmt.Errorf("invalid peer spec: %w", err)
+	}
+
+	var changed []string
+	for name := range current {
+		if _, ok := peerFields[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	for name, value := range peerFields {
+		if !bytes.Equal(value, current[name]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	if allowed != nil {
+		for _, name := range changed {
+			r, ok := allowed[name]
+			if !ok {
+				value := string(peerFields[name])
+				if value == "" {
+					value = "unset"
+				}
+				return nil, fmt.Errorf("peer spec sets %s to %s, which is not allowed", name, value)
+			}
+			if err := r.check(name, peerFields[name]); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := DefaultSpecLimits.Check(peerSpec); err != nil {
+		return nil, err
+	}
+
+	// decode into a zero spec, so fields the peer left unset are reset
+	// rather than kept, then copy over only the fields of the spec
+	adopted := reflect.New(v.Elem().Type())
+	if err := json.Unmarshal(peerSpec, adopted.Interface()); err != nil {
+		return nil, fmt.Errorf("invalid peer spec: %w", err)
+	}
+	t := v.Elem().Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		v.Elem().Field(i).Set(adopted.Elem().Field(i))
+	}
+	return changed, nil
+}
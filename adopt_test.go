@@ -0,0 +1,127 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestParseSpecAllowlist(t *testing.T) {
+	allowed, err := ParseSpecAllowlist("message_size=64..65536, target_duration=..1m,verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := allowed["message_size"]; r.Min == nil || *r.Min != 64 || r.Max == nil || *r.Max != 65536 {
+		t.Errorf("expected message_size within 64..65536, got %v", r)
+	}
+	if r := allowed["target_duration"]; r.Min != nil || r.Max == nil || *r.Max != float64(time.Minute) {
+		t.Errorf("expected target_duration up to a minute in nanoseconds, got %v", r)
+	}
+	if r, ok := allowed["verify"]; !ok || r.Min != nil || r.Max != nil {
+		t.Errorf("expected verify to be allowed unbounded, got %v, %v", r, ok)
+	}
+
+	for _, spec := range []string{"", "=1..2", "message_size=64", "message_size=a..b", "message_size=10..1"} {
+		if _, err := ParseSpecAllowlist(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestAdoptSpec(t *testing.T) {
+	// run has the reader adopt the spec of the writer with allowed
+	run := func(writer, reader *PressuredBenchmark, allowed map[string]SpecRange) (adopted []string, adoptErr, writerErr error) {
+		writerData, readerData := net.Pipe()
+		writerControl, readerControl := net.Pipe()
+		t.Cleanup(func() {
+			writerData.Close()
+			readerData.Close()
+			writerControl.Close()
+			readerControl.Close()
+		})
+		writer.Control = NewControlChannel(writerControl)
+		reader.Control = NewControlChannel(readerControl)
+
+		readerErr := make(chan error, 1)
+		go func() {
+			msg, err := reader.Control.PeekSpec()
+			if err == nil {
+				adopted, err = AdoptSpec(reader, msg, allowed)
+			}
+			if err != nil {
+				adoptErr = err
+				reader.Control.Abort(err.Error())
+				readerErr <- nil
+				return
+			}
+			readerErr <- reader.Reader(readerData)
+		}()
+		writerErr = writer.Writer(writerData)
+		if err := <-readerErr; err != nil {
+			t.Fatalf("reader: %v", err)
+		}
+		return adopted, adoptErr, writerErr
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		writer := &PressuredBenchmark{MessageSize: 512, TotalMessages: 50, Verify: true}
+		reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100, TargetDuration: time.Second, ReadBufferSize: 4096}
+		allowed, err := ParseSpecAllowlist("message_size=64..4096,total_messages,verify,target_duration")
+		if err != nil {
+			t.Fatal(err)
+		}
+		adopted, adoptErr, writerErr := run(writer, reader, allowed)
+		if adoptErr != nil || writerErr != nil {
+			t.Fatalf("adopt: %v, writer: %v", adoptErr, writerErr)
+		}
+		if want := []string{"message_size", "target_duration", "total_messages", "verify"}; !reflect.DeepEqual(adopted, want) {
+			t.Errorf("expected %v to be adopted, got %v", want, adopted)
+		}
+		if reader.MessageSize != 512 || reader.TargetDuration != 0 || !reader.Verify || reader.ReadBufferSize != 4096 {
+			t.Errorf("expected the spec of the writer with the local settings kept, got %+v", reader)
+		}
+		if reads := reader.Result()["successful_reads"]; reads != uint64(50) {
+			t.Errorf("expected 50 successful reads, got %v", reads)
+		}
+	})
+
+	t.Run("OutOfRange", func(t *testing.T) {
+		writer := &PressuredBenchmark{MessageSize: 8192, TotalMessages: 50}
+		reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 50}
+		_, adoptErr, writerErr := run(writer, reader, map[string]SpecRange{"message_size": {Max: new(float64)}})
+		if adoptErr == nil || !strings.Contains(adoptErr.Error(), "out of the allowed range") {
+			t.Errorf("expected the message size to be out of range, got %v", adoptErr)
+		}
+		if writerErr == nil || !strings.Contains(writerErr.Error(), "aborted by peer") {
+			t.Errorf("expected the writer to be aborted by peer, got %v", writerErr)
+		}
+		if reader.MessageSize != 1024 {
+			t.Errorf("expected the spec to be left unchanged, got a message size of %d", reader.MessageSize)
+		}
+	})
+
+	t.Run("NotAllowed", func(t *testing.T) {
+		writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 50, Timestamps: true}
+		reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 50}
+		_, adoptErr, _ := run(writer, reader, map[string]SpecRange{"message_size": {}})
+		if adoptErr == nil || !strings.Contains(adoptErr.Error(), "timestamps") {
+			t.Errorf("expected timestamps not to be allowed, got %v", adoptErr)
+		}
+	})
+
+	t.Run("DefaultLimits", func(t *testing.T) {
+		writer := &PressuredBenchmark{MessageSize: 64 << 20, TotalMessages: 1}
+		reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 50}
+		_, adoptErr, _ := run(writer, reader, nil)
+		if adoptErr == nil || !strings.Contains(adoptErr.Error(), "above the limit") {
+			t.Errorf("expected the message size to be above the default limit, got %v", adoptErr)
+		}
+		if reader.MessageSize != 1024 {
+			t.Errorf("expected the spec to be left unchanged, got a message size of %d", reader.MessageSize)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package benchmarkconn
+
+import (
+	"runtime"
+	"sync"
+)
+
+// allocRecorder records the heap allocations over a run, so that
+// allocation regressions in a conn implementation can be caught along with
+// the throughput. Allocations are process-wide, including goroutines not
+// part of the benchmark.
+type allocRecorder struct {
+	mutex        sync.Mutex
+	startBytes   uint64
+	startObjects uint64
+	bytes        uint64 // bytes allocated over the run
+	objects      uint64 // objects allocated over the run
+	started      bool
+	recording    bool
+}
+
+func (r *allocRecorder) startRecording() {
+	bytes, objects := readAllocs()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.startBytes, r.startObjects = bytes, objects
+	r.bytes, r.objects = 0, 0
+	r.started, r.recording = true, true
+}
+
+func (r *allocRecorder) stopRecording() {
+	bytes, objects := readAllocs()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.recording {
+		return
+	}
+	r.bytes, r.objects = bytes-r.startBytes, objects-r.startObjects
+	r.recording = false
+}
+
+// addResult adds the heap allocations over the run to result, as
+// alloc_bytes and alloc_objects, and per message over the messages read
+// and written as alloc_bytes_per_message and alloc_objects_per_message.
+func (r *allocRecorder) addResult(result map[string]any, messages uint64, profile Profile) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.started || r.recording {
+		return
+	}
+
+	result["alloc_bytes"] = r.bytes
+	result["alloc_objects"] = r.objects
+	if messages == 0 {
+		return
+	}
+	if profile == ProfileConstrained { // integer-only stats
+		result["alloc_bytes_per_message"] = r.bytes / messages
+		result["alloc_objects_per_message"] = r.objects / messages
+	} else {
+		result["alloc_bytes_per_message"] = float64(r.bytes) / float64(messages)
+		result["alloc_objects_per_message"] = float64(r.objects) / float64(messages)
+	}
+}
+
+// readAllocs reads the cumulative heap allocation totals. Unlike
+// runtime/metrics, which only accounts for small allocations once their
+// span is full, runtime.ReadMemStats is exact, at the cost of briefly
+// stopping the world at the start and end of a run.
+func readAllocs() (bytes, objects uint64) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.TotalAlloc, stats.Mallocs
+}
@@ -0,0 +1,141 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// copyingConn copies each message written, as a conn implementation with
+// an allocation regression would.
+type copyingConn struct {
+	net.Conn
+}
+
+func (c *copyingConn) Write(p []byte) (int, error) {
+	return c.Conn.Write(append([]byte(nil), p...))
+}
+
+func TestAllocs(t *testing.T) {
+	const messageSize = 4096
+
+	writer := &PressuredBenchmark{MessageSize: messageSize, TotalMessages: 1000}
+	reader := &PressuredBenchmark{MessageSize: messageSize, TotalMessages: 1000}
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := writer.Writer(&copyingConn{writerConn}); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(readerConn); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	result := writer.Result()
+	if bytes, ok := result["alloc_bytes"].(uint64); !ok || bytes < 1000*messageSize {
+		t.Errorf("expected at least %d bytes allocated, got %v", 1000*messageSize, result["alloc_bytes"])
+	}
+	if perMessage, ok := result["alloc_bytes_per_message"].(float64); !ok || perMessage < messageSize {
+		t.Errorf("expected at least %d bytes allocated per message, got %v", messageSize, result["alloc_bytes_per_message"])
+	}
+	if _, ok := reader.Result()["alloc_objects_per_message"].(float64); !ok {
+		t.Errorf("expected allocated objects per message, got %v", reader.Result()["alloc_objects_per_message"])
+	}
+}
+
+func TestAllocsMessageBuffers(t *testing.T) {
+	const messageSize = 4096
+
+	// allocations are process-wide, so the run must allocate well below a
+	// single buffer per message rather than nothing at all
+	for _, newBenchmark := range []func() Benchmark{
+		func() Benchmark { return &PressuredBenchmark{MessageSize: messageSize, TotalMessages: 1000} },
+		func() Benchmark {
+			return &IntervalBenchmark{MessageSize: messageSize, TotalMessages: 1000, Interval: time.Microsecond}
+		},
+		// the reader checks every message for the end marker
+		func() Benchmark {
+			return &PressuredBenchmark{MessageSize: messageSize, TargetDuration: 100 * time.Millisecond}
+		},
+		func() Benchmark {
+			return &IntervalBenchmark{MessageSize: messageSize, TargetDuration: 100 * time.Millisecond, Interval: time.Microsecond}
+		},
+	} {
+		writer, reader := newBenchmark(), newBenchmark()
+		runOverTCP(t, writer, reader)
+
+		for name, result := range map[string]map[string]any{"writer": writer.Result(), "reader": reader.Result()} {
+			if perMessage, ok := result["alloc_bytes_per_message"].(float64); !ok || perMessage >= messageSize/4 {
+				t.Errorf("expected the %s of %T not to allocate its messages, got %v bytes per message", name, writer, result["alloc_bytes_per_message"])
+			}
+		}
+	}
+}
+
+func TestAllocsParallel(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile Profile
+	}{
+		{"Default", ProfileDefault},
+		{"Constrained", ProfileConstrained},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newBenchmark := func() Benchmark {
+				return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100, Profile: tc.profile}
+			}
+			writer := &ParallelBenchmark{New: newBenchmark, Profile: tc.profile}
+			reader := &ParallelBenchmark{New: newBenchmark, Profile: tc.profile}
+
+			var writerConns, readerConns []net.Conn
+			for i := 0; i < 2; i++ {
+				writerConn, readerConn := net.Pipe()
+				defer writerConn.Close()
+				defer readerConn.Close()
+				writerConns, readerConns = append(writerConns, writerConn), append(readerConns, readerConn)
+			}
+
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if err := writer.Writer(writerConns); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if err := reader.Reader(readerConns); err != nil {
+					t.Error(err)
+				}
+			}()
+			wg.Wait()
+
+			perMessage := writer.Result()["alloc_bytes_per_message"]
+			switch tc.profile {
+			case ProfileConstrained:
+				if _, ok := perMessage.(uint64); !ok {
+					t.Errorf("expected integer allocations per message, got %T", perMessage)
+				}
+			default:
+				if _, ok := perMessage.(float64); !ok {
+					t.Errorf("expected allocations per message, got %T", perMessage)
+				}
+			}
+		})
+	}
+}
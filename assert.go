@@ -0,0 +1,352 @@
+package benchmarkconn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Assertion is a declarative threshold check evaluated against a result,
+// e.g., "latency_p99_ms < 20 && ops_per_s > 1000", so CI gates do not
+// need external scripting.
+//
+// Expressions support numbers, result field names, arithmetic (+ - * /),
+// comparisons (< <= > >= == !=), logical operators (&& || !) and
+// parentheses. Field names are case-insensitive. Any numeric field ending
+// with _ns may also be referenced in _us, _ms or _s, and any field ending
+// with _bps in _kbps, _mbps or _gbps, with the value converted
+// accordingly.
+type Assertion struct {
+	Expr string
+	root assertionNode
+}
+
+// ParseAssertion parses an assertion expression.
+func ParseAssertion(expr string) (*Assertion, error) {
+	p := &assertionParser{tokens: tokenizeAssertion(expr)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid assertion %q: %w", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid assertion %q: unexpected %q", expr, p.tokens[p.pos])
+	}
+	return &Assertion{Expr: expr, root: root}, nil
+}
+
+// Evaluate evaluates the assertion against result. It returns an error if
+// the expression references a field missing from the result or does not
+// evaluate to a boolean.
+func (a *Assertion) Evaluate(result map[string]any) (bool, error) {
+	v, err := a.root.eval(result)
+	if err != nil {
+		return false, err
+	}
+	passed, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("assertion %q does not evaluate to a boolean", a.Expr)
+	}
+	return passed, nil
+}
+
+func (a *Assertion) String() string {
+	return a.Expr
+}
+
+// AssertionResult is the outcome of evaluating an Assertion.
+type AssertionResult struct {
+	Expr   string `json:"expr"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// EvaluateAssertions evaluates all assertions against result. It returns
+// the outcome of each assertion and whether all of them passed.
+func EvaluateAssertions(assertions []*Assertion, result map[string]any) ([]AssertionResult, bool) {
+	allPassed := true
+	outcomes := make([]AssertionResult, 0, len(assertions))
+	for _, assertion := range assertions {
+		passed, err := assertion.Evaluate(result)
+		outcome := AssertionResult{Expr: assertion.Expr, Passed: passed}
+		if err != nil {
+			outcome.Error = err.Error()
+		}
+		allPassed = allPassed && passed
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, allPassed
+}
+
+// lookupMetric finds a numeric field in result by case-insensitive name,
+// converting units where the field is only available in another unit.
+func lookupMetric(result map[string]any, name string) (float64, error) {
+	name = strings.ToLower(name)
+	for key, value := range result {
+		if strings.ToLower(key) == name {
+			if v, ok := toFloat64(value); ok {
+				return v, nil
+			}
+			return 0, fmt.Errorf("field %q is not numeric", key)
+		}
+	}
+
+	conversions := []struct {
+		suffix, base string
+		factor       float64
+	}{
+		{"_us", "_ns", 1e-3},
+		{"_ms", "_ns", 1e-6},
+		{"_s", "_ns", 1e-9},
+		{"_kbps", "_bps", 1e-3},
+		{"_mbps", "_bps", 1e-6},
+		{"_gbps", "_bps", 1e-9},
+	}
+	for _, c := range conversions {
+		if base, ok := strings.CutSuffix(name, c.suffix); ok {
+			if v, err := lookupMetric(result, base+c.base); err == nil {
+				return v * c.factor, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unknown field %q", name)
+}
+
+type assertionNode interface {
+	eval(result map[string]any) (any, error)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(map[string]any) (any, error) { return float64(n), nil }
+
+type fieldNode string
+
+func (n fieldNode) eval(result map[string]any) (any, error) {
+	return lookupMetric(result, string(n))
+}
+
+type unaryNode struct {
+	op      string
+	operand assertionNode
+}
+
+func (n *unaryNode) eval(result map[string]any) (any, error) {
+	v, err := n.operand.eval(result)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of ! must be a boolean")
+		}
+		return !b, nil
+	default: // "-"
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("operand of - must be a number")
+		}
+		return -f, nil
+	}
+}
+
+type binaryNode struct {
+	op          string
+	left, right assertionNode
+}
+
+func (n *binaryNode) eval(result map[string]any) (any, error) {
+	l, err := n.left.eval(result)
+	if err != nil {
+		return nil, err
+	}
+
+	// short-circuit logical operators
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operands of %s must be booleans", n.op)
+		}
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := n.right.eval(result)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operands of %s must be booleans", n.op)
+		}
+		return rb, nil
+	}
+
+	r, err := n.right.eval(result)
+	if err != nil {
+		return nil, err
+	}
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operands of %s must be numbers", n.op)
+	}
+
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		return lf / rf, nil
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	case ">=":
+		return lf >= rf, nil
+	case "==":
+		return lf == rf, nil
+	case "!=":
+		return lf != rf, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %s", n.op)
+	}
+}
+
+func tokenizeAssertion(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsLetter(c) || c == '_' || unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(expr) && (unicode.IsLetter(rune(expr[j])) || unicode.IsDigit(rune(expr[j])) || expr[j] == '_' || expr[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		default:
+			if i+1 < len(expr) {
+				switch two := expr[i : i+2]; two {
+				case "&&", "||", "<=", ">=", "==", "!=":
+					tokens = append(tokens, two)
+					i += 2
+					continue
+				}
+			}
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+type assertionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *assertionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *assertionParser) parseBinary(next func() (assertionNode, error), ops ...string) (assertionNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		matched := false
+		for _, candidate := range ops {
+			if op == candidate {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *assertionParser) parseOr() (assertionNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *assertionParser) parseAnd() (assertionNode, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *assertionParser) parseComparison() (assertionNode, error) {
+	return p.parseBinary(p.parseSum, "<", "<=", ">", ">=", "==", "!=")
+}
+
+func (p *assertionParser) parseSum() (assertionNode, error) {
+	return p.parseBinary(p.parseTerm, "+", "-")
+}
+
+func (p *assertionParser) parseTerm() (assertionNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/")
+}
+
+func (p *assertionParser) parseUnary() (assertionNode, error) {
+	if op := p.peek(); op == "!" || op == "-" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *assertionParser) parsePrimary() (assertionNode, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		v, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		p.pos++
+		return numberNode(v), nil
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		p.pos++
+		return fieldNode(token), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+}
@@ -0,0 +1,76 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestAssertion(t *testing.T) {
+	result := map[string]any{
+		"ops_per_s":       float64(1200),
+		"latency_p99_ns":  int64(15_000_000),
+		"throughput_bps":  uint64(900_000_000),
+		"failed_writes":   uint64(0),
+		"duration":        "1s",
+		"verify_missing":  uint64(2),
+		"verify_valid":    uint64(998),
+		"Mixed_Case_Key":  3,
+		"latency_p50_ns":  int64(1_000_000),
+		"successful_read": 1000,
+	}
+
+	for expr, want := range map[string]bool{
+		"latency_p99_ms < 20":                                       true,
+		"latency_p99_ms < 20 && throughput_mbps > 800":              true,
+		"latency_p99_ms < 10 || throughput_Mbps > 800":              true,
+		"latency_p99_ms < 10 || throughput_gbps > 1":                false,
+		"!(failed_writes > 0)":                                      true,
+		"verify_missing / (verify_valid + verify_missing) <= 0.001": false,
+		"latency_p99_us == 15000":                                   true,
+		"mixed_case_key >= 3":                                       true,
+		"-ops_per_s < -1000":                                        true,
+		"latency_p50_s * 1000 != 1":                                 false,
+	} {
+		assertion, err := ParseAssertion(expr)
+		if err != nil {
+			t.Errorf("%q: %v", expr, err)
+			continue
+		}
+		got, err := assertion.Evaluate(result)
+		if err != nil {
+			t.Errorf("%q: %v", expr, err)
+		} else if got != want {
+			t.Errorf("%q: got %v, want %v", expr, got, want)
+		}
+	}
+
+	for _, expr := range []string{
+		"unknown_field > 1",
+		"duration > 1",
+		"ops_per_s + 1",
+		"ops_per_s && 1",
+	} {
+		assertion, err := ParseAssertion(expr)
+		if err != nil {
+			t.Errorf("%q: %v", expr, err)
+			continue
+		}
+		if _, err := assertion.Evaluate(result); err == nil {
+			t.Errorf("%q: expected evaluation error", expr)
+		}
+	}
+
+	for _, expr := range []string{"", "ops_per_s >", "(ops_per_s > 1", "ops_per_s > 1)", "ops_per_s $ 1"} {
+		if _, err := ParseAssertion(expr); err == nil {
+			t.Errorf("%q: expected parse error", expr)
+		}
+	}
+
+	pass, _ := ParseAssertion("ops_per_s > 1000")
+	fail, _ := ParseAssertion("ops_per_s > 2000")
+	outcomes, passed := EvaluateAssertions([]*Assertion{pass, fail}, result)
+	if passed || len(outcomes) != 2 || !outcomes[0].Passed || outcomes[1].Passed {
+		t.Errorf("EvaluateAssertions: got %+v, %v", outcomes, passed)
+	}
+}
@@ -1,19 +1,19 @@
 package benchmarkconn
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"io"
 	"net"
 	"os"
-	"sync"
 	"sync/atomic"
 	"time"
 
 	crand "crypto/rand"
 )
 
+// Benchmark runs one side of a benchmark over a net.Conn, the writer or the
+// reader, and reports its result. See ReadWriteCloserConn to run it over an
+// io.ReadWriteCloser.
 type Benchmark interface {
 	Writer(net.Conn, ...Counter) error
 	Reader(net.Conn, ...Counter) error
@@ -21,57 +21,163 @@ type Benchmark interface {
 }
 
 // PressuredBenchmark is a benchmark that sends a fixed number of messages of a fixed size
-// one after another as fast as possible and measures the throughput and latency.
+// one after another as fast as possible, or paced to TargetBandwidth, and measures the
+// throughput and latency.
+//
+// Over a datagram connection, e.g., a connected *net.UDPConn, each message is sent as a
+// single datagram stamped with a sequence number, and the reader additionally reports the
+// messages lost, duplicated and reordered as delivery_* along with the reorder distance.
+// Should all end markers be lost, the reader stops once no datagram arrived for a second.
 type PressuredBenchmark struct {
 	MessageSize   int    `json:"message_size" yaml:"message_size"`     // MessageSize defines how many bytes to write for each send attempt
 	TotalMessages uint64 `json:"total_messages" yaml:"total_messages"` // TotalMessages defines how many messages to send in total
 
-	messageSize      int // an internal copy of the message size used in the last run
+	WarmupMessages uint64        `json:"warmup_messages,omitempty" yaml:"warmup_messages"` // WarmupMessages defines how many messages to send before the measurement starts
+	WarmupDuration time.Duration `json:"warmup_duration,omitempty" yaml:"warmup_duration"` // WarmupDuration defines how long to send messages before the measurement starts, overriding WarmupMessages if set
+	Verify         bool          `json:"verify,omitempty" yaml:"verify"`                   // Verify defines whether each message carries a sequence number and checksum validated by the reader
+	TargetDuration time.Duration `json:"target_duration,omitempty" yaml:"target_duration"` // TargetDuration defines how long to send messages, overriding TotalMessages if set. The reader reads until the writer signals the end of the run
+
+	SizeDistribution *SizeDistribution `json:"size_distribution,omitempty" yaml:"size_distribution"` // SizeDistribution draws the size of each message, overriding MessageSize if set. Each message is then preceded by a 4-byte length header
+	TargetBandwidth  uint64            `json:"target_bandwidth,omitempty" yaml:"target_bandwidth"`   // TargetBandwidth defines the offered load in bytes per second, paced with a token bucket, as fast as possible if not set
+	SoftStart        *SoftStart        `json:"soft_start,omitempty" yaml:"soft_start"`               // SoftStart delays the measurement until the throughput stabilizes, after the warmup if any
+	Timestamps       bool              `json:"timestamps,omitempty" yaml:"timestamps"`               // Timestamps defines whether each message carries its send time for the reader to measure the one-way latency, which requires the clocks of both hosts to be synchronized
+	Completion       *Completion       `json:"completion,omitempty" yaml:"completion"`               // Completion ends the run on the first of its conditions, overriding TotalMessages and TargetDuration if set
+
+	Payload            PayloadGenerator    `json:"-" yaml:"-"`                   // Payload generates the content of each message, random if nil
+	Profile            Profile             `json:"-" yaml:"profile"`             // Profile selects the local resource footprint, it does not need to match the peer
+	Histogram          *HistogramSettings  `json:"-" yaml:"histogram"`           // Histogram sets the bounds and resolution of the latency histograms, depending on Profile if not set. It does not need to match the peer
+	HistogramLog       *HistogramLogWriter `json:"-" yaml:"-"`                   // HistogramLog receives the one-way latency histogram of each counter tick, or of the whole run without counters, tagged one_way_latency, if set with Timestamps
+	Reducers           []Reducer           `json:"-" yaml:"-"`                   // Reducers receive the one-way latency of each message in nanoseconds, if set with Timestamps, and add their statistics to the result as one_way_latency_<name>_ns
+	Clock              ClockSource         `json:"-" yaml:"-"`                   // Clock stamps and reads the send times with Timestamps, RealtimeClock if not set. Across hosts, both sides must use clocks synchronized with each other, e.g., PTP hardware clocks
+	ThroughputInterval time.Duration       `json:"-" yaml:"throughput_interval"` // ThroughputInterval defines how often the throughput is sampled into throughput_intervals, not sampled if not set. It does not need to match the peer
+	MaxMessageErrors   uint64              `json:"-" yaml:"max_message_errors"`  // MaxMessageErrors defines how many messages may fail verification before the reader fails the run, unlimited if not set
+	ReadBufferSize     int                 `json:"-" yaml:"read_buffer_size"`    // ReadBufferSize defines how many bytes the reader reads from the connection at once, reassembling the messages from the reads, one message per read if not set. It does not need to match the peer
+	Control            *ControlChannel     `json:"-" yaml:"-"`                   // Control carries the handshake instead of the data connection if set, it must be set on both sides
+
+	OnProgress       func(ProgressSnapshot) `json:"-" yaml:"-"` // OnProgress is called periodically while the benchmark runs if set, and once more when it stops
+	ProgressInterval time.Duration          `json:"-" yaml:"-"` // ProgressInterval defines how often OnProgress is called, DefaultProgressInterval if not set
+
+	OnStart    func()               `json:"-" yaml:"-"` // OnStart is called once the measurement starts, after the handshake and the warmup, if set
+	OnMessage  func(MessageEvent)   `json:"-" yaml:"-"` // OnMessage is called for each message written or read during the measurement if set. It is called from the loop sending or receiving the messages, so it must return quickly
+	OnComplete func(map[string]any) `json:"-" yaml:"-"` // OnComplete is called with the result once the benchmark ended without error if set
+	OnError    func(error)          `json:"-" yaml:"-"` // OnError is called with the error the benchmark failed with if set
+
+	messageSize      int             // an internal copy of the message size used in the last run, the mean frame size with SizeDistribution
+	framing          *messageFraming // the framing of the messages in the last run
+	socketOptions    map[string]any  // the effective socket options at the start of the last run
 	successfulReads  atomic.Uint64
 	successfulWrites atomic.Uint64
+	bytesRead        atomic.Uint64
+	bytesWritten     atomic.Uint64
 	startTime        atomic.Value
 	endTime          atomic.Value
 
-	combinedCounter *CombinedCounter
+	expectedMessages uint64               // used for receiver, TotalMessages or the number of messages sent with TargetDuration
+	termination      string               // why the last run ended, one of the Termination* reasons
+	softStart        *softStartResult     // the outcome of the soft start of the last run, if any
+	verifier         messageVerifier      // used for receiver to validate messages, and for sender to digest them, if Verify is set
+	messageErrors    messageErrorRecorder // used for receiver to count the messages failing verification
+	oneWayLatency    oneWayLatencyRecorder
+	latencyTimeline  *latencyTimeline // used for receiver to summarize the one-way latency of each counter tick
+	reducers         reducerRecorder  // used for receiver to feed the one-way latency to Reducers
+	delivery         *deliveryTracker // used for receiver over datagram connections
+	chunks           *chunkedReader   // used for receiver with ReadBufferSize
+	schedLatency     schedLatencyRecorder
+	decorators       decoratorRecorder
+	versions         versionRecorder
+	allocs           allocRecorder
+	coalescing       coalescingRecorder
+	combinedCounter  *CombinedCounter
+	throughput       *throughputTimeline // used to sample the throughput with ThroughputInterval
 }
 
-func (b *PressuredBenchmark) Writer(conn net.Conn, counters ...Counter) error {
-	// Compare benchmark specs on both sides
-	specJson, err := json.Marshal(b)
-	if err != nil {
+func (b *PressuredBenchmark) Writer(conn net.Conn, counters ...Counter) (err error) {
+	defer func() {
+		completeRun(err, b.OnComplete, b.OnError, b.Result)
+	}()
+
+	if err := validateSizeDistribution(b.SizeDistribution, b.Verify); err != nil {
 		return err
 	}
-
-	specLenWr, err := conn.Write(specJson)
-	if err != nil {
+	if err := b.Histogram.validate(b.Profile); err != nil {
 		return err
 	}
-
-	if specLenWr != len(specJson) {
-		return errors.New("failed to write the spec to the connection")
+	b.framing = newMessageFraming(b.MessageSize, b.SizeDistribution)
+	if err := validateWarmup(b.framing.bufferSize(), b.WarmupDuration); err != nil {
+		return err
+	}
+	if err := validateVerification(b.framing.bufferSize(), b.Verify); err != nil {
+		return err
+	}
+	stampHeader := sendTimeHeader(false, b.Verify)
+	if b.Timestamps {
+		if err := validateSendTime(b.MessageSize, b.SizeDistribution, stampHeader); err != nil {
+			return err
+		}
+	}
+	if err := validateTargetDuration(b.framing.bufferSize(), b.TargetDuration); err != nil {
+		return err
+	}
+	if b.Completion != nil {
+		if err := b.Completion.validate(b.framing.bufferSize(), b.Verify); err != nil {
+			return err
+		}
+	}
+	datagram := isDatagramConn(conn)
+	if datagram {
+		if err := validateDatagram(b.MessageSize, b.SizeDistribution); err != nil {
+			return err
+		}
+	}
+	if err := validateSoftStart(b.SoftStart, b.framing.bufferSize(), datagram); err != nil {
+		return err
 	}
 
-	receivedSpecJson := make([]byte, 2*len(specJson))
-	specLenRd, err := conn.Read(receivedSpecJson)
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshakeVia(conn, b.Control, b)
 	if err != nil {
 		return err
 	}
+	b.versions.record(peer)
 
-	if specLenRd != len(specJson) {
-		return errors.New("failed to read the spec from the connection")
-	}
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
 
-	if !bytes.Equal(specJson, receivedSpecJson[:specLenRd]) {
-		return errors.New("benchmark specs do not match, aborting")
+	// Warm up the connection, excluded from counters and timing
+	if err := sendWarmup(conn, b.framing.bufferSize(), b.WarmupMessages, b.WarmupDuration, 0, false); err != nil {
+		return err
+	}
+	b.softStart = nil
+	if b.SoftStart != nil {
+		if b.softStart, err = sendSoftStart(conn, b.framing.bufferSize(), b.SoftStart); err != nil {
+			return err
+		}
 	}
 
 	// Create combined counter
 	b.combinedCounter = CombineCounters(time.Second, counters...)
 
 	// Benchmark starts
-	b.messageSize = b.MessageSize
+	b.messageSize = b.meanMessageSize()
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
 	b.successfulReads.Store(0)
 	b.successfulWrites.Store(0)
+	b.bytesRead.Store(0)
+	b.bytesWritten.Store(0)
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.coalescing.startRecording(conn)
+	defer b.coalescing.stopRecording()
+	b.endTime.Store(time.Time{}) // running, see Snapshot
 	b.startTime.Store(time.Now())
 	defer func() {
 		b.endTime.Store(time.Now())
@@ -82,61 +188,180 @@ func (b *PressuredBenchmark) Writer(conn net.Conn, counters ...Counter) error {
 		b.combinedCounter.Start()
 		defer b.combinedCounter.Stop()
 	}
+	b.throughput = startThroughputTimeline(b.ThroughputInterval, b.startTime.Load().(time.Time), &b.bytesRead, &b.bytesWritten, b.Profile)
+	defer b.throughput.stop()
+	if b.OnStart != nil {
+		b.OnStart()
+	}
+
+	// Report the progress
+	defer startProgress(b.Control.reportProgress(b.OnProgress), b.ProgressInterval, b.messageSize, b.progressTotal(), b.startTime.Load().(time.Time), &b.successfulReads, &b.successfulWrites, nil, nil)()
 
-	var randMsg = make([]byte, b.messageSize)
+	pooledBuf := messageBuffers.get(b.framing.bufferSize())
+	defer messageBuffers.put(pooledBuf)
+	var randBuf = *pooledBuf
+	var reuseMsg = b.Profile == ProfileConstrained && b.Payload == nil
+	if reuseMsg {
+		crand.Read(randBuf) // fill once and reuse
+	}
+	var pacer *tokenBucket
+	if b.TargetBandwidth > 0 {
+		pacer = newTokenBucket(b.TargetBandwidth, b.framing.bufferSize())
+	}
+	var startTime = b.startTime.Load().(time.Time)
+	var now = clockOrDefault(b.Clock, RealtimeClock).Now
+	b.verifier.reset()
 	var i uint64
-	for i = 0; i < b.TotalMessages; i++ {
-		crand.Read(randMsg)
+	for i = 0; ; i++ {
+		if b.termination = b.writerTermination(i, b.bytesWritten.Load(), startTime); b.termination != "" {
+			break
+		}
+		randMsg := b.framing.next(randBuf)
+		if !reuseMsg {
+			payloadGenerator(b.Payload).Fill(b.framing.payload(randMsg))
+		}
+		if pacer != nil {
+			pacer.wait(len(randMsg))
+		}
+		if datagram {
+			stampSequence(randMsg, i) // for the reader to track the delivery of each message
+		}
+		if b.Timestamps {
+			stampSendTime(b.framing.payload(randMsg), stampHeader, now())
+		}
+		if b.Verify {
+			stampVerification(randMsg, i)
+			b.verifier.written(randMsg)
+		}
 		_, err := conn.Write(randMsg)
 		if err != nil {
 			return err
 		}
 		b.successfulWrites.Add(1)
+		b.bytesWritten.Add(uint64(len(randMsg)))
+		if b.OnMessage != nil {
+			b.OnMessage(MessageEvent{Index: i, Size: len(randMsg), Written: true})
+		}
+	}
+
+	// over datagram connections, the reader cannot count on receiving
+	// every message, so the run always ends with end markers
+	if b.untilEndMarker() && !datagram {
+		if _, err := conn.Write(b.framing.endMarker(i)); err != nil {
+			return err
+		}
+	}
+	for j := 0; datagram && j < datagramEndMarkers; j++ {
+		if _, err := conn.Write(b.framing.endMarker(i)); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (b *PressuredBenchmark) Reader(conn net.Conn, counters ...Counter) error {
-	// Compare benchmark specs on both sides
-	specJson, err := json.Marshal(b)
-	if err != nil {
+func (b *PressuredBenchmark) Reader(conn net.Conn, counters ...Counter) (err error) {
+	defer func() {
+		completeRun(err, b.OnComplete, b.OnError, b.Result)
+	}()
+
+	if err := validateSizeDistribution(b.SizeDistribution, b.Verify); err != nil {
 		return err
 	}
-
-	receivedSpecJson := make([]byte, 2*len(specJson))
-	specLenRd, err := conn.Read(receivedSpecJson)
-	if err != nil {
+	if err := b.Histogram.validate(b.Profile); err != nil {
 		return err
 	}
-
-	if specLenRd != len(specJson) {
-		return errors.New("failed to read the spec from the connection")
+	b.framing = newMessageFraming(b.MessageSize, b.SizeDistribution)
+	if err := validateVerification(b.framing.bufferSize(), b.Verify); err != nil {
+		return err
 	}
-
-	if !bytes.Equal(specJson, receivedSpecJson[:specLenRd]) {
-		return errors.New("benchmark specs do not match, aborting")
+	stampHeader := sendTimeHeader(false, b.Verify)
+	if b.Timestamps {
+		if err := validateSendTime(b.MessageSize, b.SizeDistribution, stampHeader); err != nil {
+			return err
+		}
+	}
+	if err := validateTargetDuration(b.framing.bufferSize(), b.TargetDuration); err != nil {
+		return err
+	}
+	if b.Completion != nil {
+		if err := b.Completion.validate(b.framing.bufferSize(), b.Verify); err != nil {
+			return err
+		}
+	}
+	datagram := isDatagramConn(conn)
+	if datagram {
+		if err := validateDatagram(b.MessageSize, b.SizeDistribution); err != nil {
+			return err
+		}
+	}
+	if err := validateSoftStart(b.SoftStart, b.framing.bufferSize(), datagram); err != nil {
+		return err
+	}
+	if err := validateReadBufferSize(b.ReadBufferSize, datagram); err != nil {
+		return err
 	}
 
-	specLenWr, err := conn.Write(specJson)
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshakeVia(conn, b.Control, b)
 	if err != nil {
 		return err
 	}
+	b.versions.record(peer)
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
 
-	if specLenWr != len(specJson) {
-		return errors.New("failed to write the spec to the connection")
+	// Warm up the connection, excluded from counters and timing
+	if err := receiveWarmup(conn, b.framing.bufferSize(), b.WarmupMessages, b.WarmupDuration, false); err != nil {
+		return err
+	}
+	b.softStart = nil
+	if b.SoftStart != nil {
+		if b.softStart, err = receiveSoftStart(conn, b.framing.bufferSize()); err != nil {
+			return err
+		}
 	}
 
 	// Create combined counter
 	b.combinedCounter = CombineCounters(time.Second, counters...)
 
 	// Benchmark starts
-	b.messageSize = b.MessageSize
+	b.messageSize = b.meanMessageSize()
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
 	b.successfulReads.Store(0)
 	b.successfulWrites.Store(0)
+	b.bytesRead.Store(0)
+	b.bytesWritten.Store(0)
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.coalescing.startRecording(conn)
+	defer b.coalescing.stopRecording()
+	b.endTime.Store(time.Time{}) // running, see Snapshot
 	b.startTime.Store(time.Now())
+	var exitedDueToDeadline bool
+	var stoppedAt time.Time // when the error budget of Completion ran out, the rest of the run is discarded
+	var closedAt time.Time  // when the peer closed the data connection, before waiting for its abort
 	defer func() {
-		b.endTime.Store(time.Now())
+		switch {
+		case !stoppedAt.IsZero():
+			b.endTime.Store(stoppedAt)
+		case !closedAt.IsZero():
+			b.endTime.Store(closedAt)
+		case exitedDueToDeadline:
+			b.endTime.Store(time.Now().Add(-datagramIdleTimeout)) // the run ended when the last datagram arrived
+		default:
+			b.endTime.Store(time.Now())
+		}
 	}()
 
 	// Start the counter
@@ -144,25 +369,160 @@ func (b *PressuredBenchmark) Reader(conn net.Conn, counters ...Counter) error {
 		b.combinedCounter.Start()
 		defer b.combinedCounter.Stop()
 	}
+	b.throughput = startThroughputTimeline(b.ThroughputInterval, b.startTime.Load().(time.Time), &b.bytesRead, &b.bytesWritten, b.Profile)
+	defer b.throughput.stop()
+	if b.OnStart != nil {
+		b.OnStart()
+	}
 
-	var receivedMsg = make([]byte, b.messageSize)
-	for b.successfulReads.Load() < b.TotalMessages {
+	// Report the progress
+	b.messageErrors.reset(b.startTime.Load().(time.Time), b.MaxMessageErrors)
+	defer startProgress(b.Control.reportProgress(b.OnProgress), b.ProgressInterval, b.messageSize, b.progressTotal(), b.startTime.Load().(time.Time), &b.successfulReads, &b.successfulWrites, nil, &b.messageErrors)()
+
+	b.verifier.reset()
+	b.oneWayLatency.reset(b.Timestamps, b.Histogram, b.Profile)
+	b.reducers.reset(b.Reducers)
+	b.latencyTimeline = nil
+	if b.Timestamps {
+		b.latencyTimeline = startLatencyTimeline(b.combinedCounter.tickInterval(), b.startTime.Load().(time.Time), "one_way_latency_", b.Histogram, b.Profile, b.HistogramLog)
+		defer b.latencyTimeline.stop()
+	}
+	b.expectedMessages = b.TotalMessages
+	if b.Completion != nil {
+		b.expectedMessages = 0 // unknown until the end marker arrives
+	}
+	b.termination = ""
+	b.delivery = nil
+	if datagram {
+		b.delivery = newDeliveryTracker(b.Profile)
+		if b.TargetDuration > 0 {
+			b.expectedMessages = 0 // unknown unless an end marker arrives
+		}
+	}
+	var src io.Reader = conn
+	b.chunks = nil
+	if b.ReadBufferSize > 0 {
+		b.chunks = newChunkedReader(conn, b.ReadBufferSize, b.Profile)
+		src = b.chunks
+	}
+	pooledBuf := messageBuffers.get(b.framing.bufferSize())
+	defer messageBuffers.put(pooledBuf)
+	var receivedBuf = *pooledBuf
+	var now = clockOrDefault(b.Clock, RealtimeClock).Now
+	for b.untilEndMarker() || datagram || b.successfulReads.Load() < b.TotalMessages {
+		// over datagram connections, the end markers may all be lost
+		if datagram {
+			setReadDeadline(conn, time.Now().Add(datagramIdleTimeout))
+		}
 		// _, err := conn.Read(receivedMsg) // risk reading partial messages
-		_, err := io.ReadFull(conn, receivedMsg) // read full length of the message
+		receivedMsg, err := b.framing.read(src, receivedBuf) // read full length of the message
 		if err != nil {
+			if datagram && errors.Is(err, os.ErrDeadlineExceeded) {
+				exitedDueToDeadline = true
+				break
+			}
 			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
-				return nil
+				closedAt = time.Now()
+				return b.Control.closedErr()
 			}
 			return err
 		}
+		if b.untilEndMarker() || datagram {
+			if sent, ok := b.framing.parseEndMarker(receivedMsg); ok {
+				if stoppedAt.IsZero() {
+					b.expectedMessages = sent
+					b.termination = b.readerTermination(sent, b.sentBytes(sent, datagram))
+				}
+				break
+			}
+		}
+		if !stoppedAt.IsZero() {
+			continue // discard the rest of the run
+		}
 		b.successfulReads.Add(1)
+		b.bytesRead.Add(uint64(len(receivedMsg)))
+		if b.chunks != nil {
+			b.chunks.messageDone()
+		}
+
+		if datagram {
+			b.delivery.record(receivedMsg)
+		}
+
+		var latency int64
+		if b.Timestamps {
+			if sendTime, ok := readSendTime(b.framing.payload(receivedMsg), stampHeader); ok {
+				latency = now() - sendTime
+				b.oneWayLatency.record(latency)
+				if latency >= 0 {
+					b.latencyTimeline.record(latency)
+					b.reducers.record(latency)
+				}
+			}
+		}
+		if b.OnMessage != nil {
+			b.OnMessage(MessageEvent{Index: b.successfulReads.Load() - 1, Size: len(receivedMsg), Latency: time.Duration(max(latency, 0))})
+		}
+
+		if b.Verify {
+			if kind := b.verifier.check(receivedMsg); kind != "" {
+				if err := b.messageErrors.record(kind); err != nil {
+					return err
+				}
+				if b.Completion != nil && b.Completion.Errors > 0 && b.messageErrors.count() >= b.Completion.Errors {
+					stoppedAt = time.Now()
+					b.termination = TerminationErrors
+					b.expectedMessages = b.successfulReads.Load()
+				}
+			}
+		}
 	}
 
+	if b.termination == "" {
+		if exitedDueToDeadline {
+			b.termination = TerminationIdle
+		} else {
+			b.termination = TerminationMessages
+		}
+	}
+	if datagram {
+		setReadDeadline(conn, time.Time{})
+	}
 	return nil
 }
 
+// meanMessageSize returns the size of the messages, including the length
+// header with a size distribution, on average.
+func (b *PressuredBenchmark) meanMessageSize() int {
+	if b.SizeDistribution == nil {
+		return b.MessageSize
+	}
+	return frameHeaderSize + int(b.SizeDistribution.Mean())
+}
+
+// progressTotal returns the number of messages expected, 0 if unknown.
+func (b *PressuredBenchmark) progressTotal() uint64 {
+	if b.Completion != nil {
+		if b.Completion.Bytes > 0 || b.Completion.Duration > 0 {
+			return 0
+		}
+		return b.Completion.Messages
+	}
+	if b.TargetDuration > 0 {
+		return 0
+	}
+	return b.TotalMessages
+}
+
+// Snapshot returns a view of the run in progress, or of the last run once
+// it ended. Unlike Result, it is safe to call while the benchmark runs,
+// e.g., from a monitoring goroutine.
+func (b *PressuredBenchmark) Snapshot() RunSnapshot {
+	return takeSnapshot(&b.startTime, &b.endTime, &b.successfulReads, &b.successfulWrites, &b.bytesRead, &b.bytesWritten)
+}
+
 func (b *PressuredBenchmark) Result() map[string]any {
-	if b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
 		return map[string]any{}
 	}
 
@@ -173,13 +533,67 @@ func (b *PressuredBenchmark) Result() map[string]any {
 		"end_time":          b.endTime.Load().(time.Time).Format(time.RFC3339),
 		"duration":          b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).String(),
 	}
+	if b.termination != "" {
+		result["termination_reason"] = b.termination
+	}
+	addThroughput(result, b.bytesRead.Load(), b.bytesWritten.Load(),
+		b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds(), b.Profile)
+	b.throughput.addResult(result)
 
 	// Reader only: calculate ops_per_sec and latency_ms
 	if b.successfulReads.Load() > 0 {
-		result["ops_per_s"] = float64(b.successfulReads.Load()+b.successfulWrites.Load()) / float64(b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds()) * 1e9
-		result["latency_ns"] = float64(b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds()) / float64(b.successfulReads.Load()+b.successfulWrites.Load()) // in nanoseconds
+		ops := b.successfulReads.Load() + b.successfulWrites.Load()
+		durationNs := b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds()
+		if b.Profile == ProfileConstrained { // integer-only stats
+			result["ops_per_s"] = ops * 1e9 / uint64(durationNs)
+			result["latency_ns"] = uint64(durationNs) / ops // in nanoseconds
+		} else {
+			result["ops_per_s"] = float64(ops) / float64(durationNs) * 1e9
+			result["latency_ns"] = float64(durationNs) / float64(ops) // in nanoseconds
+		}
 	}
 
+	b.softStart.addResult(result, b.Profile)
+
+	// Reader only: one-way latency
+	b.oneWayLatency.addResult(result, b.Profile)
+	if b.Clock != nil && b.Timestamps && b.successfulReads.Load() > 0 {
+		result["one_way_latency_clock"] = b.Clock.String()
+	}
+	b.latencyTimeline.addResult(result)
+	b.reducers.addResult(result, "one_way_latency")
+
+	// Reader only: verification, writer only: the digest of the messages
+	// written
+	if b.Verify && b.successfulReads.Load() > 0 {
+		b.verifier.addResult(result, b.expectedMessages)
+		b.messageErrors.addResult(result)
+	} else if b.Verify && b.successfulWrites.Load() > 0 {
+		b.verifier.addDigestResult(result)
+	}
+
+	// Reader only: delivery over datagram connections
+	if b.delivery != nil {
+		b.delivery.addResult(result, b.expectedMessages, b.Profile)
+	}
+
+	// Reader only: chunking with ReadBufferSize
+	if b.chunks != nil {
+		b.chunks.addResult(result, b.Profile)
+	}
+
+	b.schedLatency.addResult(result)
+	b.allocs.addResult(result, b.successfulReads.Load()+b.successfulWrites.Load(), b.Profile)
+	b.coalescing.addResult(result, b.bytesRead.Load(), b.bytesWritten.Load(), b.successfulReads.Load(), b.successfulWrites.Load(), b.Profile)
+
+	if b.socketOptions != nil {
+		result["socket_options"] = b.socketOptions
+	}
+	b.decorators.addResult(result, b.Profile)
+	b.versions.addResult(result)
+
+	b.Control.addAbortResult(result)
+
 	if b.combinedCounter != nil {
 		result["counters"] = b.combinedCounter.Results()
 	}
@@ -191,55 +605,140 @@ func (b *PressuredBenchmark) Result() map[string]any {
 // one after another with a fixed interval between each send attempt and measures the
 // throughput and latency.
 type IntervalBenchmark struct {
-	MessageSize   int           `json:"message_size" yaml:"message_size"`     // MessageSize defines how many bytes to write for each send attempt
-	TotalMessages uint64        `json:"total_messages" yaml:"total_messages"` // TotalMessages defines how many messages to send in total
-	Interval      time.Duration `json:"interval" yaml:"interval"`             // Interval defines how long to wait between each send attempt. If this value is too low, it is possible that the actual interval will be much higher due to system limitations
-	Echo          bool          `json:"echo" yaml:"echo"`                     // Echo defines whether the receiver should echo back the received message
-
-	messageSize      int // an internal copy of the message size used in the last run
+	MessageSize   int           `json:"message_size" yaml:"message_size"`       // MessageSize defines how many bytes to write for each send attempt
+	TotalMessages uint64        `json:"total_messages" yaml:"total_messages"`   // TotalMessages defines how many messages to send in total
+	Interval      time.Duration `json:"interval" yaml:"interval"`               // Interval defines how long to wait between each send attempt. If this value is too low, it is possible that the actual interval will be much higher due to system limitations
+	Echo          bool          `json:"echo" yaml:"echo"`                       // Echo defines whether the receiver should echo back the received message
+	BurstSize     uint64        `json:"burst_size,omitempty" yaml:"burst_size"` // BurstSize defines how many messages to send back-to-back on each tick, 1 if not set, to simulate bursty traffic such as video keyframes or batched RPCs
+
+	WarmupMessages uint64        `json:"warmup_messages,omitempty" yaml:"warmup_messages"` // WarmupMessages defines how many messages to send before the measurement starts
+	WarmupDuration time.Duration `json:"warmup_duration,omitempty" yaml:"warmup_duration"` // WarmupDuration defines how long to send messages before the measurement starts, overriding WarmupMessages if set
+	Verify         bool          `json:"verify,omitempty" yaml:"verify"`                   // Verify defines whether each message carries a sequence number and checksum validated by the reader
+	TargetDuration time.Duration `json:"target_duration,omitempty" yaml:"target_duration"` // TargetDuration defines how long to send messages, overriding TotalMessages if set. The reader reads until the writer signals the end of the run
+
+	SizeDistribution *SizeDistribution `json:"size_distribution,omitempty" yaml:"size_distribution"` // SizeDistribution draws the size of each message, overriding MessageSize if set. Each message is then preceded by a 4-byte length header
+	Completion       *Completion       `json:"completion,omitempty" yaml:"completion"`               // Completion ends the run on the first of its conditions, overriding TotalMessages and TargetDuration if set
+
+	Payload            PayloadGenerator    `json:"-" yaml:"-"`                   // Payload generates the content of each message, random if nil
+	Profile            Profile             `json:"-" yaml:"profile"`             // Profile selects the local resource footprint, it does not need to match the peer
+	Histogram          *HistogramSettings  `json:"-" yaml:"histogram"`           // Histogram sets the bounds and resolution of the latency histograms, depending on Profile if not set. It does not need to match the peer
+	HistogramLog       *HistogramLogWriter `json:"-" yaml:"-"`                   // HistogramLog receives the latency histogram of each counter tick, or of the whole run without counters, tagged latency, if set with Echo
+	Reducers           []Reducer           `json:"-" yaml:"-"`                   // Reducers receive the latency of each echo in nanoseconds, if set with Echo, and add their statistics to the result as latency_<name>_ns
+	Clock              ClockSource         `json:"-" yaml:"-"`                   // Clock measures the latency of the echoes, MonotonicClock if not set. It only applies to the writer and does not need to match the peer
+	ReferenceClock     ClockSource         `json:"-" yaml:"-"`                   // ReferenceClock measures the latency of the echoes as well, if set with Echo, to report the divergence of both clocks as a bound on the error of the latency. Messages then need 8 more bytes for the second send time
+	ThroughputInterval time.Duration       `json:"-" yaml:"throughput_interval"` // ThroughputInterval defines how often the throughput is sampled into throughput_intervals, not sampled if not set. It does not need to match the peer
+	MaxMessageErrors   uint64              `json:"-" yaml:"max_message_errors"`  // MaxMessageErrors defines how many messages may fail verification before the reader fails the run, unlimited if not set
+	Pacing             Pacing              `json:"-" yaml:"pacing"`              // Pacing selects how the sender waits for each interval, it does not need to match the peer
+	EchoWindow         uint64              `json:"-" yaml:"echo_window"`         // EchoWindow defines how many messages may be sent after one still awaiting its echo before it is given up as lost, matching echoes by sequence number so that reordered echoes are still matched, if set. It does not need to match the peer
+	ThinkTime          *DelayDistribution  `json:"-" yaml:"think_time"`          // ThinkTime delays each echo by a think time drawn from it, modeling the work of a server rather than an echo at wire speed, if set with Echo. It only applies to the reader and does not need to match the peer
+	Control            *ControlChannel     `json:"-" yaml:"-"`                   // Control carries the handshake instead of the data connection if set, it must be set on both sides
+
+	OnProgress       func(ProgressSnapshot) `json:"-" yaml:"-"` // OnProgress is called periodically while the benchmark runs if set, and once more when it stops
+	ProgressInterval time.Duration          `json:"-" yaml:"-"` // ProgressInterval defines how often OnProgress is called, DefaultProgressInterval if not set
+
+	OnStart    func()               `json:"-" yaml:"-"` // OnStart is called once the measurement starts, after the handshake and the warmup, if set
+	OnMessage  func(MessageEvent)   `json:"-" yaml:"-"` // OnMessage is called for each message written or read during the measurement if set. It is called from the loop sending or receiving the messages, so it must return quickly. The writer calls it for the echoes received concurrently with the messages written
+	OnComplete func(map[string]any) `json:"-" yaml:"-"` // OnComplete is called with the result once the benchmark ended without error if set
+	OnError    func(error)          `json:"-" yaml:"-"` // OnError is called with the error the benchmark failed with if set
+
+	messageSize      int             // an internal copy of the message size used in the last run, the mean frame size with SizeDistribution
+	framing          *messageFraming // the framing of the messages in the last run
+	socketOptions    map[string]any  // the effective socket options at the start of the last run
 	successfulReads  atomic.Uint64
 	successfulWrites atomic.Uint64
+	bytesRead        atomic.Uint64
+	bytesWritten     atomic.Uint64
 	startTime        atomic.Value
 	endTime          atomic.Value
 
-	echoMap                  *sync.Map     // used for sender to calculate latency
-	totalLatency             atomic.Uint64 // used for sender to calculate latency
-	totalMessagesWithLatency atomic.Uint64 // used for sender to calculate latency
-	ticker                   *time.Ticker
-
-	combinedCounter *CombinedCounter
+	echoWindow               *echoWindow      // used for sender to match echoes with EchoWindow
+	totalLatency             atomic.Uint64    // used for sender to calculate latency
+	totalMessagesWithLatency atomic.Uint64    // used for sender to calculate latency
+	latencyHistogram         *Histogram       // used for sender to calculate latency percentiles
+	latencyTimeline          *latencyTimeline // used for sender to summarize the latency of each counter tick
+	jitter                   jitterRecorder   // used for sender to estimate the jitter of the latency
+	reducers                 reducerRecorder  // used for sender to feed the latency to Reducers
+	clockCheck               *clockCrossCheck // used for sender to cross-check the latency with ReferenceClock
+	pacer                    *intervalPacer   // used for sender to wait for each interval
+
+	expectedMessages uint64               // used for receiver, TotalMessages or the number of messages sent with TargetDuration
+	termination      string               // why the last run ended, one of the Termination* reasons
+	verifier         messageVerifier      // used for receiver to validate messages, and for sender to digest them, if Verify is set
+	messageErrors    messageErrorRecorder // used for receiver to count the messages failing verification
+	totalThinkTime   atomic.Uint64        // used for receiver to total the think time before the echoes
+	schedLatency     schedLatencyRecorder
+	decorators       decoratorRecorder
+	versions         versionRecorder
+	allocs           allocRecorder
+	coalescing       coalescingRecorder
+	combinedCounter  *CombinedCounter
+	throughput       *throughputTimeline // used to sample the throughput with ThroughputInterval
 }
 
-func (b *IntervalBenchmark) Writer(conn net.Conn, counters ...Counter) error {
-	// Compare benchmark specs on both sides
-	{
-		specJson, err := json.Marshal(b)
-		if err != nil {
+func (b *IntervalBenchmark) Writer(conn net.Conn, counters ...Counter) (err error) {
+	defer func() {
+		completeRun(err, b.OnComplete, b.OnError, b.Result)
+	}()
+
+	if err := validateSizeDistribution(b.SizeDistribution, b.Verify); err != nil {
+		return err
+	}
+	if err := b.Histogram.validate(b.Profile); err != nil {
+		return err
+	}
+	b.framing = newMessageFraming(b.MessageSize, b.SizeDistribution)
+	if err := validateWarmup(b.framing.bufferSize(), b.WarmupDuration); err != nil {
+		return err
+	}
+	if err := validateVerification(b.framing.bufferSize(), b.Verify); err != nil {
+		return err
+	}
+	if err := validateTargetDuration(b.framing.bufferSize(), b.TargetDuration); err != nil {
+		return err
+	}
+	if b.Completion != nil {
+		if err := b.Completion.validate(b.framing.bufferSize(), b.Verify); err != nil {
 			return err
 		}
-
-		specLenWr, err := conn.Write(specJson)
-		if err != nil {
+	}
+	if err := validateEchoWindow(b.MessageSize, b.SizeDistribution, b.Echo, b.EchoWindow); err != nil {
+		return err
+	}
+	stampHeader := sendTimeHeader(true, b.Verify)
+	if b.Echo {
+		if err := validateSendTime(b.MessageSize, b.SizeDistribution, stampHeader); err != nil {
 			return err
 		}
-
-		if specLenWr != len(specJson) {
-			return errors.New("failed to write the spec to the connection")
+	}
+	if b.ReferenceClock != nil {
+		if !b.Echo {
+			return errors.New("reference clock requires echo")
 		}
-
-		receivedSpecJson := make([]byte, 2*len(specJson))
-		specLenRd, err := conn.Read(receivedSpecJson)
-		if err != nil {
+		// the reference send time precedes the send time
+		if err := validateSendTime(b.MessageSize, b.SizeDistribution, stampHeader+sendTimeSize); err != nil {
 			return err
 		}
+	}
 
-		if specLenRd != len(specJson) {
-			return errors.New("failed to read the spec from the connection")
-		}
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
 
-		if !bytes.Equal(specJson, receivedSpecJson[:specLenRd]) {
-			return errors.New("benchmark specs do not match, aborting")
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
 		}
+	}()
+
+	// Warm up the connection, excluded from counters and timing
+	if err := sendWarmup(conn, b.framing.bufferSize(), b.WarmupMessages, b.WarmupDuration, b.Interval, b.Echo); err != nil {
+		return err
 	}
 
 	var exitedDueToDeadline atomic.Bool
@@ -248,9 +747,20 @@ func (b *IntervalBenchmark) Writer(conn net.Conn, counters ...Counter) error {
 	b.combinedCounter = CombineCounters(time.Second, counters...)
 
 	// Benchmark starts
-	b.messageSize = b.MessageSize
+	b.messageSize = b.meanMessageSize()
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
 	b.successfulReads.Store(0)
 	b.successfulWrites.Store(0)
+	b.bytesRead.Store(0)
+	b.bytesWritten.Store(0)
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.coalescing.startRecording(conn)
+	defer b.coalescing.stopRecording()
+	b.endTime.Store(time.Time{}) // running, see Snapshot
 	b.startTime.Store(time.Now())
 	defer func() {
 		if exitedDueToDeadline.Load() {
@@ -259,54 +769,141 @@ func (b *IntervalBenchmark) Writer(conn net.Conn, counters ...Counter) error {
 			b.endTime.Store(time.Now())
 		}
 	}()
-	b.echoMap = new(sync.Map)
+	b.totalLatency.Store(0)
+	b.totalMessagesWithLatency.Store(0)
+	b.latencyHistogram = newLatencyHistogram(b.Histogram, b.Profile)
+	b.jitter.reset()
+	b.reducers.reset(b.Reducers)
+	b.verifier.reset()
+	b.clockCheck = newClockCrossCheck(b.ReferenceClock, b.Histogram, b.Profile)
+	b.echoWindow = nil
+	if b.EchoWindow > 0 {
+		b.echoWindow = newEchoWindow(b.EchoWindow)
+	}
+	startTime := b.startTime.Load().(time.Time)
+	now := clockNow(b.Clock, startTime)
 
 	// Start the counter
 	if b.combinedCounter != nil {
 		b.combinedCounter.Start()
 		defer b.combinedCounter.Stop()
 	}
+	b.throughput = startThroughputTimeline(b.ThroughputInterval, b.startTime.Load().(time.Time), &b.bytesRead, &b.bytesWritten, b.Profile)
+	defer b.throughput.stop()
+	if b.OnStart != nil {
+		b.OnStart()
+	}
+
+	// Summarize the latency of each counter tick
+	b.latencyTimeline = nil
+	if b.Echo {
+		b.latencyTimeline = startLatencyTimeline(b.combinedCounter.tickInterval(), startTime, "latency_", b.Histogram, b.Profile, b.HistogramLog)
+		defer b.latencyTimeline.stop()
+	}
 
-	var wgEcho sync.WaitGroup
+	// Report the progress, counting the echoes received as reads, along
+	// with their latencies
+	progressReads := &b.successfulReads
+	onProgress := b.Control.reportProgress(b.OnProgress)
+	var latencies *latencyWindow
+	if b.Echo {
+		progressReads = &b.totalMessagesWithLatency
+		latencies = newLatencyWindow(onProgress, newLatencyHistogram(b.Histogram, b.Profile))
+	}
+	defer startProgress(onProgress, b.ProgressInterval, b.messageSize, b.progressTotal(), startTime, progressReads, &b.successfulWrites, latencies, nil)()
+
+	var echoDone = make(chan struct{})
+	var deadlineUnsupported atomic.Bool
 	if b.Echo { // if echo is enabled start a goroutine to read echoed messages
-		wgEcho.Add(1)
 		go func() {
-			defer wgEcho.Done()
-			var receivedMsg = make([]byte, b.messageSize)
+			defer close(echoDone)
+			pooledBuf := messageBuffers.get(b.framing.bufferSize())
+			defer messageBuffers.put(pooledBuf)
+			var receivedBuf = *pooledBuf
 			for {
-				conn.SetReadDeadline(time.Now().Add(1 * time.Second).Add(b.Interval)) // set a deadline for reading echoed messages
+				// set a deadline for reading echoed messages
+				if !setReadDeadline(conn, time.Now().Add(1*time.Second).Add(b.Interval)) {
+					deadlineUnsupported.Store(true)
+				}
 				// n, err := conn.Read(receivedMsg) // risk reading partial messages
-				n, err := io.ReadFull(conn, receivedMsg) // read full length of the message
+				receivedMsg, err := b.framing.read(conn, receivedBuf) // read full length of the message
 				if err != nil {
 					if errors.Is(err, os.ErrDeadlineExceeded) {
 						exitedDueToDeadline.Store(true)
 					}
 					return
 				}
-				if sendTime, ok := b.echoMap.Load(string(receivedMsg[:n])); ok {
-					b.totalMessagesWithLatency.Add(1)
-					b.echoMap.CompareAndDelete(string(receivedMsg[:n]), sendTime)
-
-					// calculate latency
-					latency := time.Since(sendTime.(time.Time)).Nanoseconds()
-					b.totalLatency.Add(uint64(latency))
+				// the echo carries its own send time, so nothing is kept
+				// per message in flight
+				payload := b.framing.payload(receivedMsg)
+				if b.echoWindow != nil && !b.echoWindow.match(payload) {
+					continue
+				}
+				sendTime, ok := readSendTime(payload, stampHeader)
+				latency := now() - sendTime
+				if !ok || sendTime < 0 || latency < 0 {
+					continue // too small to be timed, or not sent by this run, e.g., corrupted
+				}
+				if b.clockCheck != nil {
+					if referenceSendTime, ok := readSendTime(payload[:len(payload)-sendTimeSize], stampHeader); ok {
+						b.clockCheck.record(latency, b.ReferenceClock.Now()-referenceSendTime)
+					}
+				}
+				echoes := b.totalMessagesWithLatency.Add(1)
+				b.totalLatency.Add(uint64(latency))
+				b.latencyHistogram.Record(latency)
+				b.jitter.record(latency)
+				latencies.record(latency)
+				b.latencyTimeline.record(latency)
+				b.reducers.record(latency)
+				if b.OnMessage != nil {
+					b.OnMessage(MessageEvent{Index: echoes - 1, Size: len(receivedMsg), Latency: time.Duration(latency)})
 				}
 			}
 		}()
 	}
 
-	// Start sending messages using ticker
-	b.ticker = time.NewTicker(b.Interval)
+	// Start sending messages, paced by the ticker or spinning
+	b.pacer = newIntervalPacer(b.Interval, b.Pacing.resolve(b.Interval))
+
+	// a single buffer is reused for all messages, since conns must not
+	// retain written buffers and echoed messages carry their send time
+	pooledBuf := messageBuffers.get(b.framing.bufferSize())
+	defer messageBuffers.put(pooledBuf)
+	var msgBuf = *pooledBuf
+	var refill = b.Profile != ProfileConstrained
+	if !refill {
+		payloadGenerator(b.Payload).Fill(msgBuf) // fill once and reuse, with the first bytes replaced by the sequence number
+	}
 
 	var i uint64
-	for i = 0; i < b.TotalMessages; i++ {
-		<-b.ticker.C // wait for the interval
-		var randMsg []byte = make([]byte, b.messageSize)
-		crand.Read(randMsg)
-
-		if b.Echo { // if echo is enabled, record the message to the echo map
-			sendTime := time.Now()
-			b.echoMap.Store(string(randMsg), sendTime) // save key as hash of the message and value as the time it was sent
+	for i = 0; ; i++ {
+		if b.termination = b.writerTermination(i, b.bytesWritten.Load(), startTime); b.termination != "" {
+			break
+		}
+		if i%b.burstSize() == 0 {
+			b.pacer.wait() // wait for the interval before each burst
+		}
+		randMsg := b.framing.next(msgBuf)
+		if refill {
+			payloadGenerator(b.Payload).Fill(b.framing.payload(randMsg))
+		}
+		if !refill || b.Echo {
+			stampSequence(b.framing.payload(randMsg), i) // keep reused messages distinguishable and echoes matchable
+		}
+		if b.Echo { // if echo is enabled, stamp the send time for the echo
+			payload := b.framing.payload(randMsg)
+			stampSendTime(payload, stampHeader, now())
+			if b.clockCheck != nil && len(payload) >= sendTimeSize {
+				stampSendTime(payload[:len(payload)-sendTimeSize], stampHeader, b.ReferenceClock.Now())
+			}
+			if b.echoWindow != nil {
+				b.echoWindow.push(i)
+			}
+		}
+		if b.Verify {
+			stampVerification(randMsg, i)
+			b.verifier.written(randMsg)
 		}
 
 		_, err := conn.Write(randMsg)
@@ -315,56 +912,116 @@ func (b *IntervalBenchmark) Writer(conn net.Conn, counters ...Counter) error {
 		}
 
 		b.successfulWrites.Add(1)
+		b.bytesWritten.Add(uint64(len(randMsg)))
+		if b.OnMessage != nil {
+			b.OnMessage(MessageEvent{Index: i, Size: len(randMsg), Written: true})
+		}
 	}
-	b.ticker.Stop()
+	b.pacer.stop()
 
-	wgEcho.Wait()
+	if b.untilEndMarker() {
+		if _, err := conn.Write(b.framing.endMarker(i)); err != nil {
+			return err
+		}
+	}
+
+	if b.Echo {
+		if deadlineUnsupported.Load() {
+			// without deadlines, stop waiting once echoes stop arriving
+			if waitIdle(echoDone, &b.totalMessagesWithLatency, 1*time.Second+b.Interval) {
+				exitedDueToDeadline.Store(true)
+			}
+		} else {
+			<-echoDone
+		}
+	}
 
 	return nil
 }
 
-func (b *IntervalBenchmark) Reader(conn net.Conn, counters ...Counter) error {
-	// Compare benchmark specs on both sides
-	{
-		specJson, err := json.Marshal(b)
-		if err != nil {
-			return err
-		}
+func (b *IntervalBenchmark) Reader(conn net.Conn, counters ...Counter) (err error) {
+	defer func() {
+		completeRun(err, b.OnComplete, b.OnError, b.Result)
+	}()
 
-		receivedSpecJson := make([]byte, 2*len(specJson))
-		specLenRd, err := conn.Read(receivedSpecJson)
-		if err != nil {
+	if err := validateSizeDistribution(b.SizeDistribution, b.Verify); err != nil {
+		return err
+	}
+	if err := b.Histogram.validate(b.Profile); err != nil {
+		return err
+	}
+	b.framing = newMessageFraming(b.MessageSize, b.SizeDistribution)
+	if err := validateVerification(b.framing.bufferSize(), b.Verify); err != nil {
+		return err
+	}
+	if err := validateTargetDuration(b.framing.bufferSize(), b.TargetDuration); err != nil {
+		return err
+	}
+	if b.Completion != nil {
+		if err := b.Completion.validate(b.framing.bufferSize(), b.Verify); err != nil {
 			return err
 		}
-
-		if specLenRd != len(specJson) {
-			return errors.New("failed to read the spec from the connection")
-		}
-
-		if !bytes.Equal(specJson, receivedSpecJson[:specLenRd]) {
-			return errors.New("benchmark specs do not match, aborting")
+	}
+	if b.ThinkTime != nil {
+		if !b.Echo {
+			return errors.New("think time requires Echo")
 		}
-
-		specLenWr, err := conn.Write(specJson)
-		if err != nil {
+		if err := b.ThinkTime.Validate(); err != nil {
 			return err
 		}
+	}
 
-		if specLenWr != len(specJson) {
-			return errors.New("failed to write the spec to the connection")
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
 		}
+	}()
+
+	// Warm up the connection, excluded from counters and timing
+	if err := receiveWarmup(conn, b.framing.bufferSize(), b.WarmupMessages, b.WarmupDuration, b.Echo); err != nil {
+		return err
 	}
 
 	// Create combined counter
 	b.combinedCounter = CombineCounters(time.Second, counters...)
 
 	// Benchmark starts
-	b.messageSize = b.MessageSize
+	b.messageSize = b.meanMessageSize()
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
 	b.successfulReads.Store(0)
 	b.successfulWrites.Store(0)
+	b.bytesRead.Store(0)
+	b.bytesWritten.Store(0)
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.coalescing.startRecording(conn)
+	defer b.coalescing.stopRecording()
+	b.endTime.Store(time.Time{}) // running, see Snapshot
 	b.startTime.Store(time.Now())
+	var stoppedAt time.Time // when the error budget of Completion ran out, the rest of the run is discarded
+	var closedAt time.Time  // when the peer closed the data connection, before waiting for its abort
 	defer func() {
-		b.endTime.Store(time.Now())
+		switch {
+		case !stoppedAt.IsZero():
+			b.endTime.Store(stoppedAt)
+		case !closedAt.IsZero():
+			b.endTime.Store(closedAt)
+		default:
+			b.endTime.Store(time.Now())
+		}
 	}()
 
 	// Start the counter
@@ -372,32 +1029,141 @@ func (b *IntervalBenchmark) Reader(conn net.Conn, counters ...Counter) error {
 		b.combinedCounter.Start()
 		defer b.combinedCounter.Stop()
 	}
+	b.throughput = startThroughputTimeline(b.ThroughputInterval, b.startTime.Load().(time.Time), &b.bytesRead, &b.bytesWritten, b.Profile)
+	defer b.throughput.stop()
+	if b.OnStart != nil {
+		b.OnStart()
+	}
+
+	// Report the progress
+	b.messageErrors.reset(b.startTime.Load().(time.Time), b.MaxMessageErrors)
+	defer startProgress(b.Control.reportProgress(b.OnProgress), b.ProgressInterval, b.messageSize, b.progressTotal(), b.startTime.Load().(time.Time), &b.successfulReads, &b.successfulWrites, nil, &b.messageErrors)()
 
-	var receivedMsg = make([]byte, b.messageSize)
-	for b.successfulReads.Load() < b.TotalMessages {
+	b.verifier.reset()
+	b.expectedMessages = b.TotalMessages
+	if b.Completion != nil {
+		b.expectedMessages = 0 // unknown until the end marker arrives
+	}
+	b.termination = ""
+	b.totalThinkTime.Store(0)
+	var thinkTime func() time.Duration
+	if b.ThinkTime != nil {
+		thinkTime = b.ThinkTime.sampler()
+	}
+	pooledBuf := messageBuffers.get(b.framing.bufferSize())
+	defer messageBuffers.put(pooledBuf)
+	var receivedBuf = *pooledBuf
+	for b.untilEndMarker() || b.successfulReads.Load() < b.TotalMessages {
 		// n, err := conn.Read(receivedMsg) // risk reading partial messages
-		n, err := io.ReadFull(conn, receivedMsg) // read full length of the message
+		receivedMsg, err := b.framing.read(conn, receivedBuf) // read full length of the message
 		if err != nil {
 			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
-				return nil
+				closedAt = time.Now()
+				return b.Control.closedErr()
 			}
 			return err
 		}
+		if b.untilEndMarker() {
+			if sent, ok := b.framing.parseEndMarker(receivedMsg); ok {
+				if stoppedAt.IsZero() {
+					b.expectedMessages = sent
+					b.termination = readerTermination(b.Completion, b.TargetDuration, sent, b.bytesRead.Load())
+				}
+				break
+			}
+		}
+		if !stoppedAt.IsZero() {
+			// discard the rest of the run, still echoing it for the
+			// writer to end its run
+			if b.Echo {
+				if _, err := conn.Write(receivedMsg); err != nil {
+					return err
+				}
+			}
+			continue
+		}
 		b.successfulReads.Add(1)
+		b.bytesRead.Add(uint64(len(receivedMsg)))
+		if b.OnMessage != nil {
+			b.OnMessage(MessageEvent{Index: b.successfulReads.Load() - 1, Size: len(receivedMsg)})
+		}
+
+		if b.Verify {
+			if kind := b.verifier.check(receivedMsg); kind != "" {
+				if err := b.messageErrors.record(kind); err != nil {
+					return err
+				}
+				if b.Completion != nil && b.Completion.Errors > 0 && b.messageErrors.count() >= b.Completion.Errors {
+					stoppedAt = time.Now()
+					b.termination = TerminationErrors
+					b.expectedMessages = b.successfulReads.Load()
+				}
+			}
+		}
 
 		if b.Echo { // if echo is enabled, echo back the received message
-			_, err := conn.Write(receivedMsg[:n])
+			if thinkTime != nil {
+				// record the think time actually slept, timers overshoot
+				thinkStart := time.Now()
+				time.Sleep(thinkTime())
+				b.totalThinkTime.Add(uint64(time.Since(thinkStart)))
+			}
+			_, err := conn.Write(receivedMsg)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	if b.termination == "" {
+		b.termination = TerminationMessages
+	}
 	return nil
 }
 
+// burstSize returns how many messages are sent on each tick.
+func (b *IntervalBenchmark) burstSize() uint64 {
+	if b.BurstSize == 0 {
+		return 1
+	}
+	return b.BurstSize
+}
+
+// meanMessageSize returns the size of the messages, including the length
+// header with a size distribution, on average.
+func (b *IntervalBenchmark) meanMessageSize() int {
+	if b.SizeDistribution == nil {
+		return b.MessageSize
+	}
+	return frameHeaderSize + int(b.SizeDistribution.Mean())
+}
+
+// progressTotal returns the number of messages expected, 0 if unknown.
+func (b *IntervalBenchmark) progressTotal() uint64 {
+	if b.Completion != nil {
+		if b.Completion.Bytes > 0 || b.Completion.Duration > 0 {
+			return 0
+		}
+		return b.Completion.Messages
+	}
+	if b.TargetDuration > 0 {
+		return 0
+	}
+	return b.TotalMessages
+}
+
+// Snapshot returns a view of the run in progress, or of the last run once
+// it ended, counting the echoes received by the writer as messages read.
+// Unlike Result, it is safe to call while the benchmark runs, e.g., from a
+// monitoring goroutine.
+func (b *IntervalBenchmark) Snapshot() RunSnapshot {
+	s := takeSnapshot(&b.startTime, &b.endTime, &b.successfulReads, &b.successfulWrites, &b.bytesRead, &b.bytesWritten)
+	s.MessagesRead += b.totalMessagesWithLatency.Load()
+	return s
+}
+
 func (b *IntervalBenchmark) Result() map[string]any {
-	if b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
 		return map[string]any{}
 	}
 
@@ -408,16 +1174,89 @@ func (b *IntervalBenchmark) Result() map[string]any {
 		"end_time":          b.endTime.Load().(time.Time).Format(time.RFC3339),
 		"duration":          b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).String(),
 	}
+	if b.termination != "" {
+		result["termination_reason"] = b.termination
+	}
+	addThroughput(result, b.bytesRead.Load(), b.bytesWritten.Load(),
+		b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds(), b.Profile)
+	b.throughput.addResult(result)
 
 	// Reader only: calculate ops_per_sec and latency_ms
 	if b.successfulReads.Load() > 0 {
-		result["ops_per_s"] = float64(b.successfulReads.Load()+b.successfulWrites.Load()) / float64(b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds()) * 1e9
+		ops := b.successfulReads.Load() + b.successfulWrites.Load()
+		durationNs := b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds()
+		if b.Profile == ProfileConstrained { // integer-only stats
+			result["ops_per_s"] = ops * 1e9 / uint64(durationNs)
+		} else {
+			result["ops_per_s"] = float64(ops) / float64(durationNs) * 1e9
+		}
 	}
 
 	if b.totalMessagesWithLatency.Load() > 0 {
-		result["latency_ns"] = float64(b.totalLatency.Load()) / float64(b.totalMessagesWithLatency.Load()) // in nanoseconds
+		if b.Profile == ProfileConstrained { // integer-only stats
+			result["latency_ns"] = b.totalLatency.Load() / b.totalMessagesWithLatency.Load() // in nanoseconds
+		} else {
+			result["latency_ns"] = float64(b.totalLatency.Load()) / float64(b.totalMessagesWithLatency.Load()) // in nanoseconds
+		}
 	}
 
+	if b.latencyHistogram != nil && b.latencyHistogram.TotalCount() > 0 {
+		for name, value := range b.latencyHistogram.Percentiles() {
+			result["latency_"+name+"_ns"] = value // in nanoseconds
+		}
+		if b.Profile == ProfileConstrained { // integer-only stats
+			result["latency_stddev_ns"] = int64(b.latencyHistogram.StdDev())
+		} else {
+			result["latency_stddev_ns"] = b.latencyHistogram.StdDev()
+		}
+	}
+	b.jitter.addResult(result, b.Profile)
+	b.latencyTimeline.addResult(result)
+	b.reducers.addResult(result, "latency")
+	if b.Clock != nil && b.totalMessagesWithLatency.Load() > 0 {
+		result["latency_clock"] = b.Clock.String()
+	}
+	b.clockCheck.addResult(result, b.Profile)
+	if b.echoWindow != nil {
+		b.echoWindow.addResult(result)
+	}
+
+	// Reader only: verification, writer only: the digest of the messages
+	// written
+	if b.Verify && b.successfulReads.Load() > 0 {
+		b.verifier.addResult(result, b.expectedMessages)
+		b.messageErrors.addResult(result)
+	} else if b.Verify && b.successfulWrites.Load() > 0 {
+		b.verifier.addDigestResult(result)
+	}
+
+	// Reader only: the think time before each echo
+	if b.ThinkTime != nil && b.successfulReads.Load() > 0 {
+		result["think_time"] = b.ThinkTime.String()
+		if b.Profile == ProfileConstrained { // integer-only stats
+			result["think_time_ns"] = b.totalThinkTime.Load() / b.successfulReads.Load()
+		} else {
+			result["think_time_ns"] = float64(b.totalThinkTime.Load()) / float64(b.successfulReads.Load())
+		}
+	}
+
+	// Sender only: the pacing in effect
+	if b.pacer != nil {
+		result["pacing"] = b.pacer.pacing.String()
+	}
+
+	b.schedLatency.addResult(result)
+	b.allocs.addResult(result, b.successfulReads.Load()+b.successfulWrites.Load(), b.Profile)
+	b.coalescing.addResult(result, b.bytesRead.Load(), b.bytesWritten.Load(), b.successfulReads.Load(), b.successfulWrites.Load(), b.Profile)
+
+	if b.socketOptions != nil {
+		result["socket_options"] = b.socketOptions
+	}
+	b.decorators.addResult(result, b.Profile)
+	b.versions.addResult(result)
+
+	b.Control.addAbortResult(result)
+
 	if b.combinedCounter != nil {
 		result["counters"] = b.combinedCounter.Results()
 	}
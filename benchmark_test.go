@@ -1,7 +1,9 @@
 package benchmarkconn_test
 
 import (
+	"errors"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -124,3 +126,559 @@ func TestIntervalBenchmark(t *testing.T) {
 	t.Logf("Sender(%s): %v", senderConn.LocalAddr(), senderIntervalBenchmark.Result())
 	t.Logf("Receiver(%s): %v", receiverConn.LocalAddr(), receiverIntervalBenchmark.Result())
 }
+
+func TestIntervalBenchmarkBurst(t *testing.T) {
+	writer := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 100, Interval: 20 * time.Millisecond, BurstSize: 10}
+	reader := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 100, Interval: 20 * time.Millisecond, BurstSize: 10}
+	runOverTCP(t, writer, reader)
+
+	if reads := reader.Result()["successful_reads"]; reads != uint64(100) {
+		t.Errorf("expected 100 messages read, got %v", reads)
+	}
+
+	// 10 bursts of 10 messages take 10 ticks rather than 100
+	duration, err := time.ParseDuration(writer.Result()["duration"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duration >= time.Second {
+		t.Errorf("expected 10 ticks of 20ms, took %v", duration)
+	}
+}
+
+func TestPressuredBenchmarkTargetBandwidth(t *testing.T) {
+	// 1000 messages of 1 KiB at 4 MiB/s take about 250ms
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, TargetBandwidth: 4 << 20}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, TargetBandwidth: 4 << 20}
+	runOverTCP(t, writer, reader)
+
+	if reads := reader.Result()["successful_reads"]; reads != uint64(1000) {
+		t.Errorf("expected 1000 messages read, got %v", reads)
+	}
+
+	throughput := writer.Result()["throughput_bps"].(float64)
+	if target := float64(4<<20) * 8; throughput > 1.1*target || throughput < 0.8*target {
+		t.Errorf("expected a throughput of about %g bps, got %g", target, throughput)
+	}
+}
+
+func TestTinyWriteProbe(t *testing.T) {
+	var writerProbe = &TinyWriteProbe{
+		MessageSize: 1024,
+		Rounds:      1000,
+	}
+
+	var readerProbe = &TinyWriteProbe{
+		MessageSize: 1024,
+		Rounds:      1000,
+	}
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiverConn, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Sender
+	go func() {
+		defer wg.Done()
+		err := writerProbe.Writer(senderConn)
+		if err != nil {
+			t.Logf("Sender errored: %v", err)
+		}
+	}()
+
+	// Receiver
+	go func() {
+		defer wg.Done()
+		err := readerProbe.Reader(receiverConn)
+		if err != nil {
+			t.Logf("Receiver errored: %v", err)
+		}
+	}()
+
+	wg.Wait()
+
+	t.Logf("Sender(%s): %v", senderConn.LocalAddr(), writerProbe.Result())
+	t.Logf("Receiver(%s): %v", receiverConn.LocalAddr(), readerProbe.Result())
+
+	if passed, _ := readerProbe.Result()["passed"].(bool); !passed {
+		t.Errorf("TCP should pass the tiny write probe")
+	}
+}
+
+func TestIntervalBenchmarkConstrained(t *testing.T) {
+	var senderIntervalBenchmark = &IntervalBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 10000,
+		Interval:      10 * time.Microsecond,
+		Echo:          true,
+		Profile:       ProfileConstrained,
+	}
+
+	var receiverIntervalBenchmark = &IntervalBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 10000,
+		Interval:      10 * time.Microsecond,
+		Echo:          true,
+		Profile:       ProfileConstrained,
+	}
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiverConn, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderConn.(*net.TCPConn).SetNoDelay(true)
+	receiverConn.(*net.TCPConn).SetNoDelay(true)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Sender
+	go func() {
+		defer wg.Done()
+		err := senderIntervalBenchmark.Writer(senderConn)
+		if err != nil {
+			t.Logf("Sender errored: %v", err)
+		}
+	}()
+
+	// Receiver
+	go func() {
+		defer wg.Done()
+		err := receiverIntervalBenchmark.Reader(receiverConn)
+		if err != nil {
+			t.Logf("Receiver errored: %v", err)
+		}
+	}()
+
+	wg.Wait()
+
+	senderResult := senderIntervalBenchmark.Result()
+	t.Logf("Sender(%s): %v", senderConn.LocalAddr(), senderResult)
+	t.Logf("Receiver(%s): %v", receiverConn.LocalAddr(), receiverIntervalBenchmark.Result())
+
+	if _, ok := senderResult["latency_ns"].(uint64); !ok {
+		t.Errorf("expected integer latency with constrained profile, got %T", senderResult["latency_ns"])
+	}
+	if _, ok := senderResult["throughput_bps"].(uint64); !ok {
+		t.Errorf("expected integer throughput with constrained profile, got %T", senderResult["throughput_bps"])
+	}
+}
+
+func TestParallelBenchmark(t *testing.T) {
+	const parallel = 4
+
+	newPressuredBenchmark := func() Benchmark {
+		return &PressuredBenchmark{
+			MessageSize:   1024,
+			TotalMessages: 10000,
+		}
+	}
+
+	var senderParallelBenchmark = &ParallelBenchmark{New: newPressuredBenchmark}
+	var receiverParallelBenchmark = &ParallelBenchmark{New: newPressuredBenchmark}
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var senderConns, receiverConns []net.Conn
+	for i := 0; i < parallel; i++ {
+		senderConn, err := net.Dial("tcp", tcpListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		senderConns = append(senderConns, senderConn)
+
+		receiverConn, err := tcpListener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiverConns = append(receiverConns, receiverConn)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Sender
+	go func() {
+		defer wg.Done()
+		err := senderParallelBenchmark.Writer(senderConns)
+		if err != nil {
+			t.Logf("Sender errored: %v", err)
+		}
+	}()
+
+	// Receiver
+	go func() {
+		defer wg.Done()
+		err := receiverParallelBenchmark.Reader(receiverConns)
+		if err != nil {
+			t.Logf("Receiver errored: %v", err)
+		}
+	}()
+
+	wg.Wait()
+
+	receiverResult := receiverParallelBenchmark.Result()
+	t.Logf("Sender: %v", senderParallelBenchmark.Result())
+	t.Logf("Receiver: %v", receiverResult)
+
+	if reads := receiverResult["successful_reads"]; reads != uint64(parallel*10000) {
+		t.Errorf("expected %d successful reads in total, got %v", parallel*10000, reads)
+	}
+	if bytesRead := receiverResult["bytes_read"]; bytesRead != uint64(parallel*10000*1024) {
+		t.Errorf("expected %d bytes read in total, got %v", parallel*10000*1024, bytesRead)
+	}
+	if throughput, ok := receiverResult["throughput_Mbps"].(float64); !ok || throughput <= 0 {
+		t.Errorf("expected positive throughput, got %v", receiverResult["throughput_Mbps"])
+	}
+}
+
+// noDeadlineConn mimics conns implemented inside WASM runtimes which do
+// not support deadlines.
+type noDeadlineConn struct {
+	net.Conn
+}
+
+func (noDeadlineConn) SetReadDeadline(time.Time) error {
+	return errors.New("deadlines not supported")
+}
+
+func TestIntervalBenchmarkWithoutDeadlines(t *testing.T) {
+	var senderIntervalBenchmark = &IntervalBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 1000,
+		Interval:      10 * time.Microsecond,
+		Echo:          true,
+	}
+
+	var receiverIntervalBenchmark = &IntervalBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 1000,
+		Interval:      10 * time.Microsecond,
+		Echo:          true,
+	}
+
+	senderConn, receiverConn := net.Pipe()
+	defer senderConn.Close()
+	defer receiverConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Sender
+	go func() {
+		defer wg.Done()
+		err := senderIntervalBenchmark.Writer(noDeadlineConn{senderConn})
+		if err != nil {
+			t.Logf("Sender errored: %v", err)
+		}
+	}()
+
+	// Receiver
+	go func() {
+		defer wg.Done()
+		err := receiverIntervalBenchmark.Reader(noDeadlineConn{receiverConn})
+		if err != nil {
+			t.Logf("Receiver errored: %v", err)
+		}
+	}()
+
+	wg.Wait()
+
+	senderResult := senderIntervalBenchmark.Result()
+	t.Logf("Sender: %v", senderResult)
+	t.Logf("Receiver: %v", receiverIntervalBenchmark.Result())
+
+	if senderResult["successful_writes"] != uint64(1000) {
+		t.Errorf("expected 1000 successful writes, got %v", senderResult["successful_writes"])
+	}
+}
+
+// runOverTCP runs writer and reader against each other over a loopback
+// TCP connection and waits for both to complete.
+func runOverTCP(t *testing.T, writer, reader Benchmark) {
+	t.Helper()
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	senderConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer senderConn.Close()
+
+	receiverConn, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiverConn.Close()
+
+	senderConn.(*net.TCPConn).SetNoDelay(true)
+	receiverConn.(*net.TCPConn).SetNoDelay(true)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Sender
+	go func() {
+		defer wg.Done()
+		err := writer.Writer(senderConn)
+		if err != nil {
+			t.Logf("Sender errored: %v", err)
+		}
+	}()
+
+	// Receiver
+	go func() {
+		defer wg.Done()
+		err := reader.Reader(receiverConn)
+		if err != nil {
+			t.Logf("Receiver errored: %v", err)
+		}
+	}()
+
+	wg.Wait()
+
+	t.Logf("Sender(%s): %v", senderConn.LocalAddr(), writer.Result())
+	t.Logf("Receiver(%s): %v", receiverConn.LocalAddr(), reader.Result())
+}
+
+func TestBenchmarkWarmup(t *testing.T) {
+	t.Run("PressuredMessages", func(t *testing.T) {
+		newBenchmark := func() *PressuredBenchmark {
+			return &PressuredBenchmark{
+				MessageSize:    1024,
+				TotalMessages:  10000,
+				WarmupMessages: 5000,
+			}
+		}
+
+		reader := newBenchmark()
+		runOverTCP(t, newBenchmark(), reader)
+
+		if reads := reader.Result()["successful_reads"]; reads != uint64(10000) {
+			t.Errorf("expected warmup messages to be excluded, got %v successful reads", reads)
+		}
+	})
+
+	t.Run("IntervalDuration", func(t *testing.T) {
+		newBenchmark := func() *IntervalBenchmark {
+			return &IntervalBenchmark{
+				MessageSize:    1024,
+				TotalMessages:  1000,
+				Interval:       10 * time.Microsecond,
+				Echo:           true,
+				WarmupDuration: 100 * time.Millisecond,
+			}
+		}
+
+		reader := newBenchmark()
+		runOverTCP(t, newBenchmark(), reader)
+
+		if reads := reader.Result()["successful_reads"]; reads != uint64(1000) {
+			t.Errorf("expected warmup messages to be excluded, got %v successful reads", reads)
+		}
+	})
+}
+
+// corruptingConn flips a bit in every n-th write.
+type corruptingConn struct {
+	net.Conn
+	n      int
+	writes int
+}
+
+func (c *corruptingConn) Write(b []byte) (int, error) {
+	c.writes++
+	if c.writes%c.n == 0 && len(b) > 0 {
+		corrupted := append([]byte(nil), b...)
+		corrupted[len(corrupted)-1] ^= 0x01
+		return c.Conn.Write(corrupted)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestPressuredBenchmarkVerify(t *testing.T) {
+	newBenchmark := func() *PressuredBenchmark {
+		return &PressuredBenchmark{
+			MessageSize:   1024,
+			TotalMessages: 1000,
+			Verify:        true,
+		}
+	}
+	writer, reader := newBenchmark(), newBenchmark()
+
+	senderConn, receiverConn := net.Pipe()
+	defer senderConn.Close()
+	defer receiverConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Sender, corrupting every 100th write after the handshake
+	go func() {
+		defer wg.Done()
+		err := writer.Writer(&corruptingConn{Conn: senderConn, n: 100})
+		if err != nil {
+			t.Logf("Sender errored: %v", err)
+		}
+	}()
+
+	// Receiver
+	go func() {
+		defer wg.Done()
+		err := reader.Reader(receiverConn)
+		if err != nil {
+			t.Logf("Receiver errored: %v", err)
+		}
+	}()
+
+	wg.Wait()
+
+	result := reader.Result()
+	t.Logf("Receiver: %v", result)
+
+	if result["verify_corrupted"] != uint64(10) {
+		t.Errorf("expected 10 corrupted messages, got %v", result["verify_corrupted"])
+	}
+	if result["verify_valid"] != uint64(990) {
+		t.Errorf("expected 990 valid messages, got %v", result["verify_valid"])
+	}
+	if result["verify_out_of_order"] != uint64(0) {
+		t.Errorf("expected no out of order messages, got %v", result["verify_out_of_order"])
+	}
+	if errs, _ := result["message_errors"].(map[string]uint64); errs["corrupted"] != 10 {
+		t.Errorf("expected 10 corrupted messages among the message errors, got %v", result["message_errors"])
+	}
+	if intervals, _ := result["message_error_intervals"].([]map[string]any); len(intervals) == 0 {
+		t.Errorf("expected the message errors to be counted per interval, got %v", result["message_error_intervals"])
+	}
+	if digest := writer.Result()["verify_digest"]; digest == nil || digest == result["verify_digest"] {
+		t.Errorf("expected the digests of both sides to differ, got %v and %v", digest, result["verify_digest"])
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	for _, bench := range []struct {
+		name           string
+		writer, reader Benchmark
+	}{
+		{"Pressure", &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, Verify: true}, &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, Verify: true}},
+		{"Echo", &IntervalBenchmark{MessageSize: 64, TotalMessages: 50, Interval: time.Millisecond, Echo: true, Verify: true}, &IntervalBenchmark{MessageSize: 64, TotalMessages: 50, Interval: time.Millisecond, Echo: true, Verify: true}},
+	} {
+		t.Run(bench.name, func(t *testing.T) {
+			senderConn, receiverConn := net.Pipe()
+			defer senderConn.Close()
+			defer receiverConn.Close()
+
+			errs := make(chan error, 1)
+			go func() { errs <- bench.reader.Reader(receiverConn) }()
+			if err := bench.writer.Writer(senderConn); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+
+			digest, ok := bench.writer.Result()["verify_digest"].(string)
+			if !ok || len(digest) != 16 || digest != bench.reader.Result()["verify_digest"] {
+				t.Errorf("expected the digests of both sides to match, got %v and %v", digest, bench.reader.Result()["verify_digest"])
+			}
+		})
+	}
+}
+
+func TestPressuredBenchmarkMaxMessageErrors(t *testing.T) {
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, Verify: true}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, Verify: true, MaxMessageErrors: 5}
+
+	senderConn, receiverConn := net.Pipe()
+	defer senderConn.Close()
+
+	go func() {
+		writer.Writer(&corruptingConn{Conn: senderConn, n: 10})
+	}()
+
+	err := reader.Reader(receiverConn)
+	receiverConn.Close() // unblock the sender
+	if err == nil || !strings.Contains(err.Error(), "more than the 5 tolerated") {
+		t.Fatalf("expected the reader to fail once more than 5 messages failed, got %v", err)
+	}
+	if errs, _ := reader.Result()["message_errors"].(map[string]uint64); errs["corrupted"] != 6 {
+		t.Errorf("expected the reader to stop at the 6th corrupted message, got %v", reader.Result()["message_errors"])
+	}
+}
+
+func TestBenchmarkTargetDuration(t *testing.T) {
+	t.Run("Pressured", func(t *testing.T) {
+		newBenchmark := func() *PressuredBenchmark {
+			return &PressuredBenchmark{
+				MessageSize:    1024,
+				TotalMessages:  1, // ignored with TargetDuration
+				TargetDuration: 200 * time.Millisecond,
+				Verify:         true,
+			}
+		}
+
+		writer, reader := newBenchmark(), newBenchmark()
+		runOverTCP(t, writer, reader)
+
+		writes := writer.Result()["successful_writes"]
+		result := reader.Result()
+		if writes.(uint64) <= 1 || result["successful_reads"] != writes {
+			t.Errorf("expected all %v messages to be read, got %v", writes, result["successful_reads"])
+		}
+		if result["verify_missing"] != uint64(0) {
+			t.Errorf("expected no missing messages, got %v", result["verify_missing"])
+		}
+	})
+
+	t.Run("Interval", func(t *testing.T) {
+		newBenchmark := func() *IntervalBenchmark {
+			return &IntervalBenchmark{
+				MessageSize:    1024,
+				Interval:       time.Millisecond,
+				TargetDuration: 200 * time.Millisecond,
+			}
+		}
+
+		writer, reader := newBenchmark(), newBenchmark()
+		runOverTCP(t, writer, reader)
+
+		writes := writer.Result()["successful_writes"].(uint64)
+		if writes < 50 || writes > 200 || reader.Result()["successful_reads"] != writes {
+			t.Errorf("expected about 200 messages all read, got %d written and %v read", writes, reader.Result()["successful_reads"])
+		}
+	})
+}
@@ -0,0 +1,158 @@
+// Package benchmarkconntest wires the benchmarks of benchmarkconn into
+// go test -bench, so that authors of net.Conn implementations can track
+// their throughput and latency next to their other benchmarks, e.g.:
+//
+//	func BenchmarkConn(b *testing.B) {
+//		benchmarkconntest.RunPressured(b, func() (net.Conn, net.Conn) {
+//			return newConnPair(b)
+//		})
+//	}
+//
+// Each helper runs the benchmark over b.N messages and reports its own
+// measurement as ns/op, excluding the handshake and the setup of the
+// connections, along with metrics of its own, e.g., MB/s.
+package benchmarkconntest
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// DefaultMessageSize is the size of the messages sent by RunPressured.
+const DefaultMessageSize = 1024
+
+// Run runs the benchmarks created by newBenchmark for b.N messages, the
+// writer over the first connection returned by dial and the reader over
+// the second, and returns their results. The timer of b runs until the
+// reader completes, since the writer of an echo benchmark waits a second
+// for late echoes. Both connections are closed once the benchmark
+// completes. It fails b if either side fails.
+func Run(b *testing.B, newBenchmark func(n int) benchmarkconn.Benchmark, dial func() (net.Conn, net.Conn)) (writerResult, readerResult map[string]any) {
+	b.Helper()
+
+	writerConn, readerConn := dial()
+	defer writerConn.Close()
+	defer readerConn.Close()
+	writer, reader := newBenchmark(b.N), newBenchmark(b.N)
+
+	b.ResetTimer()
+	var readerErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readerErr = reader.Reader(readerConn)
+		b.StopTimer() // the writer does not touch b until the reader is done
+	}()
+	writerErr := writer.Writer(writerConn)
+	wg.Wait()
+
+	if writerErr != nil {
+		b.Fatalf("writer failed: %v", writerErr)
+	}
+	if readerErr != nil {
+		b.Fatalf("reader failed: %v", readerErr)
+	}
+	return writer.Result(), reader.Result()
+}
+
+// RunPressured runs a PressuredBenchmark of b.N messages of
+// DefaultMessageSize bytes over the connections returned by dial, see
+// RunPressuredSize.
+func RunPressured(b *testing.B, dial func() (net.Conn, net.Conn)) {
+	b.Helper()
+	RunPressuredSize(b, DefaultMessageSize, dial)
+}
+
+// RunPressuredSize runs a PressuredBenchmark of b.N messages of size bytes
+// over the connections returned by dial, the first written to and the
+// second read from. It reports the time the reader took per message as
+// ns/op, the bytes per message and the resulting MB/s, and the throughput
+// in Mbps like the command line tools.
+func RunPressuredSize(b *testing.B, size int, dial func() (net.Conn, net.Conn)) {
+	b.Helper()
+	b.SetBytes(int64(size))
+
+	_, result := Run(b, func(n int) benchmarkconn.Benchmark {
+		return &benchmarkconn.PressuredBenchmark{MessageSize: size, TotalMessages: uint64(n)}
+	}, dial)
+
+	if duration, ok := resultDuration(result); ok {
+		b.ReportMetric(float64(duration.Nanoseconds())/float64(b.N), "ns/op")
+		b.ReportMetric(float64(size)*float64(b.N)/duration.Seconds()/1e6, "MB/s")
+	}
+	if throughput, ok := result["throughput_Mbps"].(float64); ok {
+		b.ReportMetric(throughput, "Mbps")
+	}
+}
+
+// RunEcho runs an echo IntervalBenchmark of b.N messages of
+// DefaultMessageSize bytes, one every interval, over the connections
+// returned by dial, the first sending and the second echoing. Since the
+// messages are paced, it reports the mean round-trip latency as ns/op
+// rather than the time per message, along with the p50-ns and p99-ns
+// percentiles.
+func RunEcho(b *testing.B, interval time.Duration, dial func() (net.Conn, net.Conn)) {
+	b.Helper()
+
+	result, _ := Run(b, func(n int) benchmarkconn.Benchmark {
+		return &benchmarkconn.IntervalBenchmark{MessageSize: DefaultMessageSize, TotalMessages: uint64(n), Interval: interval, Echo: true}
+	}, dial)
+
+	if latency, ok := result["latency_ns"].(float64); ok {
+		b.ReportMetric(latency, "ns/op")
+	}
+	for _, name := range []string{"p50", "p99"} {
+		if latency, ok := result["latency_"+name+"_ns"].(int64); ok {
+			b.ReportMetric(float64(latency), name+"-ns")
+		}
+	}
+}
+
+// TCPPair returns both ends of a TCP connection over the loopback
+// interface, for a baseline to compare other connections against, e.g.,
+// by returning it from dial.
+func TCPPair(tb testing.TB) (net.Conn, net.Conn) {
+	tb.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- c
+	}()
+
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c := <-accepted
+	if c == nil {
+		dialed.Close()
+		tb.Fatal("failed to accept the loopback connection")
+	}
+	return dialed, c
+}
+
+// resultDuration returns the duration of the measurement in result.
+func resultDuration(result map[string]any) (time.Duration, bool) {
+	s, ok := result["duration"].(string)
+	if !ok {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(s)
+	return duration, err == nil && duration > 0
+}
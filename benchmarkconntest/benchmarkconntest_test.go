@@ -0,0 +1,57 @@
+package benchmarkconntest_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn/benchmarkconntest"
+)
+
+func TestRunPressured(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dial func(b *testing.B) (net.Conn, net.Conn)
+	}{
+		{"pipe", func(*testing.B) (net.Conn, net.Conn) { return net.Pipe() }},
+		{"tcp", func(b *testing.B) (net.Conn, net.Conn) { return TCPPair(b) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := testing.Benchmark(func(b *testing.B) {
+				RunPressured(b, func() (net.Conn, net.Conn) { return tc.dial(b) })
+			})
+			t.Logf("%s", result)
+
+			if result.N == 0 {
+				t.Fatal("expected the benchmark to run")
+			}
+			if result.Bytes != DefaultMessageSize {
+				t.Errorf("expected %d bytes per op, got %d", DefaultMessageSize, result.Bytes)
+			}
+			for _, unit := range []string{"ns/op", "MB/s", "Mbps"} {
+				if result.Extra[unit] <= 0 {
+					t.Errorf("expected %s to be reported, got %v", unit, result.Extra)
+				}
+			}
+		})
+	}
+}
+
+func TestRunEcho(t *testing.T) {
+	result := testing.Benchmark(func(b *testing.B) {
+		RunEcho(b, 100*time.Microsecond, func() (net.Conn, net.Conn) { return TCPPair(b) })
+	})
+	t.Logf("%s", result)
+
+	if result.N == 0 {
+		t.Fatal("expected the benchmark to run")
+	}
+	for _, unit := range []string{"ns/op", "p50-ns", "p99-ns"} {
+		if result.Extra[unit] <= 0 {
+			t.Errorf("expected %s to be reported, got %v", unit, result.Extra)
+		}
+	}
+	if result.Extra["p99-ns"] < result.Extra["p50-ns"] {
+		t.Errorf("expected p99 of at least p50, got %v", result.Extra)
+	}
+}
@@ -0,0 +1,236 @@
+package benchmarkconn
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	crand "crypto/rand"
+)
+
+// BidirectionalBenchmark is a benchmark where both peers write and read
+// simultaneously as fast as possible, similar to iperf3 --bidir, and
+// measures the throughput of each direction. Many transports perform
+// asymmetrically under full-duplex load, which one-way benchmarks do not
+// reveal.
+//
+// Writer and Reader run the very same benchmark, one of them must be run
+// on each side.
+type BidirectionalBenchmark struct {
+	MessageSize   int    `json:"message_size" yaml:"message_size"`     // MessageSize defines how many bytes to write for each send attempt
+	TotalMessages uint64 `json:"total_messages" yaml:"total_messages"` // TotalMessages defines how many messages to send in each direction
+
+	Payload PayloadGenerator `json:"-" yaml:"-"`       // Payload generates the content of each message, random if nil
+	Profile Profile          `json:"-" yaml:"profile"` // Profile selects the local resource footprint, it does not need to match the peer
+	Control *ControlChannel  `json:"-" yaml:"-"`       // Control carries the handshake instead of the data connection if set, it must be set on both sides
+
+	OnProgress       func(ProgressSnapshot) `json:"-" yaml:"-"` // OnProgress is called periodically while the benchmark runs if set, and once more when it stops
+	ProgressInterval time.Duration          `json:"-" yaml:"-"` // ProgressInterval defines how often OnProgress is called, DefaultProgressInterval if not set
+
+	messageSize      int            // an internal copy of the message size used in the last run
+	socketOptions    map[string]any // the effective socket options at the start of the last run
+	successfulReads  atomic.Uint64
+	successfulWrites atomic.Uint64
+	startTime        atomic.Value
+	endTime          atomic.Value
+	writeEndTime     atomic.Value // when the last message was written
+	readEndTime      atomic.Value // when the last message was read
+
+	schedLatency    schedLatencyRecorder
+	decorators      decoratorRecorder
+	versions        versionRecorder
+	allocs          allocRecorder
+	coalescing      coalescingRecorder
+	combinedCounter *CombinedCounter
+}
+
+// Writer runs the benchmark on the side acting as the writer in the
+// handshake.
+func (b *BidirectionalBenchmark) Writer(conn net.Conn, counters ...Counter) error {
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+	return b.run(conn, counters)
+}
+
+// Reader runs the benchmark on the side acting as the reader in the
+// handshake.
+func (b *BidirectionalBenchmark) Reader(conn net.Conn, counters ...Counter) error {
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+	return b.run(conn, counters)
+}
+
+func (b *BidirectionalBenchmark) run(conn net.Conn, counters []Counter) (err error) {
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.messageSize = b.MessageSize
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
+	b.successfulReads.Store(0)
+	b.successfulWrites.Store(0)
+	b.writeEndTime.Store(time.Time{})
+	b.readEndTime.Store(time.Time{})
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.coalescing.startRecording(conn)
+	defer b.coalescing.stopRecording()
+	b.startTime.Store(time.Now())
+	defer func() {
+		b.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	// Report the progress
+	defer startProgress(b.Control.reportProgress(b.OnProgress), b.ProgressInterval, b.messageSize, 2*b.TotalMessages, b.startTime.Load().(time.Time), &b.successfulReads, &b.successfulWrites, nil, nil)()
+
+	var firstErr error
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			// unblock the other direction, closing the conn if it does
+			// not support deadlines since the run failed anyway
+			if conn.SetDeadline(time.Now()) != nil {
+				conn.Close()
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := b.write(conn); err != nil {
+			fail(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := b.read(conn); err != nil {
+			fail(err)
+		}
+	}()
+	wg.Wait()
+
+	return firstErr
+}
+
+func (b *BidirectionalBenchmark) write(conn net.Conn) error {
+	pooledBuf := messageBuffers.get(b.messageSize)
+	defer messageBuffers.put(pooledBuf)
+	var randMsg = *pooledBuf
+	var reuseMsg = b.Profile == ProfileConstrained && b.Payload == nil
+	if reuseMsg {
+		crand.Read(randMsg) // fill once and reuse
+	}
+	for i := uint64(0); i < b.TotalMessages; i++ {
+		if !reuseMsg {
+			payloadGenerator(b.Payload).Fill(randMsg)
+		}
+		if _, err := conn.Write(randMsg); err != nil {
+			return err
+		}
+		b.successfulWrites.Add(1)
+	}
+	b.writeEndTime.Store(time.Now())
+	return nil
+}
+
+func (b *BidirectionalBenchmark) read(conn net.Conn) error {
+	pooledBuf := messageBuffers.get(b.messageSize)
+	defer messageBuffers.put(pooledBuf)
+	var receivedMsg = *pooledBuf
+	for b.successfulReads.Load() < b.TotalMessages {
+		if _, err := io.ReadFull(conn, receivedMsg); err != nil { // read full length of the message
+			return err
+		}
+		b.successfulReads.Add(1)
+	}
+	b.readEndTime.Store(time.Now())
+	return nil
+}
+
+// Result returns the throughput of each direction, as seen from this
+// side, as write_throughput_<bps|Mbps> and read_throughput_<bps|Mbps>,
+// and their sum over the whole run as throughput_<bps|Mbps>.
+func (b *BidirectionalBenchmark) Result() map[string]any {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
+		return map[string]any{}
+	}
+
+	startTime := b.startTime.Load().(time.Time)
+	endTime := b.endTime.Load().(time.Time)
+	reads, writes := b.successfulReads.Load(), b.successfulWrites.Load()
+
+	result := map[string]any{
+		"successful_reads":  reads,
+		"successful_writes": writes,
+		"bytes_read":        reads * uint64(b.messageSize),
+		"bytes_written":     writes * uint64(b.messageSize),
+		"start_time":        startTime.Format(time.RFC3339),
+		"end_time":          endTime.Format(time.RFC3339),
+		"duration":          endTime.Sub(startTime).String(),
+	}
+	if t := b.writeEndTime.Load().(time.Time); !t.IsZero() {
+		addBitrate(result, "write_", writes*uint64(b.messageSize), t.Sub(startTime).Nanoseconds(), b.Profile)
+	}
+	if t := b.readEndTime.Load().(time.Time); !t.IsZero() {
+		addBitrate(result, "read_", reads*uint64(b.messageSize), t.Sub(startTime).Nanoseconds(), b.Profile)
+	}
+	addBitrate(result, "", (reads+writes)*uint64(b.messageSize), endTime.Sub(startTime).Nanoseconds(), b.Profile)
+
+	if ops := reads + writes; ops > 0 {
+		durationNs := endTime.Sub(startTime).Nanoseconds()
+		if b.Profile == ProfileConstrained { // integer-only stats
+			result["ops_per_s"] = ops * 1e9 / uint64(durationNs)
+		} else {
+			result["ops_per_s"] = float64(ops) / float64(durationNs) * 1e9
+		}
+	}
+
+	b.schedLatency.addResult(result)
+	b.allocs.addResult(result, reads+writes, b.Profile)
+	b.coalescing.addResult(result, reads*uint64(b.messageSize), writes*uint64(b.messageSize), reads, writes, b.Profile)
+
+	if b.socketOptions != nil {
+		result["socket_options"] = b.socketOptions
+	}
+	b.decorators.addResult(result, b.Profile)
+	b.versions.addResult(result)
+
+	b.Control.addAbortResult(result)
+
+	if b.combinedCounter != nil {
+		result["counters"] = b.combinedCounter.Results()
+	}
+
+	return result
+}
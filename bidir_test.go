@@ -0,0 +1,24 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestBidirectionalBenchmark(t *testing.T) {
+	writer := &BidirectionalBenchmark{MessageSize: 1024, TotalMessages: 10000}
+	reader := &BidirectionalBenchmark{MessageSize: 1024, TotalMessages: 10000}
+	runOverTCP(t, writer, reader)
+
+	for side, result := range map[string]map[string]any{"writer": writer.Result(), "reader": reader.Result()} {
+		if result["successful_writes"] != uint64(10000) || result["successful_reads"] != uint64(10000) {
+			t.Errorf("%s: expected 10000 messages in each direction, got %v written and %v read", side, result["successful_writes"], result["successful_reads"])
+		}
+		for _, key := range []string{"write_throughput_Mbps", "read_throughput_Mbps", "throughput_Mbps"} {
+			if v, ok := result[key].(float64); !ok || v <= 0 {
+				t.Errorf("%s: expected a positive %s, got %v", side, key, result[key])
+			}
+		}
+	}
+}
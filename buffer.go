@@ -0,0 +1,35 @@
+package benchmarkconn
+
+import "sync"
+
+// bufferPool pools message buffers by size, so that benchmarks run back
+// to back or in parallel, e.g., by PhasedBenchmark or ParallelBenchmark,
+// reuse the buffers of previous runs rather than allocating their own,
+// which would be counted by the allocation recorder.
+type bufferPool struct {
+	pools sync.Map // size -> *sync.Pool of *[]byte
+}
+
+// messageBuffers is the pool of the message buffers of all benchmarks.
+var messageBuffers bufferPool
+
+// get returns a buffer of size bytes, with arbitrary content.
+func (p *bufferPool) get(size int) *[]byte {
+	pool, ok := p.pools.Load(size)
+	if !ok {
+		pool, _ = p.pools.LoadOrStore(size, &sync.Pool{
+			New: func() any {
+				buf := make([]byte, size)
+				return &buf
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+// put returns buf to the pool once no longer used.
+func (p *bufferPool) put(buf *[]byte) {
+	if pool, ok := p.pools.Load(len(*buf)); ok {
+		pool.(*sync.Pool).Put(buf)
+	}
+}
@@ -0,0 +1,71 @@
+// Package capi exports the benchmark core over a C ABI, so that systems
+// written in C, Rust or Python, via ctypes or cffi, can run the very same
+// benchmarks on sockets they own and report numbers comparable with those
+// of the Go tools.
+//
+// Build the shared library and its header, libbenchmarkconn.h, with:
+//
+//	go build -buildmode=c-shared -o libbenchmarkconn.so ./capi
+//
+// The library exports:
+//
+//	char *benchmarkconn_run(int fd, char *config);
+//	char *benchmarkconn_version(void);
+//	void benchmarkconn_free(char *s);
+//
+// benchmarkconn_run runs one side of a benchmark over the connected socket
+// fd and blocks until it completes. The socket is duplicated, so it stays
+// open and owned by the caller, in the blocking mode it was passed in. The config is a JSON object:
+//
+//	{
+//	  "type": "pressure",
+//	  "write": true,
+//	  "profile": "default",
+//	  "digits": 6,
+//	  "spec": {"message_size": 1024, "total_messages": 1000}
+//	}
+//
+// where type is one of pressure, echo, interval, bidir, ramp and credit,
+// write selects the writer side, profile and digits are optional, and spec
+// holds the fields of the benchmark as exchanged in the handshake, e.g.,
+// durations in nanoseconds, so the peer may as well be cmd/server. It
+// returns the JSON-encoded result, or an object holding only "error" if
+// the run failed. Strings returned must be released with
+// benchmarkconn_free.
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"unsafe"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+//export benchmarkconn_run
+func benchmarkconn_run(fd C.int, config *C.char) *C.char {
+	result, err := run(int(fd), C.GoString(config))
+	if err != nil {
+		result = map[string]any{"error": err.Error()}
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		resultJSON, _ = json.Marshal(map[string]any{"error": err.Error()})
+	}
+	return C.CString(string(resultJSON))
+}
+
+//export benchmarkconn_version
+func benchmarkconn_version() *C.char {
+	return C.CString(benchmarkconn.Version())
+}
+
+//export benchmarkconn_free
+func benchmarkconn_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
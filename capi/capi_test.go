@@ -0,0 +1,92 @@
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRunSocketPair(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	const spec = `"spec":{"message_size":1024,"total_messages":100}`
+	writerErr := make(chan error, 1)
+	go func() {
+		_, err := run(fds[0], `{"type":"pressure","write":true,`+spec+`}`)
+		writerErr <- err
+	}()
+	result, err := run(fds[1], `{"type":"pressure",`+spec+`}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-writerErr; err != nil {
+		t.Fatal(err)
+	}
+	if reads := result["successful_reads"]; reads != uint64(100) {
+		t.Errorf("expected 100 messages read, got %v", reads)
+	}
+
+	// the sockets are left open and blocking, as passed in
+	for _, fd := range fds {
+		flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags&unix.O_NONBLOCK != 0 {
+			t.Errorf("expected fd %d to be left blocking", fd)
+		}
+	}
+	if _, err := syscall.Write(fds[0], []byte{1}); err != nil {
+		t.Fatalf("expected the socket to be left open: %v", err)
+	}
+	buf := make([]byte, 1)
+	if n, err := syscall.Read(fds[1], buf); err != nil || n != 1 {
+		t.Fatalf("expected a blocking read of the byte written, got %d, %v", n, err)
+	}
+}
+
+func TestRunNonBlocking(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	for _, fd := range fds {
+		if err := unix.SetNonblock(fd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const spec = `"spec":{"message_size":64,"total_messages":10}`
+	writerErr := make(chan error, 1)
+	go func() {
+		_, err := run(fds[0], `{"type":"pressure","write":true,`+spec+`}`)
+		writerErr <- err
+	}()
+	if _, err := run(fds[1], `{"type":"pressure",`+spec+`}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-writerErr; err != nil {
+		t.Fatal(err)
+	}
+
+	// a socket passed in non-blocking stays so
+	for _, fd := range fds {
+		flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags&unix.O_NONBLOCK == 0 {
+			t.Errorf("expected fd %d to be left non-blocking", fd)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func fileConn(fd int) (net.Conn, func(), error) {
+	return nil, nil, errors.New("running on an fd is only supported on Unix")
+}
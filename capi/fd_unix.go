@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileConn returns a net.Conn over a duplicate of the socket fd, so that
+// closing it leaves fd open for the caller, and a function to call once
+// the connection is closed, restoring the blocking mode of fd. net.FileConn
+// makes its socket non-blocking, and the flag is shared by every duplicate
+// of fd.
+func fileConn(fd int) (net.Conn, func(), error) {
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the flags of fd %d: %w", fd, err)
+	}
+
+	// the os.File owns the fd it wraps, closing it once collected, so it
+	// must wrap a duplicate rather than fd itself. net.FileConn duplicates
+	// it once more, so the file is closed right away
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to duplicate fd %d: %w", fd, err)
+	}
+	f := os.NewFile(uintptr(dup), "benchmarkconn")
+	defer f.Close()
+
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	restore := func() {
+		unix.SetNonblock(fd, flags&unix.O_NONBLOCK != 0)
+	}
+	return conn, restore, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// runConfig is the config passed to benchmarkconn_run.
+type runConfig struct {
+	Type    string          `json:"type"`
+	Write   bool            `json:"write"`
+	Profile string          `json:"profile"`
+	Digits  *int            `json:"digits"` // DefaultResultDigits if not set
+	Spec    json.RawMessage `json:"spec"`
+}
+
+func run(fd int, configJSON string) (map[string]any, error) {
+	var config runConfig
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	bench, err := newBenchmark(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, restore, err := fileConn(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer restore() // once closed
+	defer conn.Close()
+
+	if config.Write {
+		err = bench.Writer(conn)
+	} else {
+		err = bench.Reader(conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digits := benchmarkconn.DefaultResultDigits
+	if config.Digits != nil {
+		digits = *config.Digits
+	}
+	result := bench.Result()
+	benchmarkconn.RoundResult(result, digits)
+	return result, nil
+}
+
+// newBenchmark creates the benchmark described by config, with the fields
+// of its spec decoded like those received in a handshake.
+func newBenchmark(config *runConfig) (benchmarkconn.Benchmark, error) {
+	profile, err := benchmarkconn.ParseProfile(config.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var bench benchmarkconn.Benchmark
+	switch config.Type {
+	case "pressure":
+		bench = &benchmarkconn.PressuredBenchmark{Profile: profile}
+	case "echo":
+		bench = &benchmarkconn.IntervalBenchmark{Echo: true, Profile: profile}
+	case "interval":
+		bench = &benchmarkconn.IntervalBenchmark{Profile: profile}
+	case "bidir":
+		bench = &benchmarkconn.BidirectionalBenchmark{Profile: profile}
+	case "ramp":
+		bench = &benchmarkconn.RampBenchmark{}
+	case "credit":
+		bench = &benchmarkconn.CreditBenchmark{}
+	case "":
+		return nil, errors.New("missing benchmark type")
+	default:
+		return nil, fmt.Errorf("unknown benchmark type %q", config.Type)
+	}
+
+	if len(config.Spec) > 0 {
+		if err := json.Unmarshal(config.Spec, bench); err != nil {
+			return nil, fmt.Errorf("invalid spec: %w", err)
+		}
+	}
+	if echo, ok := bench.(*benchmarkconn.IntervalBenchmark); ok && config.Type == "echo" {
+		echo.Echo = true // the type rather than the spec decides
+	}
+	return bench, nil
+}
+
+func main() {}
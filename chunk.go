@@ -0,0 +1,146 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// validateReadBufferSize checks that the reader may read in chunks of size
+// bytes, which requires a stream connection since a datagram read returns
+// one whole datagram.
+func validateReadBufferSize(size int, datagram bool) error {
+	if size < 0 {
+		return errors.New("read buffer size must not be negative")
+	}
+	if size > 0 && datagram {
+		return errors.New("read buffer sizes are not supported over datagram connections")
+	}
+	return nil
+}
+
+// chunkedReader reads from a connection with a fixed buffer size
+// regardless of the size of the messages, as an application reading into
+// its own buffer would, and serves the messages reassembled from the reads.
+// It records how the transport split the messages across reads and
+// coalesced them into a single read, and how long the reader waited for
+// the rest of each message split.
+//
+// Bytes following the last message of a run may be consumed along with it,
+// so the connection must not carry anything else afterwards.
+type chunkedReader struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	buf   []byte
+	start int // buf[start:end] is read but not consumed yet
+	end   int
+
+	reads    uint64    // reads from the connection so far
+	readTime time.Time // when the last read returned
+
+	// the message being reassembled
+	inMessage    bool
+	messageRead  uint64 // the read delivering its first byte
+	messageStart time.Time
+
+	lastRead      uint64 // the read delivering the last byte of the previous message
+	coalescedRead uint64 // the last read counted as coalesced
+
+	bytes      uint64
+	messages   uint64
+	split      uint64 // messages delivered over more than one read
+	coalesced  uint64 // reads delivering bytes of more than one message
+	readSizes  *Histogram
+	reassembly *Histogram
+}
+
+// newChunkedReader returns a reader of conn in reads of at most size bytes,
+// with histograms sized for profile.
+func newChunkedReader(conn net.Conn, size int, profile Profile) *chunkedReader {
+	r := &chunkedReader{conn: conn, buf: make([]byte, size)}
+	if profile == ProfileConstrained {
+		r.readSizes = newConstrainedLatencyHistogram()
+		r.reassembly = newConstrainedLatencyHistogram()
+	} else {
+		r.readSizes = newDefaultLatencyHistogram()
+		r.reassembly = newDefaultLatencyHistogram()
+	}
+	return r
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.start == r.end {
+		n, err := r.conn.Read(r.buf)
+		if n == 0 {
+			return 0, err
+		}
+		r.start, r.end = 0, n
+		r.reads++
+		r.readTime = time.Now()
+		r.bytes += uint64(n)
+		r.readSizes.Record(int64(n))
+	}
+
+	if !r.inMessage {
+		r.inMessage = true
+		r.messageRead = r.reads
+		r.messageStart = r.readTime
+		if r.messages > 0 && r.lastRead == r.reads && r.coalescedRead != r.reads {
+			r.coalescedRead = r.reads
+			r.coalesced++
+		}
+	}
+
+	n := copy(p, r.buf[r.start:r.end])
+	r.start += n
+	return n, nil
+}
+
+// messageDone marks the end of the message being reassembled, once it was
+// read in full.
+func (r *chunkedReader) messageDone() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.inMessage {
+		return
+	}
+	r.inMessage = false
+	r.messages++
+	r.lastRead = r.reads
+	if r.reads > r.messageRead {
+		r.split++
+		r.reassembly.Record(r.readTime.Sub(r.messageStart).Nanoseconds())
+	}
+}
+
+// addResult adds the read buffer size to result as read_buffer_size, the
+// reads from the connection as chunk_reads and their sizes as
+// chunk_read_bytes_<stat>, the messages split across reads as
+// chunk_split_messages and the reads coalescing several messages as
+// chunk_coalesced_reads. The time from the first to the last read of each
+// message split is added as chunk_reassembly_<stat>_ns, and the reads per
+// message as chunk_reads_per_message. With ProfileConstrained, the reads
+// per message are left out.
+func (r *chunkedReader) addResult(result map[string]any, profile Profile) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result["read_buffer_size"] = len(r.buf)
+	result["chunk_reads"] = r.reads
+	result["chunk_split_messages"] = r.split
+	result["chunk_coalesced_reads"] = r.coalesced
+	for name, value := range r.readSizes.Percentiles() {
+		result["chunk_read_bytes_"+name] = value
+	}
+	for name, value := range r.reassembly.Percentiles() {
+		result["chunk_reassembly_"+name+"_ns"] = value
+	}
+	if profile != ProfileConstrained && r.messages > 0 {
+		result["chunk_reads_per_message"] = float64(r.reads) / float64(r.messages)
+	}
+}
@@ -0,0 +1,84 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPressuredBenchmarkReadBufferSize(t *testing.T) {
+	// net.Pipe delivers each write on its own, so every message is split
+	// into reads of at most 100 bytes
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100, Verify: true}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100, Verify: true, ReadBufferSize: 100}
+
+	senderConn, receiverConn := net.Pipe()
+	defer senderConn.Close()
+	defer receiverConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := writer.Writer(senderConn); err != nil {
+			t.Logf("Sender errored: %v", err)
+		}
+	}()
+	if err := reader.Reader(receiverConn); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	result := reader.Result()
+	t.Logf("Receiver: %v", result)
+
+	if result["verify_valid"] != uint64(100) {
+		t.Errorf("expected 100 valid messages, got %v", result["verify_valid"])
+	}
+	if result["chunk_reads"] != uint64(1100) {
+		t.Errorf("expected 1100 reads, got %v", result["chunk_reads"])
+	}
+	if result["chunk_split_messages"] != uint64(100) {
+		t.Errorf("expected 100 split messages, got %v", result["chunk_split_messages"])
+	}
+	if result["chunk_coalesced_reads"] != uint64(0) {
+		t.Errorf("expected no coalesced reads, got %v", result["chunk_coalesced_reads"])
+	}
+	if result["chunk_reads_per_message"] != 11.0 {
+		t.Errorf("expected 11 reads per message, got %v", result["chunk_reads_per_message"])
+	}
+	if result["chunk_read_bytes_max"] != int64(100) {
+		t.Errorf("expected reads of at most 100 bytes, got %v", result["chunk_read_bytes_max"])
+	}
+	if _, ok := result["chunk_reassembly_p50_ns"]; !ok {
+		t.Error("expected the reassembly time to be reported")
+	}
+}
+
+func TestPressuredBenchmarkReadBufferSizeCoalescing(t *testing.T) {
+	// small messages queue up over TCP faster than they are read, so reads
+	// of a large buffer span several of them
+	writer := &PressuredBenchmark{MessageSize: 100, TotalMessages: 10000, Verify: true}
+	reader := &PressuredBenchmark{MessageSize: 100, TotalMessages: 10000, Verify: true, ReadBufferSize: 65536}
+	runOverTCP(t, writer, reader)
+
+	result := reader.Result()
+	if result["verify_valid"] != uint64(10000) {
+		t.Errorf("expected 10000 valid messages, got %v", result["verify_valid"])
+	}
+	if reads := result["chunk_reads"].(uint64); reads == 0 || reads >= 10000 {
+		t.Errorf("expected fewer reads than messages, got %v", reads)
+	}
+	if coalesced := result["chunk_coalesced_reads"].(uint64); coalesced == 0 {
+		t.Error("expected reads coalescing several messages")
+	}
+}
+
+func TestPressuredBenchmarkReadBufferSizeInvalid(t *testing.T) {
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1, ReadBufferSize: -1}
+	if err := reader.Reader(nil); err == nil {
+		t.Error("expected a negative read buffer size to be rejected")
+	}
+}
@@ -0,0 +1,273 @@
+package benchmarkconn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChurnBenchmark writes messages as fast as possible like PressuredBenchmark,
+// but tears down the data connection every ChurnInterval and re-establishes
+// it with Dial, e.g., to test resumable or migrating transports. The reader
+// accepts each new connection with Accept and counts the messages lost
+// during churn, i.e., written successfully but never read in full.
+//
+// Like HandshakeBenchmark, the benchmark runs over a session connection
+// between both sides, which carries the spec handshake and the end of the
+// run. The side with Dial set writes and the other side must have Accept
+// set.
+type ChurnBenchmark struct {
+	MessageSize   int           `json:"message_size" yaml:"message_size"`     // MessageSize defines how many bytes to write for each send attempt
+	TotalMessages uint64        `json:"total_messages" yaml:"total_messages"` // TotalMessages defines how many messages to send in total, over all connections
+	ChurnInterval time.Duration `json:"churn_interval" yaml:"churn_interval"` // ChurnInterval defines how long each connection is written to before it is torn down and re-established
+
+	Dial   func() (net.Conn, error) `json:"-" yaml:"-"` // Dial establishes a new data connection to the peer
+	Accept func() (net.Conn, error) `json:"-" yaml:"-"` // Accept accepts the next data connection from the peer. It may be left blocked once the run ends, until the listener is closed
+
+	sentMessages     atomic.Uint64 // used for writer
+	receivedMessages atomic.Uint64 // used for reader
+	expectedMessages uint64        // used for reader, the number of messages the writer sent
+	connections      atomic.Uint64
+	startTime        atomic.Value
+	endTime          atomic.Value
+
+	reconnectHistogram *Histogram // used for writer, time from tearing down a connection until the next one is ready
+
+	versions        versionRecorder
+	combinedCounter *CombinedCounter
+}
+
+func (b *ChurnBenchmark) Writer(conn net.Conn, counters ...Counter) error {
+	if b.Dial == nil {
+		return errors.New("churn benchmark writer requires Dial")
+	}
+
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshake(conn, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	if err := b.start(counters); err != nil {
+		return err
+	}
+	defer b.stop()
+
+	randMsg := make([]byte, b.MessageSize)
+	if _, err := rand.Read(randMsg); err != nil {
+		return err
+	}
+
+	dataConn, err := b.dialReady()
+	if err != nil {
+		return err
+	}
+	established := time.Now()
+
+	for i := uint64(0); i < b.TotalMessages; i++ {
+		if time.Since(established) >= b.ChurnInterval {
+			teardown := time.Now()
+			dataConn.Close()
+			if dataConn, err = b.dialReady(); err != nil {
+				return err
+			}
+			established = time.Now()
+			b.reconnectHistogram.Record(established.Sub(teardown).Nanoseconds())
+		}
+
+		n, err := dataConn.Write(randMsg)
+		if err != nil {
+			dataConn.Close()
+			return err
+		}
+		if n != len(randMsg) {
+			dataConn.Close()
+			return errors.New("failed to write the whole message to the connection")
+		}
+		b.sentMessages.Add(1)
+	}
+	dataConn.Close()
+
+	var end [8]byte
+	binary.BigEndian.PutUint64(end[:], b.sentMessages.Load())
+	_, err = conn.Write(end[:])
+	return err
+}
+
+// dialReady dials a data connection and waits for a byte to be echoed over
+// it, since some transports complete their handshake lazily.
+func (b *ChurnBenchmark) dialReady() (net.Conn, error) {
+	c, err := b.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	var probe = [1]byte{1}
+	if _, err := c.Write(probe[:]); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if _, err := io.ReadFull(c, probe[:]); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	b.connections.Add(1)
+	return c, nil
+}
+
+func (b *ChurnBenchmark) Reader(conn net.Conn, counters ...Counter) error {
+	if b.Accept == nil {
+		return errors.New("churn benchmark reader requires Accept")
+	}
+
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshake(conn, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	if err := b.start(counters); err != nil {
+		return err
+	}
+	defer b.stop()
+
+	// every connection is counted before its probe is echoed, so all of
+	// them are being read by the time the writer reports the end of the run
+	var readers sync.WaitGroup
+	go func() {
+		for {
+			c, err := b.Accept()
+			if err != nil {
+				return
+			}
+			b.connections.Add(1)
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				defer c.Close()
+				b.readAll(c)
+			}()
+		}
+	}()
+
+	var end [8]byte
+	if _, err := io.ReadFull(conn, end[:]); err != nil {
+		return err
+	}
+	b.expectedMessages = binary.BigEndian.Uint64(end[:])
+
+	// the writer closed all data connections before reporting the end
+	readers.Wait()
+	return nil
+}
+
+// readAll echoes the probe of a data connection, then reads messages until
+// the writer tears it down.
+func (b *ChurnBenchmark) readAll(c net.Conn) {
+	var probe [1]byte
+	if _, err := io.ReadFull(c, probe[:]); err != nil {
+		return
+	}
+	if _, err := c.Write(probe[:]); err != nil {
+		return
+	}
+
+	buf := make([]byte, b.MessageSize)
+	for {
+		// a message torn apart by the churn is lost
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		b.receivedMessages.Add(1)
+	}
+}
+
+func (b *ChurnBenchmark) start(counters []Counter) error {
+	if b.MessageSize <= 0 {
+		return errors.New("message size must be positive")
+	}
+	if b.ChurnInterval <= 0 {
+		return errors.New("churn interval must be positive")
+	}
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.sentMessages.Store(0)
+	b.receivedMessages.Store(0)
+	b.connections.Store(0)
+	b.reconnectHistogram = newDefaultLatencyHistogram()
+	b.startTime.Store(time.Now())
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+	}
+	return nil
+}
+
+func (b *ChurnBenchmark) stop() {
+	b.endTime.Store(time.Now())
+	if b.combinedCounter != nil {
+		b.combinedCounter.Stop()
+	}
+}
+
+// Result returns the number of data connections used and reconnections
+// made. The writer also returns the number of messages sent and the latency
+// distribution of reconnecting as reconnect_latency_<stat>_ns, from tearing
+// down a connection until the next one echoed its first byte. The reader
+// also returns the number of messages received and lost.
+func (b *ChurnBenchmark) Result() map[string]any {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
+		return map[string]any{}
+	}
+
+	startTime := b.startTime.Load().(time.Time)
+	endTime := b.endTime.Load().(time.Time)
+	connections := b.connections.Load()
+	result := map[string]any{
+		"start_time":    startTime.Format(time.RFC3339),
+		"end_time":      endTime.Format(time.RFC3339),
+		"duration":      endTime.Sub(startTime).String(),
+		"connections":   connections,
+		"reconnections": uint64(0),
+	}
+	if connections > 0 {
+		result["reconnections"] = connections - 1
+	}
+
+	if b.Dial != nil { // Writer only
+		result["messages_sent"] = b.sentMessages.Load()
+		if b.reconnectHistogram.TotalCount() > 0 {
+			result["reconnect_latency_ns"] = b.reconnectHistogram.Mean()
+			for name, value := range b.reconnectHistogram.Percentiles() {
+				result["reconnect_latency_"+name+"_ns"] = value
+			}
+		}
+	} else { // Reader only
+		received := b.receivedMessages.Load()
+		result["messages_received"] = received
+		if b.expectedMessages > received {
+			result["messages_lost"] = b.expectedMessages - received
+		} else {
+			result["messages_lost"] = uint64(0)
+		}
+	}
+
+	b.versions.addResult(result)
+	if b.combinedCounter != nil {
+		result["counters"] = b.combinedCounter.Results()
+	}
+
+	return result
+}
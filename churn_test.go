@@ -0,0 +1,53 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestChurnBenchmark(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	writer := &ChurnBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 20000,
+		ChurnInterval: time.Millisecond,
+		Dial: func() (net.Conn, error) {
+			return net.Dial("tcp", listener.Addr().String())
+		},
+	}
+	reader := &ChurnBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 20000,
+		ChurnInterval: time.Millisecond,
+		Accept:        listener.Accept,
+	}
+	runOverTCP(t, writer, reader)
+
+	writerResult, readerResult := writer.Result(), reader.Result()
+	if sent := writerResult["messages_sent"]; sent != uint64(20000) {
+		t.Errorf("expected 20000 messages sent, got %v", sent)
+	}
+	if reconnections := writerResult["reconnections"]; reconnections != readerResult["reconnections"] {
+		t.Errorf("expected the same reconnections on both sides, got %v and %v", reconnections, readerResult["reconnections"])
+	}
+	if reconnections, _ := writerResult["reconnections"].(uint64); reconnections > 0 {
+		if _, ok := writerResult["reconnect_latency_p99_ns"]; !ok {
+			t.Error("expected the reconnect latency distribution")
+		}
+	}
+	// TCP delivers everything written before a graceful close
+	if lost := readerResult["messages_lost"]; lost != uint64(0) {
+		t.Errorf("expected no messages lost, got %v", lost)
+	}
+	if received := readerResult["messages_received"]; received != uint64(20000) {
+		t.Errorf("expected 20000 messages received, got %v", received)
+	}
+}
@@ -0,0 +1,162 @@
+package benchmarkconn
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ClockSource is a clock latencies are measured with, see
+// IntervalBenchmark.Clock. Kernel timestamps of SO_TIMESTAMPING are not
+// available as a clock source, since net.Conn does not expose the
+// control messages carrying them.
+type ClockSource interface {
+	// Now returns the time on the clock in nanoseconds since an origin
+	// fixed for the lifetime of the clock.
+	Now() int64
+
+	// String names the clock, e.g., "monotonic".
+	String() string
+}
+
+// processStart is the origin of MonotonicClock.
+var processStart = time.Now()
+
+type monotonicClock struct{}
+
+func (monotonicClock) Now() int64     { return time.Since(processStart).Nanoseconds() }
+func (monotonicClock) String() string { return "monotonic" }
+
+type realtimeClock struct{}
+
+func (realtimeClock) Now() int64     { return time.Now().UnixNano() }
+func (realtimeClock) String() string { return "realtime" }
+
+var (
+	// MonotonicClock is the monotonic clock of the Go runtime, immune to
+	// adjustments of the system time, the default for round trips.
+	MonotonicClock ClockSource = monotonicClock{}
+
+	// RealtimeClock is the wall clock of the system, in nanoseconds since
+	// the Unix epoch, the default for one-way latencies across hosts. It
+	// is stepped and slewed by time synchronization, e.g., NTP.
+	RealtimeClock ClockSource = realtimeClock{}
+)
+
+// clockOrDefault returns clock, or fallback if not set.
+func clockOrDefault(clock, fallback ClockSource) ClockSource {
+	if clock == nil {
+		return fallback
+	}
+	return clock
+}
+
+// clockNow returns the time on clock, or the monotonic time since start if
+// not set, as echoes have been timed before clocks were selectable.
+func clockNow(clock ClockSource, start time.Time) func() int64 {
+	if clock == nil {
+		return func() int64 { return time.Since(start).Nanoseconds() }
+	}
+	return clock.Now
+}
+
+// ParseClockSource parses a clock source as used by the command line
+// tools: "monotonic", "realtime", or "phc:<device>" for the PTP hardware
+// clock of a NIC, e.g., phc:/dev/ptp0, see NewPHCClock.
+func ParseClockSource(spec string) (ClockSource, error) {
+	switch {
+	case spec == "monotonic":
+		return MonotonicClock, nil
+	case spec == "realtime":
+		return RealtimeClock, nil
+	case strings.HasPrefix(spec, "phc:"):
+		clock, err := NewPHCClock(strings.TrimPrefix(spec, "phc:"))
+		if err != nil {
+			return nil, err
+		}
+		return clock, nil
+	default:
+		return nil, fmt.Errorf("unknown clock source %q, must be monotonic, realtime or phc:<device>", spec)
+	}
+}
+
+// clockCrossCheck measures the latencies of a run with a reference clock
+// as well as the primary one, and the divergence of both, i.e., how much
+// the latency on the reference clock differs from that on the primary
+// one, as a bound on the error of the latencies reported.
+type clockCrossCheck struct {
+	reference  ClockSource
+	latency    *Histogram // of the latencies on the reference clock
+	total      atomic.Int64
+	divergence *Histogram   // of the absolute divergences
+	sum        atomic.Int64 // of the signed divergences
+	count      atomic.Uint64
+}
+
+// newClockCrossCheck returns a cross-check against reference, nil if not
+// set.
+func newClockCrossCheck(reference ClockSource, settings *HistogramSettings, profile Profile) *clockCrossCheck {
+	if reference == nil {
+		return nil
+	}
+	return &clockCrossCheck{
+		reference:  reference,
+		latency:    newLatencyHistogram(settings, profile),
+		divergence: newLatencyHistogram(settings, profile),
+	}
+}
+
+// record records the latency of a message on the primary clock and on the
+// reference clock, in nanoseconds. Negative latencies on the reference
+// clock, e.g., after a step of the system time, only count as divergence.
+func (c *clockCrossCheck) record(latency, referenceLatency int64) {
+	if c == nil {
+		return
+	}
+	if referenceLatency >= 0 {
+		c.latency.Record(referenceLatency)
+		c.total.Add(referenceLatency)
+	}
+	divergence := referenceLatency - latency
+	c.sum.Add(divergence)
+	c.count.Add(1)
+	if divergence < 0 {
+		divergence = -divergence
+	}
+	c.divergence.Record(divergence)
+}
+
+// addResult adds the reference clock to result as
+// latency_reference_clock, the latency on it as latency_reference_ns and
+// latency_reference_<stat>_ns, and the divergence of both clocks as
+// clock_divergence_ns, the mean of reference minus primary, and
+// clock_divergence_<stat>_ns, the distribution of its absolute value.
+func (c *clockCrossCheck) addResult(result map[string]any, profile Profile) {
+	if c == nil {
+		return
+	}
+	result["latency_reference_clock"] = c.reference.String()
+	count := c.count.Load()
+	if count == 0 {
+		return
+	}
+	if recorded := c.latency.TotalCount(); recorded > 0 {
+		if profile == ProfileConstrained { // integer-only stats
+			result["latency_reference_ns"] = c.total.Load() / recorded
+		} else {
+			result["latency_reference_ns"] = float64(c.total.Load()) / float64(recorded)
+		}
+		for name, value := range c.latency.Percentiles() {
+			result["latency_reference_"+name+"_ns"] = value
+		}
+	}
+	if profile == ProfileConstrained {
+		result["clock_divergence_ns"] = c.sum.Load() / int64(count)
+	} else {
+		result["clock_divergence_ns"] = float64(c.sum.Load()) / float64(count)
+	}
+	for name, value := range c.divergence.Percentiles() {
+		result["clock_divergence_"+name+"_ns"] = value
+	}
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package benchmarkconn
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// PHCClock is the PTP hardware clock of a NIC, e.g., /dev/ptp0, which
+// timestamps packets in hardware and, synchronized with PTP, keeps hosts
+// in step to within nanoseconds. Reading it is a system call, so it adds
+// more overhead to each measurement than the clocks of the Go runtime.
+type PHCClock struct {
+	device  string
+	f       *os.File
+	clockID int32
+}
+
+// NewPHCClock opens the PTP hardware clock at device, e.g., /dev/ptp0,
+// which must be closed once no longer used. It is only supported on
+// Linux.
+func NewPHCClock(device string) (*PHCClock, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return nil, err
+	}
+	// dynamic POSIX clock of a character device, see FD_TO_CLOCKID
+	clock := &PHCClock{device: device, f: f, clockID: int32((^f.Fd())<<3 | 3)}
+	var ts unix.Timespec
+	if err := unix.ClockGettime(clock.clockID, &ts); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a PTP hardware clock: %w", device, err)
+	}
+	return clock, nil
+}
+
+// Now returns the time on the clock, or 0 if it can no longer be read,
+// e.g., once closed.
+func (c *PHCClock) Now() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(c.clockID, &ts); err != nil {
+		return 0
+	}
+	return ts.Nano()
+}
+
+func (c *PHCClock) String() string {
+	return "phc:" + c.device
+}
+
+// Close closes the device of the clock.
+func (c *PHCClock) Close() error {
+	return c.f.Close()
+}
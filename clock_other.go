@@ -0,0 +1,20 @@
+//go:build !linux
+
+package benchmarkconn
+
+import "errors"
+
+// PHCClock is the PTP hardware clock of a NIC, only supported on Linux.
+type PHCClock struct{}
+
+// NewPHCClock is only supported on Linux.
+func NewPHCClock(device string) (*PHCClock, error) {
+	return nil, errors.New("PTP hardware clocks are only supported on Linux")
+}
+
+func (c *PHCClock) Now() int64 { return 0 }
+
+func (c *PHCClock) String() string { return "phc" }
+
+// Close is only supported on Linux.
+func (c *PHCClock) Close() error { return nil }
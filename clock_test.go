@@ -0,0 +1,74 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestParseClockSource(t *testing.T) {
+	for spec, want := range map[string]ClockSource{
+		"monotonic": MonotonicClock,
+		"realtime":  RealtimeClock,
+	} {
+		clock, err := ParseClockSource(spec)
+		if err != nil || clock != want || clock.String() != spec {
+			t.Errorf("%s: expected the clock, got %v, %v", spec, clock, err)
+		}
+	}
+	for _, spec := range []string{"", "tai", "phc:/nonexistent/ptp0"} {
+		if clock, err := ParseClockSource(spec); err == nil || clock != nil {
+			t.Errorf("%q: expected an error, got %v", spec, clock)
+		}
+	}
+}
+
+// slowClock runs at half the speed of the monotonic clock.
+type slowClock struct{}
+
+func (slowClock) Now() int64     { return MonotonicClock.Now() / 2 }
+func (slowClock) String() string { return "slow" }
+
+func TestIntervalBenchmarkReferenceClock(t *testing.T) {
+	writer := &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: time.Millisecond, Echo: true, Clock: RealtimeClock, ReferenceClock: slowClock{}}
+	reader := &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: time.Millisecond, Echo: true}
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- reader.Reader(readerConn) }()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	result := writer.Result()
+	if result["latency_clock"] != "realtime" || result["latency_reference_clock"] != "slow" {
+		t.Errorf("expected the clocks, got %v and %v", result["latency_clock"], result["latency_reference_clock"])
+	}
+	latency, reference := result["latency_ns"].(float64), result["latency_reference_ns"].(float64)
+	if reference <= 0 || reference >= latency {
+		t.Errorf("expected the latency on the slow clock below %v, got %v", latency, reference)
+	}
+	if divergence := result["clock_divergence_ns"].(float64); divergence >= 0 {
+		t.Errorf("expected the slow clock to measure less, got a divergence of %v", divergence)
+	}
+	if _, ok := result["clock_divergence_p99_ns"]; !ok {
+		t.Errorf("expected the distribution of the divergence, got %v", result)
+	}
+}
+
+func TestIntervalBenchmarkReferenceClockTooSmall(t *testing.T) {
+	writer := &IntervalBenchmark{MessageSize: 8, TotalMessages: 1, Interval: time.Millisecond, Echo: true, ReferenceClock: RealtimeClock}
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+	if err := writer.Writer(writerConn); err == nil {
+		t.Error("expected messages too small for both send times to be rejected")
+	}
+}
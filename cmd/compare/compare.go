@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gaukas/benchmarkconn/cmd/utils"
+)
+
+// defaultMetrics are the result fields compared unless -metrics is set.
+var defaultMetrics = []string{
+	"throughput_Mbps",
+	"ops_per_s",
+	"latency_ns",
+	"latency_p50_ns",
+	"latency_p99_ns",
+	"latency_p999_ns",
+	"jitter_ns",
+}
+
+// runSet is the result files of one side of the comparison, repeats of
+// the same run.
+type runSet struct {
+	Pattern   string
+	Records   []*utils.ResultRecord
+	Manifests []*utils.Manifest // the manifest of each record, nil if it was run without -manifest
+}
+
+// loadRunSet loads the result files matching pattern and their manifests,
+// skipping those of failed runs.
+func loadRunSet(pattern string) (*runSet, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no result file matches %s", pattern)
+	}
+
+	set := &runSet{Pattern: pattern}
+	for _, path := range paths {
+		record, err := utils.ReadResultFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if record.Error != "" {
+			fmt.Printf("Skipping %s, the run failed: %s\n", path, record.Error)
+			continue
+		}
+		manifest, err := loadManifest(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", utils.ManifestPath(path), err)
+		}
+		set.Records = append(set.Records, record)
+		set.Manifests = append(set.Manifests, manifest)
+	}
+	if len(set.Records) == 0 {
+		return nil, fmt.Errorf("no successful run in %s", pattern)
+	}
+	return set, nil
+}
+
+// describe describes what the runs measured, e.g., "pressure write", or
+// lists the distinct descriptions if the runs differ.
+func (s *runSet) describe() string {
+	var kinds []string
+	seen := make(map[string]bool)
+	for _, record := range s.Records {
+		kind := record.Type + " " + record.Operation
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// values returns the values of the result field key over the runs having
+// it.
+func (s *runSet) values(key string) []float64 {
+	var values []float64
+	for _, record := range s.Records {
+		if value, ok := record.Result[key].(float64); ok { // JSON numbers decode as float64
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// comparison is the comparison of a metric between both sides.
+type comparison struct {
+	Metric   string
+	Old, New string // the mean and relative standard deviation of each side
+	Delta    string // the relative change of the mean, "~" if not significant
+	Note     string // the p-value and sample sizes, if tested
+}
+
+// compareRuns compares each metric reported by both sides, testing the
+// significance of the delta at alpha if both sides have repeats.
+func compareRuns(old, new *runSet, metrics []string, alpha float64) []comparison {
+	var comparisons []comparison
+	for _, metric := range metrics {
+		oldValues, newValues := old.values(metric), new.values(metric)
+		if len(oldValues) == 0 || len(newValues) == 0 {
+			continue
+		}
+
+		oldMean, oldSpread := meanRelStdDev(oldValues)
+		newMean, newSpread := meanRelStdDev(newValues)
+		c := comparison{
+			Metric: metric,
+			Old:    formatMean(oldMean, oldSpread, len(oldValues)),
+			New:    formatMean(newMean, newSpread, len(newValues)),
+			Delta:  formatDelta(oldMean, newMean),
+		}
+
+		if len(oldValues) < 2 || len(newValues) < 2 {
+			c.Note = fmt.Sprintf("(n=%d+%d, no significance without repeats)", len(oldValues), len(newValues))
+		} else {
+			p := mannWhitneyU(oldValues, newValues)
+			if p >= alpha {
+				c.Delta = "~"
+			}
+			c.Note = fmt.Sprintf("(p=%.3f n=%d+%d)", p, len(oldValues), len(newValues))
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+func formatMean(mean, spread float64, n int) string {
+	if n < 2 {
+		return fmt.Sprintf("%.4g", mean)
+	}
+	return fmt.Sprintf("%.4g ± %.0f%%", mean, spread*100)
+}
+
+func formatDelta(old, new float64) string {
+	if old == 0 {
+		return "?"
+	}
+	return fmt.Sprintf("%+.2f%%", (new-old)/old*100)
+}
+
+// renderComparison writes the comparisons as a table, along with the runs
+// compared and the differences of their configurations.
+func renderComparison(w io.Writer, old, new *runSet, diffs []configDiff, manifests bool, comparisons []comparison) error {
+	for _, side := range []struct {
+		name string
+		set  *runSet
+	}{{"old", old}, {"new", new}} {
+		runs := "runs"
+		if len(side.set.Records) == 1 {
+			runs = "run"
+		}
+		fmt.Fprintf(w, "%s: %s, %d %s of %s\n", side.name, side.set.Pattern, len(side.set.Records), runs, side.set.describe())
+	}
+	if old.describe() != new.describe() {
+		fmt.Fprintln(w, "warning: the runs compared measured different benchmarks")
+	}
+	if len(diffs) > 0 {
+		if manifests {
+			fmt.Fprintln(w, "config differences:")
+		} else {
+			fmt.Fprintln(w, "config differences (flags set, write manifests with -manifest for a full diff):")
+		}
+		for _, diff := range diffs {
+			fmt.Fprintf(w, "  %s: %s → %s\n", diff.Key, diff.Old, diff.New)
+		}
+	}
+	fmt.Fprintln(w)
+
+	if len(comparisons) == 0 {
+		_, err := fmt.Fprintln(w, "no metric is reported by both sides")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "metric\told\tnew\tdelta\t")
+	for _, c := range comparisons {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Metric, c.Old, c.New, c.Delta, c.Note)
+	}
+	return tw.Flush()
+}
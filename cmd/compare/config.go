@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/gaukas/benchmarkconn/cmd/utils"
+)
+
+// configIgnoredKeys are the configuration keys which may differ between
+// runs without changing what is measured, left out of the differences.
+var configIgnoredKeys = map[string]bool{
+	"o":             true,
+	"manifest":      true,
+	"digits":        true,
+	"seed":          true,
+	"config":        true,
+	"progress":      true,
+	"otlp-endpoint": true,
+	"otlp-insecure": true,
+	"sink":          true,
+
+	"environment.runtime.timer_resolution_ns": true, // measured anew by each run
+}
+
+// configDiff is a configuration key whose values differ between both
+// sides.
+type configDiff struct {
+	Key      string
+	Old, New string // the distinct values of the key over the runs of each side
+}
+
+// loadManifest loads the manifest written with -manifest next to the
+// result file at path, or returns nil if there is none.
+func loadManifest(path string) (*utils.Manifest, error) {
+	manifest, err := utils.ReadManifestFile(utils.ManifestPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return manifest, err
+}
+
+// configs returns the configuration of each run as flat key-value pairs,
+// and whether they come from the manifests of the runs. Without a manifest
+// for every run of both sides, only the flags explicitly set are known,
+// so the configurations of both sides are compared from those instead.
+func configs(old, new *runSet) (oldConfigs, newConfigs []map[string]string, manifests bool) {
+	manifests = old.hasManifests() && new.hasManifests()
+	return old.configs(manifests), new.configs(manifests), manifests
+}
+
+func (s *runSet) hasManifests() bool {
+	for _, manifest := range s.Manifests {
+		if manifest == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *runSet) configs(manifests bool) []map[string]string {
+	configs := make([]map[string]string, len(s.Records))
+	for i, record := range s.Records {
+		config := make(map[string]string)
+		if manifests {
+			flattenManifest(config, s.Manifests[i])
+		} else {
+			config["type"] = record.Type
+			config["operation"] = record.Operation
+			config["network"] = record.Network
+			config["address"] = record.Address
+			for name, value := range record.Flags {
+				config[name] = value
+			}
+		}
+		for key := range config {
+			if configIgnoredKeys[key] {
+				delete(config, key)
+			}
+		}
+		configs[i] = config
+	}
+	return configs
+}
+
+// flattenManifest adds the config of the manifest to config, keyed by
+// flag name, and its decorators, build and environment keyed by their
+// dotted path, e.g., build.revision, all but its time.
+func flattenManifest(config map[string]string, manifest *utils.Manifest) {
+	for name, value := range manifest.Config {
+		flatten(config, name, value)
+	}
+	if len(manifest.Decorators) > 0 {
+		config["decorators"] = strings.Join(manifest.Decorators, ", ")
+	}
+	for name, value := range manifest.Build {
+		config["build."+name] = value
+	}
+	for name, value := range manifest.Environment {
+		flatten(config, "environment."+name, value)
+	}
+}
+
+func flatten(config map[string]string, key string, value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for name, v := range value {
+			flatten(config, key+"."+name, v)
+		}
+	case []any: // repeatable flags, e.g., -assert
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = fmt.Sprint(v)
+		}
+		config[key] = strings.Join(values, "; ")
+	default:
+		config[key] = fmt.Sprint(value)
+	}
+}
+
+// diffConfigs returns the keys whose values differ between the runs of
+// both sides, the flags first, then the build and the environment.
+func diffConfigs(oldConfigs, newConfigs []map[string]string) []configDiff {
+	keys := make(map[string]bool)
+	for _, config := range append(append([]map[string]string{}, oldConfigs...), newConfigs...) {
+		for key := range config {
+			keys[key] = true
+		}
+	}
+
+	var diffs []configDiff
+	for key := range keys {
+		old, new := sideValues(oldConfigs, key), sideValues(newConfigs, key)
+		if old != new {
+			diffs = append(diffs, configDiff{Key: key, Old: old, New: new})
+		}
+	}
+	rank := func(key string) int {
+		switch {
+		case strings.HasPrefix(key, "build."):
+			return 1
+		case strings.HasPrefix(key, "environment."):
+			return 2
+		}
+		return 0
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if ri, rj := rank(diffs[i].Key), rank(diffs[j].Key); ri != rj {
+			return ri < rj
+		}
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs
+}
+
+// sideValues returns the distinct values of key over the runs of a side,
+// joined with "|" if the runs differ, or "(unset)" for runs without it.
+func sideValues(configs []map[string]string, key string) string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		value, ok := config[key]
+		if !ok {
+			value = "(unset)"
+		}
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return strings.Join(values, "|")
+}
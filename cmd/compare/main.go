@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	alpha := fs.Float64("alpha", 0.05, "significance level below which a delta is reported, otherwise shown as ~")
+	metrics := fs.String("metrics", strings.Join(defaultMetrics, ","), "comma-separated result fields to compare")
+	fs.Usage = func() {
+		fmt.Println("Example: compare [arguments...] <old_results> <new_results>")
+		fmt.Println("Compares result files written by client/server with -o and reports the delta of each metric with its significance.")
+		fmt.Println("Each side is a result file or a glob pattern, e.g., 'old-*.json', matching repeated runs.")
+		fmt.Println("Configuration differences between both sides are listed from their manifests, if written with -manifest.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		fmt.Printf("Significance level must be between 0 and 1, got %g\n", *alpha)
+		os.Exit(1)
+	}
+
+	old, err := loadRunSet(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Failed to load result files: %v\n", err)
+		os.Exit(1)
+	}
+	new, err := loadRunSet(fs.Arg(1))
+	if err != nil {
+		fmt.Printf("Failed to load result files: %v\n", err)
+		os.Exit(1)
+	}
+
+	var keys []string
+	for _, key := range strings.Split(*metrics, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	oldConfigs, newConfigs, manifests := configs(old, new)
+	diffs := diffConfigs(oldConfigs, newConfigs)
+	if err := renderComparison(os.Stdout, old, new, diffs, manifests, compareRuns(old, new, keys, *alpha)); err != nil {
+		fmt.Printf("Failed to render comparison: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// maxExactSamples is the largest number of samples on both sides together
+// for which the exact distribution of U is computed rather than
+// approximated.
+const maxExactSamples = 40
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U test
+// of whether the samples x and y come from the same distribution, which,
+// unlike a t-test, assumes nothing about the distribution, e.g., of
+// throughput skewed by a noisy run. The p-value is exact for small samples
+// without ties, and otherwise approximated by the normal distribution with
+// the tie and continuity corrections.
+func mannWhitneyU(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	// rank all samples, averaging the ranks of ties
+	type sample struct {
+		value float64
+		fromX bool
+	}
+	samples := make([]sample, 0, n1+n2)
+	for _, v := range x {
+		samples = append(samples, sample{v, true})
+	}
+	for _, v := range y {
+		samples = append(samples, sample{v, false})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].value < samples[j].value })
+
+	var rankSumX, tieCorrection float64
+	ties := false
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].value == samples[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // ranks are 1-based
+		for k := i; k < j; k++ {
+			if samples[k].fromX {
+				rankSumX += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieCorrection += t*t*t - t
+		}
+		i = j
+	}
+	u := rankSumX - float64(n1*(n1+1))/2
+
+	if !ties && n1+n2 <= maxExactSamples {
+		return exactUPValue(n1, n2, int(u))
+	}
+
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 1 // all samples are equal
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		return 1
+	}
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// exactUPValue returns the two-sided p-value of U = u for samples of n1
+// and n2 without ties, from the number of arrangements of the samples
+// yielding each U.
+func exactUPValue(n1, n2, u int) float64 {
+	// counts[i][j][k] is the number of arrangements of i and j samples
+	// with U = k, where the largest sample either comes from x, adding j
+	// to U, or from y
+	counts := make([][][]float64, n1+1)
+	for i := range counts {
+		counts[i] = make([][]float64, n2+1)
+		for j := range counts[i] {
+			counts[i][j] = make([]float64, i*j+1)
+			if i == 0 || j == 0 {
+				counts[i][j][0] = 1
+				continue
+			}
+			for k := range counts[i][j] {
+				if k-j >= 0 && k-j < len(counts[i-1][j]) {
+					counts[i][j][k] += counts[i-1][j][k-j]
+				}
+				if k < len(counts[i][j-1]) {
+					counts[i][j][k] += counts[i][j-1][k]
+				}
+			}
+		}
+	}
+
+	var total, below, above float64
+	for k, count := range counts[n1][n2] {
+		total += count
+		if k <= u {
+			below += count
+		}
+		if k >= u {
+			above += count
+		}
+	}
+	return math.Min(1, 2*math.Min(below, above)/total)
+}
+
+// meanRelStdDev returns the mean of values and their sample standard
+// deviation relative to it.
+func meanRelStdDev(values []float64) (float64, float64) {
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 || mean == 0 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares/float64(len(values)-1)) / math.Abs(mean)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMannWhitneyU(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		x, y []float64
+		p    float64
+	}{
+		// exact, from the 20 arrangements of 3 and 3 samples
+		{"ExactSeparated", []float64{1, 2, 3}, []float64{4, 5, 6}, 2.0 / 20},
+		{"ExactSeparatedReversed", []float64{4, 5, 6}, []float64{1, 2, 3}, 2.0 / 20},
+		{"ExactInterleaved", []float64{1, 3, 5}, []float64{2, 4, 6}, 14.0 / 20},
+		// exact, from the 252 arrangements of 5 and 5 samples
+		{"ExactFive", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		{"ExactUnequal", []float64{1, 2}, []float64{3, 4, 5, 6}, 2.0 / 15},
+		// normal approximation with the tie and continuity corrections,
+		// U = 2.5 against a mean of 8 and a variance of 11.2857
+		{"Ties", []float64{1, 2, 2, 3}, []float64{2, 3, 4, 5}, 0.13665824773814753},
+		// normal approximation beyond maxExactSamples, U = 0 for 21 and 21
+		{"Large", sequence(1, 21), sequence(22, 42), 3.125399998400882e-08},
+		{"AllEqual", []float64{7, 7, 7}, []float64{7, 7, 7, 7}, 1},
+		{"Empty", nil, []float64{1, 2}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if p := mannWhitneyU(tc.x, tc.y); math.Abs(p-tc.p) > 1e-9*tc.p {
+				t.Errorf("expected a p-value of %g, got %g", tc.p, p)
+			}
+		})
+	}
+}
+
+func TestExactUPValue(t *testing.T) {
+	// the distribution of U is symmetric around n1*n2/2, so the p-value
+	// at the mean is 1 and those of U and n1*n2-U are equal
+	if p := exactUPValue(4, 4, 8); p != 1 {
+		t.Errorf("expected a p-value of 1 at the mean, got %g", p)
+	}
+	for u := 0; u <= 12; u++ {
+		if p, mirrored := exactUPValue(3, 4, u), exactUPValue(3, 4, 12-u); p != mirrored {
+			t.Errorf("expected the p-values of U = %d and %d to be equal, got %g and %g", u, 12-u, p, mirrored)
+		}
+	}
+}
+
+func TestMeanRelStdDev(t *testing.T) {
+	mean, rsd := meanRelStdDev([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	if mean != 5 || math.Abs(rsd-math.Sqrt(32.0/7)/5) > 1e-12 {
+		t.Errorf("expected a mean of 5 and a relative standard deviation of %g, got %g and %g", math.Sqrt(32.0/7)/5, mean, rsd)
+	}
+	if _, rsd := meanRelStdDev([]float64{3}); rsd != 0 {
+		t.Errorf("expected no deviation of a single value, got %g", rsd)
+	}
+}
+
+// sequence returns the values from to to, inclusive.
+func sequence(from, to int) []float64 {
+	var values []float64
+	for v := from; v <= to; v++ {
+		values = append(values, float64(v))
+	}
+	return values
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	htmlBarWidth  = 480 // width of the longest bar in pixels
+	htmlBarHeight = 22
+	htmlLabelSize = 240 // width reserved for bar labels in pixels
+)
+
+var htmlTemplate = template.Must(template.New("html").Funcs(template.FuncMap{
+	"format":    formatValue,
+	"join":      strings.Join,
+	"time":      func(t time.Time) string { return t.Format(time.RFC3339) },
+	"width":     func(ratio float64) int { return int(ratio * htmlBarWidth) },
+	"y":         func(i int) int { return i * (htmlBarHeight + 6) },
+	"height":    func(bars []*Bar) int { return len(bars) * (htmlBarHeight + 6) },
+	"labelSize": func() int { return htmlLabelSize },
+	"barHeight": func() int { return htmlBarHeight },
+	"add":       func(a, b int) int { return a + b },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+th { background: #f4f4f4; }
+pre { background: #f8f8f8; padding: 8px; overflow-x: auto; }
+svg text { font-size: 12px; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated at {{time .Generated}} from {{len .Runs}} result file(s).{{if .Failed}} <strong class="error">{{.Failed}} run(s) failed assertions.</strong>{{end}}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Result</th><th>Benchmark</th><th>Operation</th><th>Network</th>{{range .Metrics}}<th>{{.}}</th>{{end}}<th>Assertions</th></tr>
+{{range $run := .Runs}}<tr><td>{{.Name}}</td><td>{{.Record.Benchmark}}</td><td>{{.Record.Operation}}</td><td>{{.Record.Network}}</td>{{range .Values}}<td>{{.}}</td>{{end}}<td{{if and (ne .Verdict "-") (ne .Verdict "passed")}} class="error"{{end}}>{{.Verdict}}</td></tr>
+{{with .Peer}}<tr><td>{{$run.Name}} (peer)</td><td>{{$run.Record.Benchmark}}</td><td>{{.Operation}}</td><td>{{$run.Record.Network}}</td>{{range .Values}}<td>{{.}}</td>{{end}}<td>-</td></tr>
+{{end}}{{end}}</table>
+
+{{if .Repeats}}<h2>Repeats</h2>
+<p>Runs of the same benchmark with the same flags, as mean ± standard deviation. Runs deviating {{.OutlierRule}}.</p>
+<table>
+<tr><th>Repeated run</th><th>Runs</th><th>Included</th>{{range .RepeatMetrics}}<th>{{.}}</th>{{end}}</tr>
+{{range .Repeats}}<tr><td><code>{{.Label}}</code></td><td>{{.Runs}}</td><td{{if lt .Included .Runs}} class="error"{{end}}>{{.Included}}</td>{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{range .Repeats}}{{if .Outliers}}<p>Outliers of <code>{{.Label}}</code>:</p>
+<ul>
+{{range .Outliers}}<li class="error"><strong>{{.Run}}</strong>: {{.Metric}} of {{.Value}}, {{.Deviation}} away</li>
+{{end}}</ul>
+{{end}}{{end}}{{end}}
+{{range .Charts}}<h2>{{.Name}}</h2>
+<svg width="{{add (labelSize) 600}}" height="{{height .Bars}}" xmlns="http://www.w3.org/2000/svg">
+{{range $i, $bar := .Bars}}<text x="0" y="{{add (y $i) 15}}">{{$bar.Label}}</text>
+<rect x="{{labelSize}}" y="{{y $i}}" width="{{width $bar.Ratio}}" height="{{barHeight}}" fill="#4a7bd0"></rect>
+<text x="{{add (add (labelSize) (width $bar.Ratio)) 6}}" y="{{add (y $i) 15}}">{{format $bar.Value}}</text>
+{{end}}</svg>
+{{end}}
+<h2>Metadata</h2>
+{{range .Runs}}<h3>{{.Name}}</h3>
+<ul>
+<li>Benchmark: {{.Record.Benchmark}} ({{.Record.Type}} {{.Record.Operation}})</li>
+<li>Address: {{.Record.Network}}://{{.Record.Address}}</li>
+<li>Recorded at: {{time .Record.Time}}</li>
+{{if .Record.Error}}<li class="error">Error: {{.Record.Error}}</li>{{end}}
+{{if .Flags}}<li>Flags: <code>{{join .Flags " "}}</code></li>{{end}}
+{{range .Record.Assertions}}<li{{if not .Passed}} class="error"{{end}}>Assertion <code>{{.Expr}}</code>: {{if .Passed}}passed{{else}}failed{{if .Error}} ({{.Error}}){{end}}{{end}}</li>
+{{end}}
+</ul>
+{{if .Phases}}<table>
+<tr><th>Phase</th><th>Start</th><th>End</th>{{range $.Metrics}}<th>{{.}}</th>{{end}}</tr>
+{{range .Phases}}<tr><td>{{.Name}}</td><td>{{.Start}}</td><td>{{.End}}</td>{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+{{if .Runtime}}<details><summary>Runtime</summary><pre>{{.Runtime}}</pre></details>{{end}}
+{{if .Counters}}<details><summary>Counters</summary><pre>{{.Counters}}</pre></details>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func renderHTML(w io.Writer, report *Report) error {
+	return htmlTemplate.Execute(w, report)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "html", "report format (html, markdown)")
+	output := fs.String("o", "", "write the report to this file instead of stdout")
+	title := fs.String("title", "benchmarkconn report", "title of the report")
+	var outliers OutlierOptions
+	fs.StringVar(&outliers.Method, "outlier-method", "mad", "how repeated runs are compared (mad for deviations from the median, stddev for deviations from the mean)")
+	fs.Float64Var(&outliers.Threshold, "outlier-threshold", 3.5, "deviations from the other repeats beyond which a run is flagged as an outlier")
+	fs.BoolVar(&outliers.Keep, "keep-outliers", false, "keep outliers in the aggregate of their repeats instead of excluding them")
+	fs.Usage = func() {
+		fmt.Println("Example: report [arguments...] <result_file> [result_file...]")
+		fmt.Println("Renders a self-contained report from result files written by client/server with -o.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	report, err := loadReport(*title, fs.Args(), outliers)
+	if err != nil {
+		fmt.Printf("Failed to load result files: %v\n", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Printf("Failed to create report file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch *format {
+	case "html":
+		err = renderHTML(out, report)
+	case "markdown", "md":
+		err = renderMarkdown(out, report)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fmt.Printf("Failed to render report: %v\n", err)
+		os.Exit(1)
+	}
+}
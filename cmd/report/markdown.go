@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const markdownBarWidth = 40
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(template.FuncMap{
+	"bar":    markdownBar,
+	"format": formatValue,
+	"join":   strings.Join,
+	"time":   func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`# {{.Title}}
+
+Generated at {{time .Generated}} from {{len .Runs}} result file(s).
+{{- if .Failed}} **{{.Failed}} run(s) failed assertions.**{{end}}
+
+## Summary
+
+| Result | Benchmark | Operation | Network |{{range .Metrics}} {{.}} |{{end}} Assertions |
+|---|---|---|---|{{range .Metrics}}---|{{end}}---|
+{{range $run := .Runs}}| {{.Name}} | {{.Record.Benchmark}} | {{.Record.Operation}} | {{.Record.Network}} |{{range .Values}} {{.}} |{{end}} {{.Verdict}} |
+{{with .Peer}}| {{$run.Name}} (peer) | {{$run.Record.Benchmark}} | {{.Operation}} | {{$run.Record.Network}} |{{range .Values}} {{.}} |{{end}} - |
+{{end}}{{end}}
+{{- if .Repeats}}
+## Repeats
+
+Runs of the same benchmark with the same flags, as mean ± standard deviation. Runs deviating {{.OutlierRule}}.
+
+| Repeated run | Runs | Included |{{range .RepeatMetrics}} {{.}} |{{end}}
+|---|---|---|{{range .RepeatMetrics}}---|{{end}}
+{{range .Repeats}}| ` + "`{{.Label}}`" + ` | {{.Runs}} | {{.Included}} |{{range .Values}} {{.}} |{{end}}
+{{end}}{{range .Repeats}}{{if .Outliers}}
+Outliers of ` + "`{{.Label}}`" + `:
+{{range .Outliers}}
+- **{{.Run}}**: {{.Metric}} of {{.Value}}, {{.Deviation}} away
+{{- end}}
+{{end}}{{end}}{{end}}
+{{- range .Charts}}
+## {{.Name}}
+
+| Result | | Value |
+|---|---|---|
+{{range .Bars}}| {{.Label}} | ` + "`{{bar .Ratio}}`" + ` | {{format .Value}} |
+{{end}}{{end}}
+## Metadata
+{{range .Runs}}
+### {{.Name}}
+
+- Benchmark: {{.Record.Benchmark}} ({{.Record.Type}} {{.Record.Operation}})
+- Address: {{.Record.Network}}://{{.Record.Address}}
+- Recorded at: {{time .Record.Time}}
+{{- if .Record.Error}}
+- Error: {{.Record.Error}}
+{{- end}}
+{{- if .Flags}}
+- Flags: ` + "`{{join .Flags \" \"}}`" + `
+{{- end}}
+{{- range .Record.Assertions}}
+- Assertion ` + "`{{.Expr}}`" + `: {{if .Passed}}passed{{else}}**failed**{{if .Error}} ({{.Error}}){{end}}{{end}}
+{{- end}}
+{{if .Phases}}
+| Phase | Start | End |{{range $.Metrics}} {{.}} |{{end}}
+|---|---|---|{{range $.Metrics}}---|{{end}}
+{{range .Phases}}| {{.Name}} | {{.Start}} | {{.End}} |{{range .Values}} {{.}} |{{end}}
+{{end}}{{end}}{{if .Runtime}}
+Runtime:
+
+` + "```json" + `
+{{.Runtime}}
+` + "```" + `
+{{end}}{{end}}`))
+
+func markdownBar(ratio float64) string {
+	n := int(ratio*markdownBarWidth + 0.5)
+	return strings.Repeat("█", n) + strings.Repeat(" ", markdownBarWidth-n)
+}
+
+func renderMarkdown(w io.Writer, report *Report) error {
+	return markdownTemplate.Execute(w, report)
+}
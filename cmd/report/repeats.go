@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// repeatMetrics lists the numeric result fields aggregated over repeated
+// runs and checked for outliers, in order.
+var repeatMetrics = []struct {
+	Key  string
+	Name string
+}{
+	{"ops_per_s", "Ops/s"},
+	{"throughput_Mbps", "Mbps"},
+	{"latency_ns", "Mean latency (ns)"},
+	{"latency_p99_ns", "p99 (ns)"},
+	{"jitter_ns", "Jitter (ns)"},
+}
+
+// repeatIgnoredFlags are the flags which may differ between repeats of a
+// run without changing what is measured.
+var repeatIgnoredFlags = map[string]bool{
+	"o":             true,
+	"manifest":      true,
+	"digits":        true,
+	"seed":          true,
+	"config":        true,
+	"progress":      true,
+	"otlp-endpoint": true,
+	"otlp-insecure": true,
+	"sink":          true,
+}
+
+const (
+	minOutlierRuns = 3 // below this, no run can be told apart as an outlier
+
+	madScale    = 1.4826 // scales the MAD to the standard deviation of normally distributed values
+	meanADScale = 1.2533 // scales the mean absolute deviation likewise, used if the MAD is 0
+)
+
+// OutlierOptions configures how repeated runs deviating from the others
+// are detected.
+type OutlierOptions struct {
+	Method    string  // Method is either "mad", deviations from the median, or "stddev", deviations from the mean
+	Threshold float64 // Threshold is how many deviations a run must be away to be an outlier
+	Keep      bool    // Keep keeps outliers in the aggregate rather than excluding them
+}
+
+func (o OutlierOptions) validate() error {
+	if o.Method != "mad" && o.Method != "stddev" {
+		return fmt.Errorf("unknown outlier method %q, must be mad or stddev", o.Method)
+	}
+	if o.Threshold <= 0 {
+		return fmt.Errorf("outlier threshold must be positive, got %g", o.Threshold)
+	}
+	return nil
+}
+
+// String describes the rule runs are flagged as outliers by.
+func (o OutlierOptions) String() string {
+	rule := fmt.Sprintf("more than %g scaled MADs from the median", o.Threshold)
+	if o.Method == "stddev" {
+		rule = fmt.Sprintf("more than %g standard deviations from the mean", o.Threshold)
+	}
+	if o.Keep {
+		return rule + ", kept in the aggregate"
+	}
+	return rule + ", excluded from the aggregate"
+}
+
+// RepeatGroup aggregates repeated runs of the same benchmark with the same
+// flags.
+type RepeatGroup struct {
+	Label    string
+	Runs     int      // Runs is the number of runs in the group
+	Included int      // Included is the number of runs in the aggregate
+	Values   []string // Values are the mean and standard deviation of the metrics over the included runs
+	Outliers []*Outlier
+}
+
+// Outlier is a metric of a run deviating from its repeats.
+type Outlier struct {
+	Run       string
+	Metric    string
+	Value     string
+	Deviation string // Deviation in the unit of the method, e.g., "5.2 MADs"
+}
+
+// groupRepeats groups the successful runs by what they measured, and for
+// each group of repeats flags the runs deviating from the others beyond
+// the threshold of opts in any of the repeatMetrics, then aggregates the
+// metrics over the remaining runs.
+func groupRepeats(runs []*Run, opts OutlierOptions) []*RepeatGroup {
+	var keys []string
+	members := make(map[string][]*Run)
+	for _, run := range runs {
+		if run.Record.Error != "" {
+			continue
+		}
+		key := repeatKey(run)
+		if members[key] == nil {
+			keys = append(keys, key)
+		}
+		members[key] = append(members[key], run)
+	}
+
+	var groups []*RepeatGroup
+	for _, key := range keys {
+		group := members[key]
+		if len(group) < 2 {
+			continue
+		}
+
+		outliers := make(map[*Run]bool)
+		repeats := &RepeatGroup{Label: key, Runs: len(group)}
+		for _, m := range repeatMetrics {
+			values, valueRuns := metricValues(group, m.Key)
+			if len(values) < minOutlierRuns {
+				continue
+			}
+			for i, score := range deviationScores(values, opts.Method) {
+				if score <= opts.Threshold {
+					continue
+				}
+				outliers[valueRuns[i]] = true
+				unit := "MADs"
+				if opts.Method == "stddev" {
+					unit = "standard deviations"
+				}
+				repeats.Outliers = append(repeats.Outliers, &Outlier{
+					Run:       valueRuns[i].Name,
+					Metric:    m.Name,
+					Value:     formatValue(values[i]),
+					Deviation: fmt.Sprintf("%.1f %s", score, unit),
+				})
+			}
+		}
+
+		var included []*Run
+		for _, run := range group {
+			if opts.Keep || !outliers[run] {
+				included = append(included, run)
+			}
+		}
+		repeats.Included = len(included)
+		for _, m := range repeatMetrics {
+			values, _ := metricValues(included, m.Key)
+			repeats.Values = append(repeats.Values, formatAggregate(values))
+		}
+		groups = append(groups, repeats)
+	}
+	return groups
+}
+
+// repeatKey identifies what a run measured: the benchmark, the operation,
+// the address and the flags set, but those in repeatIgnoredFlags.
+func repeatKey(run *Run) string {
+	record := run.Record
+	key := fmt.Sprintf("%s %s %s://%s", record.Type, record.Operation, record.Network, record.Address)
+
+	var flags []string
+	for name, value := range record.Flags {
+		if !repeatIgnoredFlags[name] {
+			flags = append(flags, name+"="+value)
+		}
+	}
+	sort.Strings(flags)
+	if len(flags) > 0 {
+		key += " " + strings.Join(flags, " ")
+	}
+	return key
+}
+
+// metricValues returns the values of the result field key of the runs
+// having it, along with those runs.
+func metricValues(runs []*Run, key string) ([]float64, []*Run) {
+	var values []float64
+	var valueRuns []*Run
+	for _, run := range runs {
+		if value, ok := run.Record.Result[key].(float64); ok { // JSON numbers decode as float64
+			values = append(values, value)
+			valueRuns = append(valueRuns, run)
+		}
+	}
+	return values, valueRuns
+}
+
+// deviationScores returns how far each value is from the others: the
+// absolute deviation from the median in scaled MADs, or from the mean in
+// sample standard deviations. All scores are 0 if the values do not
+// deviate at all.
+func deviationScores(values []float64, method string) []float64 {
+	scores := make([]float64, len(values))
+
+	if method == "stddev" {
+		mean, stddev := meanStdDev(values)
+		if stddev == 0 {
+			return scores
+		}
+		for i, value := range values {
+			scores[i] = math.Abs(value-mean) / stddev
+		}
+		return scores
+	}
+
+	center := median(values)
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - center)
+	}
+	scale := madScale * median(deviations)
+	if scale == 0 {
+		// more than half the values are equal, fall back to the mean
+		// absolute deviation so that the others still stand out
+		mean, _ := meanStdDev(deviations)
+		scale = meanADScale * mean
+	}
+	if scale == 0 {
+		return scores
+	}
+	for i, deviation := range deviations {
+		scores[i] = deviation / scale
+	}
+	return scores
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// meanStdDev returns the mean and the sample standard deviation of values.
+func meanStdDev(values []float64) (float64, float64) {
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)-1))
+}
+
+// formatAggregate formats the mean and standard deviation of values.
+func formatAggregate(values []float64) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	mean, stddev := meanStdDev(values)
+	return formatValue(mean) + " ± " + formatValue(stddev)
+}
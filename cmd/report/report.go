@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+	"github.com/gaukas/benchmarkconn/cmd/utils"
+)
+
+// metrics lists the result fields shown in the summary table, in order.
+var metrics = []struct {
+	Key  string
+	Name string
+}{
+	{"duration", "Duration"},
+	{"successful_writes", "Writes"},
+	{"successful_reads", "Reads"},
+	{"ops_per_s", "Ops/s"},
+	{"throughput_Mbps", "Mbps"},
+	{"latency_ns", "Mean latency (ns)"},
+	{"latency_p50_ns", "p50 (ns)"},
+	{"latency_p99_ns", "p99 (ns)"},
+	{"latency_p999_ns", "p99.9 (ns)"},
+	{"sched_latency_p99_ns", "Sched p99 (ns)"},
+	{"alloc_bytes_per_message", "Alloc B/msg"},
+	{"coalescing_ratio", "Writes/segment"},
+}
+
+// charts lists the numeric result fields rendered as bar charts.
+var charts = []struct {
+	Key  string
+	Name string
+}{
+	{"ops_per_s", "Throughput (ops/s)"},
+	{"throughput_Mbps", "Throughput (Mbps)"},
+	{"latency_p50_ns", "Median latency (ns)"},
+	{"latency_p99_ns", "p99 latency (ns)"},
+}
+
+type Report struct {
+	Title     string
+	Generated time.Time
+	Runs      []*Run
+	Metrics   []string // names of the metrics in the summary table
+	Charts    []*Chart
+	Failed    int // number of runs with failed assertions
+
+	RepeatMetrics []string       // names of the metrics aggregated over repeats
+	Repeats       []*RepeatGroup // groups of repeated runs, if any
+	OutlierRule   string         // how outliers among repeats are flagged
+}
+
+type Run struct {
+	Name     string // Name is the base name of the result file
+	Record   *utils.ResultRecord
+	Values   []string // Values of the metrics in the summary table
+	Peer     *PeerRow // Peer summarizes the result of the peer, if exchanged over -control
+	Flags    []string // Flags as sorted name=value pairs
+	Runtime  string   // Runtime settings as indented JSON
+	Counters string   // Counters as indented JSON, if any, segmented by phase
+	Verdict  string   // Verdict of the assertions, "-" if there are none
+	Phases   []*PhaseRow
+}
+
+// PhaseRow summarizes a phase of a phased run.
+type PhaseRow struct {
+	Name   string
+	Start  string   // Start as the offset from the start of the first phase
+	End    string   // End as the offset from the start of the first phase
+	Values []string // Values of the metrics in the summary table
+}
+
+// PeerRow summarizes the result of the peer of a run, e.g., the latency
+// measured by the reader for a writer, which has none of its own.
+type PeerRow struct {
+	Operation string   // Operation is the operation of the peer, the opposite of the run
+	Values    []string // Values of the metrics in the summary table
+}
+
+// peerRow returns the summary of the result of the peer of the run, nil
+// if it was not exchanged.
+func peerRow(record *utils.ResultRecord) *PeerRow {
+	peer, ok := record.Result["peer"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	row := &PeerRow{Operation: "write"}
+	if record.Operation == "write" {
+		row.Operation = "read"
+	}
+	for _, m := range metrics {
+		row.Values = append(row.Values, formatValue(peer[m.Key]))
+	}
+	return row
+}
+
+// phaseBoundary is a phase boundary of a result, see
+// benchmarkconn.PhasedBenchmark.
+type phaseBoundary struct {
+	phase      string
+	start, end time.Time
+}
+
+type Chart struct {
+	Name string
+	Bars []*Bar
+}
+
+type Bar struct {
+	Label string
+	Value float64
+	Ratio float64 // Ratio of the value to the maximum value in the chart
+}
+
+func loadReport(title string, paths []string, outliers OutlierOptions) (*Report, error) {
+	if err := outliers.validate(); err != nil {
+		return nil, err
+	}
+	report := &Report{
+		Title:       title,
+		Generated:   time.Now(),
+		OutlierRule: outliers.String(),
+	}
+
+	for _, m := range metrics {
+		report.Metrics = append(report.Metrics, m.Name)
+	}
+	for _, m := range repeatMetrics {
+		report.RepeatMetrics = append(report.RepeatMetrics, m.Name)
+	}
+
+	for _, path := range paths {
+		record, err := utils.ReadResultFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		run := &Run{
+			Name:   filepath.Base(path),
+			Record: record,
+		}
+		for _, m := range metrics {
+			run.Values = append(run.Values, formatValue(record.Result[m.Key]))
+		}
+		run.Peer = peerRow(record)
+		for name, value := range record.Flags {
+			run.Flags = append(run.Flags, name+"="+value)
+		}
+		sort.Strings(run.Flags)
+		if runtime, ok := record.Result["runtime"]; ok {
+			run.Runtime = indentJSON(runtime)
+		}
+		boundaries := phaseBoundaries(record.Result)
+		run.Phases = phaseRows(record.Result, boundaries)
+		if counters, ok := record.Result["counters"]; ok {
+			run.Counters = indentJSON(segmentCounters(counters, boundaries))
+		}
+		run.Verdict = assertionVerdict(record.Assertions)
+		if run.Verdict != "-" && run.Verdict != "passed" {
+			report.Failed++
+		}
+		report.Runs = append(report.Runs, run)
+	}
+
+	report.Repeats = groupRepeats(report.Runs, outliers)
+
+	for _, c := range charts {
+		chart := &Chart{Name: c.Name}
+		var max float64
+		for _, run := range report.Runs {
+			value, ok := run.Record.Result[c.Key].(float64) // JSON numbers decode as float64
+			if !ok {
+				continue
+			}
+			chart.Bars = append(chart.Bars, &Bar{Label: run.Name, Value: value})
+			if value > max {
+				max = value
+			}
+		}
+		if len(chart.Bars) == 0 {
+			continue
+		}
+		for _, bar := range chart.Bars {
+			if max > 0 {
+				bar.Ratio = bar.Value / max
+			}
+		}
+		report.Charts = append(report.Charts, chart)
+	}
+
+	return report, nil
+}
+
+// phaseBoundaries returns the phase boundaries of a phased result, if
+// any.
+func phaseBoundaries(result map[string]any) []phaseBoundary {
+	list, _ := result["phase_boundaries"].([]any)
+	var boundaries []phaseBoundary
+	for _, item := range list {
+		m, _ := item.(map[string]any)
+		phase, _ := m["phase"].(string)
+		startStr, _ := m["start_time"].(string)
+		endStr, _ := m["end_time"].(string)
+		start, err := time.Parse(time.RFC3339Nano, startStr)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339Nano, endStr)
+		if err != nil {
+			continue
+		}
+		boundaries = append(boundaries, phaseBoundary{phase: phase, start: start, end: end})
+	}
+	return boundaries
+}
+
+// phaseRows summarizes each phase of a phased result.
+func phaseRows(result map[string]any, boundaries []phaseBoundary) []*PhaseRow {
+	phases, _ := result["phases"].([]any)
+	var rows []*PhaseRow
+	for i, item := range phases {
+		phase, _ := item.(map[string]any)
+		row := &PhaseRow{Name: formatValue(phase["phase"]), Start: "-", End: "-"}
+		if i < len(boundaries) {
+			origin := boundaries[0].start
+			row.Start = fmt.Sprintf("+%.3fs", boundaries[i].start.Sub(origin).Seconds())
+			row.End = fmt.Sprintf("+%.3fs", boundaries[i].end.Sub(origin).Seconds())
+		}
+		for _, m := range metrics {
+			row.Values = append(row.Values, formatValue(phase[m.Key]))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// segmentCounters groups the samples of each counter, keyed by its name,
+// by the phase they were taken in, keyed by the index and the name of the
+// phase since names may repeat, samples taken between phases being
+// grouped under "between phases". Counters are returned as is without
+// boundaries.
+func segmentCounters(counters any, boundaries []phaseBoundary) any {
+	byName, ok := counters.(map[string]any)
+	if !ok || len(boundaries) == 0 {
+		return counters
+	}
+
+	segmented := make(map[string]map[string]map[string]any, len(byName))
+	for name, counter := range byName {
+		samples, _ := counter.(map[string]any)
+		segmented[name] = make(map[string]map[string]any)
+		for timestamp, value := range samples {
+			phase := "between phases"
+			if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+				for j, boundary := range boundaries {
+					if !t.Before(boundary.start) && !t.After(boundary.end) {
+						phase = fmt.Sprintf("%d: %s", j, boundary.phase)
+						break
+					}
+				}
+			}
+			if segmented[name][phase] == nil {
+				segmented[name][phase] = make(map[string]any)
+			}
+			segmented[name][phase][timestamp] = value
+		}
+	}
+	return segmented
+}
+
+// assertionVerdict summarizes the outcome of the assertions of a run.
+func assertionVerdict(assertions []benchmarkconn.AssertionResult) string {
+	if len(assertions) == 0 {
+		return "-"
+	}
+	failed := 0
+	for _, assertion := range assertions {
+		if !assertion.Passed {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return "passed"
+	}
+	return fmt.Sprintf("failed (%d/%d)", failed, len(assertions))
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case float64:
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+		return fmt.Sprintf("%.2f", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func indentJSON(v any) string {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -10,24 +10,40 @@ import (
 func main() {
 	args := os.Args[1:]
 
-	if len(args) < 3 {
+	if len(args) > 0 && args[0] == "schema" {
+		if err := utils.Schema(args[1:]); err != nil {
+			fmt.Printf("Failed to write the result schema: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "selftest" {
+		if err := utils.SelfTest(args[1:]); err != nil {
+			fmt.Printf("Failed to run the self-test: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	benchType, benchOp, serverAddr, flags, ok := utils.SplitArgs(args)
+	if !ok {
 		utils.NewBenchmark().Usage()
 		os.Exit(1)
 	}
 
 	b := utils.NewBenchmark()
 
-	benchType := os.Args[1]
-	benchOp := os.Args[2]
-	serverAddr := os.Args[3]
-
 	b.SetBenchType(benchType)
 	b.SetCommand(benchOp)
 	b.SetAddress(serverAddr)
-	if err := b.Init(os.Args[4:]); err != nil {
+	if err := b.Init(flags); err != nil {
 		fmt.Printf("Failed to initialize benchmark: %v\n", err)
 		os.Exit(1)
 	}
+	if b.VersionRequested() {
+		fmt.Println(utils.VersionString())
+		return
+	}
 
 	if err := b.Server(); err != nil {
 		fmt.Printf("Failed to run benchmark: %v\n", err)
@@ -11,24 +11,25 @@ import (
 func main() {
 	args := os.Args[1:]
 
-	if len(args) < 3 {
+	benchType, benchOp, serverAddr, flags, ok := utils.SplitArgs(args)
+	if !ok {
 		utils.NewBenchmark().Usage()
 		os.Exit(1)
 	}
 
 	b := utils.NewBenchmark()
 
-	benchType := os.Args[1]
-	benchOp := os.Args[2]
-	serverAddr := os.Args[3]
-
 	b.SetBenchType(benchType)
 	b.SetCommand(benchOp)
 	b.SetAddress(serverAddr)
-	if err := b.Init(os.Args[4:]); err != nil {
+	if err := b.Init(flags); err != nil {
 		fmt.Printf("Failed to initialize benchmark: %v\n", err)
 		os.Exit(1)
 	}
+	if b.VersionRequested() {
+		fmt.Println(utils.VersionString())
+		return
+	}
 
 	tlsLis, err := tlsListen(b.NetworkAddress())
 	if err != nil {
@@ -0,0 +1,40 @@
+package utils
+
+import "net"
+
+// AcceptHook is called by the server for every accepted connection, before
+// the benchmark starts, e.g., to apply custom socket options or to wrap
+// the connection. It returns the connection to benchmark, or an error to
+// reject it, in which case the connection is closed and the server keeps
+// accepting.
+type AcceptHook func(net.Conn) (net.Conn, error)
+
+// AddAcceptHook adds a hook called for every accepted connection. Hooks
+// are called in the order they were added, each on the connection
+// returned by the previous one, after the default hook setting NoDelay on
+// TCP connections.
+func (b *Benchmark) AddAcceptHook(hook AcceptHook) {
+	b.acceptHooks = append(b.acceptHooks, hook)
+}
+
+// setNoDelay is the default accept hook, disabling Nagle's algorithm on
+// TCP connections.
+func setNoDelay(c net.Conn) (net.Conn, error) {
+	if tcpConn, ok := c.(*net.TCPConn); ok {
+		tcpConn.SetNoDelay(true)
+	}
+	return c, nil
+}
+
+// onAccept calls the accept hooks on c, closing it if rejected.
+func (b *Benchmark) onAccept(c net.Conn) (net.Conn, error) {
+	for _, hook := range b.acceptHooks {
+		hooked, err := hook(c)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = hooked
+	}
+	return c, nil
+}
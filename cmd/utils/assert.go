@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// assertionList is a flag.Value collecting the assertions given with
+// repeated -assert flags.
+type assertionList []*benchmarkconn.Assertion
+
+func (l *assertionList) String() string {
+	if l == nil {
+		return ""
+	}
+	exprs := make([]string, 0, len(*l))
+	for _, assertion := range *l {
+		exprs = append(exprs, assertion.Expr)
+	}
+	return strings.Join(exprs, "; ")
+}
+
+func (l *assertionList) Set(expr string) error {
+	assertion, err := benchmarkconn.ParseAssertion(expr)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, assertion)
+	return nil
+}
+
+// evaluateAssertions evaluates the assertions against the result of the
+// benchmark and logs the outcome of each. If the benchmark failed with
+// benchErr, all assertions fail.
+func (b *Benchmark) evaluateAssertions(result map[string]any, benchErr error) ([]benchmarkconn.AssertionResult, error) {
+	if benchErr != nil {
+		outcomes := make([]benchmarkconn.AssertionResult, 0, len(b.assertions))
+		for _, assertion := range b.assertions {
+			outcomes = append(outcomes, benchmarkconn.AssertionResult{
+				Expr:  assertion.Expr,
+				Error: "benchmark failed: " + benchErr.Error(),
+			})
+		}
+		return outcomes, fmt.Errorf("assertions not evaluated, benchmark failed: %w", benchErr)
+	}
+
+	outcomes, passed := benchmarkconn.EvaluateAssertions(b.assertions, result)
+	failed := 0
+	for _, outcome := range outcomes {
+		switch {
+		case outcome.Error != "":
+			slog.Error(fmt.Sprintf("assertion %q failed: %s", outcome.Expr, outcome.Error))
+			failed++
+		case !outcome.Passed:
+			slog.Error(fmt.Sprintf("assertion %q failed", outcome.Expr))
+			failed++
+		default:
+			slog.Info(fmt.Sprintf("assertion %q passed", outcome.Expr))
+		}
+	}
+
+	if !passed {
+		return outcomes, fmt.Errorf("%d of %d assertions failed", failed, len(outcomes))
+	}
+	return outcomes, nil
+}
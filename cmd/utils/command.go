@@ -1,14 +1,23 @@
 package utils
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gaukas/benchmarkconn"
+	"github.com/gaukas/benchmarkconn/otel"
+	"golang.org/x/net/proxy"
 )
 
 const (
@@ -17,14 +26,103 @@ const (
 
 func NewBenchmark() *Benchmark {
 	b := &Benchmark{
-		fs: flag.NewFlagSet("", flag.ContinueOnError),
+		fs:          flag.NewFlagSet("", flag.ContinueOnError),
+		acceptHooks: []AcceptHook{setNoDelay},
 	}
 
-	b.network = b.fs.String("net", defaultNetwork, "network type (tcp, udp, etc)")
+	b.network = b.fs.String("net", defaultNetwork, "network type (tcp, udp, tls, quic, ws, wss, etc)")
 	b.messageSz = b.fs.Int("sz", 1024, "size of the message to send/expect")
-	b.totalMsg = b.fs.Int("m", 1000, "total number of messages to send/expect")
-	b.interval = b.fs.Duration("i", 1*time.Millisecond, "minimal interval between each message, only for echo")
+	b.totalMsg = b.fs.Int("m", 1000, "total number of messages (or probe rounds, or connections for handshake, or messages over all connections for churn) to send/expect")
+	b.targetDuration = b.fs.Duration("target-duration", 0, "send messages for this long instead of a fixed number, overrides -m, only for pressure and echo")
+	b.untilSpec = b.fs.String("until", "", "end the run on the first of comma-separated conditions (messages=<n>, bytes=<n>, duration=<duration>, errors=<n>), overriding -m and -target-duration, must be set on both sides, only for pressure and echo")
+	b.targetBandwidth = b.fs.Float64("target-bw", 0, "offered load in Mbps, paced with a token bucket, 0 for as fast as possible, only for pressure")
+	b.readBuf = b.fs.Int("read-buf", 0, "bytes the reader reads from the connection at once, reassembling the messages and reporting how they were split and coalesced, 0 for one message per read, only for pressure readers")
+	b.histogramSpec = b.fs.String("histogram", "", "bounds and resolution of the latency histograms as comma-separated settings (lowest=<duration>, highest=<duration>, sigfigs=<1-5>, auto to grow beyond highest), depending on -profile if not set, only for pressure and echo")
+	b.hdrLog = b.fs.String("hdr-log", "", "write the latency histogram of every counter tick, or of the whole run without counters, to this file in the HdrHistogram log format, e.g., for HistogramLogProcessor, only for pressure with -timestamps and echo")
+	b.reducerSpec = b.fs.String("reduce", "", "comma-separated reducers adding statistics of the latency to the result as latency_<name>_ns, or of the one-way latency as one_way_latency_<name>_ns, built-in (mean, stddev, percentiles, ewma) or registered with benchmarkconn.RegisterReducer, only for pressure with -timestamps and echo")
+	b.clockSpec = b.fs.String("clock", "", "clock source measuring the latency (monotonic, realtime, phc:<device> for the PTP hardware clock of a NIC), monotonic for echo and realtime for pressure if not set, must be on the same timescale on both sides for pressure, only for pressure with -timestamps and echo")
+	b.referenceClockSpec = b.fs.String("reference-clock", "", "clock source measuring the latency as well as -clock, reporting the divergence of both as clock_divergence_ns, only for echo writers")
+	b.timestamps = b.fs.Bool("timestamps", false, "stamp each message with its send time for the reader to report the one-way latency, requires synchronized clocks, must be set on both sides, only for pressure")
+	b.interval = b.fs.Duration("i", 1*time.Millisecond, "minimal interval between each message, only for echo and deadpeer")
+	b.burst = b.fs.Int("burst", 1, "messages sent back-to-back on each interval tick, only for echo")
+	b.echoWindow = b.fs.Uint64("echo-window", 0, "match echoes by sequence number, tolerating reordered echoes until this many later messages were sent, and report the reorder depth, 0 to match any echo, only for echo writers")
+	b.thinkTimeSpec = b.fs.String("think-time", "", "delay each echo by a think time modeling the work of a server (fixed:<delay>, uniform:<min>-<max>, exponential:<mean>[:<max>], lognormal:<median>:<sigma>[:<max>]), only for echo readers")
 	b.timeout = b.fs.Duration("t", 10*time.Second, "timeout for the benchmark")
+	b.warmupMsg = b.fs.Int("warmup-m", 0, "number of warmup messages excluded from the measurement, only for pressure and echo")
+	b.warmupTime = b.fs.Duration("warmup-t", 0, "duration of the warmup excluded from the measurement, overrides -warmup-m, only for pressure and echo")
+	b.softStart = b.fs.Duration("soft-start", 0, "after the warmup, wait up to this long for the throughput to stabilize before the measurement starts, 0 to disable, must be set on both sides, only for pressure")
+	b.softStartWindow = b.fs.Duration("soft-start-window", benchmarkconn.DefaultSoftStartWindow, "length of each throughput sample of -soft-start")
+	b.softStartTolerance = b.fs.Float64("soft-start-tolerance", benchmarkconn.DefaultSoftStartTolerance, "how far the last samples of -soft-start may lie from their mean, relative to it, for the throughput to be stable")
+	b.version = b.fs.Bool("version", false, "print the version of the binary and exit")
+	b.config = b.fs.String("config", "", "YAML file describing the run, with flag names as keys and type, operation and address, see cmd/README.md")
+	b.output = b.fs.String("o", "", "write the result as JSON to this file, e.g., for cmd/report")
+	b.format = b.fs.String("format", "json", "format of the result file (json, iperf3), iperf3 emits iperf3's JSON schema with intervals every -progress, 1s if not set")
+	b.digits = b.fs.Int("digits", benchmarkconn.DefaultResultDigits, "significant digits floating-point values are rounded to in the result file, 0 for full precision")
+	b.manifest = b.fs.Bool("manifest", false, "write a manifest reproducing the run next to -o, e.g., result.manifest.json, usable as -config")
+	b.seed = b.fs.Int64("seed", 0, "seed of the message sizes drawn with -size-dist and the think times drawn with -think-time, random if 0, recorded by -manifest")
+	b.parallel = b.fs.Int("P", 1, "number of parallel connections to run the benchmark on")
+	b.control = b.fs.Bool("control", false, "use a separate control connection for the handshake and to exchange results with the peer, must be set on both sides")
+	b.adoptSpec = b.fs.Bool("adopt-spec", false, "adopt the spec of the client rather than requiring the same flags on both sides, requires -control, only for server")
+	b.adoptAllow = b.fs.String("adopt-allow", "", "comma-separated spec fields the client may set to other values than the flags of the server with -adopt-spec, by their JSON name, optionally within a range (<name>=<min>..<max>), e.g., message_size=64..65536,target_duration=..1m,verify, any field within built-in limits if not set")
+	b.heartbeat = b.fs.Duration("heartbeat", 15*time.Second, "interval of the heartbeats keeping the control connection from going idle, 0 to disable, only with -control")
+	b.estimate = b.fs.Duration("estimate", 0, "duration of a pressure burst estimating the bandwidth before the run, 0 to disable, only for pressure and echo")
+	b.throughputInterval = b.fs.Duration("throughput-interval", time.Second, "sample the throughput into throughput_intervals of the result at this interval, 0 to disable, only for pressure and echo")
+	b.table = b.fs.Bool("table", false, "log the transfer and bitrate of every -progress interval, 1s if not set, as the rows of an iperf-style table, only for pressure, echo and bidir without -P")
+	b.progress = b.fs.Duration("progress", 0, "log the progress of the run at this interval, 0 to disable, only for pressure and echo")
+	b.soak = b.fs.Duration("soak", 0, "record interim results of a long-running pressure or echo run at this interval, written to -o as they are taken, requires -target-duration")
+	b.estimateTarget = b.fs.Duration("estimate-target", 0, "scale the total number of messages to this run length using the bandwidth estimate, requires -estimate")
+	b.killAfter = b.fs.Duration("kill-after", 3*time.Second, "how long the victim stays alive, only for deadpeer")
+	b.killMode = b.fs.String("kill-mode", benchmarkconn.KillModeClose, "how the victim dies (close, silent), only for deadpeer")
+	b.keepAlive = b.fs.Duration("keepalive", 0, "TCP keepalive period on the survivor, 0 for system default and negative to disable, only for deadpeer")
+	b.idleTimeout = b.fs.Duration("idle-timeout", 0, "how long the survivor waits for the next message, 0 to disable, only for deadpeer")
+	b.churnInterval = b.fs.Duration("churn", time.Second, "how long each data connection is written to before it is torn down and re-established, only for churn")
+
+	b.verify = b.fs.Bool("verify", false, "stamp each message with a sequence number and checksum validated by the reader, only for pressure and echo")
+	b.maxMsgErrors = b.fs.Uint64("max-msg-errors", 0, "messages failing -verify tolerated before the reader fails the run, 0 for unlimited")
+	b.sizeDistSpec = b.fs.String("size-dist", "", "distribution of message sizes overriding -sz (fixed:<size>, uniform:<min>-<max>, lognormal:<median>:<sigma>:<min>-<max>, weighted:<size>=<weight>,...), only for pressure and echo")
+	b.payloadSpec = b.fs.String("payload", "random", "payload of each message (random, zero, pattern:<text>, pattern:0x<hex>, compressible:<ratio>, corpus[:<size>]), only for pressure and echo, see cmd/README.md")
+	b.fs.Var(&b.sinks, "sink", "also write the result, and the interim results of -soak, as JSON to stdout, appended to file:<path>, or POSTed to an http:// or https:// URL, may be repeated")
+	b.fs.Var(&b.assertions, "assert", "threshold assertion on the result, e.g., \"latency_p99_ms < 20 && ops_per_s > 1000\", may be repeated, exits nonzero on failure")
+	b.rampStart = b.fs.Float64("ramp-start", 100, "rate of the first step in messages per second, only for ramp")
+	b.rampFactor = b.fs.Float64("ramp-factor", 2, "factor the rate is multiplied by after each passing step, only for ramp")
+	b.rampStep = b.fs.Duration("ramp-step", time.Second, "duration of each step, only for ramp")
+	b.rampMax = b.fs.Float64("ramp-max", 0, "rate in messages per second at which the ramp stops, 0 for no limit, only for ramp")
+	b.rampSearch = b.fs.Int("ramp-search", 3, "binary search steps after the first failing step, only for ramp")
+	b.rampLatency = b.fs.Duration("ramp-latency", 10*time.Millisecond, "p99 latency above which a step fails, only for ramp")
+	b.rampLoss = b.fs.Float64("ramp-loss", 0.01, "fraction of messages not echoed in time above which a step fails, only for ramp")
+	b.creditWindowList = b.fs.String("credit-windows", "4096,16384,65536,262144,1048576", "comma-separated credit windows in bytes the writer is limited to in turn, only for credit")
+	b.creditStep = b.fs.Duration("credit-step", time.Second, "duration of the step with each credit window, only for credit")
+	b.phaseList = b.fs.String("phases", "", "phases of the phased type as <type>:<operation> pairs, e.g., pressure:write,echo:write,pressure:read, with the operations of the side running write")
+	b.otlpEndpoint = b.fs.String("otlp-endpoint", "", "host:port of an OTLP/HTTP collector to export the run as a span and the counters as metrics to")
+	b.otlpInsecure = b.fs.Bool("otlp-insecure", false, "export to the OTLP/HTTP collector without TLS")
+	b.decorateList = b.fs.String("decorate", "", "decorators wrapping each data connection in order, e.g., meter,flate:6,delay:1ms, among meter, delay:<duration>, ratelimit:<Mbps>, flate[:<level>], pad:<block>[:<rate>[:<size>]], shape:<preset> and tls, see cmd/README.md")
+	b.proxy = b.fs.String("proxy", "", "dial through a proxy, socks5://host:port or http://host:port (HTTP CONNECT), only for clients")
+	b.compare = b.fs.String("compare", "", "address of a second path to the same server, e.g., its IPv6 address, benchmarked concurrently over the same window for a paired comparison, only for clients, run the server with -P 2")
+	b.compareProxy = b.fs.String("compare-proxy", "", "dial the -compare path through a proxy, socks5://host:port or http://host:port, the path is direct if empty")
+	b.tlsServerName = b.fs.String("tls-server-name", "", "server name to verify and send as SNI, only for tls, quic and wss clients")
+	b.tlsCA = b.fs.String("tls-ca", "", "PEM CA bundle to verify the server with, or on the server to require client certificates (mTLS), only for tls, quic and wss")
+	b.tlsCert = b.fs.String("tls-cert", "", "PEM certificate to present, a self-signed one is generated for servers if empty, only for tls, quic and wss")
+	b.tlsKey = b.fs.String("tls-key", "", "PEM private key of -tls-cert, only for tls, quic and wss")
+	b.tlsInsecure = b.fs.Bool("tls-insecure", false, "skip verifying the server certificate, only for tls, quic and wss clients")
+	b.upstream = b.fs.String("upstream", "", "address of the server each connection is forwarded to, only for relay")
+	b.sndbuf = b.fs.Int("sndbuf", 0, "SO_SNDBUF size in bytes of the benchmarked TCP or UDP connections, 0 for the OS default")
+	b.rcvbuf = b.fs.Int("rcvbuf", 0, "SO_RCVBUF size in bytes of the benchmarked TCP or UDP connections, 0 for the OS default")
+	b.tcpInfo = b.fs.Bool("tcpinfo", false, "record TCP_INFO (rtt, cwnd, retransmits, delivery rate) every second, Linux TCP only")
+	b.gcCounter = b.fs.Bool("gc", false, "record the number of goroutines, GC cycles and GC pause time every second, to reveal goroutine leaks and GC pressure")
+	b.cpuFreq = b.fs.Bool("cpufreq", false, "record the CPU frequency, thermal throttle events and temperature every second and annotate the result if the CPU was throttled, Linux only")
+	b.energy = b.fs.String("energy", "", "estimate the energy consumed over the run and per GB transferred, from the RAPL counters of the CPU packages (rapl), the CPU time of the process at -watts-per-core (model), or RAPL where readable and the model otherwise (auto)")
+	b.wattsPerCore = b.fs.Float64("watts-per-core", benchmarkconn.DefaultWattsPerCore, "power in watts drawn by a fully busy core, only for -energy model")
+	b.runtimeMetrics = b.fs.String("runtime-metrics", "", "record comma-separated runtime/metrics keys every second, or \"default\" for the scheduler latency, GC cycles and memory classes")
+	b.counterSamples = b.fs.Int("counter-samples", 0, "keep only the latest this many samples of each counter, e.g., -tcpinfo, 0 to keep all")
+	b.counterBucket = b.fs.Duration("counter-bucket", 0, "downsample each counter to its latest sample within buckets of this length, e.g., 1m for soak tests, 0 to keep every sample")
+	b.fs.TextVar(&b.profile, "profile", benchmarkconn.ProfileDefault, "resource footprint profile (default, constrained), use constrained on low-power devices")
+	b.fs.TextVar(&b.pacing, "pacing", benchmarkconn.PacingTicker, "how the writer waits for each interval (ticker, spin, auto), auto spins if -i is below the timer resolution, only for echo")
+
+	b.fs.IntVar(&b.runtimeConfig.GOMAXPROCS, "gomaxprocs", 0, "GOMAXPROCS for the run, 0 to leave unchanged")
+	b.fs.StringVar(&b.runtimeConfig.GOGC, "gogc", "", "GC target percentage for the run (or \"off\"), empty to leave unchanged")
+	b.fs.Int64Var(&b.runtimeConfig.MemoryLimit, "memlimit", 0, "soft memory limit in bytes for the run, 0 to leave unchanged")
+	b.fs.BoolVar(&b.runtimeConfig.AsyncPreemptOff, "asyncpreemptoff", false, "disable asynchronous goroutine preemption (restarts the process with GODEBUG)")
+	b.fs.StringVar(&b.runtimeConfig.GODEBUG, "godebug", "", "additional comma-separated GODEBUG settings (restarts the process with GODEBUG)")
 
 	return b
 }
@@ -38,11 +136,140 @@ type Benchmark struct {
 
 	network *string
 
-	messageSz *int
-	totalMsg  *int
-
-	interval *time.Duration
-	timeout  *time.Duration
+	messageSz          *int
+	totalMsg           *int
+	targetDuration     *time.Duration
+	untilSpec          *string
+	until              *benchmarkconn.Completion
+	targetBandwidth    *float64
+	timestamps         *bool
+	throughputInterval *time.Duration
+	histogramSpec      *string
+	histogram          *benchmarkconn.HistogramSettings
+	hdrLog             *string
+	histogramLog       *benchmarkconn.HistogramLogWriter
+	reducerSpec        *string
+	reducers           []string
+	clockSpec          *string
+	clock              benchmarkconn.ClockSource
+	referenceClockSpec *string
+	referenceClock     benchmarkconn.ClockSource
+	readBuf            *int
+
+	softStart          *time.Duration
+	softStartWindow    *time.Duration
+	softStartTolerance *float64
+
+	interval      *time.Duration
+	burst         *int
+	echoWindow    *uint64
+	thinkTimeSpec *string
+	thinkTime     *benchmarkconn.DelayDistribution
+	timeout       *time.Duration
+	parallel      *int
+	output        *string
+	format        *string
+	digits        *int
+	iperf3        iperf3Recorder
+	manifest      *bool
+	seed          *int64
+
+	control        *bool
+	heartbeat      *time.Duration
+	controlChannel *benchmarkconn.ControlChannel
+	adoptSpec      *bool
+	adoptAllow     *string
+	adoptAllowed   map[string]benchmarkconn.SpecRange
+
+	estimate       *time.Duration
+	estimateTarget *time.Duration
+
+	warmupMsg  *int
+	warmupTime *time.Duration
+
+	killAfter   *time.Duration
+	killMode    *string
+	keepAlive   *time.Duration
+	idleTimeout *time.Duration
+
+	churnInterval *time.Duration
+
+	verify       *bool
+	maxMsgErrors *uint64
+	sizeDistSpec *string
+	sizeDist     *benchmarkconn.SizeDistribution
+	payloadSpec  *string
+	payload      benchmarkconn.PayloadGenerator
+
+	rampStart   *float64
+	rampFactor  *float64
+	rampStep    *time.Duration
+	rampMax     *float64
+	rampSearch  *int
+	rampLatency *time.Duration
+	rampLoss    *float64
+
+	creditWindowList *string
+	creditWindows    []int
+	creditStep       *time.Duration
+
+	phaseList *string
+	phases    []phaseSpec
+
+	progress *time.Duration
+	table    *bool
+	soak     *time.Duration
+	interim  interimRecorder
+	config   *string
+	version  *bool
+
+	otlpEndpoint *string
+	otlpInsecure *bool
+	otelExporter *otel.Exporter
+
+	proxy       *string
+	proxyDialer proxy.Dialer
+
+	compare            *string
+	compareProxy       *string
+	compareProxyDialer proxy.Dialer
+
+	listener net.Listener // the listener of the server, accepting more connections for handshake and churn
+
+	tlsServerName *string
+	tlsCA         *string
+	tlsCert       *string
+	tlsKey        *string
+	tlsInsecure   *bool
+
+	acceptHooks []AcceptHook
+
+	sndbuf *int
+	rcvbuf *int
+
+	upstream  *string
+	relayMode benchmarkconn.RelayMode
+
+	decorateList *string
+	decorators   []decoratorSpec
+	decoratorTLS *tls.Config
+
+	tcpInfo        *bool
+	runtimeMetrics *string
+	gcCounter      *bool
+	cpuFreq        *bool
+	energy         *string
+	energySource   benchmarkconn.EnergySource
+	wattsPerCore   *float64
+	counterSamples *int
+	counterBucket  *time.Duration
+
+	assertions assertionList
+	sinks      sinkList
+
+	profile       benchmarkconn.Profile
+	pacing        benchmarkconn.Pacing
+	runtimeConfig benchmarkconn.RuntimeConfig
 }
 
 func (b *Benchmark) Address() string {
@@ -59,11 +286,30 @@ func (b *Benchmark) Command() string {
 
 func (b *Benchmark) Usage() {
 	fmt.Println("Example: <client|server> <type> <operation> <server_addr> [arguments...]")
-	fmt.Printf("- Possible <type>: pressure, echo\n")
-	fmt.Printf("- Possible <operation>: write, read\n\n")
+	fmt.Println("     or: <client|server> -config <config.yaml> [arguments...]")
+	fmt.Println("     or: <client|server> schema [-o <file>], to write the JSON Schema of the result files")
+	fmt.Println("     or: <client|server> selftest [<type>] [arguments...], to run both sides in this process for a baseline")
+	fmt.Printf("- Possible <type>: pressure, echo, bidir, ramp, credit, tinywrite, deadpeer, handshake, churn, phased, relay (server only)\n")
+	if registered := benchmarkconn.Registered(); len(registered) > 0 {
+		fmt.Printf("- Registered <type>: %s\n", strings.Join(registered, ", "))
+	}
+	fmt.Printf("- Possible <operation>: write, read, or copy, splice for relay\n\n")
 	b.fs.Usage()
 }
 
+// VersionRequested reports whether -version was set, in which case Init
+// returns right after parsing the flags and the caller should print
+// VersionString instead of running the benchmark.
+func (b *Benchmark) VersionRequested() bool {
+	return *b.version
+}
+
+// VersionString describes the binary: the version of benchmarkconn, the Go
+// version and the platform it was built for.
+func VersionString() string {
+	return fmt.Sprintf("benchmarkconn %s %s %s/%s", benchmarkconn.Version(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
 func (b *Benchmark) SetBenchType(benchType string) {
 	b.benchType = benchType
 }
@@ -80,7 +326,307 @@ func (b *Benchmark) Init(args []string) error {
 	if err := b.fs.Parse(args); err != nil {
 		return err
 	}
+	if *b.version {
+		return nil // nothing else to initialize, see VersionRequested
+	}
+
+	if *b.config != "" {
+		if err := b.loadConfig(*b.config); err != nil {
+			return err
+		}
+	}
+	if b.benchType == "" || b.command == "" || b.addr == "" {
+		return errors.New("missing <type> <operation> <server_addr>, either as arguments or in -config")
+	}
+
+	payload, err := benchmarkconn.ParsePayloadGenerator(*b.payloadSpec)
+	if err != nil {
+		return err
+	}
+	b.payload = payload
+
+	if *b.sizeDistSpec != "" {
+		sizeDist, err := benchmarkconn.ParseSizeDistribution(*b.sizeDistSpec)
+		if err != nil {
+			return err
+		}
+		b.sizeDist = sizeDist
 
+		// resolve the seed, so the run can be reproduced with it
+		if *b.seed == 0 {
+			b.fs.Set("seed", strconv.FormatInt(time.Now().UnixNano(), 10))
+		}
+		b.sizeDist.Seed = *b.seed
+	}
+	if *b.untilSpec != "" {
+		if b.benchType != "pressure" && b.benchType != "echo" {
+			return errors.New("until is only supported for pressure and echo")
+		}
+		until, err := benchmarkconn.ParseCompletion(*b.untilSpec)
+		if err != nil {
+			return err
+		}
+		if until.Errors > 0 && !*b.verify {
+			return errors.New("until errors requires -verify")
+		}
+		b.until = until
+	}
+	if *b.histogramSpec != "" {
+		if b.benchType != "pressure" && b.benchType != "echo" {
+			return errors.New("histogram is only supported for pressure and echo")
+		}
+		histogram, err := benchmarkconn.ParseHistogramSettings(*b.histogramSpec)
+		if err != nil {
+			return err
+		}
+		b.histogram = histogram
+	}
+	if *b.hdrLog != "" && b.benchType != "echo" && (b.benchType != "pressure" || !*b.timestamps) {
+		return errors.New("hdr-log is only supported for pressure with -timestamps and echo")
+	}
+	if *b.reducerSpec != "" {
+		if b.benchType != "echo" && (b.benchType != "pressure" || !*b.timestamps) {
+			return errors.New("reduce is only supported for pressure with -timestamps and echo")
+		}
+		b.reducers = nil
+		for _, name := range strings.Split(*b.reducerSpec, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if _, ok := benchmarkconn.NewRegisteredReducer(name); !ok {
+				return fmt.Errorf("unknown reducer %q, registered: %s", name, strings.Join(benchmarkconn.RegisteredReducers(), ", "))
+			}
+			b.reducers = append(b.reducers, name)
+		}
+	}
+	if *b.clockSpec != "" {
+		if b.benchType != "echo" && (b.benchType != "pressure" || !*b.timestamps) {
+			return errors.New("clock is only supported for pressure with -timestamps and echo")
+		}
+		clock, err := benchmarkconn.ParseClockSource(*b.clockSpec)
+		if err != nil {
+			return err
+		}
+		b.clock = clock
+	}
+	if *b.referenceClockSpec != "" {
+		if b.benchType != "echo" {
+			return errors.New("reference-clock is only supported for echo")
+		}
+		clock, err := benchmarkconn.ParseClockSource(*b.referenceClockSpec)
+		if err != nil {
+			return err
+		}
+		b.referenceClock = clock
+	}
+	if *b.adoptSpec {
+		if !*b.control {
+			return errors.New("adopt-spec requires -control")
+		}
+		if *b.parallel != 1 || b.benchType == "phased" {
+			return errors.New("adopt-spec does not support -P or phased")
+		}
+	}
+	if *b.adoptAllow != "" {
+		if !*b.adoptSpec {
+			return errors.New("adopt-allow requires -adopt-spec")
+		}
+		allowed, err := benchmarkconn.ParseSpecAllowlist(*b.adoptAllow)
+		if err != nil {
+			return err
+		}
+		b.adoptAllowed = allowed
+	}
+	if *b.energy != "" {
+		source, err := benchmarkconn.ParseEnergySource(*b.energy)
+		if err != nil {
+			return err
+		}
+		b.energySource = source
+	}
+	if *b.wattsPerCore <= 0 {
+		return errors.New("watts-per-core must be positive")
+	}
+	switch *b.format {
+	case "json":
+	case "iperf3":
+		if *b.output == "" {
+			return errors.New("format iperf3 requires -o")
+		}
+		if b.benchType == "relay" {
+			return errors.New("format iperf3 is not supported for relay")
+		}
+		if *b.compare != "" {
+			return errors.New("format iperf3 does not support -compare")
+		}
+		if *b.soak > 0 {
+			return errors.New("format iperf3 does not support -soak")
+		}
+	default:
+		return fmt.Errorf("unknown result format %q, must be json or iperf3", *b.format)
+	}
+	if *b.digits < 0 {
+		return errors.New("digits must not be negative")
+	}
+	if *b.manifest && *b.output == "" {
+		return errors.New("manifest requires -o")
+	}
+
+	if *b.soak < 0 {
+		return fmt.Errorf("soak interval must not be negative, got %s", *b.soak)
+	}
+	if *b.soak > 0 {
+		if b.benchType != "pressure" && b.benchType != "echo" {
+			return errors.New("soak is only supported for pressure and echo")
+		}
+		if *b.targetDuration <= 0 {
+			return errors.New("soak requires -target-duration")
+		}
+		if *b.parallel != 1 {
+			return errors.New("soak does not support -P")
+		}
+	}
+
+	if *b.targetDuration > 0 && *b.targetDuration >= *b.timeout {
+		slog.Warn(fmt.Sprintf("target duration of %s is not shorter than the timeout of %s, the run will be cut short", *b.targetDuration, *b.timeout))
+	}
+
+	if b.benchType == "echo" && b.command == "write" && *b.interval < benchmarkconn.TimerResolution() {
+		switch b.pacing {
+		case benchmarkconn.PacingTicker:
+			slog.Warn(fmt.Sprintf("interval of %s is below the timer resolution of %s, the run will measure the timer rather than the network, consider -pacing spin", *b.interval, benchmarkconn.TimerResolution()))
+		case benchmarkconn.PacingAuto:
+			slog.Info(fmt.Sprintf("interval of %s is below the timer resolution of %s, spinning", *b.interval, benchmarkconn.TimerResolution()))
+		}
+	}
+
+	if *b.proxy != "" {
+		dialer, err := newProxyDialer(*b.proxy)
+		if err != nil {
+			return err
+		}
+		b.proxyDialer = dialer
+	}
+
+	decorators, err := parseDecorators(*b.decorateList)
+	if err != nil {
+		return err
+	}
+	b.decorators = decorators
+
+	if b.benchType == "credit" {
+		windows, err := parseCreditWindows(*b.creditWindowList)
+		if err != nil {
+			return err
+		}
+		b.creditWindows = windows
+	}
+
+	if b.benchType == "phased" {
+		phases, err := parsePhases(*b.phaseList)
+		if err != nil {
+			return err
+		}
+		b.phases = phases
+	}
+
+	if *b.parallel < 1 {
+		return fmt.Errorf("number of parallel connections must be at least 1, got %d", *b.parallel)
+	}
+	if (b.benchType == "handshake" || b.benchType == "churn") && *b.parallel != 1 {
+		return fmt.Errorf("%s dials connections one after another and does not support -P", b.benchType)
+	}
+	if *b.targetBandwidth < 0 {
+		return fmt.Errorf("target bandwidth must not be negative, got %g", *b.targetBandwidth)
+	}
+	if b.benchType == "relay" {
+		if err := b.validateRelay(); err != nil {
+			return err
+		}
+	}
+	if *b.maxMsgErrors > 0 && !*b.verify {
+		return errors.New("max-msg-errors requires -verify")
+	}
+	if *b.compare != "" || *b.compareProxy != "" {
+		if err := b.validateCompare(); err != nil {
+			return err
+		}
+	}
+	if *b.sndbuf < 0 || *b.rcvbuf < 0 {
+		return fmt.Errorf("socket buffer sizes must not be negative, got %d and %d", *b.sndbuf, *b.rcvbuf)
+	}
+	if *b.timestamps && b.benchType != "pressure" {
+		return errors.New("timestamps is only supported for pressure, echo measures the round-trip latency")
+	}
+	if *b.softStart < 0 || *b.softStartWindow <= 0 || *b.softStartTolerance <= 0 {
+		return errors.New("soft-start must not be negative, and its window and tolerance must be positive")
+	}
+	if *b.softStart > 0 && b.benchType != "pressure" {
+		return errors.New("soft-start is only supported for pressure")
+	}
+	if *b.readBuf < 0 {
+		return fmt.Errorf("read buffer size must not be negative, got %d", *b.readBuf)
+	}
+	if *b.readBuf > 0 && b.benchType != "pressure" {
+		return errors.New("read-buf is only supported for pressure")
+	}
+	if *b.echoWindow > 0 && b.benchType != "echo" {
+		return errors.New("echo-window is only supported for echo")
+	}
+	if *b.thinkTimeSpec != "" {
+		if b.benchType != "echo" {
+			return errors.New("think-time is only supported for echo")
+		}
+		thinkTime, err := benchmarkconn.ParseDelayDistribution(*b.thinkTimeSpec)
+		if err != nil {
+			return err
+		}
+		// resolve the seed, so the run can be reproduced with it
+		if *b.seed == 0 {
+			b.fs.Set("seed", strconv.FormatInt(time.Now().UnixNano(), 10))
+		}
+		thinkTime.Seed = *b.seed
+		b.thinkTime = thinkTime
+	}
+	if *b.counterSamples < 0 || *b.counterBucket < 0 {
+		return errors.New("counter samples and bucket must not be negative")
+	}
+	if *b.throughputInterval < 0 {
+		return fmt.Errorf("throughput interval must not be negative, got %s", *b.throughputInterval)
+	}
+	if *b.table && *b.parallel != 1 {
+		return errors.New("table does not support -P")
+	}
+	if *b.burst < 1 {
+		return fmt.Errorf("burst size must be at least 1, got %d", *b.burst)
+	}
+
+	// GODEBUG settings only take effect at process start
+	pending, err := b.runtimeConfig.GODEBUGPending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		if err := reexecWithGODEBUG(pending); err != nil {
+			return err
+		}
+	}
+
+	if err := b.runtimeConfig.Apply(); err != nil {
+		return err
+	}
+
+	// created last, so that no error above leaks the exporter
+	if *b.otlpEndpoint != "" {
+		exporter, err := otel.NewOTLPExporter(context.Background(), otel.Config{
+			Endpoint: *b.otlpEndpoint,
+			Insecure: *b.otlpInsecure,
+		})
+		if err != nil {
+			return err
+		}
+		b.otelExporter = exporter
+	}
 	return nil
 }
 
@@ -95,21 +641,23 @@ func (b *Benchmark) Client() error {
 		b.Usage()
 		return nil
 	}
+	if *b.adoptSpec {
+		return errors.New("adopt-spec is only supported for server")
+	}
 
-	switch b.benchType {
-	case "pressure":
-		b.pressuredBenchmarkClient(writeBench)
-	case "echo":
-		b.echoBenchmarkClient(writeBench)
-	default:
+	if b.newBenchmark() == nil {
 		b.Usage()
 		return nil
 	}
 
-	return nil
+	return b.benchmarkClient(writeBench)
 }
 
 func (b *Benchmark) Server() error {
+	if b.benchType == "relay" {
+		return b.relayServer()
+	}
+
 	var writeBench bool
 	switch b.command {
 	case "write":
@@ -121,17 +669,12 @@ func (b *Benchmark) Server() error {
 		return nil
 	}
 
-	switch b.benchType {
-	case "pressure":
-		b.pressuredBenchmarkServer(writeBench)
-	case "echo":
-		b.echoBenchmarkServer(writeBench)
-	default:
+	if b.newBenchmark() == nil {
 		b.Usage()
 		return nil
 	}
 
-	return nil
+	return b.benchmarkServer(writeBench)
 }
 
 func (b *Benchmark) NetworkAddress() (string, string) {
@@ -150,227 +693,745 @@ func (b *Benchmark) ServerWithListener(l net.Listener) error {
 		return nil
 	}
 
-	switch b.benchType {
-	case "pressure":
-		b.pressuredBenchmarkServerWithListener(l, writeBench)
-	case "echo":
-		b.echoBenchmarkServerWithListener(l, writeBench)
-	default:
+	if b.newBenchmark() == nil {
 		b.Usage()
 		return nil
 	}
 
-	return nil
+	return b.benchmarkServerWithListener(l, writeBench)
 }
 
-func (b *Benchmark) pressuredBenchmarkClient(write bool) {
-	// dial the remote address
-	c, err := net.Dial(*b.network, b.addr)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to dial %s: %v\n", b.addr, err))
-		return
+// newBenchmark creates the benchmark selected by the bench type from the
+// parsed flags. It returns nil if the bench type is unknown.
+func (b *Benchmark) newBenchmark() benchmarkconn.Benchmark {
+	// parallel benchmarks, or those of compared paths, would interleave
+	// their handshakes on the control channel, so they keep the handshake
+	// in-band
+	var control *benchmarkconn.ControlChannel
+	if *b.parallel == 1 && *b.compare == "" {
+		control = b.controlChannel
 	}
 
-	wg := new(sync.WaitGroup)
-	wg.Add(1)
-	go func() {
-		defer c.Close()
-		defer wg.Done()
+	if b.benchType == "phased" {
+		return b.newPhasedBenchmark(control)
+	}
+	return b.newBenchmarkOfType(b.benchType, control)
+}
+
+// adoptPeerSpec adopts the spec the client sends over the control channel
+// for bench, within the fields allowed by -adopt-allow if set, returning
+// the fields which differed from the flags of the server.
+func (b *Benchmark) adoptPeerSpec(bench benchmarkconn.Benchmark) ([]string, error) {
+	msg, err := b.controlChannel.PeekSpec()
+	if err != nil {
+		return nil, err
+	}
+	adopted, err := benchmarkconn.AdoptSpec(bench, msg, b.adoptAllowed)
+	if err != nil {
+		return nil, err
+	}
+	if len(adopted) > 0 {
+		slog.Info(fmt.Sprintf("adopted the spec of the client, differing in %s", strings.Join(adopted, ", ")))
+	}
+	return adopted, nil
+}
 
-		pb := &benchmarkconn.PressuredBenchmark{
+// newBenchmarkOfType creates a benchmark of the given type from the parsed
+// flags, or one registered with benchmarkconn.Register under that name if
+// it is not a built-in type. It returns nil if the type is unknown.
+func (b *Benchmark) newBenchmarkOfType(benchType string, control *benchmarkconn.ControlChannel) benchmarkconn.Benchmark {
+	switch benchType {
+	case "pressure":
+		return &benchmarkconn.PressuredBenchmark{
+			MessageSize:        *b.messageSz,
+			TotalMessages:      uint64(*b.totalMsg),
+			WarmupMessages:     uint64(*b.warmupMsg),
+			WarmupDuration:     *b.warmupTime,
+			Verify:             *b.verify,
+			MaxMessageErrors:   *b.maxMsgErrors,
+			TargetDuration:     *b.targetDuration,
+			SizeDistribution:   b.sizeDist,
+			TargetBandwidth:    uint64(*b.targetBandwidth * 1e6 / 8),
+			Timestamps:         *b.timestamps,
+			Completion:         b.until,
+			SoftStart:          b.newSoftStart(),
+			ReadBufferSize:     *b.readBuf,
+			Payload:            b.payload,
+			Profile:            b.profile,
+			Histogram:          b.histogram,
+			HistogramLog:       b.histogramLog,
+			Reducers:           b.newReducers(),
+			Clock:              b.clock,
+			ThroughputInterval: *b.throughputInterval,
+			OnProgress:         b.onProgress(),
+			ProgressInterval:   b.progressInterval(),
+			Control:            control,
+		}
+	case "echo":
+		return &benchmarkconn.IntervalBenchmark{
+			MessageSize:        *b.messageSz,
+			TotalMessages:      uint64(*b.totalMsg),
+			Interval:           *b.interval,
+			Echo:               true,
+			BurstSize:          uint64(*b.burst),
+			WarmupMessages:     uint64(*b.warmupMsg),
+			WarmupDuration:     *b.warmupTime,
+			Verify:             *b.verify,
+			MaxMessageErrors:   *b.maxMsgErrors,
+			TargetDuration:     *b.targetDuration,
+			SizeDistribution:   b.sizeDist,
+			Completion:         b.until,
+			Payload:            b.payload,
+			Profile:            b.profile,
+			Histogram:          b.histogram,
+			HistogramLog:       b.histogramLog,
+			Reducers:           b.newReducers(),
+			Clock:              b.clock,
+			ReferenceClock:     b.referenceClock,
+			ThroughputInterval: *b.throughputInterval,
+			Pacing:             b.pacing,
+			EchoWindow:         *b.echoWindow,
+			ThinkTime:          b.thinkTime,
+			OnProgress:         b.onProgress(),
+			ProgressInterval:   b.progressInterval(),
+			Control:            control,
+		}
+	case "bidir":
+		return &benchmarkconn.BidirectionalBenchmark{
+			MessageSize:      *b.messageSz,
+			TotalMessages:    uint64(*b.totalMsg),
+			Payload:          b.payload,
+			Profile:          b.profile,
+			OnProgress:       b.onProgress(),
+			ProgressInterval: b.progressInterval(),
+			Control:          control,
+		}
+	case "ramp":
+		return &benchmarkconn.RampBenchmark{
+			MessageSize:      *b.messageSz,
+			StartRate:        *b.rampStart,
+			StepFactor:       *b.rampFactor,
+			StepDuration:     *b.rampStep,
+			MaxRate:          *b.rampMax,
+			SearchSteps:      *b.rampSearch,
+			LatencyThreshold: *b.rampLatency,
+			LossThreshold:    *b.rampLoss,
+			Control:          control,
+		}
+	case "credit":
+		return &benchmarkconn.CreditBenchmark{
+			MessageSize:  *b.messageSz,
+			Windows:      b.creditWindows,
+			StepDuration: *b.creditStep,
+			Control:      control,
+		}
+	case "tinywrite":
+		return &benchmarkconn.TinyWriteProbe{
+			MessageSize: *b.messageSz,
+			Rounds:      uint64(*b.totalMsg),
+		}
+	case "handshake":
+		bench := &benchmarkconn.HandshakeBenchmark{TotalConnections: uint64(*b.totalMsg)}
+		if b.listener != nil {
+			bench.Accept = func() (net.Conn, error) { return b.acceptDecorated(b.listener, false) }
+		} else {
+			bench.Dial = func() (net.Conn, error) { return b.dialDecorated(b.primaryTarget(), false) }
+		}
+		return bench
+	case "churn":
+		bench := &benchmarkconn.ChurnBenchmark{
 			MessageSize:   *b.messageSz,
 			TotalMessages: uint64(*b.totalMsg),
+			ChurnInterval: *b.churnInterval,
 		}
-
-		if write {
-			if err := pb.Writer(c); err != nil {
-				slog.Error(fmt.Sprintf("(*PressuredBenchmark).Writer: %v", err))
-				return
-			}
+		if b.listener != nil {
+			bench.Accept = func() (net.Conn, error) { return b.acceptDecorated(b.listener, false) }
 		} else {
-			if err := pb.Reader(c); err != nil {
-				slog.Error(fmt.Sprintf("(*PressuredBenchmark).Reader: %v", err))
-				return
-			}
+			bench.Dial = func() (net.Conn, error) { return b.dialDecorated(b.primaryTarget(), false) }
 		}
+		return bench
+	case "deadpeer":
+		return &benchmarkconn.DeadPeerBenchmark{
+			MessageSize: *b.messageSz,
+			Interval:    *b.interval,
+			KillAfter:   *b.killAfter,
+			KillMode:    *b.killMode,
+			KeepAlive:   *b.keepAlive,
+			IdleTimeout: *b.idleTimeout,
+		}
+	default:
+		// custom benchmarks configure themselves, the flags above do not
+		// apply to them
+		if bench, ok := benchmarkconn.NewRegistered(benchType); ok {
+			return bench
+		}
+		return nil
+	}
+}
 
-		slog.Info(fmt.Sprintf("PressuredBenchmark Result: %v", pb.Result()))
-	}()
-
-	go func() {
-		<-time.After(*b.timeout)
-		slog.Warn("timed out, closing the connection")
-		c.Close()
-	}()
+// newReducers creates the reducers of -reduce, new ones for each
+// benchmark since reducers must not be shared.
+func (b *Benchmark) newReducers() []benchmarkconn.Reducer {
+	var reducers []benchmarkconn.Reducer
+	for _, name := range b.reducers {
+		if reducer, ok := benchmarkconn.NewRegisteredReducer(name); ok {
+			reducers = append(reducers, reducer)
+		}
+	}
+	return reducers
+}
 
-	wg.Wait()
+// newSoftStart returns the soft start of -soft-start, nil if disabled.
+func (b *Benchmark) newSoftStart() *benchmarkconn.SoftStart {
+	if *b.softStart <= 0 {
+		return nil
+	}
+	return &benchmarkconn.SoftStart{
+		Window:    *b.softStartWindow,
+		Tolerance: *b.softStartTolerance,
+		Timeout:   *b.softStart,
+	}
 }
 
-func (b *Benchmark) echoBenchmarkClient(write bool) {
-	// dial the remote address
-	c, err := net.Dial(*b.network, b.addr)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to dial %s: %v\n", b.addr, err))
-		return
+// parseCreditWindows parses the comma-separated credit windows of
+// -credit-windows.
+func parseCreditWindows(list string) ([]int, error) {
+	var windows []int
+	for _, field := range strings.Split(list, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid credit window %q, must be a positive number of bytes", field)
+		}
+		windows = append(windows, window)
 	}
+	return windows, nil
+}
 
-	wg := new(sync.WaitGroup)
-	wg.Add(1)
-	go func() {
-		defer c.Close()
-		defer wg.Done()
+// benchmarkName returns the name of the benchmark used in log messages.
+func benchmarkName(bench benchmarkconn.Benchmark) string {
+	switch bench.(type) {
+	case *benchmarkconn.PressuredBenchmark:
+		return "PressuredBenchmark"
+	case *benchmarkconn.IntervalBenchmark:
+		return "IntervalBenchmark"
+	case *benchmarkconn.BidirectionalBenchmark:
+		return "BidirectionalBenchmark"
+	case *benchmarkconn.RampBenchmark:
+		return "RampBenchmark"
+	case *benchmarkconn.CreditBenchmark:
+		return "CreditBenchmark"
+	case *benchmarkconn.TinyWriteProbe:
+		return "TinyWriteProbe"
+	case *benchmarkconn.DeadPeerBenchmark:
+		return "DeadPeerBenchmark"
+	case *benchmarkconn.HandshakeBenchmark:
+		return "HandshakeBenchmark"
+	case *benchmarkconn.ChurnBenchmark:
+		return "ChurnBenchmark"
+	case *benchmarkconn.PhasedBenchmark:
+		return "PhasedBenchmark"
+	default:
+		return fmt.Sprintf("%T", bench)
+	}
+}
 
-		ib := &benchmarkconn.IntervalBenchmark{
-			MessageSize:   *b.messageSz,
-			TotalMessages: uint64(*b.totalMsg),
-			Interval:      *b.interval,
-			Echo:          true,
+// dial dials the target address over the selected network, through the
+// proxy of the target if any.
+func (b *Benchmark) dial(target dialTarget) (net.Conn, error) {
+	switch *b.network {
+	case tlsNetwork, quicNetwork, wssNetwork:
+		tlsConfig, err := b.clientTLSConfig()
+		if err != nil {
+			return nil, err
 		}
-
-		if write {
-			if err := ib.Writer(c); err != nil {
-				slog.Error(fmt.Sprintf("(*IntervalBenchmark).Writer: %v", err))
-				return
+		switch *b.network {
+		case quicNetwork:
+			if target.proxyDialer != nil {
+				return nil, errors.New("proxy is not supported with quic")
 			}
-		} else {
-			if err := ib.Reader(c); err != nil {
-				slog.Error(fmt.Sprintf("(*IntervalBenchmark).Reader: %v", err))
-				return
+			return quicDial(target.addr, tlsConfig)
+		case wssNetwork:
+			return wsDial(target.addr, tlsConfig, target.proxyDialer)
+		default:
+			conn, err := b.dialTCP(target)
+			if err != nil {
+				return nil, err
 			}
+			return tlsClient(conn, target.addr, tlsConfig)
 		}
+	case wsNetwork:
+		return wsDial(target.addr, nil, target.proxyDialer)
+	default:
+		if target.proxyDialer != nil && *b.network != defaultNetwork {
+			return nil, fmt.Errorf("proxy is not supported with %s", *b.network)
+		}
+		if target.proxyDialer != nil {
+			return target.proxyDialer.Dial(*b.network, target.addr)
+		}
+		return net.Dial(*b.network, target.addr)
+	}
+}
 
-		slog.Info(fmt.Sprintf("EchoBenchmark Result: %v", ib.Result()))
-	}()
+// dialTCP dials the target address over TCP, through the proxy if any.
+func (b *Benchmark) dialTCP(target dialTarget) (net.Conn, error) {
+	if target.proxyDialer != nil {
+		return target.proxyDialer.Dial(defaultNetwork, target.addr)
+	}
+	return net.Dial(defaultNetwork, target.addr)
+}
 
-	go func() {
-		<-time.After(*b.timeout)
-		slog.Warn("timed out, closing the connection")
-		c.Close()
-	}()
+// listen listens on the server address over the selected network.
+func (b *Benchmark) listen() (net.Listener, error) {
+	switch *b.network {
+	case tlsNetwork, quicNetwork, wssNetwork:
+		tlsConfig, err := b.serverTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		switch *b.network {
+		case quicNetwork:
+			return quicListen(b.addr, tlsConfig)
+		case wssNetwork:
+			return wsListen(b.addr, tlsConfig)
+		default:
+			return tls.Listen(defaultNetwork, b.addr, tlsConfig)
+		}
+	case wsNetwork:
+		return wsListen(b.addr, nil)
+	default:
+		return net.Listen(*b.network, b.addr)
+	}
+}
 
-	wg.Wait()
+// totalConns returns the number of connections to establish, the control
+// connection being the first one if enabled.
+func (b *Benchmark) totalConns() int {
+	conns := *b.parallel
+	if *b.compare != "" {
+		conns = 2 // one over each path
+	}
+	if *b.control {
+		return conns + 1
+	}
+	return conns
 }
 
-func (b *Benchmark) pressuredBenchmarkServer(write bool) {
-	// listen on the specified address
-	l, err := net.Listen(*b.network, b.addr)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to listen on %s: %v\n", b.addr, err))
-		return
+// isControlConn reports whether the i-th connection dialed or accepted is
+// the control connection.
+func (b *Benchmark) isControlConn(i int) bool {
+	return *b.control && i == 0
+}
+
+func (b *Benchmark) benchmarkClient(write bool) error {
+	// dial the remote address, once for each parallel connection and the
+	// control connection if any, the last connection taking the compared
+	// path if any
+	conns := make([]net.Conn, 0, b.totalConns())
+	for len(conns) < b.totalConns() {
+		target := b.primaryTarget()
+		if *b.compare != "" && len(conns) == b.totalConns()-1 {
+			target = b.compareTarget()
+		}
+		c, err := b.dialDecorated(target, b.isControlConn(len(conns)))
+		if err != nil {
+			slog.Error(err.Error())
+			closeAll(conns)
+			return nil
+		}
+		conns = append(conns, c)
 	}
 
-	slog.Info(fmt.Sprintf("server started, listening on %s", l.Addr()))
+	return b.runBenchmark(conns, write)
+}
+
+// dialDecorated dials the target address and decorates the connection,
+// unless it is the control connection.
+func (b *Benchmark) dialDecorated(target dialTarget, control bool) (net.Conn, error) {
+	c, err := b.dial(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", target.addr, err)
+	}
+	if !control {
+		if err := b.connTuner().Tune(c); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to tune the connection to %s: %w", target.addr, err)
+		}
+		if c, err = b.decorate(c, false); err != nil {
+			return nil, fmt.Errorf("failed to decorate the connection to %s: %w", target.addr, err)
+		}
+	}
+	return c, nil
+}
 
-	b.pressuredBenchmarkServerWithListener(l, write)
+// connTuner returns the socket options applied to the benchmarked
+// connections, i.e., all but the control connection.
+func (b *Benchmark) connTuner() benchmarkconn.ConnTuner {
+	return benchmarkconn.ConnTuner{SendBuffer: *b.sndbuf, ReceiveBuffer: *b.rcvbuf}
 }
 
-func (b *Benchmark) echoBenchmarkServer(write bool) {
+func (b *Benchmark) benchmarkServer(write bool) error {
 	// listen on the specified address
-	l, err := net.Listen(*b.network, b.addr)
+	l, err := b.listen()
 	if err != nil {
 		slog.Error(fmt.Sprintf("failed to listen on %s: %v\n", b.addr, err))
-		return
+		return nil
 	}
 
 	slog.Info(fmt.Sprintf("server started, listening on %s", l.Addr()))
 
-	b.echoBenchmarkServerWithListener(l, write)
+	return b.benchmarkServerWithListener(l, write)
 }
 
-func (b *Benchmark) pressuredBenchmarkServerWithListener(l net.Listener, write bool) {
-	// accept only one connection and run the benchmark
-	c, err := l.Accept()
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to accept connection: %v\n", err))
-		return
-	}
-
-	// if TCPConn, set the NoDelay option
-	if tcpConn, ok := c.(*net.TCPConn); ok {
-		tcpConn.SetNoDelay(true)
+func (b *Benchmark) benchmarkServerWithListener(l net.Listener, write bool) error {
+	// accept only as many connections as expected and run the benchmark
+	b.listener = l
+	conns := make([]net.Conn, 0, b.totalConns())
+	for len(conns) < b.totalConns() {
+		c, err := b.acceptDecorated(l, b.isControlConn(len(conns)))
+		if err != nil {
+			slog.Error(err.Error())
+			closeAll(conns)
+			return nil
+		}
+		conns = append(conns, c)
 	}
 
-	wg := new(sync.WaitGroup)
-	wg.Add(1)
-	go func() {
-		defer c.Close()
-		defer wg.Done()
+	return b.runBenchmark(conns, write)
+}
 
-		pb := &benchmarkconn.PressuredBenchmark{
-			MessageSize:   *b.messageSz,
-			TotalMessages: uint64(*b.totalMsg),
+// acceptDecorated accepts the next connection passing the accept hooks and
+// decorates it, unless it is the control connection.
+func (b *Benchmark) acceptDecorated(l net.Listener, control bool) (net.Conn, error) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("failed to accept connection: %w", err)
 		}
 
-		if write {
-			if err := pb.Writer(c); err != nil {
-				slog.Error(fmt.Sprintf("(*PressuredBenchmark).Writer: %v", err))
-				return
+		remote := c.RemoteAddr()
+		c, err = b.onAccept(c)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("rejected connection from %s: %v", remote, err))
+			continue
+		}
+		if !control {
+			if err := b.connTuner().Tune(c); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("failed to tune the connection from %s: %w", remote, err)
 			}
-		} else {
-			if err := pb.Reader(c); err != nil {
-				slog.Error(fmt.Sprintf("(*PressuredBenchmark).Reader: %v", err))
-				return
+			if c, err = b.decorate(c, true); err != nil {
+				return nil, fmt.Errorf("failed to decorate the connection from %s: %w", remote, err)
 			}
 		}
+		return c, nil
+	}
+}
 
-		slog.Info(fmt.Sprintf("PressuredBenchmark Result: %v", pb.Result()))
-	}()
+// runBenchmark runs the selected benchmark on the connections and closes
+// the connections when done or timed out. With more than one connection,
+// the benchmark is run in parallel on all of them. It returns an error if
+// any assertion fails, or could not be evaluated since the benchmark
+// failed.
+func (b *Benchmark) runBenchmark(conns []net.Conn, write bool) error {
+	// the first connection is the control connection if enabled
+	dataConns := conns
+	if *b.control {
+		b.controlChannel = benchmarkconn.NewControlChannel(conns[0])
+		dataConns = conns[1:]
+		b.controlChannel.OnPeerProgress(b.onPeerProgress())
+		if *b.heartbeat > 0 {
+			defer b.controlChannel.StartHeartbeat(*b.heartbeat)()
+		}
+	}
+
+	var counters []benchmarkconn.Counter
+	for _, c := range dataConns {
+		counters = append(counters, b.newCounters(c)...)
+	}
+	if counter := b.newRuntimeMetricsCounter(); counter != nil {
+		counters = append(counters, counter)
+	}
+	if *b.gcCounter {
+		counters = append(counters, benchmarkconn.NewGoroutineGCCounter(time.Second))
+	}
+	var cpuFreq *benchmarkconn.CPUFreqCounter
+	if *b.cpuFreq {
+		if counter, err := benchmarkconn.NewCPUFreqCounter(time.Second, nil); err != nil {
+			slog.Warn(fmt.Sprintf("CPU frequency counter disabled: %v", err))
+		} else {
+			cpuFreq = counter
+			counters = append(counters, counter)
+		}
+	}
+	var energy *benchmarkconn.EnergyMeter
+	if *b.energy != "" {
+		if meter, err := benchmarkconn.NewEnergyMeter(b.energySource, *b.wattsPerCore, nil); err != nil {
+			slog.Warn(fmt.Sprintf("energy estimation disabled: %v", err))
+		} else {
+			energy = meter
+		}
+	}
+	if retention := (benchmarkconn.CounterRetention{MaxSamples: *b.counterSamples, Bucket: *b.counterBucket}); retention != (benchmarkconn.CounterRetention{}) {
+		for _, counter := range counters {
+			if err := benchmarkconn.SetCounterRetention(counter, retention); err != nil {
+				slog.Warn(fmt.Sprintf("counter retention not applied: %v", err))
+			}
+		}
+	}
 
 	go func() {
 		<-time.After(*b.timeout)
 		slog.Warn("timed out, closing the connection")
-		c.Close()
+		if b.controlChannel != nil {
+			b.controlChannel.Abort("timed out")
+		}
+		closeAll(conns)
 	}()
 
-	wg.Wait()
-}
+	// the estimate may scale the total number of messages, so it must run
+	// before the benchmark is created
+	if err := b.estimateBandwidth(dataConns[0], write); err != nil {
+		slog.Error(fmt.Sprintf("bandwidth estimate failed: %v", err))
+		closeAll(conns)
+		if len(b.assertions) > 0 {
+			return fmt.Errorf("assertions not evaluated, bandwidth estimate failed: %w", err)
+		}
+		return nil
+	}
 
-func (b *Benchmark) echoBenchmarkServerWithListener(l net.Listener, write bool) {
-	// accept only one connection and run the benchmark
-	c, err := l.Accept()
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to accept connection: %v\n", err))
-		return
+	if *b.hdrLog != "" {
+		f, err := os.Create(*b.hdrLog)
+		if err != nil {
+			closeAll(conns)
+			return fmt.Errorf("failed to create the histogram log: %w", err)
+		}
+		defer f.Close()
+		b.histogramLog = benchmarkconn.NewHistogramLogWriter(f)
+	}
+
+	var name string
+	var writer, reader func() error
+	var resultFunc func() map[string]any
+	var adopted []string // the spec fields adopted from the client with -adopt-spec
+	if *b.compare != "" {
+		bench := &benchmarkconn.PathComparison{New: b.newBenchmark, Labels: b.pathLabels(), Control: b.controlChannel}
+		name = "PathComparison"
+		writer = func() error { return bench.Writer(dataConns[0], dataConns[1], counters...) }
+		reader = func() error { return bench.Reader(dataConns[0], dataConns[1], counters...) }
+		resultFunc = bench.Result
+	} else if len(dataConns) == 1 {
+		bench := b.newBenchmark()
+		if *b.adoptSpec {
+			var err error
+			if adopted, err = b.adoptPeerSpec(bench); err != nil {
+				slog.Error(fmt.Sprintf("failed to adopt the spec of the client: %v", err))
+				b.controlChannel.Abort(err.Error())
+				closeAll(conns)
+				return nil
+			}
+		}
+		name = benchmarkName(bench)
+		b.interim.name = name
+		writer = func() error { return bench.Writer(dataConns[0], counters...) }
+		reader = func() error { return bench.Reader(dataConns[0], counters...) }
+		resultFunc = bench.Result
+	} else {
+		bench := &benchmarkconn.ParallelBenchmark{New: b.newBenchmark, Control: b.controlChannel, Profile: b.profile}
+		name = "ParallelBenchmark"
+		writer = func() error { return bench.Writer(dataConns, counters...) }
+		reader = func() error { return bench.Reader(dataConns, counters...) }
+		resultFunc = bench.Result
 	}
 
-	// if TCPConn, set the NoDelay option
-	if tcpConn, ok := c.(*net.TCPConn); ok {
-		tcpConn.SetNoDelay(true)
+	if *b.format == "iperf3" {
+		b.startIPerf3(dataConns)
 	}
 
+	otelRun := b.startOTelRun(name, write, counters)
+	defer b.shutdownOTel()
+
+	var assertionErr error
 	wg := new(sync.WaitGroup)
 	wg.Add(1)
 	go func() {
-		defer c.Close()
+		// close before signaling completion, since closing may need to
+		// flush data buffered in user space, e.g., with QUIC
 		defer wg.Done()
+		defer closeAll(conns)
 
-		ib := &benchmarkconn.IntervalBenchmark{
-			MessageSize:   *b.messageSz,
-			TotalMessages: uint64(*b.totalMsg),
-			Interval:      *b.interval,
-			Echo:          true,
+		if energy != nil {
+			energy.Start()
 		}
-
+		var err error
 		if write {
-			if err := ib.Writer(c); err != nil {
-				slog.Error(fmt.Sprintf("(*IntervalBenchmark).Writer: %v", err))
-				return
+			if err = writer(); err != nil {
+				slog.Error(fmt.Sprintf("(*%s).Writer: %v", name, err))
 			}
 		} else {
-			if err := ib.Reader(c); err != nil {
-				slog.Error(fmt.Sprintf("(*IntervalBenchmark).Reader: %v", err))
-				return
+			if err = reader(); err != nil {
+				slog.Error(fmt.Sprintf("(*%s).Reader: %v", name, err))
 			}
 		}
 
-		slog.Info(fmt.Sprintf("EchoBenchmark Result: %v", ib.Result()))
-	}()
+		if energy != nil {
+			energy.Stop()
+		}
 
-	go func() {
-		<-time.After(*b.timeout)
-		slog.Warn("timed out, closing the connection")
-		c.Close()
+		// tell the peer to stop rather than leaving it waiting for timeout
+		if err != nil && b.controlChannel != nil && !errors.Is(err, benchmarkconn.ErrAborted) {
+			b.controlChannel.Abort(err.Error())
+		}
+
+		var result map[string]any
+		if err == nil || errors.Is(err, benchmarkconn.ErrAborted) {
+			result = resultFunc()
+			result["runtime"] = benchmarkconn.RuntimeSettings()
+			if cpuFreq != nil {
+				cpuFreq.Annotate(result)
+				if cpuFreq.Throttled() {
+					slog.Warn("the CPU was throttled during the run, the result may reflect the CPU rather than the network")
+				}
+			}
+			if energy != nil {
+				energy.Annotate(result)
+			}
+			if len(adopted) > 0 {
+				result["spec_adopted"] = adopted
+			}
+			if b.controlChannel != nil {
+				result["control_heartbeats_sent"], result["control_heartbeats_received"] = b.controlChannel.Heartbeats()
+			}
+			slog.Info(fmt.Sprintf("%s Result: %v", name, result))
+		}
+		if otelRun != nil {
+			otelRun.End(result, err)
+		}
+
+		// results are exchanged even if the run failed, so the peer does not
+		// wait for them in vain
+		if b.controlChannel != nil {
+			if peerResult, err := b.controlChannel.ExchangeResults(result); err != nil {
+				slog.Warn(fmt.Sprintf("failed to exchange results with the peer: %v", err))
+			} else if result != nil {
+				result["peer"] = peerResult
+				slog.Info(fmt.Sprintf("%s Peer Result: %v", name, peerResult))
+				compareDigests(result, peerResult)
+			}
+		}
+
+		var assertions []benchmarkconn.AssertionResult
+		if len(b.assertions) > 0 {
+			assertions, assertionErr = b.evaluateAssertions(result, err)
+		}
+
+		if len(b.sinks.sinks) > 0 {
+			record := b.newResultRecord(name, result, err)
+			record.Assertions = assertions
+			b.writeSinks(record, false)
+		}
+
+		if *b.output != "" {
+			if *b.format == "iperf3" {
+				if err := writeIPerf3File(*b.output, b.newIPerf3Result(result, write, err)); err != nil {
+					slog.Error(fmt.Sprintf("failed to write result file: %v", err))
+				}
+			} else {
+				record := b.newResultRecord(name, result, err)
+				record.Assertions = assertions
+				record.Interim = b.interimResults()
+				if err := WriteResultFile(*b.output, record); err != nil {
+					slog.Error(fmt.Sprintf("failed to write result file: %v", err))
+				}
+			}
+			if *b.manifest {
+				if err := WriteManifestFile(ManifestPath(*b.output), b.newManifest()); err != nil {
+					slog.Error(fmt.Sprintf("failed to write manifest file: %v", err))
+				}
+			}
+		}
 	}()
 
 	wg.Wait()
+
+	if b.histogramLog != nil {
+		if err := b.histogramLog.Err(); err != nil {
+			slog.Error(fmt.Sprintf("failed to write the histogram log: %v", err))
+		}
+	}
+
+	return assertionErr
+}
+
+// compareDigests compares the digest of the messages verified with -verify
+// with that of the peer, if both have one, as verify_digest_match, and
+// warns if they differ, i.e., if messages were lost, corrupted or
+// reordered on the way.
+func compareDigests(result, peerResult map[string]any) {
+	digest, ok := result["verify_digest"].(string)
+	peerDigest, peerOK := peerResult["verify_digest"].(string)
+	if !ok || !peerOK {
+		return
+	}
+	result["verify_digest_match"] = digest == peerDigest
+	if digest != peerDigest {
+		slog.Warn(fmt.Sprintf("the digest of the messages verified differs from that of the peer: %s, peer %s", digest, peerDigest))
+	}
+}
+
+func closeAll(conns []net.Conn) {
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// newCounters creates the counters requested by the flags for the
+// connection.
+func (b *Benchmark) newCounters(c net.Conn) []benchmarkconn.Counter {
+	var counters []benchmarkconn.Counter
+
+	// measure the connection underneath the decorators, if any
+	for {
+		wrapper, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = wrapper.NetConn()
+	}
+
+	if *b.tcpInfo {
+		if tcpConn, ok := c.(*net.TCPConn); ok {
+			counter, err := benchmarkconn.NewTCPInfoCounter(time.Second, tcpConn)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("TCP_INFO counter disabled: %v", err))
+			} else {
+				counters = append(counters, counter)
+			}
+		} else {
+			slog.Warn("TCP_INFO counter disabled: not a TCP connection")
+		}
+	}
+
+	return counters
+}
+
+// newRuntimeMetricsCounter creates the runtime/metrics counter requested by
+// the flags, if any, which is process-wide rather than per connection.
+func (b *Benchmark) newRuntimeMetricsCounter() benchmarkconn.Counter {
+	if *b.runtimeMetrics == "" {
+		return nil
+	}
+
+	var names []string
+	if *b.runtimeMetrics != "default" {
+		for _, name := range strings.Split(*b.runtimeMetrics, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	counter, err := benchmarkconn.NewRuntimeMetricsCounter(time.Second, names...)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("runtime metrics counter disabled: %v", err))
+		return nil
+	}
+	return counter
 }
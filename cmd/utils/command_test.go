@@ -0,0 +1,31 @@
+package utils
+
+import "testing"
+
+func TestInitOTLPExporter(t *testing.T) {
+	newBenchmark := func() *Benchmark {
+		b := NewBenchmark()
+		b.SetBenchType("pressure")
+		b.SetCommand("write")
+		b.SetAddress("127.0.0.1:8080")
+		return b
+	}
+
+	// a flag failing validation leaves no exporter behind
+	b := newBenchmark()
+	if err := b.Init([]string{"-otlp-endpoint", "127.0.0.1:4318", "-otlp-insecure", "-burst", "0"}); err == nil {
+		t.Fatal("expected a burst size of 0 to be rejected")
+	}
+	if b.otelExporter != nil {
+		t.Error("expected no exporter to be created once validation failed")
+	}
+
+	b = newBenchmark()
+	if err := b.Init([]string{"-otlp-endpoint", "127.0.0.1:4318", "-otlp-insecure"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.otelExporter == nil {
+		t.Fatal("expected an exporter to be created")
+	}
+	b.shutdownOTel()
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialTarget is an address to dial and the proxy to dial it through, if
+// any.
+type dialTarget struct {
+	addr        string
+	proxyDialer proxy.Dialer
+}
+
+// primaryTarget returns the server address, through -proxy if set.
+func (b *Benchmark) primaryTarget() dialTarget {
+	return dialTarget{addr: b.addr, proxyDialer: b.proxyDialer}
+}
+
+// compareTarget returns the address of the compared path, through
+// -compare-proxy if set.
+func (b *Benchmark) compareTarget() dialTarget {
+	return dialTarget{addr: *b.compare, proxyDialer: b.compareProxyDialer}
+}
+
+// validateCompare checks the flags of a comparison of two paths to the
+// same server.
+func (b *Benchmark) validateCompare() error {
+	if *b.compare == "" {
+		return errors.New("compare-proxy requires -compare")
+	}
+	switch b.benchType {
+	case "handshake", "churn", "relay":
+		return fmt.Errorf("%s does not support -compare", b.benchType)
+	}
+	if *b.parallel != 1 {
+		return errors.New("compare runs one connection over each path and does not support -P")
+	}
+	if *b.soak > 0 {
+		return errors.New("soak does not support -compare")
+	}
+
+	if *b.compareProxy != "" {
+		dialer, err := newProxyDialer(*b.compareProxy)
+		if err != nil {
+			return err
+		}
+		b.compareProxyDialer = dialer
+	}
+
+	if labels := b.pathLabels(); labels[0] == labels[1] {
+		return fmt.Errorf("compared path %s is the same as the benchmarked one", labels[1])
+	}
+	return nil
+}
+
+// pathLabels names the benchmarked and the compared path in the result by
+// their address and proxy, e.g., "[::1]:8080" and "127.0.0.1:8080 via
+// proxy.example:1080".
+func (b *Benchmark) pathLabels() [2]string {
+	return [2]string{pathLabel(b.addr, *b.proxy), pathLabel(*b.compare, *b.compareProxy)}
+}
+
+// pathLabel names the path to addr through the proxy at proxyURL, if any,
+// leaving out the credentials of the proxy.
+func pathLabel(addr, proxyURL string) string {
+	if proxyURL == "" {
+		return addr
+	}
+	if u, err := url.Parse(proxyURL); err == nil {
+		return addr + " via " + u.Host
+	}
+	return addr + " via proxy"
+}
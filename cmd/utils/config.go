@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SplitArgs splits the command line arguments into the positional
+// <type> <operation> <server_addr> and the flags. The positional
+// arguments may be omitted if the flags start right away, e.g., when the
+// run is described by -config. ok is false if the arguments are invalid.
+func SplitArgs(args []string) (benchType, operation, addr string, flags []string, ok bool) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		return "", "", "", args, true
+	}
+	if len(args) < 3 {
+		return "", "", "", nil, false
+	}
+	return args[0], args[1], args[2], args[3:], true
+}
+
+// loadConfig applies the run described by the YAML config file at path.
+// The file is a mapping of the type, operation and address of the run and
+// of flag names without the leading dash to their values, with lists for
+// repeatable flags, e.g.:
+//
+//	type: pressure
+//	operation: write
+//	address: 127.0.0.1:8080
+//	net: quic
+//	sz: 4096
+//	assert:
+//	  - latency_p99_ms < 20
+//
+// A manifest written with -manifest is a config file as well, describing
+// the run it was written for. Positional arguments and flags set on the
+// command line take precedence.
+func (b *Benchmark) loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config map[string]any
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	if manifestConfig, ok := config["config"].(map[string]any); ok {
+		config = manifestConfig
+	}
+
+	explicit := make(map[string]bool)
+	b.fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// apply in a stable order, so errors are reproducible
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := config[name]
+		switch name {
+		case "type":
+			if b.benchType == "" {
+				b.benchType = fmt.Sprint(value)
+			}
+			continue
+		case "operation":
+			if b.command == "" {
+				b.command = fmt.Sprint(value)
+			}
+			continue
+		case "address":
+			if b.addr == "" {
+				b.addr = fmt.Sprint(value)
+			}
+			continue
+		case "config":
+			return errors.New("config files can not be nested")
+		}
+
+		if b.fs.Lookup(name) == nil {
+			return fmt.Errorf("invalid config %s: unknown setting %q", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		values, ok := value.([]any)
+		if !ok {
+			values = []any{value}
+		}
+		for _, v := range values {
+			if err := b.fs.Set(name, configValue(v)); err != nil {
+				return fmt.Errorf("invalid config %s: %s: %w", path, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// configValue formats a value of the config file as a flag value. YAML
+// decodes numbers like 1e6 as floats, which fmt formats in exponent
+// notation that integer flags reject, so they are formatted in full.
+func configValue(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
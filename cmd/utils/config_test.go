@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "type: pressure\noperation: write\naddress: 127.0.0.1:8080\nm: 1e6\nsz: 4096\ntarget-bw: 1.5\nassert:\n  - ops_per_s > 0\n  - bytes_written > 0\n"
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBenchmark()
+	if err := b.fs.Parse([]string{"-sz", "64"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.loadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if b.benchType != "pressure" || b.command != "write" || b.addr != "127.0.0.1:8080" {
+		t.Errorf("expected pressure write 127.0.0.1:8080, got %s %s %s", b.benchType, b.command, b.addr)
+	}
+	if *b.totalMsg != 1000000 {
+		t.Errorf("expected 1e6 messages, got %d", *b.totalMsg)
+	}
+	if *b.messageSz != 64 {
+		t.Errorf("expected the flag on the command line to take precedence, got %d", *b.messageSz)
+	}
+	if *b.targetBandwidth != 1.5 {
+		t.Errorf("expected a target bandwidth of 1.5, got %v", *b.targetBandwidth)
+	}
+	if len(b.assertions) != 2 {
+		t.Errorf("expected 2 assertions, got %d", len(b.assertions))
+	}
+}
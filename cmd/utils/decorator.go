@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"compress/flate"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// decoratorSpec is a decorator listed with -decorate, e.g., delay:10ms.
+type decoratorSpec struct {
+	name  string
+	param string
+}
+
+// parseDecorators parses a comma-separated list of decorators, validating
+// their parameters, e.g., "meter,flate:6,delay:1ms".
+func parseDecorators(spec string) ([]decoratorSpec, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	var specs []decoratorSpec
+	for _, decorator := range strings.Split(spec, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(decorator), ":")
+		s := decoratorSpec{name: name, param: param}
+		if _, err := s.newDecorator(nil, false); err != nil {
+			return nil, fmt.Errorf("invalid decorator %q: %w", decorator, err)
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+// newDecorator creates the decorator, secured with tlsConfig for tls.
+func (s decoratorSpec) newDecorator(tlsConfig *tls.Config, server bool) (benchmarkconn.ConnDecorator, error) {
+	switch s.name {
+	case "meter", "tls":
+		if s.param != "" {
+			return nil, fmt.Errorf("%s takes no parameter", s.name)
+		}
+		if s.name == "tls" {
+			return &benchmarkconn.TLSDecorator{Config: tlsConfig, Server: server}, nil
+		}
+		return &benchmarkconn.MeterDecorator{}, nil
+	case "delay":
+		delay, err := time.ParseDuration(s.param)
+		if err != nil {
+			return nil, err
+		}
+		if delay < 0 {
+			return nil, fmt.Errorf("negative delay %s", delay)
+		}
+		return &benchmarkconn.DelayDecorator{Delay: delay}, nil
+	case "ratelimit":
+		mbps, err := strconv.ParseFloat(s.param, 64)
+		if err != nil {
+			return nil, err
+		}
+		if mbps <= 0 {
+			return nil, fmt.Errorf("rate limit must be positive, got %g Mbps", mbps)
+		}
+		return &benchmarkconn.RateLimitDecorator{BitsPerSecond: mbps * 1e6}, nil
+	case "shape":
+		// shape:<preset> or shape:<delay>:<Mbps>
+		delaySpec, mbpsSpec, custom := strings.Cut(s.param, ":")
+		if !custom {
+			return benchmarkconn.ShapePreset(s.param)
+		}
+		delay, err := time.ParseDuration(delaySpec)
+		if err != nil {
+			return nil, err
+		}
+		if delay < 0 {
+			return nil, fmt.Errorf("negative delay %s", delay)
+		}
+		mbps, err := strconv.ParseFloat(mbpsSpec, 64)
+		if err != nil {
+			return nil, err
+		}
+		if mbps < 0 {
+			return nil, fmt.Errorf("negative rate %g Mbps", mbps)
+		}
+		return &benchmarkconn.ShapeDecorator{Delay: delay, BitsPerSecond: mbps * 1e6}, nil
+	case "flate":
+		level := flate.DefaultCompression
+		if s.param != "" {
+			var err error
+			if level, err = strconv.Atoi(s.param); err != nil {
+				return nil, err
+			}
+		}
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return nil, fmt.Errorf("flate level must be between %d and %d, got %d", flate.HuffmanOnly, flate.BestCompression, level)
+		}
+		return &benchmarkconn.FlateDecorator{Level: level}, nil
+	case "pad":
+		// pad:<block size>[:<dummy rate>[:<dummy size>]]
+		fields := strings.Split(s.param, ":")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("pad takes at most a block size, a dummy rate and a dummy size, got %q", s.param)
+		}
+		padding := &benchmarkconn.PaddingDecorator{}
+		var err error
+		if padding.BlockSize, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, err
+		}
+		if len(fields) > 1 {
+			if padding.DummyRate, err = strconv.ParseFloat(fields[1], 64); err != nil {
+				return nil, err
+			}
+		}
+		if len(fields) > 2 {
+			if padding.DummySize, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, err
+			}
+		}
+		if padding.BlockSize < 0 || padding.BlockSize > 1<<16-1 {
+			return nil, fmt.Errorf("padding block size must be within [0, 65535], got %d", padding.BlockSize)
+		}
+		if padding.DummyRate < 0 {
+			return nil, fmt.Errorf("negative dummy rate %g", padding.DummyRate)
+		}
+		dummySize := padding.DummySize
+		if dummySize == 0 {
+			dummySize = padding.BlockSize
+		}
+		if padding.DummyRate > 0 && (dummySize < 4 || dummySize > 1<<16+3) {
+			return nil, fmt.Errorf("dummy record size must be within [4, 65539], got %d", dummySize)
+		}
+		return padding, nil
+	default:
+		return nil, fmt.Errorf("unknown decorator %s, must be meter, delay:<duration>, ratelimit:<Mbps>, flate[:<level>], pad:<block>[:<rate>[:<size>]], shape:<preset>, shape:<delay>:<Mbps> or tls", s.name)
+	}
+}
+
+// decorate wraps a data connection with the decorators listed with
+// -decorate, if any, closing it on failure.
+func (b *Benchmark) decorate(c net.Conn, server bool) (net.Conn, error) {
+	if len(b.decorators) == 0 {
+		return c, nil
+	}
+
+	var tlsConfig *tls.Config
+	for _, s := range b.decorators {
+		if s.name == "tls" {
+			var err error
+			if tlsConfig, err = b.decoratorTLSConfig(server); err != nil {
+				c.Close()
+				return nil, err
+			}
+			break
+		}
+	}
+
+	decorators := make([]benchmarkconn.ConnDecorator, len(b.decorators))
+	for i, s := range b.decorators {
+		decorator, err := s.newDecorator(tlsConfig, server)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		decorators[i] = decorator
+	}
+
+	decorated, err := benchmarkconn.DecorateConn(c, decorators...)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return decorated, nil
+}
+
+// decoratorTLSConfig returns the TLS configuration of the tls decorator,
+// created once since servers generate a certificate.
+func (b *Benchmark) decoratorTLSConfig(server bool) (*tls.Config, error) {
+	if b.decoratorTLS != nil {
+		return b.decoratorTLS, nil
+	}
+
+	var config *tls.Config
+	var err error
+	if server {
+		config, err = b.serverTLSConfig()
+	} else {
+		config, err = b.clientTLSConfig()
+		if err == nil && config.ServerName == "" {
+			config.ServerName, _, err = net.SplitHostPort(b.addr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.decoratorTLS = config
+	return config, nil
+}
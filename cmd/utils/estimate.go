@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// estimateBandwidth runs the bandwidth estimate requested with -estimate
+// on the connection, warns if the benchmark is not expected to complete
+// before the timeout and scales the total number of messages if
+// -estimate-target is set.
+//
+// With parallel connections, the estimate of the first connection is
+// assumed to be the capacity shared by all of them.
+func (b *Benchmark) estimateBandwidth(conn net.Conn, write bool) error {
+	if *b.estimate <= 0 {
+		return nil
+	}
+	if b.benchType != "pressure" && b.benchType != "echo" {
+		slog.Warn(fmt.Sprintf("bandwidth estimate is not supported by %s, skipped", b.benchType))
+		return nil
+	}
+
+	estimate := &benchmarkconn.BandwidthEstimate{
+		MessageSize: *b.messageSz,
+		Duration:    *b.estimate,
+	}
+	if write {
+		if err := estimate.Writer(conn); err != nil {
+			return err
+		}
+	} else {
+		if err := estimate.Reader(conn); err != nil {
+			return err
+		}
+	}
+	slog.Info(fmt.Sprintf("estimated bandwidth: %.2f Mbps", estimate.BytesPerSecond()*8/1e6))
+
+	// echo messages are paced by the interval, whichever is slower wins
+	paced := b.benchType == "echo" && *b.interval > 0
+
+	if *b.estimateTarget > 0 {
+		messages := estimate.MessagesFor(*b.messageSz, *b.estimateTarget) / uint64(*b.parallel)
+		if paced {
+			messages = min(messages, uint64(*b.estimateTarget / *b.interval))
+		}
+		messages = max(messages, 1)
+		slog.Info(fmt.Sprintf("scaled total messages from %d to %d for a run length of %s", *b.totalMsg, messages, *b.estimateTarget))
+		*b.totalMsg = int(messages)
+	}
+
+	expected := estimate.DurationFor(*b.messageSz, uint64(*b.totalMsg)*uint64(*b.parallel))
+	if paced {
+		expected = max(expected, time.Duration(*b.totalMsg)**b.interval)
+	}
+	if expected > *b.timeout {
+		slog.Warn(fmt.Sprintf("%d messages of %d bytes are expected to take about %s at the estimated bandwidth, longer than the timeout of %s",
+			*b.totalMsg, *b.messageSz, expected.Round(time.Second), *b.timeout))
+	}
+
+	return nil
+}
@@ -0,0 +1,343 @@
+package utils
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// iperf3TimeFormat is the format of start.timestamp.time in iperf3's JSON
+// output.
+const iperf3TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// iperf3Result mirrors the top level of iperf3's JSON output, iperf3 -J,
+// so that tools parsing it can read results of benchmarkconn as well.
+type iperf3Result struct {
+	Start     iperf3Start      `json:"start"`
+	Intervals []iperf3Interval `json:"intervals"`
+	End       iperf3End        `json:"end"`
+	Error     string           `json:"error,omitempty"`
+}
+
+type iperf3Start struct {
+	Connected    []iperf3Connected `json:"connected"`
+	Version      string            `json:"version"`
+	SystemInfo   string            `json:"system_info"`
+	Timestamp    iperf3Timestamp   `json:"timestamp"`
+	ConnectingTo *iperf3Host       `json:"connecting_to,omitempty"` // the client only
+	TestStart    iperf3TestStart   `json:"test_start"`
+}
+
+type iperf3Connected struct {
+	Socket     int    `json:"socket"`
+	LocalHost  string `json:"local_host"`
+	LocalPort  int    `json:"local_port"`
+	RemoteHost string `json:"remote_host"`
+	RemotePort int    `json:"remote_port"`
+}
+
+type iperf3Timestamp struct {
+	Time     string `json:"time"`
+	Timesecs int64  `json:"timesecs"`
+}
+
+type iperf3Host struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type iperf3TestStart struct {
+	Protocol   string `json:"protocol"`
+	NumStreams int    `json:"num_streams"`
+	Blksize    int    `json:"blksize"`
+	Omit       int    `json:"omit"`     // the warmup in seconds
+	Duration   int    `json:"duration"` // the target duration in seconds, 0 for a fixed number of messages
+	Bytes      uint64 `json:"bytes"`
+	Blocks     uint64 `json:"blocks"` // the number of messages, 0 for a target duration
+	Reverse    int    `json:"reverse"`
+}
+
+type iperf3Interval struct {
+	Streams []iperf3Stream `json:"streams"`
+	Sum     iperf3Stream   `json:"sum"`
+}
+
+// iperf3Stream is the transfer of a stream, or the sum over all streams,
+// over an interval or the whole run.
+type iperf3Stream struct {
+	Socket        int     `json:"socket,omitempty"` // unset for sums
+	Start         float64 `json:"start"`
+	End           float64 `json:"end"`
+	Seconds       float64 `json:"seconds"`
+	Bytes         uint64  `json:"bytes"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	Omitted       bool    `json:"omitted"`
+	Sender        bool    `json:"sender"`
+}
+
+type iperf3End struct {
+	Streams     []iperf3EndStream `json:"streams"`
+	SumSent     *iperf3Stream     `json:"sum_sent,omitempty"`
+	SumReceived *iperf3Stream     `json:"sum_received,omitempty"`
+}
+
+type iperf3EndStream struct {
+	Sender   *iperf3Stream `json:"sender,omitempty"`
+	Receiver *iperf3Stream `json:"receiver,omitempty"`
+}
+
+// iperf3Recorder collects the intervals of a run for -format iperf3 from
+// the progress snapshots.
+type iperf3Recorder struct {
+	mutex        sync.Mutex
+	start        time.Time
+	conns        []net.Conn
+	intervals    []iperf3Interval
+	lastElapsed  time.Duration
+	lastMessages uint64
+}
+
+// startIPerf3 starts recording the run over the data connections for
+// -format iperf3.
+func (b *Benchmark) startIPerf3(dataConns []net.Conn) {
+	b.iperf3.mutex.Lock()
+	defer b.iperf3.mutex.Unlock()
+	b.iperf3.start = time.Now()
+	b.iperf3.conns = dataConns
+}
+
+// recordIPerf3Interval records the transfer since the previous snapshot
+// as an interval. Only the messages in the direction of the local side
+// count, each assumed to be of -sz bytes, like the progress itself.
+func (b *Benchmark) recordIPerf3Interval(snapshot benchmarkconn.ProgressSnapshot, write bool) {
+	b.iperf3.mutex.Lock()
+	defer b.iperf3.mutex.Unlock()
+
+	messages := snapshot.MessagesRead
+	if write {
+		messages = snapshot.MessagesWritten
+	}
+	if snapshot.Elapsed <= b.iperf3.lastElapsed {
+		return
+	}
+
+	stream := iperf3Stream{
+		Socket:  1,
+		Start:   b.iperf3.lastElapsed.Seconds(),
+		End:     snapshot.Elapsed.Seconds(),
+		Seconds: (snapshot.Elapsed - b.iperf3.lastElapsed).Seconds(),
+		Bytes:   (messages - min(messages, b.iperf3.lastMessages)) * uint64(*b.messageSz),
+		Sender:  write,
+	}
+	stream.BitsPerSecond = float64(stream.Bytes) * 8 / stream.Seconds
+	sum := stream
+	sum.Socket = 0
+	b.iperf3.intervals = append(b.iperf3.intervals, iperf3Interval{Streams: []iperf3Stream{stream}, Sum: sum})
+	b.iperf3.lastElapsed, b.iperf3.lastMessages = snapshot.Elapsed, messages
+}
+
+// newIPerf3Result converts the result of the run to iperf3's JSON output.
+// The end summary holds the side of the local peer, sender if write, and
+// that of the remote peer only if its result was exchanged over -control.
+func (b *Benchmark) newIPerf3Result(result map[string]any, write bool, benchErr error) *iperf3Result {
+	b.iperf3.mutex.Lock()
+	defer b.iperf3.mutex.Unlock()
+
+	out := &iperf3Result{
+		Start: iperf3Start{
+			Version:    VersionString(),
+			SystemInfo: runtime.GOOS + " " + runtime.GOARCH + " " + runtime.Version(),
+			Timestamp: iperf3Timestamp{
+				Time:     b.iperf3.start.UTC().Format(iperf3TimeFormat),
+				Timesecs: b.iperf3.start.Unix(),
+			},
+			TestStart: iperf3TestStart{
+				Protocol:   iperf3Protocol(*b.network),
+				NumStreams: len(b.iperf3.conns),
+				Blksize:    *b.messageSz,
+				Omit:       int(b.warmupTime.Seconds()),
+				Duration:   int(b.targetDuration.Seconds()),
+			},
+		},
+		Intervals: b.iperf3.intervals,
+	}
+	if *b.targetDuration <= 0 {
+		out.Start.TestStart.Blocks = uint64(*b.totalMsg)
+	}
+
+	// iperf3 describes the direction from the client, which sends unless
+	// reversed
+	server := b.listener != nil
+	if write == server {
+		out.Start.TestStart.Reverse = 1
+	}
+	if !server {
+		host, port := splitHostPort(b.addr)
+		out.Start.ConnectingTo = &iperf3Host{Host: host, Port: port}
+	}
+
+	for i, c := range b.iperf3.conns {
+		connected := iperf3Connected{Socket: i + 1}
+		if c.LocalAddr() != nil {
+			connected.LocalHost, connected.LocalPort = splitHostPort(c.LocalAddr().String())
+		}
+		if c.RemoteAddr() != nil {
+			connected.RemoteHost, connected.RemotePort = splitHostPort(c.RemoteAddr().String())
+		}
+		out.Start.Connected = append(out.Start.Connected, connected)
+	}
+	if out.Intervals == nil {
+		out.Intervals = []iperf3Interval{}
+	}
+
+	if benchErr != nil {
+		out.Error = benchErr.Error()
+	}
+
+	local := iperf3EndSide(result, write)
+	var remote []*iperf3Stream
+	if peer, ok := result["peer"].(map[string]any); ok {
+		remote = iperf3EndSide(peer, !write)
+	}
+	for i := range local {
+		var stream iperf3EndStream
+		if write {
+			stream.Sender = local[i]
+		} else {
+			stream.Receiver = local[i]
+		}
+		if i < len(remote) {
+			if write {
+				stream.Receiver = remote[i]
+			} else {
+				stream.Sender = remote[i]
+			}
+		}
+		out.End.Streams = append(out.End.Streams, stream)
+	}
+	if out.End.Streams == nil {
+		out.End.Streams = []iperf3EndStream{}
+	}
+
+	localSum, remoteSum := iperf3Sum(local), iperf3Sum(remote)
+	if write {
+		out.End.SumSent, out.End.SumReceived = localSum, remoteSum
+	} else {
+		out.End.SumSent, out.End.SumReceived = remoteSum, localSum
+	}
+	return out
+}
+
+// iperf3EndSide returns the transfer of each connection of one side of
+// the run from its result, the only one unless the result lists several
+// under "connections", or nil without a result.
+func iperf3EndSide(result map[string]any, sender bool) []*iperf3Stream {
+	if len(result) == 0 {
+		return nil
+	}
+
+	results := []map[string]any{result}
+	switch connections := result["connections"].(type) {
+	case []map[string]any:
+		results = connections
+	case []any: // decoded from JSON, e.g., the result of the peer
+		results = results[:0]
+		for _, connection := range connections {
+			if connection, ok := connection.(map[string]any); ok {
+				results = append(results, connection)
+			}
+		}
+	}
+
+	streams := make([]*iperf3Stream, len(results))
+	for i, r := range results {
+		key := "bytes_read"
+		if sender {
+			key = "bytes_written"
+		}
+		bytes, _ := resultFloat64(r[key])
+		var seconds float64
+		if duration, ok := r["duration"].(string); ok {
+			if d, err := time.ParseDuration(duration); err == nil {
+				seconds = d.Seconds()
+			}
+		}
+
+		streams[i] = &iperf3Stream{Socket: i + 1, End: seconds, Seconds: seconds, Bytes: uint64(bytes), Sender: sender}
+		if seconds > 0 {
+			streams[i].BitsPerSecond = bytes * 8 / seconds
+		}
+	}
+	return streams
+}
+
+// iperf3Sum sums the transfer over the streams, which run side by side,
+// or returns nil without any.
+func iperf3Sum(streams []*iperf3Stream) *iperf3Stream {
+	if len(streams) == 0 {
+		return nil
+	}
+	sum := &iperf3Stream{Sender: streams[0].Sender}
+	for _, stream := range streams {
+		sum.End = max(sum.End, stream.End)
+		sum.Bytes += stream.Bytes
+		sum.BitsPerSecond += stream.BitsPerSecond
+	}
+	sum.Seconds = sum.End
+	return sum
+}
+
+// iperf3Protocol returns the transport protocol of network as iperf3 names
+// it.
+func iperf3Protocol(network string) string {
+	switch network {
+	case "udp", "udp4", "udp6", "quic":
+		return "UDP"
+	default:
+		return "TCP"
+	}
+}
+
+// splitHostPort splits addr into its host and port, 0 if it has none,
+// e.g., a Unix socket path.
+func splitHostPort(addr string) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// writeIPerf3File writes the result as indented JSON in iperf3's schema to
+// path.
+func writeIPerf3File(path string, result *iperf3Result) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// resultFloat64 converts a numeric result value, which may be an integer
+// with -profile constrained, to float64.
+func resultFloat64(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case uint64:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// manifestExcludedFlags are the flags left out of the config of a
+// manifest, since reproducing a run must not overwrite its files.
+var manifestExcludedFlags = map[string]bool{
+	"o":        true,
+	"manifest": true,
+	"config":   true,
+	"version":  true,
+	"sink":     true,
+}
+
+// Manifest is the content of a manifest file written with -manifest next
+// to the result file, describing the run in full so that its result can
+// be reproduced later. Its Config makes the manifest usable as -config.
+type Manifest struct {
+	Time        time.Time         `json:"time"`                 // Time is when the manifest was written
+	Config      map[string]any    `json:"config"`               // Config holds the type, operation and address and every flag of the run except output files, defaults and resolved values included
+	Decorators  []string          `json:"decorators,omitempty"` // Decorators lists the decorator chain of -decorate, presets included
+	Build       map[string]string `json:"build"`                // Build identifies the binary by its module version and VCS revision, if known
+	Environment map[string]any    `json:"environment"`          // Environment fingerprints the host and the Go runtime
+}
+
+// ManifestPath returns the path of the manifest of the result file at
+// path, e.g., result.manifest.json for result.json.
+func ManifestPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".manifest.json"
+}
+
+// newManifest creates the manifest of the run.
+func (b *Benchmark) newManifest() *Manifest {
+	manifest := &Manifest{
+		Time: time.Now(),
+		Config: map[string]any{
+			"type":      b.benchType,
+			"operation": b.command,
+			"address":   b.addr,
+		},
+		Build:       buildInfo(),
+		Environment: environment(),
+	}
+
+	b.fs.VisitAll(func(f *flag.Flag) {
+		if manifestExcludedFlags[f.Name] {
+			return
+		}
+		if f.Name == "assert" { // repeatable, listed as in config files
+			if len(b.assertions) == 0 {
+				return
+			}
+			exprs := make([]string, len(b.assertions))
+			for i, assertion := range b.assertions {
+				exprs[i] = assertion.Expr
+			}
+			manifest.Config[f.Name] = exprs
+			return
+		}
+		manifest.Config[f.Name] = f.Value.String()
+	})
+
+	for _, s := range b.decorators {
+		if decorator, err := s.newDecorator(nil, b.listener != nil); err == nil {
+			manifest.Decorators = append(manifest.Decorators, decorator.String())
+		}
+	}
+
+	return manifest
+}
+
+// buildInfo returns the module path and version of the binary and, if
+// built from a VCS checkout, its revision, commit time and whether the
+// tree was modified.
+func buildInfo() map[string]string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return map[string]string{}
+	}
+
+	build := map[string]string{
+		"path":       info.Main.Path,
+		"version":    info.Main.Version,
+		"go_version": info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			build[strings.TrimPrefix(setting.Key, "vcs.")] = setting.Value
+		}
+	}
+	return build
+}
+
+// environment fingerprints the host: its name, the Go runtime settings,
+// the timer resolution and, on Linux, the kernel release.
+func environment() map[string]any {
+	env := map[string]any{
+		"runtime": benchmarkconn.RuntimeSettings(),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		env["hostname"] = hostname
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		env["kernel"] = strings.TrimSpace(string(release))
+	}
+	return env
+}
+
+// WriteManifestFile writes the manifest as indented JSON to path.
+func WriteManifestFile(path string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReadManifestFile reads a manifest written by WriteManifestFile.
+func ReadManifestFile(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+	"github.com/gaukas/benchmarkconn/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// otelShutdownTimeout bounds how long flushing to the collector may take
+// once the run is over.
+const otelShutdownTimeout = 5 * time.Second
+
+// startOTelRun starts exporting the run if -otlp-endpoint is set. It
+// returns nil otherwise, or if the run could not be started.
+func (b *Benchmark) startOTelRun(name string, write bool, counters []benchmarkconn.Counter) *otel.Run {
+	if b.otelExporter == nil {
+		return nil
+	}
+
+	_, run, err := b.otelExporter.StartRun(context.Background(), name, counters...)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to export the run to OpenTelemetry: %v", err))
+		return nil
+	}
+	operation := "read"
+	if write {
+		operation = "write"
+	}
+	run.SetAttributes(
+		attribute.String("benchmark.type", b.benchType),
+		attribute.String("benchmark.operation", operation),
+		attribute.String("benchmark.network", *b.network),
+	)
+	return run
+}
+
+// shutdownOTel flushes the exported runs to the collector.
+func (b *Benchmark) shutdownOTel() {
+	if b.otelExporter == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), otelShutdownTimeout)
+	defer cancel()
+	if err := b.otelExporter.Shutdown(ctx); err != nil {
+		slog.Warn(fmt.Sprintf("failed to flush to the OpenTelemetry collector: %v", err))
+	}
+}
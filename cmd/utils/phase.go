@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// phaseSpec is a phase of the phased type.
+type phaseSpec struct {
+	name      string // name is the phase as listed, e.g., pressure:read
+	benchType string
+	reverse   bool // the side running write reads in this phase
+}
+
+// parsePhases parses the phases of the phased type from comma-separated
+// <type>:<operation> pairs, e.g., pressure:write,echo:write,pressure:read,
+// where the operation is the one of the side running write.
+func parsePhases(spec string) ([]phaseSpec, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("phased type requires -phases")
+	}
+
+	var phases []phaseSpec
+	for _, phase := range strings.Split(spec, ",") {
+		phase = strings.TrimSpace(phase)
+		benchType, op, ok := strings.Cut(phase, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid phase %q, must be <type>:<operation>", phase)
+		}
+
+		switch benchType {
+		case "pressure", "echo", "bidir", "tinywrite":
+		default:
+			return nil, fmt.Errorf("invalid phase %q, type must be pressure, echo, bidir or tinywrite", phase)
+		}
+
+		switch op {
+		case "write":
+			phases = append(phases, phaseSpec{name: phase, benchType: benchType})
+		case "read":
+			phases = append(phases, phaseSpec{name: phase, benchType: benchType, reverse: true})
+		default:
+			return nil, fmt.Errorf("invalid phase %q, operation must be write or read", phase)
+		}
+	}
+
+	return phases, nil
+}
+
+// newPhasedBenchmark creates the phased benchmark from the parsed phases,
+// each configured from the same flags.
+func (b *Benchmark) newPhasedBenchmark(control *benchmarkconn.ControlChannel) benchmarkconn.Benchmark {
+	phased := &benchmarkconn.PhasedBenchmark{}
+	for _, phase := range b.phases {
+		phased.Phases = append(phased.Phases, benchmarkconn.Phase{
+			Name:      phase.name,
+			Benchmark: b.newBenchmarkOfType(phase.benchType, control),
+			Reverse:   phase.reverse,
+		})
+	}
+	return phased
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// onProgress returns the progress callback logging the progress of the
+// run if -progress or -soak is set, and a summary of the messages failing
+// verification over each interval if -verify is set, nil otherwise. With
+// -soak, it also records each snapshot as an interim result, with -format
+// iperf3, as an interval, and with -table, it logs it as a row.
+func (b *Benchmark) onProgress() func(benchmarkconn.ProgressSnapshot) {
+	logProgress := *b.progress > 0 || *b.soak > 0
+	iperf3 := *b.format == "iperf3" && *b.parallel == 1
+	if !logProgress && !*b.verify && !iperf3 && !*b.table {
+		return nil
+	}
+	rows := &tableRecorder{} // one table per benchmark, e.g., both sides of selftest
+
+	return func(snapshot benchmarkconn.ProgressSnapshot) {
+		if iperf3 {
+			b.recordIPerf3Interval(snapshot, b.command == "write")
+		}
+		if *b.table {
+			rows.log(snapshot)
+		}
+		if len(snapshot.MessageErrors) > 0 {
+			slog.Warn(fmt.Sprintf("messages failing verification since the last report: %s", formatMessageErrors(snapshot.MessageErrors)))
+		}
+		if snapshot.Done || !logProgress {
+			return // the result is logged instead
+		}
+		if *b.soak > 0 {
+			b.recordInterim(snapshot)
+		}
+
+		slog.Info("progress: " + formatProgress(snapshot))
+	}
+}
+
+// onPeerProgress returns the callback logging the progress the peer sends
+// over the control connection if -progress is set, nil otherwise.
+func (b *Benchmark) onPeerProgress() func(benchmarkconn.ProgressSnapshot) {
+	if *b.progress <= 0 {
+		return nil
+	}
+	return func(snapshot benchmarkconn.ProgressSnapshot) {
+		if !snapshot.Done { // the result of the peer is logged instead
+			slog.Info("peer progress: " + formatProgress(snapshot))
+		}
+	}
+}
+
+// formatProgress formats the messages, throughput and latency of snapshot.
+func formatProgress(snapshot benchmarkconn.ProgressSnapshot) string {
+	messages := fmt.Sprintf("%d written, %d read", snapshot.MessagesWritten, snapshot.MessagesRead)
+	if snapshot.TotalMessages > 0 {
+		messages += fmt.Sprintf(" of %d", snapshot.TotalMessages)
+	}
+	var latency string
+	if p99, ok := snapshot.LatencyPercentiles["p99"]; ok {
+		latency = fmt.Sprintf(", %s p99 latency", time.Duration(p99))
+	}
+	return fmt.Sprintf("%s messages, %.2f Mbps%s, %s elapsed", messages, snapshot.ThroughputBps/1e6, latency, snapshot.Elapsed.Round(time.Millisecond))
+}
+
+// tableRecorder logs the progress snapshots of a run as the rows of an
+// iperf-style table of the transfer and bitrate of each interval.
+type tableRecorder struct {
+	mutex       sync.Mutex
+	header      bool
+	lastElapsed time.Duration
+}
+
+// log logs the interval since the previous snapshot as a row, preceded by
+// the header on the first one.
+func (r *tableRecorder) log(snapshot benchmarkconn.ProgressSnapshot) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	seconds := (snapshot.Elapsed - r.lastElapsed).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	if !r.header {
+		slog.Info(fmt.Sprintf("%-17s %12s %16s", "Interval", "Transfer", "Bitrate"))
+		r.header = true
+	}
+	transfer := snapshot.ThroughputBps * seconds / 8
+	slog.Info(fmt.Sprintf("%6.2f-%-6.2f sec %6.2f MBytes %8.2f Mbits/sec", r.lastElapsed.Seconds(), snapshot.Elapsed.Seconds(), transfer/(1<<20), snapshot.ThroughputBps/1e6))
+	r.lastElapsed = snapshot.Elapsed
+}
+
+// formatMessageErrors formats the message errors by kind, e.g.,
+// "3 corrupted, 1 out_of_order".
+func formatMessageErrors(counts map[string]uint64) string {
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%d %s", counts[kind], kind)
+	}
+	return strings.Join(parts, ", ")
+}
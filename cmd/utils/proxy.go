@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// newProxyDialer creates a dialer connecting through the proxy at rawURL,
+// either socks5://[user:password@]host:port or
+// http://[user:password@]host:port using HTTP CONNECT.
+func newProxyDialer(rawURL string) (proxy.Dialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return proxy.FromURL(u, proxy.Direct)
+	case "http":
+		return &httpConnectDialer{proxy: u}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, must be socks5 or http", u.Scheme)
+	}
+}
+
+// httpConnectDialer dials through an HTTP proxy using the CONNECT method.
+type httpConnectDialer struct {
+	proxy *url.URL
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := net.Dial(network, d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect: %s", resp.Status)
+	}
+
+	// the server may have sent data right after the response, e.g., the
+	// benchmark spec when it is the writer
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads from a buffered reader holding data already read
+// from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
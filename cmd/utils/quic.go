@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	quicNetwork = "quic"
+	quicALPN    = "benchmarkconn"
+
+	// quicCloseTimeout bounds how long closing a stream waits for the peer
+	// to close its side, since closing a QUIC connection discards any data
+	// not yet delivered.
+	quicCloseTimeout = 3 * time.Second
+)
+
+// quicStreamPreamble is written by the dialer when opening the stream,
+// since a stream is only announced to the listener with its first frame
+// and the first message of a benchmark may come from either side.
+var quicStreamPreamble = []byte{0}
+
+// quicDial dials a QUIC connection and opens a single stream on it.
+func quicDial(address string, tlsConfig *tls.Config) (net.Conn, error) {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{quicALPN}
+
+	conn, err := quic.DialAddr(context.Background(), address, tlsConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	// announce the stream to the listener before the benchmark starts
+	if _, err := stream.Write(quicStreamPreamble); err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	return &quicStreamConn{Stream: stream, conn: conn}, nil
+}
+
+// quicListen listens for QUIC connections.
+func quicListen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{quicALPN}
+
+	l, err := quic.ListenAddr(address, tlsConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quicListener{Listener: l}, nil
+}
+
+// quicListener adapts a QUIC listener to net.Listener, accepting the first
+// stream of each QUIC connection.
+type quicListener struct {
+	*quic.Listener
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.AcceptStream(context.Background())
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(stream, make([]byte, len(quicStreamPreamble))); err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	return &quicStreamConn{Stream: stream, conn: conn}, nil
+}
+
+// quicStreamConn adapts a QUIC stream to net.Conn.
+type quicStreamConn struct {
+	quic.Stream
+	conn quic.Connection
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicStreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the stream gracefully, waiting for the peer to close its
+// side of the stream so that data still in flight is delivered, then
+// closes the connection.
+func (c *quicStreamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.Stream.Close()
+
+		c.Stream.SetReadDeadline(time.Now().Add(quicCloseTimeout))
+		io.Copy(io.Discard, c.Stream)
+
+		c.closeErr = c.conn.CloseWithError(0, "")
+	})
+	return c.closeErr
+}
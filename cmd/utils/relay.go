@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// relayName is the name of the relay in logs and result files.
+const relayName = "Relay"
+
+// validateRelay checks the flags of a relay, whose operation is the relay
+// mode.
+func (b *Benchmark) validateRelay() error {
+	mode, err := benchmarkconn.ParseRelayMode(b.command)
+	if err != nil {
+		return fmt.Errorf("relay operation must be either \"copy\" or \"splice\", got %q", b.command)
+	}
+	if *b.upstream == "" {
+		return errors.New("relay requires -upstream")
+	}
+	switch *b.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("relay forwards raw bytes and does not support -net %s", *b.network)
+	}
+	b.relayMode = mode
+	return nil
+}
+
+// relayServer accepts as many connections as the peers establish, each
+// forwarded to the upstream server until both sides close it, and reports
+// the cost of forwarding in the selected mode.
+func (b *Benchmark) relayServer() error {
+	l, err := net.Listen(*b.network, b.addr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to listen on %s: %v\n", b.addr, err))
+		return nil
+	}
+	defer l.Close()
+
+	slog.Info(fmt.Sprintf("relay started, listening on %s, forwarding to %s with %s", l.Addr(), *b.upstream, b.relayMode))
+
+	relay := &benchmarkconn.Relay{Mode: b.relayMode}
+	var conns []net.Conn
+	var connsMutex sync.Mutex
+	timer := time.AfterFunc(*b.timeout, func() {
+		slog.Warn("timed out, closing the connections")
+		connsMutex.Lock()
+		defer connsMutex.Unlock()
+		closeAll(conns)
+	})
+	defer timer.Stop()
+
+	var wg sync.WaitGroup
+	var errsMutex sync.Mutex
+	var errs []error
+	for i := 0; i < b.totalConns(); i++ {
+		in, out, err := b.acceptRelayed(l)
+		if err != nil {
+			slog.Error(err.Error())
+			connsMutex.Lock()
+			closeAll(conns)
+			connsMutex.Unlock()
+			break
+		}
+		connsMutex.Lock()
+		conns = append(conns, in, out)
+		connsMutex.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := relay.Forward(in, out); err != nil {
+				slog.Error(fmt.Sprintf("(*%s).Forward: %v", relayName, err))
+				errsMutex.Lock()
+				errs = append(errs, err)
+				errsMutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = errors.Join(errs...)
+	result := relay.Result()
+	result["runtime"] = benchmarkconn.RuntimeSettings()
+	slog.Info(fmt.Sprintf("%s Result: %v", relayName, result))
+
+	var assertionErr error
+	var assertions []benchmarkconn.AssertionResult
+	if len(b.assertions) > 0 {
+		assertions, assertionErr = b.evaluateAssertions(result, err)
+	}
+	if len(b.sinks.sinks) > 0 {
+		b.writeSinks(b.newResultRecord(relayName, result, err), false)
+	}
+	if *b.output != "" {
+		record := b.newResultRecord(relayName, result, err)
+		record.Assertions = assertions
+		if err := WriteResultFile(*b.output, record); err != nil {
+			slog.Error(fmt.Sprintf("failed to write result file: %v", err))
+		}
+		if *b.manifest {
+			if err := WriteManifestFile(ManifestPath(*b.output), b.newManifest()); err != nil {
+				slog.Error(fmt.Sprintf("failed to write manifest file: %v", err))
+			}
+		}
+	}
+	return assertionErr
+}
+
+// acceptRelayed accepts the next connection passing the accept hooks and
+// dials the upstream server for it, applying the socket options to both.
+func (b *Benchmark) acceptRelayed(l net.Listener) (in, out net.Conn, err error) {
+	for {
+		in, err = l.Accept()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to accept connection: %w", err)
+		}
+		remote := in.RemoteAddr()
+		if in, err = b.onAccept(in); err != nil {
+			slog.Warn(fmt.Sprintf("rejected connection from %s: %v", remote, err))
+			continue
+		}
+		break
+	}
+
+	out, err = net.Dial(*b.network, *b.upstream)
+	if err != nil {
+		in.Close()
+		return nil, nil, fmt.Errorf("failed to dial %s: %w", *b.upstream, err)
+	}
+	for _, c := range []net.Conn{in, out} {
+		if err := b.connTuner().Tune(c); err != nil {
+			in.Close()
+			out.Close()
+			return nil, nil, fmt.Errorf("failed to tune the relayed connection: %w", err)
+		}
+	}
+	return in, out, nil
+}
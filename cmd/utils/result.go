@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// ResultRecord is the content of a result file written with -o. It
+// carries enough metadata for the result to be interpreted on its own.
+type ResultRecord struct {
+	Benchmark string            `json:"benchmark"`        // Benchmark is the name of the benchmark, e.g., PressuredBenchmark
+	Type      string            `json:"type"`             // Type is the bench type given on the command line, e.g., pressure
+	Operation string            `json:"operation"`        // Operation is either write or read
+	Network   string            `json:"network"`          // Network is the network type, e.g., tcp
+	Address   string            `json:"address"`          // Address is the server address
+	Time      time.Time         `json:"time"`             // Time is when the result was recorded
+	Digits    int               `json:"digits,omitempty"` // Digits is the significant digits floating-point values are rounded to, full precision if 0
+	Flags     map[string]string `json:"flags,omitempty"`  // Flags holds all flags explicitly set on the command line
+	Result    map[string]any    `json:"result"`           // Result is the result of the benchmark
+	Error     string            `json:"error,omitempty"`  // Error is set if the benchmark failed
+
+	Assertions []benchmarkconn.AssertionResult `json:"assertions,omitempty"` // Assertions holds the outcome of each -assert flag, if any
+	Interim    []map[string]any                `json:"interim,omitempty"`    // Interim holds the interim results of a -soak run in order, if any
+}
+
+// WriteResultFile writes the record as indented JSON to path, with the
+// floating-point values of the result and the interim results rounded to
+// record.Digits significant digits. Fields are written in a stable order,
+// map keys sorted, so that result files diff cleanly.
+func WriteResultFile(path string, record *ResultRecord) error {
+	benchmarkconn.RoundResult(record.Result, record.Digits)
+	for _, interim := range record.Interim {
+		benchmarkconn.RoundResult(interim, record.Digits)
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReadResultFile reads a record written by WriteResultFile.
+func ReadResultFile(path string) (*ResultRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var record ResultRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// newResultRecord creates a record for the result of the benchmark.
+func (b *Benchmark) newResultRecord(name string, result map[string]any, err error) *ResultRecord {
+	record := &ResultRecord{
+		Benchmark: name,
+		Type:      b.benchType,
+		Operation: b.command,
+		Network:   *b.network,
+		Address:   b.addr,
+		Time:      time.Now(),
+		Digits:    *b.digits,
+		Flags:     make(map[string]string),
+		Result:    result,
+	}
+	b.fs.Visit(func(f *flag.Flag) {
+		record.Flags[f.Name] = f.Value.String()
+	})
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// reexecEnv marks the environment of a process re-executed with GODEBUG,
+// so that it is never re-executed again.
+const reexecEnv = "BENCHMARKCONN_GODEBUG_REEXEC"
+
+// reexecWithGODEBUG runs the current executable again with the given
+// GODEBUG settings appended to the environment, since they can only take
+// effect at process start. It exits with the child's exit code and only
+// returns on failure to start the child, or if the settings are still
+// pending in the child.
+func reexecWithGODEBUG(settings []string) error {
+	if os.Getenv(reexecEnv) != "" {
+		return fmt.Errorf("GODEBUG settings %s still pending after restarting", strings.Join(settings, ","))
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable for applying GODEBUG: %w", err)
+	}
+
+	godebug := strings.Join(settings, ",")
+	if current := os.Getenv("GODEBUG"); current != "" {
+		godebug = current + "," + godebug // later settings take precedence
+	}
+
+	slog.Info(fmt.Sprintf("restarting with GODEBUG=%s", godebug))
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), "GODEBUG="+godebug, reexecEnv+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
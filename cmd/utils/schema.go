@@ -0,0 +1,30 @@
+package utils
+
+import (
+	_ "embed"
+	"flag"
+	"os"
+)
+
+// ResultSchema is the JSON Schema of the result files written with -o,
+// so that analysis tools, e.g., notebooks, can validate them before
+// loading.
+//
+//go:embed result.schema.json
+var ResultSchema []byte
+
+// Schema runs the schema subcommand, which writes ResultSchema to the
+// file given by -o, or to stdout.
+func Schema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	output := fs.String("o", "", "write the JSON Schema of the result files to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(ResultSchema)
+		return err
+	}
+	return os.WriteFile(*output, ResultSchema, 0o644)
+}
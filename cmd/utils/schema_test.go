@@ -0,0 +1,302 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validateSchema validates the JSON value against the subset of JSON Schema
+// 2020-12 that ResultSchema uses, failing on any other keyword so that
+// the schema cannot use one this test does not check.
+func validateSchema(root, schema map[string]any, value any, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := resolveRef(root, ref)
+		if err != nil {
+			return err
+		}
+		if err := validateSchema(root, target, value, path); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	object, isObject := value.(map[string]any)
+	for _, key := range keys {
+		keyword := schema[key]
+		switch key {
+		case "$schema", "$id", "$defs", "$ref", "title", "description":
+		case "type":
+			if !hasType(value, keyword.(string)) {
+				return fmt.Errorf("%s: expected %s, got %T", path, keyword, value)
+			}
+		case "enum":
+			found := false
+			for _, allowed := range keyword.([]any) {
+				found = found || reflect.DeepEqual(allowed, value)
+			}
+			if !found {
+				return fmt.Errorf("%s: %v is not one of %v", path, value, keyword)
+			}
+		case "minimum":
+			n, ok := value.(json.Number)
+			if !ok {
+				continue
+			}
+			v, _ := new(big.Float).SetString(n.String())
+			min, _ := new(big.Float).SetString(keyword.(json.Number).String())
+			if v.Cmp(min) < 0 {
+				return fmt.Errorf("%s: %v is below the minimum of %v", path, value, keyword)
+			}
+		case "format":
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			if keyword != "date-time" {
+				return fmt.Errorf("%s: unsupported format %v", path, keyword)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time: %w", path, s, err)
+			}
+		case "oneOf":
+			var matched int
+			for _, sub := range keyword.([]any) {
+				if validateSchema(root, sub.(map[string]any), value, path) == nil {
+					matched++
+				}
+			}
+			if matched != 1 {
+				return fmt.Errorf("%s: expected exactly one schema of oneOf to match, %d did", path, matched)
+			}
+		case "items":
+			array, ok := value.([]any)
+			if !ok {
+				continue
+			}
+			for i, item := range array {
+				if err := validateSchema(root, keyword.(map[string]any), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		case "required":
+			if !isObject {
+				continue
+			}
+			for _, name := range keyword.([]any) {
+				if _, ok := object[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required %v", path, name)
+				}
+			}
+		case "propertyNames":
+			for name := range object {
+				if err := validateSchema(root, keyword.(map[string]any), name, path+"."+name); err != nil {
+					return err
+				}
+			}
+		case "properties", "patternProperties", "additionalProperties":
+			// validated together below, as additionalProperties depends on the others
+		default:
+			return fmt.Errorf("%s: unsupported keyword %s", path, key)
+		}
+	}
+
+	if !isObject {
+		return nil
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	patterns, _ := schema["patternProperties"].(map[string]any)
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child, matched := object[name], false
+		if sub, ok := properties[name]; ok {
+			matched = true
+			if err := validateSchema(root, sub.(map[string]any), child, path+"."+name); err != nil {
+				return err
+			}
+		}
+		for pattern, sub := range patterns {
+			if !regexp.MustCompile(pattern).MatchString(name) {
+				continue
+			}
+			matched = true
+			if err := validateSchema(root, sub.(map[string]any), child, path+"."+name); err != nil {
+				return err
+			}
+		}
+		if matched {
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: unexpected property %s", path, name)
+			}
+		case map[string]any:
+			if err := validateSchema(root, additional, child, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef resolves a reference within the schema, e.g., #/$defs/count.
+func resolveRef(root map[string]any, ref string) (map[string]any, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %s", ref)
+	}
+	node := any(root)
+	for _, name := range strings.Split(ref[2:], "/") {
+		object, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %s", ref)
+		}
+		node = object[name]
+	}
+	target, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %s", ref)
+	}
+	return target, nil
+}
+
+// hasType reports whether the value, decoded with json.Number, is of the
+// JSON Schema type.
+func hasType(value any, typ string) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		return typ == "object"
+	case []any:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case nil:
+		return typ == "null"
+	case json.Number:
+		if typ == "number" {
+			return true
+		}
+		f, ok := new(big.Float).SetString(v.String())
+		return typ == "integer" && ok && f.IsInt()
+	}
+	return false
+}
+
+func decodeJSON(t *testing.T, data []byte) any {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// runWithResultFiles runs the benchmark between a client and a server over
+// loopback TCP, both writing a result file, and returns their paths.
+func runWithResultFiles(t *testing.T, benchType, clientOp, serverOp string, flags ...string) (client, server string) {
+	t.Helper()
+	dir := t.TempDir()
+	client, server = filepath.Join(dir, "client.json"), filepath.Join(dir, "server.json")
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	newBenchmark := func(op, output string) *Benchmark {
+		b := NewBenchmark()
+		b.SetBenchType(benchType)
+		b.SetCommand(op)
+		b.SetAddress(l.Addr().String())
+		if err := b.Init(append([]string{"-o", output}, flags...)); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	serverBench, clientBench := newBenchmark(serverOp, server), newBenchmark(clientOp, client)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- serverBench.ServerWithListener(l)
+	}()
+	if err := clientBench.Client(); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	return client, server
+}
+
+func TestResultSchema(t *testing.T) {
+	schema, ok := decodeJSON(t, ResultSchema).(map[string]any)
+	if !ok {
+		t.Fatal("expected the schema to be an object")
+	}
+
+	for _, tc := range []struct {
+		name                          string
+		benchType, clientOp, serverOp string
+		flags                         []string
+	}{
+		{name: "Pressure", benchType: "pressure", clientOp: "write", serverOp: "read", flags: []string{"-m", "200", "-verify", "-timestamps"}},
+		{name: "Control", benchType: "pressure", clientOp: "write", serverOp: "read", flags: []string{"-m", "200", "-control", "-assert", "successful_reads + successful_writes == 200"}},
+		{name: "Parallel", benchType: "pressure", clientOp: "read", serverOp: "write", flags: []string{"-m", "200", "-P", "2"}},
+		{name: "Echo", benchType: "echo", clientOp: "write", serverOp: "read", flags: []string{"-m", "50", "-i", "100us"}},
+		{name: "Soak", benchType: "pressure", clientOp: "write", serverOp: "read", flags: []string{"-target-duration", "300ms", "-soak", "100ms", "-i", "1ms", "-tcpinfo"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := runWithResultFiles(t, tc.benchType, tc.clientOp, tc.serverOp, tc.flags...)
+			for _, path := range []string{client, server} {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := validateSchema(schema, schema, decodeJSON(t, data), filepath.Base(path)); err != nil {
+					t.Errorf("expected the result file to match ResultSchema: %v", err)
+				}
+			}
+		})
+	}
+
+	// the validator must reject what the schema rules out, or the runs
+	// above prove nothing
+	t.Run("Invalid", func(t *testing.T) {
+		for _, record := range []string{
+			`{"benchmark":"PressuredBenchmark","type":"pressure","operation":"write","network":"tcp","address":"localhost:1","time":"2024-01-01T00:00:00Z"}`,
+			`{"benchmark":"PressuredBenchmark","type":"pressure","operation":"write","network":"tcp","address":"localhost:1","time":"yesterday","result":null}`,
+			`{"benchmark":"PressuredBenchmark","type":"pressure","operation":"write","network":"tcp","address":"localhost:1","time":"2024-01-01T00:00:00Z","result":{"bytes_read":-1}}`,
+			`{"benchmark":"PressuredBenchmark","type":"pressure","operation":"write","network":"tcp","address":"localhost:1","time":"2024-01-01T00:00:00Z","result":{"termination_reason":"boredom"}}`,
+			`{"benchmark":"PressuredBenchmark","type":"pressure","operation":"write","network":"tcp","address":"localhost:1","time":"2024-01-01T00:00:00Z","result":null,"unknown":1}`,
+		} {
+			if err := validateSchema(schema, schema, decodeJSON(t, []byte(record)), "record"); err == nil {
+				t.Errorf("expected %s to be invalid", record)
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// selfTestUnsupportedFlags are the flags describing how to connect to a
+// peer, which the self-test has none of.
+var selfTestUnsupportedFlags = []string{"P", "control", "compare", "decorate", "estimate", "soak", "o"}
+
+// SelfTest runs the selftest subcommand: both sides of the benchmark
+// described by the arguments, [<type>] [arguments...] with pressure if no
+// type is given, in this process over net.Pipe and loopback TCP, or only
+// the transport given by -net, to establish a baseline of the machine.
+func SelfTest(args []string) error {
+	benchType := "pressure"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		benchType, args = args[0], args[1:]
+	}
+	switch benchType {
+	case "pressure", "echo", "bidir", "ramp", "credit":
+	default:
+		return fmt.Errorf("selftest supports pressure, echo, bidir, ramp and credit, not %s", benchType)
+	}
+
+	b := NewBenchmark()
+	b.SetBenchType(benchType)
+	b.SetCommand("write")
+	b.SetAddress("selftest")
+	if err := b.Init(args); err != nil {
+		return err
+	}
+
+	transports := []string{benchmarkconn.SelfTestPipe, benchmarkconn.SelfTestTCP}
+	var unsupported error
+	b.fs.Visit(func(f *flag.Flag) {
+		if f.Name == "net" {
+			transports = []string{*b.network}
+		}
+		for _, name := range selfTestUnsupportedFlags {
+			if f.Name == name && unsupported == nil {
+				unsupported = fmt.Errorf("-%s is not supported by selftest", name)
+			}
+		}
+	})
+	if unsupported != nil {
+		return unsupported
+	}
+
+	for _, transport := range transports {
+		writerResult, readerResult, err := benchmarkconn.SelfTest(transport, b.newBenchmark)
+		if err != nil {
+			return fmt.Errorf("self-test over %s failed: %w", transport, err)
+		}
+		slog.Info(fmt.Sprintf("%s Writer Result: %v", transport, writerResult))
+		slog.Info(fmt.Sprintf("%s Reader Result: %v", transport, readerResult))
+		fmt.Printf("%s: %s\n", transport, selfTestSummary(writerResult, readerResult))
+	}
+	return nil
+}
+
+// selfTestSummary summarizes the throughput seen by the reader, the
+// round-trip latency seen by the writer for echo, and the best throughput
+// of the steps for credit and ramp.
+func selfTestSummary(writerResult, readerResult map[string]any) string {
+	var parts []string
+	if throughput, ok := readerResult["throughput_Mbps"]; ok {
+		parts = append(parts, fmt.Sprintf("%.4g Mbps", throughput))
+	}
+	if ops, ok := readerResult["ops_per_s"]; ok {
+		parts = append(parts, fmt.Sprintf("%.0f ops/s", ops))
+	}
+	for _, name := range []string{"p50", "p99"} {
+		if latency, ok := writerResult["latency_"+name+"_ns"]; ok {
+			parts = append(parts, fmt.Sprintf("%v ns %s latency", latency, name))
+		}
+	}
+	for _, result := range []map[string]any{writerResult, readerResult} {
+		for _, key := range []string{"max_throughput_Mbps", "max_sustainable_throughput_Mbps"} {
+			if throughput, ok := result[key]; ok {
+				parts = append(parts, fmt.Sprintf("%.4g Mbps at best", throughput))
+				return strings.Join(parts, ", ")
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return "done"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// sinkTimeout bounds how long writing a result to the sinks may take, so
+// an unreachable collector does not hold up the run.
+const sinkTimeout = 10 * time.Second
+
+// sinkList is a flag.Value collecting the result sinks given with repeated
+// -sink flags: stdout, file:<path>, or an http:// or https:// URL.
+type sinkList struct {
+	specs []string
+	sinks []benchmarkconn.ResultSink
+}
+
+func (l *sinkList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.specs, "; ")
+}
+
+func (l *sinkList) Set(spec string) error {
+	var sink benchmarkconn.ResultSink
+	switch {
+	case spec == "stdout":
+		sink = benchmarkconn.NewWriterSink(os.Stdout)
+	case strings.HasPrefix(spec, "file:") && len(spec) > len("file:"):
+		sink = benchmarkconn.NewFileSink(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		sink = benchmarkconn.NewHTTPSink(spec, nil)
+	default:
+		return fmt.Errorf("invalid sink %q, must be stdout, file:<path> or an http:// or https:// URL", spec)
+	}
+	l.specs = append(l.specs, spec)
+	l.sinks = append(l.sinks, sink)
+	return nil
+}
+
+// writeSinks writes the record to the sinks of -sink, if any, as an
+// interim result if interim is set, rounded as in result files.
+func (b *Benchmark) writeSinks(record *ResultRecord, interim bool) {
+	if len(b.sinks.sinks) == 0 {
+		return
+	}
+
+	report := benchmarkconn.ResultReport{
+		Benchmark: record.Benchmark,
+		Time:      record.Time,
+		Interim:   interim,
+		Labels: map[string]string{
+			"type":      record.Type,
+			"operation": record.Operation,
+			"network":   record.Network,
+			"address":   record.Address,
+		},
+		Result: record.Result,
+		Error:  record.Error,
+	}
+	benchmarkconn.RoundResult(report.Result, record.Digits)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+	if err := benchmarkconn.MultiSink(b.sinks.sinks...).WriteResult(ctx, report); err != nil {
+		slog.Warn(fmt.Sprintf("failed to write the result to a sink: %v", err))
+	}
+}
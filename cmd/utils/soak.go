@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// interimRecorder collects the interim results of a soak run.
+type interimRecorder struct {
+	mutex   sync.Mutex
+	name    string // the name of the benchmark, for the result file
+	results []map[string]any
+}
+
+// progressInterval returns the interval of the progress callback, the soak
+// interval if set, and that of iperf3's intervals, 1s, with -format iperf3
+// or -table unless -progress is set.
+func (b *Benchmark) progressInterval() time.Duration {
+	if *b.soak > 0 {
+		return *b.soak
+	}
+	if (*b.format == "iperf3" || *b.table) && *b.progress <= 0 {
+		return time.Second
+	}
+	return *b.progress
+}
+
+// recordInterim records snapshot as an interim result and, with -o,
+// rewrites the result file with all interim results so far, so a run
+// killed after hours still leaves them behind. With -sink, the interim
+// result is written to the sinks as well. Each interim result holds a
+// handful of numbers, so memory grows per interval rather than per message.
+func (b *Benchmark) recordInterim(snapshot benchmarkconn.ProgressSnapshot) {
+	interim := map[string]any{
+		"time":             time.Now().Format(time.RFC3339),
+		"elapsed":          snapshot.Elapsed.String(),
+		"messages_read":    snapshot.MessagesRead,
+		"messages_written": snapshot.MessagesWritten,
+		"throughput_Mbps":  snapshot.ThroughputBps / 1e6,
+	}
+	for name, value := range snapshot.LatencyPercentiles {
+		interim["latency_"+name+"_ns"] = value
+	}
+
+	b.interim.mutex.Lock()
+	b.interim.results = append(b.interim.results, interim)
+	name := b.interim.name
+	if *b.output != "" {
+		record := b.newResultRecord(name, nil, nil)
+		record.Interim = b.interim.results
+		if err := WriteResultFile(*b.output, record); err != nil {
+			slog.Warn(fmt.Sprintf("failed to write interim results: %v", err))
+		}
+	}
+	b.interim.mutex.Unlock()
+
+	// outside the lock, as a collector may be slow to respond
+	b.writeSinks(b.newResultRecord(name, interim, nil), true)
+}
+
+// interimResults returns the interim results recorded so far, if any.
+func (b *Benchmark) interimResults() []map[string]any {
+	b.interim.mutex.Lock()
+	defer b.interim.mutex.Unlock()
+	return b.interim.results
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const tlsNetwork = "tls"
+
+// clientTLSConfig creates the TLS configuration of the client from the
+// -tls-* flags, presenting a client certificate for mTLS if one is set.
+func (b *Benchmark) clientTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         *b.tlsServerName,
+		InsecureSkipVerify: *b.tlsInsecure,
+	}
+
+	if *b.tlsCA != "" {
+		pool, err := loadCertPool(*b.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if *b.tlsCert != "" || *b.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*b.tlsCert, *b.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// serverTLSConfig creates the TLS configuration of the server from the
+// -tls-* flags, with a self-signed certificate generated on the fly if
+// none is set. With a CA bundle, clients are required to present a
+// certificate signed by it (mTLS).
+func (b *Benchmark) serverTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if *b.tlsCert != "" || *b.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*b.tlsCert, *b.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if *b.tlsCA != "" {
+		pool, err := loadCertPool(*b.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// tlsClient performs the TLS handshake over conn, e.g., one dialed through
+// a proxy, defaulting the server name to the host of address.
+func tlsClient(conn net.Conn, address string, config *tls.Config) (net.Conn, error) {
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in CA bundle")
+	}
+	return pool, nil
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "benchmarkconn"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,340 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// testTLSConfigs returns the TLS configs of a listener with a self-signed
+// certificate and of a dialer trusting any.
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	cert, err := selfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, &tls.Config{InsecureSkipVerify: true}
+}
+
+// acceptOne accepts a single connection on l in the background.
+func acceptOne(t *testing.T, l net.Listener) <-chan net.Conn {
+	t.Helper()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("failed to accept: %v", err)
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	return accepted
+}
+
+// roundTrip runs a pressure benchmark from dialed to accepted, checking
+// that every message arrives.
+func roundTrip(t *testing.T, dialed, accepted net.Conn) {
+	t.Helper()
+	writer := &benchmarkconn.PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, Verify: true}
+	reader := &benchmarkconn.PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, Verify: true}
+
+	writerErr := make(chan error, 1)
+	go func() {
+		writerErr <- writer.Writer(dialed)
+	}()
+	if err := reader.Reader(accepted); err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	if err := <-writerErr; err != nil {
+		t.Fatalf("writer: %v", err)
+	}
+	if valid := reader.Result()["verify_valid"]; valid != uint64(1000) {
+		t.Errorf("expected 1000 valid messages, got %v", valid)
+	}
+}
+
+func TestQUIC(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	l, err := quicListen("localhost:0", serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		accepted := acceptOne(t, l)
+		dialed, err := quicDial(l.Addr().String(), clientTLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dialed.Close()
+		conn := <-accepted
+		if conn == nil {
+			t.FailNow()
+		}
+		defer conn.Close()
+
+		roundTrip(t, dialed, conn)
+	})
+
+	// the preamble announces the stream, so that the listener accepts it
+	// before the dialer sends anything
+	t.Run("ListenerFirst", func(t *testing.T) {
+		accepted := acceptOne(t, l)
+		dialed, err := quicDial(l.Addr().String(), clientTLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dialed.Close()
+
+		var conn net.Conn
+		select {
+		case conn = <-accepted:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the stream to be accepted before any data is sent")
+		}
+		if conn == nil {
+			t.FailNow()
+		}
+		defer conn.Close()
+
+		go conn.Write([]byte("hello"))
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(dialed, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("expected the listener to send first, got %q, %v", buf, err)
+		}
+	})
+
+	// closing waits for the data in flight to be delivered, rather than
+	// discarding it with the connection
+	t.Run("GracefulClose", func(t *testing.T) {
+		accepted := acceptOne(t, l)
+		dialed, err := quicDial(l.Addr().String(), clientTLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := <-accepted
+		if conn == nil {
+			t.FailNow()
+		}
+
+		data := make([]byte, 4<<20)
+		rand.Read(data)
+		received := make(chan []byte, 1)
+		go func() {
+			all, _ := io.ReadAll(conn)
+			conn.Close() // closes the side of the stream the dialer waits for
+			received <- all
+		}()
+
+		if _, err := dialed.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := dialed.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if all := <-received; !bytes.Equal(all, data) {
+			t.Errorf("expected all %d bytes written before closing to be delivered, got %d", len(data), len(all))
+		}
+	})
+}
+
+func TestWebSocket(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	for _, tc := range []struct {
+		name                 string
+		serverTLS, clientTLS *tls.Config
+	}{
+		{"WS", nil, nil},
+		{"WSS", serverTLS, clientTLS},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := wsListen("localhost:0", tc.serverTLS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			accepted := acceptOne(t, l)
+			dialed, err := wsDial(l.Addr().String(), tc.clientTLS, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dialed.Close()
+			conn := <-accepted
+			if conn == nil {
+				t.FailNow()
+			}
+			defer conn.Close()
+
+			roundTrip(t, dialed, conn)
+		})
+	}
+
+	t.Run("Closed", func(t *testing.T) {
+		l, err := wsListen("localhost:0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+		if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected accepting on a closed listener to fail with net.ErrClosed, got %v", err)
+		}
+	})
+}
+
+// connectProxy is an HTTP CONNECT proxy requiring credentials, if set.
+// It sends the response along with the first bytes of the target, if
+// early, in a single write, as a proxy may.
+type connectProxy struct {
+	net.Listener
+	credentials string
+	early       int
+}
+
+func newConnectProxy(t *testing.T, credentials string, early int) *connectProxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &connectProxy{Listener: l, credentials: credentials, early: early}
+	go p.serve()
+	return p
+}
+
+func (p *connectProxy) serve() {
+	for {
+		conn, err := p.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *connectProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	if p.credentials != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(p.credentials)) {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+
+	response := []byte("HTTP/1.1 200 Connection established\r\n\r\n")
+	if p.early > 0 {
+		early := make([]byte, p.early)
+		if _, err := io.ReadFull(target, early); err != nil {
+			return
+		}
+		response = append(response, early...)
+	}
+	if _, err := conn.Write(response); err != nil {
+		return
+	}
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	target, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		p := newConnectProxy(t, "user:secret", 0)
+		defer p.Close()
+		dialer, err := newProxyDialer("http://user:secret@" + p.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		accepted := acceptOne(t, target)
+		dialed, err := dialer.Dial("tcp", target.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dialed.Close()
+		conn := <-accepted
+		if conn == nil {
+			t.FailNow()
+		}
+		defer conn.Close()
+
+		roundTrip(t, dialed, conn)
+	})
+
+	// the server may send first, e.g., the spec when it is the writer, so
+	// its first bytes may arrive along with the response of the proxy
+	t.Run("EarlyData", func(t *testing.T) {
+		p := newConnectProxy(t, "", 5)
+		defer p.Close()
+		dialer, err := newProxyDialer("http://" + p.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("hello, world"))
+			io.Copy(io.Discard, conn)
+		}()
+		dialed, err := dialer.Dial("tcp", target.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dialed.Close()
+
+		if _, ok := dialed.(*bufferedConn); !ok {
+			t.Errorf("expected the data following the response to be buffered, got %T", dialed)
+		}
+		buf := make([]byte, len("hello, world"))
+		if _, err := io.ReadFull(dialed, buf); err != nil || string(buf) != "hello, world" {
+			t.Errorf("expected the early data followed by the rest, got %q, %v", buf, err)
+		}
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		p := newConnectProxy(t, "user:secret", 0)
+		defer p.Close()
+		dialer, err := newProxyDialer("http://user:wrong@" + p.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn, err := dialer.Dial("tcp", target.Addr().String()); err == nil {
+			conn.Close()
+			t.Error("expected the proxy to refuse wrong credentials")
+		}
+	})
+
+	if _, err := newProxyDialer("ftp://localhost:21"); err == nil {
+		t.Error("expected an unsupported proxy scheme to be rejected")
+	}
+}
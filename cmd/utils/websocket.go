@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	wsNetwork  = "ws"
+	wssNetwork = "wss"
+	wsPath     = "/"
+)
+
+// wsDial dials a WebSocket connection, through proxyDialer if not nil.
+// Each write is sent as a single binary frame. With a TLS config, the
+// connection is made over wss://.
+func wsDial(address string, tlsConfig *tls.Config, proxyDialer proxy.Dialer) (net.Conn, error) {
+	scheme, origin := "ws://", "http://"
+	if tlsConfig != nil {
+		scheme, origin = "wss://", "https://"
+	}
+
+	config, err := websocket.NewConfig(scheme+address+wsPath, origin+address)
+	if err != nil {
+		return nil, err
+	}
+	config.TlsConfig = tlsConfig
+
+	var conn *websocket.Conn
+	if proxyDialer != nil {
+		var rawConn net.Conn
+		rawConn, err = proxyDialer.Dial(defaultNetwork, address)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig != nil {
+			if rawConn, err = tlsClient(rawConn, address, tlsConfig); err != nil {
+				return nil, err
+			}
+		}
+		if conn, err = websocket.NewClient(config, rawConn); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+	} else if conn, err = websocket.DialConfig(config); err != nil {
+		return nil, err
+	}
+	conn.PayloadType = websocket.BinaryFrame
+
+	return conn, nil
+}
+
+// wsListen listens for WebSocket connections on address. With a TLS
+// config, connections are accepted over wss://.
+func wsListen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	l, err := net.Listen(defaultNetwork, address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	wsl := &wsListener{
+		Listener: l,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(wsPath, websocket.Server{Handler: wsl.handle}) // no origin check
+	go func() {
+		err := http.Serve(l, mux)
+		wsl.closeOnce.Do(func() {
+			wsl.err = err
+			close(wsl.closed)
+		})
+	}()
+
+	return wsl, nil
+}
+
+// wsListener adapts an HTTP server upgrading requests to WebSocket to
+// net.Listener.
+type wsListener struct {
+	net.Listener
+
+	conns     chan net.Conn
+	err       error // why the server stopped, set before closed is closed, nil if closed locally
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// handle hands the connection over to Accept and keeps the handler
+// running until the connection is closed, since the server closes the
+// connection once the handler returns.
+func (l *wsListener) handle(conn *websocket.Conn) {
+	conn.PayloadType = websocket.BinaryFrame
+
+	c := &wsServerConn{Conn: conn, done: make(chan struct{})}
+	select {
+	case l.conns <- c:
+		<-c.done
+	case <-l.closed:
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.closed) })
+	return err
+}
+
+// wsServerConn signals the handler when the connection is closed.
+type wsServerConn struct {
+	*websocket.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *wsServerConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { close(c.done) })
+	return err
+}
@@ -0,0 +1,85 @@
+package benchmarkconn
+
+import (
+	"net"
+	"sync"
+)
+
+// segmentStats are the data segments sent and received on a connection
+// so far, and the maximum segment sizes, as reported by the kernel.
+type segmentStats struct {
+	segsOut uint32 // data segments sent, excluding pure ACKs
+	segsIn  uint32 // data segments received, excluding pure ACKs
+	sndMSS  uint32
+	rcvMSS  uint32
+}
+
+// coalescingRecorder records the data segments on the wire over a run, so
+// that the application writes can be compared with the segments they were
+// sent in, revealing whether Nagle, corking or segmentation offloads
+// behave as expected for the message size. It is only supported for TCP
+// connections on Linux, possibly wrapped in TLS.
+type coalescingRecorder struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	start *segmentStats
+	end   *segmentStats
+}
+
+func (r *coalescingRecorder) startRecording(conn net.Conn) {
+	start := readSegmentStats(conn)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.conn, r.start, r.end = conn, start, nil
+}
+
+func (r *coalescingRecorder) stopRecording() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.start == nil {
+		return
+	}
+	r.end = readSegmentStats(r.conn)
+}
+
+// addResult adds the data segments sent and received over the run to
+// result as data_segs_out and data_segs_in, along with snd_mss and
+// rcv_mss. If messages totaling bytesWritten were written, it adds the
+// number of writes per segment sent as coalescing_ratio, above 1 when
+// writes are coalesced and below 1 when they are split, and the bytes per
+// segment sent as bytes_per_segment_out. Likewise for the messages read,
+// it adds bytes_per_segment_in. With ProfileConstrained, the bytes per segment are
+// integers and the coalescing ratio is left out.
+func (r *coalescingRecorder) addResult(result map[string]any, bytesRead, bytesWritten, reads, writes uint64, profile Profile) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.start == nil || r.end == nil {
+		return
+	}
+
+	segsOut := uint64(r.end.segsOut - r.start.segsOut) // wraps around like the kernel counters
+	segsIn := uint64(r.end.segsIn - r.start.segsIn)
+	result["data_segs_out"] = segsOut
+	result["data_segs_in"] = segsIn
+	result["snd_mss"] = r.end.sndMSS
+	result["rcv_mss"] = r.end.rcvMSS
+
+	if profile == ProfileConstrained { // integer-only stats
+		if writes > 0 && segsOut > 0 {
+			result["bytes_per_segment_out"] = bytesWritten / segsOut
+		}
+		if reads > 0 && segsIn > 0 {
+			result["bytes_per_segment_in"] = bytesRead / segsIn
+		}
+		return
+	}
+	if writes > 0 && segsOut > 0 {
+		result["coalescing_ratio"] = float64(writes) / float64(segsOut)
+		result["bytes_per_segment_out"] = float64(bytesWritten) / float64(segsOut)
+	}
+	if reads > 0 && segsIn > 0 {
+		result["bytes_per_segment_in"] = float64(bytesRead) / float64(segsIn)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package benchmarkconn
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// readSegmentStats queries TCP_INFO from conn, or returns nil if conn is
+// not a TCP connection or is closed.
+func readSegmentStats(conn net.Conn) *segmentStats {
+	tcpConn, ok := underlyingConn(conn).(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil || sockErr != nil {
+		return nil // connection is likely closed
+	}
+
+	return &segmentStats{
+		segsOut: info.Data_segs_out,
+		segsIn:  info.Data_segs_in,
+		sndMSS:  info.Snd_mss,
+		rcvMSS:  info.Rcv_mss,
+	}
+}
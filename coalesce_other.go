@@ -0,0 +1,10 @@
+//go:build !linux
+
+package benchmarkconn
+
+import "net"
+
+// readSegmentStats is only supported on Linux.
+func readSegmentStats(conn net.Conn) *segmentStats {
+	return nil
+}
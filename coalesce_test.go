@@ -0,0 +1,34 @@
+package benchmarkconn_test
+
+import (
+	"runtime"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestCoalescing(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is only supported on Linux")
+	}
+
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 10000}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 10000}
+	runOverTCP(t, writer, reader)
+
+	result := writer.Result()
+	segs, ok := result["data_segs_out"].(uint64)
+	if !ok || segs == 0 {
+		t.Fatalf("expected data segments to be sent, got %v", result["data_segs_out"])
+	}
+	if ratio, ok := result["coalescing_ratio"].(float64); !ok || ratio <= 0 {
+		t.Errorf("expected a positive coalescing ratio, got %v", result["coalescing_ratio"])
+	}
+	if perSegment, _ := result["bytes_per_segment_out"].(float64); perSegment > float64(result["snd_mss"].(uint32)) {
+		t.Errorf("expected at most %v bytes per segment, got %v", result["snd_mss"], perSegment)
+	}
+
+	if _, ok := reader.Result()["bytes_per_segment_in"].(float64); !ok {
+		t.Errorf("expected the bytes per segment received, got %v", reader.Result()["bytes_per_segment_in"])
+	}
+}
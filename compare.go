@@ -0,0 +1,225 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// comparedMetrics are the result keys compared between the paths of a
+// PathComparison, if both paths report them.
+var comparedMetrics = []string{
+	"throughput_bps",
+	"ops_per_s",
+	"latency_ns",
+	"latency_p50_ns",
+	"latency_p99_ns",
+	"latency_p999_ns",
+}
+
+// PathComparison runs the same benchmark concurrently over two connections
+// taking different paths to the same peer, e.g., direct and proxied, or
+// IPv4 and IPv6, and compares their results. Unlike two runs one after
+// another, both paths experience the same time window and host load.
+//
+// PathComparison does not implement Benchmark since it operates on two
+// connections at once.
+type PathComparison struct {
+	// New creates the benchmark to run on each path. Both benchmarks
+	// created must be configured identically on both sides.
+	New func() Benchmark
+
+	// Labels name the paths in the result, e.g., "direct" and "proxied",
+	// "a" and "b" if not set.
+	Labels [2]string
+
+	// Control, if set, stops both paths promptly once the run is aborted.
+	// The benchmarks created by New keep their handshakes in-band, which
+	// would otherwise interleave on the control channel.
+	Control *ControlChannel
+
+	benchmarks [2]Benchmark
+	windows    [2][2]time.Time // the start and end of the run on each path
+	startTime  atomic.Value
+	endTime    atomic.Value
+
+	schedLatency    schedLatencyRecorder
+	combinedCounter *CombinedCounter
+}
+
+// Writer runs the writer side of the benchmark on both paths.
+func (c *PathComparison) Writer(a, b net.Conn, counters ...Counter) error {
+	return c.run([2]net.Conn{a, b}, counters, Benchmark.Writer)
+}
+
+// Reader runs the reader side of the benchmark on both paths.
+func (c *PathComparison) Reader(a, b net.Conn, counters ...Counter) error {
+	return c.run([2]net.Conn{a, b}, counters, Benchmark.Reader)
+}
+
+func (c *PathComparison) run(conns [2]net.Conn, counters []Counter, side func(Benchmark, net.Conn, ...Counter) error) (err error) {
+	if c.New == nil {
+		return errors.New("PathComparison requires New to be set")
+	}
+	if conns[0] == nil || conns[1] == nil {
+		return errors.New("PathComparison requires a connection for each path")
+	}
+	labels := c.labels()
+	if labels[0] == labels[1] {
+		return fmt.Errorf("PathComparison requires distinct labels, got %q twice", labels[0])
+	}
+
+	for i := range c.benchmarks {
+		c.benchmarks[i] = c.New()
+	}
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O errors
+	for _, conn := range conns {
+		defer c.Control.watchAbort(conn)()
+	}
+	defer func() {
+		if abortErr := c.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
+
+	// Create combined counter, shared by both paths as they share the host
+	c.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	c.schedLatency.startRecording()
+	defer c.schedLatency.stopRecording()
+	c.startTime.Store(time.Now())
+	defer func() {
+		c.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if c.combinedCounter != nil {
+		c.combinedCounter.Start()
+		defer c.combinedCounter.Stop()
+	}
+
+	// release both paths at once, so that neither gets a head start while
+	// the other goroutine is being scheduled
+	start := make(chan struct{})
+	errs := make([]error, len(conns))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer wg.Done()
+			<-start
+			c.windows[i][0] = time.Now()
+			if err := side(c.benchmarks[i], conn); err != nil {
+				errs[i] = fmt.Errorf("path %s: %w", labels[i], err)
+			}
+			c.windows[i][1] = time.Now()
+		}(i, conn)
+	}
+	close(start)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// labels returns the labels of the paths, defaulting to "a" and "b".
+func (c *PathComparison) labels() [2]string {
+	labels := c.Labels
+	if labels[0] == "" {
+		labels[0] = "a"
+	}
+	if labels[1] == "" {
+		labels[1] = "b"
+	}
+	return labels
+}
+
+// Result returns the result of each path keyed by its label under
+// "paths", and under "comparison" the difference and ratio of the second
+// path to the first for the metrics both report, e.g., a throughput ratio
+// of 0.8 means the second path achieved 80% of the throughput of the
+// first. The overlap is the fraction of the run during which both paths
+// were running, which should be close to 1 for a fair comparison.
+func (c *PathComparison) Result() map[string]any {
+	if c.endTime.Load() == nil || c.endTime.Load().(time.Time).IsZero() {
+		return map[string]any{}
+	}
+
+	labels := c.labels()
+	result := map[string]any{
+		"labels":     labels[:],
+		"start_time": c.startTime.Load().(time.Time).Format(time.RFC3339),
+		"end_time":   c.endTime.Load().(time.Time).Format(time.RFC3339),
+		"duration":   c.endTime.Load().(time.Time).Sub(c.startTime.Load().(time.Time)).String(),
+	}
+
+	var results [2]map[string]any
+	paths := make(map[string]any, len(labels))
+	for i, benchmark := range c.benchmarks {
+		results[i] = benchmark.Result()
+		results[i]["path_duration"] = c.windows[i][1].Sub(c.windows[i][0]).String()
+		paths[labels[i]] = results[i]
+	}
+	result["paths"] = paths
+
+	comparison := make(map[string]any)
+	for _, metric := range comparedMetrics {
+		a, okA := toFloat64(results[0][metric])
+		b, okB := toFloat64(results[1][metric])
+		if !okA || !okB {
+			continue
+		}
+		compared := map[string]any{
+			labels[0]: a,
+			labels[1]: b,
+			"delta":   b - a,
+		}
+		if a != 0 {
+			compared["ratio"] = b / a
+		}
+		comparison[metric] = compared
+	}
+	result["comparison"] = comparison
+	result["overlap"] = c.overlap()
+
+	c.schedLatency.addResult(result)
+
+	c.Control.addAbortResult(result)
+
+	if c.combinedCounter != nil {
+		result["counters"] = c.combinedCounter.Results()
+	}
+
+	return result
+}
+
+// overlap returns the fraction of the combined window of both paths during
+// which both were running.
+func (c *PathComparison) overlap() float64 {
+	a, b := c.windows[0], c.windows[1]
+	first, last := a[0], a[1]
+	if b[0].Before(first) {
+		first = b[0]
+	}
+	if b[1].After(last) {
+		last = b[1]
+	}
+	overlapStart, overlapEnd := a[0], a[1]
+	if b[0].After(overlapStart) {
+		overlapStart = b[0]
+	}
+	if b[1].Before(overlapEnd) {
+		overlapEnd = b[1]
+	}
+
+	union := last.Sub(first)
+	if union <= 0 || !overlapEnd.After(overlapStart) {
+		return 0
+	}
+	return float64(overlapEnd.Sub(overlapStart)) / float64(union)
+}
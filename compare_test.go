@@ -0,0 +1,87 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPathComparison(t *testing.T) {
+	newPressuredBenchmark := func() Benchmark {
+		return &PressuredBenchmark{
+			MessageSize:    1024,
+			TargetDuration: 300 * time.Millisecond,
+		}
+	}
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	// the second path delays each write, e.g., a detour through a proxy
+	var writerConns, readerConns [2]net.Conn
+	for i := range writerConns {
+		if writerConns[i], err = net.Dial("tcp", tcpListener.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+		defer writerConns[i].Close()
+		if readerConns[i], err = tcpListener.Accept(); err != nil {
+			t.Fatal(err)
+		}
+		defer readerConns[i].Close()
+	}
+	if writerConns[1], err = DecorateConn(writerConns[1], &DelayDecorator{Delay: 100 * time.Microsecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &PathComparison{New: newPressuredBenchmark, Labels: [2]string{"direct", "delayed"}}
+	reader := &PathComparison{New: newPressuredBenchmark, Labels: [2]string{"direct", "delayed"}}
+
+	var readerErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readerErr = reader.Reader(readerConns[0], readerConns[1])
+	}()
+	if err := writer.Writer(writerConns[0], writerConns[1]); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if readerErr != nil {
+		t.Fatal(readerErr)
+	}
+
+	result := reader.Result()
+	t.Logf("Reader: %v", result)
+
+	paths := result["paths"].(map[string]any)
+	if _, ok := paths["direct"]; !ok {
+		t.Errorf("expected the result of the direct path, got %v", paths)
+	}
+	if _, ok := paths["delayed"]; !ok {
+		t.Errorf("expected the result of the delayed path, got %v", paths)
+	}
+	if overlap := result["overlap"].(float64); overlap < 0.8 {
+		t.Errorf("expected the paths to run over the same window, got an overlap of %.2f", overlap)
+	}
+
+	throughput, ok := result["comparison"].(map[string]any)["throughput_bps"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected a throughput comparison, got %v", result["comparison"])
+	}
+	if ratio := throughput["ratio"].(float64); ratio >= 1 {
+		t.Errorf("expected the delayed path to be slower, got a throughput ratio of %.2f", ratio)
+	}
+
+	// identical labels would collide in the result
+	same := &PathComparison{New: newPressuredBenchmark, Labels: [2]string{"path", "path"}}
+	if err := same.Writer(writerConns[0], writerConns[1]); err == nil {
+		t.Error("expected identical labels to be rejected")
+	}
+}
@@ -0,0 +1,193 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Termination reasons, reported as termination_reason.
+const (
+	TerminationMessages = "messages" // the number of messages was sent
+	TerminationBytes    = "bytes"    // the number of bytes was sent
+	TerminationDuration = "duration" // the duration has elapsed
+	TerminationErrors   = "errors"   // the error budget was exhausted
+	TerminationIdle     = "idle"     // no datagram arrived for a while, the end markers were all lost
+)
+
+// Completion ends a run of a PressuredBenchmark or an IntervalBenchmark on
+// the first of several conditions, each ignored if not set. It is part of
+// the spec, so both sides must agree on it.
+//
+// The writer stops once it sent Messages messages, Bytes bytes or for
+// Duration, and signals the end of the run to the reader. Errors is only
+// enforced by the reader, which requires verification: once that many
+// messages failed, the reader ends its measurement and discards the rest
+// of the run, whereas MaxMessageErrors fails the run. With Echo, the reader
+// of an IntervalBenchmark still echoes the messages it discards.
+type Completion struct {
+	Messages uint64        `json:"messages,omitempty" yaml:"messages"` // Messages ends the run once this many messages were sent
+	Bytes    uint64        `json:"bytes,omitempty" yaml:"bytes"`       // Bytes ends the run once this many bytes were sent, including the length headers with a size distribution
+	Duration time.Duration `json:"duration,omitempty" yaml:"duration"` // Duration ends the run once it has elapsed
+	Errors   uint64        `json:"errors,omitempty" yaml:"errors"`     // Errors ends the measurement of the reader once this many messages failed verification
+}
+
+// ParseCompletion parses completion conditions as used by the command line
+// tools, comma-separated <condition>=<value> pairs of messages, bytes,
+// duration and errors, e.g., "messages=100000,duration=10s".
+func ParseCompletion(spec string) (*Completion, error) {
+	c := &Completion{}
+	for _, entry := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("completion condition %q must be <condition>=<value>", entry)
+		}
+
+		var err error
+		switch name {
+		case "messages":
+			c.Messages, err = strconv.ParseUint(value, 10, 64)
+		case "bytes":
+			c.Bytes, err = strconv.ParseUint(value, 10, 64)
+		case "duration":
+			c.Duration, err = time.ParseDuration(value)
+		case "errors":
+			c.Errors, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return nil, fmt.Errorf("unknown completion condition %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s completion condition: %w", name, err)
+		}
+	}
+	return c, nil
+}
+
+// String returns the conditions in the format parsed by ParseCompletion.
+func (c *Completion) String() string {
+	var conditions []string
+	if c.Messages > 0 {
+		conditions = append(conditions, "messages="+strconv.FormatUint(c.Messages, 10))
+	}
+	if c.Bytes > 0 {
+		conditions = append(conditions, "bytes="+strconv.FormatUint(c.Bytes, 10))
+	}
+	if c.Duration > 0 {
+		conditions = append(conditions, "duration="+c.Duration.String())
+	}
+	if c.Errors > 0 {
+		conditions = append(conditions, "errors="+strconv.FormatUint(c.Errors, 10))
+	}
+	return strings.Join(conditions, ",")
+}
+
+// validate checks that the writer has a condition to stop on, that the
+// error budget can be enforced, and that messages of messageSize bytes
+// can carry the end marker.
+func (c *Completion) validate(messageSize int, verify bool) error {
+	if c.Duration < 0 {
+		return errors.New("completion duration must not be negative")
+	}
+	if c.Messages == 0 && c.Bytes == 0 && c.Duration == 0 {
+		return errors.New("completion requires a number of messages, a number of bytes or a duration")
+	}
+	if c.Errors > 0 && !verify {
+		return errors.New("completion on errors requires verification")
+	}
+	if messageSize < minEndMarkerSize {
+		return errors.New("completion conditions require a message size of at least 16 bytes")
+	}
+	return nil
+}
+
+// reached returns the first condition of the writer reached after sent
+// messages totaling bytes, elapsed since the start of the run, or "" if
+// none.
+func (c *Completion) reached(sent, bytes uint64, elapsed time.Duration) string {
+	switch {
+	case c.Messages > 0 && sent >= c.Messages:
+		return TerminationMessages
+	case c.Bytes > 0 && bytes >= c.Bytes:
+		return TerminationBytes
+	case c.Duration > 0 && elapsed >= c.Duration:
+		return TerminationDuration
+	}
+	return ""
+}
+
+// writerTermination returns why the writer stops after sent messages
+// totaling bytes since start, or "" to send the next message.
+func (b *PressuredBenchmark) writerTermination(sent, bytes uint64, start time.Time) string {
+	return writerTermination(b.Completion, b.TotalMessages, b.TargetDuration, sent, bytes, start)
+}
+
+// readerTermination returns why the writer stopped, as signaled by the end
+// marker counting sent messages, totaling bytes.
+func (b *PressuredBenchmark) readerTermination(sent, bytes uint64) string {
+	return readerTermination(b.Completion, b.TargetDuration, sent, bytes)
+}
+
+// writerTermination returns why a writer stops after sent messages
+// totaling bytes since start, on the first condition of c if set, or
+// after totalMessages or the target duration, or "" to send the next
+// message.
+func writerTermination(c *Completion, totalMessages uint64, target time.Duration, sent, bytes uint64, start time.Time) string {
+	if c != nil {
+		return c.reached(sent, bytes, time.Since(start))
+	}
+	if keepSending(sent, totalMessages, start, target) {
+		return ""
+	}
+	if target > 0 {
+		return TerminationDuration
+	}
+	return TerminationMessages
+}
+
+// readerTermination returns why the writer stopped, as signaled by the end
+// marker counting sent messages, totaling bytes.
+func readerTermination(c *Completion, target time.Duration, sent, bytes uint64) string {
+	switch {
+	case c != nil:
+		// the writer stopped on the first condition reached, anything
+		// else must be the duration
+		if reason := c.reached(sent, bytes, 0); reason != "" {
+			return reason
+		}
+		return TerminationDuration
+	case target > 0:
+		return TerminationDuration
+	default:
+		return TerminationMessages
+	}
+}
+
+// sentBytes returns the bytes of sent messages, as counted by the writer.
+// Over a datagram connection, some may have been lost, but the messages
+// are all of the same size.
+func (b *PressuredBenchmark) sentBytes(sent uint64, datagram bool) uint64 {
+	if datagram {
+		return sent * uint64(b.MessageSize)
+	}
+	return b.bytesRead.Load()
+}
+
+// untilEndMarker reports whether the run ends with an end marker, since
+// the reader cannot tell when the writer will stop.
+func (b *PressuredBenchmark) untilEndMarker() bool {
+	return b.TargetDuration > 0 || b.Completion != nil
+}
+
+// writerTermination returns why the writer stops after sent messages
+// totaling bytes since start, or "" to send the next message.
+func (b *IntervalBenchmark) writerTermination(sent, bytes uint64, start time.Time) string {
+	return writerTermination(b.Completion, b.TotalMessages, b.TargetDuration, sent, bytes, start)
+}
+
+// untilEndMarker reports whether the run ends with an end marker, since
+// the reader cannot tell when the writer will stop.
+func (b *IntervalBenchmark) untilEndMarker() bool {
+	return b.TargetDuration > 0 || b.Completion != nil
+}
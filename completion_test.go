@@ -0,0 +1,227 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestParseCompletion(t *testing.T) {
+	for _, spec := range []string{
+		"messages=1000",
+		"bytes=1048576,duration=10s",
+		"messages=1000,bytes=1048576,duration=1m0s,errors=5",
+	} {
+		c, err := ParseCompletion(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if c.String() != spec {
+			t.Errorf("expected %s, got %s", spec, c)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"messages",
+		"messages=-1",
+		"duration=10",
+		"packets=10",
+	} {
+		if _, err := ParseCompletion(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestPressuredBenchmarkCompletion(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		completion Completion
+		reason     string
+	}{
+		{"Messages", Completion{Messages: 500, Duration: time.Minute}, TerminationMessages},
+		{"Bytes", Completion{Bytes: 100000, Duration: time.Minute}, TerminationBytes},
+		{"Duration", Completion{Messages: 1 << 40, Duration: 200 * time.Millisecond}, TerminationDuration},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newBenchmark := func() *PressuredBenchmark {
+				completion := tc.completion
+				return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1, Completion: &completion, Verify: true}
+			}
+			writer, reader := newBenchmark(), newBenchmark()
+			runOverTCP(t, writer, reader)
+
+			writerResult, readerResult := writer.Result(), reader.Result()
+			for side, result := range map[string]map[string]any{"writer": writerResult, "reader": readerResult} {
+				if result["termination_reason"] != tc.reason {
+					t.Errorf("expected the %s to report %s, got %v", side, tc.reason, result["termination_reason"])
+				}
+			}
+			if readerResult["successful_reads"] != writerResult["successful_writes"] {
+				t.Errorf("expected all %v messages to be read, got %v", writerResult["successful_writes"], readerResult["successful_reads"])
+			}
+
+			switch tc.reason {
+			case TerminationMessages:
+				if writes := writerResult["successful_writes"]; writes != uint64(500) {
+					t.Errorf("expected 500 messages, got %v", writes)
+				}
+			case TerminationBytes:
+				if written := writerResult["bytes_written"]; written != uint64(100352) { // 98 messages of 1024 bytes
+					t.Errorf("expected 100352 bytes, got %v", written)
+				}
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		newBenchmark := func() *PressuredBenchmark {
+			return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1, Completion: &Completion{Messages: 1000, Errors: 3}, Verify: true}
+		}
+		writer, reader := newBenchmark(), newBenchmark()
+
+		senderConn, receiverConn := net.Pipe()
+		defer senderConn.Close()
+		defer receiverConn.Close()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writer.Writer(&corruptingConn{Conn: senderConn, n: 100}); err != nil {
+				t.Errorf("writer failed: %v", err)
+			}
+		}()
+		if err := reader.Reader(receiverConn); err != nil {
+			t.Fatalf("reader failed: %v", err)
+		}
+		wg.Wait()
+
+		// the handshake is the first write, so the 99th, 199th and 299th
+		// messages are corrupted
+		result := reader.Result()
+		if result["termination_reason"] != TerminationErrors {
+			t.Errorf("expected the reader to report %s, got %v", TerminationErrors, result["termination_reason"])
+		}
+		if reads := result["successful_reads"]; reads != uint64(299) {
+			t.Errorf("expected the measurement to end after 299 messages, got %v", reads)
+		}
+		if writer.Result()["termination_reason"] != TerminationMessages {
+			t.Errorf("expected the writer to report %s, got %v", TerminationMessages, writer.Result()["termination_reason"])
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, completion := range []Completion{
+			{},
+			{Errors: 1},
+			{Messages: 1, Errors: 1}, // without verification
+		} {
+			writer := &PressuredBenchmark{MessageSize: 1024, Completion: &completion}
+			if err := writer.Writer(nil); err == nil {
+				t.Errorf("%+v: expected an error", completion)
+			}
+		}
+	})
+}
+
+func TestIntervalBenchmarkCompletion(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		completion Completion
+		reason     string
+	}{
+		{"Messages", Completion{Messages: 50, Duration: time.Minute}, TerminationMessages},
+		{"Bytes", Completion{Bytes: 10000, Duration: time.Minute}, TerminationBytes},
+		{"Duration", Completion{Messages: 1 << 40, Duration: 200 * time.Millisecond}, TerminationDuration},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newBenchmark := func() *IntervalBenchmark {
+				completion := tc.completion
+				return &IntervalBenchmark{MessageSize: 1024, TotalMessages: 1, Interval: time.Millisecond, Echo: true, Completion: &completion, Verify: true}
+			}
+			writer, reader := newBenchmark(), newBenchmark()
+			runOverTCP(t, writer, reader)
+
+			writerResult, readerResult := writer.Result(), reader.Result()
+			for side, result := range map[string]map[string]any{"writer": writerResult, "reader": readerResult} {
+				if result["termination_reason"] != tc.reason {
+					t.Errorf("expected the %s to report %s, got %v", side, tc.reason, result["termination_reason"])
+				}
+			}
+			if readerResult["successful_reads"] != writerResult["successful_writes"] {
+				t.Errorf("expected all %v messages to be read, got %v", writerResult["successful_writes"], readerResult["successful_reads"])
+			}
+
+			switch tc.reason {
+			case TerminationMessages:
+				if writes := writerResult["successful_writes"]; writes != uint64(50) {
+					t.Errorf("expected 50 messages, got %v", writes)
+				}
+			case TerminationBytes:
+				if written := writerResult["bytes_written"]; written != uint64(10240) { // 10 messages of 1024 bytes
+					t.Errorf("expected 10240 bytes, got %v", written)
+				}
+			}
+		})
+	}
+
+	// the reader keeps echoing the messages it discards, so the writer
+	// still ends its run on its own condition
+	t.Run("Errors", func(t *testing.T) {
+		newBenchmark := func() *IntervalBenchmark {
+			return &IntervalBenchmark{MessageSize: 1024, TotalMessages: 1, Interval: 100 * time.Microsecond, Echo: true, Completion: &Completion{Messages: 400, Errors: 3}, Verify: true}
+		}
+		writer, reader := newBenchmark(), newBenchmark()
+
+		senderConn, receiverConn := net.Pipe()
+		defer senderConn.Close()
+		defer receiverConn.Close()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writer.Writer(&corruptingConn{Conn: senderConn, n: 100}); err != nil {
+				t.Errorf("writer failed: %v", err)
+			}
+		}()
+		if err := reader.Reader(receiverConn); err != nil {
+			t.Fatalf("reader failed: %v", err)
+		}
+		wg.Wait()
+
+		result := reader.Result()
+		if result["termination_reason"] != TerminationErrors {
+			t.Errorf("expected the reader to report %s, got %v", TerminationErrors, result["termination_reason"])
+		}
+		if reads := result["successful_reads"]; reads != uint64(299) {
+			t.Errorf("expected the measurement to end after 299 messages, got %v", reads)
+		}
+		writerResult := writer.Result()
+		if writerResult["termination_reason"] != TerminationMessages {
+			t.Errorf("expected the writer to report %s, got %v", TerminationMessages, writerResult["termination_reason"])
+		}
+		if echoes := writerResult["successful_writes"]; echoes != uint64(400) {
+			t.Errorf("expected the writer to send all 400 messages, got %v", echoes)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, completion := range []Completion{
+			{},
+			{Errors: 1},
+			{Messages: 1, Errors: 1}, // without verification
+		} {
+			writer := &IntervalBenchmark{MessageSize: 1024, Interval: time.Millisecond, Completion: &completion}
+			if err := writer.Writer(nil); err == nil {
+				t.Errorf("%+v: expected an error", completion)
+			}
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// HandshakeBenchmark measures connection establishment rather than data
+// transfer: one side repeatedly dials new connections with Dial and the
+// other accepts them with Accept, each connection being closed after a
+// single byte was echoed over it. Dial cost, e.g., of TLS, QUIC or
+// obfuscated transports, is often what matters for short-lived
+// connections.
+//
+// The benchmark runs over a session connection between both sides, which
+// carries the spec handshake and the end of the run. Writer and Reader
+// only decide the role in the spec handshake: the side with Dial set dials
+// and the other side must have Accept set.
+type HandshakeBenchmark struct {
+	TotalConnections uint64 `json:"total_connections" yaml:"total_connections"` // TotalConnections defines how many connections to dial one after another
+
+	Dial   func() (net.Conn, error) `json:"-" yaml:"-"` // Dial establishes a new connection to the peer, including any handshake of the transport
+	Accept func() (net.Conn, error) `json:"-" yaml:"-"` // Accept accepts the next connection from the peer. It may be left blocked once the run ends, until the listener is closed
+
+	successfulConns atomic.Uint64 // used for dialer
+	failedConns     atomic.Uint64 // used for dialer
+	acceptedConns   atomic.Uint64 // used for accepter
+	startTime       atomic.Value
+	endTime         atomic.Value
+
+	dialHistogram  *Histogram // used for dialer, time until Dial returns
+	readyHistogram *Histogram // used for dialer, time until the first byte is echoed
+
+	versions        versionRecorder
+	combinedCounter *CombinedCounter
+}
+
+func (b *HandshakeBenchmark) Writer(conn net.Conn, counters ...Counter) error {
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshake(conn, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+	return b.run(conn, counters)
+}
+
+func (b *HandshakeBenchmark) Reader(conn net.Conn, counters ...Counter) error {
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshake(conn, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+	return b.run(conn, counters)
+}
+
+func (b *HandshakeBenchmark) run(conn net.Conn, counters []Counter) error {
+	if b.Dial == nil && b.Accept == nil {
+		return errors.New("handshake benchmark requires either Dial or Accept")
+	}
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.successfulConns.Store(0)
+	b.failedConns.Store(0)
+	b.acceptedConns.Store(0)
+	b.dialHistogram = newDefaultLatencyHistogram()
+	b.readyHistogram = newDefaultLatencyHistogram()
+	b.startTime.Store(time.Now())
+	defer func() {
+		b.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	if b.Dial != nil {
+		return b.dialAll(conn)
+	}
+	return b.acceptAll(conn)
+}
+
+// dialAll dials the connections one after another and tells the peer how
+// many succeeded over the session connection.
+func (b *HandshakeBenchmark) dialAll(conn net.Conn) error {
+	for i := uint64(0); i < b.TotalConnections; i++ {
+		if err := b.dialOne(); err != nil {
+			b.failedConns.Add(1)
+			continue
+		}
+		b.successfulConns.Add(1)
+	}
+
+	var end [8]byte
+	binary.BigEndian.PutUint64(end[:], b.successfulConns.Load())
+	_, err := conn.Write(end[:])
+	return err
+}
+
+// dialOne dials a connection and waits for a byte to be echoed over it,
+// since some transports complete their handshake lazily.
+func (b *HandshakeBenchmark) dialOne() error {
+	start := time.Now()
+	c, err := b.Dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	dialed := time.Now()
+
+	var probe = [1]byte{1}
+	if _, err := c.Write(probe[:]); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c, probe[:]); err != nil {
+		return err
+	}
+
+	b.dialHistogram.Record(dialed.Sub(start).Nanoseconds())
+	b.readyHistogram.Record(time.Since(start).Nanoseconds())
+	return nil
+}
+
+// acceptAll accepts and echoes connections until the peer reports the end
+// of the run over the session connection.
+func (b *HandshakeBenchmark) acceptAll(conn net.Conn) error {
+	go func() {
+		for {
+			c, err := b.Accept()
+			if err != nil {
+				return
+			}
+			// counted before echoing, so that all connections the dialer
+			// succeeded with are counted by the end of the run
+			b.acceptedConns.Add(1)
+			go func() {
+				defer c.Close()
+
+				var probe [1]byte
+				if _, err := io.ReadFull(c, probe[:]); err != nil {
+					return
+				}
+				c.Write(probe[:])
+			}()
+		}
+	}()
+
+	var end [8]byte
+	_, err := io.ReadFull(conn, end[:])
+	return err
+}
+
+// Result returns, on the accepting side, the number of connections
+// accepted. On the dialing side, it returns the number of connections
+// established and failed, the rate as connections_per_s, and the latency
+// distributions of Dial as handshake_latency_<stat>_ns and of the first
+// echoed byte as ready_latency_<stat>_ns.
+func (b *HandshakeBenchmark) Result() map[string]any {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
+		return map[string]any{}
+	}
+
+	startTime := b.startTime.Load().(time.Time)
+	endTime := b.endTime.Load().(time.Time)
+	result := map[string]any{
+		"start_time": startTime.Format(time.RFC3339),
+		"end_time":   endTime.Format(time.RFC3339),
+		"duration":   endTime.Sub(startTime).String(),
+	}
+
+	if b.Dial == nil { // Accepter only
+		result["accepted_connections"] = b.acceptedConns.Load()
+	} else { // Dialer only
+		result["successful_connections"] = b.successfulConns.Load()
+		result["failed_connections"] = b.failedConns.Load()
+		result["connections_per_s"] = float64(b.successfulConns.Load()) / endTime.Sub(startTime).Seconds()
+		if b.dialHistogram.TotalCount() > 0 {
+			result["handshake_latency_ns"] = b.dialHistogram.Mean()
+			for name, value := range b.dialHistogram.Percentiles() {
+				result["handshake_latency_"+name+"_ns"] = value
+			}
+			result["ready_latency_ns"] = b.readyHistogram.Mean()
+			for name, value := range b.readyHistogram.Percentiles() {
+				result["ready_latency_"+name+"_ns"] = value
+			}
+		}
+	}
+
+	b.versions.addResult(result)
+	if b.combinedCounter != nil {
+		result["counters"] = b.combinedCounter.Results()
+	}
+
+	return result
+}
@@ -0,0 +1,39 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestHandshakeBenchmark(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	writer := &HandshakeBenchmark{
+		TotalConnections: 100,
+		Dial: func() (net.Conn, error) {
+			return net.Dial("tcp", listener.Addr().String())
+		},
+	}
+	reader := &HandshakeBenchmark{TotalConnections: 100, Accept: listener.Accept}
+	runOverTCP(t, writer, reader)
+
+	writerResult, readerResult := writer.Result(), reader.Result()
+	if conns := writerResult["successful_connections"]; conns != uint64(100) {
+		t.Errorf("expected 100 connections established, got %v", conns)
+	}
+	if conns := readerResult["accepted_connections"]; conns != uint64(100) {
+		t.Errorf("expected 100 connections accepted, got %v", conns)
+	}
+	if _, ok := writerResult["handshake_latency_p99_ns"]; !ok {
+		t.Error("expected the handshake latency distribution")
+	}
+	if ready, dial := writerResult["ready_latency_ns"].(float64), writerResult["handshake_latency_ns"].(float64); ready < dial {
+		t.Errorf("expected the first byte after the handshake, got %gns before %gns", ready, dial)
+	}
+}
@@ -0,0 +1,450 @@
+package benchmarkconn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Control message types.
+const (
+	ControlSpec   = "spec"   // ControlSpec carries the benchmark spec during the handshake
+	ControlAbort  = "abort"  // ControlAbort tells the peer to stop the run, with the reason
+	ControlResult = "result" // ControlResult carries the result of one side after the run
+
+	ControlHeartbeat = "heartbeat" // ControlHeartbeat keeps the control connection from going idle, it is counted and dropped by the peer
+	ControlProgress  = "progress"  // ControlProgress carries a progress snapshot of one side during the run, see ControlChannel.OnPeerProgress
+)
+
+// ControlMessage is a message exchanged over a ControlChannel.
+type ControlMessage struct {
+	Type      string            `json:"type"`
+	Benchmark string            `json:"benchmark,omitempty"`
+	Role      string            `json:"role,omitempty"`
+	Spec      json.RawMessage   `json:"spec,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Result    map[string]any    `json:"result,omitempty"`
+	Progress  *ProgressSnapshot `json:"progress,omitempty"`
+	Version   string            `json:"version,omitempty"`
+}
+
+// abortGracePeriod is how long a run whose data connection was closed
+// waits for an abort from the peer before it is considered complete.
+const abortGracePeriod = 100 * time.Millisecond
+
+// ErrAborted is returned by benchmarks aborted over the control channel,
+// either locally or by the peer.
+var ErrAborted = errors.New("aborted")
+
+// ControlChannel is an optional out-of-band channel between the peers of
+// a benchmark, e.g., a second connection, carrying the handshake, abort
+// commands, progress updates and the final result exchange so that the
+// data connection is kept byte-clean.
+//
+// Messages are encoded as newline-delimited JSON. Incoming messages are
+// read in the background, so aborts from the peer are noticed at any
+// time.
+type ControlChannel struct {
+	conn net.Conn
+
+	mutex sync.Mutex // protects enc
+	enc   *json.Encoder
+
+	receiveOnce sync.Once
+	inbox       chan *ControlMessage
+	receiveErr  error         // set before closed is closed
+	closed      chan struct{} // closed when no more messages can be received
+
+	peekMutex sync.Mutex      // protects peeked
+	peeked    *ControlMessage // received by PeekSpec, delivered again by the next receive
+
+	heartbeatsSent     atomic.Uint64
+	heartbeatsReceived atomic.Uint64
+
+	progressMutex  sync.Mutex // protects peerProgress and onPeerProgress
+	peerProgress   *ProgressSnapshot
+	onPeerProgress func(ProgressSnapshot)
+
+	abortOnce     sync.Once
+	abortReason   string // set before aborted is closed
+	abortedByPeer bool   // set before aborted is closed
+	aborted       chan struct{}
+}
+
+// NewControlChannel creates a control channel over conn.
+func NewControlChannel(conn net.Conn) *ControlChannel {
+	return &ControlChannel{
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		inbox:   make(chan *ControlMessage, 16),
+		closed:  make(chan struct{}),
+		aborted: make(chan struct{}),
+	}
+}
+
+// Send sends a message to the peer. It is safe for concurrent use.
+func (c *ControlChannel) Send(msg *ControlMessage) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.enc.Encode(msg)
+}
+
+// Receive receives the next message from the peer, except aborts which
+// are reported by Aborted instead.
+func (c *ControlChannel) Receive() (*ControlMessage, error) {
+	return c.receive(nil)
+}
+
+// receive receives the next message, giving up once interrupt is closed.
+func (c *ControlChannel) receive(interrupt <-chan struct{}) (*ControlMessage, error) {
+	c.peekMutex.Lock()
+	if msg := c.peeked; msg != nil {
+		c.peeked = nil
+		c.peekMutex.Unlock()
+		return msg, nil
+	}
+	c.peekMutex.Unlock()
+
+	c.startReceiving()
+
+	select {
+	case msg := <-c.inbox:
+		return msg, nil
+	case <-c.closed:
+		// deliver messages received before the channel was closed first
+		select {
+		case msg := <-c.inbox:
+			return msg, nil
+		default:
+			return nil, c.receiveErr
+		}
+	case <-interrupt:
+		return nil, errors.New("interrupted")
+	}
+}
+
+// PeekSpec receives the spec the peer sends at the start of its handshake
+// without consuming it, so that the handshake of the benchmark receives it
+// all the same. It allows the accepting side to adopt the spec of the peer
+// with AdoptSpec before creating its benchmark, rather than requiring the
+// very same spec on both sides.
+func (c *ControlChannel) PeekSpec() (*ControlMessage, error) {
+	c.peekMutex.Lock()
+	if msg := c.peeked; msg != nil {
+		c.peekMutex.Unlock()
+		return msg, nil
+	}
+	c.peekMutex.Unlock()
+
+	msg, err := c.receiveType(ControlSpec, true)
+	if err != nil {
+		return nil, err
+	}
+	c.peekMutex.Lock()
+	c.peeked = msg
+	c.peekMutex.Unlock()
+	return msg, nil
+}
+
+// startReceiving starts reading incoming messages in the background, once.
+func (c *ControlChannel) startReceiving() {
+	c.receiveOnce.Do(func() {
+		go c.receiveLoop()
+	})
+}
+
+func (c *ControlChannel) receiveLoop() {
+	dec := json.NewDecoder(c.conn)
+	for {
+		var msg ControlMessage
+		if err := dec.Decode(&msg); err != nil {
+			c.receiveErr = err
+			close(c.closed)
+			return
+		}
+
+		if msg.Type == ControlAbort {
+			c.abort(msg.Reason, true)
+			continue
+		}
+		if msg.Type == ControlHeartbeat {
+			c.heartbeatsReceived.Add(1)
+			continue
+		}
+		if msg.Type == ControlProgress {
+			if msg.Progress != nil {
+				c.receivedProgress(*msg.Progress)
+			}
+			continue
+		}
+		c.inbox <- &msg
+	}
+}
+
+// StartHeartbeat sends a heartbeat to the peer every interval until the
+// returned stop is called or sending fails, so that NATs and middleboxes
+// do not drop the control connection while it is idle, e.g., during the
+// run or while waiting for the result of the peer.
+func (c *ControlChannel) StartHeartbeat(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Send(&ControlMessage{Type: ControlHeartbeat}); err != nil {
+					return
+				}
+				c.heartbeatsSent.Add(1)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Heartbeats returns the number of heartbeats sent to and received from
+// the peer so far, which are not part of any benchmark traffic.
+func (c *ControlChannel) Heartbeats() (sent, received uint64) {
+	return c.heartbeatsSent.Load(), c.heartbeatsReceived.Load()
+}
+
+// OnPeerProgress sets f to be called with each progress snapshot the peer
+// sends during the run, every ProgressInterval of its benchmark. It is
+// called from the loop receiving the control messages, so it must return
+// quickly.
+func (c *ControlChannel) OnPeerProgress(f func(ProgressSnapshot)) {
+	c.progressMutex.Lock()
+	defer c.progressMutex.Unlock()
+	c.onPeerProgress = f
+}
+
+// PeerProgress returns the last progress snapshot sent by the peer, if
+// any.
+func (c *ControlChannel) PeerProgress() (ProgressSnapshot, bool) {
+	c.progressMutex.Lock()
+	defer c.progressMutex.Unlock()
+	if c.peerProgress == nil {
+		return ProgressSnapshot{}, false
+	}
+	return *c.peerProgress, true
+}
+
+func (c *ControlChannel) receivedProgress(snapshot ProgressSnapshot) {
+	c.progressMutex.Lock()
+	c.peerProgress = &snapshot
+	onPeerProgress := c.onPeerProgress
+	c.progressMutex.Unlock()
+	if onPeerProgress != nil {
+		onPeerProgress(snapshot)
+	}
+}
+
+// reportProgress returns the progress callback of a benchmark run with c,
+// which sends each snapshot to the peer before passing it to onProgress,
+// if set. It returns onProgress if c is nil.
+func (c *ControlChannel) reportProgress(onProgress func(ProgressSnapshot)) func(ProgressSnapshot) {
+	if c == nil {
+		return onProgress
+	}
+	return func(snapshot ProgressSnapshot) {
+		c.Send(&ControlMessage{Type: ControlProgress, Progress: &snapshot}) // best effort, the peer may be gone
+		if onProgress != nil {
+			onProgress(snapshot)
+		}
+	}
+}
+
+// Abort aborts the run on both sides with the given reason.
+func (c *ControlChannel) Abort(reason string) error {
+	c.abort(reason, false)
+	return c.Send(&ControlMessage{Type: ControlAbort, Reason: reason})
+}
+
+func (c *ControlChannel) abort(reason string, byPeer bool) {
+	c.abortOnce.Do(func() {
+		c.abortReason = reason
+		c.abortedByPeer = byPeer
+		close(c.aborted)
+	})
+}
+
+// Aborted returns a channel closed when the run is aborted, either
+// locally or by the peer.
+func (c *ControlChannel) Aborted() <-chan struct{} {
+	// make sure aborts from the peer are noticed without any Receive
+	c.startReceiving()
+	return c.aborted
+}
+
+// AbortErr returns an error wrapping ErrAborted with the reason of the
+// abort, or nil if the run has not been aborted or c is nil.
+func (c *ControlChannel) AbortErr() error {
+	if c == nil {
+		return nil
+	}
+	select {
+	case <-c.aborted:
+		if c.abortedByPeer {
+			return fmt.Errorf("%w by peer: %s", ErrAborted, c.abortReason)
+		}
+		return fmt.Errorf("%w: %s", ErrAborted, c.abortReason)
+	default:
+		return nil
+	}
+}
+
+// addAbortResult adds the status of an aborted run to result.
+func (c *ControlChannel) addAbortResult(result map[string]any) {
+	if c == nil || c.AbortErr() == nil {
+		return
+	}
+	if c.abortedByPeer {
+		result["status"] = "aborted by peer"
+	} else {
+		result["status"] = "aborted"
+	}
+	result["abort_reason"] = c.abortReason
+}
+
+// watchAbort interrupts the run on conn once aborted, by closing conn,
+// until stop is called.
+func (c *ControlChannel) watchAbort(conn net.Conn) (stop func()) {
+	if c == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-c.Aborted():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// closedErr returns the error of a run whose data connection was closed
+// by the peer: nil, unless the peer aborted the run, as its abort may
+// arrive on the control channel shortly after the data connection closed.
+func (c *ControlChannel) closedErr() error {
+	if c == nil {
+		return nil
+	}
+	select {
+	case <-c.Aborted():
+	case <-c.closed:
+	case <-time.After(abortGracePeriod):
+	}
+	return c.AbortErr()
+}
+
+// receiveType receives the next message and expects it to be of the given
+// type. If abortable, an abort is returned as an error.
+func (c *ControlChannel) receiveType(msgType string, abortable bool) (*ControlMessage, error) {
+	var interrupt <-chan struct{}
+	if abortable {
+		interrupt = c.Aborted()
+	}
+
+	msg, err := c.receive(interrupt)
+	if abortable && c.AbortErr() != nil {
+		return nil, c.AbortErr()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type != msgType {
+		return nil, fmt.Errorf("unexpected control message %q, expecting %q", msg.Type, msgType)
+	}
+	return msg, nil
+}
+
+// Close closes the underlying connection.
+func (c *ControlChannel) Close() error {
+	return c.conn.Close()
+}
+
+// writerHandshake sends the JSON-encoded spec to the peer and expects the
+// very same spec from a reader. It returns the handshake message of the
+// peer.
+func (c *ControlChannel) writerHandshake(spec any) (*handshakeMessage, error) {
+	return c.handshake(roleWriter, spec)
+}
+
+// readerHandshake sends the JSON-encoded spec to the peer and expects the
+// very same spec from a writer. It returns the handshake message of the
+// peer.
+func (c *ControlChannel) readerHandshake(spec any) (*handshakeMessage, error) {
+	return c.handshake(roleReader, spec)
+}
+
+// handshake sends the role, the spec and the version to the peer, then
+// checks those of the peer. On mismatch, the peer is told to abort. It
+// returns the handshake message of the peer.
+func (c *ControlChannel) handshake(role string, spec any) (*handshakeMessage, error) {
+	local, err := newHandshakeMessage(role, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// receive before sending, since synchronous connections such as
+	// net.Pipe block the send until the peer reads
+	c.startReceiving()
+	if err := c.Send(&ControlMessage{Type: ControlSpec, Benchmark: local.Benchmark, Role: role, Spec: local.Spec, Version: local.Version}); err != nil {
+		return nil, err
+	}
+
+	msg, err := c.receiveType(ControlSpec, true)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := &handshakeMessage{Benchmark: msg.Benchmark, Role: msg.Role, Spec: msg.Spec, Version: msg.Version}
+	if err := local.check(peer); err != nil {
+		if role == roleWriter && peer.Role == roleReader {
+			// the reader tells the writer to abort on mismatched specs
+			c.receive(c.Aborted())
+			if abortErr := c.AbortErr(); abortErr != nil {
+				return nil, abortErr
+			}
+			return nil, err
+		}
+		c.Abort(err.Error())
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// ExchangeResults sends the local result to the peer and returns the
+// result of the peer, so either side is able to report both.
+func (c *ControlChannel) ExchangeResults(result map[string]any) (map[string]any, error) {
+	// send concurrently, so neither side blocks on a full send buffer
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- c.Send(&ControlMessage{Type: ControlResult, Result: result})
+	}()
+
+	// results are exchanged even after an abort
+	msg, err := c.receiveType(ControlResult, false)
+	if err := <-sendErr; err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Result, nil
+}
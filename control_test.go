@@ -0,0 +1,351 @@
+package benchmarkconn_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// countingConn counts the bytes written to it.
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func TestControlChannel(t *testing.T) {
+	run := func(writer, reader *PressuredBenchmark) (writerErr, readerErr error) {
+		writerData, readerData := net.Pipe()
+		writerControl, readerControl := net.Pipe()
+		t.Cleanup(func() {
+			writerData.Close()
+			readerData.Close()
+			writerControl.Close()
+			readerControl.Close()
+		})
+
+		writer.Control = NewControlChannel(writerControl)
+		reader.Control = NewControlChannel(readerControl)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readerErr = reader.Reader(readerData)
+			if readerErr != nil {
+				readerData.Close() // unblock the writer
+			}
+		}()
+		writerErr = writer.Writer(writerData)
+		wg.Wait()
+		return writerErr, readerErr
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		if writerErr, readerErr := run(writer, reader); writerErr != nil || readerErr != nil {
+			t.Fatalf("writer: %v, reader: %v", writerErr, readerErr)
+		}
+		if reads := reader.Result()["successful_reads"]; reads != uint64(100) {
+			t.Errorf("expected 100 successful reads, got %v", reads)
+		}
+
+		// exchange the results over the control channel
+		var peerResult map[string]any
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := reader.Control.ExchangeResults(reader.Result()); err != nil {
+				t.Errorf("reader: %v", err)
+			}
+		}()
+		peerResult, err := writer.Control.ExchangeResults(writer.Result())
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reads := peerResult["successful_reads"]; reads != float64(100) { // JSON numbers decode as float64
+			t.Errorf("expected 100 successful reads in the peer result, got %v", reads)
+		}
+	})
+
+	t.Run("DataOnly", func(t *testing.T) {
+		for _, control := range []bool{false, true} {
+			writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+			reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+
+			writerData, readerData := net.Pipe()
+			writerControl, readerControl := net.Pipe()
+			t.Cleanup(func() {
+				writerData.Close()
+				readerData.Close()
+				writerControl.Close()
+				readerControl.Close()
+			})
+			if control {
+				writer.Control = NewControlChannel(writerControl)
+				reader.Control = NewControlChannel(readerControl)
+			}
+
+			// the in-band handshake goes both ways, so count both sides
+			writerConn, readerConn := &countingConn{Conn: writerData}, &countingConn{Conn: readerData}
+			errs := make(chan error, 1)
+			go func() { errs <- reader.Reader(readerConn) }()
+			if err := writer.Writer(writerConn); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+
+			messages := int64(100 * 1024)
+			written := writerConn.written.Load() + readerConn.written.Load()
+			if control && written != messages {
+				t.Errorf("expected the data connection to carry only the %d bytes of the messages with a control channel, got %d", messages, written)
+			}
+			if !control && written <= messages {
+				t.Errorf("expected the in-band handshake on the data connection without a control channel, got %d bytes", written)
+			}
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		reader := &PressuredBenchmark{MessageSize: 512, TotalMessages: 100}
+		writerErr, readerErr := run(writer, reader)
+		if readerErr == nil {
+			t.Errorf("expected reader to fail on mismatched specs")
+		}
+		if writerErr == nil || !strings.Contains(writerErr.Error(), "aborted by peer") {
+			t.Errorf("expected writer to be aborted by peer, got %v", writerErr)
+		}
+	})
+
+	t.Run("SameRole", func(t *testing.T) {
+		first := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		second := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+
+		firstData, secondData := net.Pipe()
+		firstControl, secondControl := net.Pipe()
+		t.Cleanup(func() {
+			firstData.Close()
+			secondData.Close()
+			firstControl.Close()
+			secondControl.Close()
+		})
+		first.Control = NewControlChannel(firstControl)
+		second.Control = NewControlChannel(secondControl)
+
+		var secondErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			secondErr = second.Writer(secondData)
+		}()
+		firstErr := first.Writer(firstData)
+		wg.Wait()
+
+		for _, err := range []error{firstErr, secondErr} {
+			if err == nil || !strings.Contains(err.Error(), "peer is also a writer") {
+				t.Errorf("expected a role mismatch, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		writer := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 10000, Interval: time.Millisecond}
+		reader := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 10000, Interval: time.Millisecond}
+
+		writerData, readerData := net.Pipe()
+		writerControl, readerControl := net.Pipe()
+		t.Cleanup(func() {
+			writerData.Close()
+			readerData.Close()
+			writerControl.Close()
+			readerControl.Close()
+		})
+		writer.Control = NewControlChannel(writerControl)
+		reader.Control = NewControlChannel(readerControl)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			writer.Control.Abort("stopped by test")
+		}()
+
+		var readerErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readerErr = reader.Reader(readerData)
+		}()
+		writerErr := writer.Writer(writerData)
+		wg.Wait()
+
+		if !errors.Is(writerErr, ErrAborted) || !errors.Is(readerErr, ErrAborted) {
+			t.Fatalf("expected both sides to be aborted, got writer: %v, reader: %v", writerErr, readerErr)
+		}
+		result := reader.Result()
+		if result["status"] != "aborted by peer" || result["abort_reason"] != "stopped by test" {
+			t.Errorf("expected aborted by peer status, got %v: %v", result["status"], result["abort_reason"])
+		}
+		if reads := result["successful_reads"].(uint64); reads == 0 || reads >= 10000 {
+			t.Errorf("expected the run to stop early, got %d successful reads", reads)
+		}
+	})
+
+	// the peer may close the data connection before its abort arrives on
+	// the control channel
+	closeEarly := func(t *testing.T, abort bool) (readerErr error, reader *PressuredBenchmark, closedAfter time.Duration) {
+		writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1 << 30}
+		reader = &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1 << 30}
+
+		writerData, readerData := net.Pipe()
+		writerControl, readerControl := net.Pipe()
+		t.Cleanup(func() {
+			writerData.Close()
+			readerData.Close()
+			writerControl.Close()
+			readerControl.Close()
+		})
+		writer.Control = NewControlChannel(writerControl)
+		reader.Control = NewControlChannel(readerControl)
+
+		start := time.Now()
+		go writer.Writer(writerData)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			closedAfter = time.Since(start)
+			writerData.Close()
+			if abort {
+				time.Sleep(20 * time.Millisecond)
+				writer.Control.Abort("closed by test")
+			}
+		}()
+		readerErr = reader.Reader(readerData)
+		return readerErr, reader, closedAfter
+	}
+
+	t.Run("AbortAfterClose", func(t *testing.T) {
+		readerErr, reader, _ := closeEarly(t, true)
+		if !errors.Is(readerErr, ErrAborted) {
+			t.Fatalf("expected the abort following the close to be reported, got %v", readerErr)
+		}
+		if status := reader.Result()["status"]; status != "aborted by peer" {
+			t.Errorf("expected aborted by peer status, got %v", status)
+		}
+	})
+
+	t.Run("ClosedEarly", func(t *testing.T) {
+		readerErr, reader, closedAfter := closeEarly(t, false)
+		if readerErr != nil {
+			t.Fatalf("expected a run closed without abort to succeed, got %v", readerErr)
+		}
+		// the run ends when the connection closed, not once no abort came
+		duration, err := time.ParseDuration(reader.Result()["duration"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if duration > closedAfter+50*time.Millisecond {
+			t.Errorf("expected the run to end once the connection closed after %s, took %s", closedAfter, duration)
+		}
+	})
+
+	t.Run("Progress", func(t *testing.T) {
+		writer := &IntervalBenchmark{MessageSize: 64, TotalMessages: 100, Interval: time.Millisecond, ProgressInterval: 10 * time.Millisecond}
+		reader := &IntervalBenchmark{MessageSize: 64, TotalMessages: 100, Interval: time.Millisecond, ProgressInterval: 10 * time.Millisecond}
+
+		writerData, readerData := net.Pipe()
+		writerControl, readerControl := net.Pipe()
+		t.Cleanup(func() {
+			writerData.Close()
+			readerData.Close()
+			writerControl.Close()
+			readerControl.Close()
+		})
+		writer.Control = NewControlChannel(writerControl)
+		reader.Control = NewControlChannel(readerControl)
+
+		var mutex sync.Mutex
+		var snapshots []ProgressSnapshot
+		reader.Control.OnPeerProgress(func(snapshot ProgressSnapshot) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			snapshots = append(snapshots, snapshot)
+		})
+
+		var readerErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readerErr = reader.Reader(readerData)
+		}()
+		writerErr := writer.Writer(writerData)
+		wg.Wait()
+		if writerErr != nil || readerErr != nil {
+			t.Fatalf("writer: %v, reader: %v", writerErr, readerErr)
+		}
+
+		// the final snapshot of the writer is sent once it stopped, so
+		// wait for it along with the results
+		go writer.Control.ExchangeResults(writer.Result())
+		if _, err := reader.Control.ExchangeResults(reader.Result()); err != nil {
+			t.Fatal(err)
+		}
+		last, ok := reader.Control.PeerProgress()
+		if !ok || !last.Done || last.MessagesWritten != 100 || last.TotalMessages != 100 {
+			t.Errorf("expected the final progress of the writer, got %+v, %v", last, ok)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if len(snapshots) < 2 {
+			t.Errorf("expected progress of the writer during the run, got %d snapshots", len(snapshots))
+		}
+	})
+
+	t.Run("Heartbeat", func(t *testing.T) {
+		writerControl, readerControl := net.Pipe()
+		defer writerControl.Close()
+		defer readerControl.Close()
+
+		writer, reader := NewControlChannel(writerControl), NewControlChannel(readerControl)
+		stopWriter, stopReader := writer.StartHeartbeat(5*time.Millisecond), reader.StartHeartbeat(5*time.Millisecond)
+
+		// heartbeats are dropped while waiting for the result of the peer
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			writer.ExchangeResults(map[string]any{"side": "writer"})
+		}()
+		peerResult, err := reader.ExchangeResults(map[string]any{"side": "reader"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peerResult["side"] != "writer" {
+			t.Errorf("expected the result of the writer, got %v", peerResult)
+		}
+		stopWriter()
+		stopReader()
+
+		if sent, _ := writer.Heartbeats(); sent < 3 {
+			t.Errorf("expected heartbeats to be sent while idle, got %d", sent)
+		}
+		if _, received := reader.Heartbeats(); received < 3 {
+			t.Errorf("expected heartbeats to be received while waiting, got %d", received)
+		}
+	})
+}
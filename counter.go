@@ -1,6 +1,8 @@
 package benchmarkconn
 
 import (
+	"errors"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -27,13 +29,90 @@ func (r *counterReport) Add(time time.Time, value any) {
 func (r *counterReport) Result() (result map[time.Time]any) {
 	result = make(map[time.Time]any)
 	r.internalMap.Range(func(key, value interface{}) bool {
-		result[key.(time.Time)] = value.(int64)
+		result[key.(time.Time)] = value
 		return true
 	})
 	return
 }
 
+// CounterRetention bounds the samples a CounterReport keeps, since one
+// taking a sample every second for the whole of a soak test grows without
+// bound. Zero values keep every sample.
+type CounterRetention struct {
+	MaxSamples int           `json:"max_samples,omitempty" yaml:"max_samples"` // MaxSamples defines how many of the latest samples to keep, dropping the oldest ones
+	Bucket     time.Duration `json:"bucket,omitempty" yaml:"bucket"`           // Bucket downsamples to the latest sample within each bucket of this length, e.g., a minute, keyed by the time it was taken
+}
+
+// boundedCounterReport is a CounterReport keeping the samples within a
+// CounterRetention in a ring buffer, oldest first from head once full.
+type boundedCounterReport struct {
+	mutex     sync.Mutex
+	retention CounterRetention
+	samples   []counterSample
+	head      int
+}
+
+type counterSample struct {
+	time  time.Time
+	value any
+}
+
+// NewBoundedCounterReport creates a CounterReport keeping the samples
+// within retention.
+func NewBoundedCounterReport(retention CounterRetention) CounterReport {
+	return &boundedCounterReport{retention: retention}
+}
+
+func (r *boundedCounterReport) Add(time time.Time, value any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sample := counterSample{time: time, value: value}
+	if bucket := r.retention.Bucket; bucket > 0 && len(r.samples) > 0 {
+		last := &r.samples[(r.head+len(r.samples)-1)%len(r.samples)]
+		if time.Truncate(bucket).Equal(last.time.Truncate(bucket)) {
+			*last = sample
+			return
+		}
+	}
+	if r.retention.MaxSamples > 0 && len(r.samples) >= r.retention.MaxSamples {
+		r.samples[r.head] = sample
+		r.head = (r.head + 1) % len(r.samples)
+		return
+	}
+	r.samples = append(r.samples, sample)
+}
+
+func (r *boundedCounterReport) Result() map[time.Time]any {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := make(map[time.Time]any, len(r.samples))
+	for _, sample := range r.samples {
+		result[sample.time] = sample.value
+	}
+	return result
+}
+
+// SetCounterRetention bounds the samples kept by counter, which must not
+// be started yet, e.g., to keep a day of TCP_INFO downsampled to a sample
+// a minute. The counters of this package support it, as do those built
+// on CounterBase.
+func SetCounterRetention(counter Counter, retention CounterRetention) error {
+	if retention.MaxSamples < 0 || retention.Bucket < 0 {
+		return errors.New("counter retention must not be negative")
+	}
+	retainer, ok := counter.(interface{ SetRetention(CounterRetention) })
+	if !ok {
+		return errors.New("counter does not support retention")
+	}
+	retainer.SetRetention(retention)
+	return nil
+}
+
 type Counter interface {
+	Name() string // Name identifies the counter in the results of a CombinedCounter, e.g., tcpinfo
+
 	CountNow() // CountNow forcibly make the counter take a measurement immediately and save it to the result
 
 	Start()
@@ -54,10 +133,6 @@ func CombineCounters(interval time.Duration, counters ...Counter) *CombinedCount
 		return nil
 	}
 
-	report := make([]CounterReport, len(counters))
-	for i := range report {
-		report[i] = NewCounterReport()
-	}
 	return &CombinedCounter{
 		counters: counters,
 		interval: interval,
@@ -90,14 +165,43 @@ func (c *CombinedCounter) Stop() {
 	close(c.closed)
 }
 
-func (c *CombinedCounter) Results() []map[time.Time]any {
-	results := make([]map[time.Time]any, len(c.counters))
-	for i, counter := range c.counters {
-		results[i] = counter.Result()
+// tickInterval returns how often the counters take a measurement, 0 if
+// there is no counter.
+func (c *CombinedCounter) tickInterval() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.interval
+}
+
+// Results returns the results of the counters keyed by their names, see
+// CounterNames.
+func (c *CombinedCounter) Results() map[string]map[time.Time]any {
+	results := make(map[string]map[time.Time]any, len(c.counters))
+	for i, name := range CounterNames(c.counters...) {
+		results[name] = c.counters[i].Result()
 	}
 	return results
 }
 
+// CounterNames returns the names of counters in order, as keyed in the
+// results of a CombinedCounter: repeated names are numbered from their
+// second occurrence, e.g., tcpinfo, tcpinfo#2 and tcpinfo#3 for the
+// TCP_INFO counters of parallel connections.
+func CounterNames(counters ...Counter) []string {
+	names := make([]string, len(counters))
+	seen := make(map[string]int, len(counters))
+	for i, counter := range counters {
+		name := counter.Name()
+		seen[name]++
+		if n := seen[name]; n > 1 {
+			name += "#" + strconv.Itoa(n)
+		}
+		names[i] = name
+	}
+	return names
+}
+
 // CounterBase is an incomplete implementation of Counter.
 type CounterBase struct {
 	ticker   *time.Ticker
@@ -123,6 +227,13 @@ func (c *CounterBase) Start() {
 	c.ticker = time.NewTicker(c.interval)
 }
 
+// SetRetention bounds the samples kept in the report of the counter. It
+// must be called before Start, since the samples taken so far are
+// dropped.
+func (c *CounterBase) SetRetention(retention CounterRetention) {
+	c.report = NewBoundedCounterReport(retention)
+}
+
 // Stop stops the ticker and closes the closed channel.
 //
 // It is recommended for Counter implementations to directly
@@ -150,6 +261,10 @@ func NewCpuUsageCounter(interval time.Duration) Counter {
 	}
 }
 
+func (c *cpuUsageCounter) Name() string {
+	return "cpu"
+}
+
 func (c *cpuUsageCounter) CountNow() {
 	// c.report.Add(time.Now(), 0) // TODO: count CPU usage
 }
@@ -178,6 +293,10 @@ func NewMemoryUsageCounter(interval time.Duration) Counter {
 	}
 }
 
+func (c *memoryUsageCounter) Name() string {
+	return "memory"
+}
+
 func (c *memoryUsageCounter) CountNow() {
 	// c.report.Add(time.Now(), 0) // TODO: count memory usage
 }
@@ -0,0 +1,242 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// freqDropThreshold is how much the fastest core must slow down over a
+// run to be considered throttled, on platforms without throttle counters.
+const freqDropThreshold = 0.2
+
+// CPUFreqCounter is a Counter which samples the CPU frequency and, where
+// available, the thermal throttle counters and temperatures from sysfs
+// each tick, so that results skewed by thermal throttling, e.g., of a
+// laptop during a long run, can be told apart.
+type CPUFreqCounter struct {
+	*CounterBase
+
+	sysfs         fs.FS
+	freqFiles     []string // scaling_cur_freq of each CPU
+	throttleFiles []string // core and package throttle counts, if exposed
+	tempFiles     []string // temperature of each thermal zone, if exposed
+
+	initialThrottleCount uint64 // throttle events are counted since the counter was created
+
+	mutex             sync.Mutex // protects the fields below
+	lastThrottleCount uint64
+	fastestHigh       uint64  // the highest frequency of the fastest core in kHz
+	fastestLow        uint64  // the lowest frequency of the fastest core in kHz
+	tempMax           float64 // the highest temperature in degrees Celsius
+	samples           uint64
+}
+
+// NewCPUFreqCounter creates a CPUFreqCounter reading from sysfs, the root
+// of the sysfs tree, or /sys if nil. Each sample records the mean, min
+// and max current frequency across CPUs as freq_mean_mhz, freq_min_mhz
+// and freq_max_mhz, the throttle events since the previous sample as
+// throttle_events if the kernel exposes them, e.g., on Intel CPUs, and the
+// highest thermal zone temperature as temp_max_c if any.
+//
+// It fails if sysfs exposes no CPU frequency, e.g., on platforms other
+// than Linux or in virtual machines.
+func NewCPUFreqCounter(interval time.Duration, sysfs fs.FS) (*CPUFreqCounter, error) {
+	if sysfs == nil {
+		sysfs = os.DirFS("/sys")
+	}
+
+	freqFiles, _ := fs.Glob(sysfs, "devices/system/cpu/cpu[0-9]*/cpufreq/scaling_cur_freq")
+	if len(freqFiles) == 0 {
+		return nil, errors.New("CPU frequency counter requires cpufreq in sysfs")
+	}
+	coreThrottleFiles, _ := fs.Glob(sysfs, "devices/system/cpu/cpu[0-9]*/thermal_throttle/core_throttle_count")
+	packageThrottleFiles, _ := fs.Glob(sysfs, "devices/system/cpu/cpu[0-9]*/thermal_throttle/package_throttle_count")
+	tempFiles, _ := fs.Glob(sysfs, "class/thermal/thermal_zone[0-9]*/temp")
+
+	c := &CPUFreqCounter{
+		CounterBase:   NewCounterBase(interval),
+		sysfs:         sysfs,
+		freqFiles:     freqFiles,
+		throttleFiles: append(coreThrottleFiles, packageThrottleFiles...),
+		tempFiles:     tempFiles,
+	}
+	c.initialThrottleCount = c.throttleCount()
+	c.lastThrottleCount = c.initialThrottleCount
+	return c, nil
+}
+
+func (c *CPUFreqCounter) Name() string {
+	return "cpufreq"
+}
+
+func (c *CPUFreqCounter) CountNow() {
+	c.report.Add(time.Now(), c.sample())
+}
+
+func (c *CPUFreqCounter) Start() {
+	c.CounterBase.Start()
+	go func() {
+		for {
+			select {
+			case <-c.ticker.C:
+				c.CountNow()
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+}
+
+// sample reads the frequencies, throttle counts and temperatures, and
+// updates the summary of the run.
+func (c *CPUFreqCounter) sample() map[string]any {
+	var total, count uint64
+	var lowest, fastest uint64 = math.MaxUint64, 0
+	for _, name := range c.freqFiles {
+		freq, ok := c.readUint(name)
+		if !ok {
+			continue // the CPU went offline
+		}
+		total += freq
+		count++
+		lowest = min(lowest, freq)
+		fastest = max(fastest, freq)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	values := make(map[string]any)
+	if count > 0 {
+		values["freq_mean_mhz"] = float64(total) / float64(count) / 1e3
+		values["freq_min_mhz"] = lowest / 1e3
+		values["freq_max_mhz"] = fastest / 1e3
+
+		if c.samples == 0 || fastest > c.fastestHigh {
+			c.fastestHigh = fastest
+		}
+		if c.samples == 0 || fastest < c.fastestLow {
+			c.fastestLow = fastest
+		}
+		c.samples++
+	}
+
+	if len(c.throttleFiles) > 0 {
+		throttleCount := c.throttleCount()
+		events := throttleCount - min(throttleCount, c.lastThrottleCount) // counters reset with CPU hotplug
+		c.lastThrottleCount = throttleCount
+		values["throttle_events"] = events
+	}
+
+	if temp, ok := c.maxTemp(); ok {
+		values["temp_max_c"] = temp
+		c.tempMax = max(c.tempMax, temp)
+	}
+	return values
+}
+
+// throttleEvents returns the throttle events since the counter was
+// created, which need not have been sampled.
+func (c *CPUFreqCounter) throttleEvents() uint64 {
+	throttleCount := c.throttleCount()
+	return throttleCount - min(throttleCount, c.initialThrottleCount)
+}
+
+// throttleCount returns the sum of the throttle counts of all CPUs.
+func (c *CPUFreqCounter) throttleCount() uint64 {
+	var sum uint64
+	for _, name := range c.throttleFiles {
+		if count, ok := c.readUint(name); ok {
+			sum += count
+		}
+	}
+	return sum
+}
+
+// maxTemp returns the highest temperature across thermal zones in degrees
+// Celsius, false if none is exposed.
+func (c *CPUFreqCounter) maxTemp() (float64, bool) {
+	var highest int64
+	found := false
+	for _, name := range c.tempFiles {
+		data, err := fs.ReadFile(c.sysfs, name)
+		if err != nil {
+			continue
+		}
+		temp, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			continue
+		}
+		if !found || temp > highest {
+			highest, found = temp, true
+		}
+	}
+	return float64(highest) / 1e3, found // in millidegrees
+}
+
+func (c *CPUFreqCounter) readUint(name string) (uint64, bool) {
+	data, err := fs.ReadFile(c.sysfs, name)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return value, err == nil
+}
+
+// Throttled reports whether the CPU was throttled while sampled: if the
+// kernel counted throttle events or, where it exposes no throttle
+// counters, if the fastest core slowed down by more than 20%.
+func (c *CPUFreqCounter) Throttled() bool {
+	if len(c.throttleFiles) > 0 {
+		return c.throttleEvents() > 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.freqDrop() > freqDropThreshold
+}
+
+// freqDrop returns how much the fastest core slowed down over the run,
+// relative to its highest frequency.
+func (c *CPUFreqCounter) freqDrop() float64 {
+	if c.fastestHigh == 0 {
+		return 0
+	}
+	return 1 - float64(c.fastestLow)/float64(c.fastestHigh)
+}
+
+// Annotate adds a summary of the run to result under "cpu_throttling":
+// whether the CPU was throttled as "throttled", the throttle events
+// counted as "throttle_events" if exposed, the highest and lowest
+// frequency of the fastest core as "freq_high_mhz" and "freq_low_mhz"
+// along with the relative "freq_drop", and the highest temperature as
+// "temp_max_c" if exposed. Without throttle counters, it adds nothing
+// unless a sample was taken.
+func (c *CPUFreqCounter) Annotate(result map[string]any) {
+	throttled := c.Throttled()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	annotation := map[string]any{"throttled": throttled}
+	if len(c.throttleFiles) > 0 {
+		annotation["throttle_events"] = c.throttleEvents()
+	} else if c.samples == 0 {
+		return
+	}
+	if c.samples > 0 {
+		annotation["freq_high_mhz"] = c.fastestHigh / 1e3
+		annotation["freq_low_mhz"] = c.fastestLow / 1e3
+		annotation["freq_drop"] = c.freqDrop()
+		if len(c.tempFiles) > 0 {
+			annotation["temp_max_c"] = c.tempMax
+		}
+	}
+	result["cpu_throttling"] = annotation
+}
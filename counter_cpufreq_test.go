@@ -0,0 +1,78 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestCPUFreqCounter(t *testing.T) {
+	if _, err := NewCPUFreqCounter(time.Second, fstest.MapFS{}); err == nil {
+		t.Error("expected a sysfs without cpufreq to be rejected")
+	}
+
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data + "\n")} }
+	sysfs := fstest.MapFS{
+		"devices/system/cpu/cpu0/cpufreq/scaling_cur_freq":             file("3000000"),
+		"devices/system/cpu/cpu1/cpufreq/scaling_cur_freq":             file("2000000"),
+		"devices/system/cpu/cpu0/thermal_throttle/core_throttle_count": file("5"),
+		"devices/system/cpu/cpu1/thermal_throttle/core_throttle_count": file("0"),
+		"class/thermal/thermal_zone0/temp":                             file("60000"),
+	}
+	counter, err := NewCPUFreqCounter(time.Second, sysfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter.CountNow()
+	for _, sample := range counter.Result() {
+		values := sample.(map[string]any)
+		if values["freq_max_mhz"] != uint64(3000) || values["freq_mean_mhz"] != float64(2500) {
+			t.Errorf("expected the frequencies of both CPUs, got %v", values)
+		}
+		if values["throttle_events"] != uint64(0) {
+			t.Errorf("expected throttle events before the counter was created to be left out, got %v", values["throttle_events"])
+		}
+	}
+	if counter.Throttled() {
+		t.Error("expected no throttling yet")
+	}
+
+	// the CPU heats up and is throttled
+	sysfs["devices/system/cpu/cpu0/cpufreq/scaling_cur_freq"] = file("1500000")
+	sysfs["devices/system/cpu/cpu1/cpufreq/scaling_cur_freq"] = file("1000000")
+	sysfs["devices/system/cpu/cpu0/thermal_throttle/core_throttle_count"] = file("7")
+	sysfs["class/thermal/thermal_zone0/temp"] = file("95000")
+	counter.CountNow()
+
+	result := make(map[string]any)
+	counter.Annotate(result)
+	annotation, ok := result["cpu_throttling"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected the result to be annotated, got %v", result)
+	}
+	if annotation["throttled"] != true || annotation["throttle_events"] != uint64(2) {
+		t.Errorf("expected 2 throttle events, got %v", annotation)
+	}
+	if annotation["freq_drop"] != 0.5 || annotation["temp_max_c"] != float64(95) {
+		t.Errorf("expected the fastest core to slow down by half at 95°C, got %v", annotation)
+	}
+
+	// without throttle counters, a slowdown of the fastest core is taken as
+	// throttling
+	delete(sysfs, "devices/system/cpu/cpu0/thermal_throttle/core_throttle_count")
+	delete(sysfs, "devices/system/cpu/cpu1/thermal_throttle/core_throttle_count")
+	sysfs["devices/system/cpu/cpu0/cpufreq/scaling_cur_freq"] = file("3000000")
+	counter, err = NewCPUFreqCounter(time.Second, sysfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter.CountNow()
+	sysfs["devices/system/cpu/cpu0/cpufreq/scaling_cur_freq"] = file("2000000")
+	counter.CountNow()
+	if !counter.Throttled() {
+		t.Error("expected a third slower fastest core to be taken as throttling")
+	}
+}
@@ -0,0 +1,234 @@
+package benchmarkconn
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// DefaultRuntimeMetrics are the runtime/metrics keys sampled by a runtime
+// metrics counter if none are selected: the scheduler latency, the number
+// of goroutines, GC cycles and the main memory classes.
+var DefaultRuntimeMetrics = []string{
+	"/sched/latencies:seconds",
+	"/sched/goroutines:goroutines",
+	"/gc/cycles/total:gc-cycles",
+	"/gc/pauses:seconds",
+	"/memory/classes/heap/objects:bytes",
+	"/memory/classes/heap/free:bytes",
+	"/memory/classes/total:bytes",
+}
+
+type runtimeMetricsCounter struct {
+	*CounterBase
+
+	mutex    sync.Mutex // protects samples and previous
+	samples  []metrics.Sample
+	previous map[string]*metrics.Float64Histogram // previous snapshot of each histogram
+}
+
+// NewRuntimeMetricsCounter creates a Counter which snapshots the selected
+// runtime/metrics keys each tick, DefaultRuntimeMetrics if none are
+// selected, see metrics.All for the keys supported.
+//
+// Each sample maps the keys to their values. Histograms, which are
+// cumulative, are summarized over the tick as a map of count, p50, p90,
+// p99 and max, in the unit of the metric.
+func NewRuntimeMetricsCounter(interval time.Duration, names ...string) (Counter, error) {
+	if len(names) == 0 {
+		names = DefaultRuntimeMetrics
+	}
+
+	supported := make(map[string]bool)
+	for _, description := range metrics.All() {
+		supported[description.Name] = true
+	}
+
+	samples := make([]metrics.Sample, len(names))
+	for i, name := range names {
+		if !supported[name] {
+			return nil, fmt.Errorf("runtime metric %q is not supported by %s", name, runtime.Version())
+		}
+		samples[i].Name = name
+	}
+
+	c := &runtimeMetricsCounter{
+		CounterBase: NewCounterBase(interval),
+		samples:     samples,
+		previous:    make(map[string]*metrics.Float64Histogram),
+	}
+	c.snapshot() // histograms are summarized since the counter was created
+	return c, nil
+}
+
+func (c *runtimeMetricsCounter) Name() string {
+	return "runtime_metrics"
+}
+
+func (c *runtimeMetricsCounter) CountNow() {
+	c.report.Add(time.Now(), c.snapshot())
+}
+
+// snapshot reads the selected metrics.
+func (c *runtimeMetricsCounter) snapshot() map[string]any {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	metrics.Read(c.samples)
+
+	values := make(map[string]any, len(c.samples))
+	for _, sample := range c.samples {
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			values[sample.Name] = sample.Value.Uint64()
+		case metrics.KindFloat64:
+			values[sample.Name] = sample.Value.Float64()
+		case metrics.KindFloat64Histogram:
+			current := copyHistogram(sample.Value.Float64Histogram())
+			values[sample.Name] = summarizeHistogram(c.previous[sample.Name], current)
+			c.previous[sample.Name] = current
+		}
+	}
+	return values
+}
+
+func (c *runtimeMetricsCounter) Start() {
+	c.CounterBase.Start()
+	go func() {
+		for {
+			select {
+			case <-c.ticker.C:
+				c.CountNow()
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+}
+
+// copyHistogram copies h, since metrics.Read reuses the memory of the
+// samples.
+func copyHistogram(h *metrics.Float64Histogram) *metrics.Float64Histogram {
+	return &metrics.Float64Histogram{
+		Counts:  append([]uint64(nil), h.Counts...),
+		Buckets: append([]float64(nil), h.Buckets...),
+	}
+}
+
+// summarizeHistogram summarizes the values recorded by the cumulative
+// histogram between previous, if any, and current.
+func summarizeHistogram(previous, current *metrics.Float64Histogram) map[string]any {
+	counts := current.Counts
+	if previous != nil && len(previous.Counts) == len(counts) {
+		counts = make([]uint64, len(current.Counts))
+		for i := range counts {
+			counts[i] = current.Counts[i] - previous.Counts[i]
+		}
+	}
+
+	var total uint64
+	for _, count := range counts {
+		total += count
+	}
+
+	summary := map[string]any{"count": total}
+	if total == 0 {
+		return summary
+	}
+
+	// each bucket is represented by its upper bound unless unbounded
+	bucketValue := func(i int) float64 {
+		if math.IsInf(current.Buckets[i+1], 1) {
+			return current.Buckets[i]
+		}
+		return current.Buckets[i+1]
+	}
+
+	percentiles := []struct {
+		name       string
+		percentile float64
+	}{{"p50", 50}, {"p90", 90}, {"p99", 99}}
+	var cumulative uint64
+	next := 0
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		cumulative += count
+		for next < len(percentiles) && float64(cumulative) >= percentiles[next].percentile/100*float64(total) {
+			summary[percentiles[next].name] = bucketValue(i)
+			next++
+		}
+		summary["max"] = bucketValue(i)
+	}
+	return summary
+}
+
+type goroutineGCCounter struct {
+	*CounterBase
+
+	mutex   sync.Mutex // protects gcStats
+	gcStats debug.GCStats
+	cycles  int64         // GC cycles as of the previous tick
+	pauses  time.Duration // GC pause total as of the previous tick
+}
+
+// NewGoroutineGCCounter creates a Counter which samples the number of
+// goroutines and the GC activity each tick, revealing goroutine leaks and
+// GC pressure of a conn implementation which raw throughput hides.
+//
+// Each sample maps "goroutines" to runtime.NumGoroutine, "gc_cycles" and
+// "gc_pause_ns" to the GC cycles and stop-the-world pause time since the
+// previous tick, and "gc_cycles_total" and "gc_pause_total_ns" to their
+// totals since the process started.
+func NewGoroutineGCCounter(interval time.Duration) Counter {
+	c := &goroutineGCCounter{
+		CounterBase: NewCounterBase(interval),
+	}
+	c.snapshot() // GC activity is counted since the counter was created
+	return c
+}
+
+func (c *goroutineGCCounter) Name() string {
+	return "gc"
+}
+
+func (c *goroutineGCCounter) CountNow() {
+	c.report.Add(time.Now(), c.snapshot())
+}
+
+// snapshot samples the goroutines and the GC activity since the previous
+// snapshot.
+func (c *goroutineGCCounter) snapshot() map[string]any {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	debug.ReadGCStats(&c.gcStats)
+	sample := map[string]any{
+		"goroutines":        runtime.NumGoroutine(),
+		"gc_cycles":         c.gcStats.NumGC - c.cycles,
+		"gc_cycles_total":   c.gcStats.NumGC,
+		"gc_pause_ns":       (c.gcStats.PauseTotal - c.pauses).Nanoseconds(),
+		"gc_pause_total_ns": c.gcStats.PauseTotal.Nanoseconds(),
+	}
+	c.cycles, c.pauses = c.gcStats.NumGC, c.gcStats.PauseTotal
+	return sample
+}
+
+func (c *goroutineGCCounter) Start() {
+	c.CounterBase.Start()
+	go func() {
+		for {
+			select {
+			case <-c.ticker.C:
+				c.CountNow()
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+}
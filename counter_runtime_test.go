@@ -0,0 +1,73 @@
+package benchmarkconn_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestRuntimeMetricsCounter(t *testing.T) {
+	if _, err := NewRuntimeMetricsCounter(time.Second, "/not/a/metric:units"); err == nil {
+		t.Errorf("expected unsupported metrics to be rejected")
+	}
+
+	counter, err := NewRuntimeMetricsCounter(time.Second, "/gc/cycles/total:gc-cycles", "/sched/latencies:seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.GC()
+	counter.CountNow()
+
+	results := counter.Result()
+	if len(results) != 1 {
+		t.Fatalf("expected a single sample, got %d", len(results))
+	}
+	for _, sample := range results {
+		values := sample.(map[string]any)
+		if cycles, ok := values["/gc/cycles/total:gc-cycles"].(uint64); !ok || cycles == 0 {
+			t.Errorf("expected GC cycles to be counted, got %v", values["/gc/cycles/total:gc-cycles"])
+		}
+		summary, ok := values["/sched/latencies:seconds"].(map[string]any)
+		if !ok {
+			t.Fatalf("expected the scheduler latency to be summarized, got %v", values["/sched/latencies:seconds"])
+		}
+		if count := summary["count"].(uint64); count > 0 && summary["p50"].(float64) > summary["max"].(float64) {
+			t.Errorf("expected p50 not to exceed max, got %v", summary)
+		}
+	}
+}
+
+func TestGoroutineGCCounter(t *testing.T) {
+	counter := NewGoroutineGCCounter(time.Second)
+
+	stop := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() { <-stop }()
+	}
+	defer close(stop)
+	runtime.GC()
+	counter.CountNow()
+
+	results := counter.Result()
+	if len(results) != 1 {
+		t.Fatalf("expected a single sample, got %d", len(results))
+	}
+	for _, sample := range results {
+		values := sample.(map[string]any)
+		if goroutines := values["goroutines"].(int); goroutines < 10 {
+			t.Errorf("expected at least 10 goroutines, got %d", goroutines)
+		}
+		if cycles := values["gc_cycles"].(int64); cycles < 1 {
+			t.Errorf("expected the forced GC cycle to be counted, got %d", cycles)
+		}
+		if total := values["gc_cycles_total"].(int64); total < values["gc_cycles"].(int64) {
+			t.Errorf("expected the total GC cycles to include those of the tick, got %v", values)
+		}
+		if pause := values["gc_pause_ns"].(int64); pause <= 0 || pause > values["gc_pause_total_ns"].(int64) {
+			t.Errorf("expected the pause of the forced GC cycle within the total, got %v", values)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package benchmarkconn
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type tcpInfoCounter struct {
+	*CounterBase
+	rawConn syscall.RawConn
+}
+
+// NewTCPInfoCounter creates a Counter which queries TCP_INFO from the
+// benchmarked connection each tick and records the RTT, RTT variance,
+// congestion window, retransmits and delivery rate.
+//
+// It is only supported on Linux.
+func NewTCPInfoCounter(interval time.Duration, conn *net.TCPConn) (Counter, error) {
+	if conn == nil {
+		return nil, errors.New("TCP_INFO counter requires a non-nil *net.TCPConn")
+	}
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tcpInfoCounter{
+		CounterBase: NewCounterBase(interval),
+		rawConn:     rawConn,
+	}, nil
+}
+
+func (c *tcpInfoCounter) Name() string {
+	return "tcpinfo"
+}
+
+func (c *tcpInfoCounter) CountNow() {
+	var info *unix.TCPInfo
+	var sockErr error
+	if err := c.rawConn.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil || sockErr != nil {
+		return // connection is likely closed
+	}
+
+	c.report.Add(time.Now(), map[string]any{
+		"rtt_us":            info.Rtt,
+		"rttvar_us":         info.Rttvar,
+		"snd_cwnd":          info.Snd_cwnd,
+		"snd_mss":           info.Snd_mss,
+		"retransmits":       info.Retransmits,
+		"total_retrans":     info.Total_retrans,
+		"delivery_rate_Bps": info.Delivery_rate,
+		"bytes_acked":       info.Bytes_acked,
+		"bytes_received":    info.Bytes_received,
+		"segs_out":          info.Segs_out,
+		"segs_in":           info.Segs_in,
+		"notsent_bytes":     info.Notsent_bytes,
+	})
+}
+
+func (c *tcpInfoCounter) Start() {
+	c.CounterBase.Start()
+	go func() {
+		for {
+			select {
+			case <-c.ticker.C:
+				c.CountNow()
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+//go:build linux
+
+package benchmarkconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestTCPInfoCounter(t *testing.T) {
+	if _, err := NewTCPInfoCounter(time.Second, nil); err == nil {
+		t.Error("expected a nil conn to be rejected")
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	counter, err := NewTCPInfoCounter(time.Second, conn.(*net.TCPConn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.Name() != "tcpinfo" {
+		t.Errorf("expected the tcpinfo counter, got %s", counter.Name())
+	}
+
+	const size = 64 << 10
+	go conn.Write(make([]byte, size))
+	buf := make([]byte, size)
+	for read := 0; read < size; {
+		n, err := peer.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+	counter.CountNow()
+
+	results := counter.Result()
+	if len(results) != 1 {
+		t.Fatalf("expected a single sample, got %d", len(results))
+	}
+	for _, sample := range results {
+		values := sample.(map[string]any)
+		if cwnd, ok := values["snd_cwnd"].(uint32); !ok || cwnd == 0 {
+			t.Errorf("expected a congestion window, got %v", values["snd_cwnd"])
+		}
+		if segs, ok := values["segs_out"].(uint32); !ok || segs == 0 {
+			t.Errorf("expected segments sent, got %v", values["segs_out"])
+		}
+		if _, ok := values["rtt_us"]; !ok {
+			t.Errorf("expected the RTT, got %v", values)
+		}
+	}
+
+	// a closed connection is no longer sampled
+	conn.Close()
+	counter.CountNow()
+	if results := counter.Result(); len(results) != 1 {
+		t.Errorf("expected no sample of a closed connection, got %d", len(results))
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package benchmarkconn
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// NewTCPInfoCounter creates a Counter which queries TCP_INFO from the
+// benchmarked connection each tick and records the RTT, RTT variance,
+// congestion window, retransmits and delivery rate.
+//
+// It is only supported on Linux.
+func NewTCPInfoCounter(interval time.Duration, conn *net.TCPConn) (Counter, error) {
+	return nil, errors.New("TCP_INFO counter is only supported on Linux")
+}
@@ -0,0 +1,90 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestBoundedCounterReport(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("MaxSamples", func(t *testing.T) {
+		report := NewBoundedCounterReport(CounterRetention{MaxSamples: 3})
+		for i := 0; i < 10; i++ {
+			report.Add(start.Add(time.Duration(i)*time.Second), i)
+		}
+		result := report.Result()
+		if len(result) != 3 {
+			t.Fatalf("expected 3 samples, got %v", result)
+		}
+		for i := 7; i < 10; i++ {
+			if result[start.Add(time.Duration(i)*time.Second)] != i {
+				t.Errorf("expected the latest samples to be kept, got %v", result)
+			}
+		}
+	})
+
+	t.Run("Bucket", func(t *testing.T) {
+		report := NewBoundedCounterReport(CounterRetention{MaxSamples: 2, Bucket: time.Minute})
+		for i := 0; i < 180; i++ {
+			report.Add(start.Add(time.Duration(i)*time.Second), i)
+		}
+		result := report.Result()
+		if len(result) != 2 || result[start.Add(119*time.Second)] != 119 || result[start.Add(179*time.Second)] != 179 {
+			t.Errorf("expected the latest sample of the last 2 minutes, got %v", result)
+		}
+	})
+}
+
+func TestSetCounterRetention(t *testing.T) {
+	counter := NewGoroutineGCCounter(time.Second)
+	if err := SetCounterRetention(counter, CounterRetention{MaxSamples: -1}); err == nil {
+		t.Error("expected a negative retention to be rejected")
+	}
+	if err := SetCounterRetention(counter, CounterRetention{MaxSamples: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		counter.CountNow()
+	}
+	if samples := len(counter.Result()); samples > 2 {
+		t.Errorf("expected at most 2 samples, got %d", samples)
+	}
+}
+
+// namedCounter is a counter reporting a single sample of its name.
+type namedCounter struct {
+	*CounterBase
+	name string
+}
+
+func (c *namedCounter) Name() string { return c.name }
+
+func (c *namedCounter) CountNow() {}
+
+func (c *namedCounter) Result() map[time.Time]any {
+	return map[time.Time]any{time.Unix(1, 0): c.name}
+}
+
+func TestCombinedCounterResults(t *testing.T) {
+	counters := []Counter{
+		&namedCounter{NewCounterBase(time.Second), "tcpinfo"},
+		&namedCounter{NewCounterBase(time.Second), "gc"},
+		&namedCounter{NewCounterBase(time.Second), "tcpinfo"},
+	}
+	if names := CounterNames(counters...); len(names) != 3 || names[0] != "tcpinfo" || names[1] != "gc" || names[2] != "tcpinfo#2" {
+		t.Errorf("expected repeated names to be numbered, got %v", names)
+	}
+
+	results := CombineCounters(time.Second, counters...).Results()
+	if len(results) != 3 {
+		t.Fatalf("expected 3 counters, got %v", results)
+	}
+	for _, name := range []string{"tcpinfo", "gc", "tcpinfo#2"} {
+		if len(results[name]) != 1 {
+			t.Errorf("expected the samples of %s, got %v", name, results[name])
+		}
+	}
+}
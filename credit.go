@@ -0,0 +1,401 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	minCreditMessageSize = 16  // 8 bytes for the step, 1 byte for the last message flag, and room for the end marker
+	creditFrameSize      = 8   // the bytes consumed by the reader so far
+	creditLastMessage    = 1   // flags the last message of a step
+	saturationRatio      = 0.9 // fraction of the maximum throughput a window saturates the transport at
+)
+
+// CreditBenchmark characterizes a window-based transport by measuring the
+// throughput achievable under receiver-driven flow control: the reader
+// grants the writer credit with in-band control frames, and the writer
+// never has more than a credit window of bytes unconsumed by the reader.
+// The writer sends messages for StepDuration with each credit window in
+// Windows, waiting for the reader to consume every message of a step
+// before starting the next one.
+//
+// As window-based transports commonly do, the reader grants credit once
+// it consumed half the window, or a message if the window holds less than
+// two, and at the end of each step.
+type CreditBenchmark struct {
+	MessageSize  int           `json:"message_size" yaml:"message_size"`   // MessageSize defines how many bytes to write for each message, at least 16
+	Windows      []int         `json:"windows" yaml:"windows"`             // Windows defines the credit window of each step in bytes, each at least MessageSize
+	StepDuration time.Duration `json:"step_duration" yaml:"step_duration"` // StepDuration defines how long each step sends messages for
+
+	Control *ControlChannel `json:"-" yaml:"-"` // Control carries the handshake instead of the data connection if set, it must be set on both sides
+
+	messageSize      int            // an internal copy of the message size used in the last run
+	socketOptions    map[string]any // the effective socket options at the start of the last run
+	successfulReads  atomic.Uint64
+	successfulWrites atomic.Uint64
+	creditFrames     atomic.Uint64 // received by the writer, sent by the reader
+	startTime        atomic.Value
+	endTime          atomic.Value
+
+	stepsMutex sync.Mutex
+	steps      []*creditStep // used for sender, the steps run so far
+
+	schedLatency    schedLatencyRecorder
+	decorators      decoratorRecorder
+	versions        versionRecorder
+	allocs          allocRecorder
+	combinedCounter *CombinedCounter
+}
+
+// creditStep is a step of the benchmark with a credit window.
+type creditStep struct {
+	window    int
+	startTime time.Time
+	duration  time.Duration // until the reader consumed the last message
+	messages  uint64
+	stalls    uint64        // how many times the writer ran out of credit
+	stalled   time.Duration // how long the writer waited for credit
+}
+
+func (b *CreditBenchmark) validate() error {
+	if b.MessageSize < minCreditMessageSize {
+		return errors.New("credit requires a message size of at least 16 bytes")
+	}
+	if len(b.Windows) == 0 {
+		return errors.New("credit requires at least one credit window")
+	}
+	for _, window := range b.Windows {
+		if window < b.MessageSize {
+			return fmt.Errorf("credit window of %d bytes cannot hold a message of %d bytes", window, b.MessageSize)
+		}
+	}
+	if b.StepDuration <= 0 {
+		return errors.New("credit requires a positive step duration")
+	}
+	return nil
+}
+
+// grantThreshold returns how many bytes the reader consumes before
+// granting credit with window. It never exceeds the bytes of the whole
+// messages the window holds, so that the writer cannot stall for good.
+func (b *CreditBenchmark) grantThreshold(window int) uint64 {
+	return uint64(max(window/2, b.MessageSize))
+}
+
+func (b *CreditBenchmark) Writer(conn net.Conn, counters ...Counter) (err error) {
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.messageSize = b.MessageSize
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
+	b.successfulReads.Store(0)
+	b.successfulWrites.Store(0)
+	b.creditFrames.Store(0)
+	b.stepsMutex.Lock()
+	b.steps = nil
+	b.stepsMutex.Unlock()
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.startTime.Store(time.Now())
+	defer func() {
+		b.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	// read the credit frames, each carrying the bytes consumed by the reader
+	// so far
+	var consumed atomic.Uint64
+	credited := make(chan struct{}, 1)
+	creditDone := make(chan struct{})
+	go func() {
+		defer close(creditDone)
+		frame := make([]byte, creditFrameSize)
+		for {
+			if _, err := io.ReadFull(conn, frame); err != nil {
+				return
+			}
+			b.creditFrames.Add(1)
+			consumed.Store(binary.BigEndian.Uint64(frame))
+			select {
+			case credited <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	defer func() {
+		// unblock the credit reader once done, the end marker is not
+		// credited. Without deadlines, it is left behind until the conn is
+		// closed
+		if setReadDeadline(conn, time.Now()) {
+			<-creditDone
+			conn.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	// waitConsumed waits until the reader consumed at least n bytes
+	waitConsumed := func(n uint64) error {
+		for consumed.Load() < n {
+			select {
+			case <-credited:
+			case <-creditDone:
+				return errors.New("connection closed while waiting for credit")
+			}
+		}
+		return nil
+	}
+
+	msg := make([]byte, b.messageSize)
+	payloadGenerator(nil).Fill(msg)
+	var sent uint64 // bytes sent over all steps
+	for i, window := range b.Windows {
+		step := &creditStep{window: window}
+		b.stepsMutex.Lock()
+		b.steps = append(b.steps, step)
+		b.stepsMutex.Unlock()
+
+		binary.BigEndian.PutUint64(msg[0:8], uint64(i))
+		step.startTime = time.Now()
+		for last := false; !last; {
+			// send only as much as the reader granted credit for
+			if next := sent + uint64(b.messageSize); next > consumed.Load()+uint64(window) {
+				stallStart := time.Now()
+				if err := waitConsumed(next - uint64(window)); err != nil {
+					return err
+				}
+				step.stalls++
+				step.stalled += time.Since(stallStart)
+			}
+
+			last = time.Since(step.startTime) >= b.StepDuration
+			msg[8] = 0
+			if last {
+				msg[8] = creditLastMessage
+			}
+			if _, err := conn.Write(msg); err != nil {
+				return err
+			}
+			sent += uint64(b.messageSize)
+			step.messages++
+			b.successfulWrites.Add(1)
+		}
+
+		// the step ends once the reader consumed all of it, so that the
+		// next step starts with the whole window
+		if err := waitConsumed(sent); err != nil {
+			return err
+		}
+		step.duration = time.Since(step.startTime)
+	}
+
+	// signal the end of the run to the reader
+	if _, err := conn.Write(endMarker(b.messageSize, b.successfulWrites.Load())); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *CreditBenchmark) Reader(conn net.Conn, counters ...Counter) (err error) {
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.messageSize = b.MessageSize
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
+	b.successfulReads.Store(0)
+	b.successfulWrites.Store(0)
+	b.creditFrames.Store(0)
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.startTime.Store(time.Now())
+	var closedAt time.Time // when the peer closed the data connection, before waiting for its abort
+	defer func() {
+		if closedAt.IsZero() {
+			closedAt = time.Now()
+		}
+		b.endTime.Store(closedAt)
+	}()
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	// grant credit for the consumed messages until the writer signals the
+	// end of the run
+	receivedMsg := make([]byte, b.messageSize)
+	frame := make([]byte, creditFrameSize)
+	var consumed, ungranted uint64
+	for {
+		if _, err := io.ReadFull(conn, receivedMsg); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				closedAt = time.Now()
+				return b.Control.closedErr()
+			}
+			return err
+		}
+		if _, ok := parseEndMarker(receivedMsg); ok {
+			return nil
+		}
+		b.successfulReads.Add(1)
+
+		index := binary.BigEndian.Uint64(receivedMsg[0:8])
+		if index >= uint64(len(b.Windows)) {
+			return fmt.Errorf("received a message of step %d out of %d", index, len(b.Windows))
+		}
+		consumed += uint64(b.messageSize)
+		ungranted += uint64(b.messageSize)
+		if receivedMsg[8] != creditLastMessage && ungranted < b.grantThreshold(b.Windows[index]) {
+			continue
+		}
+
+		binary.BigEndian.PutUint64(frame, consumed)
+		if _, err := conn.Write(frame); err != nil {
+			return err
+		}
+		b.creditFrames.Add(1)
+		ungranted = 0
+	}
+}
+
+// Result returns, for the writer, the throughput achieved with each credit
+// window under "steps", the highest one as max_throughput_bps along with
+// its max_throughput_window, and the smallest window achieving 90% of it
+// as saturating_window. Both sides report the credit frames exchanged as
+// credit_frames.
+func (b *CreditBenchmark) Result() map[string]any {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
+		return map[string]any{}
+	}
+
+	result := map[string]any{
+		"successful_reads":  b.successfulReads.Load(),
+		"successful_writes": b.successfulWrites.Load(),
+		"credit_frames":     b.creditFrames.Load(),
+		"start_time":        b.startTime.Load().(time.Time).Format(time.RFC3339),
+		"end_time":          b.endTime.Load().(time.Time).Format(time.RFC3339),
+		"duration":          b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).String(),
+	}
+
+	b.stepsMutex.Lock()
+	if len(b.steps) > 0 { // writer only
+		steps := make([]map[string]any, len(b.steps))
+		maxThroughput, maxWindow := 0.0, 0
+		for i, step := range b.steps {
+			steps[i] = step.result(b.messageSize)
+			if bps, ok := steps[i]["throughput_bps"].(float64); ok && bps > maxThroughput {
+				maxThroughput, maxWindow = bps, step.window
+			}
+		}
+		result["steps"] = steps
+
+		if maxThroughput > 0 {
+			result["max_throughput_bps"] = maxThroughput
+			result["max_throughput_Mbps"] = maxThroughput / 1e6
+			result["max_throughput_window"] = maxWindow
+
+			// windows need not be sorted
+			saturating := 0
+			for i, step := range b.steps {
+				if bps, ok := steps[i]["throughput_bps"].(float64); ok && bps >= saturationRatio*maxThroughput && (saturating == 0 || step.window < saturating) {
+					saturating = step.window
+				}
+			}
+			result["saturating_window"] = saturating
+		}
+	}
+	b.stepsMutex.Unlock()
+
+	b.schedLatency.addResult(result)
+	b.allocs.addResult(result, b.successfulReads.Load()+b.successfulWrites.Load(), ProfileDefault)
+
+	if b.socketOptions != nil {
+		result["socket_options"] = b.socketOptions
+	}
+	b.decorators.addResult(result, ProfileDefault)
+	b.versions.addResult(result)
+
+	b.Control.addAbortResult(result)
+
+	if b.combinedCounter != nil {
+		result["counters"] = b.combinedCounter.Results()
+	}
+
+	return result
+}
+
+func (s *creditStep) result(messageSize int) map[string]any {
+	result := map[string]any{
+		"window":     s.window,
+		"start_time": s.startTime.Format(time.RFC3339Nano),
+		"messages":   s.messages,
+		"stalls":     s.stalls,
+		"stalled_ns": s.stalled.Nanoseconds(),
+	}
+	if s.duration > 0 {
+		result["duration"] = s.duration.String()
+		result["stalled_ratio"] = s.stalled.Seconds() / s.duration.Seconds()
+	}
+	addBitrate(result, "", s.messages*uint64(messageSize), s.duration.Nanoseconds(), ProfileDefault)
+	return result
+}
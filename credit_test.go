@@ -0,0 +1,86 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestCreditBenchmark(t *testing.T) {
+	newCredit := func() *CreditBenchmark {
+		return &CreditBenchmark{
+			MessageSize:  1024,
+			Windows:      []int{1024, 64 << 10},
+			StepDuration: 100 * time.Millisecond,
+		}
+	}
+	writer, reader := newCredit(), newCredit()
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	writerConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writerConn.Close()
+	readerConn, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readerConn.Close()
+
+	// each credit frame takes at least 1ms to reach the writer, so that a
+	// window of a single message caps the throughput at about 8 Mbps
+	delayedConn, err := DecorateConn(readerConn, &DelayDecorator{Delay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var readerErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readerErr = reader.Reader(delayedConn)
+	}()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if readerErr != nil {
+		t.Fatal(readerErr)
+	}
+
+	result := writer.Result()
+	t.Logf("Writer: %v", result)
+
+	steps, _ := result["steps"].([]map[string]any)
+	if len(steps) != 2 {
+		t.Fatalf("expected a step for each window, got %v", steps)
+	}
+	if small, large := steps[0]["throughput_bps"].(float64), steps[1]["throughput_bps"].(float64); large < 2*small {
+		t.Errorf("expected the larger window to achieve a higher throughput, got %g and %g bps", small, large)
+	}
+	if window := result["saturating_window"]; window != 64<<10 {
+		t.Errorf("expected the larger window to saturate the connection, got %v", window)
+	}
+	if frames := reader.Result()["credit_frames"]; frames != result["credit_frames"] {
+		t.Errorf("expected every credit frame granted to be received, got %v granted and %v received", frames, result["credit_frames"])
+	}
+	if reader.Result()["successful_reads"] != result["successful_writes"] {
+		t.Errorf("expected every message to be read, got %v read and %v written", reader.Result()["successful_reads"], result["successful_writes"])
+	}
+
+	// a window must hold at least a message
+	tooSmall := &CreditBenchmark{MessageSize: 1024, Windows: []int{512}, StepDuration: time.Second}
+	if err := tooSmall.Writer(writerConn); err == nil {
+		t.Error("expected a window smaller than a message to be rejected")
+	}
+}
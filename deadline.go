@@ -0,0 +1,41 @@
+package benchmarkconn
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// setReadDeadline sets the read deadline on conn and reports whether it
+// is supported. Conns implemented inside WASM runtimes (wasip1/js) and
+// other custom transports frequently do not support deadlines, in which
+// case the benchmarks fall back to waiting without them.
+func setReadDeadline(conn net.Conn, t time.Time) bool {
+	return conn.SetReadDeadline(t) == nil
+}
+
+// waitIdle blocks until done is closed or progress stops increasing for
+// longer than idle. It returns true if it gave up due to idleness.
+//
+// It is used in place of a read deadline when the conn does not support
+// deadlines, in which case the blocked reader is left behind until the
+// conn is closed.
+func waitIdle(done <-chan struct{}, progress *atomic.Uint64, idle time.Duration) bool {
+	last := progress.Load()
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return false
+		case <-timer.C:
+			current := progress.Load()
+			if current == last {
+				return true
+			}
+			last = current
+			timer.Reset(idle)
+		}
+	}
+}
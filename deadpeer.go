@@ -0,0 +1,218 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	KillModeClose  = "close"  // the victim closes the connection abruptly, sending RST if possible
+	KillModeSilent = "silent" // the victim stops sending without closing the connection
+)
+
+// DeadPeerBenchmark measures how long the surviving side of a connection
+// takes to notice that its peer has died.
+//
+// The writer acts as the victim: it sends a message every Interval and,
+// after KillAfter, dies according to KillMode. The reader acts as the
+// survivor: it applies the configured keepalive and idle timeout settings
+// and reports the time between the last message received and the moment
+// it detected the dead peer.
+type DeadPeerBenchmark struct {
+	MessageSize int           `json:"message_size" yaml:"message_size"` // MessageSize defines how many bytes to write for each heartbeat message
+	Interval    time.Duration `json:"interval" yaml:"interval"`         // Interval defines how long to wait between each heartbeat message
+	KillAfter   time.Duration `json:"kill_after" yaml:"kill_after"`     // KillAfter defines how long the victim stays alive before it dies
+	KillMode    string        `json:"kill_mode" yaml:"kill_mode"`       // KillMode defines how the victim dies, either "close" or "silent"
+	KeepAlive   time.Duration `json:"keep_alive" yaml:"keep_alive"`     // KeepAlive defines the TCP keepalive period on the survivor side, 0 to leave it untouched and negative to disable it
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout"` // IdleTimeout defines how long the survivor waits for the next message before declaring the peer dead, 0 to disable
+
+	messageSize     int // an internal copy of the message size used in the last run
+	messagesSent    atomic.Uint64
+	messagesRecv    atomic.Uint64
+	killTime        atomic.Value
+	lastMessageTime atomic.Value
+	detectionTime   atomic.Value
+	detectionCause  atomic.Value
+	startTime       atomic.Value
+	endTime         atomic.Value
+
+	versions        versionRecorder
+	combinedCounter *CombinedCounter
+}
+
+func (b *DeadPeerBenchmark) Writer(conn net.Conn, counters ...Counter) error {
+	if b.KillMode != KillModeClose && b.KillMode != KillModeSilent {
+		return errors.New("unknown kill mode, must be either \"close\" or \"silent\"")
+	}
+
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshake(conn, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.messageSize = b.MessageSize
+	b.messagesSent.Store(0)
+	b.startTime.Store(time.Now())
+	defer func() {
+		b.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	var msg = make([]byte, b.messageSize)
+	ticker := time.NewTicker(b.Interval)
+	killTimer := time.NewTimer(b.KillAfter)
+	defer ticker.Stop()
+	defer killTimer.Stop()
+
+SENDING:
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := conn.Write(msg); err != nil {
+				return err
+			}
+			b.messagesSent.Add(1)
+		case <-killTimer.C:
+			break SENDING
+		}
+	}
+
+	b.killTime.Store(time.Now())
+	switch b.KillMode {
+	case KillModeClose:
+		// drop any unsent data and send RST instead of FIN if possible
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		return conn.Close()
+	default: // KillModeSilent
+		// keep the connection open but stop sending, until the survivor gives up
+		io.Copy(io.Discard, conn)
+		return nil
+	}
+}
+
+func (b *DeadPeerBenchmark) Reader(conn net.Conn, counters ...Counter) error {
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshake(conn, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	// Apply keepalive settings
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if b.KeepAlive > 0 {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(b.KeepAlive)
+		} else if b.KeepAlive < 0 {
+			tcpConn.SetKeepAlive(false)
+		}
+	}
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.messageSize = b.MessageSize
+	b.messagesRecv.Store(0)
+	b.startTime.Store(time.Now())
+	b.lastMessageTime.Store(time.Now())
+	defer func() {
+		b.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	var receivedMsg = make([]byte, b.messageSize)
+	for {
+		if b.IdleTimeout > 0 && !setReadDeadline(conn, time.Now().Add(b.IdleTimeout)) {
+			return errors.New("idle timeout requires a conn supporting read deadlines")
+		}
+
+		_, err := io.ReadFull(conn, receivedMsg)
+		if err != nil {
+			b.detectionTime.Store(time.Now())
+			b.detectionCause.Store(deadPeerDetectionCause(err))
+			if errors.Is(err, net.ErrClosed) {
+				return err // closed locally, e.g., timed out
+			}
+			return nil
+		}
+		b.messagesRecv.Add(1)
+		b.lastMessageTime.Store(time.Now())
+	}
+}
+
+// deadPeerDetectionCause classifies the error which revealed the dead peer.
+func deadPeerDetectionCause(err error) string {
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "idle_timeout"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.ETIMEDOUT):
+		return "keepalive_timeout"
+	case errors.Is(err, net.ErrClosed):
+		return "closed_locally"
+	default:
+		return "error: " + err.Error()
+	}
+}
+
+func (b *DeadPeerBenchmark) Result() map[string]any {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() {
+		return map[string]any{}
+	}
+
+	result := map[string]any{
+		"start_time": b.startTime.Load().(time.Time).Format(time.RFC3339),
+		"end_time":   b.endTime.Load().(time.Time).Format(time.RFC3339),
+		"duration":   b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).String(),
+	}
+
+	// Victim only
+	if b.killTime.Load() != nil {
+		result["kill_mode"] = b.KillMode
+		result["kill_time"] = b.killTime.Load().(time.Time).Format(time.RFC3339Nano)
+		result["messages_sent"] = b.messagesSent.Load()
+	}
+
+	// Survivor only
+	if b.detectionTime.Load() != nil {
+		detectionLatency := b.detectionTime.Load().(time.Time).Sub(b.lastMessageTime.Load().(time.Time))
+		result["messages_received"] = b.messagesRecv.Load()
+		result["detection_cause"] = b.detectionCause.Load().(string)
+		result["detection_latency_ns"] = detectionLatency.Nanoseconds()
+		result["detection_latency"] = detectionLatency.String()
+	}
+
+	b.versions.addResult(result)
+	if b.combinedCounter != nil {
+		result["counters"] = b.combinedCounter.Results()
+	}
+
+	return result
+}
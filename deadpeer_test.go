@@ -0,0 +1,104 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// runDeadPeer runs bench over loopback TCP, closing the connection of the
+// survivor once it detected the dead peer, as a silent victim waits for.
+func runDeadPeer(t *testing.T, bench func() *DeadPeerBenchmark) (victim, survivor *DeadPeerBenchmark) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	victimConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer victimConn.Close()
+	survivorConn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer survivorConn.Close()
+
+	victim, survivor = bench(), bench()
+	victimErr := make(chan error, 1)
+	go func() {
+		victimErr <- victim.Writer(victimConn)
+	}()
+	if err := survivor.Reader(survivorConn); err != nil {
+		t.Fatalf("survivor: %v", err)
+	}
+	survivorConn.Close()
+	select {
+	case err := <-victimErr:
+		if err != nil {
+			t.Fatalf("victim: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("victim did not return once the survivor closed the connection")
+	}
+	return victim, survivor
+}
+
+func TestDeadPeerBenchmark(t *testing.T) {
+	t.Run("Close", func(t *testing.T) {
+		victim, survivor := runDeadPeer(t, func() *DeadPeerBenchmark {
+			return &DeadPeerBenchmark{MessageSize: 64, Interval: 5 * time.Millisecond, KillAfter: 50 * time.Millisecond, KillMode: KillModeClose}
+		})
+
+		result := survivor.Result()
+		if cause := result["detection_cause"]; cause != "reset" && cause != "eof" {
+			t.Errorf("expected the close to be detected by a reset or EOF, got %v", cause)
+		}
+		latency, ok := result["detection_latency_ns"].(int64)
+		if !ok || latency < 0 || latency > int64(time.Second) {
+			t.Errorf("expected a detection latency within a second, got %v", result["detection_latency_ns"])
+		}
+		sent, _ := victim.Result()["messages_sent"].(uint64)
+		if received, _ := result["messages_received"].(uint64); received == 0 || received > sent {
+			t.Errorf("expected messages received before the close, at most those sent, sent %d, received %d", sent, received)
+		}
+		if mode := victim.Result()["kill_mode"]; mode != KillModeClose {
+			t.Errorf("expected the kill mode in the result of the victim, got %v", mode)
+		}
+	})
+
+	t.Run("SilentIdleTimeout", func(t *testing.T) {
+		const idleTimeout = 100 * time.Millisecond
+		_, survivor := runDeadPeer(t, func() *DeadPeerBenchmark {
+			return &DeadPeerBenchmark{MessageSize: 64, Interval: 5 * time.Millisecond, KillAfter: 50 * time.Millisecond, KillMode: KillModeSilent, IdleTimeout: idleTimeout}
+		})
+
+		result := survivor.Result()
+		if cause := result["detection_cause"]; cause != "idle_timeout" {
+			t.Errorf("expected the silence to be detected by the idle timeout, got %v", cause)
+		}
+		latency, ok := result["detection_latency_ns"].(int64)
+		if !ok || latency < int64(idleTimeout) || latency > int64(idleTimeout+time.Second) {
+			t.Errorf("expected a detection latency of about %s, got %v", idleTimeout, result["detection_latency_ns"])
+		}
+		if received := result["messages_received"]; received == uint64(0) {
+			t.Error("expected messages received before the victim went silent")
+		}
+	})
+
+	t.Run("UnknownKillMode", func(t *testing.T) {
+		bench := &DeadPeerBenchmark{MessageSize: 64, Interval: time.Millisecond, KillMode: "explode"}
+		conn, peer := net.Pipe()
+		defer conn.Close()
+		defer peer.Close()
+		if err := bench.Writer(conn); err == nil {
+			t.Error("expected an unknown kill mode to fail")
+		}
+	})
+}
@@ -0,0 +1,706 @@
+package benchmarkconn
+
+import (
+	"compress/flate"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnDecorator wraps a connection before a benchmark runs over it, e.g.,
+// to meter, impair, compress or encrypt it.
+type ConnDecorator interface {
+	Decorate(net.Conn) (net.Conn, error)
+
+	// String describes the decorator and its parameters, recorded in the
+	// results for reproducibility, e.g., "delay:10ms".
+	String() string
+}
+
+// decoratorStats is implemented by decorators reporting statistics, e.g.,
+// the bytes metered, recorded in the results along with their names.
+type decoratorStats interface {
+	Stats() map[string]any
+}
+
+// decoratedConn is a connection wrapped by a chain of decorators.
+type decoratedConn struct {
+	net.Conn            // the connection returned by the last decorator
+	raw        net.Conn // the connection before decoration
+	decorators []ConnDecorator
+}
+
+// DecorateConn wraps conn with decorators in order, the first one wrapping
+// conn directly and the last one wrapping all others. The benchmarks run
+// over the returned connection record the chain in their results under
+// "decorators", in the same order, and the overhead of the layers between
+// meters under "decorator_overhead", e.g., with meter, flate, meter.
+func DecorateConn(conn net.Conn, decorators ...ConnDecorator) (net.Conn, error) {
+	decorated := conn
+	for _, decorator := range decorators {
+		var err error
+		decorated, err = decorator.Decorate(decorated)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", decorator, err)
+		}
+	}
+	return &decoratedConn{Conn: decorated, raw: conn, decorators: decorators}, nil
+}
+
+// NetConn returns the connection before decoration, e.g., to read socket
+// options from.
+func (c *decoratedConn) NetConn() net.Conn {
+	return c.raw
+}
+
+// decoratorRecorder records the decorators of the connection a benchmark
+// runs over, if any.
+type decoratorRecorder struct {
+	mutex      sync.Mutex
+	decorators []ConnDecorator
+}
+
+func (r *decoratorRecorder) startRecording(conn net.Conn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.decorators = nil
+	if c, ok := conn.(*decoratedConn); ok {
+		r.decorators = c.decorators
+	}
+}
+
+// addResult adds the decorators to result under "decorators", each with
+// its name under "decorator" and its statistics, if any. If the chain has
+// several meters, it adds the overhead of the layers between them under
+// "decorator_overhead".
+func (r *decoratorRecorder) addResult(result map[string]any, profile Profile) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.decorators) == 0 {
+		return
+	}
+
+	decorators := make([]map[string]any, len(r.decorators))
+	for i, decorator := range r.decorators {
+		decorators[i] = map[string]any{"decorator": decorator.String()}
+		if stats, ok := decorator.(decoratorStats); ok {
+			for key, value := range stats.Stats() {
+				decorators[i][key] = value
+			}
+		}
+	}
+	result["decorators"] = decorators
+
+	if overhead := r.overhead(profile); len(overhead) > 0 {
+		result["decorator_overhead"] = overhead
+	}
+}
+
+// overhead attributes the cost of the layers between consecutive meters
+// in the chain, each layer being the decorators between two meters. For
+// each layer, it reports the decorators under "layer", the bytes passed
+// to the inner meter per byte passed to the outer one as
+// write_amplification and read_amplification, and the time spent in the
+// layer per call of the outer meter as write_latency_ns and
+// read_latency_ns. With ProfileConstrained, the amplifications are left
+// out and the latencies are integers.
+func (r *decoratorRecorder) overhead(profile Profile) []map[string]any {
+	var layers []map[string]any
+	var inner *MeterDecorator
+	var innerIndex int
+	for i, decorator := range r.decorators {
+		outer, ok := decorator.(*MeterDecorator)
+		if !ok {
+			continue
+		}
+		if inner != nil {
+			names := make([]string, 0, i-innerIndex-1)
+			for _, d := range r.decorators[innerIndex+1 : i] {
+				names = append(names, d.String())
+			}
+			layers = append(layers, meterOverhead(strings.Join(names, ","), inner, outer, profile))
+		}
+		inner, innerIndex = outer, i
+	}
+	return layers
+}
+
+// meterOverhead returns the overhead of the layer between the inner and
+// the outer meter.
+func meterOverhead(layer string, inner, outer *MeterDecorator, profile Profile) map[string]any {
+	overhead := map[string]any{"layer": layer}
+	for _, direction := range []struct {
+		name                     string
+		innerBytes, outerBytes   uint64
+		innerNs, outerNs, outerN uint64
+	}{
+		{"write", inner.bytesWritten.Load(), outer.bytesWritten.Load(), inner.writeNs.Load(), outer.writeNs.Load(), outer.writes.Load()},
+		{"read", inner.bytesRead.Load(), outer.bytesRead.Load(), inner.readNs.Load(), outer.readNs.Load(), outer.reads.Load()},
+	} {
+		if direction.outerN == 0 {
+			continue
+		}
+		// the outer meter times the calls including the inner meter's
+		addedNs := direction.outerNs - min(direction.innerNs, direction.outerNs)
+		if profile == ProfileConstrained { // integer-only stats
+			overhead[direction.name+"_latency_ns"] = addedNs / direction.outerN
+			continue
+		}
+		overhead[direction.name+"_latency_ns"] = float64(addedNs) / float64(direction.outerN)
+		if direction.outerBytes > 0 {
+			overhead[direction.name+"_amplification"] = float64(direction.innerBytes) / float64(direction.outerBytes)
+		}
+	}
+	return overhead
+}
+
+// MeterDecorator counts the bytes, calls and time spent in calls of reads
+// and writes at its position in the chain, e.g., before and after
+// compression. Meters placed between decorators attribute the overhead of
+// each layer, see DecorateConn. A meter must decorate a single connection.
+type MeterDecorator struct {
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	reads        atomic.Uint64
+	writes       atomic.Uint64
+	readNs       atomic.Uint64
+	writeNs      atomic.Uint64
+}
+
+func (d *MeterDecorator) Decorate(conn net.Conn) (net.Conn, error) {
+	return &meteredConn{Conn: conn, meter: d}, nil
+}
+
+func (d *MeterDecorator) String() string {
+	return "meter"
+}
+
+// Stats returns the bytes read and written as bytes_read and
+// bytes_written, the calls as reads and writes, and the time spent in
+// them as read_time_ns and write_time_ns.
+func (d *MeterDecorator) Stats() map[string]any {
+	return map[string]any{
+		"bytes_read":    d.bytesRead.Load(),
+		"bytes_written": d.bytesWritten.Load(),
+		"reads":         d.reads.Load(),
+		"writes":        d.writes.Load(),
+		"read_time_ns":  d.readNs.Load(),
+		"write_time_ns": d.writeNs.Load(),
+	}
+}
+
+type meteredConn struct {
+	net.Conn
+	meter *MeterDecorator
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Read(p)
+	c.meter.readNs.Add(uint64(time.Since(start)))
+	c.meter.bytesRead.Add(uint64(n))
+	c.meter.reads.Add(1)
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(p)
+	c.meter.writeNs.Add(uint64(time.Since(start)))
+	c.meter.bytesWritten.Add(uint64(n))
+	c.meter.writes.Add(1)
+	return n, err
+}
+
+// DelayDecorator delays each write by Delay, impairing the connection with
+// a fixed per-write latency. Since writes block, it also caps the write
+// rate.
+type DelayDecorator struct {
+	Delay time.Duration
+}
+
+func (d *DelayDecorator) Decorate(conn net.Conn) (net.Conn, error) {
+	if d.Delay < 0 {
+		return nil, fmt.Errorf("negative delay %s", d.Delay)
+	}
+	return &delayedConn{Conn: conn, delay: d.Delay}, nil
+}
+
+func (d *DelayDecorator) String() string {
+	return "delay:" + d.Delay.String()
+}
+
+type delayedConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *delayedConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+// RateLimitDecorator paces writes to at most BitsPerSecond, impairing the
+// connection with a bottleneck.
+type RateLimitDecorator struct {
+	BitsPerSecond float64
+}
+
+func (d *RateLimitDecorator) Decorate(conn net.Conn) (net.Conn, error) {
+	if d.BitsPerSecond <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %g", d.BitsPerSecond)
+	}
+	return &rateLimitedConn{Conn: conn, bitsPerSecond: d.BitsPerSecond}, nil
+}
+
+func (d *RateLimitDecorator) String() string {
+	return fmt.Sprintf("ratelimit:%gMbps", d.BitsPerSecond/1e6)
+}
+
+type rateLimitedConn struct {
+	net.Conn
+	bitsPerSecond float64
+
+	mutex   sync.Mutex
+	start   time.Time
+	written uint64
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	if c.start.IsZero() {
+		c.start = time.Now()
+	}
+	// wait until the bytes written so far are due at the rate
+	due := c.start.Add(time.Duration(float64(c.written*8) / c.bitsPerSecond * float64(time.Second)))
+	c.written += uint64(len(p))
+	c.mutex.Unlock()
+
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return c.Conn.Write(p)
+}
+
+// shapeDrainTimeout is how long closing a shaped connection waits for the
+// writes still in flight beyond their delay before closing it anyway, e.g.,
+// if the peer stopped reading.
+const shapeDrainTimeout = time.Second
+
+// shapeQueueSize is how many writes may be in flight on a shaped
+// connection before further writes block.
+const shapeQueueSize = 1024
+
+// shapingPresets are the named link profiles of ShapePreset, with one-way
+// delays and rates of typical links, per direction.
+var shapingPresets = map[string]ShapeDecorator{
+	"datacenter": {Delay: 250 * time.Microsecond, BitsPerSecond: 10e9},
+	"lte":        {Delay: 35 * time.Millisecond, BitsPerSecond: 20e6},
+	"dsl":        {Delay: 15 * time.Millisecond, BitsPerSecond: 8e6},
+	"3g":         {Delay: 100 * time.Millisecond, BitsPerSecond: 1.6e6},
+	"satellite":  {Delay: 300 * time.Millisecond, BitsPerSecond: 10e6},
+}
+
+// ShapeDecorator shapes the writes of a connection like a link with a
+// one-way Delay behind a bottleneck of BitsPerSecond, similar to netem.
+// Unlike DelayDecorator, writes return once they passed the bottleneck and
+// are delivered after Delay in the background, so the delay does not cap
+// the rate. An error delivering a write is returned by the next Write. It
+// must decorate both sides to shape both directions.
+type ShapeDecorator struct {
+	Preset        string        // Preset names the preset the parameters come from, if any, recorded in place of them
+	Delay         time.Duration // Delay defines how long each write takes to reach the peer once it passed the bottleneck
+	BitsPerSecond float64       // BitsPerSecond defines the rate of the bottleneck, 0 for no bottleneck
+}
+
+// ShapePreset returns the ShapeDecorator of the named preset, among
+// ShapePresets.
+func ShapePreset(name string) (*ShapeDecorator, error) {
+	preset, ok := shapingPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown shaping preset %q, must be one of %s", name, strings.Join(ShapePresets(), ", "))
+	}
+	preset.Preset = name
+	return &preset, nil
+}
+
+// ShapePresets returns the names of the shaping presets, from the fastest
+// to the slowest link.
+func ShapePresets() []string {
+	return []string{"datacenter", "lte", "dsl", "3g", "satellite"}
+}
+
+func (d *ShapeDecorator) Decorate(conn net.Conn) (net.Conn, error) {
+	if d.Delay < 0 {
+		return nil, fmt.Errorf("negative delay %s", d.Delay)
+	}
+	if d.BitsPerSecond < 0 {
+		return nil, fmt.Errorf("negative rate %g", d.BitsPerSecond)
+	}
+
+	c := &shapedConn{
+		Conn:          conn,
+		delay:         d.Delay,
+		bitsPerSecond: d.BitsPerSecond,
+		queue:         make(chan shapedWrite, shapeQueueSize),
+		done:          make(chan struct{}),
+	}
+	go c.deliver()
+	return c, nil
+}
+
+// String returns "shape:<preset>" for presets, otherwise
+// "shape:<delay>:<Mbps>".
+func (d *ShapeDecorator) String() string {
+	if d.Preset != "" {
+		return "shape:" + d.Preset
+	}
+	return fmt.Sprintf("shape:%s:%g", d.Delay, d.BitsPerSecond/1e6)
+}
+
+// Stats returns the parameters of the link, so that presets are
+// reproducible even if they change.
+func (d *ShapeDecorator) Stats() map[string]any {
+	return map[string]any{
+		"delay_ns": d.Delay.Nanoseconds(),
+		"rate_bps": uint64(d.BitsPerSecond),
+	}
+}
+
+type shapedWrite struct {
+	p   []byte
+	due time.Time
+}
+
+type shapedConn struct {
+	net.Conn
+	delay         time.Duration
+	bitsPerSecond float64
+
+	mutex     sync.Mutex
+	departure time.Time // when the last write passes the bottleneck
+	closed    bool
+	queue     chan shapedWrite
+	done      chan struct{}
+
+	err atomic.Value // the first error delivering a write
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	if err, ok := c.err.Load().(error); ok {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return 0, net.ErrClosed
+	}
+	departure := time.Now()
+	if c.departure.After(departure) {
+		departure = c.departure
+	}
+	if c.bitsPerSecond > 0 {
+		departure = departure.Add(time.Duration(float64(len(p)*8) / c.bitsPerSecond * float64(time.Second)))
+	}
+	c.departure = departure
+	// queued under the mutex to keep writes in order
+	c.queue <- shapedWrite{p: append([]byte(nil), p...), due: departure.Add(c.delay)}
+	c.mutex.Unlock()
+
+	// block until the write passed the bottleneck, pushing back on the writer
+	if wait := time.Until(departure); wait > 0 {
+		time.Sleep(wait)
+	}
+	return len(p), nil
+}
+
+// deliver writes the queued writes to the connection once they are due,
+// discarding them after an error.
+func (c *shapedConn) deliver() {
+	defer close(c.done)
+	for w := range c.queue {
+		if c.err.Load() != nil {
+			continue
+		}
+		if wait := time.Until(w.due); wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := c.Conn.Write(w.p); err != nil {
+			c.err.Store(err)
+		}
+	}
+}
+
+// Close waits for the writes in flight to be delivered, up to
+// shapeDrainTimeout beyond the delay, then closes the connection.
+func (c *shapedConn) Close() error {
+	c.mutex.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mutex.Unlock()
+
+	select {
+	case <-c.done:
+	case <-time.After(c.delay + shapeDrainTimeout):
+	}
+	return c.Conn.Close()
+}
+
+// FlateDecorator compresses the connection with DEFLATE at Level, see
+// compress/flate, flushing after each write. It must decorate both sides.
+type FlateDecorator struct {
+	Level int
+}
+
+func (d *FlateDecorator) Decorate(conn net.Conn) (net.Conn, error) {
+	writer, err := flate.NewWriter(conn, d.Level)
+	if err != nil {
+		return nil, err
+	}
+	return &flateConn{Conn: conn, reader: flate.NewReader(conn), writer: writer}, nil
+}
+
+func (d *FlateDecorator) String() string {
+	return fmt.Sprintf("flate:%d", d.Level)
+}
+
+type flateConn struct {
+	net.Conn
+	reader io.ReadCloser
+
+	mutex  sync.Mutex
+	writer *flate.Writer
+}
+
+func (c *flateConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *flateConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n, err := c.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.writer.Flush() // deliver each write promptly
+}
+
+// TLSDecorator secures the connection with TLS, as the client or, if
+// Server is set, the server. The handshake is completed before the
+// benchmark starts.
+type TLSDecorator struct {
+	Config *tls.Config
+	Server bool
+}
+
+func (d *TLSDecorator) Decorate(conn net.Conn) (net.Conn, error) {
+	var tlsConn *tls.Conn
+	if d.Server {
+		tlsConn = tls.Server(conn, d.Config)
+	} else {
+		tlsConn = tls.Client(conn, d.Config)
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (d *TLSDecorator) String() string {
+	return "tls"
+}
+
+// paddingHeaderSize is the size of the header of each padded record: the
+// 2-byte length of the data followed by the 2-byte length of the padding.
+const paddingHeaderSize = 4
+
+// maxPaddingField is the largest length in a padded record header.
+const maxPaddingField = 1<<16 - 1
+
+// PaddingDecorator simulates traffic-shaping defenses of obfuscated
+// transports: it pads each write to a multiple of BlockSize bytes and
+// sends dummy records of DummySize bytes at DummyRate records per second,
+// so their throughput and latency cost can be measured with the standard
+// benchmarks. Each record carries a header with the length of its data and
+// padding. It must decorate both sides, though the parameters only apply
+// to writes and may differ. A padding decorator must decorate a single
+// connection.
+type PaddingDecorator struct {
+	BlockSize int     // BlockSize defines the multiple each record is padded to, header included, 0 for no padding
+	DummyRate float64 // DummyRate defines how many dummy records to send per second, 0 for none
+	DummySize int     // DummySize defines the size of each dummy record, header included, BlockSize if 0
+
+	paddingBytes atomic.Uint64
+	dummyBytes   atomic.Uint64
+	dummyRecords atomic.Uint64
+}
+
+func (d *PaddingDecorator) Decorate(conn net.Conn) (net.Conn, error) {
+	if d.BlockSize < 0 || d.BlockSize > maxPaddingField {
+		return nil, fmt.Errorf("padding block size must be within [0, %d], got %d", maxPaddingField, d.BlockSize)
+	}
+	if d.DummyRate < 0 {
+		return nil, fmt.Errorf("negative dummy rate %g", d.DummyRate)
+	}
+	if d.DummyRate > 0 && (d.dummySize() < paddingHeaderSize || d.dummySize() > paddingHeaderSize+maxPaddingField) {
+		return nil, fmt.Errorf("dummy record size must be within [%d, %d], got %d", paddingHeaderSize, paddingHeaderSize+maxPaddingField, d.dummySize())
+	}
+
+	c := &paddedConn{Conn: conn, padding: d, closed: make(chan struct{})}
+	if d.DummyRate > 0 {
+		go c.sendDummies()
+	}
+	return c, nil
+}
+
+func (d *PaddingDecorator) String() string {
+	if d.DummyRate > 0 {
+		return fmt.Sprintf("pad:%d:%g:%d", d.BlockSize, d.DummyRate, d.dummySize())
+	}
+	return fmt.Sprintf("pad:%d", d.BlockSize)
+}
+
+// Stats returns the bytes of headers and padding added to the data
+// written as padding_bytes, and the dummy records sent as dummy_records
+// and dummy_bytes.
+func (d *PaddingDecorator) Stats() map[string]any {
+	return map[string]any{
+		"padding_bytes": d.paddingBytes.Load(),
+		"dummy_bytes":   d.dummyBytes.Load(),
+		"dummy_records": d.dummyRecords.Load(),
+	}
+}
+
+func (d *PaddingDecorator) dummySize() int {
+	if d.DummySize == 0 {
+		return d.BlockSize
+	}
+	return d.DummySize
+}
+
+type paddedConn struct {
+	net.Conn
+	padding *PaddingDecorator
+
+	readMutex     sync.Mutex
+	readRemaining int // data left to read in the current record
+	readPadding   int // padding to skip after the data of the current record
+
+	writeMutex sync.Mutex
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+func (c *paddedConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	// skip dummy records
+	for c.readRemaining == 0 {
+		var header [paddingHeaderSize]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		c.readRemaining = int(binary.BigEndian.Uint16(header[0:2]))
+		c.readPadding = int(binary.BigEndian.Uint16(header[2:4]))
+		if err := c.skipPadding(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.Conn.Read(p[:min(len(p), c.readRemaining)])
+	c.readRemaining -= n
+	if err == nil {
+		err = c.skipPadding()
+	}
+	return n, err
+}
+
+// skipPadding discards the padding once the data of the current record
+// was read, rather than on the next read, since the writer may be blocked
+// until it is read.
+func (c *paddedConn) skipPadding() error {
+	if c.readRemaining > 0 || c.readPadding == 0 {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, c.Conn, int64(c.readPadding))
+	c.readPadding = 0
+	return err
+}
+
+func (c *paddedConn) Write(p []byte) (int, error) {
+	// build all records, so that they are written at once
+	var records []byte
+	for data := p; len(data) > 0; {
+		chunk := data[:min(len(data), maxPaddingField)]
+		data = data[len(chunk):]
+
+		padding := 0
+		if blockSize := c.padding.BlockSize; blockSize > 0 {
+			padding = (blockSize - (paddingHeaderSize+len(chunk))%blockSize) % blockSize
+		}
+		records = binary.BigEndian.AppendUint16(records, uint16(len(chunk)))
+		records = binary.BigEndian.AppendUint16(records, uint16(padding))
+		records = append(records, chunk...)
+		records = append(records, make([]byte, padding)...)
+		c.padding.paddingBytes.Add(uint64(paddingHeaderSize + padding))
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if _, err := c.Conn.Write(records); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sendDummies sends dummy records at the dummy rate until the connection
+// is closed or a write fails.
+func (c *paddedConn) sendDummies() {
+	size := c.padding.dummySize()
+	record := make([]byte, size)
+	binary.BigEndian.PutUint16(record[2:4], uint16(size-paddingHeaderSize))
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / c.padding.DummyRate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.closed:
+			return
+		}
+
+		c.writeMutex.Lock()
+		_, err := c.Conn.Write(record)
+		c.writeMutex.Unlock()
+		if err != nil {
+			return
+		}
+		c.padding.dummyRecords.Add(1)
+		c.padding.dummyBytes.Add(uint64(size))
+	}
+}
+
+func (c *paddedConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,224 @@
+package benchmarkconn_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestDecorateConn(t *testing.T) {
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	// meter the compressed bytes on the wire and the bytes written by the
+	// benchmark
+	wire, app := &MeterDecorator{}, &MeterDecorator{}
+	decoratedWriterConn, err := DecorateConn(writerConn, wire, &FlateDecorator{Level: 6}, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoratedReaderConn, err := DecorateConn(readerConn, &FlateDecorator{Level: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100, Payload: ZeroPayload()}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100, Payload: ZeroPayload()}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := writer.Writer(decoratedWriterConn); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(decoratedReaderConn); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if reads := reader.Result()["successful_reads"]; reads != uint64(100) {
+		t.Errorf("expected 100 messages read through the decorators, got %v", reads)
+	}
+
+	decorators, ok := writer.Result()["decorators"].([]map[string]any)
+	if !ok || len(decorators) != 3 {
+		t.Fatalf("expected the chain of 3 decorators to be recorded, got %v", writer.Result()["decorators"])
+	}
+	for i, name := range []string{"meter", "flate:6", "meter"} {
+		if decorators[i]["decorator"] != name {
+			t.Errorf("expected decorator %d to be %s, got %v", i, name, decorators[i]["decorator"])
+		}
+	}
+	appBytes, wireBytes := decorators[2]["bytes_written"].(uint64), decorators[0]["bytes_written"].(uint64)
+	if appBytes < 100*1024 || wireBytes >= appBytes/2 {
+		t.Errorf("expected zeros to compress, got %d bytes written and %d on the wire", appBytes, wireBytes)
+	}
+}
+
+func TestDecoratorOverhead(t *testing.T) {
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	// a meter between each layer attributes the cost of compression and of
+	// the delay separately
+	decoratedWriterConn, err := DecorateConn(writerConn, &MeterDecorator{}, &FlateDecorator{Level: 6}, &MeterDecorator{}, &DelayDecorator{Delay: time.Millisecond}, &MeterDecorator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoratedReaderConn, err := DecorateConn(readerConn, &FlateDecorator{Level: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 20, Payload: ZeroPayload()}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 20, Payload: ZeroPayload()}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := writer.Writer(decoratedWriterConn); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(decoratedReaderConn); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	overhead, ok := writer.Result()["decorator_overhead"].([]map[string]any)
+	if !ok || len(overhead) != 2 {
+		t.Fatalf("expected the overhead of 2 layers, got %v", writer.Result()["decorator_overhead"])
+	}
+	if overhead[0]["layer"] != "flate:6" || overhead[1]["layer"] != "delay:1ms" {
+		t.Fatalf("expected the flate:6 and delay:1ms layers, got %v and %v", overhead[0]["layer"], overhead[1]["layer"])
+	}
+	if amplification := overhead[0]["write_amplification"].(float64); amplification >= 0.5 {
+		t.Errorf("expected zeros to compress, got a write amplification of %g", amplification)
+	}
+	if amplification := overhead[1]["write_amplification"].(float64); amplification != 1 {
+		t.Errorf("expected the delay to pass bytes through, got a write amplification of %g", amplification)
+	}
+	if latency := overhead[1]["write_latency_ns"].(float64); latency < float64(time.Millisecond) {
+		t.Errorf("expected the delay to add at least 1ms per write, got %gns", latency)
+	}
+}
+
+func TestPaddingDecorator(t *testing.T) {
+	writerConn, readerConn := net.Pipe()
+
+	wire, padding := &MeterDecorator{}, &PaddingDecorator{BlockSize: 512, DummyRate: 1000}
+	decoratedWriterConn, err := DecorateConn(writerConn, wire, padding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoratedWriterConn.Close()
+	decoratedReaderConn, err := DecorateConn(readerConn, &PaddingDecorator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoratedReaderConn.Close()
+
+	writer := &IntervalBenchmark{MessageSize: 100, TotalMessages: 50, Interval: time.Millisecond}
+	reader := &IntervalBenchmark{MessageSize: 100, TotalMessages: 50, Interval: time.Millisecond}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := writer.Writer(decoratedWriterConn); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(decoratedReaderConn); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if reads := reader.Result()["successful_reads"]; reads != uint64(50) {
+		t.Errorf("expected 50 messages read through the padding, got %v", reads)
+	}
+
+	// each message is padded to a block, the handshake to several
+	stats := padding.Stats()
+	if padded := stats["padding_bytes"].(uint64); padded < 50*(512-100) {
+		t.Errorf("expected each message to be padded to 512 bytes, got %d bytes of padding", padded)
+	}
+	if dummies := stats["dummy_records"].(uint64); dummies == 0 {
+		t.Error("expected dummy records to be sent")
+	}
+	if wireBytes := wire.Stats()["bytes_written"].(uint64); wireBytes%512 != 0 {
+		t.Errorf("expected only whole blocks on the wire, got %d bytes", wireBytes)
+	}
+}
+
+func TestShapeDecorator(t *testing.T) {
+	writerConn, readerConn := net.Pipe()
+	defer readerConn.Close()
+
+	shape := &ShapeDecorator{Delay: 50 * time.Millisecond, BitsPerSecond: 8e6}
+	decoratedWriterConn, err := DecorateConn(writerConn, shape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoratedWriterConn.Close()
+
+	// 50KB pass the 8Mbps bottleneck in 50ms, then take 50ms to arrive
+	msg := make([]byte, 1000)
+	start := time.Now()
+	go func() {
+		for i := 0; i < 50; i++ {
+			if _, err := decoratedWriterConn.Write(msg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if written := time.Since(start); written < 45*time.Millisecond || written > 500*time.Millisecond {
+			t.Errorf("expected the writes to be paced by the bottleneck but not the delay, took %s", written)
+		}
+	}()
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(readerConn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if first := time.Since(start); first < 50*time.Millisecond {
+		t.Errorf("expected the first write to arrive after the delay, took %s", first)
+	}
+	for i := 1; i < 50; i++ {
+		if _, err := io.ReadFull(readerConn, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last := time.Since(start); last < 100*time.Millisecond {
+		t.Errorf("expected the last write to arrive after the bottleneck and the delay, took %s", last)
+	}
+
+	preset, err := ShapePreset("satellite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preset.String() != "shape:satellite" || preset.Delay != 300*time.Millisecond {
+		t.Errorf("unexpected satellite preset %s with delay %s", preset, preset.Delay)
+	}
+	if _, err := ShapePreset("dialup"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+}
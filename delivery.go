@@ -0,0 +1,157 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	deliveryWindow      = 1 << 16     // sequence numbers tracked behind the highest one received
+	datagramEndMarkers  = 3           // end markers sent over datagram connections, since any may be lost
+	datagramIdleTimeout = time.Second // how long the reader waits for the next datagram before giving up on the rest
+	maxDatagramSize     = 1<<16 - 1   // the largest UDP payload
+)
+
+// isDatagramConn reports whether conn is a datagram connection, e.g., a
+// connected *net.UDPConn, over which messages may be lost, duplicated or
+// reordered.
+func isDatagramConn(conn net.Conn) bool {
+	switch c := underlyingConn(conn).(type) {
+	case *net.UnixConn:
+		// unix sockets implement net.PacketConn whatever their type, e.g.,
+		// a stream socket passed over the C API
+		addr := c.LocalAddr()
+		return addr != nil && addr.Network() == "unixgram"
+	case net.PacketConn:
+		return true
+	}
+	return false
+}
+
+// validateDatagram checks that messages can be sent as datagrams: one
+// datagram each, carrying a sequence number, and large enough for the end
+// marker.
+func validateDatagram(messageSize int, sizes *SizeDistribution) error {
+	if sizes != nil {
+		return errors.New("size distributions are not supported over datagram connections")
+	}
+	if messageSize < minEndMarkerSize {
+		return errors.New("datagram connections require a message size of at least 16 bytes")
+	}
+	return nil
+}
+
+// deliveryTracker tracks the delivery of each sequence number over a
+// datagram connection, counting the messages duplicated or reordered with
+// a bitmap of the sequence numbers received within deliveryWindow of the
+// highest one.
+type deliveryTracker struct {
+	mutex    sync.Mutex
+	received []uint64 // bitmap by sequence number modulo deliveryWindow
+	highest  uint64   // the highest sequence number received so far
+	any      bool     // whether any message was received so far
+
+	unique     uint64 // messages received for the first time
+	duplicates uint64 // messages received again
+	reordered  uint64 // messages received after a later one
+	late       uint64 // messages too far behind to tell apart from duplicates
+	distances  *Histogram
+}
+
+// newDeliveryTracker returns a tracker with a histogram of the reorder
+// distances sized for profile.
+func newDeliveryTracker(profile Profile) *deliveryTracker {
+	t := &deliveryTracker{received: make([]uint64, deliveryWindow/64)}
+	if profile == ProfileConstrained {
+		t.distances = newConstrainedLatencyHistogram()
+	} else {
+		t.distances = newDefaultLatencyHistogram()
+	}
+	return t
+}
+
+// record records the delivery of the message with the sequence number
+// stamped at the start of payload.
+func (t *deliveryTracker) record(payload []byte) {
+	seq := binary.BigEndian.Uint64(payload[:8])
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch {
+	case !t.any || seq > t.highest:
+		// forget the sequence numbers sliding out of the window
+		if t.any {
+			for s := t.highest + 1; s < seq && s-t.highest <= deliveryWindow; s++ {
+				t.clear(s)
+			}
+		}
+		t.highest, t.any = seq, true
+	case t.highest-seq >= deliveryWindow:
+		t.late++
+		return
+	case t.isSet(seq):
+		t.duplicates++
+		return
+	default:
+		// the reorder distance is how many sequence numbers later the
+		// latest message received was
+		t.reordered++
+		t.distances.Record(int64(t.highest - seq))
+	}
+	t.set(seq)
+	t.unique++
+}
+
+func (t *deliveryTracker) set(seq uint64) {
+	idx := seq % deliveryWindow
+	t.received[idx/64] |= 1 << (idx % 64)
+}
+
+func (t *deliveryTracker) clear(seq uint64) {
+	idx := seq % deliveryWindow
+	t.received[idx/64] &^= 1 << (idx % 64)
+}
+
+func (t *deliveryTracker) isSet(seq uint64) bool {
+	idx := seq % deliveryWindow
+	return t.received[idx/64]&(1<<(idx%64)) != 0
+}
+
+// addResult adds the delivery of the messages to result: the messages
+// expected, i.e., sent, or up to the highest sequence number received if
+// unknown, those received, lost, duplicated, reordered and received too
+// late to be told apart from duplicates, along with the loss in percent
+// and the distribution of the reorder distance as
+// delivery_reorder_distance_<stat>.
+func (t *deliveryTracker) addResult(result map[string]any, expected uint64, profile Profile) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.any && expected <= t.highest {
+		expected = t.highest + 1
+	}
+	lost := expected - min(expected, t.unique+t.late)
+
+	result["delivery_expected"] = expected
+	result["delivery_received"] = t.unique
+	result["delivery_lost"] = lost
+	result["delivery_duplicates"] = t.duplicates
+	result["delivery_reordered"] = t.reordered
+	result["delivery_late"] = t.late
+	if expected > 0 {
+		if profile == ProfileConstrained { // integer-only stats
+			result["delivery_loss_percent"] = lost * 100 / expected
+		} else {
+			result["delivery_loss_percent"] = float64(lost) * 100 / float64(expected)
+		}
+	}
+	if t.distances.TotalCount() > 0 {
+		for name, value := range t.distances.Percentiles() {
+			result["delivery_reorder_distance_"+name] = value
+		}
+	}
+}
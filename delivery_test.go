@@ -0,0 +1,122 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// impairedUDPConn drops, duplicates and reorders every tenth message of
+// size written, leaving the handshake untouched. It embeds *net.UDPConn
+// to remain a datagram connection.
+type impairedUDPConn struct {
+	*net.UDPConn
+	size    int
+	written int
+	held    []byte
+}
+
+func (c *impairedUDPConn) Write(p []byte) (int, error) {
+	if len(p) != c.size {
+		return c.UDPConn.Write(p)
+	}
+	n := c.written
+	c.written++
+
+	switch n % 10 {
+	case 3: // lost
+		return len(p), nil
+	case 5: // duplicated
+		if _, err := c.UDPConn.Write(p); err != nil {
+			return 0, err
+		}
+	case 7: // sent after the next message
+		c.held = append([]byte(nil), p...)
+		return len(p), nil
+	}
+	if _, err := c.UDPConn.Write(p); err != nil {
+		return 0, err
+	}
+	if c.held != nil {
+		held := c.held
+		c.held = nil
+		if _, err := c.UDPConn.Write(held); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func TestPressuredBenchmarkDatagram(t *testing.T) {
+	const totalMessages = 1000
+
+	// pick a port for the reader, then connect both ends to each other
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readerAddr := listener.LocalAddr().(*net.UDPAddr)
+	listener.Close()
+
+	writerConn, err := net.DialUDP("udp", nil, readerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writerConn.Close()
+	readerConn, err := net.DialUDP("udp", readerAddr, writerConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readerConn.Close()
+
+	// paced, so the socket buffers do not drop messages on their own
+	newPressured := func() *PressuredBenchmark {
+		return &PressuredBenchmark{MessageSize: 64, TotalMessages: totalMessages, TargetBandwidth: 1 << 20}
+	}
+	writer, reader := newPressured(), newPressured()
+
+	var readerErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readerErr = reader.Reader(readerConn)
+	}()
+	if err := writer.Writer(&impairedUDPConn{UDPConn: writerConn, size: 64}); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if readerErr != nil {
+		t.Fatal(readerErr)
+	}
+
+	result := reader.Result()
+	t.Logf("Reader: %v", result)
+
+	if expected := result["delivery_expected"]; expected != uint64(totalMessages) {
+		t.Errorf("expected %d messages to be expected, got %v", totalMessages, expected)
+	}
+	if lost := result["delivery_lost"]; lost != uint64(totalMessages/10) {
+		t.Errorf("expected every tenth message to be lost, got %v", lost)
+	}
+	if loss := result["delivery_loss_percent"]; loss != float64(10) {
+		t.Errorf("expected a loss of 10%%, got %v", loss)
+	}
+	if duplicates := result["delivery_duplicates"]; duplicates != uint64(totalMessages/10) {
+		t.Errorf("expected every tenth message to be duplicated, got %v", duplicates)
+	}
+	if reordered := result["delivery_reordered"]; reordered != uint64(totalMessages/10) {
+		t.Errorf("expected every tenth message to be reordered, got %v", reordered)
+	}
+	if distance := result["delivery_reorder_distance_max"]; distance != int64(1) {
+		t.Errorf("expected a reorder distance of 1, got %v", distance)
+	}
+
+	// datagrams cannot carry length-prefixed messages
+	sized := &PressuredBenchmark{MessageSize: 64, TotalMessages: 1, SizeDistribution: UniformSize(16, 64)}
+	if err := sized.Writer(writerConn); err == nil {
+		t.Error("expected a size distribution over a datagram connection to be rejected")
+	}
+}
@@ -0,0 +1,12 @@
+// Package benchmarkconn benchmarks the throughput and latency of net.Conn
+// implementations.
+//
+// The core library builds for wasip1 and js (GOARCH=wasm) in addition to
+// native targets, so conns implemented inside WASM runtimes can
+// self-benchmark using the same code paths as native runs. Features which
+// depend on deadlines degrade gracefully when the conn does not support
+// them, and OS-specific counters (e.g., TCP_INFO) report an error on
+// unsupported platforms instead of failing to build. Transports which only
+// implement io.ReadWriteCloser, e.g., SSH channels or pipes, can be
+// benchmarked through ReadWriteCloserConn.
+package benchmarkconn
@@ -0,0 +1,127 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// echoSequenceSize is the size of the sequence number carried by each
+// message when echoes are matched with an echo window.
+const echoSequenceSize = 8
+
+// validateEchoWindow checks that echoes can be matched by the sequence
+// number at the start of every message.
+func validateEchoWindow(messageSize int, sizes *SizeDistribution, echo bool, window uint64) error {
+	if window == 0 {
+		return nil
+	}
+	if !echo {
+		return errors.New("echo window requires echo")
+	}
+	if minMessageSize(messageSize, sizes) < echoSequenceSize {
+		return errors.New("echo window requires a message size of at least 8 bytes")
+	}
+	return nil
+}
+
+// echoWindow matches echoes by their sequence number against a sliding
+// window of the latest sent messages, so that echoes reordered or delayed
+// by the transport are still matched as long as no more messages than the
+// window holds were sent after them. The oldest message still outstanding
+// when it slides out of the window is given up as lost.
+type echoWindow struct {
+	mutex       sync.Mutex
+	outstanding []bool // by sequence number modulo the window
+	next        uint64 // the sequence number of the next message sent
+	highest     uint64 // the highest sequence number echoed so far
+	echoed      bool   // whether any message was echoed so far
+
+	lost       uint64 // messages slid out of the window without an echo
+	late       uint64 // echoes of messages already given up as lost
+	duplicates uint64 // echoes of messages already echoed
+	reordered  uint64 // echoes arriving after that of a later message
+	depths     *Histogram
+}
+
+func newEchoWindow(size uint64) *echoWindow {
+	return &echoWindow{
+		outstanding: make([]bool, size),
+		depths:      newDefaultLatencyHistogram(),
+	}
+}
+
+// push records the message with the next sequence number as outstanding,
+// giving up on the message sliding out of the window if it still is.
+func (w *echoWindow) push(seq uint64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	idx := seq % uint64(len(w.outstanding))
+	if w.outstanding[idx] {
+		w.lost++
+	}
+	w.outstanding[idx] = true
+	w.next = seq + 1
+}
+
+// match reports whether the message with the sequence number stamped on
+// the echoed payload was outstanding, and no longer is.
+func (w *echoWindow) match(payload []byte) bool {
+	seq := binary.BigEndian.Uint64(payload[:echoSequenceSize])
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	switch {
+	case seq >= w.next:
+		return false // never sent, e.g., corrupted
+	case w.next-seq > uint64(len(w.outstanding)):
+		w.late++
+		return false
+	}
+	idx := seq % uint64(len(w.outstanding))
+	if !w.outstanding[idx] {
+		w.duplicates++
+		return false
+	}
+	w.outstanding[idx] = false
+
+	// the reorder depth is how many messages sent after this one were
+	// echoed before it, at most
+	if w.echoed && seq < w.highest {
+		w.reordered++
+		w.depths.Record(int64(w.highest - seq))
+	} else {
+		w.highest = seq
+		w.echoed = true
+	}
+	return true
+}
+
+// addResult adds the echoes lost, late, duplicated and reordered to
+// result, along with the distribution of the reorder depth as
+// echo_reorder_depth_<stat>.
+func (w *echoWindow) addResult(result map[string]any) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// messages still outstanding at the end are lost as well
+	lost := w.lost
+	for _, outstanding := range w.outstanding {
+		if outstanding {
+			lost++
+		}
+	}
+
+	result["echo_window"] = uint64(len(w.outstanding))
+	result["echo_lost"] = lost
+	result["echo_late"] = w.late
+	result["echo_duplicates"] = w.duplicates
+	result["echo_reordered"] = w.reordered
+	if w.depths.TotalCount() > 0 {
+		for name, value := range w.depths.Percentiles() {
+			result["echo_reorder_depth_"+name] = value
+		}
+	}
+}
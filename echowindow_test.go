@@ -0,0 +1,104 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// swappingConn swaps every pair of messages of size written, reordering
+// echoes by a depth of one while leaving the handshake untouched.
+type swappingConn struct {
+	net.Conn
+	size int
+	held []byte
+}
+
+func (c *swappingConn) Write(p []byte) (int, error) {
+	if len(p) != c.size {
+		return c.Conn.Write(p)
+	}
+	if c.held == nil {
+		c.held = append([]byte(nil), p...)
+		return len(p), nil
+	}
+	if _, err := c.Conn.Write(p); err != nil {
+		return 0, err
+	}
+	_, err := c.Conn.Write(c.held)
+	c.held = nil
+	return len(p), err
+}
+
+func TestIntervalBenchmarkEchoWindow(t *testing.T) {
+	const totalMessages = 200
+
+	writer := &IntervalBenchmark{
+		MessageSize:   64,
+		TotalMessages: totalMessages,
+		Interval:      100 * time.Microsecond,
+		Echo:          true,
+		EchoWindow:    16,
+	}
+	reader := &IntervalBenchmark{
+		MessageSize:   64,
+		TotalMessages: totalMessages,
+		Interval:      100 * time.Microsecond,
+		Echo:          true,
+	}
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	writerConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writerConn.Close()
+	readerConn, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readerConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(&swappingConn{Conn: readerConn, size: 64}); err != nil {
+			t.Logf("Reader errored: %v", err)
+		}
+	}()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	result := writer.Result()
+	t.Logf("Writer: %v", result)
+
+	if reordered := result["echo_reordered"].(uint64); reordered != totalMessages/2 {
+		t.Errorf("expected every other echo to be reordered, got %d", reordered)
+	}
+	if depth := result["echo_reorder_depth_max"].(int64); depth != 1 {
+		t.Errorf("expected a reorder depth of 1, got %d", depth)
+	}
+	if lost := result["echo_lost"].(uint64); lost != 0 {
+		t.Errorf("expected no echo to be lost, got %d", lost)
+	}
+	if _, ok := result["latency_ns"]; !ok {
+		t.Error("expected the latency of the reordered echoes")
+	}
+
+	// without echo, there is nothing to match
+	noEcho := &IntervalBenchmark{MessageSize: 64, TotalMessages: 1, Interval: time.Millisecond, EchoWindow: 16}
+	if err := noEcho.Writer(writerConn); err == nil {
+		t.Error("expected an echo window without echo to be rejected")
+	}
+}
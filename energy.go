@@ -0,0 +1,245 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWattsPerCore is the power drawn by a fully busy core assumed by
+// EnergyModel, roughly the TDP of a server CPU shared by its cores.
+const DefaultWattsPerCore = 10.0
+
+// EnergySource selects how an EnergyMeter measures the energy of a run.
+type EnergySource uint8
+
+const (
+	// EnergyAuto selects EnergyRAPL if the RAPL counters are readable,
+	// otherwise EnergyModel.
+	EnergyAuto EnergySource = iota
+
+	// EnergyRAPL reads the energy counters of the CPU packages exposed by
+	// RAPL in sysfs, e.g., on Intel and AMD CPUs under Linux. They count
+	// the energy of the whole packages, including other processes.
+	EnergyRAPL
+
+	// EnergyModel estimates the energy from the CPU time of the process
+	// and a power drawn per busy core, so it only accounts for the
+	// process, but is as accurate as the power assumed.
+	EnergyModel
+)
+
+// ParseEnergySource parses the name of an energy source.
+func ParseEnergySource(name string) (EnergySource, error) {
+	switch name {
+	case "", "auto":
+		return EnergyAuto, nil
+	case "rapl":
+		return EnergyRAPL, nil
+	case "model":
+		return EnergyModel, nil
+	default:
+		return EnergyAuto, errors.New("unknown energy source, must be either \"auto\", \"rapl\" or \"model\"")
+	}
+}
+
+func (s EnergySource) String() string {
+	switch s {
+	case EnergyRAPL:
+		return "rapl"
+	case EnergyModel:
+		return "model"
+	default:
+		return "auto"
+	}
+}
+
+// EnergyMeter estimates the energy consumed over a run, between Start and
+// Stop, so transports can be compared by the energy they take per
+// gigabyte transferred rather than by their throughput alone.
+type EnergyMeter struct {
+	source       EnergySource // resolved, either EnergyRAPL or EnergyModel
+	wattsPerCore float64
+	sysfs        fs.FS
+	raplFiles    []string // energy_uj of each package
+	raplRanges   []uint64 // max_energy_range_uj of each package, at which its counter wraps around
+
+	mutex       sync.Mutex // protects the fields below
+	startTime   time.Time
+	endTime     time.Time
+	startCPU    cpuTime
+	endCPU      cpuTime
+	startEnergy []uint64
+	endEnergy   []uint64
+	started     bool
+	running     bool
+}
+
+// NewEnergyMeter creates an EnergyMeter measuring with source, reading
+// RAPL from sysfs, the root of the sysfs tree, or /sys if nil. The model
+// assumes wattsPerCore for each core kept busy by the process,
+// DefaultWattsPerCore if not positive.
+//
+// It fails if RAPL is selected but not readable, e.g., without root on
+// recent kernels or in virtual machines, or if the model is selected but
+// the CPU time of the process cannot be read, i.e., on platforms other
+// than Linux.
+func NewEnergyMeter(source EnergySource, wattsPerCore float64, sysfs fs.FS) (*EnergyMeter, error) {
+	if sysfs == nil {
+		sysfs = os.DirFS("/sys")
+	}
+	if wattsPerCore <= 0 {
+		wattsPerCore = DefaultWattsPerCore
+	}
+
+	m := &EnergyMeter{wattsPerCore: wattsPerCore, sysfs: sysfs}
+	var raplErr error
+	if source != EnergyModel {
+		if raplErr = m.findRAPL(); raplErr == nil {
+			m.source = EnergyRAPL
+			return m, nil
+		}
+		if source == EnergyRAPL {
+			return nil, raplErr
+		}
+	}
+	if !processCPUTime().ok {
+		if raplErr != nil {
+			return nil, fmt.Errorf("energy model requires the CPU time of the process, only supported on Linux, and %w", raplErr)
+		}
+		return nil, errors.New("energy model requires the CPU time of the process, only supported on Linux")
+	}
+	m.source = EnergyModel
+	return m, nil
+}
+
+// findRAPL finds the readable energy counters of the top-level RAPL
+// domains, i.e., the packages, leaving out their subdomains, e.g., the
+// cores and the DRAM of intel-rapl:0:0, which the packages include.
+func (m *EnergyMeter) findRAPL() error {
+	dirs, _ := fs.Glob(m.sysfs, "class/powercap/intel-rapl:*")
+	for _, dir := range dirs {
+		if strings.Count(path.Base(dir), ":") != 1 {
+			continue
+		}
+		name := dir + "/energy_uj"
+		if _, ok := m.readUint(name); !ok {
+			return fmt.Errorf("RAPL energy counter %s is not readable", name)
+		}
+		maxRange, _ := m.readUint(dir + "/max_energy_range_uj")
+		m.raplFiles = append(m.raplFiles, name)
+		m.raplRanges = append(m.raplRanges, maxRange)
+	}
+	if len(m.raplFiles) == 0 {
+		return errors.New("RAPL energy counters require powercap in sysfs")
+	}
+	return nil
+}
+
+func (m *EnergyMeter) readUint(name string) (uint64, bool) {
+	data, err := fs.ReadFile(m.sysfs, name)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return value, err == nil
+}
+
+// readEnergy reads the energy counter of each package in microjoules.
+func (m *EnergyMeter) readEnergy() []uint64 {
+	if m.source != EnergyRAPL {
+		return nil
+	}
+	energy := make([]uint64, len(m.raplFiles))
+	for i, name := range m.raplFiles {
+		energy[i], _ = m.readUint(name)
+	}
+	return energy
+}
+
+// Source returns the source the meter measures with, either EnergyRAPL or
+// EnergyModel.
+func (m *EnergyMeter) Source() EnergySource {
+	return m.source
+}
+
+// Start starts measuring, discarding any previous measurement.
+func (m *EnergyMeter) Start() {
+	cpu, energy := processCPUTime(), m.readEnergy()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.startTime, m.startCPU, m.startEnergy = time.Now(), cpu, energy
+	m.started, m.running = true, true
+}
+
+// Stop stops measuring.
+func (m *EnergyMeter) Stop() {
+	cpu, energy := processCPUTime(), m.readEnergy()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.running {
+		return
+	}
+	m.endTime, m.endCPU, m.endEnergy = time.Now(), cpu, energy
+	m.running = false
+}
+
+// joules returns the energy consumed between Start and Stop.
+func (m *EnergyMeter) joules() float64 {
+	if m.source == EnergyModel {
+		busy := (m.endCPU.user - m.startCPU.user) + (m.endCPU.system - m.startCPU.system)
+		return busy.Seconds() * m.wattsPerCore
+	}
+	var microjoules uint64
+	for i := range m.raplFiles {
+		start, end := m.startEnergy[i], m.endEnergy[i]
+		if end < start { // wrapped around
+			end += m.raplRanges[i]
+		}
+		if end >= start { // unless the range is unknown
+			microjoules += end - start
+		}
+	}
+	return float64(microjoules) / 1e6
+}
+
+// Annotate adds the energy consumed over the run to result: the source as
+// energy_source, the energy as energy_joules, the mean power as
+// energy_watts and, over the bytes_read and bytes_written of result, the
+// energy per gigabyte transferred as energy_joules_per_gb. The CPU time
+// of the process is added as cpu_time_ns where readable, and the power
+// assumed by the model as energy_watts_per_core. It adds nothing unless
+// the meter was started and stopped.
+func (m *EnergyMeter) Annotate(result map[string]any) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.started || m.running {
+		return
+	}
+
+	joules := m.joules()
+	result["energy_source"] = m.source.String()
+	result["energy_joules"] = joules
+	if duration := m.endTime.Sub(m.startTime); duration > 0 {
+		result["energy_watts"] = joules / duration.Seconds()
+	}
+	bytesRead, _ := result["bytes_read"].(uint64)
+	bytesWritten, _ := result["bytes_written"].(uint64)
+	if transferred := bytesRead + bytesWritten; transferred > 0 {
+		result["energy_joules_per_gb"] = joules / (float64(transferred) / 1e9)
+	}
+	if m.startCPU.ok && m.endCPU.ok {
+		result["cpu_time_ns"] = ((m.endCPU.user - m.startCPU.user) + (m.endCPU.system - m.startCPU.system)).Nanoseconds()
+	}
+	if m.source == EnergyModel {
+		result["energy_watts_per_core"] = m.wattsPerCore
+	}
+}
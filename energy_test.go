@@ -0,0 +1,84 @@
+package benchmarkconn_test
+
+import (
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestEnergyMeterRAPL(t *testing.T) {
+	if _, err := NewEnergyMeter(EnergyRAPL, 0, fstest.MapFS{}); err == nil {
+		t.Error("expected a sysfs without RAPL to be rejected")
+	}
+
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data + "\n")} }
+	sysfs := fstest.MapFS{
+		"class/powercap/intel-rapl:0/energy_uj":             file("1000000"),
+		"class/powercap/intel-rapl:0/max_energy_range_uj":   file("10000000"),
+		"class/powercap/intel-rapl:1/energy_uj":             file("9500000"),
+		"class/powercap/intel-rapl:1/max_energy_range_uj":   file("10000000"),
+		"class/powercap/intel-rapl:0:0/energy_uj":           file("500000"),
+		"class/powercap/intel-rapl:0:0/max_energy_range_uj": file("10000000"),
+	}
+	meter, err := NewEnergyMeter(EnergyAuto, 0, sysfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meter.Source() != EnergyRAPL {
+		t.Fatalf("expected RAPL to be selected, got %v", meter.Source())
+	}
+
+	meter.Start()
+	// the second package wraps around, the subdomain is part of the first
+	sysfs["class/powercap/intel-rapl:0/energy_uj"] = file("3000000")
+	sysfs["class/powercap/intel-rapl:1/energy_uj"] = file("500000")
+	sysfs["class/powercap/intel-rapl:0:0/energy_uj"] = file("2500000")
+	meter.Stop()
+
+	result := map[string]any{"bytes_read": uint64(0), "bytes_written": uint64(2e9)}
+	meter.Annotate(result)
+	if result["energy_source"] != "rapl" || result["energy_joules"] != float64(3) {
+		t.Errorf("expected 3J from both packages, got %v from %v", result["energy_joules"], result["energy_source"])
+	}
+	if result["energy_joules_per_gb"] != 1.5 {
+		t.Errorf("expected 1.5J per GB, got %v", result["energy_joules_per_gb"])
+	}
+}
+
+func TestEnergyMeterModel(t *testing.T) {
+	meter, err := NewEnergyMeter(EnergyModel, 20, nil)
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Error("expected the model to require the CPU time of the process")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(map[string]any)
+	meter.Annotate(result)
+	if len(result) != 0 {
+		t.Errorf("expected nothing before the meter was started and stopped, got %v", result)
+	}
+
+	meter.Start()
+	for i := 0; i < 1e7; i++ { // keep a core busy
+		_ = i * i
+	}
+	meter.Stop()
+	meter.Annotate(result)
+	cpu, ok := result["cpu_time_ns"].(int64)
+	if !ok || result["energy_source"] != "model" || result["energy_watts_per_core"] != float64(20) {
+		t.Fatalf("expected the model to be annotated, got %v", result)
+	}
+	if joules := result["energy_joules"].(float64); joules != float64(cpu)/1e9*20 {
+		t.Errorf("expected %vns of CPU time at 20W, got %vJ", cpu, joules)
+	}
+	if _, ok := result["energy_joules_per_gb"]; ok {
+		t.Errorf("expected no energy per GB without bytes transferred, got %v", result["energy_joules_per_gb"])
+	}
+}
@@ -0,0 +1,116 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// BandwidthEstimate is a quick capacity probe run before a benchmark: the
+// writer sends a short pressure burst for Duration and the reader reports
+// back the rate it measured, so both sides agree on the same estimate.
+//
+// The estimate is used to sanity-check the benchmark spec, e.g., to warn
+// that 1M messages of 64KB will take hours at the measured rate, or to
+// scale TotalMessages to a target duration.
+type BandwidthEstimate struct {
+	MessageSize int           `json:"message_size" yaml:"message_size"` // MessageSize defines how many bytes to write for each message of the burst
+	Duration    time.Duration `json:"duration" yaml:"duration"`         // Duration defines how long the burst lasts
+
+	bytesPerSecond float64
+}
+
+func (e *BandwidthEstimate) validate() error {
+	if e.Duration <= 0 {
+		return errors.New("bandwidth estimate requires a positive duration")
+	}
+	return validateWarmup(e.MessageSize, e.Duration)
+}
+
+func (e *BandwidthEstimate) Writer(conn net.Conn) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	// Compare estimate specs on both sides
+	if _, err := writerHandshake(conn, e); err != nil {
+		return err
+	}
+
+	// the burst is terminated by the warmup marker, exactly like a
+	// duration-based warmup
+	if err := sendWarmup(conn, e.MessageSize, 0, e.Duration, 0, false); err != nil {
+		return err
+	}
+
+	var rate [8]byte
+	if _, err := io.ReadFull(conn, rate[:]); err != nil {
+		return err
+	}
+	e.bytesPerSecond = math.Float64frombits(binary.BigEndian.Uint64(rate[:]))
+
+	return nil
+}
+
+func (e *BandwidthEstimate) Reader(conn net.Conn) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	// Compare estimate specs on both sides
+	if _, err := readerHandshake(conn, e); err != nil {
+		return err
+	}
+
+	marker := warmupMarker(e.MessageSize)
+	receivedMsg := make([]byte, e.MessageSize)
+	start := time.Now()
+	var received uint64
+	for {
+		if _, err := io.ReadFull(conn, receivedMsg); err != nil {
+			return err
+		}
+		received += uint64(e.MessageSize)
+		if bytes.Equal(receivedMsg, marker) {
+			break
+		}
+	}
+	e.bytesPerSecond = float64(received) / time.Since(start).Seconds()
+
+	var rate [8]byte
+	binary.BigEndian.PutUint64(rate[:], math.Float64bits(e.bytesPerSecond))
+	if _, err := conn.Write(rate[:]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// BytesPerSecond returns the estimated bandwidth, as measured by the
+// reader, or 0 if the estimate has not been run.
+func (e *BandwidthEstimate) BytesPerSecond() float64 {
+	return e.bytesPerSecond
+}
+
+// DurationFor returns how long sending totalMessages messages of
+// messageSize bytes is expected to take at the estimated bandwidth.
+func (e *BandwidthEstimate) DurationFor(messageSize int, totalMessages uint64) time.Duration {
+	if e.bytesPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(float64(messageSize) * float64(totalMessages) / e.bytesPerSecond * float64(time.Second))
+}
+
+// MessagesFor returns how many messages of messageSize bytes are expected
+// to be sent within target at the estimated bandwidth, at least 1.
+func (e *BandwidthEstimate) MessagesFor(messageSize int, target time.Duration) uint64 {
+	messages := uint64(e.bytesPerSecond * target.Seconds() / float64(messageSize))
+	if messages < 1 {
+		return 1
+	}
+	return messages
+}
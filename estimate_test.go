@@ -0,0 +1,45 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestBandwidthEstimate(t *testing.T) {
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	writer := &BandwidthEstimate{MessageSize: 1024, Duration: 50 * time.Millisecond}
+	reader := &BandwidthEstimate{MessageSize: 1024, Duration: 50 * time.Millisecond}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(readerConn); err != nil {
+			t.Errorf("Reader: %v", err)
+		}
+	}()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatalf("Writer: %v", err)
+	}
+	wg.Wait()
+
+	if writer.BytesPerSecond() <= 0 || writer.BytesPerSecond() != reader.BytesPerSecond() {
+		t.Fatalf("estimates differ: writer %f, reader %f", writer.BytesPerSecond(), reader.BytesPerSecond())
+	}
+
+	messages := writer.MessagesFor(1024, time.Second)
+	if got := writer.DurationFor(1024, messages); got < 990*time.Millisecond || got > time.Second {
+		t.Errorf("DurationFor(MessagesFor(1s)): got %s", got)
+	}
+
+	if err := (&BandwidthEstimate{MessageSize: 8, Duration: time.Second}).Writer(writerConn); err == nil {
+		t.Errorf("expected error for a message size too small for the marker")
+	}
+}
@@ -0,0 +1,202 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Roles of the peers of a benchmark, exchanged during the handshake so
+// that two writers or two readers fail immediately instead of waiting for
+// each other until they time out.
+const (
+	roleWriter = "writer"
+	roleReader = "reader"
+)
+
+// handshakeMessage is sent by both peers during the handshake.
+type handshakeMessage struct {
+	Benchmark string          `json:"benchmark"`
+	Role      string          `json:"role"`
+	Spec      json.RawMessage `json:"spec"`
+	Version   string          `json:"version,omitempty"` // Version is informational and may differ between the peers
+}
+
+func newHandshakeMessage(role string, spec any) (*handshakeMessage, error) {
+	specJson, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handshakeMessage{
+		Benchmark: benchmarkType(spec),
+		Role:      role,
+		Spec:      specJson,
+		Version:   Version(),
+	}, nil
+}
+
+// check checks that the peer runs the same type of benchmark with the
+// very same spec, taking the opposite role.
+func (m *handshakeMessage) check(peer *handshakeMessage) error {
+	if peer.Benchmark != m.Benchmark {
+		return fmt.Errorf("benchmark type mismatch, %s here but %s at the peer, aborting", m.Benchmark, peer.Benchmark)
+	}
+
+	if peer.Role == m.Role {
+		return fmt.Errorf("peer is also a %s, one side must write and the other read, aborting", m.Role)
+	}
+
+	if !bytes.Equal(m.Spec, peer.Spec) {
+		return errors.New("benchmark specs do not match, aborting")
+	}
+
+	return nil
+}
+
+// benchmarkType identifies the type of benchmark of spec, named after the
+// types accepted by cmd/client and cmd/server where applicable.
+func benchmarkType(spec any) string {
+	switch spec := spec.(type) {
+	case *PressuredBenchmark:
+		return "pressure"
+	case *IntervalBenchmark:
+		if spec.Echo {
+			return "echo"
+		}
+		return "interval"
+	case *BidirectionalBenchmark:
+		return "bidir"
+	case *RampBenchmark:
+		return "ramp"
+	case *CreditBenchmark:
+		return "credit"
+	case *TinyWriteProbe:
+		return "tinywrite"
+	case *DeadPeerBenchmark:
+		return "deadpeer"
+	case *HandshakeBenchmark:
+		return "handshake"
+	case *ChurnBenchmark:
+		return "churn"
+	case *BandwidthEstimate:
+		return "estimate"
+	default:
+		return fmt.Sprintf("%T", spec)
+	}
+}
+
+// writerHandshake sends the JSON-encoded spec to the peer and expects the
+// very same spec from a reader. It returns the handshake message of the
+// peer.
+func writerHandshake(conn net.Conn, spec any) (*handshakeMessage, error) {
+	return handshake(conn, roleWriter, spec)
+}
+
+// readerHandshake sends the JSON-encoded spec to the peer and expects the
+// very same spec from a writer. It returns the handshake message of the
+// peer.
+func readerHandshake(conn net.Conn, spec any) (*handshakeMessage, error) {
+	return handshake(conn, roleReader, spec)
+}
+
+// handshake sends the benchmark type, the role, the spec and the version
+// to the peer, then checks those of the peer. Both peers send first, so
+// that neither waits on the other. It returns the handshake message of the
+// peer.
+func handshake(conn net.Conn, role string, spec any) (*handshakeMessage, error) {
+	msg, err := newHandshakeMessage(role, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	msgJson, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// send concurrently, since synchronous connections such as net.Pipe
+	// block the write until the peer reads
+	writeErr := make(chan error, 1)
+	go func() {
+		msgLenWr, err := conn.Write(msgJson)
+		if err == nil && msgLenWr != len(msgJson) {
+			err = errors.New("failed to write the spec to the connection")
+		}
+		writeErr <- err
+	}()
+
+	var received handshakeMessage
+	readErr := readSpec(conn, &received)
+	if err := <-writeErr; err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if err := msg.check(&received); err != nil {
+		return nil, err
+	}
+	return &received, nil
+}
+
+// readSpec reads a single JSON-encoded handshake message from the peer
+// into v. A single Read is not enough since stream-oriented transports
+// such as QUIC may deliver it in multiple parts, while reading past it
+// would consume the first messages of the benchmark.
+func readSpec(conn net.Conn, v any) error {
+	if isDatagramConn(conn) {
+		// a datagram is read whole, a shorter buffer would truncate it
+		buf := make([]byte, maxDatagramSize)
+		n, err := conn.Read(buf)
+		if err == nil {
+			err = json.Unmarshal(buf[:n], v)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the spec from the connection: %w", err)
+		}
+		return nil
+	}
+
+	// the peer starts the benchmark right after the handshake, so
+	// stream-oriented connections are read byte by byte
+	if err := json.NewDecoder(&byteReader{r: conn}).Decode(v); err != nil {
+		return fmt.Errorf("failed to read the spec from the connection: %w", err)
+	}
+
+	return nil
+}
+
+// byteReader reads at most one byte at a time.
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+// writerHandshakeVia performs the writer handshake over control if set,
+// otherwise in-band over conn.
+func writerHandshakeVia(conn net.Conn, control *ControlChannel, spec any) (*handshakeMessage, error) {
+	if control != nil {
+		return control.writerHandshake(spec)
+	}
+	return writerHandshake(conn, spec)
+}
+
+// readerHandshakeVia performs the reader handshake over control if set,
+// otherwise in-band over conn.
+func readerHandshakeVia(conn net.Conn, control *ControlChannel, spec any) (*handshakeMessage, error) {
+	if control != nil {
+		return control.readerHandshake(spec)
+	}
+	return readerHandshake(conn, spec)
+}
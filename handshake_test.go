@@ -0,0 +1,92 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestHandshakeSameRole(t *testing.T) {
+	run := func(t *testing.T, side func(Benchmark, net.Conn, ...Counter) error) {
+		first := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		second := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+
+		firstConn, secondConn := net.Pipe()
+		t.Cleanup(func() {
+			firstConn.Close()
+			secondConn.Close()
+		})
+
+		secondErr := make(chan error, 1)
+		go func() {
+			secondErr <- side(second, secondConn)
+		}()
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- side(first, firstConn)
+		}()
+
+		for _, errs := range []chan error{errs, secondErr} {
+			select {
+			case err := <-errs:
+				if err == nil || !strings.Contains(err.Error(), "peer is also a") {
+					t.Errorf("expected a role mismatch, got %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the handshake to fail fast")
+			}
+		}
+	}
+
+	t.Run("Writers", func(t *testing.T) {
+		run(t, Benchmark.Writer)
+	})
+
+	t.Run("Readers", func(t *testing.T) {
+		run(t, Benchmark.Reader)
+	})
+}
+
+func TestHandshakeTypeMismatch(t *testing.T) {
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+	reader := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 100, Interval: time.Millisecond, Echo: true}
+
+	writerConn, readerConn := net.Pipe()
+	t.Cleanup(func() {
+		writerConn.Close()
+		readerConn.Close()
+	})
+
+	readerErr := make(chan error, 1)
+	go func() {
+		readerErr <- reader.Reader(readerConn)
+	}()
+	writerErr := writer.Writer(writerConn)
+
+	if writerErr == nil || !strings.Contains(writerErr.Error(), "type mismatch, pressure here but echo at the peer") {
+		t.Errorf("expected a type mismatch, got %v", writerErr)
+	}
+	if err := <-readerErr; err == nil || !strings.Contains(err.Error(), "type mismatch, echo here but pressure at the peer") {
+		t.Errorf("expected a type mismatch, got %v", err)
+	}
+}
+
+func TestHandshakeVersion(t *testing.T) {
+	if Version() == "" {
+		t.Fatal("expected a version, unknown at worst")
+	}
+
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+	runOverTCP(t, writer, reader)
+
+	for name, result := range map[string]map[string]any{"writer": writer.Result(), "reader": reader.Result()} {
+		if result["version"] != Version() || result["peer_version"] != Version() {
+			t.Errorf("expected the %s to record the version of both peers, got %v and %v", name, result["version"], result["peer_version"])
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Cookies of the V2 encoding of HdrHistogram, with the word size bits
+// set as by the Java implementation. Decoders ignore those bits.
+const (
+	hdrEncodingCookie           int32 = 0x1c849303 | 0x10
+	hdrCompressedEncodingCookie int32 = 0x1c849304 | 0x10
+	hdrCookieMask               int32 = ^0xf0
+	hdrEncodingHeaderSize             = 40
+)
+
+// Encode encodes the histogram in the V2 compressed encoding of
+// HdrHistogram, as found base64-encoded in HdrHistogram logs, so that it
+// can be read by existing HdrHistogram tooling.
+func (h *Histogram) Encode() ([]byte, error) {
+	h.mutex.Lock()
+	payload := h.encodeCounts()
+	header := make([]byte, hdrEncodingHeaderSize)
+	binary.BigEndian.PutUint32(header[0:], uint32(hdrEncodingCookie))
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[8:], 0) // normalizing index offset
+	binary.BigEndian.PutUint32(header[12:], uint32(h.sigFigs))
+	binary.BigEndian.PutUint64(header[16:], uint64(h.lowest))
+	binary.BigEndian.PutUint64(header[24:], uint64(h.highest))
+	binary.BigEndian.PutUint64(header[32:], math.Float64bits(1)) // integer to double conversion ratio
+	h.mutex.Unlock()
+
+	var compressed bytes.Buffer
+	compressed.Write(make([]byte, 8)) // cookie and length of the compressed contents
+	w, err := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(header)
+	w.Write(payload)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	encoded := compressed.Bytes()
+	binary.BigEndian.PutUint32(encoded[0:], uint32(hdrCompressedEncodingCookie))
+	binary.BigEndian.PutUint32(encoded[4:], uint32(len(encoded)-8))
+	return encoded, nil
+}
+
+// encodeCounts encodes the counts up to the one of the largest value as
+// ZigZag LEB128 integers, runs of zero counts as their negated length.
+func (h *Histogram) encodeCounts() []byte {
+	limit := 1
+	if h.totalCount > 0 {
+		limit = h.countsIndexFor(h.max) + 1
+	}
+
+	var buf []byte
+	for i := 0; i < limit; {
+		count := h.counts[i]
+		i++
+		if count == 0 {
+			zeros := int64(1)
+			for i < limit && h.counts[i] == 0 {
+				zeros++
+				i++
+			}
+			if zeros > 1 {
+				count = -zeros
+			}
+		}
+		buf = appendZigZag(buf, count)
+	}
+	return buf
+}
+
+// DecodeHistogram decodes a histogram in the V2 compressed encoding of
+// HdrHistogram, as returned by Histogram.Encode. Its mean and standard
+// deviation are those of the middle of the value ranges counted.
+func DecodeHistogram(data []byte) (*Histogram, error) {
+	if len(data) < 8 || int32(binary.BigEndian.Uint32(data))&hdrCookieMask != hdrCompressedEncodingCookie&hdrCookieMask {
+		return nil, errors.New("not a compressed HdrHistogram")
+	}
+	length := int(binary.BigEndian.Uint32(data[4:]))
+	if length > len(data)-8 {
+		return nil, errors.New("truncated compressed HdrHistogram")
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data[8 : 8+length]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress HdrHistogram: %w", err)
+	}
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress HdrHistogram: %w", err)
+	}
+
+	if len(decompressed) < hdrEncodingHeaderSize || int32(binary.BigEndian.Uint32(decompressed))&hdrCookieMask != hdrEncodingCookie&hdrCookieMask {
+		return nil, errors.New("unsupported HdrHistogram encoding")
+	}
+	payloadLength := int(binary.BigEndian.Uint32(decompressed[4:]))
+	if binary.BigEndian.Uint32(decompressed[8:]) != 0 {
+		return nil, errors.New("normalized HdrHistograms are not supported")
+	}
+	sigFigs := int(binary.BigEndian.Uint32(decompressed[12:]))
+	lowest := int64(binary.BigEndian.Uint64(decompressed[16:]))
+	highest := int64(binary.BigEndian.Uint64(decompressed[24:]))
+	payload := decompressed[hdrEncodingHeaderSize:]
+	if payloadLength > len(payload) {
+		return nil, errors.New("truncated HdrHistogram")
+	}
+
+	h, err := NewHistogram(lowest, highest, sigFigs)
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[:payloadLength]
+	for i := 0; len(payload) > 0; {
+		var count int64
+		count, payload, err = readZigZag(payload)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			i += int(-count)
+			continue
+		}
+		if i >= len(h.counts) {
+			return nil, errors.New("HdrHistogram counts exceed its highest trackable value")
+		}
+		if count > 0 {
+			value := h.valueFromIndex(i)
+			h.counts[i] = count
+			h.totalCount += count
+			h.sum += float64(h.lowestEquivalentValue(value)+h.sizeOfEquivalentValueRange(value)/2) * float64(count)
+			h.min = min(h.min, value)
+			h.max = max(h.max, h.highestEquivalentValue(value))
+		}
+		i++
+	}
+	return h, nil
+}
+
+// appendZigZag appends value to buf as a ZigZag LEB128 integer of up to 9
+// bytes, the last one holding 8 bits, as encoded by HdrHistogram.
+func appendZigZag(buf []byte, value int64) []byte {
+	v := uint64(value<<1) ^ uint64(value>>63)
+	for i := 0; i < 8; i++ {
+		if v < 0x80 {
+			return append(buf, byte(v))
+		}
+		buf = append(buf, byte(v&0x7f)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+// readZigZag reads a ZigZag LEB128 integer written by appendZigZag from
+// buf, returning the rest of buf.
+func readZigZag(buf []byte) (int64, []byte, error) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(buf) {
+			return 0, nil, errors.New("truncated HdrHistogram counts")
+		}
+		b := buf[i]
+		if i == 8 {
+			v |= uint64(b) << 56
+		} else {
+			v |= uint64(b&0x7f) << (7 * i)
+			if b < 0x80 {
+				return int64(v>>1) ^ -int64(v&1), buf[i+1:], nil
+			}
+		}
+	}
+	return int64(v>>1) ^ -int64(v&1), buf[9:], nil
+}
+
+// HistogramLogWriter writes latency histograms in the HdrHistogram log
+// format, version 1.3, one line per interval, so that they can be plotted
+// or processed with existing HdrHistogram tooling, e.g.,
+// HistogramLogProcessor or hdrhistogram-plotter. Values are recorded in
+// nanoseconds, and the maximum of each interval is written in
+// milliseconds, as expected by the tooling by default.
+//
+// It is safe for concurrent use, e.g., by both latency histograms of a
+// benchmark or by parallel benchmarks, whose intervals may then be
+// written out of order. Writes after the first error are dropped, see
+// Err.
+type HistogramLogWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	start time.Time // the start of the log, zero until the header is written
+	err   error
+}
+
+// NewHistogramLogWriter creates a HistogramLogWriter writing to w.
+func NewHistogramLogWriter(w io.Writer) *HistogramLogWriter {
+	return &HistogramLogWriter{w: w}
+}
+
+// WriteInterval writes h as the histogram of the interval from start to
+// end, tagged with tag if not empty. The header of the log is written
+// first, with the start of the first interval as the start and base time
+// of the log, relative to which the intervals are timestamped.
+func (l *HistogramLogWriter) WriteInterval(tag string, start, end time.Time, h *Histogram) error {
+	encoded, err := h.Encode()
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+
+	var line bytes.Buffer
+	if l.start.IsZero() {
+		l.start = start
+		seconds := float64(start.UnixNano()) / 1e9
+		fmt.Fprintf(&line, "#[Histogram log format version 1.3]\n")
+		fmt.Fprintf(&line, "#[StartTime: %.3f (seconds since epoch), %s]\n", seconds, start.Format(time.RFC3339Nano))
+		fmt.Fprintf(&line, "#[BaseTime: %.3f (seconds since epoch)]\n", seconds)
+		fmt.Fprintf(&line, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+	}
+	if tag != "" {
+		fmt.Fprintf(&line, "Tag=%s,", tag)
+	}
+	fmt.Fprintf(&line, "%.3f,%.3f,%.3f,%s\n",
+		start.Sub(l.start).Seconds(), end.Sub(start).Seconds(), float64(h.Max())/1e6,
+		base64.StdEncoding.EncodeToString(encoded))
+
+	if _, err := l.w.Write(line.Bytes()); err != nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// Err returns the first error writing the log, if any.
+func (l *HistogramLogWriter) Err() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.err
+}
@@ -0,0 +1,135 @@
+package benchmarkconn_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestHistogramEncode(t *testing.T) {
+	h, err := NewHistogram(1, 3600*1000000000, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 10000; i++ {
+		h.Record(i * 1000)
+	}
+	h.RecordN(3000000000000, 5) // far beyond the others, after a long run of zero counts
+
+	encoded, err := h.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded[:4], []byte{0x1c, 0x84, 0x93, 0x14}) {
+		t.Errorf("expected the compressed V2 cookie, got %x", encoded[:4])
+	}
+
+	decoded, err := DecodeHistogram(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.TotalCount() != h.TotalCount() {
+		t.Errorf("expected %d values, got %d", h.TotalCount(), decoded.TotalCount())
+	}
+	for _, percentile := range []float64{1, 50, 90, 99, 99.9} {
+		if decoded.ValueAtPercentile(percentile) != h.ValueAtPercentile(percentile) {
+			t.Errorf("expected p%v of %d, got %d", percentile, h.ValueAtPercentile(percentile), decoded.ValueAtPercentile(percentile))
+		}
+	}
+	if decoded.Max() < h.Max() {
+		t.Errorf("expected a max of at least %d, got %d", h.Max(), decoded.Max())
+	}
+
+	if _, err := DecodeHistogram(encoded[:len(encoded)-1]); err == nil {
+		t.Error("expected a truncated histogram to fail")
+	}
+
+	empty, _ := NewHistogram(1, 1000, 2)
+	if encoded, err = empty.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err = DecodeHistogram(encoded); err != nil || decoded.TotalCount() != 0 {
+		t.Errorf("expected an empty histogram, got %v, %v", decoded, err)
+	}
+}
+
+func TestHistogramLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewHistogramLogWriter(&buf)
+
+	start := time.Unix(1700000000, 250000000)
+	h := newTestHistogram(t)
+	h.Record(2500000) // 2.5ms
+	if err := log.WriteInterval("latency", start, start.Add(time.Second), h); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.WriteInterval("", start.Add(time.Second), start.Add(1500*time.Millisecond), h); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 4 header lines and 2 intervals, got %q", lines)
+	}
+	for i, prefix := range []string{
+		"#[Histogram log format version 1.3]",
+		"#[StartTime: 1700000000.250 (seconds since epoch), ",
+		"#[BaseTime: 1700000000.250 (seconds since epoch)]",
+		`"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`,
+		"Tag=latency,0.000,1.000,2.500,",
+		"1.000,0.500,2.500,",
+	} {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected line %d to start with %q, got %q", i+1, prefix, lines[i])
+		}
+	}
+
+	encoded, err := base64.StdEncoding.DecodeString(lines[5][strings.LastIndex(lines[5], ",")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := DecodeHistogram(encoded); err != nil || decoded.TotalCount() != 1 {
+		t.Errorf("expected the histogram of the interval, got %v, %v", decoded, err)
+	}
+}
+
+func TestIntervalBenchmarkHistogramLog(t *testing.T) {
+	var buf bytes.Buffer
+	writer := &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: time.Millisecond, Echo: true, HistogramLog: NewHistogramLogWriter(&buf)}
+	reader := &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: time.Millisecond, Echo: true}
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- reader.Reader(readerConn) }()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// without counters, the whole run is a single interval
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[4], "Tag=latency,") {
+		t.Fatalf("expected a single interval tagged latency, got %q", lines)
+	}
+	if _, ok := writer.Result()["latency_intervals"]; ok {
+		t.Error("expected no latency intervals without counters")
+	}
+}
+
+func newTestHistogram(t *testing.T) *Histogram {
+	t.Helper()
+	h, err := NewHistogram(1, 3600*1000000000, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
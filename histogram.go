@@ -0,0 +1,357 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+const (
+	defaultHistogramLowest  int64 = 1                 // 1 nanosecond
+	defaultHistogramHighest int64 = 3600 * 1000000000 // 1 hour in nanoseconds
+	defaultHistogramSigFigs int   = 3                 // 0.1% value precision
+	maxHistogramSigFigs     int   = 5                 // same upper bound as HdrHistogram
+	maxHistogramHighest     int64 = math.MaxInt64 / 2 // highest value we can safely shift
+)
+
+// Histogram is a concurrency-safe HDR-style histogram for recording
+// non-negative integer values (e.g., latencies in nanoseconds) with a
+// fixed number of significant figures of precision over a configurable
+// value range.
+//
+// The bucket layout follows the one used by HdrHistogram, so the counts
+// can be exported in formats understood by existing HdrHistogram tooling.
+type Histogram struct {
+	mutex sync.Mutex
+
+	lowest     int64
+	highest    int64
+	sigFigs    int
+	autoResize bool // grow to track values above highest instead of clamping them
+
+	unitMagnitude               int
+	subBucketHalfCountMagnitude int
+	subBucketCount              int
+	subBucketHalfCount          int
+	subBucketMask               int64
+	bucketCount                 int
+
+	counts     []int64
+	totalCount int64
+	min        int64
+	max        int64
+	sum        float64
+}
+
+// NewHistogram creates a Histogram able to track values between lowest
+// and highest (inclusive) with sigFigs significant decimal figures of
+// precision.
+func NewHistogram(lowest, highest int64, sigFigs int) (*Histogram, error) {
+	if lowest < 1 {
+		return nil, errors.New("histogram lowest discernible value must be at least 1")
+	}
+	if highest < 2*lowest {
+		return nil, errors.New("histogram highest trackable value must be at least twice the lowest value")
+	}
+	if highest > maxHistogramHighest {
+		return nil, errors.New("histogram highest trackable value is too large")
+	}
+	if sigFigs < 1 || sigFigs > maxHistogramSigFigs {
+		return nil, errors.New("histogram significant figures must be between 1 and 5")
+	}
+
+	largestValueWithSingleUnitResolution := 2 * int64(math.Pow10(sigFigs))
+	subBucketCountMagnitude := int(math.Ceil(math.Log2(float64(largestValueWithSingleUnitResolution))))
+	subBucketHalfCountMagnitude := subBucketCountMagnitude - 1
+	if subBucketHalfCountMagnitude < 0 {
+		subBucketHalfCountMagnitude = 0
+	}
+
+	h := &Histogram{
+		lowest:                      lowest,
+		highest:                     highest,
+		sigFigs:                     sigFigs,
+		unitMagnitude:               bits.Len64(uint64(lowest)) - 1,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketCount:              1 << (subBucketHalfCountMagnitude + 1),
+	}
+	h.subBucketHalfCount = h.subBucketCount / 2
+	h.subBucketMask = int64(h.subBucketCount-1) << h.unitMagnitude
+
+	h.bucketCount = h.bucketsNeeded(highest)
+
+	h.counts = make([]int64, (h.bucketCount+1)*h.subBucketHalfCount)
+	h.min = math.MaxInt64
+
+	return h, nil
+}
+
+// bucketsNeeded returns how many buckets are needed to cover highest.
+func (h *Histogram) bucketsNeeded(highest int64) int {
+	smallestUntrackableValue := int64(h.subBucketCount) << h.unitMagnitude
+	bucketsNeeded := 1
+	for smallestUntrackableValue <= highest {
+		if smallestUntrackableValue > math.MaxInt64/2 {
+			bucketsNeeded++
+			break
+		}
+		smallestUntrackableValue <<= 1
+		bucketsNeeded++
+	}
+	return bucketsNeeded
+}
+
+// SetAutoResize sets whether values above the highest trackable value
+// grow the histogram to track them, keeping the precision, rather than
+// being clamped to it.
+func (h *Histogram) SetAutoResize(autoResize bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.autoResize = autoResize
+}
+
+// resize grows the histogram to track values up to at least value, and
+// up to whatever its last bucket covers. The counts already recorded keep
+// their index, since only buckets are added.
+func (h *Histogram) resize(value int64) {
+	if value > maxHistogramHighest {
+		value = maxHistogramHighest
+	}
+	h.bucketCount = h.bucketsNeeded(value)
+	counts := make([]int64, (h.bucketCount+1)*h.subBucketHalfCount)
+	copy(counts, h.counts)
+	h.counts = counts
+
+	// the last bucket covers up to subBucketCount << (unitMagnitude + bucketCount - 1)
+	h.highest = maxHistogramHighest
+	if magnitude := h.subBucketHalfCountMagnitude + 1 + h.unitMagnitude + h.bucketCount - 1; magnitude < 62 {
+		h.highest = int64(1)<<magnitude - 1
+	}
+}
+
+// newDefaultLatencyHistogram returns a histogram suitable for recording
+// latencies from 1 nanosecond up to 1 hour with 3 significant figures.
+func newDefaultLatencyHistogram() *Histogram {
+	h, err := NewHistogram(defaultHistogramLowest, defaultHistogramHighest, defaultHistogramSigFigs)
+	if err != nil {
+		panic(err) // should never happen with the default values
+	}
+	return h
+}
+
+// Record adds a single value to the histogram. Values outside of the
+// trackable range are clamped to the nearest bound, unless the histogram
+// grows to track them, see SetAutoResize.
+func (h *Histogram) Record(value int64) {
+	h.RecordN(value, 1)
+}
+
+// RecordN adds n occurrences of value to the histogram.
+func (h *Histogram) RecordN(value int64, n int64) {
+	if n <= 0 {
+		return
+	}
+	if value < 0 {
+		value = 0
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if value > h.highest && h.autoResize {
+		h.resize(value)
+	}
+	if value > h.highest {
+		value = h.highest
+	}
+	h.counts[h.countsIndexFor(value)] += n
+	h.totalCount += n
+	h.sum += float64(value) * float64(n)
+	if value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// Reset clears all recorded values while keeping the configuration.
+func (h *Histogram) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.totalCount = 0
+	h.sum = 0
+	h.min = math.MaxInt64
+	h.max = 0
+}
+
+// TotalCount returns the number of values recorded.
+func (h *Histogram) TotalCount() int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.totalCount
+}
+
+// Min returns the smallest recorded value, or 0 if nothing was recorded.
+func (h *Histogram) Min() int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded value, or 0 if nothing was recorded.
+func (h *Histogram) Max() int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.max
+}
+
+// Mean returns the arithmetic mean of all recorded values.
+func (h *Histogram) Mean() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.sum / float64(h.totalCount)
+}
+
+// StdDev returns the standard deviation of all recorded values, to the
+// configured number of significant figures.
+func (h *Histogram) StdDev() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	mean := h.sum / float64(h.totalCount)
+	var squares float64
+	for i, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		value := h.valueFromIndex(i)
+		median := h.lowestEquivalentValue(value) + h.sizeOfEquivalentValueRange(value)/2
+		deviation := float64(median) - mean
+		squares += deviation * deviation * float64(count)
+	}
+	return math.Sqrt(squares / float64(h.totalCount))
+}
+
+// ValueAtPercentile returns the value below which the given percentage
+// (0-100) of recorded values fall. The returned value is accurate to the
+// configured number of significant figures.
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.valueAtPercentile(percentile)
+}
+
+func (h *Histogram) valueAtPercentile(percentile float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	if percentile <= 0 {
+		return h.min
+	}
+
+	countAtPercentile := int64(percentile/100*float64(h.totalCount) + 0.5)
+	if countAtPercentile < 1 {
+		countAtPercentile = 1
+	}
+
+	var total int64
+	for i, count := range h.counts {
+		total += count
+		if total >= countAtPercentile {
+			value := h.highestEquivalentValue(h.valueFromIndex(i))
+			// never report beyond the actual recorded extremes
+			if value > h.max {
+				value = h.max
+			}
+			if value < h.min {
+				value = h.min
+			}
+			return value
+		}
+	}
+	return h.max
+}
+
+// Percentiles returns the commonly reported summary of the histogram,
+// keyed by name suffix (min, max, p50, p90, p99, p999).
+func (h *Histogram) Percentiles() map[string]int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.totalCount == 0 {
+		return map[string]int64{}
+	}
+
+	return map[string]int64{
+		"min":  h.min,
+		"max":  h.max,
+		"p50":  h.valueAtPercentile(50),
+		"p90":  h.valueAtPercentile(90),
+		"p99":  h.valueAtPercentile(99),
+		"p999": h.valueAtPercentile(99.9),
+	}
+}
+
+func (h *Histogram) countsIndexFor(value int64) int {
+	bucketIdx, subBucketIdx := h.bucketIndexes(value)
+	return h.countsIndex(bucketIdx, subBucketIdx)
+}
+
+func (h *Histogram) bucketIndexes(value int64) (bucketIdx, subBucketIdx int) {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(value|h.subBucketMask))
+	bucketIdx = pow2Ceiling - h.unitMagnitude - (h.subBucketHalfCountMagnitude + 1)
+	subBucketIdx = int(value >> uint(bucketIdx+h.unitMagnitude))
+	return
+}
+
+func (h *Histogram) countsIndex(bucketIdx, subBucketIdx int) int {
+	bucketBaseIdx := (bucketIdx + 1) << uint(h.subBucketHalfCountMagnitude)
+	offsetInBucket := subBucketIdx - h.subBucketHalfCount
+	return bucketBaseIdx + offsetInBucket
+}
+
+func (h *Histogram) valueFromIndex(index int) int64 {
+	bucketIdx := (index >> uint(h.subBucketHalfCountMagnitude)) - 1
+	subBucketIdx := (index & (h.subBucketHalfCount - 1)) + h.subBucketHalfCount
+	if bucketIdx < 0 {
+		subBucketIdx -= h.subBucketHalfCount
+		bucketIdx = 0
+	}
+	return int64(subBucketIdx) << uint(bucketIdx+h.unitMagnitude)
+}
+
+func (h *Histogram) sizeOfEquivalentValueRange(value int64) int64 {
+	bucketIdx, subBucketIdx := h.bucketIndexes(value)
+	adjustedBucket := bucketIdx
+	if subBucketIdx >= h.subBucketCount {
+		adjustedBucket++
+	}
+	return int64(1) << uint(h.unitMagnitude+adjustedBucket)
+}
+
+func (h *Histogram) lowestEquivalentValue(value int64) int64 {
+	bucketIdx, subBucketIdx := h.bucketIndexes(value)
+	return int64(subBucketIdx) << uint(bucketIdx+h.unitMagnitude)
+}
+
+func (h *Histogram) highestEquivalentValue(value int64) int64 {
+	return h.lowestEquivalentValue(value) + h.sizeOfEquivalentValueRange(value) - 1
+}
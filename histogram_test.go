@@ -0,0 +1,93 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestHistogram(t *testing.T) {
+	h, err := NewHistogram(1, 3600*1000000000, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 10000; i++ {
+		h.Record(i * 1000) // 1us to 10ms
+	}
+
+	if h.TotalCount() != 10000 {
+		t.Fatalf("expected 10000 values, got %d", h.TotalCount())
+	}
+
+	if h.Min() != 1000 || h.Max() != 10000000 {
+		t.Fatalf("unexpected min/max: %d/%d", h.Min(), h.Max())
+	}
+
+	for _, tc := range []struct {
+		percentile float64
+		expected   int64
+	}{
+		{50, 5000000},
+		{90, 9000000},
+		{99, 9900000},
+		{99.9, 9990000},
+		{100, 10000000},
+	} {
+		got := h.ValueAtPercentile(tc.percentile)
+		// 3 significant figures means the value is within 0.1%
+		if diff := got - tc.expected; diff < -tc.expected/1000 || diff > tc.expected/1000 {
+			t.Errorf("p%v: expected ~%d, got %d", tc.percentile, tc.expected, got)
+		}
+	}
+
+	// uniform over 1us to 10ms, i.e., a standard deviation of about
+	// 10ms / sqrt(12)
+	if stddev := h.StdDev(); stddev < 2880000 || stddev > 2890000 {
+		t.Errorf("expected a standard deviation of ~2886000, got %f", stddev)
+	}
+
+	h.Reset()
+	if h.TotalCount() != 0 || h.ValueAtPercentile(50) != 0 {
+		t.Fatalf("histogram not empty after reset")
+	}
+}
+
+func TestNewHistogramInvalid(t *testing.T) {
+	if _, err := NewHistogram(0, 1000, 3); err == nil {
+		t.Errorf("expected error for lowest < 1")
+	}
+	if _, err := NewHistogram(10, 15, 3); err == nil {
+		t.Errorf("expected error for highest < 2*lowest")
+	}
+	if _, err := NewHistogram(1, 1000, 6); err == nil {
+		t.Errorf("expected error for too many significant figures")
+	}
+}
+
+func TestHistogramAutoResize(t *testing.T) {
+	h, err := NewHistogram(1, 1000000, 3) // up to 1ms
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Record(500000)
+	h.Record(5000000000) // 5s, clamped
+	if h.Max() != 1000000 {
+		t.Fatalf("expected the value to be clamped to 1ms, got %d", h.Max())
+	}
+
+	h.Reset()
+	h.SetAutoResize(true)
+	h.Record(500000)
+	h.Record(5000000000)
+	if h.Max() != 5000000000 {
+		t.Fatalf("expected the histogram to grow to 5s, got %d", h.Max())
+	}
+	// the values recorded before growing keep their precision
+	if p50 := h.ValueAtPercentile(50); p50 < 499500 || p50 > 500500 {
+		t.Errorf("expected a median of ~500us, got %d", p50)
+	}
+	if p100 := h.ValueAtPercentile(100); p100 != 5000000000 {
+		t.Errorf("expected a maximum of 5s, got %d", p100)
+	}
+}
@@ -0,0 +1,122 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistogramSettings sets the bounds and resolution of the latency
+// histograms of a benchmark, which otherwise depend on its Profile, e.g.,
+// to resolve microsecond-scale latencies in a datacenter with more
+// significant figures, or to track latencies of several seconds over a
+// satellite link. It is local and does not need to match the peer.
+type HistogramSettings struct {
+	Lowest    time.Duration `json:"lowest,omitempty" yaml:"lowest"`         // Lowest defines the lowest discernible latency, 1ns if not set
+	Highest   time.Duration `json:"highest,omitempty" yaml:"highest"`       // Highest defines the highest trackable latency, 1 hour or 1 minute with ProfileConstrained if not set
+	SigFigs   int           `json:"sig_figs,omitempty" yaml:"sig_figs"`     // SigFigs defines the significant decimal figures of precision, between 1 and 5, 3 or 2 with ProfileConstrained if not set
+	AutoRange bool          `json:"auto_range,omitempty" yaml:"auto_range"` // AutoRange grows the histograms to track latencies above Highest instead of clamping them to it
+}
+
+// ParseHistogramSettings parses histogram settings as used by the command
+// line tools, comma-separated <setting>=<value> pairs of lowest, highest
+// and sigfigs, and auto for AutoRange, e.g., "lowest=100ns,sigfigs=4,auto".
+func ParseHistogramSettings(spec string) (*HistogramSettings, error) {
+	s := &HistogramSettings{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "auto" {
+			s.AutoRange = true
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("histogram setting %q must be <setting>=<value> or auto", entry)
+		}
+
+		var err error
+		switch name {
+		case "lowest":
+			s.Lowest, err = time.ParseDuration(value)
+		case "highest":
+			s.Highest, err = time.ParseDuration(value)
+		case "sigfigs":
+			s.SigFigs, err = strconv.Atoi(value)
+		default:
+			return nil, fmt.Errorf("unknown histogram setting %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s histogram setting: %w", name, err)
+		}
+	}
+	return s, nil
+}
+
+// String returns the settings in the format parsed by
+// ParseHistogramSettings.
+func (s *HistogramSettings) String() string {
+	var settings []string
+	if s.Lowest > 0 {
+		settings = append(settings, "lowest="+s.Lowest.String())
+	}
+	if s.Highest > 0 {
+		settings = append(settings, "highest="+s.Highest.String())
+	}
+	if s.SigFigs > 0 {
+		settings = append(settings, "sigfigs="+strconv.Itoa(s.SigFigs))
+	}
+	if s.AutoRange {
+		settings = append(settings, "auto")
+	}
+	return strings.Join(settings, ",")
+}
+
+// validate checks that a histogram can be created with the settings, with
+// the defaults of profile for those not set.
+func (s *HistogramSettings) validate(profile Profile) error {
+	if s == nil {
+		return nil
+	}
+	if s.Lowest < 0 || s.Highest < 0 || s.SigFigs < 0 {
+		return errors.New("histogram settings must not be negative")
+	}
+	_, err := NewHistogram(s.resolve(profile))
+	return err
+}
+
+// resolve returns the arguments of NewHistogram for the settings, with the
+// defaults of profile for those not set.
+func (s *HistogramSettings) resolve(profile Profile) (lowest, highest int64, sigFigs int) {
+	lowest, highest, sigFigs = defaultHistogramLowest, defaultHistogramHighest, defaultHistogramSigFigs
+	if profile == ProfileConstrained {
+		highest, sigFigs = constrainedHistogramHighest, constrainedHistogramSigFigs
+	}
+	if s == nil {
+		return
+	}
+	if s.Lowest > 0 {
+		lowest = s.Lowest.Nanoseconds()
+	}
+	if s.Highest > 0 {
+		highest = s.Highest.Nanoseconds()
+	}
+	if s.SigFigs > 0 {
+		sigFigs = s.SigFigs
+	}
+	return
+}
+
+// newLatencyHistogram returns a histogram recording latencies in
+// nanoseconds with settings, sized for profile if nil.
+func newLatencyHistogram(settings *HistogramSettings, profile Profile) *Histogram {
+	h, err := NewHistogram(settings.resolve(profile))
+	if err != nil {
+		panic(err) // should never happen with validated settings
+	}
+	if settings != nil && settings.AutoRange {
+		h.SetAutoResize(true)
+	}
+	return h
+}
@@ -0,0 +1,81 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestParseHistogramSettings(t *testing.T) {
+	for _, spec := range []string{
+		"sigfigs=4",
+		"lowest=1µs,highest=10s",
+		"lowest=100ns,highest=1m0s,sigfigs=2,auto",
+		"auto",
+	} {
+		s, err := ParseHistogramSettings(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if s.String() != spec {
+			t.Errorf("expected %s, got %s", spec, s)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"sigfigs",
+		"sigfigs=three",
+		"highest=10",
+		"resolution=4",
+	} {
+		if _, err := ParseHistogramSettings(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestIntervalBenchmarkHistogramSettings(t *testing.T) {
+	newEcho := func(histogram *HistogramSettings) *IntervalBenchmark {
+		return &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: 5 * time.Millisecond, Echo: true, Histogram: histogram}
+	}
+
+	run := func(histogram *HistogramSettings) map[string]any {
+		writer, reader := newEcho(histogram), newEcho(nil)
+
+		writerConn, readerConn := net.Pipe()
+		defer writerConn.Close()
+		defer readerConn.Close()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := reader.Reader(&alternatingDelayConn{Conn: readerConn, size: 64, delay: 2 * time.Millisecond}); err != nil {
+				t.Logf("Reader errored: %v", err)
+			}
+		}()
+		if err := writer.Writer(writerConn); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		return writer.Result()
+	}
+
+	// latencies above 1ms are clamped, unless the histogram grows
+	if max := run(&HistogramSettings{Highest: time.Millisecond})["latency_max_ns"].(int64); max != int64(time.Millisecond) {
+		t.Errorf("expected the latencies to be clamped to 1ms, got %d", max)
+	}
+	if max := run(&HistogramSettings{Highest: time.Millisecond, AutoRange: true})["latency_max_ns"].(int64); max < int64(2*time.Millisecond) {
+		t.Errorf("expected the latencies of the delayed echoes, got %d", max)
+	}
+
+	writer := newEcho(&HistogramSettings{Lowest: time.Second, Highest: time.Millisecond})
+	if err := writer.Writer(nil); err == nil {
+		t.Errorf("expected an error for a highest latency below the lowest one")
+	}
+}
@@ -0,0 +1,25 @@
+package benchmarkconn
+
+import "time"
+
+// MessageEvent is a message written or read during the measurement, passed
+// to OnMessage.
+type MessageEvent struct {
+	Index   uint64        // Index is how many messages were written, or read, before this one in the run
+	Size    int           // Size is the size of the message in bytes, including the length header with a size distribution
+	Written bool          // Written is set for the messages written, and unset for those read, including the echoes received by the writer of an echo benchmark
+	Latency time.Duration // Latency is the round-trip latency of an echo, or the one-way latency of a message with Timestamps, 0 if not measured
+}
+
+// completeRun calls onComplete with the result of a run which ended
+// without error, or onError with the error it failed with, each if set.
+func completeRun(err error, onComplete func(map[string]any), onError func(error), result func() map[string]any) {
+	switch {
+	case err != nil:
+		if onError != nil {
+			onError(err)
+		}
+	case onComplete != nil:
+		onComplete(result())
+	}
+}
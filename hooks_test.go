@@ -0,0 +1,120 @@
+package benchmarkconn_test
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPressuredBenchmarkHooks(t *testing.T) {
+	var started, completed atomic.Bool
+	var written, read atomic.Uint64
+	var timed atomic.Uint64
+	writer := &PressuredBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 1000,
+		Timestamps:    true,
+		OnStart:       func() { started.Store(true) },
+		OnMessage: func(m MessageEvent) {
+			if !m.Written || m.Index != written.Load() || m.Size != 1024 {
+				t.Errorf("unexpected message written: %+v", m)
+			}
+			written.Add(1)
+		},
+		OnError: func(err error) { t.Errorf("unexpected error: %v", err) },
+	}
+	reader := &PressuredBenchmark{
+		MessageSize:   1024,
+		TotalMessages: 1000,
+		Timestamps:    true,
+		OnMessage: func(m MessageEvent) {
+			if m.Written || m.Index != read.Load() {
+				t.Errorf("unexpected message read: %+v", m)
+			}
+			if m.Latency > 0 {
+				timed.Add(1)
+			}
+			read.Add(1)
+		},
+		OnComplete: func(result map[string]any) {
+			if result["successful_reads"] != uint64(1000) {
+				t.Errorf("expected the result of the run, got %v", result)
+			}
+			completed.Store(true)
+		},
+	}
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(readerConn); err != nil {
+			t.Errorf("Reader errored: %v", err)
+		}
+	}()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if !started.Load() || !completed.Load() {
+		t.Errorf("expected OnStart and OnComplete to be called")
+	}
+	if written.Load() != 1000 || read.Load() != 1000 || timed.Load() != 1000 {
+		t.Errorf("expected 1000 messages written, read and timed, got %d, %d and %d", written.Load(), read.Load(), timed.Load())
+	}
+}
+
+func TestIntervalBenchmarkHooks(t *testing.T) {
+	var echoes atomic.Uint64
+	var failure error
+	writer := &IntervalBenchmark{
+		MessageSize:   64,
+		TotalMessages: 20,
+		Interval:      time.Millisecond,
+		Echo:          true,
+		OnMessage: func(m MessageEvent) {
+			if !m.Written && m.Latency > 0 {
+				echoes.Add(1)
+			}
+		},
+		OnComplete: func(map[string]any) { t.Errorf("unexpected completion") },
+		OnError:    func(err error) { failure = err },
+	}
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	// the reader drops the connection halfway, failing the writer
+	reader := &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: time.Millisecond, Echo: true}
+	reader.OnMessage = func(m MessageEvent) {
+		if m.Index == 9 {
+			readerConn.Close()
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reader.Reader(readerConn)
+	}()
+	err := writer.Writer(writerConn)
+	wg.Wait()
+
+	if err == nil || !errors.Is(failure, err) {
+		t.Errorf("expected OnError to be called with %v, got %v", err, failure)
+	}
+	if echoes.Load() == 0 {
+		t.Errorf("expected the echoes received to be passed to OnMessage")
+	}
+}
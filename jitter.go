@@ -0,0 +1,52 @@
+package benchmarkconn
+
+import "sync"
+
+// jitterRecorder estimates the interarrival jitter of RFC 3550, section
+// 6.4.1: the mean deviation of the difference in latency between
+// consecutive messages, smoothed with a gain of 1/16. Unlike the standard
+// deviation of the latency, it reflects how much latency varies from one
+// message to the next, which is what playout buffers of realtime
+// workloads absorb.
+type jitterRecorder struct {
+	mutex    sync.Mutex
+	previous int64 // the latency of the previous message
+	recorded bool  // whether any latency was recorded
+	jitter   int64 // scaled by 16 to keep integer precision, see RFC 3550, appendix A.8
+}
+
+func (r *jitterRecorder) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.previous, r.recorded, r.jitter = 0, false, 0
+}
+
+// record records the latency of the next message in nanoseconds.
+func (r *jitterRecorder) record(latency int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.recorded {
+		d := latency - r.previous
+		if d < 0 {
+			d = -d
+		}
+		r.jitter += d - ((r.jitter + 8) >> 4)
+	}
+	r.previous, r.recorded = latency, true
+}
+
+// addResult adds the jitter to result as jitter_ns.
+func (r *jitterRecorder) addResult(result map[string]any, profile Profile) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.recorded {
+		return
+	}
+	if profile == ProfileConstrained { // integer-only stats
+		result["jitter_ns"] = r.jitter >> 4
+	} else {
+		result["jitter_ns"] = float64(r.jitter) / 16
+	}
+}
@@ -0,0 +1,65 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// alternatingDelayConn delays every other write of size by delay, so that
+// the latency of consecutive echoes alternates.
+type alternatingDelayConn struct {
+	net.Conn
+	size    int
+	delay   time.Duration
+	delayed bool
+}
+
+func (c *alternatingDelayConn) Write(p []byte) (int, error) {
+	if len(p) == c.size {
+		if c.delayed {
+			time.Sleep(c.delay)
+		}
+		c.delayed = !c.delayed
+	}
+	return c.Conn.Write(p)
+}
+
+func TestIntervalBenchmarkJitter(t *testing.T) {
+	newEcho := func() *IntervalBenchmark {
+		return &IntervalBenchmark{MessageSize: 64, TotalMessages: 100, Interval: 5 * time.Millisecond, Echo: true}
+	}
+	writer, reader := newEcho(), newEcho()
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(&alternatingDelayConn{Conn: readerConn, size: 64, delay: 2 * time.Millisecond}); err != nil {
+			t.Logf("Reader errored: %v", err)
+		}
+	}()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	result := writer.Result()
+	t.Logf("Writer: %v", result)
+
+	// consecutive latencies differ by about 2ms, and deviate from their
+	// mean by about 1ms
+	if jitter, ok := result["jitter_ns"].(float64); !ok || jitter < 1e6 || jitter > 4e6 {
+		t.Errorf("expected a jitter of about 2ms, got %v", result["jitter_ns"])
+	}
+	if stddev, ok := result["latency_stddev_ns"].(float64); !ok || stddev < 0.5e6 || stddev > 2e6 {
+		t.Errorf("expected a latency standard deviation of about 1ms, got %v", result["latency_stddev_ns"])
+	}
+}
@@ -0,0 +1,135 @@
+package benchmarkconn
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyTimeline summarizes the latencies of each interval of a run with
+// their percentiles, so that how latency evolves under sustained load is
+// visible in the result without keeping every sample. It also writes the
+// histogram of each interval to a HistogramLogWriter, if any.
+type latencyTimeline struct {
+	mutex         sync.Mutex
+	start         time.Time
+	intervalStart time.Time
+	histogram     *Histogram // the latencies of the current interval
+	intervals     []map[string]any
+	summarize     bool   // whether to keep the summaries of the intervals
+	prefix        string // the prefix of the percentiles, e.g., latency_
+	log           *HistogramLogWriter
+
+	ticker *time.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// startLatencyTimeline starts summarizing the latencies recorded every
+// interval from start, with percentiles keyed as <prefix><stat>_ns and a
+// histogram of settings sized for profile, and writing the histogram of
+// each interval to log if set, tagged as prefix without its trailing
+// underscore. If interval is not positive, the whole run is a single
+// interval written to log and not summarized, and nil is returned without
+// log.
+func startLatencyTimeline(interval time.Duration, start time.Time, prefix string, settings *HistogramSettings, profile Profile, log *HistogramLogWriter) *latencyTimeline {
+	if interval <= 0 && log == nil {
+		return nil
+	}
+
+	t := &latencyTimeline{
+		start:         start,
+		intervalStart: start,
+		summarize:     interval > 0,
+		prefix:        prefix,
+		log:           log,
+		done:          make(chan struct{}),
+		histogram:     newLatencyHistogram(settings, profile),
+	}
+	if interval <= 0 {
+		return t
+	}
+
+	t.ticker = time.NewTicker(interval)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for {
+			select {
+			case now := <-t.ticker.C:
+				t.closeInterval(now)
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// record records a latency in nanoseconds into the current interval.
+func (t *latencyTimeline) record(latency int64) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.histogram.Record(latency)
+}
+
+// stop stops summarizing, closing the last interval, however short.
+func (t *latencyTimeline) stop() {
+	if t == nil {
+		return
+	}
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+	close(t.done)
+	t.wg.Wait()
+	t.closeInterval(time.Now())
+}
+
+// closeInterval summarizes and logs the current interval ending at now,
+// if any latency was recorded in it, and starts the next one. Errors
+// writing the log are left to HistogramLogWriter.Err.
+func (t *latencyTimeline) closeInterval(now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	start := t.intervalStart
+	t.intervalStart = now
+	count := t.histogram.TotalCount()
+	if count == 0 {
+		return
+	}
+	if t.summarize {
+		interval := map[string]any{
+			"time":    now.Format(time.RFC3339Nano),
+			"elapsed": now.Sub(t.start).String(),
+			"count":   count,
+		}
+		for name, value := range t.histogram.Percentiles() {
+			interval[t.prefix+name+"_ns"] = value
+		}
+		t.intervals = append(t.intervals, interval)
+	}
+	if t.log != nil {
+		t.log.WriteInterval(strings.TrimSuffix(t.prefix, "_"), start, now, t.histogram)
+	}
+	t.histogram.Reset()
+}
+
+// addResult adds the summaries of the intervals with latencies to result
+// as <prefix>intervals in order, each holding the time it ended, the
+// elapsed time since the start of the run, the count of latencies and
+// their percentiles.
+func (t *latencyTimeline) addResult(result map[string]any) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.intervals) > 0 {
+		result[t.prefix+"intervals"] = t.intervals
+	}
+}
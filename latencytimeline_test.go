@@ -0,0 +1,57 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestIntervalBenchmarkLatencyIntervals(t *testing.T) {
+	newEcho := func() *IntervalBenchmark {
+		return &IntervalBenchmark{MessageSize: 64, Interval: 10 * time.Millisecond, Echo: true, TargetDuration: 2500 * time.Millisecond}
+	}
+	writer, reader := newEcho(), newEcho()
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(readerConn); err != nil {
+			t.Logf("Reader errored: %v", err)
+		}
+	}()
+	if err := writer.Writer(writerConn, NewCpuUsageCounter(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	result := writer.Result()
+
+	// a summary for each second of the run, and one for the rest
+	intervals, ok := result["latency_intervals"].([]map[string]any)
+	if !ok || len(intervals) < 2 {
+		t.Fatalf("expected a summary per counter tick, got %v", result["latency_intervals"])
+	}
+	var total int64
+	for _, interval := range intervals {
+		if _, ok := interval["latency_p99_ns"]; !ok {
+			t.Errorf("expected the percentiles of the interval, got %v", interval)
+		}
+		total += interval["count"].(int64)
+	}
+	if messages := result["successful_writes"].(uint64); total == 0 || uint64(total) > messages {
+		t.Errorf("expected up to %d latencies across the intervals, got %d", messages, total)
+	}
+
+	// without counters, there is no tick to summarize
+	if _, ok := reader.Result()["latency_intervals"]; ok {
+		t.Errorf("expected no summary without counters")
+	}
+}
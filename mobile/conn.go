@@ -0,0 +1,80 @@
+package mobile
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var errDeadlineUnsupported = errors.New("deadlines are not supported by mobile conns")
+
+// countedConn is a net.Conn which keeps track of the bytes transferred
+// for progress reporting.
+type countedConn interface {
+	net.Conn
+	counts() (bytesRead, bytesWritten int64)
+}
+
+// counters implements the byte accounting shared by the conn adapters.
+type counters struct {
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+}
+
+func (c *counters) counts() (int64, int64) {
+	return c.bytesRead.Load(), c.bytesWritten.Load()
+}
+
+// netConn adapts a Conn implemented by the app to net.Conn.
+type netConn struct {
+	counters
+	conn Conn
+}
+
+func (c *netConn) Read(b []byte) (int, error) {
+	data, err := c.conn.Read(len(b))
+	n := copy(b, data)
+	c.bytesRead.Add(int64(n))
+	return n, err
+}
+
+func (c *netConn) Write(b []byte) (int, error) {
+	n, err := c.conn.Write(b)
+	c.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+func (c *netConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *netConn) LocalAddr() net.Addr  { return mobileAddr{} }
+func (c *netConn) RemoteAddr() net.Addr { return mobileAddr{} }
+
+func (c *netConn) SetDeadline(time.Time) error      { return errDeadlineUnsupported }
+func (c *netConn) SetReadDeadline(time.Time) error  { return errDeadlineUnsupported }
+func (c *netConn) SetWriteDeadline(time.Time) error { return errDeadlineUnsupported }
+
+// countingConn counts the bytes transferred over a regular net.Conn.
+type countingConn struct {
+	counters
+	net.Conn
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesRead.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+type mobileAddr struct{}
+
+func (mobileAddr) Network() string { return "mobile" }
+func (mobileAddr) String() string  { return "mobile" }
@@ -0,0 +1,170 @@
+// Package mobile exposes a gomobile-friendly API for the benchmark core,
+// so mobile apps embedding custom transports can run the same benchmarks
+// on-device and report comparable numbers.
+//
+// Only types supported by gomobile bind are used in the exported API:
+// strings, signed integers, booleans, byte slices, and interfaces whose
+// methods use those types. Results are delivered as JSON strings.
+//
+// Build with:
+//
+//	gomobile bind -target=android github.com/gaukas/benchmarkconn/mobile
+//	gomobile bind -target=ios github.com/gaukas/benchmarkconn/mobile
+package mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+)
+
+// Conn is implemented by the app to expose its custom transport.
+//
+// Read returns up to max bytes of data, blocking until at least one byte
+// is available. Write writes all of data.
+type Conn interface {
+	Read(max int) ([]byte, error)
+	Write(data []byte) (int, error)
+	Close() error
+}
+
+// Callback receives progress updates and the final result of a run.
+type Callback interface {
+	// OnProgress is called periodically with the number of bytes read and
+	// written so far and the time elapsed since the run started.
+	OnProgress(bytesRead, bytesWritten, elapsedMs int64)
+	// OnResult is called once with the JSON-encoded result of the run.
+	OnResult(resultJSON string)
+}
+
+// Config describes a benchmark run.
+type Config struct {
+	Type               string // Type is the benchmark type: "pressure" or "echo"
+	Write              bool   // Write runs the writer side if true, otherwise the reader side
+	MessageSize        int    // MessageSize defines how many bytes to write for each send attempt
+	TotalMessages      int64  // TotalMessages defines how many messages to send in total
+	IntervalMs         int64  // IntervalMs defines the interval between messages for "echo", in milliseconds
+	Profile            string // Profile is either "default" or "constrained"
+	ProgressIntervalMs int64  // ProgressIntervalMs defines how often OnProgress is called, 0 to disable
+	Digits             int    // Digits defines the significant digits floating-point values in the result are rounded to, 0 for full precision
+}
+
+// NewConfig returns a Config with the same defaults as the command line
+// tools.
+func NewConfig() *Config {
+	return &Config{
+		Type:               "pressure",
+		Write:              true,
+		MessageSize:        1024,
+		TotalMessages:      1000,
+		IntervalMs:         1,
+		Profile:            "default",
+		ProgressIntervalMs: 1000,
+		Digits:             benchmarkconn.DefaultResultDigits,
+	}
+}
+
+// Run runs the benchmark described by config over conn and blocks until
+// it completes. The conn is not closed.
+func Run(conn Conn, config *Config, callback Callback) error {
+	if conn == nil {
+		return errors.New("conn must not be nil")
+	}
+	return run(&netConn{conn: conn}, config, callback)
+}
+
+// RunTCP dials the address over TCP and runs the benchmark described by
+// config, which is handy for establishing an on-device baseline.
+func RunTCP(address string, config *Config, callback Callback) error {
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return run(&countingConn{Conn: c}, config, callback)
+}
+
+func run(conn countedConn, config *Config, callback Callback) error {
+	if config == nil {
+		return errors.New("config must not be nil")
+	}
+
+	bench, err := newBenchmark(config)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if callback != nil && config.ProgressIntervalMs > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(time.Duration(config.ProgressIntervalMs) * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					bytesRead, bytesWritten := conn.counts()
+					callback.OnProgress(bytesRead, bytesWritten, time.Since(start).Milliseconds())
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	if config.Write {
+		err = bench.Writer(conn)
+	} else {
+		err = bench.Reader(conn)
+	}
+	if err != nil {
+		return err
+	}
+
+	result := bench.Result()
+	benchmarkconn.RoundResult(result, config.Digits)
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if callback != nil {
+		callback.OnResult(string(resultJSON))
+	}
+
+	return nil
+}
+
+func newBenchmark(config *Config) (benchmarkconn.Benchmark, error) {
+	profile, err := benchmarkconn.ParseProfile(config.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if config.TotalMessages < 0 {
+		return nil, errors.New("total messages must not be negative")
+	}
+
+	switch config.Type {
+	case "pressure":
+		return &benchmarkconn.PressuredBenchmark{
+			MessageSize:   config.MessageSize,
+			TotalMessages: uint64(config.TotalMessages),
+			Profile:       profile,
+		}, nil
+	case "echo":
+		return &benchmarkconn.IntervalBenchmark{
+			MessageSize:   config.MessageSize,
+			TotalMessages: uint64(config.TotalMessages),
+			Interval:      time.Duration(config.IntervalMs) * time.Millisecond,
+			Echo:          true,
+			Profile:       profile,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown benchmark type %q", config.Type)
+	}
+}
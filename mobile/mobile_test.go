@@ -0,0 +1,138 @@
+package mobile_test
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/gaukas/benchmarkconn"
+	"github.com/gaukas/benchmarkconn/mobile"
+)
+
+// pipeConn implements the Conn of an app over one end of a net.Pipe.
+type pipeConn struct {
+	net.Conn
+}
+
+func (c *pipeConn) Read(max int) ([]byte, error) {
+	buf := make([]byte, max)
+	n, err := c.Conn.Read(buf)
+	return buf[:n], err
+}
+
+// recordingCallback records the progress and the result of a run.
+type recordingCallback struct {
+	mutex        sync.Mutex
+	progress     int
+	bytesWritten int64
+	results      []string
+}
+
+func (c *recordingCallback) OnProgress(bytesRead, bytesWritten, elapsedMs int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.progress++
+	c.bytesWritten = bytesWritten
+}
+
+func (c *recordingCallback) OnResult(resultJSON string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.results = append(c.results, resultJSON)
+}
+
+func TestRun(t *testing.T) {
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	config := mobile.NewConfig()
+	config.Type = "echo"
+	config.MessageSize = 64
+	config.TotalMessages = 50
+	config.ProgressIntervalMs = 5
+
+	readerConfig := *config
+	readerConfig.Write = false
+	readerErr := make(chan error, 1)
+	go func() {
+		readerErr <- mobile.Run(&pipeConn{readerConn}, &readerConfig, nil)
+	}()
+
+	callback := &recordingCallback{}
+	if err := mobile.Run(&pipeConn{writerConn}, config, callback); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-readerErr; err != nil {
+		t.Fatal(err)
+	}
+
+	callback.mutex.Lock()
+	defer callback.mutex.Unlock()
+	if callback.progress == 0 || callback.bytesWritten == 0 {
+		t.Errorf("expected progress with bytes written, got %d calls, %d bytes", callback.progress, callback.bytesWritten)
+	}
+	if len(callback.results) != 1 {
+		t.Fatalf("expected a single result, got %d", len(callback.results))
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(callback.results[0]), &result); err != nil {
+		t.Fatalf("expected a JSON result, got %q: %v", callback.results[0], err)
+	}
+	if writes := result["successful_writes"]; writes != float64(50) {
+		t.Errorf("expected 50 messages written, got %v", writes)
+	}
+	if _, ok := result["latency_p50_ns"]; !ok {
+		t.Errorf("expected the echo latency in the result, got %v", result)
+	}
+}
+
+func TestRunTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	reader := &benchmarkconn.PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000}
+	readerErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			readerErr <- err
+			return
+		}
+		defer conn.Close()
+		readerErr <- reader.Reader(conn)
+	}()
+
+	callback := &recordingCallback{}
+	if err := mobile.RunTCP(listener.Addr().String(), mobile.NewConfig(), callback); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-readerErr; err != nil {
+		t.Fatal(err)
+	}
+	if reads := reader.Result()["successful_reads"]; reads != uint64(1000) {
+		t.Errorf("expected 1000 messages read, got %v", reads)
+	}
+	if len(callback.results) != 1 {
+		t.Errorf("expected a single result, got %d", len(callback.results))
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	config := mobile.NewConfig()
+	config.Type = "unknown"
+	if err := mobile.Run(&pipeConn{conn}, config, nil); err == nil {
+		t.Error("expected an unknown benchmark type to fail")
+	}
+	if err := mobile.Run(nil, mobile.NewConfig(), nil); err == nil {
+		t.Error("expected a nil conn to fail")
+	}
+}
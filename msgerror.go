@@ -0,0 +1,120 @@
+package benchmarkconn
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// messageErrorInterval is the interval message errors are counted over in
+// the result.
+const messageErrorInterval = time.Second
+
+// Kinds of per-message errors.
+const (
+	messageErrorCorrupted  = "corrupted"
+	messageErrorOutOfOrder = "out_of_order"
+)
+
+// messageErrorRecorder counts per-message errors by kind over the run and
+// over each interval, so that a misbehaving transport failing thousands
+// of messages per second yields a handful of counts rather than a
+// thousand entries.
+type messageErrorRecorder struct {
+	mutex     sync.Mutex
+	start     time.Time
+	limit     uint64            // the errors tolerated before the run fails, unlimited if 0
+	total     uint64            // the errors over the run
+	totals    map[string]uint64 // the errors over the run by kind
+	window    map[string]uint64 // the errors of the current interval by kind
+	windowAt  int64             // the index of the current interval since start
+	intervals []map[string]any  // the counts of the past intervals with errors
+	progress  map[string]uint64 // the errors since the last progress snapshot by kind
+}
+
+// reset starts counting at start, tolerating limit errors, unlimited if 0.
+func (r *messageErrorRecorder) reset(start time.Time, limit uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.start = start
+	r.limit = limit
+	r.total = 0
+	r.totals = make(map[string]uint64)
+	r.window = make(map[string]uint64)
+	r.windowAt = 0
+	r.intervals = nil
+	r.progress = make(map[string]uint64)
+}
+
+// record counts an error of kind. It returns an error once more errors
+// than tolerated were recorded, failing the run.
+func (r *messageErrorRecorder) record(kind string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if at := int64(time.Since(r.start) / messageErrorInterval); at != r.windowAt {
+		r.closeWindow()
+		r.windowAt = at
+	}
+	r.total++
+	r.totals[kind]++
+	r.window[kind]++
+	r.progress[kind]++
+
+	if r.limit > 0 && r.total > r.limit {
+		return fmt.Errorf("%d messages failed, more than the %d tolerated", r.total, r.limit)
+	}
+	return nil
+}
+
+// closeWindow moves the counts of the current interval, if any, to the
+// past intervals.
+func (r *messageErrorRecorder) closeWindow() {
+	if len(r.window) == 0 {
+		return
+	}
+	interval := map[string]any{
+		"elapsed": (time.Duration(r.windowAt) * messageErrorInterval).String(),
+	}
+	for kind, count := range r.window {
+		interval[kind] = count
+	}
+	r.intervals = append(r.intervals, interval)
+	clear(r.window)
+}
+
+// count returns the errors over the run so far.
+func (r *messageErrorRecorder) count() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.total
+}
+
+// drain returns the errors by kind since the last call, or nil if none.
+func (r *messageErrorRecorder) drain() map[string]uint64 {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.progress) == 0 {
+		return nil
+	}
+	counts := maps.Clone(r.progress)
+	clear(r.progress)
+	return counts
+}
+
+// addResult adds the errors by kind to result as message_errors, and
+// those of each interval with errors as message_error_intervals.
+func (r *messageErrorRecorder) addResult(result map[string]any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.total == 0 {
+		return
+	}
+	r.closeWindow()
+	result["message_errors"] = maps.Clone(r.totals)
+	result["message_error_intervals"] = r.intervals
+}
@@ -0,0 +1,285 @@
+// Package otel exports benchmark runs to OpenTelemetry, so that results
+// land in an existing observability stack: each run is mapped to a span
+// carrying the result, and the samples of its counters to metrics.
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/gaukas/benchmarkconn"
+
+	// DefaultServiceName is the service name reported if none is set.
+	DefaultServiceName = "benchmarkconn"
+)
+
+// Config configures the export over OTLP/HTTP.
+type Config struct {
+	Endpoint       string        // Endpoint is the host:port of the OTLP/HTTP collector
+	Insecure       bool          // Insecure disables TLS towards the collector
+	ServiceName    string        // ServiceName defaults to DefaultServiceName
+	MetricInterval time.Duration // MetricInterval defaults to 1 second, the interval of the counters
+}
+
+// Exporter maps benchmark runs to spans and counter samples to metrics.
+type Exporter struct {
+	tracer  trace.Tracer
+	meter   metric.Meter
+	counter metric.Float64ObservableGauge
+
+	shutdown func(context.Context) error
+}
+
+// NewOTLPExporter creates an Exporter sending traces and metrics to an
+// OTLP/HTTP collector. Shutdown must be called to flush them.
+func NewOTLPExporter(ctx context.Context, config Config) (*Exporter, error) {
+	if config.ServiceName == "" {
+		config.ServiceName = DefaultServiceName
+	}
+	if config.MetricInterval <= 0 {
+		config.MetricInterval = time.Second
+	}
+
+	traceOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+	metricOptions := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		traceOptions = append(traceOptions, otlptracehttp.WithInsecure())
+		metricOptions = append(metricOptions, otlpmetrichttp.WithInsecure())
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, traceOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP trace exporter: %w", err)
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP metric exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", config.ServiceName))
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(config.MetricInterval))),
+		sdkmetric.WithResource(res),
+	)
+
+	exporter, err := NewExporter(tracerProvider, meterProvider)
+	if err != nil {
+		return nil, err
+	}
+	exporter.shutdown = func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}
+	return exporter, nil
+}
+
+// NewExporter creates an Exporter using the given providers, e.g., the
+// global ones of an application already set up with OpenTelemetry.
+func NewExporter(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*Exporter, error) {
+	meter := meterProvider.Meter(instrumentationName)
+	counter, err := meter.Float64ObservableGauge("benchmarkconn.counter",
+		metric.WithDescription("Latest sample of a counter of a benchmark run"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exporter{
+		tracer:  tracerProvider.Tracer(instrumentationName),
+		meter:   meter,
+		counter: counter,
+	}, nil
+}
+
+// Shutdown flushes and stops the export if the Exporter was created by
+// NewOTLPExporter. Otherwise, the providers are left to their owner.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	if e.shutdown == nil {
+		return nil
+	}
+	return e.shutdown(ctx)
+}
+
+// Run is a benchmark run being exported.
+type Run struct {
+	ctx          context.Context
+	tracer       trace.Tracer
+	span         trace.Span
+	registration metric.Registration
+}
+
+// StartRun starts the span of a run of the named benchmark and observes
+// the latest sample of each counter, identified by its name as keyed in
+// the result, see benchmarkconn.CounterNames, until the run ends.
+func (e *Exporter) StartRun(ctx context.Context, name string, counters ...benchmarkconn.Counter) (context.Context, *Run, error) {
+	ctx, span := e.tracer.Start(ctx, name)
+	run := &Run{ctx: ctx, tracer: e.tracer, span: span}
+
+	if len(counters) > 0 {
+		names := benchmarkconn.CounterNames(counters...)
+		registration, err := e.meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+			for i, counter := range counters {
+				for field, value := range latestSample(counter) {
+					attrs := []attribute.KeyValue{
+						attribute.String("benchmark", name),
+						attribute.String("counter", names[i]),
+					}
+					if field != "" {
+						attrs = append(attrs, attribute.String("field", field))
+					}
+					o.ObserveFloat64(e.counter, value, metric.WithAttributes(attrs...))
+				}
+			}
+			return nil
+		}, e.counter)
+		if err != nil {
+			span.End()
+			return nil, nil, err
+		}
+		run.registration = registration
+	}
+
+	return ctx, run, nil
+}
+
+// SetAttributes sets additional attributes of the span of the run, e.g.,
+// describing how it was configured.
+func (r *Run) SetAttributes(attrs ...attribute.KeyValue) {
+	r.span.SetAttributes(attrs...)
+}
+
+// End ends the run with its result, scalar values of which are set as
+// attributes of the span, and the error it failed with if any. Phases
+// listed under "phase_boundaries" are added as child spans.
+func (r *Run) End(result map[string]any, err error) {
+	if r.registration != nil {
+		r.registration.Unregister()
+	}
+
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if attr, ok := resultAttribute("benchmark."+key, result[key]); ok {
+			r.span.SetAttributes(attr)
+		}
+	}
+
+	r.addPhases(result["phase_boundaries"])
+
+	if err != nil {
+		r.span.RecordError(err)
+		r.span.SetStatus(codes.Error, err.Error())
+	}
+	r.span.End()
+}
+
+// addPhases adds a child span for each phase boundary of the result.
+func (r *Run) addPhases(boundaries any) {
+	list, ok := boundaries.([]map[string]any)
+	if !ok {
+		return
+	}
+
+	for _, boundary := range list {
+		phase, _ := boundary["phase"].(string)
+		startStr, _ := boundary["start_time"].(string)
+		endStr, _ := boundary["end_time"].(string)
+		start, err := time.Parse(time.RFC3339Nano, startStr)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339Nano, endStr)
+		if err != nil {
+			continue
+		}
+
+		_, span := r.tracer.Start(r.ctx, phase, trace.WithTimestamp(start))
+		span.End(trace.WithTimestamp(end))
+	}
+}
+
+// resultAttribute converts a scalar result value to an attribute. Nested
+// values, e.g., the results of each connection, are skipped.
+func resultAttribute(key string, value any) (attribute.KeyValue, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		return attribute.Bool(key, v.Bool()), true
+	case reflect.String:
+		return attribute.String(key, v.String()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return attribute.Int64(key, v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return attribute.Int64(key, int64(min(v.Uint(), math.MaxInt64))), true
+	case reflect.Float32, reflect.Float64:
+		return attribute.Float64(key, v.Float()), true
+	default:
+		return attribute.KeyValue{}, false
+	}
+}
+
+// latestSample returns the numeric values of the latest sample of the
+// counter, keyed by field for samples with multiple fields and by the
+// empty string otherwise.
+func latestSample(counter benchmarkconn.Counter) map[string]float64 {
+	var latest time.Time
+	var sample any
+	for t, value := range counter.Result() {
+		if t.After(latest) {
+			latest, sample = t, value
+		}
+	}
+
+	values := make(map[string]float64)
+	switch sample := sample.(type) {
+	case map[string]any:
+		for field, value := range sample {
+			if f, ok := toFloat64(value); ok {
+				values[field] = f
+			}
+		}
+	default:
+		if f, ok := toFloat64(sample); ok {
+			values[""] = f
+		}
+	}
+	return values
+}
+
+func toFloat64(value any) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
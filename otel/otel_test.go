@@ -0,0 +1,98 @@
+package otel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gaukas/benchmarkconn"
+	. "github.com/gaukas/benchmarkconn/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// sampleCounter reports a single fixed sample.
+type sampleCounter struct {
+	*benchmarkconn.CounterBase
+}
+
+func (c *sampleCounter) Name() string { return "sample" }
+
+func (c *sampleCounter) CountNow() {}
+
+func (c *sampleCounter) Result() map[time.Time]any {
+	return map[time.Time]any{
+		time.Unix(1, 0): map[string]any{"rtt_us": uint32(100)},
+		time.Unix(2, 0): map[string]any{"rtt_us": uint32(200)},
+	}
+}
+
+func TestExporter(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	exporter, err := NewExporter(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &sampleCounter{benchmarkconn.NewCounterBase(time.Second)}
+	_, run, err := exporter.StartRun(context.Background(), "PressuredBenchmark", counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	gauge := metrics.ScopeMetrics[0].Metrics[0].Data.(metricdata.Gauge[float64])
+	if len(gauge.DataPoints) != 1 || gauge.DataPoints[0].Value != 200 {
+		t.Errorf("expected the latest sample of 200, got %v", gauge.DataPoints)
+	} else if name, _ := gauge.DataPoints[0].Attributes.Value("counter"); name.AsString() != "sample" {
+		t.Errorf("expected the counter to be named sample, got %v", name.AsString())
+	}
+
+	run.End(map[string]any{
+		"successful_writes": uint64(1000),
+		"throughput_bps":    8e8,
+		"connections":       []map[string]any{{}},
+		"phase_boundaries": []map[string]any{{
+			"phase":      "pressure",
+			"start_time": "2026-01-01T00:00:00.5Z",
+			"end_time":   "2026-01-01T00:00:01.5Z",
+		}},
+	}, errors.New("failed"))
+
+	ended := spans.Ended()
+	if len(ended) != 2 || ended[0].Name() != "pressure" || ended[1].Name() != "PressuredBenchmark" {
+		t.Fatalf("expected a span for the phase and one for the run, got %v", ended)
+	}
+	if ended[0].Parent().SpanID() != ended[1].SpanContext().SpanID() {
+		t.Errorf("expected the span of the phase to be a child of the span of the run")
+	}
+	if duration := ended[0].EndTime().Sub(ended[0].StartTime()); duration != time.Second {
+		t.Errorf("expected the span of the phase to last 1s, got %s", duration)
+	}
+	ended = ended[1:]
+	attrs := attribute.NewSet(ended[0].Attributes()...)
+	if v, ok := attrs.Value("benchmark.successful_writes"); !ok || v.AsInt64() != 1000 {
+		t.Errorf("expected 1000 successful writes, got %v", v)
+	}
+	if v, ok := attrs.Value("benchmark.throughput_bps"); !ok || v.AsFloat64() != 8e8 {
+		t.Errorf("expected a throughput of 8e8, got %v", v)
+	}
+	if _, ok := attrs.Value("benchmark.connections"); ok {
+		t.Errorf("expected nested results to be skipped")
+	}
+	if ended[0].Status().Code != codes.Error {
+		t.Errorf("expected an error status, got %v", ended[0].Status())
+	}
+}
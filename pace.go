@@ -0,0 +1,41 @@
+package benchmarkconn
+
+import "time"
+
+// pacingBurst is how much of the rate a token bucket may accumulate while
+// idle, so that coarse sleeps do not cap the rate below the target.
+const pacingBurst = 10 * time.Millisecond
+
+// tokenBucket paces writes to a rate in bytes per second, allowing bursts
+// of up to pacingBurst worth of bytes or a single message, whichever is
+// larger. It is not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full token bucket for messages of up to
+// messageSize bytes.
+func newTokenBucket(rate uint64, messageSize int) *tokenBucket {
+	burst := max(float64(rate)*pacingBurst.Seconds(), float64(messageSize))
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be written.
+func (t *tokenBucket) wait(n int) {
+	now := time.Now()
+	t.tokens = min(t.tokens+now.Sub(t.last).Seconds()*t.rate, t.burst)
+	t.last = now
+
+	t.tokens -= float64(n)
+	if t.tokens < 0 { // in debt, sleep until repaid
+		time.Sleep(time.Duration(-t.tokens / t.rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,200 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ParallelBenchmark runs the same benchmark concurrently over multiple
+// connections and aggregates the results, similar to iperf3 -P. Single
+// stream results frequently understate what a transport can do.
+//
+// ParallelBenchmark does not implement Benchmark since it operates on
+// multiple connections at once.
+type ParallelBenchmark struct {
+	// New creates the benchmark to run on each connection. All benchmarks
+	// created must be configured identically on both sides.
+	New func() Benchmark
+
+	// Control, if set, stops all connections promptly once the run is
+	// aborted. The benchmarks created by New keep their handshakes in-band,
+	// which would otherwise interleave on the control channel.
+	Control *ControlChannel
+
+	// Profile selects the local resource footprint of the aggregated
+	// result, e.g., integer-only allocation statistics with
+	// ProfileConstrained. It should match the Profile of the benchmarks
+	// created by New.
+	Profile Profile
+
+	benchmarks []Benchmark
+	startTime  atomic.Value
+	endTime    atomic.Value
+
+	schedLatency    schedLatencyRecorder
+	allocs          allocRecorder
+	combinedCounter *CombinedCounter
+}
+
+// Writer runs the writer side of the benchmark on every connection.
+func (p *ParallelBenchmark) Writer(conns []net.Conn, counters ...Counter) error {
+	return p.run(conns, counters, Benchmark.Writer)
+}
+
+// Reader runs the reader side of the benchmark on every connection.
+func (p *ParallelBenchmark) Reader(conns []net.Conn, counters ...Counter) error {
+	return p.run(conns, counters, Benchmark.Reader)
+}
+
+func (p *ParallelBenchmark) run(conns []net.Conn, counters []Counter, side func(Benchmark, net.Conn, ...Counter) error) (err error) {
+	if p.New == nil {
+		return errors.New("ParallelBenchmark requires New to be set")
+	}
+	if len(conns) == 0 {
+		return errors.New("ParallelBenchmark requires at least one connection")
+	}
+
+	p.benchmarks = make([]Benchmark, len(conns))
+	for i := range p.benchmarks {
+		p.benchmarks[i] = p.New()
+	}
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O errors
+	for _, conn := range conns {
+		defer p.Control.watchAbort(conn)()
+	}
+	defer func() {
+		if abortErr := p.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
+
+	// Create combined counter, shared by all connections
+	p.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	p.schedLatency.startRecording()
+	defer p.schedLatency.stopRecording()
+	p.allocs.startRecording()
+	defer p.allocs.stopRecording()
+	p.startTime.Store(time.Now())
+	defer func() {
+		p.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if p.combinedCounter != nil {
+		p.combinedCounter.Start()
+		defer p.combinedCounter.Stop()
+	}
+
+	errs := make([]error, len(conns))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer wg.Done()
+			if err := side(p.benchmarks[i], conn); err != nil {
+				errs[i] = fmt.Errorf("connection %d: %w", i, err)
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Result returns the aggregated result of all connections, with the
+// result of each individual connection listed under "connections".
+func (p *ParallelBenchmark) Result() map[string]any {
+	if p.endTime.Load() == nil || p.endTime.Load().(time.Time).IsZero() {
+		return map[string]any{}
+	}
+
+	result := map[string]any{
+		"parallel":   len(p.benchmarks),
+		"start_time": p.startTime.Load().(time.Time).Format(time.RFC3339),
+		"end_time":   p.endTime.Load().(time.Time).Format(time.RFC3339),
+		"duration":   p.endTime.Load().(time.Time).Sub(p.startTime.Load().(time.Time)).String(),
+	}
+
+	var successfulReads, successfulWrites, bytesRead, bytesWritten uint64
+	var opsPerSecond, throughput, latencySum float64
+	var latencyCount int
+	connections := make([]map[string]any, len(p.benchmarks))
+	for i, benchmark := range p.benchmarks {
+		connections[i] = benchmark.Result()
+
+		if v, ok := connections[i]["successful_reads"].(uint64); ok {
+			successfulReads += v
+		}
+		if v, ok := connections[i]["successful_writes"].(uint64); ok {
+			successfulWrites += v
+		}
+		if v, ok := connections[i]["bytes_read"].(uint64); ok {
+			bytesRead += v
+		}
+		if v, ok := connections[i]["bytes_written"].(uint64); ok {
+			bytesWritten += v
+		}
+		if v, ok := toFloat64(connections[i]["ops_per_s"]); ok {
+			opsPerSecond += v
+		}
+		if v, ok := toFloat64(connections[i]["throughput_bps"]); ok {
+			throughput += v
+		}
+		if v, ok := toFloat64(connections[i]["latency_ns"]); ok {
+			latencySum += v
+			latencyCount++
+		}
+	}
+
+	result["successful_reads"] = successfulReads
+	result["successful_writes"] = successfulWrites
+	result["bytes_read"] = bytesRead
+	result["bytes_written"] = bytesWritten
+	if opsPerSecond > 0 {
+		result["ops_per_s"] = opsPerSecond // sum over all connections
+	}
+	if throughput > 0 {
+		result["throughput_bps"] = throughput // sum over all connections
+		result["throughput_Mbps"] = throughput / 1e6
+	}
+	if latencyCount > 0 {
+		result["latency_ns"] = latencySum / float64(latencyCount) // mean over all connections
+	}
+	result["connections"] = connections
+
+	p.schedLatency.addResult(result)
+	p.allocs.addResult(result, successfulReads+successfulWrites, p.Profile)
+
+	p.Control.addAbortResult(result)
+
+	if p.combinedCounter != nil {
+		result["counters"] = p.combinedCounter.Results()
+	}
+
+	return result
+}
+
+// toFloat64 converts numeric result values, which may be integers with
+// ProfileConstrained, to float64.
+func toFloat64(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case uint64:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
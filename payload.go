@@ -0,0 +1,181 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	crand "crypto/rand"
+)
+
+// PayloadGenerator generates the payload of each message sent by a
+// benchmark, so transports whose performance depends on payload entropy
+// (compression, DPI-evasion transports) can be tested with realistic data.
+//
+// Implementations must be safe for concurrent use, since the same
+// generator may be shared by benchmarks running in parallel.
+type PayloadGenerator interface {
+	// Fill fills p with the payload of the next message.
+	Fill(p []byte)
+}
+
+// PayloadGeneratorFunc adapts an ordinary function to a PayloadGenerator.
+type PayloadGeneratorFunc func(p []byte)
+
+func (f PayloadGeneratorFunc) Fill(p []byte) {
+	f(p)
+}
+
+// RandomPayload returns a generator filling each message from crypto/rand,
+// which is the default behavior of all benchmarks.
+func RandomPayload() PayloadGenerator {
+	return PayloadGeneratorFunc(func(p []byte) {
+		crand.Read(p)
+	})
+}
+
+// DefaultPayloadCorpusSize is the size of the corpus of CorpusPayload if
+// not set, large enough that compressors and deduplicating transports do
+// not notice it repeating.
+const DefaultPayloadCorpusSize = 4 << 20
+
+// CorpusPayload returns a generator filling each message from a corpus of
+// size random bytes, DefaultPayloadCorpusSize if 0, pre-generated from
+// crypto/rand once. Each message is copied from where the previous one
+// ended, wrapping around, so payloads look random to the transport while
+// costing a copy rather than a call to crypto/rand per message, which
+// becomes the bottleneck beyond a million messages per second. Use
+// RandomPayload where payloads must never repeat.
+func CorpusPayload(size int) (PayloadGenerator, error) {
+	if size < 0 {
+		return nil, errors.New("payload corpus size must not be negative")
+	}
+	if size == 0 {
+		size = DefaultPayloadCorpusSize
+	}
+
+	corpus := make([]byte, size)
+	crand.Read(corpus)
+	var next atomic.Uint64
+	return PayloadGeneratorFunc(func(p []byte) {
+		end := next.Add(uint64(len(p)))
+		offset := int((end - uint64(len(p))) % uint64(len(corpus)))
+		for n := 0; n < len(p); offset = 0 {
+			n += copy(p[n:], corpus[offset:])
+		}
+	}), nil
+}
+
+// ZeroPayload returns a generator filling each message with zeroes.
+func ZeroPayload() PayloadGenerator {
+	return PayloadGeneratorFunc(func(p []byte) {
+		clear(p)
+	})
+}
+
+// PatternPayload returns a generator filling each message by repeating
+// pattern from the start of the message.
+func PatternPayload(pattern []byte) (PayloadGenerator, error) {
+	if len(pattern) == 0 {
+		return nil, errors.New("payload pattern must not be empty")
+	}
+
+	pattern = append([]byte(nil), pattern...) // copy to prevent modification by the caller
+	return PayloadGeneratorFunc(func(p []byte) {
+		for i := 0; i < len(p); i += len(pattern) {
+			copy(p[i:], pattern)
+		}
+	}), nil
+}
+
+// compressibleBlockSize is the size of the blocks mixing random bytes and
+// zeroes in CompressiblePayload. It is small enough that any compressor
+// sees a uniform mix rather than one random and one zero region.
+const compressibleBlockSize = 64
+
+// CompressiblePayload returns a generator whose messages compress by
+// roughly the given ratio (e.g., 4 for a 4:1 compression ratio), by
+// mixing random bytes and zeroes in every block of 64 bytes.
+func CompressiblePayload(ratio float64) (PayloadGenerator, error) {
+	if ratio < 1 {
+		return nil, errors.New("payload compression ratio must be at least 1")
+	}
+
+	randomPerBlock := int(float64(compressibleBlockSize) / ratio)
+	if randomPerBlock < 1 {
+		randomPerBlock = 1
+	}
+	return PayloadGeneratorFunc(func(p []byte) {
+		for i := 0; i < len(p); i += compressibleBlockSize {
+			block := p[i:min(i+compressibleBlockSize, len(p))]
+			n := min(randomPerBlock, len(block))
+			crand.Read(block[:n])
+			clear(block[n:])
+		}
+	}), nil
+}
+
+// ParsePayloadGenerator parses a payload generator specification as used
+// by the command line tools:
+//
+//   - "random" (or an empty string) for RandomPayload
+//   - "zero" for ZeroPayload
+//   - "pattern:<text>" or "pattern:0x<hex>" for PatternPayload
+//   - "compressible:<ratio>" for CompressiblePayload
+//   - "corpus" or "corpus:<size>" for CorpusPayload
+func ParsePayloadGenerator(spec string) (PayloadGenerator, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "random":
+		return RandomPayload(), nil
+	case "zero":
+		return ZeroPayload(), nil
+	case "pattern":
+		if hexPattern, ok := strings.CutPrefix(arg, "0x"); ok {
+			pattern, err := hex.DecodeString(hexPattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex payload pattern: %w", err)
+			}
+			return PatternPayload(pattern)
+		}
+		return PatternPayload([]byte(arg))
+	case "compressible":
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload compression ratio: %w", err)
+		}
+		return CompressiblePayload(ratio)
+	case "corpus":
+		if arg == "" {
+			return CorpusPayload(0)
+		}
+		size, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload corpus size: %w", err)
+		}
+		return CorpusPayload(size)
+	default:
+		return nil, fmt.Errorf("unknown payload generator %q", kind)
+	}
+}
+
+// payloadGenerator returns generator, or RandomPayload if it is nil.
+func payloadGenerator(generator PayloadGenerator) PayloadGenerator {
+	if generator == nil {
+		return RandomPayload()
+	}
+	return generator
+}
+
+// stampSequence writes the sequence number into the first bytes of msg,
+// so that messages from low-entropy generators remain distinguishable
+// when matching echoes.
+func stampSequence(msg []byte, seq uint64) {
+	var stamp [8]byte
+	binary.BigEndian.PutUint64(stamp[:], seq)
+	copy(msg, stamp[:])
+}
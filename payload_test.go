@@ -0,0 +1,106 @@
+package benchmarkconn_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestParsePayloadGenerator(t *testing.T) {
+	msg := make([]byte, 10)
+
+	zero, err := ParsePayloadGenerator("zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg[0] = 1
+	zero.Fill(msg)
+	if !bytes.Equal(msg, make([]byte, 10)) {
+		t.Errorf("zero payload: got %x", msg)
+	}
+
+	pattern, err := ParsePayloadGenerator("pattern:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern.Fill(msg)
+	if string(msg) != "abcabcabca" {
+		t.Errorf("pattern payload: got %q", msg)
+	}
+
+	hexPattern, err := ParsePayloadGenerator("pattern:0xff00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hexPattern.Fill(msg)
+	if !bytes.Equal(msg, []byte{0xff, 0, 0xff, 0, 0xff, 0, 0xff, 0, 0xff, 0}) {
+		t.Errorf("hex pattern payload: got %x", msg)
+	}
+
+	for _, spec := range []string{"pattern:", "pattern:0xzz", "compressible:0.5", "compressible:x", "corpus:-1", "corpus:x", "unknown"} {
+		if _, err := ParsePayloadGenerator(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestCompressiblePayload(t *testing.T) {
+	const size = 1 << 20
+
+	for _, ratio := range []float64{1, 4, 16} {
+		generator, err := CompressiblePayload(ratio)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := make([]byte, size)
+		generator.Fill(msg)
+
+		var compressed bytes.Buffer
+		w, _ := flate.NewWriter(&compressed, flate.BestCompression)
+		w.Write(msg)
+		w.Close()
+
+		achieved := float64(size) / float64(compressed.Len())
+		t.Logf("ratio %v: achieved %.2f", ratio, achieved)
+		if achieved < ratio*0.5 || achieved > ratio*2 {
+			t.Errorf("ratio %v: achieved compression ratio %.2f is too far off", ratio, achieved)
+		}
+	}
+}
+
+func TestCorpusPayload(t *testing.T) {
+	const corpusSize = 1000
+
+	generator, err := CorpusPayload(corpusSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// consecutive messages are consecutive slices of the corpus, wrapping
+	// around once it is exhausted
+	var stream []byte
+	for i := 0; i < 7; i++ {
+		msg := make([]byte, 300)
+		generator.Fill(msg)
+		stream = append(stream, msg...)
+	}
+	if bytes.Equal(stream[:300], stream[300:600]) {
+		t.Error("expected consecutive messages to differ")
+	}
+	if !bytes.Equal(stream[:corpusSize], stream[corpusSize:2*corpusSize]) {
+		t.Error("expected the corpus to repeat once exhausted")
+	}
+	if bytes.Equal(stream[:corpusSize], make([]byte, corpusSize)) {
+		t.Error("expected a random corpus")
+	}
+
+	// messages larger than the corpus are filled by repeating it
+	large := make([]byte, 2*corpusSize+1)
+	generator.Fill(large)
+	if !bytes.Equal(large[:corpusSize], large[corpusSize:2*corpusSize]) {
+		t.Error("expected a large message to repeat the corpus")
+	}
+}
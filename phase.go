@@ -0,0 +1,205 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is a phase of a PhasedBenchmark.
+type Phase struct {
+	// Name identifies the phase in the result, defaulting to the type of
+	// the benchmark.
+	Name string
+
+	// Benchmark is the benchmark run in this phase. It must be configured
+	// identically on both sides.
+	Benchmark Benchmark
+
+	// Reverse swaps the roles of the peers in this phase, i.e., the side
+	// running the Writer of the PhasedBenchmark runs the Reader of the
+	// phase and vice versa.
+	Reverse bool
+}
+
+// phaseDoneMarker is exchanged between phases, so that neither side
+// starts the next phase while the peer may still be reading the previous
+// one, e.g., the echoes of an IntervalBenchmark.
+var phaseDoneMarker = []byte("benchmarkconn:phase-done\n")
+
+// PhasedBenchmark runs multiple benchmarks back-to-back over a single
+// connection, so that effects depending on the state of the connection,
+// e.g., a warm congestion window, can be studied.
+//
+// Counters are run across all phases, since they can not be restarted.
+type PhasedBenchmark struct {
+	Phases []Phase
+
+	completed       int
+	boundaries      []PhaseBoundary
+	startTime       atomic.Value
+	endTime         atomic.Value
+	combinedCounter *CombinedCounter
+}
+
+// Writer runs all phases, as the writer of the phases not reversed.
+func (p *PhasedBenchmark) Writer(conn net.Conn, counters ...Counter) error {
+	return p.run(conn, counters, false)
+}
+
+// Reader runs all phases, as the reader of the phases not reversed.
+func (p *PhasedBenchmark) Reader(conn net.Conn, counters ...Counter) error {
+	return p.run(conn, counters, true)
+}
+
+func (p *PhasedBenchmark) run(conn net.Conn, counters []Counter, reverse bool) error {
+	if len(p.Phases) == 0 {
+		return errors.New("PhasedBenchmark requires at least one phase")
+	}
+	for i, phase := range p.Phases {
+		if phase.Benchmark == nil {
+			return fmt.Errorf("phase %d has no benchmark", i)
+		}
+	}
+
+	// Create combined counter, shared by all phases
+	p.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	p.completed = 0
+	p.boundaries = nil
+	p.startTime.Store(time.Now())
+	defer func() {
+		p.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if p.combinedCounter != nil {
+		p.combinedCounter.Start()
+		defer p.combinedCounter.Stop()
+	}
+
+	for i, phase := range p.Phases {
+		write := phase.Reverse == reverse
+		phaseStart := time.Now()
+		var err error
+		if write {
+			err = phase.Benchmark.Writer(conn)
+		} else {
+			err = phase.Benchmark.Reader(conn)
+		}
+		p.boundaries = append(p.boundaries, PhaseBoundary{
+			Phase: p.phaseName(i),
+			Start: phaseStart,
+			End:   time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("phase %d (%s): %w", i, p.phaseName(i), err)
+		}
+		p.completed++
+
+		if i == len(p.Phases)-1 {
+			break
+		}
+
+		// benchmarks may leave deadlines behind, e.g., when waiting for echoes
+		conn.SetDeadline(time.Time{})
+
+		if err := syncPhase(conn, write); err != nil {
+			return fmt.Errorf("phase %d (%s): %w", i, p.phaseName(i), err)
+		}
+	}
+
+	return nil
+}
+
+// syncPhase exchanges phaseDoneMarker with the peer. The writer of the
+// phase sends first, since the reader has nothing left to read by then.
+func syncPhase(conn net.Conn, write bool) error {
+	if write {
+		if _, err := conn.Write(phaseDoneMarker); err != nil {
+			return err
+		}
+		return readPhaseDone(conn)
+	}
+
+	if err := readPhaseDone(conn); err != nil {
+		return err
+	}
+	_, err := conn.Write(phaseDoneMarker)
+	return err
+}
+
+func readPhaseDone(conn net.Conn) error {
+	marker := make([]byte, len(phaseDoneMarker))
+	if _, err := io.ReadFull(conn, marker); err != nil {
+		return fmt.Errorf("failed to read the end of the phase: %w", err)
+	}
+	if !bytes.Equal(marker, phaseDoneMarker) {
+		return errors.New("peer is out of sync at the end of the phase")
+	}
+	return nil
+}
+
+func (p *PhasedBenchmark) phaseName(i int) string {
+	if p.Phases[i].Name != "" {
+		return p.Phases[i].Name
+	}
+	return benchmarkType(p.Phases[i].Benchmark)
+}
+
+// PhaseBoundary is when a phase started and ended, on the same clock as
+// the samples of the counters, so that their timelines can be segmented.
+type PhaseBoundary struct {
+	Phase string
+	Start time.Time
+	End   time.Time
+}
+
+// Boundaries returns the boundaries of each phase run, including a
+// failed one.
+func (p *PhasedBenchmark) Boundaries() []PhaseBoundary {
+	return p.boundaries
+}
+
+// Result returns the result of each completed phase listed under
+// "phases", with the name of the phase under "phase", and the boundaries
+// of the phases under "phase_boundaries" with nanosecond precision.
+func (p *PhasedBenchmark) Result() map[string]any {
+	if p.endTime.Load() == nil || p.endTime.Load().(time.Time).IsZero() {
+		return map[string]any{}
+	}
+
+	result := map[string]any{
+		"start_time": p.startTime.Load().(time.Time).Format(time.RFC3339),
+		"end_time":   p.endTime.Load().(time.Time).Format(time.RFC3339),
+		"duration":   p.endTime.Load().(time.Time).Sub(p.startTime.Load().(time.Time)).String(),
+	}
+
+	phases := make([]map[string]any, p.completed)
+	for i := range phases {
+		phases[i] = p.Phases[i].Benchmark.Result()
+		phases[i]["phase"] = p.phaseName(i)
+	}
+	result["phases"] = phases
+
+	boundaries := make([]map[string]any, len(p.boundaries))
+	for i, boundary := range p.boundaries {
+		boundaries[i] = map[string]any{
+			"phase":      boundary.Phase,
+			"start_time": boundary.Start.Format(time.RFC3339Nano),
+			"end_time":   boundary.End.Format(time.RFC3339Nano),
+		}
+	}
+	result["phase_boundaries"] = boundaries
+
+	if p.combinedCounter != nil {
+		result["counters"] = p.combinedCounter.Results()
+	}
+
+	return result
+}
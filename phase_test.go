@@ -0,0 +1,50 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPhasedBenchmark(t *testing.T) {
+	newPhased := func() *PhasedBenchmark {
+		return &PhasedBenchmark{
+			Phases: []Phase{
+				{Benchmark: &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000}},
+				{Benchmark: &IntervalBenchmark{MessageSize: 1024, TotalMessages: 100, Interval: 100 * time.Microsecond, Echo: true}},
+				{Name: "pressure-reverse", Benchmark: &PressuredBenchmark{MessageSize: 512, TotalMessages: 1000}, Reverse: true},
+			},
+		}
+	}
+
+	writer, reader := newPhased(), newPhased()
+	runOverTCP(t, writer, reader)
+
+	phases, ok := writer.Result()["phases"].([]map[string]any)
+	if !ok || len(phases) != 3 {
+		t.Fatalf("expected 3 phases, got %v", writer.Result()["phases"])
+	}
+	if phases[0]["phase"] != "pressure" || phases[1]["phase"] != "echo" || phases[2]["phase"] != "pressure-reverse" {
+		t.Errorf("unexpected phase names %v, %v, %v", phases[0]["phase"], phases[1]["phase"], phases[2]["phase"])
+	}
+	boundaries := writer.Boundaries()
+	if len(boundaries) != 3 || boundaries[2].Phase != "pressure-reverse" {
+		t.Fatalf("expected 3 phase boundaries, got %v", boundaries)
+	}
+	for i, boundary := range boundaries {
+		if boundary.End.Before(boundary.Start) || (i > 0 && boundary.Start.Before(boundaries[i-1].End)) {
+			t.Errorf("expected phase %d to start after the previous one ended and end after it started, got %v", i, boundary)
+		}
+	}
+
+	if writes := phases[0]["successful_writes"]; writes != uint64(1000) {
+		t.Errorf("expected 1000 successful writes in the first phase, got %v", writes)
+	}
+	if _, ok := phases[1]["latency_ns"]; !ok {
+		t.Errorf("expected the latency of echoes in the second phase, got %v", phases[1])
+	}
+	if reads := phases[2]["successful_reads"]; reads != uint64(1000) {
+		t.Errorf("expected 1000 successful reads in the reversed phase, got %v", reads)
+	}
+}
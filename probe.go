@@ -0,0 +1,233 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// TinyWriteProbe is a benchmark that mixes zero-length and 1-byte writes
+// with normal sized messages and verifies the peer sees exactly the
+// expected byte stream. It reports transports that drop, merge or mangle
+// such writes, which is a common source of subtle bugs in framed transports.
+//
+// Each round consists of a zero-length write, a 1-byte write and a write
+// of MessageSize bytes. The content of the stream is deterministic so the
+// reader is able to verify every byte without any additional framing.
+type TinyWriteProbe struct {
+	MessageSize int    `json:"message_size" yaml:"message_size"` // MessageSize defines how many bytes to write for each normal message
+	Rounds      uint64 `json:"rounds" yaml:"rounds"`             // Rounds defines how many rounds of zero-length, 1-byte and normal writes to perform
+
+	messageSize int // an internal copy of the message size used in the last run
+
+	// writer side
+	zeroLengthWrites       atomic.Uint64
+	zeroLengthWriteErrors  atomic.Uint64 // zero-length writes which returned an error
+	zeroLengthWriteNonZero atomic.Uint64 // zero-length writes which claimed to have written something
+	tinyWrites             atomic.Uint64
+	normalWrites           atomic.Uint64
+
+	// reader side
+	reads           atomic.Uint64
+	zeroLengthReads atomic.Uint64 // reads returning 0 bytes without an error
+	singleByteReads atomic.Uint64 // reads returning exactly 1 byte, i.e., tiny writes not merged
+	bytesReceived   atomic.Uint64
+	mangledBytes    atomic.Uint64
+
+	startTime atomic.Value
+	endTime   atomic.Value
+
+	versions        versionRecorder
+	combinedCounter *CombinedCounter
+}
+
+// tinyWriteProbeByteAt returns the expected byte at the given offset of
+// the probe stream. 251 is prime so the pattern never aligns with common
+// power-of-two message sizes.
+func tinyWriteProbeByteAt(offset uint64) byte {
+	return byte(offset % 251)
+}
+
+func (p *TinyWriteProbe) expectedBytes() uint64 {
+	return p.Rounds * uint64(1+p.messageSize)
+}
+
+func (p *TinyWriteProbe) reset() {
+	p.messageSize = p.MessageSize
+	p.zeroLengthWrites.Store(0)
+	p.zeroLengthWriteErrors.Store(0)
+	p.zeroLengthWriteNonZero.Store(0)
+	p.tinyWrites.Store(0)
+	p.normalWrites.Store(0)
+	p.reads.Store(0)
+	p.zeroLengthReads.Store(0)
+	p.singleByteReads.Store(0)
+	p.bytesReceived.Store(0)
+	p.mangledBytes.Store(0)
+}
+
+func (p *TinyWriteProbe) Writer(conn net.Conn, counters ...Counter) error {
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshake(conn, p)
+	if err != nil {
+		return err
+	}
+	p.versions.record(peer)
+
+	// Create combined counter
+	p.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Probe starts
+	p.reset()
+	p.startTime.Store(time.Now())
+	defer func() {
+		p.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if p.combinedCounter != nil {
+		p.combinedCounter.Start()
+		defer p.combinedCounter.Stop()
+	}
+
+	var offset uint64
+	var msg = make([]byte, p.messageSize)
+	var i uint64
+	for i = 0; i < p.Rounds; i++ {
+		// zero-length write: must not fail and must not claim to write anything
+		n, err := conn.Write([]byte{})
+		p.zeroLengthWrites.Add(1)
+		if err != nil {
+			p.zeroLengthWriteErrors.Add(1)
+		} else if n != 0 {
+			p.zeroLengthWriteNonZero.Add(1)
+		}
+
+		// 1-byte write
+		if _, err := conn.Write([]byte{tinyWriteProbeByteAt(offset)}); err != nil {
+			return err
+		}
+		offset++
+		p.tinyWrites.Add(1)
+
+		// normal write
+		for j := range msg {
+			msg[j] = tinyWriteProbeByteAt(offset + uint64(j))
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		offset += uint64(len(msg))
+		p.normalWrites.Add(1)
+	}
+
+	return nil
+}
+
+func (p *TinyWriteProbe) Reader(conn net.Conn, counters ...Counter) error {
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshake(conn, p)
+	if err != nil {
+		return err
+	}
+	p.versions.record(peer)
+
+	// Create combined counter
+	p.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Probe starts
+	p.reset()
+	p.startTime.Store(time.Now())
+	defer func() {
+		p.endTime.Store(time.Now())
+	}()
+
+	// Start the counter
+	if p.combinedCounter != nil {
+		p.combinedCounter.Start()
+		defer p.combinedCounter.Stop()
+	}
+
+	var offset uint64
+	var expected = p.expectedBytes()
+	var buf = make([]byte, p.messageSize+1)
+	for offset < expected {
+		n, err := conn.Read(buf)
+		p.reads.Add(1)
+		if n == 0 && err == nil {
+			p.zeroLengthReads.Add(1)
+		}
+		if n == 1 {
+			p.singleByteReads.Add(1)
+		}
+
+		for j := 0; j < n; j++ {
+			if buf[j] != tinyWriteProbeByteAt(offset+uint64(j)) {
+				p.mangledBytes.Add(1)
+			}
+		}
+		offset += uint64(n)
+		p.bytesReceived.Add(uint64(n))
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *TinyWriteProbe) Result() map[string]any {
+	if p.endTime.Load() == nil || p.endTime.Load().(time.Time).IsZero() {
+		return map[string]any{}
+	}
+
+	result := map[string]any{
+		"start_time": p.startTime.Load().(time.Time).Format(time.RFC3339),
+		"end_time":   p.endTime.Load().(time.Time).Format(time.RFC3339),
+		"duration":   p.endTime.Load().(time.Time).Sub(p.startTime.Load().(time.Time)).String(),
+	}
+
+	// Writer only
+	if p.zeroLengthWrites.Load() > 0 {
+		result["zero_length_writes"] = p.zeroLengthWrites.Load()
+		result["zero_length_write_errors"] = p.zeroLengthWriteErrors.Load()
+		result["zero_length_write_nonzero"] = p.zeroLengthWriteNonZero.Load()
+		result["tiny_writes"] = p.tinyWrites.Load()
+		result["normal_writes"] = p.normalWrites.Load()
+	}
+
+	// Reader only
+	if p.reads.Load() > 0 {
+		expected := p.expectedBytes()
+		received := p.bytesReceived.Load()
+
+		result["reads"] = p.reads.Load()
+		result["zero_length_reads"] = p.zeroLengthReads.Load()
+		result["single_byte_reads"] = p.singleByteReads.Load()
+		result["bytes_expected"] = expected
+		result["bytes_received"] = received
+		result["mangled_bytes"] = p.mangledBytes.Load()
+		if received < expected {
+			result["dropped_bytes"] = expected - received
+		} else {
+			result["dropped_bytes"] = uint64(0)
+		}
+		if received > expected {
+			result["extra_bytes"] = received - expected
+		}
+		result["passed"] = received == expected && p.mangledBytes.Load() == 0
+	}
+
+	p.versions.addResult(result)
+	if p.combinedCounter != nil {
+		result["counters"] = p.combinedCounter.Results()
+	}
+
+	return result
+}
@@ -0,0 +1,68 @@
+package benchmarkconn
+
+import "errors"
+
+// Profile selects the trade-off between measurement detail and the
+// resource footprint of the benchmark itself.
+type Profile uint8
+
+const (
+	// ProfileDefault favors measurement detail.
+	ProfileDefault Profile = iota
+
+	// ProfileConstrained is meant for routers, SBCs and other low-power
+	// devices acting as the far endpoint. It uses smaller latency
+	// histograms, reuses message buffers instead of refilling them from
+	// crypto/rand, and reports integer-only stats, so the benchmark does not
+	// distort results through its own overhead.
+	ProfileConstrained
+)
+
+const (
+	constrainedHistogramHighest = 60 * 1000000000 // 1 minute in nanoseconds
+	constrainedHistogramSigFigs = 2               // 1% value precision
+)
+
+// ParseProfile parses the name of a profile.
+func ParseProfile(name string) (Profile, error) {
+	switch name {
+	case "", "default":
+		return ProfileDefault, nil
+	case "constrained":
+		return ProfileConstrained, nil
+	default:
+		return ProfileDefault, errors.New("unknown profile, must be either \"default\" or \"constrained\"")
+	}
+}
+
+func (p Profile) String() string {
+	switch p {
+	case ProfileConstrained:
+		return "constrained"
+	default:
+		return "default"
+	}
+}
+
+// newConstrainedLatencyHistogram returns a histogram with a much smaller
+// footprint than the default one, at the cost of range and precision.
+func newConstrainedLatencyHistogram() *Histogram {
+	h, err := NewHistogram(defaultHistogramLowest, constrainedHistogramHighest, constrainedHistogramSigFigs)
+	if err != nil {
+		panic(err) // should never happen with the constrained values
+	}
+	return h
+}
+
+func (p Profile) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Profile) UnmarshalText(text []byte) error {
+	parsed, err := ParseProfile(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
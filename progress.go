@@ -0,0 +1,154 @@
+package benchmarkconn
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressInterval is how often OnProgress is called if no
+// ProgressInterval is set.
+const DefaultProgressInterval = time.Second
+
+// ProgressSnapshot is the progress of a running benchmark, passed to
+// OnProgress, and sent to the peer over a ControlChannel.
+type ProgressSnapshot struct {
+	Elapsed         time.Duration `json:"elapsed_ns"`       // Elapsed is the time since the benchmark started, excluding the warmup
+	MessagesRead    uint64        `json:"messages_read"`    // MessagesRead counts the messages read, or echoes received by the writer of an echo benchmark
+	MessagesWritten uint64        `json:"messages_written"` // MessagesWritten counts the messages written
+	TotalMessages   uint64        `json:"total_messages"`   // TotalMessages is the number of messages expected, 0 if unknown with TargetDuration
+	ThroughputBps   float64       `json:"throughput_bps"`   // ThroughputBps is the instantaneous throughput in bits per second since the previous snapshot
+	Done            bool          `json:"done,omitempty"`   // Done is set on the final snapshot, once the benchmark stopped
+
+	LatencyPercentiles map[string]int64  `json:"latency_percentiles_ns,omitempty"` // LatencyPercentiles are the percentiles of the latency in nanoseconds since the previous snapshot, keyed like (*Histogram).Percentiles, nil without latencies
+	MessageErrors      map[string]uint64 `json:"message_errors,omitempty"`         // MessageErrors counts the messages failing verification since the previous snapshot by kind, e.g., corrupted or out_of_order, nil without errors
+}
+
+// latencyWindow records the latencies since the last progress snapshot, so
+// that degradation over long runs is not averaged away by the whole run.
+type latencyWindow struct {
+	mutex     sync.Mutex
+	histogram *Histogram
+}
+
+// newLatencyWindow returns a latency window backed by histogram, or nil
+// without a callback to report it to.
+func newLatencyWindow(onProgress func(ProgressSnapshot), histogram *Histogram) *latencyWindow {
+	if onProgress == nil {
+		return nil
+	}
+	return &latencyWindow{histogram: histogram}
+}
+
+func (w *latencyWindow) record(latency int64) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.histogram.Record(latency)
+}
+
+// percentiles returns the percentiles of the latencies recorded since the
+// last call and resets the window, or nil if none were recorded.
+func (w *latencyWindow) percentiles() map[string]int64 {
+	if w == nil {
+		return nil
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.histogram.TotalCount() == 0 {
+		return nil
+	}
+	percentiles := w.histogram.Percentiles()
+	w.histogram.Reset()
+	return percentiles
+}
+
+// progressReporter calls onProgress periodically while a benchmark runs.
+type progressReporter struct {
+	onProgress  func(ProgressSnapshot)
+	messageSize int
+	total       uint64
+	start       time.Time
+	reads       *atomic.Uint64
+	writes      *atomic.Uint64
+	latencies   *latencyWindow
+	errors      *messageErrorRecorder
+
+	lastTime     time.Time
+	lastMessages uint64
+}
+
+// startProgress calls onProgress every interval, DefaultProgressInterval
+// if not set, until the returned stop is called, which reports the final
+// snapshot. It does nothing if onProgress is nil. The snapshots include
+// the latencies recorded in latencies and the message errors recorded in
+// errors, if not nil.
+func startProgress(onProgress func(ProgressSnapshot), interval time.Duration, messageSize int, total uint64, start time.Time, reads, writes *atomic.Uint64, latencies *latencyWindow, errors *messageErrorRecorder) (stop func()) {
+	if onProgress == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+
+	r := &progressReporter{
+		onProgress:  onProgress,
+		messageSize: messageSize,
+		total:       total,
+		start:       start,
+		reads:       reads,
+		writes:      writes,
+		latencies:   latencies,
+		errors:      errors,
+		lastTime:    start,
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				r.report(false)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		wg.Wait() // never call onProgress concurrently
+		r.report(true)
+	}
+}
+
+func (r *progressReporter) report(done bool) {
+	now := time.Now()
+	reads, writes := r.reads.Load(), r.writes.Load()
+
+	var throughput float64
+	if elapsed := now.Sub(r.lastTime); elapsed > 0 {
+		throughput = float64(reads+writes-r.lastMessages) * float64(r.messageSize) * 8 / elapsed.Seconds()
+	}
+	r.lastTime, r.lastMessages = now, reads+writes
+
+	r.onProgress(ProgressSnapshot{
+		Elapsed:         now.Sub(r.start),
+		MessagesRead:    reads,
+		MessagesWritten: writes,
+		TotalMessages:   r.total,
+		ThroughputBps:   throughput,
+		Done:            done,
+
+		LatencyPercentiles: r.latencies.percentiles(),
+		MessageErrors:      r.errors.drain(),
+	})
+}
@@ -0,0 +1,83 @@
+package benchmarkconn_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestProgress(t *testing.T) {
+	var mutex sync.Mutex
+	var snapshots []ProgressSnapshot
+	writer := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 200, Interval: time.Millisecond}
+	reader := &IntervalBenchmark{
+		MessageSize:      1024,
+		TotalMessages:    200,
+		Interval:         time.Millisecond,
+		ProgressInterval: 20 * time.Millisecond,
+		OnProgress: func(snapshot ProgressSnapshot) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			snapshots = append(snapshots, snapshot)
+		},
+	}
+	runOverTCP(t, writer, reader)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(snapshots) < 2 {
+		t.Fatalf("expected periodic snapshots, got %d", len(snapshots))
+	}
+	for i, snapshot := range snapshots[1:] {
+		if snapshot.MessagesRead < snapshots[i].MessagesRead || snapshot.Elapsed < snapshots[i].Elapsed {
+			t.Errorf("expected the progress to increase, got %+v after %+v", snapshot, snapshots[i])
+		}
+	}
+
+	last := snapshots[len(snapshots)-1]
+	if !last.Done || last.MessagesRead != 200 || last.TotalMessages != 200 {
+		t.Errorf("expected a final snapshot of 200 messages read, got %+v", last)
+	}
+	for _, snapshot := range snapshots[:len(snapshots)-1] {
+		if snapshot.Done {
+			t.Errorf("expected only the final snapshot to be done, got %+v", snapshot)
+		}
+	}
+}
+
+func TestProgressLatencyWindow(t *testing.T) {
+	var mutex sync.Mutex
+	var snapshots []ProgressSnapshot
+	writer := &IntervalBenchmark{
+		MessageSize:      1024,
+		TotalMessages:    200,
+		Interval:         time.Millisecond,
+		Echo:             true,
+		ProgressInterval: 20 * time.Millisecond,
+		OnProgress: func(snapshot ProgressSnapshot) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			snapshots = append(snapshots, snapshot)
+		},
+	}
+	reader := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 200, Interval: time.Millisecond, Echo: true}
+	runOverTCP(t, writer, reader)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	windows := 0
+	for _, snapshot := range snapshots {
+		if snapshot.LatencyPercentiles == nil {
+			continue
+		}
+		windows++
+		if p99 := snapshot.LatencyPercentiles["p99"]; p99 <= 0 {
+			t.Errorf("expected a positive p99 latency in the window, got %d", p99)
+		}
+	}
+	if windows < 2 {
+		t.Errorf("expected the latencies of several windows, got %d of %d snapshots", windows, len(snapshots))
+	}
+}
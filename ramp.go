@@ -0,0 +1,444 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	minRampMessageSize = 16 // 8 bytes for the step and 8 bytes for the send time
+	maxRampSteps       = 64 // bounds the ramp if no step ever fails
+	minAchievedRatio   = 0.9
+)
+
+// RampBenchmark finds the maximum sustainable rate of a connection: the
+// writer sends echoed messages at a rate increased step-wise by StepFactor
+// until a step exceeds the latency or loss threshold, then optionally
+// narrows down the maximum rate with a binary search between the last
+// passing and the first failing rate. It automates repeated
+// IntervalBenchmark runs at decreasing intervals.
+//
+// A step fails if the p99 latency of its echoes exceeds LatencyThreshold,
+// if more than LossThreshold of its messages are not echoed within
+// LatencyThreshold after the step, or if the writer could not send at
+// 90% of the target rate.
+type RampBenchmark struct {
+	MessageSize      int           `json:"message_size" yaml:"message_size"`           // MessageSize defines how many bytes to write for each message, at least 16
+	StartRate        float64       `json:"start_rate" yaml:"start_rate"`               // StartRate defines the rate of the first step in messages per second
+	StepFactor       float64       `json:"step_factor" yaml:"step_factor"`             // StepFactor defines how much the rate is multiplied by after each passing step, above 1
+	StepDuration     time.Duration `json:"step_duration" yaml:"step_duration"`         // StepDuration defines how long each step sends messages for
+	MaxRate          float64       `json:"max_rate,omitempty" yaml:"max_rate"`         // MaxRate defines the rate at which the ramp stops if no step failed, 0 for no limit
+	SearchSteps      int           `json:"search_steps,omitempty" yaml:"search_steps"` // SearchSteps defines how many binary search steps are run after the first failing step
+	LatencyThreshold time.Duration `json:"latency_threshold" yaml:"latency_threshold"` // LatencyThreshold defines the p99 latency above which a step fails
+	LossThreshold    float64       `json:"loss_threshold" yaml:"loss_threshold"`       // LossThreshold defines the fraction of messages not echoed in time above which a step fails
+
+	Control *ControlChannel `json:"-" yaml:"-"` // Control carries the handshake instead of the data connection if set, it must be set on both sides
+
+	messageSize      int            // an internal copy of the message size used in the last run
+	socketOptions    map[string]any // the effective socket options at the start of the last run
+	successfulReads  atomic.Uint64
+	successfulWrites atomic.Uint64
+	startTime        atomic.Value
+	endTime          atomic.Value
+
+	stepsMutex  sync.Mutex
+	steps       []*rampStep // used for sender, the steps run so far
+	maxRate     float64     // used for sender, the highest passing rate
+	rampStopped string      // used for sender, why the ramp stopped
+
+	schedLatency    schedLatencyRecorder
+	decorators      decoratorRecorder
+	versions        versionRecorder
+	allocs          allocRecorder
+	combinedCounter *CombinedCounter
+}
+
+// rampStep is a step of the ramp at a target rate.
+type rampStep struct {
+	rate      float64
+	startTime time.Time
+	sendTime  time.Duration // how long sending the messages took
+	sent      uint64
+	echoed    atomic.Uint64
+	latency   *Histogram
+	passed    bool
+	reason    string // why the step failed, if it did
+}
+
+func (b *RampBenchmark) validate() error {
+	if b.MessageSize < minRampMessageSize {
+		return errors.New("ramp requires a message size of at least 16 bytes")
+	}
+	if b.StartRate <= 0 {
+		return errors.New("ramp requires a positive start rate")
+	}
+	if b.StepFactor <= 1 {
+		return errors.New("ramp requires a step factor above 1")
+	}
+	if b.StepDuration <= 0 || b.LatencyThreshold <= 0 {
+		return errors.New("ramp requires a positive step duration and latency threshold")
+	}
+	if b.LossThreshold < 0 || b.LossThreshold >= 1 {
+		return errors.New("ramp requires a loss threshold in [0, 1)")
+	}
+	return nil
+}
+
+func (b *RampBenchmark) Writer(conn net.Conn, counters ...Counter) (err error) {
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	// Compare benchmark specs on both sides
+	peer, err := writerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.messageSize = b.MessageSize
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
+	b.successfulReads.Store(0)
+	b.successfulWrites.Store(0)
+	b.stepsMutex.Lock()
+	b.steps, b.maxRate, b.rampStopped = nil, 0, ""
+	b.stepsMutex.Unlock()
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.startTime.Store(time.Now())
+	defer func() {
+		b.endTime.Store(time.Now())
+	}()
+	startTime := b.startTime.Load().(time.Time)
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	// read echoed messages, attributing each to the step it was sent in
+	echoDone := make(chan struct{})
+	go func() {
+		defer close(echoDone)
+		receivedMsg := make([]byte, b.messageSize)
+		for {
+			if _, err := io.ReadFull(conn, receivedMsg); err != nil {
+				return
+			}
+			b.successfulReads.Add(1)
+
+			index := binary.BigEndian.Uint64(receivedMsg[0:8])
+			sentAt := time.Duration(binary.BigEndian.Uint64(receivedMsg[8:16]))
+			if step := b.step(index); step != nil {
+				step.echoed.Add(1)
+				step.latency.Record((time.Since(startTime) - sentAt).Nanoseconds())
+			}
+		}
+	}()
+	defer func() {
+		// unblock the reader once done, the end marker is not echoed.
+		// Without deadlines, it is left behind until the conn is closed
+		if setReadDeadline(conn, time.Now()) {
+			<-echoDone
+			conn.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	msg := make([]byte, b.messageSize)
+	payloadGenerator(nil).Fill(msg)
+	runStep := func(rate float64) (bool, error) {
+		step := b.addStep(rate)
+		if err := b.runStep(conn, step, msg, startTime, echoDone); err != nil {
+			return false, err
+		}
+		return step.passed, nil
+	}
+
+	// ramp up step-wise until a step fails
+	rate := b.StartRate
+	failedRate := 0.0
+	for len(b.steps) < maxRampSteps {
+		passed, err := runStep(rate)
+		if err != nil {
+			return err
+		}
+		if !passed {
+			failedRate = rate
+			b.rampStopped = "threshold exceeded"
+			break
+		}
+		b.maxRate = rate
+		if b.MaxRate > 0 && rate >= b.MaxRate {
+			b.rampStopped = "max rate reached"
+			break
+		}
+		rate *= b.StepFactor
+		if b.MaxRate > 0 && rate > b.MaxRate {
+			rate = b.MaxRate
+		}
+	}
+	if b.rampStopped == "" {
+		b.rampStopped = "max steps reached"
+	}
+
+	// narrow down the maximum rate between the last passing and the first
+	// failing rate
+	for i := 0; i < b.SearchSteps && failedRate > 0 && b.maxRate > 0; i++ {
+		rate := (b.maxRate + failedRate) / 2
+		passed, err := runStep(rate)
+		if err != nil {
+			return err
+		}
+		if passed {
+			b.maxRate = rate
+		} else {
+			failedRate = rate
+		}
+	}
+
+	// signal the end of the run to the reader
+	if _, err := conn.Write(endMarker(b.messageSize, b.successfulWrites.Load())); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// addStep adds a step at rate to the ramp.
+func (b *RampBenchmark) addStep(rate float64) *rampStep {
+	b.stepsMutex.Lock()
+	defer b.stepsMutex.Unlock()
+
+	step := &rampStep{rate: rate, latency: newDefaultLatencyHistogram()}
+	b.steps = append(b.steps, step)
+	return step
+}
+
+// step returns the step at index, or nil if there is none.
+func (b *RampBenchmark) step(index uint64) *rampStep {
+	b.stepsMutex.Lock()
+	defer b.stepsMutex.Unlock()
+
+	if index >= uint64(len(b.steps)) {
+		return nil
+	}
+	return b.steps[index]
+}
+
+// runStep sends messages paced at the rate of step for StepDuration, then
+// waits up to LatencyThreshold for their echoes and evaluates the step.
+func (b *RampBenchmark) runStep(conn net.Conn, step *rampStep, msg []byte, startTime time.Time, echoDone <-chan struct{}) error {
+	b.stepsMutex.Lock()
+	index := uint64(len(b.steps) - 1)
+	b.stepsMutex.Unlock()
+	binary.BigEndian.PutUint64(msg[0:8], index)
+
+	step.startTime = time.Now()
+	interval := time.Duration(float64(time.Second) / step.rate)
+	for time.Since(step.startTime) < b.StepDuration {
+		// pace by the schedule rather than a ticker, so that late messages
+		// are caught up in bursts instead of lowering the rate
+		next := step.startTime.Add(time.Duration(step.sent) * interval)
+		if wait := time.Until(next); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		binary.BigEndian.PutUint64(msg[8:16], uint64(time.Since(startTime)))
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		step.sent++
+		b.successfulWrites.Add(1)
+	}
+	step.sendTime = time.Since(step.startTime)
+
+	// wait for the echoes of the step
+	deadline := time.Now().Add(b.LatencyThreshold)
+	for step.echoed.Load() < step.sent && time.Now().Before(deadline) {
+		select {
+		case <-echoDone:
+			return errors.New("connection closed while waiting for echoes")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	achieved := float64(step.sent) / step.sendTime.Seconds()
+	loss := 1 - float64(step.echoed.Load())/float64(step.sent)
+	p99 := time.Duration(step.latency.ValueAtPercentile(99))
+	switch {
+	case achieved < minAchievedRatio*step.rate:
+		step.reason = "rate not achieved"
+	case loss > b.LossThreshold:
+		step.reason = "loss threshold exceeded"
+	case p99 > b.LatencyThreshold:
+		step.reason = "latency threshold exceeded"
+	default:
+		step.passed = true
+	}
+	return nil
+}
+
+func (b *RampBenchmark) Reader(conn net.Conn, counters ...Counter) (err error) {
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	// Compare benchmark specs on both sides
+	peer, err := readerHandshakeVia(conn, b.Control, b)
+	if err != nil {
+		return err
+	}
+	b.versions.record(peer)
+
+	// Stop promptly if aborted over the control channel, reporting the abort
+	// rather than the resulting I/O error
+	defer b.Control.watchAbort(conn)()
+	defer func() {
+		if abortErr := b.Control.AbortErr(); abortErr != nil {
+			err = abortErr
+		}
+	}()
+
+	// Create combined counter
+	b.combinedCounter = CombineCounters(time.Second, counters...)
+
+	// Benchmark starts
+	b.messageSize = b.MessageSize
+	b.socketOptions = readSocketOptions(conn)
+	b.decorators.startRecording(conn)
+	b.successfulReads.Store(0)
+	b.successfulWrites.Store(0)
+	b.schedLatency.startRecording()
+	defer b.schedLatency.stopRecording()
+	b.allocs.startRecording()
+	defer b.allocs.stopRecording()
+	b.startTime.Store(time.Now())
+	var closedAt time.Time // when the peer closed the data connection, before waiting for its abort
+	defer func() {
+		if closedAt.IsZero() {
+			closedAt = time.Now()
+		}
+		b.endTime.Store(closedAt)
+	}()
+
+	// Start the counter
+	if b.combinedCounter != nil {
+		b.combinedCounter.Start()
+		defer b.combinedCounter.Stop()
+	}
+
+	// echo every message back until the writer signals the end of the run
+	receivedMsg := make([]byte, b.messageSize)
+	for {
+		if _, err := io.ReadFull(conn, receivedMsg); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				closedAt = time.Now()
+				return b.Control.closedErr()
+			}
+			return err
+		}
+		if _, ok := parseEndMarker(receivedMsg); ok {
+			return nil
+		}
+		b.successfulReads.Add(1)
+
+		if _, err := conn.Write(receivedMsg); err != nil {
+			return err
+		}
+		b.successfulWrites.Add(1)
+	}
+}
+
+// Result returns, for the writer, the maximum sustainable rate found as
+// max_sustainable_rate in messages per second and
+// max_sustainable_throughput_Mbps, and the outcome of each step under
+// "steps".
+func (b *RampBenchmark) Result() map[string]any {
+	if b.endTime.Load() == nil || b.endTime.Load().(time.Time).IsZero() || b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).Nanoseconds() == 0 {
+		return map[string]any{}
+	}
+
+	result := map[string]any{
+		"successful_reads":  b.successfulReads.Load(),
+		"successful_writes": b.successfulWrites.Load(),
+		"start_time":        b.startTime.Load().(time.Time).Format(time.RFC3339),
+		"end_time":          b.endTime.Load().(time.Time).Format(time.RFC3339),
+		"duration":          b.endTime.Load().(time.Time).Sub(b.startTime.Load().(time.Time)).String(),
+	}
+
+	b.stepsMutex.Lock()
+	if len(b.steps) > 0 { // writer only
+		result["max_sustainable_rate"] = b.maxRate
+		result["max_sustainable_throughput_Mbps"] = b.maxRate * float64(b.messageSize) * 8 / 1e6
+		result["ramp_stopped"] = b.rampStopped
+
+		steps := make([]map[string]any, len(b.steps))
+		for i, step := range b.steps {
+			steps[i] = step.result()
+		}
+		result["steps"] = steps
+	}
+	b.stepsMutex.Unlock()
+
+	b.schedLatency.addResult(result)
+	b.allocs.addResult(result, b.successfulReads.Load()+b.successfulWrites.Load(), ProfileDefault)
+
+	if b.socketOptions != nil {
+		result["socket_options"] = b.socketOptions
+	}
+	b.decorators.addResult(result, ProfileDefault)
+	b.versions.addResult(result)
+
+	b.Control.addAbortResult(result)
+
+	if b.combinedCounter != nil {
+		result["counters"] = b.combinedCounter.Results()
+	}
+
+	return result
+}
+
+func (s *rampStep) result() map[string]any {
+	result := map[string]any{
+		"target_rate": s.rate,
+		"start_time":  s.startTime.Format(time.RFC3339Nano),
+		"sent":        s.sent,
+		"echoed":      s.echoed.Load(),
+		"passed":      s.passed,
+	}
+	if s.sendTime > 0 {
+		result["achieved_rate"] = float64(s.sent) / s.sendTime.Seconds()
+	}
+	if s.sent > 0 {
+		result["loss"] = 1 - float64(s.echoed.Load())/float64(s.sent)
+	}
+	for name, value := range s.latency.Percentiles() {
+		result["latency_"+name+"_ns"] = value // in nanoseconds
+	}
+	if s.reason != "" {
+		result["reason"] = s.reason
+	}
+	return result
+}
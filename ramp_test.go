@@ -0,0 +1,92 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// throttledConn delays each write, capping the rate at which it can send.
+type throttledConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+func TestRampBenchmark(t *testing.T) {
+	newRamp := func() *RampBenchmark {
+		return &RampBenchmark{
+			MessageSize:      64,
+			StartRate:        100,
+			StepFactor:       2,
+			StepDuration:     100 * time.Millisecond,
+			MaxRate:          800,
+			SearchSteps:      2,
+			LatencyThreshold: 50 * time.Millisecond,
+			LossThreshold:    0.01,
+		}
+	}
+
+	writer, reader := newRamp(), newRamp()
+	runOverTCP(t, writer, reader)
+
+	result := writer.Result()
+	if result["max_sustainable_rate"] != float64(800) || result["ramp_stopped"] != "max rate reached" {
+		t.Errorf("expected the ramp to reach the max rate, got %v (%v)", result["max_sustainable_rate"], result["ramp_stopped"])
+	}
+	steps, _ := result["steps"].([]map[string]any)
+	if len(steps) != 4 {
+		t.Fatalf("expected 4 steps at 100, 200, 400 and 800 messages per second, got %v", steps)
+	}
+	if reader.Result()["successful_reads"] != result["successful_writes"] {
+		t.Errorf("expected every message to be echoed, got %v read and %v written", reader.Result()["successful_reads"], result["successful_writes"])
+	}
+}
+
+func TestRampBenchmarkThreshold(t *testing.T) {
+	newRamp := func() *RampBenchmark {
+		return &RampBenchmark{
+			MessageSize:      64,
+			StartRate:        50,
+			StepFactor:       4,
+			StepDuration:     200 * time.Millisecond,
+			SearchSteps:      2,
+			LatencyThreshold: 50 * time.Millisecond,
+		}
+	}
+
+	writer, reader := newRamp(), newRamp()
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	// writes take at least 2ms, capping the rate at about 500 messages per
+	// second, so that the step at 800 fails
+	errs := make(chan error, 2)
+	go func() { errs <- writer.Writer(&throttledConn{Conn: writerConn, delay: 2 * time.Millisecond}) }()
+	go func() { errs <- reader.Reader(readerConn) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := writer.Result()
+	rate, _ := result["max_sustainable_rate"].(float64)
+	if rate < 200 || rate >= 800 {
+		t.Errorf("expected a max sustainable rate between 200 and 800 messages per second, got %v", rate)
+	}
+	if result["ramp_stopped"] != "threshold exceeded" {
+		t.Errorf("expected the ramp to stop at a threshold, got %v", result["ramp_stopped"])
+	}
+	steps, _ := result["steps"].([]map[string]any)
+	if len(steps) < 3 || steps[len(steps)-1]["target_rate"].(float64) >= 800 {
+		t.Errorf("expected binary search steps after the first failing step, got %v", steps)
+	}
+}
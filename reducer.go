@@ -0,0 +1,264 @@
+package benchmarkconn
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reducer reduces the latency samples of a run into summary statistics
+// added to the result of the benchmark, so that custom statistics, e.g.,
+// the minimum latency over the last 5 seconds, can be reported without
+// forking the benchmarks. See IntervalBenchmark.Reducers and
+// PressuredBenchmark.Reducers.
+//
+// A benchmark calls Add from a single goroutine while it runs, and Fields
+// once it ended. A reducer must not be shared between benchmarks.
+type Reducer interface {
+	// Add consumes a sample taken at t.
+	Add(t time.Time, value float64)
+
+	// Fields returns the statistics of the samples added so far, keyed by
+	// name, e.g., "ewma". The benchmark adds each to its result as
+	// <metric>_<name>_ns, e.g., latency_ewma_ns, replacing a field it
+	// reports itself under the same name.
+	Fields() map[string]any
+}
+
+// reducers holds the reducers registered by name, see RegisterReducer.
+var reducers = struct {
+	mutex     sync.RWMutex
+	factories map[string]func() Reducer
+}{factories: map[string]func() Reducer{
+	"mean":        func() Reducer { return NewMeanReducer() },
+	"stddev":      func() Reducer { return NewStdDevReducer() },
+	"percentiles": func() Reducer { return NewPercentilesReducer() },
+	"ewma":        func() Reducer { return NewEWMAReducer(DefaultEWMAAlpha) },
+}}
+
+// RegisterReducer makes a custom Reducer available by name to the tools
+// looking up reducers with NewRegisteredReducer, e.g., the command line
+// tools built on cmd/utils with -reduce. factory is called for each
+// benchmark, so it must return a new reducer every time.
+//
+// The built-in reducers are registered as "mean", "stddev", "percentiles"
+// and "ewma". Like Register, it is meant to be called from an init
+// function and panics if name is empty, factory is nil or name is
+// already registered.
+func RegisterReducer(name string, factory func() Reducer) {
+	if name == "" {
+		panic("benchmarkconn: RegisterReducer with an empty name")
+	}
+	if factory == nil {
+		panic("benchmarkconn: RegisterReducer factory is nil")
+	}
+
+	reducers.mutex.Lock()
+	defer reducers.mutex.Unlock()
+	if _, dup := reducers.factories[name]; dup {
+		panic("benchmarkconn: RegisterReducer called twice for " + name)
+	}
+	reducers.factories[name] = factory
+}
+
+// NewRegisteredReducer creates a new reducer registered under name, and
+// reports whether there is one.
+func NewRegisteredReducer(name string) (Reducer, bool) {
+	reducers.mutex.RLock()
+	factory, ok := reducers.factories[name]
+	reducers.mutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// RegisteredReducers returns the names of the registered reducers, the
+// built-in ones included, in order.
+func RegisteredReducers() []string {
+	reducers.mutex.RLock()
+	defer reducers.mutex.RUnlock()
+
+	names := make([]string, 0, len(reducers.factories))
+	for name := range reducers.factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// meanReducer reports the arithmetic mean of the samples as "mean".
+type meanReducer struct {
+	sum   float64
+	count uint64
+}
+
+// NewMeanReducer returns a reducer reporting the mean of the samples as
+// "mean".
+func NewMeanReducer() Reducer {
+	return &meanReducer{}
+}
+
+func (r *meanReducer) Add(_ time.Time, value float64) {
+	r.sum += value
+	r.count++
+}
+
+func (r *meanReducer) Fields() map[string]any {
+	if r.count == 0 {
+		return map[string]any{}
+	}
+	return map[string]any{"mean": r.sum / float64(r.count)}
+}
+
+// stdDevReducer reports the population standard deviation of the samples
+// as "stddev", computed with Welford's online algorithm.
+type stdDevReducer struct {
+	count uint64
+	mean  float64
+	m2    float64
+}
+
+// NewStdDevReducer returns a reducer reporting the population standard
+// deviation of the samples as "stddev".
+func NewStdDevReducer() Reducer {
+	return &stdDevReducer{}
+}
+
+func (r *stdDevReducer) Add(_ time.Time, value float64) {
+	r.count++
+	delta := value - r.mean
+	r.mean += delta / float64(r.count)
+	r.m2 += delta * (value - r.mean)
+}
+
+func (r *stdDevReducer) Fields() map[string]any {
+	if r.count == 0 {
+		return map[string]any{}
+	}
+	return map[string]any{"stddev": math.Sqrt(r.m2 / float64(r.count))}
+}
+
+// DefaultPercentiles are the percentiles reported by NewPercentilesReducer
+// if none are given.
+var DefaultPercentiles = []float64{50, 90, 99, 99.9}
+
+// percentilesReducer reports percentiles of the samples, rounded to
+// integers, from a histogram with 3 significant figures.
+type percentilesReducer struct {
+	percentiles []float64
+	histogram   *Histogram
+}
+
+// NewPercentilesReducer returns a reducer reporting the given percentiles
+// of the samples, DefaultPercentiles if none, named after their digits,
+// e.g., "p999" for 99.9. Samples are rounded to integers and tracked with
+// 3 significant figures, those below 1 as 1.
+func NewPercentilesReducer(percentiles ...float64) Reducer {
+	if len(percentiles) == 0 {
+		percentiles = DefaultPercentiles
+	}
+	h, err := NewHistogram(1, 3600*1000000000, 3)
+	if err != nil {
+		panic(err) // should never happen with constant bounds
+	}
+	h.SetAutoResize(true)
+	return &percentilesReducer{percentiles: percentiles, histogram: h}
+}
+
+func (r *percentilesReducer) Add(_ time.Time, value float64) {
+	r.histogram.Record(max(int64(math.Round(value)), 1))
+}
+
+func (r *percentilesReducer) Fields() map[string]any {
+	fields := make(map[string]any, len(r.percentiles))
+	if r.histogram.TotalCount() == 0 {
+		return fields
+	}
+	for _, percentile := range r.percentiles {
+		name := "p" + strings.ReplaceAll(strconv.FormatFloat(percentile, 'f', -1, 64), ".", "")
+		fields[name] = r.histogram.ValueAtPercentile(percentile)
+	}
+	return fields
+}
+
+// DefaultEWMAAlpha is the smoothing factor of the EWMA reducer registered
+// as "ewma".
+const DefaultEWMAAlpha = 0.1
+
+// ewmaReducer reports the exponentially weighted moving average of the
+// samples as "ewma".
+type ewmaReducer struct {
+	alpha float64
+	ewma  float64
+	init  bool
+}
+
+// NewEWMAReducer returns a reducer reporting the exponentially weighted
+// moving average of the samples as "ewma", i.e., the latest samples
+// weighted by alpha in (0, 1], seeded with the first sample. It panics if
+// alpha is out of range.
+func NewEWMAReducer(alpha float64) Reducer {
+	if alpha <= 0 || alpha > 1 {
+		panic(fmt.Sprintf("benchmarkconn: EWMA smoothing factor must be in (0, 1], got %g", alpha))
+	}
+	return &ewmaReducer{alpha: alpha}
+}
+
+func (r *ewmaReducer) Add(_ time.Time, value float64) {
+	if !r.init {
+		r.ewma, r.init = value, true
+		return
+	}
+	r.ewma += r.alpha * (value - r.ewma)
+}
+
+func (r *ewmaReducer) Fields() map[string]any {
+	if !r.init {
+		return map[string]any{}
+	}
+	return map[string]any{"ewma": r.ewma}
+}
+
+// reducerRecorder feeds the latency samples of a benchmark to its
+// reducers and adds their fields to its result.
+type reducerRecorder struct {
+	mutex    sync.Mutex
+	reducers []Reducer
+}
+
+// reset starts feeding the reducers.
+func (r *reducerRecorder) reset(reducers []Reducer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reducers = reducers
+}
+
+// record feeds a latency in nanoseconds to the reducers, if any.
+func (r *reducerRecorder) record(latency int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.reducers) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, reducer := range r.reducers {
+		reducer.Add(now, float64(latency))
+	}
+}
+
+// addResult adds the fields of the reducers to result as
+// <metric>_<name>_ns.
+func (r *reducerRecorder) addResult(result map[string]any, metric string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, reducer := range r.reducers {
+		for name, value := range reducer.Fields() {
+			result[metric+"_"+name+"_ns"] = value
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package benchmarkconn_test
+
+import (
+	"math"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestReducers(t *testing.T) {
+	now := time.Now()
+	mean, stddev, percentiles, ewma := NewMeanReducer(), NewStdDevReducer(), NewPercentilesReducer(50, 99.9), NewEWMAReducer(0.5)
+	for _, reducer := range []Reducer{mean, stddev, percentiles, ewma} {
+		if fields := reducer.Fields(); len(fields) != 0 {
+			t.Errorf("expected no fields without samples, got %v", fields)
+		}
+		for _, value := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+			reducer.Add(now, value)
+		}
+	}
+
+	if got := mean.Fields()["mean"]; got != 5.0 {
+		t.Errorf("expected a mean of 5, got %v", got)
+	}
+	if got := stddev.Fields()["stddev"]; got != 2.0 {
+		t.Errorf("expected a standard deviation of 2, got %v", got)
+	}
+	if fields := percentiles.Fields(); fields["p50"] != int64(4) || fields["p999"] != int64(9) {
+		t.Errorf("expected p50 of 4 and p999 of 9, got %v", fields)
+	}
+	// 2, 3, 3.5, 3.75, 4.375, 4.6875, 5.84375, 7.421875
+	if got := ewma.Fields()["ewma"].(float64); math.Abs(got-7.421875) > 1e-9 {
+		t.Errorf("expected an EWMA of 7.421875, got %v", got)
+	}
+}
+
+// trailingMinReducer reports the minimum of the samples over the last
+// window before the last sample.
+type trailingMinReducer struct {
+	window  time.Duration
+	samples []time.Time
+	values  []float64
+}
+
+func (r *trailingMinReducer) Add(t time.Time, value float64) {
+	r.samples = append(r.samples, t)
+	r.values = append(r.values, value)
+}
+
+func (r *trailingMinReducer) Fields() map[string]any {
+	if len(r.samples) == 0 {
+		return map[string]any{}
+	}
+	minimum := math.Inf(1)
+	last := r.samples[len(r.samples)-1]
+	for i, t := range r.samples {
+		if last.Sub(t) <= r.window {
+			minimum = min(minimum, r.values[i])
+		}
+	}
+	return map[string]any{"trailing_min": minimum}
+}
+
+func TestRegisterReducer(t *testing.T) {
+	RegisterReducer("trailing_min_5s", func() Reducer { return &trailingMinReducer{window: 5 * time.Second} })
+	if names := RegisteredReducers(); !slices.Equal(names, []string{"ewma", "mean", "percentiles", "stddev", "trailing_min_5s"}) {
+		t.Errorf("expected the built-in and registered reducers, got %v", names)
+	}
+	if _, ok := NewRegisteredReducer("unknown"); ok {
+		t.Error("expected no unknown reducer")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a reducer twice to panic")
+		}
+	}()
+	RegisterReducer("mean", NewMeanReducer)
+}
+
+func TestIntervalBenchmarkReducers(t *testing.T) {
+	reducers := []Reducer{&trailingMinReducer{window: 5 * time.Second}, NewEWMAReducer(DefaultEWMAAlpha)}
+	writer := &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: time.Millisecond, Echo: true, Reducers: reducers}
+	reader := &IntervalBenchmark{MessageSize: 64, TotalMessages: 20, Interval: time.Millisecond, Echo: true}
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- reader.Reader(readerConn) }()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	result := writer.Result()
+	minimum, ok := result["latency_trailing_min_ns"].(float64)
+	if !ok || minimum <= 0 || minimum > float64(result["latency_max_ns"].(int64)) {
+		t.Errorf("expected the trailing minimum latency, got %v", result["latency_trailing_min_ns"])
+	}
+	if _, ok := result["latency_ewma_ns"].(float64); !ok {
+		t.Errorf("expected the EWMA of the latency, got %v", result["latency_ewma_ns"])
+	}
+}
@@ -0,0 +1,61 @@
+package benchmarkconn
+
+import (
+	"slices"
+	"sync"
+)
+
+// registry holds the benchmarks registered by name, see Register.
+var registry = struct {
+	mutex     sync.RWMutex
+	factories map[string]func() Benchmark
+}{factories: make(map[string]func() Benchmark)}
+
+// Register makes a custom Benchmark available by name to the tools looking
+// up benchmarks with NewRegistered, e.g., the command line tools built on
+// cmd/utils. factory is called for each benchmark created, so it must
+// return a new one every time, as one is created per connection.
+//
+// Like database/sql.Register, it is meant to be called from an init
+// function and panics if name is empty, factory is nil or name is
+// already registered.
+func Register(name string, factory func() Benchmark) {
+	if name == "" {
+		panic("benchmarkconn: Register with an empty name")
+	}
+	if factory == nil {
+		panic("benchmarkconn: Register factory is nil")
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if _, dup := registry.factories[name]; dup {
+		panic("benchmarkconn: Register called twice for " + name)
+	}
+	registry.factories[name] = factory
+}
+
+// NewRegistered creates a benchmark with the factory registered as name,
+// false if none is.
+func NewRegistered(name string) (Benchmark, bool) {
+	registry.mutex.RLock()
+	factory, ok := registry.factories[name]
+	registry.mutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// Registered returns the names of the registered benchmarks in order.
+func Registered() []string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package benchmarkconn_test
+
+import (
+	"slices"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestRegister(t *testing.T) {
+	Register("test-pressure", func() Benchmark {
+		return &PressuredBenchmark{MessageSize: 64, TotalMessages: 100}
+	})
+
+	if !slices.Contains(Registered(), "test-pressure") {
+		t.Fatalf("expected test-pressure to be registered, got %v", Registered())
+	}
+
+	writer, ok := NewRegistered("test-pressure")
+	if !ok {
+		t.Fatal("expected test-pressure to be found")
+	}
+	reader, _ := NewRegistered("test-pressure")
+	if writer == reader {
+		t.Fatal("expected a new benchmark each time")
+	}
+	runOverTCP(t, writer, reader)
+	if reads := reader.Result()["successful_reads"]; reads != uint64(100) {
+		t.Errorf("expected 100 messages read, got %v", reads)
+	}
+
+	if _, ok := NewRegistered("test-unknown"); ok {
+		t.Error("expected an unregistered name not to be found")
+	}
+
+	for name, register := range map[string]func(){
+		"duplicate":   func() { Register("test-pressure", func() Benchmark { return &PressuredBenchmark{} }) },
+		"empty name":  func() { Register("", func() Benchmark { return &PressuredBenchmark{} }) },
+		"nil factory": func() { Register("test-nil", nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
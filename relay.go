@@ -0,0 +1,216 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRelayBufferSize is the size of the buffer RelayCopy copies
+// through if no BufferSize is set, that of io.Copy.
+const DefaultRelayBufferSize = 32 * 1024
+
+// RelayMode selects how a Relay forwards bytes between two connections.
+type RelayMode uint8
+
+const (
+	// RelayCopy reads into a userspace buffer and writes it out, as do
+	// proxies handling the bytes they forward.
+	RelayCopy RelayMode = iota
+
+	// RelaySplice forwards bytes within the kernel with splice(2), without
+	// copying them to userspace. It is only supported on Linux between TCP
+	// connections.
+	RelaySplice
+)
+
+// ParseRelayMode parses the name of a relay mode.
+func ParseRelayMode(name string) (RelayMode, error) {
+	switch name {
+	case "", "copy":
+		return RelayCopy, nil
+	case "splice":
+		return RelaySplice, nil
+	default:
+		return RelayCopy, errors.New("unknown relay mode, must be either \"copy\" or \"splice\"")
+	}
+}
+
+func (m RelayMode) String() string {
+	if m == RelaySplice {
+		return "splice"
+	}
+	return "copy"
+}
+
+func (m RelayMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *RelayMode) UnmarshalText(text []byte) error {
+	parsed, err := ParseRelayMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Relay forwards bytes between pairs of connections in both directions,
+// like a proxy, measuring the cost of forwarding in the selected Mode.
+// Peers run any benchmark end-to-end through the relay, which is
+// transparent to them; comparing runs with RelayCopy and RelaySplice
+// tells how much a proxy built on a conn implementation loses by copying
+// through userspace.
+//
+// Forward may be called concurrently for multiple pairs, the result
+// accounting for all of them.
+type Relay struct {
+	Mode       RelayMode // Mode selects how bytes are forwarded
+	BufferSize int       // BufferSize defines the buffer size of RelayCopy, DefaultRelayBufferSize if not set
+
+	connections     atomic.Uint64
+	bytesUpstream   atomic.Uint64 // forwarded from in to out
+	bytesDownstream atomic.Uint64 // forwarded from out to in
+
+	mutex     sync.Mutex
+	active    int
+	startTime time.Time
+	endTime   time.Time
+	startCPU  cpuTime
+	endCPU    cpuTime
+}
+
+// Forward forwards bytes from in to out and back until both directions
+// are closed, half-closing each direction as its source reaches EOF, then
+// closes both connections.
+func (r *Relay) Forward(in, out net.Conn) error {
+	r.begin()
+	defer r.end()
+	defer in.Close()
+	defer out.Close()
+
+	errs := make(chan error, 2)
+	go func() {
+		n, err := r.copy(out, in)
+		r.bytesUpstream.Add(uint64(n))
+		errs <- err
+	}()
+	go func() {
+		n, err := r.copy(in, out)
+		r.bytesDownstream.Add(uint64(n))
+		errs <- err
+	}()
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if copyErr := <-errs; copyErr != nil && err == nil {
+			err = copyErr
+			// unblock the other direction
+			in.Close()
+			out.Close()
+		}
+	}
+	return err
+}
+
+// copy forwards bytes from src to dst until src reaches EOF, then
+// half-closes dst if supported.
+func (r *Relay) copy(dst, src net.Conn) (int64, error) {
+	var n int64
+	var err error
+	if r.Mode == RelaySplice {
+		n, err = spliceCopy(dst, src)
+	} else {
+		bufferSize := r.BufferSize
+		if bufferSize <= 0 {
+			bufferSize = DefaultRelayBufferSize
+		}
+		// hide io.ReaderFrom and io.WriterTo, which may splice
+		n, err = io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, bufferSize))
+	}
+	if err != nil {
+		return n, err
+	}
+
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	return n, nil
+}
+
+// begin accounts for a new pair, starting the measurement with the first.
+func (r *Relay) begin() {
+	r.connections.Add(1)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.active == 0 && r.startTime.IsZero() {
+		r.startTime = time.Now()
+		r.startCPU = processCPUTime()
+	}
+	r.active++
+}
+
+// end accounts for the end of a pair, ending the measurement with the
+// last.
+func (r *Relay) end() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.active--
+	if r.active == 0 {
+		r.endTime = time.Now()
+		r.endCPU = processCPUTime()
+	}
+}
+
+// Result returns the bytes forwarded in either direction and the
+// resulting throughput, from the first pair forwarded until the last one
+// was closed. On Linux, it includes the CPU time of the process over the
+// same period, as cpu_user_ns and cpu_system_ns, and per MB forwarded as
+// cpu_ns_per_mb; like allocations, it is process-wide.
+func (r *Relay) Result() map[string]any {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.startTime.IsZero() || r.active > 0 {
+		return map[string]any{}
+	}
+
+	upstream, downstream := r.bytesUpstream.Load(), r.bytesDownstream.Load()
+	durationNs := r.endTime.Sub(r.startTime).Nanoseconds()
+	result := map[string]any{
+		"relay_mode":       r.Mode.String(),
+		"connections":      r.connections.Load(),
+		"bytes_upstream":   upstream,
+		"bytes_downstream": downstream,
+		"bytes_forwarded":  upstream + downstream,
+		"start_time":       r.startTime.Format(time.RFC3339),
+		"end_time":         r.endTime.Format(time.RFC3339),
+		"duration":         r.endTime.Sub(r.startTime).String(),
+	}
+	addBitrate(result, "", upstream+downstream, durationNs, ProfileDefault)
+
+	if r.startCPU.ok && r.endCPU.ok {
+		user := r.endCPU.user - r.startCPU.user
+		system := r.endCPU.system - r.startCPU.system
+		result["cpu_user_ns"] = user.Nanoseconds()
+		result["cpu_system_ns"] = system.Nanoseconds()
+		if forwarded := upstream + downstream; forwarded > 0 {
+			result["cpu_ns_per_mb"] = float64((user + system).Nanoseconds()) / (float64(forwarded) / 1e6)
+		}
+	}
+
+	return result
+}
+
+// cpuTime is the CPU time consumed by the process so far.
+type cpuTime struct {
+	user   time.Duration
+	system time.Duration
+	ok     bool // whether the CPU time could be read
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package benchmarkconn
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// spliceCopy forwards bytes from src to dst with splice(2), as does
+// (*net.TCPConn).ReadFrom for a TCP or Unix stream source.
+func spliceCopy(dst, src net.Conn) (int64, error) {
+	tcpDst, ok := dst.(*net.TCPConn)
+	if !ok {
+		return 0, fmt.Errorf("splice requires a TCP destination, got %T", dst)
+	}
+	switch src.(type) {
+	case *net.TCPConn, *net.UnixConn:
+	default:
+		return 0, fmt.Errorf("splice requires a TCP or Unix source, got %T", src)
+	}
+	return tcpDst.ReadFrom(src)
+}
+
+// processCPUTime reads the user and system CPU time of the process.
+func processCPUTime() cpuTime {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return cpuTime{}
+	}
+	return cpuTime{
+		user:   time.Duration(usage.Utime.Nano()),
+		system: time.Duration(usage.Stime.Nano()),
+		ok:     true,
+	}
+}
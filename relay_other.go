@@ -0,0 +1,18 @@
+//go:build !linux
+
+package benchmarkconn
+
+import (
+	"errors"
+	"net"
+)
+
+// spliceCopy is only supported on Linux.
+func spliceCopy(dst, src net.Conn) (int64, error) {
+	return 0, errors.New("splice is only supported on Linux")
+}
+
+// processCPUTime is only supported on Linux.
+func processCPUTime() cpuTime {
+	return cpuTime{}
+}
@@ -0,0 +1,94 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestRelay(t *testing.T) {
+	for _, mode := range []RelayMode{RelayCopy, RelaySplice} {
+		t.Run(mode.String(), func(t *testing.T) {
+			if mode == RelaySplice && runtime.GOOS != "linux" {
+				t.Skip("splice is only supported on Linux")
+			}
+
+			upstreamListener, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer upstreamListener.Close()
+			relayListener, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer relayListener.Close()
+
+			relay := &Relay{Mode: mode}
+			relayErr := make(chan error, 1)
+			go func() {
+				in, err := relayListener.Accept()
+				if err != nil {
+					relayErr <- err
+					return
+				}
+				out, err := net.Dial("tcp", upstreamListener.Addr().String())
+				if err != nil {
+					in.Close()
+					relayErr <- err
+					return
+				}
+				relayErr <- relay.Forward(in, out)
+			}()
+
+			writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000}
+			reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000}
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := upstreamListener.Accept()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer conn.Close()
+				if err := reader.Reader(conn); err != nil {
+					t.Error(err)
+				}
+			}()
+
+			conn, err := net.Dial("tcp", relayListener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Writer(conn); err != nil {
+				t.Fatal(err)
+			}
+			wg.Wait()
+			conn.Close()
+			if err := <-relayErr; err != nil {
+				t.Fatal(err)
+			}
+
+			if reader.Result()["bytes_read"] != uint64(1000*1024) {
+				t.Errorf("expected the whole run to be relayed, got %v", reader.Result()["bytes_read"])
+			}
+			result := relay.Result()
+			if result["relay_mode"] != mode.String() || result["connections"] != uint64(1) {
+				t.Errorf("expected a single %s relay, got %v", mode, result)
+			}
+			if upstream, _ := result["bytes_upstream"].(uint64); upstream < 1000*1024 {
+				t.Errorf("expected the messages to be forwarded upstream, got %v", result["bytes_upstream"])
+			}
+			if runtime.GOOS == "linux" {
+				if _, ok := result["cpu_ns_per_mb"]; !ok {
+					t.Errorf("expected the CPU time to be recorded, got %v", result)
+				}
+			}
+		})
+	}
+}
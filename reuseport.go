@@ -0,0 +1,23 @@
+package benchmarkconn
+
+import (
+	"context"
+	"net"
+)
+
+// ListenReusePort listens on address like net.ListenConfig.Listen, with
+// SO_REUSEPORT set on the socket, so that several processes may listen on
+// the same address at once.
+//
+// It lets the binary of a Service be upgraded without downtime: the new
+// process starts its Service on a listener of its own on the same
+// address, then the old process is shut down. Once the old listener is
+// closed, the kernel hands every new connection to the new process, while
+// the old one finishes the runs in progress. Both processes must listen
+// with ListenReusePort, and run as the same user.
+//
+// It is only supported on Linux.
+func ListenReusePort(ctx context.Context, network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(ctx, network, address)
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package benchmarkconn
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortControl sets SO_REUSEPORT on the socket before it is bound.
+func reusePortControl(network, address string, rawConn syscall.RawConn) error {
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
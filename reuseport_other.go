@@ -0,0 +1,13 @@
+//go:build !linux
+
+package benchmarkconn
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl is only supported on Linux.
+func reusePortControl(network, address string, rawConn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is only supported on Linux")
+}
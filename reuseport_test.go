@@ -0,0 +1,79 @@
+package benchmarkconn_test
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestListenReusePortUpgrade(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only supported on Linux")
+	}
+
+	newService := func(l net.Listener) *Service {
+		return &Service{
+			Listeners: []net.Listener{l},
+			New: func() Benchmark {
+				return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+			},
+		}
+	}
+	run := func(addr string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		if err := writer.Writer(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldListener, err := ListenReusePort(context.Background(), "tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := oldListener.Addr().String()
+	oldService := newService(oldListener)
+	if err := oldService.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the upgraded process listens on the same address, then the old one
+	// shuts down
+	newListener, err := ListenReusePort(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded := newService(newListener)
+	if err := upgraded.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer upgraded.Shutdown(context.Background())
+	if err := oldService.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		run(addr)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(upgraded.Results.Results()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if results := upgraded.Results.Results(); len(results) != 3 {
+		t.Errorf("expected the upgraded service to run every benchmark, got %d", len(results))
+	}
+
+	// a plain listener cannot share the address
+	if l, err := net.Listen("tcp", addr); err == nil {
+		l.Close()
+		t.Errorf("expected the address to be in use")
+	}
+}
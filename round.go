@@ -0,0 +1,92 @@
+package benchmarkconn
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// DefaultResultDigits is the number of significant digits results are
+// rounded to when serialized by the command line tools, enough to tell
+// apart any difference a benchmark can reliably measure.
+const DefaultResultDigits = 6
+
+// RoundResult rounds all floating-point values in result, including those
+// nested in maps and slices such as the counter results or the steps of a
+// ramp, to digits significant digits in place, so that serializing results
+// which differ by noise only does not produce noisy diffs. Integers,
+// strings and durations are left as they are. It does nothing if digits
+// is not positive.
+//
+// Map keys, e.g., of a result serialized as JSON, are always written in
+// sorted order, so rounded results serialize to the same text unless the
+// values differ.
+func RoundResult(result map[string]any, digits int) {
+	if digits <= 0 {
+		return
+	}
+	roundValue(reflect.ValueOf(result), digits)
+}
+
+// roundValue rounds the floating-point values held by the map or slice v
+// in place.
+func roundValue(v reflect.Value, digits int) {
+	switch v.Kind() {
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if rounded, ok := roundElem(iter.Value(), digits); ok {
+				v.SetMapIndex(iter.Key(), rounded)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if rounded, ok := roundElem(v.Index(i), digits); ok {
+				v.Index(i).Set(rounded)
+			}
+		}
+	}
+}
+
+// roundElem returns the rounded value of the map or slice element elem,
+// false if there is nothing to replace it with, e.g., if elem is a map
+// rounded in place.
+func roundElem(elem reflect.Value, digits int) (reflect.Value, bool) {
+	if elem.Kind() == reflect.Interface {
+		if elem.IsNil() {
+			return elem, false
+		}
+		inner := elem.Elem()
+		if inner.Kind() != reflect.Float32 && inner.Kind() != reflect.Float64 {
+			roundValue(inner, digits)
+			return elem, false
+		}
+		rounded := reflect.New(inner.Type()).Elem()
+		rounded.SetFloat(roundSignificant(inner.Float(), digits))
+		return rounded, true
+	}
+
+	switch elem.Kind() {
+	case reflect.Float32, reflect.Float64:
+		rounded := reflect.New(elem.Type()).Elem()
+		rounded.SetFloat(roundSignificant(elem.Float(), digits))
+		return rounded, true
+	case reflect.Map, reflect.Slice:
+		roundValue(elem, digits)
+	}
+	return elem, false
+}
+
+// roundSignificant rounds x to digits significant digits. Formatting
+// rather than scaling avoids the representation error of the scale, so
+// the result is always the closest float64 to the rounded decimal.
+func roundSignificant(x float64, digits int) float64 {
+	if x == 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(x, 'g', digits, 64), 64)
+	if err != nil {
+		return x
+	}
+	return rounded
+}
@@ -0,0 +1,65 @@
+package benchmarkconn_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestRoundResult(t *testing.T) {
+	tick := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	result := map[string]any{
+		"throughput_bps":  9.97466590713742e+06,
+		"ops_per_s":       19481.769349877773,
+		"loss_ratio":      0.000123456789,
+		"latency_ns":      int64(51330),
+		"stalled_ratio":   float64(0),
+		"duration":        "51.33004ms",
+		"socket_options":  map[string]any{"rtt_ms": 0.1234567},
+		"steps":           []map[string]any{{"throughput_Mbps": 123.4567891}},
+		"counters":        map[string]map[time.Time]any{"cpufreq": {tick: map[string]any{"cpu": 12.345678912}}},
+		"freq_mean_mhz":   float32(2400.123456),
+		"runtime_samples": []float64{1.23456789},
+	}
+
+	RoundResult(result, 6)
+
+	for _, tc := range []struct {
+		got      any
+		expected any
+	}{
+		{result["throughput_bps"], 9.97467e+06},
+		{result["ops_per_s"], 19481.8},
+		{result["loss_ratio"], 0.000123457},
+		{result["latency_ns"], int64(51330)},
+		{result["stalled_ratio"], float64(0)},
+		{result["duration"], "51.33004ms"},
+		{result["socket_options"].(map[string]any)["rtt_ms"], 0.123457},
+		{result["steps"].([]map[string]any)[0]["throughput_Mbps"], 123.457},
+		{result["counters"].(map[string]map[time.Time]any)["cpufreq"][tick].(map[string]any)["cpu"], 12.3457},
+		{result["freq_mean_mhz"], float32(2400.12)},
+		{result["runtime_samples"].([]float64)[0], 1.23457},
+	} {
+		if tc.got != tc.expected {
+			t.Errorf("expected %v (%T), got %v (%T)", tc.expected, tc.expected, tc.got, tc.got)
+		}
+	}
+
+	// the rounded values serialize as written
+	data, err := json.Marshal(map[string]any{"ops_per_s": result["ops_per_s"], "throughput_bps": result["throughput_bps"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"ops_per_s":19481.8,"throughput_bps":9974670}` {
+		t.Errorf("unexpected serialization: %s", data)
+	}
+
+	// without digits, nothing is rounded
+	unrounded := map[string]any{"ops_per_s": 19481.769349877773}
+	RoundResult(unrounded, 0)
+	if unrounded["ops_per_s"] != 19481.769349877773 {
+		t.Errorf("expected no rounding, got %v", unrounded["ops_per_s"])
+	}
+}
@@ -0,0 +1,138 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// RuntimeConfig describes the Go runtime settings to use for a run so
+// that the scheduler configuration becomes an explicit experimental
+// variable. Zero values leave the corresponding setting untouched.
+type RuntimeConfig struct {
+	GOMAXPROCS  int    `json:"gomaxprocs" yaml:"gomaxprocs"`     // GOMAXPROCS sets the maximum number of CPUs executing Go code simultaneously
+	GOGC        string `json:"gogc" yaml:"gogc"`                 // GOGC sets the GC target percentage, or "off" to disable the GC
+	MemoryLimit int64  `json:"memory_limit" yaml:"memory_limit"` // MemoryLimit sets the soft memory limit in bytes
+
+	// GODEBUG settings can only be applied at process start. They are
+	// listed here so that the caller can re-execute the process if they
+	// are not yet in effect, see (*RuntimeConfig).GODEBUGPending.
+	AsyncPreemptOff bool   `json:"async_preempt_off" yaml:"async_preempt_off"` // AsyncPreemptOff disables signal-based asynchronous goroutine preemption
+	GODEBUG         string `json:"godebug" yaml:"godebug"`                     // GODEBUG holds additional comma-separated GODEBUG settings, e.g., netdns=go
+}
+
+// Apply applies all settings which can be changed at runtime.
+func (c *RuntimeConfig) Apply() error {
+	if c.GOMAXPROCS < 0 {
+		return errors.New("GOMAXPROCS must not be negative")
+	}
+	if c.GOMAXPROCS > 0 {
+		runtime.GOMAXPROCS(c.GOMAXPROCS)
+	}
+
+	switch c.GOGC {
+	case "":
+	case "off":
+		debug.SetGCPercent(-1)
+	default:
+		percent, err := strconv.Atoi(c.GOGC)
+		if err != nil {
+			return errors.New("GOGC must be either \"off\" or an integer")
+		}
+		debug.SetGCPercent(percent)
+	}
+
+	if c.MemoryLimit < 0 {
+		return errors.New("memory limit must not be negative")
+	}
+	if c.MemoryLimit > 0 {
+		debug.SetMemoryLimit(c.MemoryLimit)
+	}
+
+	return nil
+}
+
+// GODEBUGSettings returns the GODEBUG settings requested by the config.
+// Each setting must be of the form key=value, since the Go runtime ignores
+// any other.
+func (c *RuntimeConfig) GODEBUGSettings() ([]string, error) {
+	var settings []string
+	if c.AsyncPreemptOff {
+		settings = append(settings, "asyncpreemptoff=1")
+	}
+	for _, setting := range strings.Split(c.GODEBUG, ",") {
+		if setting = strings.TrimSpace(setting); setting == "" {
+			continue
+		}
+		if key, _, ok := strings.Cut(setting, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid GODEBUG setting %q, must be key=value", setting)
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// GODEBUGPending returns the requested GODEBUG settings which are not in
+// effect for the current process. A non-empty return value means the
+// process must be restarted with the settings in its environment.
+func (c *RuntimeConfig) GODEBUGPending() ([]string, error) {
+	settings, err := c.GODEBUGSettings()
+	if err != nil {
+		return nil, err
+	}
+	current := godebugSettings()
+
+	var pending []string
+	for _, setting := range settings {
+		key, value, _ := strings.Cut(setting, "=")
+		if current[key] != value {
+			pending = append(pending, setting)
+		}
+	}
+	return pending, nil
+}
+
+// godebugSettings parses the GODEBUG environment variable. Later
+// settings override earlier ones, same as the Go runtime.
+func godebugSettings() map[string]string {
+	settings := make(map[string]string)
+	for _, setting := range strings.Split(os.Getenv("GODEBUG"), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if ok && key != "" {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+// RuntimeSettings returns the effective Go runtime settings of the
+// current process and the TimerResolution of the host, suitable for
+// recording alongside benchmark results.
+func RuntimeSettings() map[string]any {
+	// read rather than set the GC percentage, which would trigger a GC
+	sample := []metrics.Sample{{Name: "/gc/gogc:percent"}}
+	metrics.Read(sample)
+	gogc := "off" // reported as -1 wrapped around
+	if percent := sample[0].Value.Uint64(); percent <= math.MaxInt32 {
+		gogc = strconv.FormatUint(percent, 10)
+	}
+
+	return map[string]any{
+		"go_version":          runtime.Version(),
+		"goos":                runtime.GOOS,
+		"goarch":              runtime.GOARCH,
+		"num_cpu":             runtime.NumCPU(),
+		"gomaxprocs":          runtime.GOMAXPROCS(0),
+		"gogc":                gogc,
+		"memory_limit":        debug.SetMemoryLimit(-1), // negative input only queries the limit
+		"async_preempt_off":   godebugSettings()["asyncpreemptoff"] == "1",
+		"godebug":             os.Getenv("GODEBUG"),
+		"timer_resolution_ns": TimerResolution().Nanoseconds(),
+	}
+}
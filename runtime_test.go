@@ -0,0 +1,103 @@
+package benchmarkconn_test
+
+import (
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestRuntimeConfigGODEBUG(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		config   RuntimeConfig
+		env      string
+		settings []string
+		pending  []string
+		invalid  bool
+	}{
+		{name: "Empty"},
+		{name: "AsyncPreemptOff", config: RuntimeConfig{AsyncPreemptOff: true}, settings: []string{"asyncpreemptoff=1"}, pending: []string{"asyncpreemptoff=1"}},
+		{name: "InEffect", config: RuntimeConfig{AsyncPreemptOff: true}, env: "asyncpreemptoff=1", settings: []string{"asyncpreemptoff=1"}},
+		{name: "Overridden", config: RuntimeConfig{GODEBUG: "netdns=go"}, env: "netdns=go,netdns=cgo", settings: []string{"netdns=go"}, pending: []string{"netdns=go"}},
+		{name: "List", config: RuntimeConfig{AsyncPreemptOff: true, GODEBUG: " netdns=go , ,madvdontneed=1"}, env: "madvdontneed=1", settings: []string{"asyncpreemptoff=1", "netdns=go", "madvdontneed=1"}, pending: []string{"asyncpreemptoff=1", "netdns=go"}},
+		{name: "EmptyValue", config: RuntimeConfig{GODEBUG: "netdns="}, settings: []string{"netdns="}},
+		{name: "MissingValue", config: RuntimeConfig{GODEBUG: "foo"}, invalid: true},
+		{name: "MissingKey", config: RuntimeConfig{GODEBUG: "netdns=go,=1"}, invalid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("GODEBUG", tc.env)
+
+			settings, err := tc.config.GODEBUGSettings()
+			if tc.invalid {
+				if err == nil {
+					t.Errorf("expected an invalid setting, got %v", settings)
+				}
+				if _, err := tc.config.GODEBUGPending(); err == nil {
+					t.Error("expected an invalid setting never to be pending")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(settings, tc.settings) {
+				t.Errorf("expected settings %v, got %v", tc.settings, settings)
+			}
+			pending, err := tc.config.GODEBUGPending()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pending, tc.pending) {
+				t.Errorf("expected %v pending, got %v", tc.pending, pending)
+			}
+		})
+	}
+}
+
+func TestRuntimeConfigApply(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	gcPercent := debug.SetGCPercent(100)
+	memoryLimit := debug.SetMemoryLimit(-1)
+	t.Cleanup(func() {
+		runtime.GOMAXPROCS(procs)
+		debug.SetGCPercent(gcPercent)
+		debug.SetMemoryLimit(memoryLimit)
+	})
+
+	for _, tc := range []struct {
+		name     string
+		config   RuntimeConfig
+		invalid  bool
+		settings map[string]any
+	}{
+		{name: "Unchanged", settings: map[string]any{"gogc": "100"}},
+		{name: "All", config: RuntimeConfig{GOMAXPROCS: 1, GOGC: "50", MemoryLimit: 1 << 30}, settings: map[string]any{"gomaxprocs": 1, "gogc": "50", "memory_limit": int64(1 << 30)}},
+		{name: "GCOff", config: RuntimeConfig{GOGC: "off"}, settings: map[string]any{"gogc": "off"}},
+		{name: "NegativeGOMAXPROCS", config: RuntimeConfig{GOMAXPROCS: -1}, invalid: true},
+		{name: "InvalidGOGC", config: RuntimeConfig{GOGC: "fast"}, invalid: true},
+		{name: "NegativeMemoryLimit", config: RuntimeConfig{MemoryLimit: -1}, invalid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			debug.SetGCPercent(100)
+			err := tc.config.Apply()
+			if tc.invalid {
+				if err == nil {
+					t.Error("expected an invalid config")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			settings := RuntimeSettings()
+			for name, want := range tc.settings {
+				if settings[name] != want {
+					t.Errorf("expected %s of %v, got %v", name, want, settings[name])
+				}
+			}
+		})
+	}
+}
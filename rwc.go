@@ -0,0 +1,63 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ErrDeadlineUnsupported is returned by the deadline methods of the conns
+// returned by ReadWriteCloserConn whose stream does not support them.
+var ErrDeadlineUnsupported = errors.New("deadlines are not supported")
+
+// ReadWriteCloserConn adapts rwc to net.Conn, so that the benchmarks can
+// run over custom transports implementing io.ReadWriteCloser but not the
+// deadlines and addresses of net.Conn, e.g., WASM transports, SSH channels
+// or pipes. It returns rwc itself if it already is a net.Conn.
+//
+// The deadline methods of rwc are used if it has them, e.g., those of an
+// *os.File, and otherwise return ErrDeadlineUnsupported. The benchmarks
+// then fall back to waiting without deadlines where they can, e.g., for
+// late echoes, and fail with an explanatory error where they cannot, e.g.,
+// for the idle timeout of DeadPeerBenchmark. Goroutines left blocked on a
+// read return once rwc is closed. Both addresses are "rwc".
+func ReadWriteCloserConn(rwc io.ReadWriteCloser) net.Conn {
+	if conn, ok := rwc.(net.Conn); ok {
+		return conn
+	}
+	return &rwcConn{ReadWriteCloser: rwc}
+}
+
+type rwcConn struct {
+	io.ReadWriteCloser
+}
+
+func (c *rwcConn) LocalAddr() net.Addr  { return rwcAddr{} }
+func (c *rwcConn) RemoteAddr() net.Addr { return rwcAddr{} }
+
+func (c *rwcConn) SetDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return ErrDeadlineUnsupported
+}
+
+func (c *rwcConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return ErrDeadlineUnsupported
+}
+
+func (c *rwcConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return ErrDeadlineUnsupported
+}
+
+type rwcAddr struct{}
+
+func (rwcAddr) Network() string { return "rwc" }
+func (rwcAddr) String() string  { return "rwc" }
@@ -0,0 +1,86 @@
+package benchmarkconn_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+// pipeRWC is one end of a pair of io.Pipes, an io.ReadWriteCloser without
+// deadlines or addresses.
+type pipeRWC struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeRWC) Close() error {
+	p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
+
+func rwcPair() (pipeRWC, pipeRWC) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	return pipeRWC{r1, w2}, pipeRWC{r2, w1}
+}
+
+func TestReadWriteCloserConn(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		newBenchmark func() Benchmark
+	}{
+		{"Pressured", func() Benchmark {
+			return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000, Verify: true}
+		}},
+		{"Echo", func() Benchmark {
+			return &IntervalBenchmark{MessageSize: 1024, TotalMessages: 100, Interval: 100 * time.Microsecond, Echo: true}
+		}},
+		{"Bidirectional", func() Benchmark {
+			return &BidirectionalBenchmark{MessageSize: 1024, TotalMessages: 1000}
+		}},
+		{"Ramp", func() Benchmark {
+			return &RampBenchmark{MessageSize: 64, StartRate: 1000, StepFactor: 2, StepDuration: 50 * time.Millisecond, MaxRate: 2000, LatencyThreshold: 50 * time.Millisecond}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			writerRWC, readerRWC := rwcPair()
+			writerConn, readerConn := ReadWriteCloserConn(writerRWC), ReadWriteCloserConn(readerRWC)
+			if err := writerConn.SetReadDeadline(time.Now()); err != ErrDeadlineUnsupported {
+				t.Fatalf("expected deadlines to be unsupported, got %v", err)
+			}
+
+			writer, reader := tc.newBenchmark(), tc.newBenchmark()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := reader.Reader(readerConn); err != nil {
+					t.Errorf("reader failed: %v", err)
+				}
+			}()
+			if err := writer.Writer(writerConn); err != nil {
+				t.Errorf("writer failed: %v", err)
+			}
+			wg.Wait()
+			writerConn.Close()
+			readerConn.Close()
+
+			if writes, _ := writer.Result()["successful_writes"].(uint64); writes == 0 {
+				t.Errorf("expected messages to be written, got %v", writer.Result())
+			}
+		})
+	}
+
+	t.Run("NetConn", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer conn.Close()
+		defer peer.Close()
+		if ReadWriteCloserConn(conn) != conn {
+			t.Error("expected a net.Conn to be returned as is")
+		}
+	})
+}
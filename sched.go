@@ -0,0 +1,86 @@
+package benchmarkconn
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+)
+
+// schedLatenciesMetric is the distribution of the time goroutines spent
+// runnable before actually running, cumulative since the process started.
+const schedLatenciesMetric = "/sched/latencies:seconds"
+
+// schedLatencyRecorder records the Go scheduler latency over a run, so
+// that long latency tails can be attributed to Go scheduling rather than
+// the network. The latency is process-wide, including goroutines not part
+// of the benchmark.
+type schedLatencyRecorder struct {
+	mutex sync.Mutex
+	start *metrics.Float64Histogram
+	end   *metrics.Float64Histogram
+}
+
+func (r *schedLatencyRecorder) startRecording() {
+	start := readSchedLatencies()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.start, r.end = start, nil
+}
+
+func (r *schedLatencyRecorder) stopRecording() {
+	end := readSchedLatencies()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.end = end
+}
+
+// addResult adds the distribution of the scheduler latency over the run to
+// result, as sched_latency_<min|max|p50|p90|p99|p999>_ns and the number of
+// schedulings sampled by the runtime as sched_latency_samples.
+func (r *schedLatencyRecorder) addResult(result map[string]any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.start == nil || r.end == nil || len(r.start.Counts) != len(r.end.Counts) {
+		return
+	}
+
+	h := newDefaultLatencyHistogram()
+	for i := range r.end.Counts {
+		count := r.end.Counts[i] - r.start.Counts[i]
+		if count == 0 {
+			continue
+		}
+		h.RecordN(bucketValueNs(r.end.Buckets[i], r.end.Buckets[i+1]), int64(count))
+	}
+	if h.TotalCount() == 0 {
+		return
+	}
+
+	result["sched_latency_samples"] = uint64(h.TotalCount())
+	for name, value := range h.Percentiles() {
+		result["sched_latency_"+name+"_ns"] = value // in nanoseconds
+	}
+}
+
+// bucketValueNs returns the value in nanoseconds representing a bucket of
+// the runtime histogram, its upper bound unless unbounded.
+func bucketValueNs(lower, upper float64) int64 {
+	if math.IsInf(upper, 1) {
+		return int64(lower * 1e9)
+	}
+	return int64(math.Ceil(upper * 1e9))
+}
+
+// readSchedLatencies reads the cumulative scheduler latency histogram, or
+// returns nil if not supported by the runtime.
+func readSchedLatencies() *metrics.Float64Histogram {
+	sample := []metrics.Sample{{Name: schedLatenciesMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindFloat64Histogram {
+		return nil
+	}
+	return sample[0].Value.Float64Histogram()
+}
@@ -0,0 +1,26 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestSchedLatency(t *testing.T) {
+	writer := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 100, Interval: time.Millisecond, Echo: true}
+	reader := &IntervalBenchmark{MessageSize: 1024, TotalMessages: 100, Interval: time.Millisecond, Echo: true}
+	runOverTCP(t, writer, reader)
+
+	result := writer.Result()
+	count, ok := result["sched_latency_samples"].(uint64)
+	if !ok || count == 0 {
+		t.Fatalf("expected goroutines to be scheduled during the run, got %v", result["sched_latency_samples"])
+	}
+	p50, _ := result["sched_latency_p50_ns"].(int64)
+	p99, _ := result["sched_latency_p99_ns"].(int64)
+	max, _ := result["sched_latency_max_ns"].(int64)
+	if p50 > p99 || p99 > max {
+		t.Errorf("expected increasing percentiles, got p50 %d, p99 %d, max %d", p50, p99, max)
+	}
+}
@@ -0,0 +1,97 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+const (
+	SelfTestPipe = "pipe" // net.Pipe, synchronous and in memory, the upper bound of the benchmark itself
+	SelfTestTCP  = "tcp"  // TCP over the loopback interface, the baseline of the kernel network stack
+)
+
+// SelfTest runs both sides of a benchmark in this process over transport,
+// either SelfTestPipe or SelfTestTCP, and returns the results of the
+// writer and the reader. newBenchmark is called once for each side and
+// must configure both identically.
+//
+// The results establish a baseline of the machine, free of any network or
+// transport under test, so a slow transport can be told apart from a slow
+// or busy host before blaming the transport.
+func SelfTest(transport string, newBenchmark func() Benchmark) (writerResult, readerResult map[string]any, err error) {
+	writerConn, readerConn, err := selfTestConns(transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	writer, reader := newBenchmark(), newBenchmark()
+
+	var readerErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readerErr = reader.Reader(readerConn)
+	}()
+	writerErr := writer.Writer(writerConn)
+	if writerErr != nil {
+		// unblock the reader rather than waiting for its deadline
+		readerConn.Close()
+	}
+	wg.Wait()
+
+	if err := errors.Join(writerErr, readerErr); err != nil {
+		return nil, nil, err
+	}
+	return writer.Result(), reader.Result(), nil
+}
+
+// selfTestConns returns both ends of a connection over transport.
+func selfTestConns(transport string) (net.Conn, net.Conn, error) {
+	switch transport {
+	case SelfTestPipe:
+		writerConn, readerConn := net.Pipe()
+		return writerConn, readerConn, nil
+	case SelfTestTCP:
+		return loopbackTCPConns()
+	default:
+		return nil, nil, fmt.Errorf("unknown self-test transport %q, must be %s or %s", transport, SelfTestPipe, SelfTestTCP)
+	}
+}
+
+// loopbackTCPConns returns both ends of a TCP connection over the loopback
+// interface.
+func loopbackTCPConns() (net.Conn, net.Conn, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer l.Close()
+
+	type acceptResult struct {
+		conn net.Conn
+		err  error
+	}
+	accepted := make(chan acceptResult, 1)
+	go func() {
+		conn, err := l.Accept()
+		accepted <- acceptResult{conn, err}
+	}()
+
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		l.Close() // unblock Accept
+		<-accepted
+		return nil, nil, err
+	}
+	result := <-accepted
+	if result.err != nil {
+		dialed.Close()
+		return nil, nil, result.err
+	}
+	return dialed, result.conn, nil
+}
@@ -0,0 +1,45 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestSelfTest(t *testing.T) {
+	for _, transport := range []string{SelfTestPipe, SelfTestTCP} {
+		t.Run(transport, func(t *testing.T) {
+			writerResult, readerResult, err := SelfTest(transport, func() Benchmark {
+				return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 1000}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("Writer: %v", writerResult)
+			t.Logf("Reader: %v", readerResult)
+
+			if bytesRead := readerResult["bytes_read"]; bytesRead != uint64(1024*1000) {
+				t.Errorf("expected %d bytes read, got %v", 1024*1000, bytesRead)
+			}
+			if throughput, ok := readerResult["throughput_bps"].(float64); !ok || throughput <= 0 {
+				t.Errorf("expected a throughput, got %v", readerResult["throughput_bps"])
+			}
+		})
+	}
+
+	// echo exercises both directions
+	writerResult, _, err := SelfTest(SelfTestPipe, func() Benchmark {
+		return &IntervalBenchmark{MessageSize: 64, TotalMessages: 10, Interval: time.Millisecond, Echo: true}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := writerResult["latency_p99_ns"]; !ok {
+		t.Errorf("expected the echo latency, got %v", writerResult)
+	}
+
+	if _, _, err := SelfTest("udp", func() Benchmark { return &PressuredBenchmark{} }); err == nil {
+		t.Error("expected an unknown transport to be rejected")
+	}
+}
@@ -0,0 +1,577 @@
+package benchmarkconn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultResultStoreSize is how many results a Service keeps if no
+// ResultStore is set.
+const DefaultResultStoreSize = 100
+
+// ErrServiceClosed is returned by Service.Start and Service.Shutdown after
+// Shutdown.
+var ErrServiceClosed = errors.New("benchmark service closed")
+
+// Service embeds a benchmark server in an application, e.g., a proxy
+// exposing a self-benchmark port: it accepts connections on its
+// listeners, runs a new benchmark on each of them from a run queue, keeps
+// the results and hands them to the exporters.
+//
+// While MaxConcurrentRuns are running, further connections wait in the
+// queue in the order they were accepted, or by Priority if set, and
+// Queued reports their position, also served to peers by StatusHandler.
+//
+// See ListenReusePort to upgrade the binary embedding a Service without
+// downtime.
+//
+// Each connection runs its own benchmark, so peers must run the opposite
+// side of the very same spec, as checked by the handshake. With Sniff,
+// the Service instead runs whatever benchmark each peer requests.
+type Service struct {
+	Listeners []net.Listener   // Listeners accept the connections to benchmark, and are closed on Shutdown
+	New       func() Benchmark // New creates the benchmark to run on each accepted connection
+	Write     bool             // Write runs the writer side of the benchmarks, the reader side if not set
+
+	// Sniff, if set, runs the benchmark each peer requests in its
+	// handshake, with its spec and the opposite role, instead of those of
+	// New and Write, so that one Service serves every type of benchmark.
+	// See Sniff for the benchmarks supported.
+	Sniff bool
+
+	// SpecLimits bounds the spec peers may request with Sniff,
+	// DefaultSpecLimits if not set.
+	SpecLimits *SpecLimits
+
+	// Counters, if set, creates the counters of the benchmark run on conn,
+	// e.g., a TCP_INFO counter.
+	Counters func(conn net.Conn) []Counter
+
+	// OnAccept, if set, is called for every accepted connection before it
+	// is queued, e.g., to apply socket options with a ConnTuner. It returns
+	// the connection to benchmark, or an error to reject it.
+	OnAccept func(conn net.Conn) (net.Conn, error)
+
+	// Priority, if set, returns the priority of an accepted connection,
+	// e.g., by the address of the peer. Queued connections of higher
+	// priority run first, and those of equal priority in the order they
+	// were accepted. It is called from the loop accepting the connections
+	// of the listener, so it must return quickly, e.g., rather than look
+	// up the peer remotely.
+	Priority func(conn net.Conn) int
+
+	MaxConcurrentRuns int           // MaxConcurrentRuns defines how many benchmarks run at once, 1 if not set
+	QueueSize         int           // QueueSize defines how many accepted connections wait while MaxConcurrentRuns are running, further ones are rejected
+	Timeout           time.Duration // Timeout defines how long a benchmark may run before its connection is closed, unlimited if not set
+
+	Results   *ResultStore     // Results keeps the results of the runs, DefaultResultStoreSize of them if not set
+	Exporters []ResultExporter // Exporters are handed every result once its run ends, and shut down on Shutdown
+
+	// OnError, if set, is called for errors not tied to a run, e.g., failed
+	// accepts or exports.
+	OnError func(err error)
+
+	mutex    sync.Mutex
+	started  bool
+	closed   bool
+	queue    []*serviceRun // queued connections, in the order they run
+	queued   *sync.Cond    // signaled when a connection is queued or the queue is closed
+	drained  bool          // no more connections are queued
+	slots    chan struct{} // admitted connections, running or queued
+	active   map[*serviceRun]struct{}
+	accepted sync.WaitGroup // accept loops
+	workers  sync.WaitGroup // run queue workers
+	nextID   atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// serviceRun is a connection accepted by a Service, queued or running.
+type serviceRun struct {
+	id       uint64
+	conn     net.Conn
+	listener net.Addr
+	queuedAt time.Time
+	priority int
+
+	bench     Benchmark // set once running, or once sniffed, under the mutex of the Service
+	startTime time.Time
+}
+
+// ServiceStatus is a benchmark of a Service still running, see Running,
+// or a connection waiting in its queue, see Queued.
+type ServiceStatus struct {
+	ID         uint64      `json:"id"`                   // ID identifies the run, increasing in the order connections were accepted
+	Benchmark  string      `json:"benchmark,omitempty"`  // Benchmark is the type of benchmark run, e.g., pressure, empty while queued
+	Listener   string      `json:"listener"`             // Listener is the address the connection was accepted on
+	RemoteAddr string      `json:"remote_addr"`          // RemoteAddr is the address of the peer
+	Priority   int         `json:"priority,omitempty"`   // Priority is the priority of the connection, see Service.Priority
+	Position   int         `json:"position,omitempty"`   // Position is the position of the connection in the queue, from 1 for the next to run, 0 once running
+	QueuedAt   time.Time   `json:"queued_at"`            // QueuedAt is when the connection was accepted
+	StartTime  time.Time   `json:"start_time,omitempty"` // StartTime is when the benchmark started, zero while queued
+	Snapshot   RunSnapshot `json:"snapshot"`             // Snapshot is the progress of the benchmark, zero while queued or if it does not implement Snapshot() RunSnapshot
+}
+
+// ServiceResult is the result of a benchmark run by a Service.
+type ServiceResult struct {
+	ID         uint64         `json:"id"`              // ID identifies the run, increasing in the order connections were accepted
+	Benchmark  string         `json:"benchmark"`       // Benchmark is the type of benchmark run, e.g., pressure
+	Listener   string         `json:"listener"`        // Listener is the address the connection was accepted on
+	RemoteAddr string         `json:"remote_addr"`     // RemoteAddr is the address of the peer
+	QueuedAt   time.Time      `json:"queued_at"`       // QueuedAt is when the connection was accepted
+	StartTime  time.Time      `json:"start_time"`      // StartTime is when the benchmark started
+	EndTime    time.Time      `json:"end_time"`        // EndTime is when the benchmark ended
+	QueueWait  time.Duration  `json:"queue_wait_ns"`   // QueueWait is how long the connection waited in the queue, from QueuedAt to StartTime
+	RunTime    time.Duration  `json:"run_time_ns"`     // RunTime is how long the benchmark ran, from StartTime to EndTime
+	Result     map[string]any `json:"result"`          // Result is the result of the benchmark
+	Error      string         `json:"error,omitempty"` // Error is the error the benchmark failed with, if any
+}
+
+// ResultExporter exports the results of a Service, e.g., to a metrics
+// backend or a file. Exporters implementing Shutdown(context.Context)
+// error are shut down with the Service.
+type ResultExporter interface {
+	Export(ctx context.Context, result ServiceResult) error
+}
+
+// ResultExporterFunc adapts a function to a ResultExporter.
+type ResultExporterFunc func(ctx context.Context, result ServiceResult) error
+
+func (f ResultExporterFunc) Export(ctx context.Context, result ServiceResult) error {
+	return f(ctx, result)
+}
+
+// Start starts accepting connections on the listeners and running the
+// benchmarks in the background. It returns ErrServiceClosed after
+// Shutdown.
+func (s *Service) Start() error {
+	if s.New == nil && !s.Sniff {
+		return errors.New("benchmark service requires New or Sniff")
+	}
+	if len(s.Listeners) == 0 {
+		return errors.New("benchmark service requires at least one listener")
+	}
+	if s.QueueSize < 0 || s.MaxConcurrentRuns < 0 {
+		return errors.New("benchmark service queue size and concurrent runs must not be negative")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrServiceClosed
+	}
+	if s.started {
+		return errors.New("benchmark service already started")
+	}
+	s.started = true
+
+	if s.Results == nil {
+		s.Results = NewResultStore(DefaultResultStoreSize)
+	}
+	workers := s.MaxConcurrentRuns
+	if workers == 0 {
+		workers = 1
+	}
+	s.queued = sync.NewCond(&s.mutex)
+	s.slots = make(chan struct{}, workers+s.QueueSize)
+	s.active = make(map[*serviceRun]struct{})
+
+	for i := 0; i < workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+	for _, l := range s.Listeners {
+		s.accepted.Add(1)
+		go s.accept(l)
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, closes the queued ones and waits
+// for the running benchmarks to end, then shuts down the exporters. Once
+// ctx is done, the connections of the benchmarks still running are closed
+// and ctx.Err() is returned.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	alreadyClosed := s.closed
+	s.closed = true
+	started := s.started
+	s.mutex.Unlock()
+	if alreadyClosed {
+		return ErrServiceClosed
+	}
+
+	var errs []error
+	for _, l := range s.Listeners {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	if !started {
+		return errors.Join(errs...)
+	}
+	s.accepted.Wait()
+	s.mutex.Lock()
+	s.drained = true // workers close the queued connections
+	s.queued.Broadcast()
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.mutex.Lock()
+		for run := range s.active {
+			run.conn.Close()
+		}
+		s.mutex.Unlock()
+		<-done
+		errs = append(errs, ctx.Err())
+	}
+
+	for _, exporter := range s.Exporters {
+		if shutdowner, ok := exporter.(interface{ Shutdown(context.Context) error }); ok {
+			if err := shutdowner.Shutdown(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Rejected returns how many connections were rejected, either by OnAccept
+// or since the queue was full.
+func (s *Service) Rejected() uint64 {
+	return s.rejected.Load()
+}
+
+// Running returns the benchmarks running, with their progress so far, in
+// the order their connections were accepted.
+func (s *Service) Running() []ServiceStatus {
+	s.mutex.Lock()
+	runs := make([]*serviceRun, 0, len(s.active))
+	benches := make(map[*serviceRun]Benchmark, len(s.active))
+	for run := range s.active {
+		runs = append(runs, run)
+		benches[run] = run.bench
+	}
+	s.mutex.Unlock()
+	sort.Slice(runs, func(i, j int) bool { return runs[i].id < runs[j].id })
+
+	statuses := make([]ServiceStatus, 0, len(runs))
+	for _, run := range runs {
+		status := ServiceStatus{
+			ID:         run.id,
+			Listener:   run.listener.String(),
+			RemoteAddr: run.conn.RemoteAddr().String(),
+			Priority:   run.priority,
+			QueuedAt:   run.queuedAt,
+			StartTime:  run.startTime,
+		}
+		if bench := benches[run]; bench != nil { // nil while sniffing
+			status.Benchmark = benchmarkType(bench)
+			if snapshotter, ok := bench.(interface{ Snapshot() RunSnapshot }); ok {
+				status.Snapshot = snapshotter.Snapshot()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Queued returns the connections waiting in the queue, in the order they
+// will run.
+func (s *Service) Queued() []ServiceStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make([]ServiceStatus, 0, len(s.queue))
+	for i, run := range s.queue {
+		statuses = append(statuses, ServiceStatus{
+			ID:         run.id,
+			Listener:   run.listener.String(),
+			RemoteAddr: run.conn.RemoteAddr().String(),
+			Priority:   run.priority,
+			Position:   i + 1,
+			QueuedAt:   run.queuedAt,
+		})
+	}
+	return statuses
+}
+
+// StatusHandler returns an HTTP handler serving the status of the
+// Service as JSON, so that peers waiting in the queue and operators can
+// follow it:
+//
+//	GET /running                      lists Running
+//	GET /queued                       lists Queued
+//	GET /queued?remote_addr=<addr>    the ServiceStatus of the connection from addr, 404 if not queued
+//
+// A peer finds its own position with its local address, as seen by the
+// Service, as remote_addr.
+func (s *Service) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/running", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, s.Running())
+	})
+	mux.HandleFunc("/queued", func(w http.ResponseWriter, r *http.Request) {
+		queued := s.Queued()
+		remoteAddr := r.URL.Query().Get("remote_addr")
+		if remoteAddr == "" {
+			writeStatus(w, r, queued)
+			return
+		}
+		for _, status := range queued {
+			if status.RemoteAddr == remoteAddr {
+				writeStatus(w, r, status)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("no connection from %s is queued", remoteAddr), http.StatusNotFound)
+	})
+	return mux
+}
+
+// writeStatus writes v as the JSON response to a GET request.
+func writeStatus(w http.ResponseWriter, r *http.Request, v any) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// accept accepts connections on l and queues them until l is closed.
+func (s *Service) accept(l net.Listener) {
+	defer s.accepted.Done()
+
+	var backoff time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.onError(fmt.Errorf("failed to accept connection: %w", err))
+
+			// back off on persistent errors, e.g., too many open files
+			if backoff = max(2*backoff, 5*time.Millisecond); backoff > time.Second {
+				backoff = time.Second
+			}
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		if s.OnAccept != nil {
+			if conn, err = s.OnAccept(conn); err != nil {
+				s.rejected.Add(1)
+				continue
+			}
+		}
+
+		run := &serviceRun{
+			id:       s.nextID.Add(1),
+			conn:     conn,
+			listener: l.Addr(),
+			queuedAt: time.Now(),
+		}
+		if s.Priority != nil {
+			run.priority = s.Priority(conn)
+		}
+		select {
+		case s.slots <- struct{}{}:
+			s.enqueue(run)
+		default:
+			s.rejected.Add(1)
+			conn.Close()
+		}
+	}
+}
+
+// enqueue queues run after the connections of the same or a higher
+// priority.
+func (s *Service) enqueue(run *serviceRun) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := len(s.queue)
+	for i > 0 && s.queue[i-1].priority < run.priority {
+		i--
+	}
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = run
+	s.queued.Signal()
+}
+
+// dequeue waits for the next queued connection, or returns nil once the
+// queue is drained.
+func (s *Service) dequeue() *serviceRun {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for len(s.queue) == 0 && !s.drained {
+		s.queued.Wait()
+	}
+	if len(s.queue) == 0 {
+		return nil
+	}
+	run := s.queue[0]
+	s.queue = append(s.queue[:0], s.queue[1:]...)
+	return run
+}
+
+// work runs the benchmarks of the queued connections until the queue is
+// drained, closing the queued connections left once shut down.
+func (s *Service) work() {
+	defer s.workers.Done()
+	for run := s.dequeue(); run != nil; run = s.dequeue() {
+		if s.isClosed() {
+			run.conn.Close()
+		} else {
+			s.run(run)
+		}
+		<-s.slots
+	}
+}
+
+// run runs a new benchmark on the connection of run, then stores and
+// exports its result.
+func (s *Service) run(run *serviceRun) {
+	var bench Benchmark
+	if !s.Sniff {
+		bench = s.New()
+	}
+	run.startTime = time.Now()
+	s.mutex.Lock()
+	run.bench = bench
+	s.active[run] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.active, run)
+		s.mutex.Unlock()
+	}()
+
+	var counters []Counter
+	if s.Counters != nil {
+		counters = s.Counters(run.conn)
+	}
+	if s.Timeout > 0 {
+		timer := time.AfterFunc(s.Timeout, func() { run.conn.Close() })
+		defer timer.Stop()
+	}
+
+	result := ServiceResult{
+		ID:         run.id,
+		Listener:   run.listener.String(),
+		RemoteAddr: run.conn.RemoteAddr().String(),
+		QueuedAt:   run.queuedAt,
+		StartTime:  run.startTime,
+	}
+	var err error
+	if s.Sniff {
+		limits := DefaultSpecLimits
+		if s.SpecLimits != nil {
+			limits = *s.SpecLimits
+		}
+		var sniffed *Sniffed
+		if sniffed, err = SniffLimited(run.conn, limits); err == nil {
+			bench = sniffed.Benchmark
+			s.mutex.Lock()
+			run.bench = bench
+			s.mutex.Unlock()
+			err = sniffed.Run(counters...)
+		}
+	} else if s.Write {
+		err = bench.Writer(run.conn, counters...)
+	} else {
+		err = bench.Reader(run.conn, counters...)
+	}
+	run.conn.Close()
+	result.EndTime = time.Now()
+	result.QueueWait = result.StartTime.Sub(result.QueuedAt)
+	result.RunTime = result.EndTime.Sub(result.StartTime)
+	if bench != nil {
+		result.Benchmark = benchmarkType(bench)
+		result.Result = bench.Result()
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	s.Results.Add(result)
+	for _, exporter := range s.Exporters {
+		if err := exporter.Export(context.Background(), result); err != nil {
+			s.onError(fmt.Errorf("failed to export the result of run %d: %w", result.ID, err))
+		}
+	}
+}
+
+func (s *Service) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Service) onError(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// ResultStore keeps the latest results of a Service in memory, safe for
+// concurrent use, e.g., to serve them over HTTP.
+type ResultStore struct {
+	mutex   sync.Mutex
+	size    int
+	results []ServiceResult
+}
+
+// NewResultStore creates a store keeping the latest size results.
+func NewResultStore(size int) *ResultStore {
+	if size < 1 {
+		size = 1
+	}
+	return &ResultStore{size: size}
+}
+
+// Add adds result to the store, dropping the oldest result if full.
+func (r *ResultStore) Add(result ServiceResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.results) == r.size {
+		r.results = append(r.results[:0], r.results[1:]...)
+	}
+	r.results = append(r.results, result)
+}
+
+// Results returns the results kept, oldest first.
+func (r *ResultStore) Results() []ServiceResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]ServiceResult(nil), r.results...)
+}
+
+// Latest returns the result of the latest run, false if none ended yet.
+func (r *ResultStore) Latest() (ServiceResult, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.results) == 0 {
+		return ServiceResult{}, false
+	}
+	return r.results[len(r.results)-1], true
+}
@@ -0,0 +1,229 @@
+package benchmarkconn_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestService(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var exported []ServiceResult
+	service := &Service{
+		Listeners: []net.Listener{listener},
+		New: func() Benchmark {
+			return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		},
+		QueueSize: 4,
+		Exporters: []ResultExporter{ResultExporterFunc(func(_ context.Context, result ServiceResult) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			exported = append(exported, result)
+			return nil
+		})},
+	}
+	if err := service.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		if err := writer.Writer(conn); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(service.Results.Results()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := service.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Start(); err != ErrServiceClosed {
+		t.Errorf("expected the service to be closed, got %v", err)
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+
+	results := service.Results.Results()
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.ID != uint64(i+1) || result.Benchmark != "pressure" || result.Error != "" {
+			t.Errorf("expected run %d of pressure to succeed, got %+v", i+1, result)
+		}
+		if result.Result["bytes_read"] != uint64(100*1024) {
+			t.Errorf("expected the whole run to be read, got %v", result.Result["bytes_read"])
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(exported) != 3 {
+		t.Errorf("expected every result to be exported, got %d", len(exported))
+	}
+}
+
+func TestServiceShutdownTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &Service{
+		Listeners: []net.Listener{listener},
+		New: func() Benchmark {
+			return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		},
+	}
+	if err := service.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a peer which never completes the handshake
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := service.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the shutdown to time out, got %v, %+v", err, service.Results.Results())
+	}
+
+	results := service.Results.Results()
+	if len(results) != 1 || results[0].Error == "" {
+		t.Errorf("expected the interrupted run to fail, got %+v", results)
+	}
+}
+
+func TestServicePriority(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var priority atomic.Int64
+	service := &Service{
+		Listeners: []net.Listener{listener},
+		New: func() Benchmark {
+			return &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+		},
+		Priority:  func(net.Conn) int { return int(priority.Add(1)) }, // later connections first
+		QueueSize: 3,
+	}
+	if err := service.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Shutdown(context.Background())
+
+	// the first connection runs, waiting for the handshake, and the others
+	// queue up
+	var conns []net.Conn
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(service.Queued()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	queued := service.Queued()
+	if len(queued) != 3 {
+		t.Fatalf("expected 3 queued connections, got %+v", queued)
+	}
+	for i, status := range queued {
+		if status.ID != uint64(4-i) || status.Position != i+1 {
+			t.Errorf("expected run %d at position %d, got %+v", 4-i, i+1, status)
+		}
+	}
+	if running := service.Running(); len(running) != 1 || running[0].ID != 1 {
+		t.Errorf("expected the first run to be running, got %+v", running)
+	}
+
+	// a waiting peer looks up its position by its address
+	status := httptest.NewServer(service.StatusHandler())
+	defer status.Close()
+	get := func(path string, v any) int {
+		resp, err := http.Get(status.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+	var position ServiceStatus
+	if code := get("/queued?remote_addr="+conns[1].LocalAddr().String(), &position); code != http.StatusOK || position.ID != 2 || position.Position != 3 {
+		t.Errorf("expected run 2 at position 3, got %d, %+v", code, position)
+	}
+	if code := get("/queued?remote_addr="+conns[0].LocalAddr().String(), &position); code != http.StatusNotFound {
+		t.Errorf("expected the running connection not to be queued, got %d", code)
+	}
+	var all []ServiceStatus
+	if code := get("/queued", &all); code != http.StatusOK || len(all) != 3 {
+		t.Errorf("expected 3 queued connections, got %d, %+v", code, all)
+	}
+	if code := get("/running", &all); code != http.StatusOK || len(all) != 1 || all[0].ID != 1 {
+		t.Errorf("expected the first run to be running, got %d, %+v", code, all)
+	}
+
+	// fail the runs one after another by closing their connections
+	time.Sleep(20 * time.Millisecond)
+	for _, conn := range conns {
+		conn.Close()
+	}
+	for len(service.Results.Results()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	results := service.Results.Results()
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	for i, id := range []uint64{1, 4, 3, 2} {
+		if results[i].ID != id {
+			t.Errorf("expected run %d to be the %d-th to run, got %d", id, i+1, results[i].ID)
+		}
+	}
+	if last := results[3]; last.QueueWait < 20*time.Millisecond || last.QueueWait != last.StartTime.Sub(last.QueuedAt) {
+		t.Errorf("expected the wait in the queue to be recorded, got %s", last.QueueWait)
+	}
+}
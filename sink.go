@@ -0,0 +1,182 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ResultReport is a result handed to a ResultSink: the result of a run
+// once it ended, or an interim snapshot of a run in progress.
+type ResultReport struct {
+	Benchmark string            `json:"benchmark"`         // Benchmark names the benchmark run, e.g., pressure
+	Time      time.Time         `json:"time"`              // Time is when the result was taken
+	Interim   bool              `json:"interim,omitempty"` // Interim is set for the snapshots of a run in progress rather than its result
+	Labels    map[string]string `json:"labels,omitempty"`  // Labels describe the run, e.g., its operation and address
+	Result    map[string]any    `json:"result,omitempty"`  // Result is the result of the run, or the snapshot
+	Error     string            `json:"error,omitempty"`   // Error is the error the run failed with, if any
+}
+
+// ResultSink receives results, e.g., to write them to a file or to send
+// them to a collector, so they need not be scraped from the logs. Sinks
+// must be safe for concurrent use, as parallel runs may report at once.
+type ResultSink interface {
+	WriteResult(ctx context.Context, report ResultReport) error
+}
+
+// ResultSinkFunc adapts a function to a ResultSink.
+type ResultSinkFunc func(ctx context.Context, report ResultReport) error
+
+func (f ResultSinkFunc) WriteResult(ctx context.Context, report ResultReport) error {
+	return f(ctx, report)
+}
+
+// writerSink writes each report as a line of JSON to a writer.
+type writerSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewWriterSink returns a sink writing each report as a line of JSON to w,
+// e.g., os.Stdout, one report at a time.
+func NewWriterSink(w io.Writer) ResultSink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) WriteResult(_ context.Context, report ResultReport) error {
+	line, err := marshalReport(report)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.w.Write(line)
+	return err
+}
+
+// fileSink appends each report as a line of JSON to a file.
+type fileSink struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// NewFileSink returns a sink appending each report as a line of JSON to
+// the file at path, created if missing. The file is opened for each
+// report, so the sink needs no closing and the file may be rotated.
+func NewFileSink(path string) ResultSink {
+	return &fileSink{path: path}
+}
+
+func (s *fileSink) WriteResult(_ context.Context, report ResultReport) error {
+	line, err := marshalReport(report)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// httpSink POSTs each report as JSON to a URL.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a sink POSTing each report as JSON to url, e.g., of
+// a collector, with client, http.DefaultClient if nil. A response status
+// other than 2xx fails the write.
+func NewHTTPSink(url string, client *http.Client) ResultSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSink{url: url, client: client}
+}
+
+func (s *httpSink) WriteResult(ctx context.Context, report ResultReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) // drain to reuse the connection
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// multiSink fans each report out to several sinks.
+type multiSink []ResultSink
+
+// MultiSink returns a sink writing each report to all sinks in order, like
+// io.MultiWriter. Unlike io.MultiWriter, a failing sink does not keep the
+// report from the others, and the errors of all failing sinks are joined.
+func MultiSink(sinks ...ResultSink) ResultSink {
+	return multiSink(append([]ResultSink(nil), sinks...))
+}
+
+func (m multiSink) WriteResult(ctx context.Context, report ResultReport) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.WriteResult(ctx, report); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SinkExporter returns an exporter writing the results of a Service to
+// sink, labeled with the ID of the run, the listener and the address of
+// the peer.
+func SinkExporter(sink ResultSink) ResultExporter {
+	return ResultExporterFunc(func(ctx context.Context, result ServiceResult) error {
+		return sink.WriteResult(ctx, ResultReport{
+			Benchmark: result.Benchmark,
+			Time:      result.EndTime,
+			Labels: map[string]string{
+				"id":          strconv.FormatUint(result.ID, 10),
+				"listener":    result.Listener,
+				"remote_addr": result.RemoteAddr,
+			},
+			Result: result.Result,
+			Error:  result.Error,
+		})
+	})
+}
+
+func marshalReport(report ResultReport) ([]byte, error) {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
@@ -0,0 +1,99 @@
+package benchmarkconn_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestResultSinks(t *testing.T) {
+	report := ResultReport{
+		Benchmark: "pressure",
+		Time:      time.Unix(1700000000, 0).UTC(),
+		Labels:    map[string]string{"operation": "write"},
+		Result:    map[string]any{"throughput_Mbps": 100.5},
+	}
+
+	var buf bytes.Buffer
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	var posted []ResultReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got ResultReport
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&got) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		posted = append(posted, got)
+	}))
+	defer server.Close()
+
+	sink := MultiSink(NewWriterSink(&buf), NewFileSink(path), NewHTTPSink(server.URL, nil))
+	for i := 0; i < 2; i++ {
+		if err := sink.WriteResult(context.Background(), report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, output := range []string{buf.String(), string(data)} {
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected a line per report, got %q", output)
+		}
+		var got ResultReport
+		if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Benchmark != "pressure" || got.Labels["operation"] != "write" || got.Result["throughput_Mbps"] != 100.5 || !got.Time.Equal(report.Time) {
+			t.Errorf("expected the report, got %+v", got)
+		}
+	}
+	if len(posted) != 2 || posted[0].Benchmark != "pressure" {
+		t.Errorf("expected the reports to be posted, got %+v", posted)
+	}
+}
+
+func TestMultiSinkErrors(t *testing.T) {
+	var buf bytes.Buffer
+	failing := ResultSinkFunc(func(context.Context, ResultReport) error { return errors.New("unreachable") })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := MultiSink(failing, NewHTTPSink(server.URL, nil), NewWriterSink(&buf)).WriteResult(context.Background(), ResultReport{Benchmark: "echo"})
+	if err == nil || !strings.Contains(err.Error(), "unreachable") || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the errors of both failing sinks, got %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Error("expected the report to reach the sinks after the failing ones")
+	}
+}
+
+func TestSinkExporter(t *testing.T) {
+	var got ResultReport
+	exporter := SinkExporter(ResultSinkFunc(func(_ context.Context, report ResultReport) error {
+		got = report
+		return nil
+	}))
+	end := time.Now()
+	if err := exporter.Export(context.Background(), ServiceResult{ID: 7, Benchmark: "pressure", Listener: "127.0.0.1:8080", EndTime: end, Error: "failed"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Benchmark != "pressure" || got.Labels["id"] != "7" || got.Labels["listener"] != "127.0.0.1:8080" || !got.Time.Equal(end) || got.Error != "failed" {
+		t.Errorf("expected the service result as a report, got %+v", got)
+	}
+}
@@ -0,0 +1,388 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SizeDistribution draws the size of each message, since real traffic is
+// rarely fixed-size. It is part of the benchmark spec and must match on
+// both sides.
+type SizeDistribution struct {
+	Kind    string    `json:"kind" yaml:"kind"`                 // Kind is one of "fixed", "uniform", "lognormal" or "weighted"
+	Min     int       `json:"min,omitempty" yaml:"min"`         // Min is the smallest size, and the only size for "fixed"
+	Max     int       `json:"max,omitempty" yaml:"max"`         // Max is the largest size, lognormal sizes are clamped to [Min, Max]
+	Mu      float64   `json:"mu,omitempty" yaml:"mu"`           // Mu is the mean of the natural logarithm of the size for "lognormal"
+	Sigma   float64   `json:"sigma,omitempty" yaml:"sigma"`     // Sigma is the standard deviation of the natural logarithm of the size for "lognormal"
+	Sizes   []int     `json:"sizes,omitempty" yaml:"sizes"`     // Sizes lists the possible sizes for "weighted"
+	Weights []float64 `json:"weights,omitempty" yaml:"weights"` // Weights lists the relative weight of each of Sizes for "weighted"
+
+	Seed int64 `json:"-" yaml:"seed"` // Seed seeds the sizes drawn by the writer for reproducible runs, time-based if 0. It does not need to match the peer
+}
+
+// FixedSize returns a distribution always drawing size.
+func FixedSize(size int) *SizeDistribution {
+	return &SizeDistribution{Kind: "fixed", Min: size, Max: size}
+}
+
+// UniformSize returns a distribution drawing sizes uniformly from [min, max].
+func UniformSize(min, max int) *SizeDistribution {
+	return &SizeDistribution{Kind: "uniform", Min: min, Max: max}
+}
+
+// LogNormalSize returns a distribution drawing sizes whose natural
+// logarithm is normally distributed with mean mu and standard deviation
+// sigma, clamped to [min, max]. The median size is e^mu.
+func LogNormalSize(mu, sigma float64, min, max int) *SizeDistribution {
+	return &SizeDistribution{Kind: "lognormal", Min: min, Max: max, Mu: mu, Sigma: sigma}
+}
+
+// WeightedSizes returns a distribution drawing each of sizes with a
+// probability proportional to its weight.
+func WeightedSizes(sizes []int, weights []float64) *SizeDistribution {
+	d := &SizeDistribution{Kind: "weighted", Sizes: sizes, Weights: weights}
+	for i, size := range sizes {
+		if i == 0 || size < d.Min {
+			d.Min = size
+		}
+		if size > d.Max {
+			d.Max = size
+		}
+	}
+	return d
+}
+
+// maxFrameSize bounds the message size so that it never collides with
+// endFrameLength.
+const maxFrameSize = 1 << 30
+
+// Validate checks that the distribution only draws sizes of at least 1
+// byte and at most 1 GiB.
+func (d *SizeDistribution) Validate() error {
+	if d.Min < 1 || d.Max < d.Min || d.Max > maxFrameSize {
+		return fmt.Errorf("message sizes must be within [1, %d], got [%d, %d]", maxFrameSize, d.Min, d.Max)
+	}
+
+	switch d.Kind {
+	case "fixed":
+		if d.Min != d.Max {
+			return errors.New("fixed message size must have equal min and max")
+		}
+	case "uniform":
+	case "lognormal":
+		if d.Sigma < 0 || math.IsNaN(d.Mu) || math.IsInf(d.Mu, 0) {
+			return errors.New("lognormal message size must have a finite mu and a non-negative sigma")
+		}
+	case "weighted":
+		if len(d.Sizes) == 0 || len(d.Sizes) != len(d.Weights) {
+			return errors.New("weighted message sizes must have as many weights as sizes")
+		}
+		var total float64
+		for i, weight := range d.Weights {
+			if weight < 0 || d.Sizes[i] < d.Min || d.Sizes[i] > d.Max {
+				return errors.New("weighted message sizes must have non-negative weights and sizes within [min, max]")
+			}
+			total += weight
+		}
+		if total <= 0 {
+			return errors.New("weighted message sizes must have a positive total weight")
+		}
+	default:
+		return fmt.Errorf("unknown message size distribution %q", d.Kind)
+	}
+	return nil
+}
+
+// String returns the distribution in the format parsed by
+// ParseSizeDistribution.
+func (d *SizeDistribution) String() string {
+	switch d.Kind {
+	case "fixed":
+		return fmt.Sprintf("fixed:%d", d.Min)
+	case "uniform":
+		return fmt.Sprintf("uniform:%d-%d", d.Min, d.Max)
+	case "lognormal":
+		return fmt.Sprintf("lognormal:%.6g:%g:%d-%d", math.Exp(d.Mu), d.Sigma, d.Min, d.Max)
+	case "weighted":
+		entries := make([]string, len(d.Sizes))
+		for i, size := range d.Sizes {
+			entries[i] = fmt.Sprintf("%d=%g", size, d.Weights[i])
+		}
+		return "weighted:" + strings.Join(entries, ",")
+	default:
+		return d.Kind
+	}
+}
+
+// Mean returns the expected message size.
+func (d *SizeDistribution) Mean() float64 {
+	switch d.Kind {
+	case "lognormal": // ignoring the clamping
+		return min(max(math.Exp(d.Mu+d.Sigma*d.Sigma/2), float64(d.Min)), float64(d.Max))
+	case "weighted":
+		var sum, total float64
+		for i, size := range d.Sizes {
+			sum += float64(size) * d.Weights[i]
+			total += d.Weights[i]
+		}
+		return sum / total
+	default:
+		return float64(d.Min+d.Max) / 2
+	}
+}
+
+// sampler returns a function drawing message sizes from the distribution,
+// which must be valid. It is not safe for concurrent use.
+func (d *SizeDistribution) sampler() func() int {
+	seed := d.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	switch d.Kind {
+	case "uniform":
+		return func() int {
+			return d.Min + rng.Intn(d.Max-d.Min+1)
+		}
+	case "lognormal":
+		return func() int {
+			size := math.Round(math.Exp(d.Mu + d.Sigma*rng.NormFloat64()))
+			return int(min(max(size, float64(d.Min)), float64(d.Max)))
+		}
+	case "weighted":
+		var total float64
+		for _, weight := range d.Weights {
+			total += weight
+		}
+		return func() int {
+			x := rng.Float64() * total
+			for i, weight := range d.Weights {
+				if x < weight {
+					return d.Sizes[i]
+				}
+				x -= weight
+			}
+			return d.Sizes[len(d.Sizes)-1] // rounding
+		}
+	default:
+		return func() int {
+			return d.Min
+		}
+	}
+}
+
+// ParseSizeDistribution parses a message size distribution specification
+// as used by the command line tools:
+//
+//   - "fixed:<size>" for FixedSize
+//   - "uniform:<min>-<max>" for UniformSize
+//   - "lognormal:<median>:<sigma>:<min>-<max>" for LogNormalSize
+//   - "weighted:<size>=<weight>,<size>=<weight>,..." for WeightedSizes
+func ParseSizeDistribution(spec string) (*SizeDistribution, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+
+	var d *SizeDistribution
+	switch kind {
+	case "fixed":
+		size, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed message size: %w", err)
+		}
+		d = FixedSize(size)
+	case "uniform":
+		min, max, err := parseSizeRange(arg)
+		if err != nil {
+			return nil, err
+		}
+		d = UniformSize(min, max)
+	case "lognormal":
+		fields := strings.Split(arg, ":")
+		if len(fields) != 3 {
+			return nil, errors.New("lognormal message size must be <median>:<sigma>:<min>-<max>")
+		}
+		median, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || median <= 0 {
+			return nil, errors.New("invalid lognormal median message size")
+		}
+		sigma, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lognormal sigma: %w", err)
+		}
+		min, max, err := parseSizeRange(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		d = LogNormalSize(math.Log(median), sigma, min, max)
+	case "weighted":
+		var sizes []int
+		var weights []float64
+		for _, entry := range strings.Split(arg, ",") {
+			sizeStr, weightStr, ok := strings.Cut(entry, "=")
+			if !ok {
+				return nil, fmt.Errorf("weighted message size %q must be <size>=<weight>", entry)
+			}
+			size, err := strconv.Atoi(sizeStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weighted message size: %w", err)
+			}
+			weight, err := strconv.ParseFloat(weightStr, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight: %w", err)
+			}
+			sizes = append(sizes, size)
+			weights = append(weights, weight)
+		}
+		d = WeightedSizes(sizes, weights)
+	default:
+		return nil, fmt.Errorf("unknown message size distribution %q", kind)
+	}
+
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func parseSizeRange(s string) (int, int, error) {
+	minStr, maxStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("message size range %q must be <min>-<max>", s)
+	}
+	min, err := strconv.Atoi(minStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid minimum message size: %w", err)
+	}
+	max, err := strconv.Atoi(maxStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maximum message size: %w", err)
+	}
+	return min, max, nil
+}
+
+// frameHeaderSize is the size of the big-endian length header preceding
+// each message when message sizes follow a distribution.
+const frameHeaderSize = 4
+
+// endFrameLength is the length header of the frame ending a run with a
+// target duration, followed by the 8-byte number of messages sent.
+const endFrameLength = math.MaxUint32
+
+// messageFraming reads and writes messages, either of a fixed size or,
+// with a size distribution, each preceded by its length.
+type messageFraming struct {
+	size   int        // the fixed message size, or the largest frame with a size distribution
+	sample func() int // draws the size of the next message, nil for fixed-size messages
+}
+
+// newMessageFraming returns the framing of messages of messageSize, or of
+// sizes drawn from sizes if not nil.
+func newMessageFraming(messageSize int, sizes *SizeDistribution) *messageFraming {
+	if sizes == nil {
+		return &messageFraming{size: messageSize}
+	}
+	return &messageFraming{
+		size:   frameHeaderSize + max(sizes.Max, minEndMarkerSize), // room for the end and warmup markers
+		sample: sizes.sampler(),
+	}
+}
+
+// bufferSize returns the size of a buffer fitting any message.
+func (f *messageFraming) bufferSize() int {
+	return f.size
+}
+
+// next returns the next message to send in buf, which must be of
+// bufferSize, with its length header written.
+func (f *messageFraming) next(buf []byte) []byte {
+	if f.sample == nil {
+		return buf
+	}
+	n := f.sample()
+	binary.BigEndian.PutUint32(buf[:frameHeaderSize], uint32(n))
+	return buf[:frameHeaderSize+n]
+}
+
+// payload returns the part of msg after its length header.
+func (f *messageFraming) payload(msg []byte) []byte {
+	if f.sample == nil {
+		return msg
+	}
+	return msg[frameHeaderSize:]
+}
+
+// read reads the next message into buf, which must be of bufferSize.
+func (f *messageFraming) read(r io.Reader, buf []byte) ([]byte, error) {
+	if f.sample == nil {
+		_, err := io.ReadFull(r, buf) // read full length of the message
+		return buf, err
+	}
+
+	if _, err := io.ReadFull(r, buf[:frameHeaderSize]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(buf[:frameHeaderSize])
+	if n == endFrameLength {
+		n = 8
+	} else if int(n) > f.size-frameHeaderSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the maximum message size", n)
+	}
+	if _, err := io.ReadFull(r, buf[frameHeaderSize:frameHeaderSize+n]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf[:frameHeaderSize+n], nil
+}
+
+// endMarker returns the message ending a run with a target duration,
+// carrying the number of messages sent.
+func (f *messageFraming) endMarker(sent uint64) []byte {
+	if f.sample == nil {
+		return endMarker(f.size, sent)
+	}
+	marker := make([]byte, frameHeaderSize+8)
+	binary.BigEndian.PutUint32(marker[:frameHeaderSize], endFrameLength)
+	binary.BigEndian.PutUint64(marker[frameHeaderSize:], sent)
+	return marker
+}
+
+// parseEndMarker returns the number of messages sent if msg is an end
+// marker.
+func (f *messageFraming) parseEndMarker(msg []byte) (uint64, bool) {
+	if f.sample == nil {
+		return parseEndMarker(msg)
+	}
+	if len(msg) != frameHeaderSize+8 || binary.BigEndian.Uint32(msg[:frameHeaderSize]) != endFrameLength {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(msg[frameHeaderSize:]), true
+}
+
+// validateSizeDistribution checks that sizes is valid and compatible with
+// the other options of the benchmark.
+func validateSizeDistribution(sizes *SizeDistribution, verify bool) error {
+	if sizes == nil {
+		return nil
+	}
+	if verify {
+		return errors.New("verification requires a fixed message size")
+	}
+	return sizes.Validate()
+}
+
+// minMessageSize returns the smallest payload size of the messages, those
+// drawn from sizes if not nil.
+func minMessageSize(messageSize int, sizes *SizeDistribution) int {
+	if sizes == nil {
+		return messageSize
+	}
+	return sizes.Min
+}
@@ -0,0 +1,99 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestParseSizeDistribution(t *testing.T) {
+	for _, spec := range []string{
+		"fixed:1024",
+		"uniform:64-1500",
+		"lognormal:512:1:64-65536",
+		"weighted:64=0.5,1500=0.5",
+	} {
+		d, err := ParseSizeDistribution(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if d.String() != spec {
+			t.Errorf("expected %s, got %s", spec, d)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"fixed:0",
+		"uniform:1500-64",
+		"lognormal:512:-1:64-65536",
+		"weighted:64=0,1500=0",
+		"weighted:64",
+		"pareto:1",
+	} {
+		if _, err := ParseSizeDistribution(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestPressuredBenchmarkSizeDistribution(t *testing.T) {
+	sizes := WeightedSizes([]int{64, 1500}, []float64{1, 1})
+	writer := &PressuredBenchmark{TotalMessages: 1000, SizeDistribution: sizes}
+	reader := &PressuredBenchmark{TotalMessages: 1000, SizeDistribution: sizes}
+	runOverTCP(t, writer, reader)
+
+	writerResult, readerResult := writer.Result(), reader.Result()
+	if reads := readerResult["successful_reads"]; reads != uint64(1000) {
+		t.Fatalf("expected 1000 messages read, got %v", reads)
+	}
+
+	// each message carries a 4-byte length header
+	bytesRead := readerResult["bytes_read"].(uint64)
+	if bytesRead != writerResult["bytes_written"] {
+		t.Errorf("expected %v bytes read, got %v", writerResult["bytes_written"], bytesRead)
+	}
+	if bytesRead <= 1000*(4+64) || bytesRead >= 1000*(4+1500) {
+		t.Errorf("expected a mix of message sizes, got %v bytes", bytesRead)
+	}
+}
+
+func TestSizeDistributionSeed(t *testing.T) {
+	run := func(seed int64) any {
+		writer := &PressuredBenchmark{TotalMessages: 1000, SizeDistribution: &SizeDistribution{Kind: "uniform", Min: 1, Max: 4096, Seed: seed}}
+		reader := &PressuredBenchmark{TotalMessages: 1000, SizeDistribution: UniformSize(1, 4096)}
+		runOverTCP(t, writer, reader)
+		return writer.Result()["bytes_written"]
+	}
+
+	// the seed is local to the writer, so the reader does not need it
+	if first, second := run(42), run(42); first != second {
+		t.Errorf("expected the same sizes with the same seed, got %v and %v bytes", first, second)
+	}
+}
+
+func TestIntervalBenchmarkSizeDistribution(t *testing.T) {
+	for _, profile := range []Profile{ProfileDefault, ProfileConstrained} {
+		sizes := UniformSize(1, 2048)
+		writer := &IntervalBenchmark{Interval: time.Millisecond, Echo: true, TargetDuration: 200 * time.Millisecond, SizeDistribution: sizes, Profile: profile}
+		reader := &IntervalBenchmark{Interval: time.Millisecond, Echo: true, TargetDuration: 200 * time.Millisecond, SizeDistribution: sizes, Profile: profile}
+		runOverTCP(t, writer, reader)
+
+		writerResult, readerResult := writer.Result(), reader.Result()
+		if writerResult["successful_writes"] != readerResult["successful_reads"] {
+			t.Errorf("%s: expected %v messages read, got %v", profile, writerResult["successful_writes"], readerResult["successful_reads"])
+		}
+		if _, ok := writerResult["latency_ns"]; !ok {
+			t.Errorf("%s: expected echoes to be matched", profile)
+		}
+	}
+}
+
+func TestSizeDistributionVerify(t *testing.T) {
+	writer := &PressuredBenchmark{TotalMessages: 1, Verify: true, SizeDistribution: FixedSize(1024)}
+	if err := writer.Writer(nil); err == nil {
+		t.Error("expected verification to require a fixed message size")
+	}
+}
@@ -0,0 +1,46 @@
+package benchmarkconn
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RunSnapshot is a view of a benchmark run, taken while it runs or once it
+// ended, see (*PressuredBenchmark).Snapshot.
+type RunSnapshot struct {
+	Running         bool          // Running is set while the measurement is in progress
+	StartTime       time.Time     // StartTime is when the measurement started, zero before the first run
+	Elapsed         time.Duration // Elapsed is the time since the start of the measurement, until its end once it ended
+	MessagesRead    uint64        // MessagesRead counts the messages read, or echoes received by the writer of an echo benchmark
+	MessagesWritten uint64        // MessagesWritten counts the messages written
+	BytesRead       uint64        // BytesRead counts the bytes read, excluding echoes received
+	BytesWritten    uint64        // BytesWritten counts the bytes written
+	ThroughputBps   float64       // ThroughputBps is the mean throughput in bits per second since the start of the measurement
+}
+
+// takeSnapshot returns a view of the run timed by startTime and endTime,
+// the latter being zero while it runs, with its counts loaded from the
+// others.
+func takeSnapshot(startTime, endTime *atomic.Value, reads, writes, bytesRead, bytesWritten *atomic.Uint64) RunSnapshot {
+	start, _ := startTime.Load().(time.Time)
+	if start.IsZero() {
+		return RunSnapshot{}
+	}
+	end, _ := endTime.Load().(time.Time)
+
+	s := RunSnapshot{
+		Running:         end.IsZero(),
+		StartTime:       start,
+		MessagesRead:    reads.Load(),
+		MessagesWritten: writes.Load(),
+		BytesRead:       bytesRead.Load(),
+		BytesWritten:    bytesWritten.Load(),
+	}
+	if s.Running {
+		end = time.Now()
+	}
+	if s.Elapsed = end.Sub(start); s.Elapsed > 0 {
+		s.ThroughputBps = float64(s.BytesRead+s.BytesWritten) * 8 / s.Elapsed.Seconds()
+	}
+	return s
+}
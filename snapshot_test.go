@@ -0,0 +1,110 @@
+package benchmarkconn_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPressuredBenchmarkSnapshot(t *testing.T) {
+	writer := &PressuredBenchmark{MessageSize: 1024, TargetDuration: 500 * time.Millisecond, TargetBandwidth: 1 << 20}
+	reader := &PressuredBenchmark{MessageSize: 1024, TargetDuration: 500 * time.Millisecond, TargetBandwidth: 1 << 20}
+	if s := reader.Snapshot(); s.Running || !s.StartTime.IsZero() {
+		t.Errorf("expected an empty snapshot before the run, got %+v", s)
+	}
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := writer.Writer(writerConn); err != nil {
+			t.Errorf("Writer errored: %v", err)
+		}
+	}()
+
+	// monitor the reader while it runs
+	done := make(chan struct{})
+	var running []RunSnapshot
+	go func() {
+		defer close(done)
+		for range time.Tick(50 * time.Millisecond) {
+			s := reader.Snapshot()
+			if s.Running {
+				running = append(running, s)
+				if len(reader.Result()) != 0 {
+					t.Errorf("expected no result while running")
+				}
+			} else if !s.StartTime.IsZero() {
+				return
+			}
+		}
+	}()
+	if err := reader.Reader(readerConn); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	<-done
+
+	if len(running) < 2 {
+		t.Fatalf("expected snapshots while running, got %+v", running)
+	}
+	last := running[len(running)-1]
+	if last.MessagesRead == 0 || last.BytesRead != last.MessagesRead*1024 || last.ThroughputBps <= 0 {
+		t.Errorf("expected the progress of the reader, got %+v", last)
+	}
+	final := reader.Snapshot()
+	if final.Running || final.MessagesRead < last.MessagesRead || final.Elapsed < last.Elapsed {
+		t.Errorf("expected the snapshot of the whole run, got %+v after %+v", final, last)
+	}
+}
+
+func TestServiceRunning(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &Service{
+		Listeners: []net.Listener{listener},
+		New: func() Benchmark {
+			return &PressuredBenchmark{MessageSize: 1024, TargetDuration: 300 * time.Millisecond, TargetBandwidth: 1 << 20}
+		},
+	}
+	if err := service.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	writer := &PressuredBenchmark{MessageSize: 1024, TargetDuration: 300 * time.Millisecond, TargetBandwidth: 1 << 20}
+	go writer.Writer(conn)
+
+	time.Sleep(150 * time.Millisecond)
+	running := service.Running()
+	if len(running) != 1 || running[0].ID != 1 || running[0].Benchmark != "pressure" {
+		t.Fatalf("expected the run in progress, got %+v", running)
+	}
+	if !running[0].Snapshot.Running || running[0].Snapshot.MessagesRead == 0 {
+		t.Errorf("expected the progress of the run, got %+v", running[0].Snapshot)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(service.Results.Results()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if running := service.Running(); len(running) != 0 {
+		t.Errorf("expected no run in progress, got %+v", running)
+	}
+}
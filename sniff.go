@@ -0,0 +1,142 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// Sniffed is the benchmark a peer requested in its handshake, see Sniff.
+type Sniffed struct {
+	Benchmark   Benchmark // Benchmark is a new benchmark of the type and spec requested by the peer
+	Type        string    // Type is the type of benchmark requested, e.g., pressure
+	Write       bool      // Write is set if the peer runs the reader side, so that the writer side must run here
+	PeerVersion string    // PeerVersion is the version of benchmarkconn the peer runs, if sent
+	Conn        net.Conn  // Conn replays the handshake of the peer before reading from the sniffed connection
+}
+
+// Sniff reads the handshake a peer sends first on conn and creates the
+// benchmark it requests, with its spec, so that a server can run whatever
+// its clients ask for instead of one type of benchmark set in advance.
+//
+// The built-in benchmarks are those accepted by cmd/client, except those
+// dialing or accepting connections of their own, e.g., churn, and the
+// bandwidth estimate. Other benchmarks are created by the registered
+// factory whose benchmarks send the type requested, see Register. Run the
+// benchmark over the returned Conn, e.g., with Sniffed.Run, so that its
+// own handshake reads the one sniffed.
+//
+// The spec must be within DefaultSpecLimits, see SniffLimited for other
+// limits. Benchmarks whose handshake goes over a ControlChannel cannot be
+// sniffed.
+func Sniff(conn net.Conn) (*Sniffed, error) {
+	return SniffLimited(conn, DefaultSpecLimits)
+}
+
+// SniffLimited is like Sniff, but fails if the spec of the peer is not
+// within limits rather than DefaultSpecLimits.
+func SniffLimited(conn net.Conn, limits SpecLimits) (*Sniffed, error) {
+	var raw json.RawMessage
+	if err := readSpec(conn, &raw); err != nil {
+		return nil, err
+	}
+
+	var peer handshakeMessage
+	if err := json.Unmarshal(raw, &peer); err != nil {
+		return nil, fmt.Errorf("failed to sniff the handshake: %w", err)
+	}
+	if peer.Role != roleWriter && peer.Role != roleReader {
+		return nil, fmt.Errorf("failed to sniff the handshake: unknown role %q", peer.Role)
+	}
+
+	bench, err := newSniffedBenchmark(peer.Benchmark)
+	if err != nil {
+		return nil, err
+	}
+	if err := limits.Check(peer.Spec); err != nil {
+		return nil, fmt.Errorf("failed to sniff the %s spec: %w", peer.Benchmark, err)
+	}
+	if err := json.Unmarshal(peer.Spec, bench); err != nil {
+		return nil, fmt.Errorf("failed to sniff the %s spec: %w", peer.Benchmark, err)
+	}
+
+	return &Sniffed{
+		Benchmark:   bench,
+		Type:        peer.Benchmark,
+		Write:       peer.Role == roleReader,
+		PeerVersion: peer.Version,
+		Conn:        replayConn(conn, raw),
+	}, nil
+}
+
+// Run runs the side of the benchmark opposite to the peer over Conn.
+func (s *Sniffed) Run(counters ...Counter) error {
+	if s.Write {
+		return s.Benchmark.Writer(s.Conn, counters...)
+	}
+	return s.Benchmark.Reader(s.Conn, counters...)
+}
+
+// newSniffedBenchmark creates a benchmark of type name, as sent in the
+// handshake.
+func newSniffedBenchmark(name string) (Benchmark, error) {
+	switch name {
+	case "pressure":
+		return &PressuredBenchmark{}, nil
+	case "echo":
+		return &IntervalBenchmark{Echo: true}, nil
+	case "interval":
+		return &IntervalBenchmark{}, nil
+	case "bidir":
+		return &BidirectionalBenchmark{}, nil
+	case "ramp":
+		return &RampBenchmark{}, nil
+	case "credit":
+		return &CreditBenchmark{}, nil
+	case "tinywrite":
+		return &TinyWriteProbe{}, nil
+	case "deadpeer":
+		return &DeadPeerBenchmark{}, nil
+	}
+
+	// custom benchmarks send their Go type, e.g., *main.MyBenchmark
+	for _, registered := range Registered() {
+		if bench, ok := NewRegistered(registered); ok && benchmarkType(bench) == name {
+			return bench, nil
+		}
+	}
+	return nil, fmt.Errorf("peer requested the %s benchmark, which is neither built-in nor registered", name)
+}
+
+// replayConn returns a connection reading preface before reading from
+// conn, keeping the decorators of conn, if any, recorded.
+func replayConn(conn net.Conn, preface []byte) net.Conn {
+	if c, ok := conn.(*decoratedConn); ok {
+		return &decoratedConn{Conn: replayConn(c.Conn, preface), raw: c.raw, decorators: c.decorators}
+	}
+	return &sniffedConn{Conn: conn, preface: bytes.Clone(preface)}
+}
+
+// sniffedConn replays the handshake sniffed from a connection. Datagram
+// connections read the handshake whole as before, as long as the buffer is
+// large enough for it.
+type sniffedConn struct {
+	net.Conn
+	preface []byte
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	if len(c.preface) > 0 {
+		n := copy(p, c.preface)
+		c.preface = c.preface[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// NetConn returns the sniffed connection, e.g., to read socket options
+// from.
+func (c *sniffedConn) NetConn() net.Conn {
+	return c.Conn
+}
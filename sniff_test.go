@@ -0,0 +1,224 @@
+package benchmarkconn_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestSniff(t *testing.T) {
+	run := func(t *testing.T, peer Benchmark, peerWrites bool) Benchmark {
+		listener, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		peerConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peerConn.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		peerErr := make(chan error, 1)
+		go func() {
+			if peerWrites {
+				peerErr <- peer.Writer(peerConn)
+			} else {
+				peerErr <- peer.Reader(peerConn)
+			}
+		}()
+
+		sniffed, err := Sniff(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sniffed.Write == peerWrites {
+			t.Errorf("expected the opposite role to the peer, got write=%v", sniffed.Write)
+		}
+		if sniffed.PeerVersion != Version() {
+			t.Errorf("expected the version of the peer, got %q", sniffed.PeerVersion)
+		}
+		if err := sniffed.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-peerErr; err != nil {
+			t.Fatal(err)
+		}
+		return sniffed.Benchmark
+	}
+
+	t.Run("Pressure", func(t *testing.T) {
+		bench := run(t, &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}, true)
+		if reads := bench.Result()["successful_reads"]; reads != uint64(100) {
+			t.Errorf("expected 100 messages read, got %v", reads)
+		}
+	})
+
+	t.Run("Echo", func(t *testing.T) {
+		bench := run(t, &IntervalBenchmark{MessageSize: 64, TotalMessages: 10, Interval: time.Millisecond, Echo: true}, false)
+		if _, ok := bench.(*IntervalBenchmark); !ok {
+			t.Fatalf("expected an echo benchmark, got %T", bench)
+		}
+	})
+}
+
+func TestSniffUnknown(t *testing.T) {
+	peerConn, conn := net.Pipe()
+	defer peerConn.Close()
+	defer conn.Close()
+
+	go peerConn.Write([]byte(`{"benchmark":"unknown","role":"writer","spec":{}}`))
+	if _, err := Sniff(conn); err == nil || !strings.Contains(err.Error(), "neither built-in nor registered") {
+		t.Errorf("expected an unknown benchmark to fail, got %v", err)
+	}
+}
+
+func TestSniffLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		spec   string
+		limits SpecLimits
+		field  string
+	}{
+		{"MessageSize", `{"message_size":1125899906842624,"total_messages":1}`, DefaultSpecLimits, "message_size"},
+		{"NegativeMessageSize", `{"message_size":-1,"total_messages":1}`, DefaultSpecLimits, "message_size"},
+		{"SizeDistribution", `{"message_size":64,"total_messages":1,"size_distribution":{"kind":"uniform","min":1,"max":1073741824}}`, DefaultSpecLimits, "size_distribution.max"},
+		{"TotalMessages", `{"message_size":64,"total_messages":100}`, SpecLimits{MaxMessages: 10}, "total_messages"},
+		{"TargetDuration", `{"message_size":64,"total_messages":1,"target_duration":7200000000000}`, DefaultSpecLimits, "target_duration"},
+		{"TargetBandwidth", `{"message_size":64,"total_messages":1,"target_bandwidth":1000000}`, SpecLimits{MaxBandwidth: 1000}, "target_bandwidth"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peerConn, conn := net.Pipe()
+			defer peerConn.Close()
+			defer conn.Close()
+
+			go peerConn.Write([]byte(`{"benchmark":"pressure","role":"writer","spec":` + tc.spec + `}`))
+			sniffed, err := SniffLimited(conn, tc.limits)
+			if err == nil {
+				t.Fatalf("expected the spec to be rejected, got %+v", sniffed.Benchmark)
+			}
+			if !strings.Contains(err.Error(), tc.field) {
+				t.Errorf("expected the error to name %s, got %v", tc.field, err)
+			}
+		})
+	}
+
+	t.Run("Ramp", func(t *testing.T) {
+		peerConn, conn := net.Pipe()
+		defer peerConn.Close()
+		defer conn.Close()
+
+		go peerConn.Write([]byte(`{"benchmark":"ramp","role":"writer","spec":{"message_size":64,"start_rate":1e9,"step_factor":2,"step_duration":1000000}}`))
+		if _, err := SniffLimited(conn, SpecLimits{MaxRate: 1000}); err == nil || !strings.Contains(err.Error(), "start_rate") {
+			t.Errorf("expected the rate to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestServiceSniffLimits(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &Service{
+		Listeners: []net.Listener{listener},
+		Sniff:     true,
+		QueueSize: 4,
+	}
+	if err := service.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		service.Shutdown(ctx)
+	}()
+
+	// a peer asking for an oversized message fails its own run rather
+	// than crashing the service
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte(`{"benchmark":"pressure","role":"writer","spec":{"message_size":1125899906842624,"total_messages":1}}`))
+	conn.Read(make([]byte, 1)) // until the service closes the connection
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(service.Results.Results()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	results := service.Results.Results()
+	if len(results) != 1 || !strings.Contains(results[0].Error, "message_size") {
+		t.Fatalf("expected the run to fail on the message size, got %+v", results)
+	}
+}
+
+func TestServiceSniff(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &Service{
+		Listeners: []net.Listener{listener},
+		Sniff:     true,
+		QueueSize: 4,
+	}
+	if err := service.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		service.Shutdown(ctx)
+	}()
+
+	for _, peer := range []struct {
+		bench Benchmark
+		write bool
+	}{
+		{&PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}, true},
+		{&PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}, false},
+		{&IntervalBenchmark{MessageSize: 64, TotalMessages: 10, Interval: time.Millisecond, Echo: true}, false},
+	} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peer.write {
+			err = peer.bench.Writer(conn)
+		} else {
+			err = peer.bench.Reader(conn)
+		}
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(service.Results.Results()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	results := service.Results.Results()
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for i, benchmark := range []string{"pressure", "pressure", "echo"} {
+		if results[i].Benchmark != benchmark || results[i].Error != "" {
+			t.Errorf("expected run %d of %s to succeed, got %+v", i+1, benchmark, results[i])
+		}
+	}
+}
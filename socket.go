@@ -0,0 +1,65 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// underlyingConn returns the connection underlying conn, unwrapping TLS
+// connections, so that socket options can be read from it.
+func underlyingConn(conn net.Conn) net.Conn {
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn }) // e.g., *tls.Conn
+		if !ok {
+			return conn
+		}
+		conn = wrapper.NetConn()
+	}
+}
+
+// ConnTuner applies socket options to a connection before a benchmark
+// starts, since buffer sizes often dominate throughput results. Zero
+// values leave the corresponding option to the OS default.
+//
+// The kernel may clamp the sizes, e.g., to net.core.wmem_max and
+// net.core.rmem_max on Linux; the effective sizes are recorded under
+// socket_options in the result.
+type ConnTuner struct {
+	SendBuffer    int `json:"sndbuf,omitempty" yaml:"sndbuf,omitempty"` // SendBuffer is the SO_SNDBUF size in bytes
+	ReceiveBuffer int `json:"rcvbuf,omitempty" yaml:"rcvbuf,omitempty"` // ReceiveBuffer is the SO_RCVBUF size in bytes
+}
+
+// bufferSetter is implemented by *net.TCPConn and *net.UDPConn.
+type bufferSetter interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// Tune applies the socket options to the TCP or UDP connection underlying
+// conn, unwrapping TLS connections. It returns an error if any option is
+// set and conn is neither, e.g., a QUIC stream.
+func (t ConnTuner) Tune(conn net.Conn) error {
+	if t.SendBuffer < 0 || t.ReceiveBuffer < 0 {
+		return errors.New("socket buffer sizes must not be negative")
+	}
+	if t.SendBuffer == 0 && t.ReceiveBuffer == 0 {
+		return nil
+	}
+
+	setter, ok := underlyingConn(conn).(bufferSetter)
+	if !ok {
+		return fmt.Errorf("socket buffer sizes require a TCP or UDP connection, got %T", underlyingConn(conn))
+	}
+	if t.SendBuffer > 0 {
+		if err := setter.SetWriteBuffer(t.SendBuffer); err != nil {
+			return fmt.Errorf("failed to set the send buffer size: %w", err)
+		}
+	}
+	if t.ReceiveBuffer > 0 {
+		if err := setter.SetReadBuffer(t.ReceiveBuffer); err != nil {
+			return fmt.Errorf("failed to set the receive buffer size: %w", err)
+		}
+	}
+	return nil
+}
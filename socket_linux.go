@@ -0,0 +1,69 @@
+//go:build linux
+
+package benchmarkconn
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// readSocketOptions reads back the effective options of the socket
+// underlying conn, which may differ from those requested, e.g., buffer
+// sizes clamped by net.core.wmem_max or an unavailable congestion control
+// algorithm. It returns nil if conn is not a TCP or UDP connection.
+//
+// The buffer sizes are as reported by the kernel, i.e., twice the size
+// requested to account for bookkeeping overhead.
+func readSocketOptions(conn net.Conn) map[string]any {
+	var sysConn syscall.Conn
+	var isTCP bool
+	switch c := underlyingConn(conn).(type) {
+	case *net.TCPConn:
+		sysConn, isTCP = c, true
+	case *net.UDPConn:
+		sysConn = c
+	default:
+		return nil
+	}
+	rawConn, err := sysConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	isIPv6 := false
+	if addr, ok := conn.LocalAddr().(interface{ AddrPort() netip.AddrPort }); ok {
+		isIPv6 = !addr.AddrPort().Addr().Unmap().Is4()
+	}
+
+	options := make(map[string]any)
+	if err := rawConn.Control(func(fd uintptr) {
+		if v, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF); err == nil {
+			options["sndbuf_bytes"] = v
+		}
+		if v, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF); err == nil {
+			options["rcvbuf_bytes"] = v
+		}
+		if isIPv6 {
+			if v, err := unix.GetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS); err == nil {
+				options["tos"] = v
+			}
+		} else if v, err := unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS); err == nil {
+			options["tos"] = v
+		}
+		if !isTCP {
+			return
+		}
+		if v, err := unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NODELAY); err == nil {
+			options["tcp_nodelay"] = v != 0
+		}
+		if v, err := unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION); err == nil {
+			options["tcp_congestion"] = v
+		}
+	}); err != nil {
+		return nil // connection is likely closed
+	}
+	return options
+}
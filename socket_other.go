@@ -0,0 +1,10 @@
+//go:build !linux
+
+package benchmarkconn
+
+import "net"
+
+// readSocketOptions is only supported on Linux.
+func readSocketOptions(conn net.Conn) map[string]any {
+	return nil
+}
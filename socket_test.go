@@ -0,0 +1,90 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestSocketOptions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("socket options are only read back on Linux")
+	}
+
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+	runOverTCP(t, writer, reader)
+
+	options, ok := writer.Result()["socket_options"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected the socket options to be recorded, got %v", writer.Result()["socket_options"])
+	}
+	if options["tcp_nodelay"] != true {
+		t.Errorf("expected TCP_NODELAY as set on the connection, got %v", options["tcp_nodelay"])
+	}
+	if sndbuf, _ := options["sndbuf_bytes"].(int); sndbuf <= 0 {
+		t.Errorf("expected a positive send buffer size, got %v", options["sndbuf_bytes"])
+	}
+	if cc, _ := options["tcp_congestion"].(string); cc == "" {
+		t.Errorf("expected a congestion control algorithm, got %v", options["tcp_congestion"])
+	}
+}
+
+func TestConnTuner(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if err := (ConnTuner{}).Tune(client); err != nil {
+		t.Errorf("expected no options to apply to any conn, got %v", err)
+	}
+	if err := (ConnTuner{SendBuffer: 1 << 16}).Tune(client); err == nil {
+		t.Error("expected an error for a conn without a socket")
+	}
+	if err := (ConnTuner{ReceiveBuffer: -1}).Tune(client); err == nil {
+		t.Error("expected an error for a negative buffer size")
+	}
+
+	if runtime.GOOS != "linux" {
+		t.Skip("socket options are only read back on Linux")
+	}
+
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+	senderConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer senderConn.Close()
+	receiverConn, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiverConn.Close()
+
+	tuner := ConnTuner{SendBuffer: 64 << 10, ReceiveBuffer: 32 << 10}
+	if err := tuner.Tune(senderConn); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+	reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 100}
+	done := make(chan error, 1)
+	go func() { done <- reader.Reader(receiverConn) }()
+	if err := writer.Writer(senderConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the kernel reports twice the size requested
+	options, _ := writer.Result()["socket_options"].(map[string]any)
+	if options["sndbuf_bytes"] != 2*tuner.SendBuffer || options["rcvbuf_bytes"] != 2*tuner.ReceiveBuffer {
+		t.Errorf("expected the tuned buffer sizes, got %v", options)
+	}
+}
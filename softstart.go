@@ -0,0 +1,188 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	crand "crypto/rand"
+)
+
+// Defaults of the SoftStart fields not set.
+const (
+	DefaultSoftStartWindow    = 100 * time.Millisecond
+	DefaultSoftStartSamples   = 5
+	DefaultSoftStartTolerance = 0.1
+	DefaultSoftStartTimeout   = 10 * time.Second
+)
+
+// SoftStart delays the measurement until the transport reaches a steady
+// state, e.g., once TCP left slow start, rather than for a fixed warmup.
+// The writer sends as fast as possible, sampling its throughput over
+// consecutive windows, until the last Samples samples all lie within
+// Tolerance of their mean, and then signals the reader that the
+// measurement starts. It is part of the spec, so both sides must agree on
+// it.
+type SoftStart struct {
+	Window    time.Duration `json:"window,omitempty" yaml:"window"`       // Window defines how long each throughput sample lasts, DefaultSoftStartWindow if not set
+	Samples   int           `json:"samples,omitempty" yaml:"samples"`     // Samples defines how many consecutive samples must agree, DefaultSoftStartSamples if not set
+	Tolerance float64       `json:"tolerance,omitempty" yaml:"tolerance"` // Tolerance defines how far each sample may lie from their mean, relative to it, DefaultSoftStartTolerance if not set
+	Timeout   time.Duration `json:"timeout,omitempty" yaml:"timeout"`     // Timeout defines how long to wait for a steady state before measuring regardless, DefaultSoftStartTimeout if not set
+}
+
+func (s *SoftStart) window() time.Duration {
+	if s.Window > 0 {
+		return s.Window
+	}
+	return DefaultSoftStartWindow
+}
+
+func (s *SoftStart) samples() int {
+	if s.Samples > 0 {
+		return s.Samples
+	}
+	return DefaultSoftStartSamples
+}
+
+func (s *SoftStart) tolerance() float64 {
+	if s.Tolerance > 0 {
+		return s.Tolerance
+	}
+	return DefaultSoftStartTolerance
+}
+
+func (s *SoftStart) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultSoftStartTimeout
+}
+
+// validateSoftStart checks that messages can carry the warmup marker
+// ending the soft start, which must not be lost.
+func validateSoftStart(s *SoftStart, messageSize int, datagram bool) error {
+	if s == nil {
+		return nil
+	}
+	if s.Window < 0 || s.Samples < 0 || s.Tolerance < 0 || s.Timeout < 0 {
+		return errors.New("soft start parameters must not be negative")
+	}
+	if messageSize < minWarmupMarkerSize {
+		return errors.New("soft start requires a message size of at least 16 bytes")
+	}
+	if datagram {
+		return errors.New("soft start is not supported over datagram connections")
+	}
+	return nil
+}
+
+// softStartResult is the outcome of a soft start.
+type softStartResult struct {
+	duration time.Duration // until the steady state, or until the marker arrived for the reader
+	bytes    uint64        // bytes sent or received during the soft start
+	writer   bool          // whether the fields below are known, only to the writer
+
+	stable      bool          // whether a steady state was reached before the timeout
+	steadyBytes uint64        // bytes sent over the last samples
+	steadyTime  time.Duration // the duration of the last samples
+}
+
+// sendSoftStart sends messages of messageSize bytes as fast as possible
+// until their throughput stabilizes or the timeout of s elapses, followed
+// by the warmup marker.
+func sendSoftStart(conn net.Conn, messageSize int, s *SoftStart) (*softStartResult, error) {
+	msg := make([]byte, messageSize)
+	crand.Read(msg)
+
+	type sample struct {
+		bytes    uint64
+		duration time.Duration
+	}
+	n := s.samples()
+	samples := make([]sample, 0, n)
+	result := &softStartResult{writer: true}
+
+	start := time.Now()
+	windowStart, windowBytes := start, uint64(0)
+	for {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		result.bytes += uint64(messageSize)
+		windowBytes += uint64(messageSize)
+
+		now := time.Now()
+		if elapsed := now.Sub(windowStart); elapsed >= s.window() {
+			if len(samples) == n {
+				samples = append(samples[:0], samples[1:]...) // slide the window
+			}
+			samples = append(samples, sample{windowBytes, elapsed})
+			windowStart, windowBytes = now, 0
+
+			if len(samples) == n {
+				result.steadyBytes, result.steadyTime = 0, 0
+				for _, sample := range samples {
+					result.steadyBytes += sample.bytes
+					result.steadyTime += sample.duration
+				}
+				mean := float64(result.steadyBytes) / result.steadyTime.Seconds()
+				result.stable = true
+				for _, sample := range samples {
+					if rate := float64(sample.bytes) / sample.duration.Seconds(); rate < mean*(1-s.tolerance()) || rate > mean*(1+s.tolerance()) {
+						result.stable = false
+						break
+					}
+				}
+			}
+		}
+		if result.stable || now.Sub(start) >= s.timeout() {
+			result.duration = now.Sub(start)
+			break
+		}
+	}
+
+	if _, err := conn.Write(warmupMarker(messageSize)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// receiveSoftStart receives the messages sent by sendSoftStart until the
+// warmup marker arrives.
+func receiveSoftStart(conn net.Conn, messageSize int) (*softStartResult, error) {
+	marker := warmupMarker(messageSize)
+	receivedMsg := make([]byte, messageSize)
+	result := &softStartResult{}
+
+	start := time.Now()
+	for {
+		if _, err := io.ReadFull(conn, receivedMsg); err != nil {
+			return nil, err
+		}
+		if bytes.Equal(receivedMsg, marker) {
+			break
+		}
+		result.bytes += uint64(messageSize)
+	}
+	result.duration = time.Since(start)
+	return result, nil
+}
+
+// addResult adds how long the soft start took to result as soft_start_ns
+// and the bytes transferred during it as soft_start_bytes. The writer
+// also adds whether a steady state was reached before the timeout as
+// soft_start_stable and the throughput over the last samples as
+// soft_start_throughput_bps and soft_start_throughput_Mbps.
+func (r *softStartResult) addResult(result map[string]any, profile Profile) {
+	if r == nil {
+		return
+	}
+	result["soft_start_ns"] = r.duration.Nanoseconds()
+	result["soft_start_bytes"] = r.bytes
+	if r.writer {
+		result["soft_start_stable"] = r.stable
+		addBitrate(result, "soft_start_", r.steadyBytes, r.steadyTime.Nanoseconds(), profile)
+	}
+}
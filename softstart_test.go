@@ -0,0 +1,62 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPressuredBenchmarkSoftStart(t *testing.T) {
+	t.Run("Stable", func(t *testing.T) {
+		newBenchmark := func() *PressuredBenchmark {
+			return &PressuredBenchmark{
+				MessageSize:   1024,
+				TotalMessages: 1000,
+				SoftStart:     &SoftStart{Window: 10 * time.Millisecond, Samples: 3, Tolerance: 0.9, Timeout: 5 * time.Second},
+			}
+		}
+		writer, reader := newBenchmark(), newBenchmark()
+		runOverTCP(t, writer, reader)
+
+		writerResult, readerResult := writer.Result(), reader.Result()
+		if writerResult["soft_start_stable"] != true {
+			t.Errorf("expected a steady state, got %v", writerResult["soft_start_stable"])
+		}
+		if ns, _ := writerResult["soft_start_ns"].(int64); ns < int64(30*time.Millisecond) {
+			t.Errorf("expected the soft start to last at least 3 samples, got %v", writerResult["soft_start_ns"])
+		}
+		if _, ok := writerResult["soft_start_throughput_Mbps"].(float64); !ok {
+			t.Errorf("expected the steady throughput, got %v", writerResult["soft_start_throughput_Mbps"])
+		}
+		if readerResult["soft_start_bytes"] != writerResult["soft_start_bytes"] {
+			t.Errorf("expected the reader to receive the %v bytes of the soft start, got %v", writerResult["soft_start_bytes"], readerResult["soft_start_bytes"])
+		}
+		if reads := readerResult["successful_reads"]; reads != uint64(1000) {
+			t.Errorf("expected the soft start to be excluded, got %v successful reads", reads)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		newBenchmark := func() *PressuredBenchmark {
+			return &PressuredBenchmark{
+				MessageSize:   1024,
+				TotalMessages: 1000,
+				SoftStart:     &SoftStart{Window: 10 * time.Millisecond, Tolerance: 1e-12, Timeout: 100 * time.Millisecond},
+			}
+		}
+		writer, reader := newBenchmark(), newBenchmark()
+		runOverTCP(t, writer, reader)
+
+		result := writer.Result()
+		if result["soft_start_stable"] != false {
+			t.Errorf("expected no steady state, got %v", result["soft_start_stable"])
+		}
+		if ns, _ := result["soft_start_ns"].(int64); ns < int64(100*time.Millisecond) {
+			t.Errorf("expected the soft start to last until the timeout, got %v", result["soft_start_ns"])
+		}
+		if reads := reader.Result()["successful_reads"]; reads != uint64(1000) {
+			t.Errorf("expected the measurement to start anyway, got %v successful reads", reads)
+		}
+	})
+}
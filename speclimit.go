@@ -0,0 +1,144 @@
+package benchmarkconn
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SpecLimits bounds the spec a remote peer may request, e.g., with Sniff
+// or AdoptSpec, so that a peer cannot have a server allocate buffers of
+// any size or run for any time. Limits which are not set are unbounded.
+type SpecLimits struct {
+	MaxMessageSize int           // MaxMessageSize bounds the size of each message in bytes, including the sizes of a SizeDistribution
+	MaxMessages    uint64        // MaxMessages bounds the number of messages, rounds and bursts, including the warmup
+	MaxDuration    time.Duration // MaxDuration bounds every duration of the spec, e.g., target_duration and interval
+	MaxRate        float64       // MaxRate bounds the rates of the spec in messages per second, e.g., max_rate of RampBenchmark
+	MaxBandwidth   uint64        // MaxBandwidth bounds the offered load in bytes per second, e.g., target_bandwidth
+}
+
+// DefaultSpecLimits are the limits of Sniff, and of AdoptSpec without
+// an allowlist.
+var DefaultSpecLimits = SpecLimits{
+	MaxMessageSize: 16 << 20,
+	MaxMessages:    1 << 32,
+	MaxDuration:    time.Hour,
+	MaxRate:        10e6,
+	MaxBandwidth:   100e9 / 8,
+}
+
+// specLimitKind is which of SpecLimits bounds a spec field.
+type specLimitKind int
+
+const (
+	limitMessageSize specLimitKind = iota
+	limitMessages
+	limitDuration
+	limitRate
+	limitBandwidth
+)
+
+// specLimitFields maps the fields of the built-in specs, by their JSON
+// path, to the limit bounding them. Arrays are bounded element-wise.
+var specLimitFields = map[string]specLimitKind{
+	"message_size":            limitMessageSize,
+	"size_distribution.min":   limitMessageSize,
+	"size_distribution.max":   limitMessageSize,
+	"size_distribution.sizes": limitMessageSize,
+	"windows":                 limitMessageSize,
+	"total_messages":          limitMessages,
+	"warmup_messages":         limitMessages,
+	"burst_size":              limitMessages,
+	"rounds":                  limitMessages,
+	"completion.messages":     limitMessages,
+	"target_duration":         limitDuration,
+	"warmup_duration":         limitDuration,
+	"step_duration":           limitDuration,
+	"interval":                limitDuration,
+	"kill_after":              limitDuration,
+	"idle_timeout":            limitDuration,
+	"completion.duration":     limitDuration,
+	"soft_start.timeout":      limitDuration,
+	"start_rate":              limitRate,
+	"max_rate":                limitRate,
+	"target_bandwidth":        limitBandwidth,
+}
+
+// max returns the upper bound of kind, and whether it is bounded.
+func (l SpecLimits) max(kind specLimitKind) (float64, bool) {
+	switch kind {
+	case limitMessageSize:
+		return float64(l.MaxMessageSize), l.MaxMessageSize > 0
+	case limitMessages:
+		return float64(l.MaxMessages), l.MaxMessages > 0
+	case limitDuration:
+		return float64(l.MaxDuration), l.MaxDuration > 0
+	case limitRate:
+		return l.MaxRate, l.MaxRate > 0
+	case limitBandwidth:
+		return float64(l.MaxBandwidth), l.MaxBandwidth > 0
+	}
+	return 0, false
+}
+
+// Check checks that spec, the JSON encoding of the spec of a built-in
+// benchmark as sent in its handshake, is within the limits. Every bounded
+// field must also not be negative. Fields of custom benchmarks, which
+// the limits do not know of, are not checked.
+func (l SpecLimits) Check(spec json.RawMessage) error {
+	var fields map[string]any
+	if err := json.Unmarshal(spec, &fields); err != nil {
+		return fmt.Errorf("invalid spec: %w", err)
+	}
+	return l.check("", fields)
+}
+
+// check checks the fields of the object at path.
+func (l SpecLimits) check(path string, fields map[string]any) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names) // report the same field first on every check
+
+	for _, name := range names {
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		if object, ok := fields[name].(map[string]any); ok {
+			if err := l.check(fieldPath, object); err != nil {
+				return err
+			}
+			continue
+		}
+		kind, ok := specLimitFields[fieldPath]
+		if !ok {
+			continue
+		}
+		values, ok := fields[name].([]any)
+		if !ok {
+			values = []any{fields[name]}
+		}
+		for _, value := range values {
+			number, ok := value.(float64)
+			if !ok {
+				continue // not a number, left to the decoding of the spec
+			}
+			if number < 0 {
+				return fmt.Errorf("peer spec sets %s to %s, which must not be negative", fieldPath, formatSpecNumber(number))
+			}
+			if max, bounded := l.max(kind); bounded && number > max {
+				return fmt.Errorf("peer spec sets %s to %s, above the limit of %s", fieldPath, formatSpecNumber(number), formatSpecNumber(max))
+			}
+		}
+	}
+	return nil
+}
+
+// formatSpecNumber formats a number of a spec without an exponent.
+func formatSpecNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
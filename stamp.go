@@ -0,0 +1,115 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+)
+
+// sendTimeSize is the size of the send time carried at the end of the
+// payload of each message whose latency is measured.
+const sendTimeSize = 8
+
+// sendTimeHeader returns how many bytes at the start of the payload
+// precede the send time: the sequence number if sequence is set, and the
+// verification header if verify is set.
+func sendTimeHeader(sequence, verify bool) int {
+	header := 0
+	if sequence {
+		header = echoSequenceSize
+	}
+	if verify {
+		header = max(header, verificationHeaderSize)
+	}
+	return header
+}
+
+// validateSendTime checks that messages are large enough to carry their
+// send time after header bytes. With a size distribution, messages drawn
+// smaller than that are sent without one and their latency is not
+// measured.
+func validateSendTime(messageSize int, sizes *SizeDistribution, header int) error {
+	largest := messageSize
+	if sizes != nil {
+		largest = sizes.Max
+	}
+	if minimum := header + sendTimeSize; largest < minimum {
+		return fmt.Errorf("latency measurement requires a message size of at least %d bytes", minimum)
+	}
+	return nil
+}
+
+// stampSendTime writes sendTime, in nanoseconds, into the last bytes of
+// payload if it is large enough to hold it after header bytes, so that
+// latency is measured from the message itself rather than by looking up
+// when it was sent.
+func stampSendTime(payload []byte, header int, sendTime int64) {
+	if len(payload) >= header+sendTimeSize {
+		binary.BigEndian.PutUint64(payload[len(payload)-sendTimeSize:], uint64(sendTime))
+	}
+}
+
+// readSendTime returns the send time stamped by stampSendTime, false if
+// payload is too small to carry one.
+func readSendTime(payload []byte, header int) (int64, bool) {
+	if len(payload) < header+sendTimeSize {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(payload[len(payload)-sendTimeSize:])), true
+}
+
+// oneWayLatencyRecorder records the one-way latency of the messages
+// carrying their send time, from the clock of the writer to that of the
+// reader.
+type oneWayLatencyRecorder struct {
+	histogram *Histogram // nil unless recording
+	total     atomic.Uint64
+	count     atomic.Uint64
+	negative  atomic.Uint64 // latencies below zero, i.e., the clock of the reader is behind
+}
+
+// reset starts recording if enabled, with a histogram of settings sized
+// for profile.
+func (r *oneWayLatencyRecorder) reset(enabled bool, settings *HistogramSettings, profile Profile) {
+	r.total.Store(0)
+	r.count.Store(0)
+	r.negative.Store(0)
+	r.histogram = nil
+	if enabled {
+		r.histogram = newLatencyHistogram(settings, profile)
+	}
+}
+
+// record records a latency in nanoseconds, counting negative ones apart
+// since they reveal unsynchronized clocks rather than the transport.
+func (r *oneWayLatencyRecorder) record(latency int64) {
+	if latency < 0 {
+		r.negative.Add(1)
+		return
+	}
+	r.total.Add(uint64(latency))
+	r.count.Add(1)
+	r.histogram.Record(latency)
+}
+
+// addResult adds the mean one-way latency to result as
+// one_way_latency_ns, its distribution as one_way_latency_<stat>_ns, and
+// the negative latencies dropped as one_way_latency_negative.
+func (r *oneWayLatencyRecorder) addResult(result map[string]any, profile Profile) {
+	if r.histogram == nil {
+		return
+	}
+	if count := r.count.Load(); count > 0 {
+		if profile == ProfileConstrained { // integer-only stats
+			result["one_way_latency_ns"] = r.total.Load() / count
+		} else {
+			result["one_way_latency_ns"] = float64(r.total.Load()) / float64(count)
+		}
+		for name, value := range r.histogram.Percentiles() {
+			result["one_way_latency_"+name+"_ns"] = value
+		}
+	}
+	if negative := r.negative.Load(); negative > 0 {
+		result["one_way_latency_negative"] = negative
+	}
+}
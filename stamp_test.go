@@ -0,0 +1,32 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPressuredBenchmarkTimestamps(t *testing.T) {
+	for _, profile := range []Profile{ProfileDefault, ProfileConstrained} {
+		writer := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 10000, Timestamps: true, Verify: true, Profile: profile}
+		reader := &PressuredBenchmark{MessageSize: 1024, TotalMessages: 10000, Timestamps: true, Verify: true, Profile: profile}
+		runOverTCP(t, writer, reader)
+
+		result := reader.Result()
+		if _, ok := result["one_way_latency_ns"]; !ok {
+			t.Errorf("%s: expected the one-way latency, got %v", profile, result)
+		}
+		if _, ok := result["one_way_latency_p99_ns"]; !ok {
+			t.Errorf("%s: expected the one-way latency percentiles, got %v", profile, result)
+		}
+		if valid := result["verify_valid"]; valid != uint64(10000) {
+			t.Errorf("%s: expected stamped messages to pass verification, got %v valid", profile, valid)
+		}
+	}
+
+	// too small to carry the send time after the verification header
+	writer := &PressuredBenchmark{MessageSize: 16, TotalMessages: 1, Timestamps: true, Verify: true}
+	if err := writer.Writer(nil); err == nil {
+		t.Error("expected messages too small for the send time to be rejected")
+	}
+}
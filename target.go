@@ -0,0 +1,56 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const minEndMarkerSize = 16 // 8 bytes for the message count and 8 bytes of pattern
+
+var endMarkerPattern = []byte("benchmarkconn:run-complete;")
+
+// endMarker returns the message sent by the writer to signal the end of
+// a run with a target duration, carrying the number of messages sent
+// since the reader does not know it in advance.
+func endMarker(size int, sent uint64) []byte {
+	marker := make([]byte, size)
+	binary.BigEndian.PutUint64(marker[0:8], sent)
+	for i := 8; i < size; i++ {
+		marker[i] = endMarkerPattern[(i-8)%len(endMarkerPattern)]
+	}
+	return marker
+}
+
+// parseEndMarker returns the number of messages sent if msg is an end
+// marker. It is called for every message read, so it compares msg with the
+// pattern in place rather than allocating a marker to compare with.
+func parseEndMarker(msg []byte) (uint64, bool) {
+	if len(msg) < minEndMarkerSize {
+		return 0, false
+	}
+	for i, b := range msg[8:] {
+		if b != endMarkerPattern[i%len(endMarkerPattern)] {
+			return 0, false
+		}
+	}
+	return binary.BigEndian.Uint64(msg[0:8]), true
+}
+
+func validateTargetDuration(messageSize int, target time.Duration) error {
+	if target > 0 && messageSize < minEndMarkerSize {
+		return errors.New("target duration requires a message size of at least 16 bytes")
+	}
+	return nil
+}
+
+// keepSending reports whether the writer should send the next message,
+// given the number of messages sent so far. With a positive target, the
+// writer sends until the target duration has elapsed since start,
+// regardless of totalMessages.
+func keepSending(sent, totalMessages uint64, start time.Time, target time.Duration) bool {
+	if target > 0 {
+		return time.Since(start) < target
+	}
+	return sent < totalMessages
+}
@@ -0,0 +1,222 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DelayDistribution draws the think time of the echo reader before each
+// echo, modeling the work of a server handling a request rather than an
+// echo at wire speed. It is local to the reader and does not need to match
+// the peer.
+type DelayDistribution struct {
+	Kind  string        `json:"kind" yaml:"kind"`             // Kind is one of "fixed", "uniform", "exponential" or "lognormal"
+	Min   time.Duration `json:"min,omitempty" yaml:"min"`     // Min is the shortest delay, and the only delay for "fixed"
+	Max   time.Duration `json:"max,omitempty" yaml:"max"`     // Max is the longest delay, exponential and lognormal delays are clamped to it if set
+	Mean  time.Duration `json:"mean,omitempty" yaml:"mean"`   // Mean is the mean delay for "exponential"
+	Mu    float64       `json:"mu,omitempty" yaml:"mu"`       // Mu is the mean of the natural logarithm of the delay in nanoseconds for "lognormal"
+	Sigma float64       `json:"sigma,omitempty" yaml:"sigma"` // Sigma is the standard deviation of the natural logarithm of the delay for "lognormal"
+
+	Seed int64 `json:"-" yaml:"seed"` // Seed seeds the delays drawn for reproducible runs, time-based if 0
+}
+
+// FixedDelay returns a distribution always drawing delay.
+func FixedDelay(delay time.Duration) *DelayDistribution {
+	return &DelayDistribution{Kind: "fixed", Min: delay, Max: delay}
+}
+
+// UniformDelay returns a distribution drawing delays uniformly from
+// [min, max].
+func UniformDelay(min, max time.Duration) *DelayDistribution {
+	return &DelayDistribution{Kind: "uniform", Min: min, Max: max}
+}
+
+// ExponentialDelay returns a distribution drawing exponentially
+// distributed delays of the given mean, as the service times of a queueing
+// model, clamped to max if set.
+func ExponentialDelay(mean, max time.Duration) *DelayDistribution {
+	return &DelayDistribution{Kind: "exponential", Mean: mean, Max: max}
+}
+
+// LogNormalDelay returns a distribution drawing delays whose natural
+// logarithm is normally distributed with the natural logarithm of median
+// as mean and standard deviation sigma, clamped to max if set, as the
+// long-tailed processing times of real servers.
+func LogNormalDelay(median time.Duration, sigma float64, max time.Duration) *DelayDistribution {
+	return &DelayDistribution{Kind: "lognormal", Mu: math.Log(float64(median)), Sigma: sigma, Max: max}
+}
+
+// Validate checks that the distribution only draws non-negative delays.
+func (d *DelayDistribution) Validate() error {
+	if d.Min < 0 || d.Max < 0 || d.Mean < 0 {
+		return errors.New("think time must not be negative")
+	}
+
+	switch d.Kind {
+	case "fixed":
+		if d.Min != d.Max {
+			return errors.New("fixed think time must have equal min and max")
+		}
+	case "uniform":
+		if d.Max < d.Min {
+			return fmt.Errorf("uniform think time must have min below max, got [%s, %s]", d.Min, d.Max)
+		}
+	case "exponential":
+		if d.Mean <= 0 {
+			return errors.New("exponential think time must have a positive mean")
+		}
+	case "lognormal":
+		if d.Sigma < 0 || math.IsNaN(d.Mu) || math.IsInf(d.Mu, 0) {
+			return errors.New("lognormal think time must have a positive median and a non-negative sigma")
+		}
+	default:
+		return fmt.Errorf("unknown think time distribution %q", d.Kind)
+	}
+	return nil
+}
+
+// String returns the distribution in the format parsed by
+// ParseDelayDistribution.
+func (d *DelayDistribution) String() string {
+	var s string
+	switch d.Kind {
+	case "fixed":
+		return "fixed:" + d.Min.String()
+	case "uniform":
+		return fmt.Sprintf("uniform:%s-%s", d.Min, d.Max)
+	case "exponential":
+		s = "exponential:" + d.Mean.String()
+	case "lognormal":
+		s = fmt.Sprintf("lognormal:%s:%g", time.Duration(math.Round(math.Exp(d.Mu))), d.Sigma)
+	default:
+		return d.Kind
+	}
+	if d.Max > 0 {
+		s += ":" + d.Max.String()
+	}
+	return s
+}
+
+// sampler returns a function drawing delays from the distribution, which
+// must be valid. It is not safe for concurrent use.
+func (d *DelayDistribution) sampler() func() time.Duration {
+	seed := d.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	clamp := func(delay float64) time.Duration {
+		if d.Max > 0 {
+			delay = min(delay, float64(d.Max))
+		}
+		return time.Duration(delay)
+	}
+	switch d.Kind {
+	case "uniform":
+		return func() time.Duration {
+			return d.Min + time.Duration(rng.Int63n(int64(d.Max-d.Min)+1))
+		}
+	case "exponential":
+		return func() time.Duration {
+			return clamp(rng.ExpFloat64() * float64(d.Mean))
+		}
+	case "lognormal":
+		return func() time.Duration {
+			return clamp(math.Exp(d.Mu + d.Sigma*rng.NormFloat64()))
+		}
+	default:
+		return func() time.Duration {
+			return d.Min
+		}
+	}
+}
+
+// ParseDelayDistribution parses a think time distribution specification
+// as used by the command line tools, optionally clamped to a maximum delay
+// for the unbounded distributions:
+//
+//   - "fixed:<delay>" for FixedDelay
+//   - "uniform:<min>-<max>" for UniformDelay
+//   - "exponential:<mean>[:<max>]" for ExponentialDelay
+//   - "lognormal:<median>:<sigma>[:<max>]" for LogNormalDelay
+func ParseDelayDistribution(spec string) (*DelayDistribution, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	fields := strings.Split(arg, ":")
+
+	var d *DelayDistribution
+	switch kind {
+	case "fixed":
+		delay, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed think time: %w", err)
+		}
+		d = FixedDelay(delay)
+	case "uniform":
+		minStr, maxStr, ok := strings.Cut(arg, "-")
+		if !ok {
+			return nil, fmt.Errorf("think time range %q must be <min>-<max>", arg)
+		}
+		min, err := time.ParseDuration(minStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum think time: %w", err)
+		}
+		max, err := time.ParseDuration(maxStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maximum think time: %w", err)
+		}
+		d = UniformDelay(min, max)
+	case "exponential":
+		if len(fields) > 2 {
+			return nil, errors.New("exponential think time must be <mean>[:<max>]")
+		}
+		mean, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponential mean think time: %w", err)
+		}
+		max, err := parseMaxDelay(fields[1:])
+		if err != nil {
+			return nil, err
+		}
+		d = ExponentialDelay(mean, max)
+	case "lognormal":
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, errors.New("lognormal think time must be <median>:<sigma>[:<max>]")
+		}
+		median, err := time.ParseDuration(fields[0])
+		if err != nil || median <= 0 {
+			return nil, errors.New("invalid lognormal median think time")
+		}
+		sigma, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lognormal sigma: %w", err)
+		}
+		max, err := parseMaxDelay(fields[2:])
+		if err != nil {
+			return nil, err
+		}
+		d = LogNormalDelay(median, sigma, max)
+	default:
+		return nil, fmt.Errorf("unknown think time distribution %q", kind)
+	}
+
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func parseMaxDelay(fields []string) (time.Duration, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	max, err := time.ParseDuration(fields[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid maximum think time: %w", err)
+	}
+	return max, nil
+}
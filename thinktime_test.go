@@ -0,0 +1,69 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestParseDelayDistribution(t *testing.T) {
+	for _, spec := range []string{
+		"fixed:2ms",
+		"uniform:1ms-3ms",
+		"exponential:2ms",
+		"exponential:2ms:20ms",
+		"lognormal:2ms:0.5",
+		"lognormal:2ms:0.5:50ms",
+	} {
+		d, err := ParseDelayDistribution(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if d.String() != spec {
+			t.Errorf("expected %s to round-trip, got %s", spec, d.String())
+		}
+	}
+
+	for _, spec := range []string{
+		"fixed:-1ms",
+		"uniform:3ms-1ms",
+		"exponential:0s",
+		"lognormal:2ms",
+		"gaussian:2ms",
+	} {
+		if _, err := ParseDelayDistribution(spec); err == nil {
+			t.Errorf("expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestIntervalBenchmarkThinkTime(t *testing.T) {
+	writer := &IntervalBenchmark{MessageSize: 64, TotalMessages: 10, Interval: 10 * time.Millisecond, Echo: true}
+	reader := &IntervalBenchmark{MessageSize: 64, TotalMessages: 10, Interval: 10 * time.Millisecond, Echo: true, ThinkTime: FixedDelay(5 * time.Millisecond)}
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- reader.Reader(readerConn) }()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if thinkTime := reader.Result()["think_time_ns"].(float64); thinkTime < float64(5*time.Millisecond) {
+		t.Errorf("expected a think time of at least 5ms, got %v", thinkTime)
+	}
+	if latency := writer.Result()["latency_min_ns"].(int64); latency < int64(5*time.Millisecond) {
+		t.Errorf("expected the latency to include the think time, got %v", latency)
+	}
+
+	if err := (&IntervalBenchmark{MessageSize: 64, TotalMessages: 10, ThinkTime: FixedDelay(time.Millisecond)}).Reader(readerConn); err == nil {
+		t.Error("expected think time without echo to be rejected")
+	}
+}
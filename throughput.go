@@ -0,0 +1,134 @@
+package benchmarkconn
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// addThroughput adds the bytes transferred and the resulting throughput
+// to result, so results are directly comparable with iperf-style tools.
+// The throughput is computed from the busier direction, i.e., the data
+// stream for one-way benchmarks and the echoed stream otherwise.
+func addThroughput(result map[string]any, bytesRead, bytesWritten uint64, durationNs int64, profile Profile) {
+	result["bytes_read"] = bytesRead
+	result["bytes_written"] = bytesWritten
+
+	addBitrate(result, "", max(bytesRead, bytesWritten), durationNs, profile)
+}
+
+// addBitrate adds the throughput of transferring bytes in durationNs to
+// result as <prefix>throughput_bps and <prefix>throughput_Mbps.
+func addBitrate(result map[string]any, prefix string, bytes uint64, durationNs int64, profile Profile) {
+	if durationNs <= 0 {
+		return
+	}
+
+	bitsTransferred := bytes * 8
+	if profile == ProfileConstrained { // integer-only stats
+		hi, lo := bits.Mul64(bitsTransferred, 1e9)
+		if hi < uint64(durationNs) { // otherwise the quotient overflows
+			bps, _ := bits.Div64(hi, lo, uint64(durationNs))
+			result[prefix+"throughput_bps"] = bps
+			result[prefix+"throughput_Mbps"] = bps / 1e6
+		}
+	} else {
+		bps := float64(bitsTransferred) / float64(durationNs) * 1e9
+		result[prefix+"throughput_bps"] = bps
+		result[prefix+"throughput_Mbps"] = bps / 1e6
+	}
+}
+
+// throughputTimeline samples the throughput of a run every interval, so
+// that ramp-ups and dips are visible rather than averaged away by the
+// whole run.
+type throughputTimeline struct {
+	mutex        sync.Mutex
+	start        time.Time
+	profile      Profile
+	bytesRead    *atomic.Uint64
+	bytesWritten *atomic.Uint64
+	last         time.Time // the end of the last interval
+	lastBytes    uint64    // the bytes transferred until the end of the last interval
+	intervals    []map[string]any
+
+	ticker *time.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// startThroughputTimeline starts sampling the throughput of the bytes
+// read and written every interval from start. It returns nil if interval
+// is not positive.
+func startThroughputTimeline(interval time.Duration, start time.Time, bytesRead, bytesWritten *atomic.Uint64, profile Profile) *throughputTimeline {
+	if interval <= 0 {
+		return nil
+	}
+
+	t := &throughputTimeline{
+		start:        start,
+		profile:      profile,
+		bytesRead:    bytesRead,
+		bytesWritten: bytesWritten,
+		last:         start,
+		ticker:       time.NewTicker(interval),
+		done:         make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for {
+			select {
+			case now := <-t.ticker.C:
+				t.closeInterval(now)
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// stop stops sampling, closing the last interval, however short.
+func (t *throughputTimeline) stop() {
+	if t == nil {
+		return
+	}
+	t.ticker.Stop()
+	close(t.done)
+	t.wg.Wait()
+	t.closeInterval(time.Now())
+}
+
+// closeInterval samples the interval ending at now and starts the next
+// one.
+func (t *throughputTimeline) closeInterval(now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	bytes := max(t.bytesRead.Load(), t.bytesWritten.Load())
+	interval := map[string]any{
+		"start": t.last.Sub(t.start).Round(time.Millisecond).String(),
+		"end":   now.Sub(t.start).Round(time.Millisecond).String(),
+		"bytes": bytes - t.lastBytes,
+	}
+	addBitrate(interval, "", bytes-t.lastBytes, now.Sub(t.last).Nanoseconds(), t.profile)
+	t.intervals = append(t.intervals, interval)
+	t.last, t.lastBytes = now, bytes
+}
+
+// addResult adds the samples to result as throughput_intervals in order,
+// each holding the start and end of its interval since the start of the
+// run, the bytes transferred in the busier direction and the resulting
+// throughput.
+func (t *throughputTimeline) addResult(result map[string]any) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.intervals) > 0 {
+		result["throughput_intervals"] = t.intervals
+	}
+}
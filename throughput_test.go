@@ -0,0 +1,62 @@
+package benchmarkconn_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestPressuredBenchmarkThroughputIntervals(t *testing.T) {
+	newPressure := func() *PressuredBenchmark {
+		return &PressuredBenchmark{MessageSize: 1024, TargetDuration: 500 * time.Millisecond, TargetBandwidth: 1 << 20}
+	}
+	writer, reader := newPressure(), newPressure()
+	writer.ThroughputInterval = 100 * time.Millisecond
+
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := reader.Reader(readerConn); err != nil {
+			t.Errorf("Reader errored: %v", err)
+		}
+	}()
+	if err := writer.Writer(writerConn); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	result := writer.Result()
+	intervals, ok := result["throughput_intervals"].([]map[string]any)
+	if !ok || len(intervals) < 5 {
+		t.Fatalf("expected a sample every 100ms, got %v", result["throughput_intervals"])
+	}
+	if intervals[0]["start"] != "0s" {
+		t.Errorf("expected the first interval to start with the run, got %v", intervals[0]["start"])
+	}
+	var total uint64
+	for i, interval := range intervals[:4] {
+		// paced to 1 MiB/s, i.e., about 8.4 Mbps
+		if mbps := interval["throughput_Mbps"].(float64); mbps < 4 || mbps > 16 {
+			t.Errorf("expected about 8.4 Mbps in interval %d, got %v", i, mbps)
+		}
+	}
+	for _, interval := range intervals {
+		total += interval["bytes"].(uint64)
+	}
+	if total != result["bytes_written"] {
+		t.Errorf("expected the intervals to add up to %v bytes, got %d", result["bytes_written"], total)
+	}
+
+	// the reader does not sample without ThroughputInterval
+	if _, ok := reader.Result()["throughput_intervals"]; ok {
+		t.Errorf("expected no samples without ThroughputInterval")
+	}
+}
@@ -0,0 +1,154 @@
+package benchmarkconn
+
+import (
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// timerCalibrationSamples is how many sleeps TimerResolution measures.
+const timerCalibrationSamples = 20
+
+var (
+	timerResolutionOnce sync.Once
+	timerResolution     time.Duration
+)
+
+// TimerResolution returns the shortest sleep the host achieves, i.e., the
+// median time a 1µs sleep takes, measured on the first call. Intervals
+// below it are paced by the OS timer rather than the benchmark, e.g., on
+// Windows or virtualized hosts with coarse timers.
+func TimerResolution() time.Duration {
+	timerResolutionOnce.Do(func() {
+		samples := make([]time.Duration, timerCalibrationSamples)
+		for i := range samples {
+			start := time.Now()
+			time.Sleep(time.Microsecond)
+			samples[i] = time.Since(start)
+		}
+		slices.Sort(samples)
+		timerResolution = samples[len(samples)/2]
+	})
+	return timerResolution
+}
+
+// Pacing selects how a benchmark waits between sends.
+type Pacing uint8
+
+const (
+	// PacingTicker waits on a time.Ticker, sleeping between sends.
+	PacingTicker Pacing = iota
+
+	// PacingSpin sleeps until TimerResolution before each send, then spins
+	// until it is due, achieving intervals below the resolution at the
+	// cost of a busy CPU.
+	PacingSpin
+
+	// PacingAuto selects PacingSpin if the interval is below
+	// TimerResolution, otherwise PacingTicker.
+	PacingAuto
+)
+
+// ParsePacing parses the name of a pacing mode.
+func ParsePacing(name string) (Pacing, error) {
+	switch name {
+	case "", "ticker":
+		return PacingTicker, nil
+	case "spin":
+		return PacingSpin, nil
+	case "auto":
+		return PacingAuto, nil
+	default:
+		return PacingTicker, errors.New("unknown pacing, must be either \"ticker\", \"spin\" or \"auto\"")
+	}
+}
+
+func (p Pacing) String() string {
+	switch p {
+	case PacingSpin:
+		return "spin"
+	case PacingAuto:
+		return "auto"
+	default:
+		return "ticker"
+	}
+}
+
+func (p Pacing) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Pacing) UnmarshalText(text []byte) error {
+	parsed, err := ParsePacing(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// resolve returns the pacing mode to use for interval, resolving
+// PacingAuto.
+func (p Pacing) resolve(interval time.Duration) Pacing {
+	if p != PacingAuto {
+		return p
+	}
+	if interval < TimerResolution() {
+		return PacingSpin
+	}
+	return PacingTicker
+}
+
+// intervalPacer waits for the next send every interval. Like time.Ticker,
+// it drops the sends missed by a slow sender instead of catching up.
+type intervalPacer struct {
+	pacing Pacing
+
+	ticker *time.Ticker // used for PacingTicker
+
+	interval   time.Duration // used for PacingSpin
+	resolution time.Duration // used for PacingSpin
+	next       time.Time     // used for PacingSpin
+}
+
+// newIntervalPacer starts pacing every interval with pacing, which must
+// not be PacingAuto.
+func newIntervalPacer(interval time.Duration, pacing Pacing) *intervalPacer {
+	if pacing == PacingSpin {
+		return &intervalPacer{
+			pacing:     PacingSpin,
+			interval:   interval,
+			resolution: TimerResolution(),
+			next:       time.Now().Add(interval),
+		}
+	}
+	return &intervalPacer{pacing: PacingTicker, ticker: time.NewTicker(interval)}
+}
+
+// wait blocks until the next send is due.
+func (p *intervalPacer) wait() {
+	if p.ticker != nil {
+		<-p.ticker.C
+		return
+	}
+
+	if sleep := time.Until(p.next) - p.resolution; sleep > 0 {
+		time.Sleep(sleep)
+	}
+	for time.Now().Before(p.next) {
+		runtime.Gosched() // let other goroutines, e.g., the reader, run
+	}
+
+	p.next = p.next.Add(p.interval)
+	if now := time.Now(); p.next.Before(now) {
+		p.next = now
+	}
+}
+
+func (p *intervalPacer) stop() {
+	if p.ticker != nil {
+		p.ticker.Stop()
+	}
+}
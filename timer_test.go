@@ -0,0 +1,55 @@
+package benchmarkconn_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/gaukas/benchmarkconn"
+)
+
+func TestTimerResolution(t *testing.T) {
+	resolution := TimerResolution()
+	if resolution < time.Microsecond {
+		t.Errorf("expected a sleep to take at least 1µs, got %s", resolution)
+	}
+	if again := TimerResolution(); again != resolution {
+		t.Errorf("expected the resolution to be measured once, got %s then %s", resolution, again)
+	}
+	if _, ok := RuntimeSettings()["timer_resolution_ns"]; !ok {
+		t.Error("expected the timer resolution among the runtime settings")
+	}
+}
+
+func TestIntervalBenchmarkSpinPacing(t *testing.T) {
+	// auto spins below the timer resolution, otherwise spin explicitly
+	writer := &IntervalBenchmark{MessageSize: 64, TotalMessages: 1000, Interval: 20 * time.Microsecond, Echo: true, Pacing: PacingAuto}
+	reader := &IntervalBenchmark{MessageSize: 64, TotalMessages: 1000, Interval: 20 * time.Microsecond, Echo: true}
+	if TimerResolution() <= writer.Interval {
+		writer.Pacing = PacingSpin
+	}
+	runOverTCP(t, writer, reader)
+
+	result := writer.Result()
+	if pacing := result["pacing"]; pacing != "spin" {
+		t.Errorf("expected spin pacing below the timer resolution, got %v", pacing)
+	}
+	// spinning keeps the interval, where sleeping would take the resolution
+	if duration, err := time.ParseDuration(result["duration"].(string)); err != nil {
+		t.Fatal(err)
+	} else if duration < 1000*writer.Interval {
+		t.Errorf("expected at least %s for 1000 intervals, took %s", 1000*writer.Interval, duration)
+	}
+
+	for _, name := range []string{"ticker", "spin", "auto"} {
+		pacing, err := ParsePacing(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pacing.String() != name {
+			t.Errorf("expected %s, got %s", name, pacing)
+		}
+	}
+	if _, err := ParsePacing("busy"); err == nil {
+		t.Error("expected an error for an unknown pacing")
+	}
+}
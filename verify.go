@@ -0,0 +1,132 @@
+package benchmarkconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+// verificationHeaderSize is the size of the header carried by each
+// message in verification mode: an 8-byte sequence number followed by a
+// 4-byte CRC-32C of the rest of the message.
+const verificationHeaderSize = 12
+
+var verificationTable = crc32.MakeTable(crc32.Castagnoli)
+
+func validateVerification(messageSize int, verify bool) error {
+	if verify && messageSize < verificationHeaderSize {
+		return errors.New("verification requires a message size of at least 12 bytes")
+	}
+	return nil
+}
+
+// stampVerification writes the sequence number and the checksum of the
+// rest of the message into the verification header of msg.
+func stampVerification(msg []byte, seq uint64) {
+	binary.BigEndian.PutUint64(msg[0:8], seq)
+	binary.BigEndian.PutUint32(msg[8:12], crc32.Checksum(msg[verificationHeaderSize:], verificationTable))
+}
+
+// FNV-1a parameters of the digest of the verified messages.
+const (
+	digestOffset uint64 = 14695981039346656037
+	digestPrime  uint64 = 1099511628211
+)
+
+// messageDigest is a cumulative digest of the messages of a run, folding
+// the verification header of each message, i.e., its sequence number and
+// checksum, in order with FNV-1a. The digests of both sides match if the
+// very same messages went through intact and in order, which is told
+// with constant memory however long the run.
+type messageDigest struct {
+	sum atomic.Uint64 // only updated by a single goroutine
+}
+
+func (d *messageDigest) reset() {
+	d.sum.Store(digestOffset)
+}
+
+// add folds the verification header of msg into the digest.
+func (d *messageDigest) add(msg []byte) {
+	sum := d.sum.Load()
+	for _, b := range msg[:verificationHeaderSize] {
+		sum ^= uint64(b)
+		sum *= digestPrime
+	}
+	d.sum.Store(sum)
+}
+
+func (d *messageDigest) String() string {
+	return fmt.Sprintf("%016x", d.sum.Load())
+}
+
+// messageVerifier validates the ordering and content of messages stamped
+// by stampVerification, using constant memory.
+type messageVerifier struct {
+	nextSeq    uint64 // only accessed by the reading goroutine
+	valid      atomic.Uint64
+	corrupted  atomic.Uint64
+	outOfOrder atomic.Uint64
+	digest     messageDigest // of the messages written, or of the intact messages read in order of arrival
+}
+
+func (v *messageVerifier) reset() {
+	v.nextSeq = 0
+	v.valid.Store(0)
+	v.corrupted.Store(0)
+	v.outOfOrder.Store(0)
+	v.digest.reset()
+}
+
+// written folds a message stamped by stampVerification into the digest
+// of the messages written.
+func (v *messageVerifier) written(msg []byte) {
+	v.digest.add(msg)
+}
+
+// check validates a single message, returning the kind of message error
+// if it failed, or an empty string. A corrupted message says nothing
+// reliable about its sequence number, so it does not affect ordering.
+func (v *messageVerifier) check(msg []byte) string {
+	if len(msg) < verificationHeaderSize ||
+		binary.BigEndian.Uint32(msg[8:12]) != crc32.Checksum(msg[verificationHeaderSize:], verificationTable) {
+		v.corrupted.Add(1)
+		return messageErrorCorrupted
+	}
+
+	// a gap is either corrupted or missing messages, only messages
+	// arriving after a later one are out of order
+	seq := binary.BigEndian.Uint64(msg[0:8])
+	v.valid.Add(1)
+	v.digest.add(msg)
+	if seq < v.nextSeq {
+		v.outOfOrder.Add(1)
+		return messageErrorOutOfOrder
+	}
+	v.nextSeq = seq + 1
+	return ""
+}
+
+// addResult adds the verification counts to result. Messages neither
+// received intact nor reported as corrupted are reported as missing.
+func (v *messageVerifier) addResult(result map[string]any, totalMessages uint64) {
+	received := v.valid.Load() + v.corrupted.Load()
+
+	result["verify_valid"] = v.valid.Load()
+	result["verify_corrupted"] = v.corrupted.Load()
+	result["verify_out_of_order"] = v.outOfOrder.Load()
+	if received < totalMessages {
+		result["verify_missing"] = totalMessages - received
+	} else {
+		result["verify_missing"] = uint64(0)
+	}
+	v.addDigestResult(result)
+}
+
+// addDigestResult adds the digest of the messages to result as
+// verify_digest, to be compared with that of the peer.
+func (v *messageVerifier) addDigestResult(result map[string]any) {
+	result["verify_digest"] = v.digest.String()
+}
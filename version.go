@@ -0,0 +1,93 @@
+package benchmarkconn
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// modulePath is the path of this module, looked up in the build info of
+// binaries embedding it as a dependency.
+const modulePath = "github.com/gaukas/benchmarkconn"
+
+var (
+	versionOnce sync.Once
+	version     string
+)
+
+// Version returns the version of this module built into the running
+// binary, e.g., v1.2.0, or for binaries built from a VCS checkout, the
+// revision they were built from, e.g., (devel)+3f2a9c1b7d0e-dirty. It is
+// exchanged during the handshake and recorded with the result under
+// version and peer_version, so that results of different binaries can be
+// told apart.
+func Version() string {
+	versionOnce.Do(func() {
+		version = "unknown"
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+
+		module := &info.Main
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				module = dep
+				if dep.Replace != nil {
+					module = dep.Replace
+				}
+			}
+		}
+		if module.Version != "" {
+			version = module.Version
+		}
+		if module != &info.Main || version != "(devel)" {
+			return // released, or a dependency built from another checkout
+		}
+
+		var revision string
+		var modified bool
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		if revision != "" {
+			version += "+" + revision
+		}
+		if modified {
+			version += "-dirty"
+		}
+	})
+	return version
+}
+
+// versionRecorder records the version of the peer received during the
+// handshake.
+type versionRecorder struct {
+	peer string
+}
+
+// record records the version of the peer from its handshake message,
+// unknown if the peer predates the exchange.
+func (r *versionRecorder) record(peer *handshakeMessage) {
+	r.peer = peer.Version
+	if r.peer == "" {
+		r.peer = "unknown"
+	}
+}
+
+// addResult adds the version of both peers to result, if the handshake
+// was performed.
+func (r *versionRecorder) addResult(result map[string]any) {
+	if r.peer == "" {
+		return
+	}
+	result["version"] = Version()
+	result["peer_version"] = r.peer
+}
@@ -0,0 +1,124 @@
+package benchmarkconn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	crand "crypto/rand"
+)
+
+const minWarmupMarkerSize = 16 // shorter markers could collide with random payloads
+
+var warmupMarkerPattern = []byte("benchmarkconn:warmup-done;")
+
+// warmupMarker returns the message sent by the writer to signal the end
+// of a duration-based warmup, since the reader does not know how many
+// messages were sent during the warmup.
+func warmupMarker(size int) []byte {
+	marker := make([]byte, size)
+	for i := range marker {
+		marker[i] = warmupMarkerPattern[i%len(warmupMarkerPattern)]
+	}
+	return marker
+}
+
+func validateWarmup(messageSize int, duration time.Duration) error {
+	if duration > 0 && messageSize < minWarmupMarkerSize {
+		return errors.New("duration-based warmup requires a message size of at least 16 bytes")
+	}
+	return nil
+}
+
+// sendWarmup sends the warmup messages, paced by interval if positive.
+// If duration is positive, messages are sent until the duration has
+// elapsed and followed by the warmup marker. Otherwise exactly messages
+// messages are sent. If echo is true, each message is expected to be
+// echoed back before the next one is sent.
+func sendWarmup(conn net.Conn, messageSize int, messages uint64, duration time.Duration, interval time.Duration, echo bool) error {
+	if messages == 0 && duration <= 0 {
+		return nil
+	}
+
+	var msg = make([]byte, messageSize)
+	crand.Read(msg)
+
+	var echoedMsg []byte
+	if echo {
+		echoedMsg = make([]byte, messageSize)
+	}
+	send := func(msg []byte) error {
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		if echo {
+			if _, err := io.ReadFull(conn, echoedMsg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var ticker *time.Ticker
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+
+	deadline := time.Now().Add(duration)
+	var i uint64
+	for (duration > 0 && time.Now().Before(deadline)) || (duration <= 0 && i < messages) {
+		if ticker != nil {
+			<-ticker.C
+		}
+		if err := send(msg); err != nil {
+			return err
+		}
+		i++
+	}
+
+	if duration > 0 {
+		if err := send(warmupMarker(messageSize)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// receiveWarmup receives the warmup messages sent by sendWarmup, echoing
+// them back if echo is true. If duration is positive, messages are read
+// until the warmup marker arrives.
+func receiveWarmup(conn net.Conn, messageSize int, messages uint64, duration time.Duration, echo bool) error {
+	if messages == 0 && duration <= 0 {
+		return nil
+	}
+
+	var marker []byte
+	if duration > 0 {
+		marker = warmupMarker(messageSize)
+	}
+
+	var receivedMsg = make([]byte, messageSize)
+	var i uint64
+	for duration > 0 || i < messages {
+		if _, err := io.ReadFull(conn, receivedMsg); err != nil {
+			return err
+		}
+		i++
+
+		if echo {
+			if _, err := conn.Write(receivedMsg); err != nil {
+				return err
+			}
+		}
+
+		if marker != nil && bytes.Equal(receivedMsg, marker) {
+			break
+		}
+	}
+
+	return nil
+}